@@ -62,6 +62,7 @@ Remove blockchain and state databases`,
 			dbPutCmd,
 			dbGetSlotsCmd,
 			dbDumpFreezerIndex,
+			dbImportPreimagesCmd,
 		},
 	}
 	dbInspectCmd = cli.Command{
@@ -187,6 +188,25 @@ WARNING: This is a low-level operation which may cause database corruption!`,
 		},
 		Description: "This command displays information about the freezer index.",
 	}
+	dbImportPreimagesCmd = cli.Command{
+		Action:    utils.MigrateFlags(dbImportPreimages),
+		Name:      "import-preimages",
+		Usage:     "Import preimage data into the append-only preimage store",
+		ArgsUsage: "<datafile>",
+		Flags: []cli.Flag{
+			utils.DataDirFlag,
+			utils.SyncModeFlag,
+			utils.MainnetFlag,
+			utils.RopstenFlag,
+			utils.RinkebyFlag,
+			utils.GoerliFlag,
+		},
+		Description: `The import-preimages command imports hash preimages into the store used
+with --preimages.store. The file is either an RLP stream of preimages, as produced by
+the export-preimages command, or in the compact binary format holding every preimage
+as its uvarint length followed by its content, after a "\xffgeth-preimages\x00" header.
+Files ending in .gz are decompressed on the fly.`,
+	}
 )
 
 func removeDB(ctx *cli.Context) error {
@@ -499,3 +519,31 @@ func freezerInspect(ctx *cli.Context) error {
 	}
 	return nil
 }
+
+func dbImportPreimages(ctx *cli.Context) error {
+	if ctx.NArg() != 1 {
+		return fmt.Errorf("required arguments: %v", ctx.Command.ArgsUsage)
+	}
+	stack, _ := makeConfigNode(ctx)
+	defer stack.Close()
+
+	db := utils.MakeChainDatabase(ctx, stack, false)
+	defer db.Close()
+
+	store, err := rawdb.NewPreimageStore(db, stack.ResolvePath("preimages"), false)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	start := time.Now()
+	if err := utils.ImportPreimagesToStore(store, ctx.Args().First()); err != nil {
+		return err
+	}
+	count, err := store.Count()
+	if err != nil {
+		return err
+	}
+	log.Info("Imported preimages into store", "stored", count, "elapsed", common.PrettyDuration(time.Since(start)))
+	return nil
+}
@@ -18,9 +18,16 @@ package main
 
 import (
 	"bytes"
+	"compress/gzip"
 	"encoding/json"
 	"errors"
+	"io"
 	"os"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/ethereum/go-ethereum/cmd/utils"
@@ -45,6 +52,18 @@ var (
 	emptyCode = crypto.Keccak256(nil)
 )
 
+var (
+	checkStateThreadsFlag = cli.IntFlag{
+		Name:  "threads",
+		Usage: "Number of threads to cross-check the state with",
+		Value: runtime.NumCPU(),
+	}
+	checkStateRepairFlag = cli.BoolFlag{
+		Name:  "repair",
+		Usage: "Regenerate the snapshot entries of the mismatching accounts from the tries",
+	}
+)
+
 var (
 	snapshotCommand = cli.Command{
 		Name:        "snapshot",
@@ -100,6 +119,33 @@ geth snapshot verify-state <state-root>
 will traverse the whole accounts and storages set based on the specified
 snapshot and recalculate the root hash of state for verification.
 In other words, this command does the snapshot to trie conversion.
+`,
+			},
+			{
+				Name:      "check-state",
+				Usage:     "Cross-check every snapshot account, storage slot and code against the tries",
+				ArgsUsage: "<root>",
+				Action:    utils.MigrateFlags(checkState),
+				Category:  "MISCELLANEOUS COMMANDS",
+				Flags: []cli.Flag{
+					utils.DataDirFlag,
+					utils.AncientFlag,
+					utils.RopstenFlag,
+					utils.RinkebyFlag,
+					utils.GoerliFlag,
+					checkStateThreadsFlag,
+					checkStateRepairFlag,
+				},
+				Description: `
+geth snapshot check-state <state-root>
+will walk the snapshot and the tries of the specified state in lockstep, using
+multiple threads, and report every account and storage slot missing from either
+of them or differing between them, as well as every missing or corrupted contract
+code. The default checking target is the HEAD state.
+
+With --repair, the snapshot entries of the mismatching accounts are regenerated
+from the tries afterwards. Only the persistent snapshot layer can be repaired, so
+the default target becomes the root of that layer.
 `,
 			},
 			{
@@ -170,6 +216,50 @@ as the backend data source, making this command a lot faster.
 
 The argument is interpreted as block number or hash. If none is provided, the latest
 block is used.
+`,
+			},
+			{
+				Name:      "export",
+				Usage:     "Export the state snapshot into a portable file",
+				ArgsUsage: "<file> [<blockHash> | <blockNum>]",
+				Action:    utils.MigrateFlags(exportSnapshot),
+				Category:  "MISCELLANEOUS COMMANDS",
+				Flags: []cli.Flag{
+					utils.DataDirFlag,
+					utils.AncientFlag,
+					utils.RopstenFlag,
+					utils.RinkebyFlag,
+					utils.GoerliFlag,
+				},
+				Description: `
+geth snapshot export <file> [<blocknum|blockhash>]
+will export all the accounts, storage slots and contract codes of the state of the
+specified block into a chunked and checksummed binary file, based on the snapshot.
+The default exporting target is the HEAD state. If the file ends with .gz, the
+output will be gzipped.
+`,
+			},
+			{
+				Name:      "import",
+				Usage:     "Import the state snapshot from a portable file",
+				ArgsUsage: "<file>",
+				Action:    utils.MigrateFlags(importSnapshot),
+				Category:  "MISCELLANEOUS COMMANDS",
+				Flags: []cli.Flag{
+					utils.DataDirFlag,
+					utils.AncientFlag,
+					utils.RopstenFlag,
+					utils.RinkebyFlag,
+					utils.GoerliFlag,
+				},
+				Description: `
+geth snapshot import <file>
+will import a state exported via 'geth snapshot export' into a datadir containing
+the chain up to the block of the exported state, but not the state itself (e.g.
+the blocks retrieved by an interrupted snap sync). The state snapshot is written as is,
+while the state trie is regenerated from it and verified against the state root of
+the block, which becomes the head of the chain. The node can then continue from
+the imported state without retrieving it from the network.
 `,
 			},
 		},
@@ -240,6 +330,78 @@ func verifyState(ctx *cli.Context) error {
 	return nil
 }
 
+// checkState cross-checks the snapshot against the tries, optionally repairing
+// the mismatching accounts.
+func checkState(ctx *cli.Context) error {
+	stack, _ := makeConfigNode(ctx)
+	defer stack.Close()
+
+	repair := ctx.Bool(checkStateRepairFlag.Name)
+	chaindb := utils.MakeChainDatabase(ctx, stack, !repair)
+	headBlock := rawdb.ReadHeadBlock(chaindb)
+	if headBlock == nil {
+		log.Error("Failed to load head block")
+		return errors.New("no head block")
+	}
+	snaptree, err := snapshot.New(chaindb, trie.NewDatabase(chaindb), 256, headBlock.Root(), false, false, false)
+	if err != nil {
+		log.Error("Failed to open snapshot tree", "err", err)
+		return err
+	}
+	if ctx.NArg() > 1 {
+		log.Error("Too many arguments given")
+		return errors.New("too many arguments")
+	}
+	root := headBlock.Root()
+	if repair {
+		root = snaptree.DiskRoot()
+	}
+	if ctx.NArg() == 1 {
+		root, err = parseRoot(ctx.Args()[0])
+		if err != nil {
+			log.Error("Failed to resolve state root", "err", err)
+			return err
+		}
+	}
+	var (
+		lock    sync.Mutex
+		damaged = make(map[common.Hash]struct{})
+	)
+	stats, err := snaptree.VerifyState(root, ctx.Int(checkStateThreadsFlag.Name), func(m *snapshot.Mismatch) {
+		log.Warn("Snapshot mismatch", "kind", m.Kind, "detail", m)
+
+		// Missing codes can't be fixed by regenerating the snapshot
+		if m.Kind != snapshot.CodeMismatch {
+			lock.Lock()
+			damaged[m.Account] = struct{}{}
+			lock.Unlock()
+		}
+	})
+	if err != nil {
+		log.Error("Failed to check state", "root", root, "err", err)
+		return err
+	}
+	if stats.Mismatches == 0 {
+		log.Info("Checked the state", "root", root, "accounts", stats.Accounts, "slots", stats.Slots, "codes", stats.Codes)
+		return nil
+	}
+	if !repair || len(damaged) == 0 {
+		log.Error("State check failed", "root", root, "mismatches", stats.Mismatches)
+		return errors.New("snapshot mismatches found")
+	}
+	accounts := make([]common.Hash, 0, len(damaged))
+	for hash := range damaged {
+		accounts = append(accounts, hash)
+	}
+	sort.Slice(accounts, func(i, j int) bool { return bytes.Compare(accounts[i][:], accounts[j][:]) < 0 })
+	if err := snaptree.Repair(root, accounts); err != nil {
+		log.Error("Failed to repair snapshot", "root", root, "err", err)
+		return err
+	}
+	log.Info("Repaired the snapshot", "root", root, "accounts", len(accounts))
+	return nil
+}
+
 // traverseState is a helper function used for pruning verification.
 // Basically it just iterates the trie, ensure all nodes and associated
 // contract codes are present.
@@ -294,7 +456,7 @@ func traverseState(ctx *cli.Context) error {
 			return err
 		}
 		if acc.Root != emptyRoot {
-			storageTrie, err := trie.NewSecure(acc.Root, triedb)
+			storageTrie, err := trie.NewSecureWithOwner(common.BytesToHash(accIter.Key), acc.Root, triedb)
 			if err != nil {
 				log.Error("Failed to open storage trie", "root", acc.Root, "err", err)
 				return err
@@ -400,7 +562,7 @@ func traverseRawState(ctx *cli.Context) error {
 				return errors.New("invalid account")
 			}
 			if acc.Root != emptyRoot {
-				storageTrie, err := trie.NewSecure(acc.Root, triedb)
+				storageTrie, err := trie.NewSecureWithOwner(common.BytesToHash(accIter.LeafKey()), acc.Root, triedb)
 				if err != nil {
 					log.Error("Failed to open storage trie", "root", acc.Root, "err", err)
 					return errors.New("missing storage trie")
@@ -528,3 +690,97 @@ func dumpState(ctx *cli.Context) error {
 		"elapsed", common.PrettyDuration(time.Since(start)))
 	return nil
 }
+
+func exportSnapshot(ctx *cli.Context) error {
+	if ctx.NArg() < 1 {
+		utils.Fatalf("This command requires an argument.")
+	}
+	if ctx.NArg() > 2 {
+		log.Error("Too many arguments given")
+		return errors.New("too many arguments")
+	}
+	stack, _ := makeConfigNode(ctx)
+	defer stack.Close()
+
+	chaindb := utils.MakeChainDatabase(ctx, stack, true)
+	headBlock := rawdb.ReadHeadBlock(chaindb)
+	if headBlock == nil {
+		log.Error("Failed to load head block")
+		return errors.New("no head block")
+	}
+	snaptree, err := snapshot.New(chaindb, trie.NewDatabase(chaindb), 256, headBlock.Root(), false, false, false)
+	if err != nil {
+		log.Error("Failed to open snapshot tree", "err", err)
+		return err
+	}
+	header := headBlock.Header()
+	if ctx.NArg() == 2 {
+		arg := ctx.Args()[1]
+		if hashish(arg) {
+			hash := common.HexToHash(arg)
+			if number := rawdb.ReadHeaderNumber(chaindb, hash); number != nil {
+				header = rawdb.ReadHeader(chaindb, hash, *number)
+			} else {
+				header = nil
+			}
+		} else {
+			number, err := strconv.ParseUint(arg, 10, 64)
+			if err != nil {
+				log.Error("Failed to resolve block", "err", err)
+				return err
+			}
+			header = rawdb.ReadHeader(chaindb, rawdb.ReadCanonicalHash(chaindb, number), number)
+		}
+		if header == nil {
+			log.Error("Failed to resolve block", "block", arg)
+			return errors.New("block not found")
+		}
+	}
+	fh, err := os.OpenFile(ctx.Args()[0], os.O_CREATE|os.O_WRONLY|os.O_TRUNC, os.ModePerm)
+	if err != nil {
+		return err
+	}
+	defer fh.Close()
+
+	var writer io.Writer = fh
+	if strings.HasSuffix(ctx.Args()[0], ".gz") {
+		writer = gzip.NewWriter(writer)
+		defer writer.(*gzip.Writer).Close()
+	}
+	if err := snaptree.Export(header, writer); err != nil {
+		log.Error("Failed to export snapshot", "number", header.Number, "root", header.Root, "err", err)
+		return err
+	}
+	return nil
+}
+
+func importSnapshot(ctx *cli.Context) error {
+	if ctx.NArg() != 1 {
+		utils.Fatalf("This command requires an argument.")
+	}
+	stack, _ := makeConfigNode(ctx)
+	defer stack.Close()
+
+	fh, err := os.Open(ctx.Args()[0])
+	if err != nil {
+		return err
+	}
+	defer fh.Close()
+
+	var reader io.Reader = fh
+	if strings.HasSuffix(ctx.Args()[0], ".gz") {
+		if reader, err = gzip.NewReader(reader); err != nil {
+			return err
+		}
+	}
+	chaindb := utils.MakeChainDatabase(ctx, stack, false)
+	defer chaindb.Close()
+
+	header, err := snapshot.Import(chaindb, reader)
+	if err != nil {
+		log.Error("Failed to import snapshot", "err", err)
+		return err
+	}
+	log.Info("Imported the state", "number", header.Number, "hash", header.Hash(), "root", header.Root)
+	return nil
+}
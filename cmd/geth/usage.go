@@ -48,6 +48,14 @@ var AppHelpFlagGroups = []flags.FlagGroup{
 			utils.SyncModeFlag,
 			utils.ExitWhenSyncedFlag,
 			utils.GCModeFlag,
+			utils.StateSchemeFlag,
+			utils.StatePruningFlag,
+			utils.StatePruningIntervalFlag,
+			utils.StatePruningBloomSizeFlag,
+			utils.StatePruningThrottleFlag,
+			utils.StateDiffsFlag,
+			utils.StateArchiveFlag,
+			utils.PreimageStoreFlag,
 			utils.TxLookupLimitFlag,
 			utils.EthStatsURLFlag,
 			utils.IdentityFlag,
@@ -96,6 +104,8 @@ var AppHelpFlagGroups = []flags.FlagGroup{
 			utils.TxPoolNoLocalsFlag,
 			utils.TxPoolJournalFlag,
 			utils.TxPoolRejournalFlag,
+			utils.TxPoolRemoteJournalFlag,
+			utils.TxPoolRemoteJournalAgeFlag,
 			utils.TxPoolPriceLimitFlag,
 			utils.TxPoolPriceBumpFlag,
 			utils.TxPoolAccountSlotsFlag,
@@ -103,6 +113,10 @@ var AppHelpFlagGroups = []flags.FlagGroup{
 			utils.TxPoolAccountQueueFlag,
 			utils.TxPoolGlobalQueueFlag,
 			utils.TxPoolLifetimeFlag,
+			utils.TxPoolAllowedSendersFlag,
+			utils.TxPoolBlockedRecipientsFlag,
+			utils.TxPoolSenderRateLimitFlag,
+			utils.TxPoolSenderRateIntervalFlag,
 		},
 	},
 	{
@@ -140,6 +154,10 @@ var AppHelpFlagGroups = []flags.FlagGroup{
 			utils.HTTPPathPrefixFlag,
 			utils.HTTPCORSDomainFlag,
 			utils.HTTPVirtualHostsFlag,
+			utils.AuthListenFlag,
+			utils.AuthPortFlag,
+			utils.AuthVirtualHostsFlag,
+			utils.JWTSecretFlag,
 			utils.WSEnabledFlag,
 			utils.WSListenAddrFlag,
 			utils.WSPortFlag,
@@ -187,6 +205,7 @@ var AppHelpFlagGroups = []flags.FlagGroup{
 			utils.MinerExtraDataFlag,
 			utils.MinerRecommitIntervalFlag,
 			utils.MinerNoVerifyFlag,
+			utils.MinerOrderingFlag,
 		},
 	},
 	{
@@ -18,11 +18,19 @@
 package utils
 
 import (
+	"bufio"
+	"bytes"
 	"compress/gzip"
+	"crypto/sha256"
+	"encoding/binary"
 	"fmt"
 	"io"
+	"io/ioutil"
+	"math"
+	"math/big"
 	"os"
 	"os/signal"
+	"path/filepath"
 	"runtime"
 	"strings"
 	"syscall"
@@ -36,9 +44,12 @@ import (
 	"github.com/ethereum/go-ethereum/eth/ethconfig"
 	"github.com/ethereum/go-ethereum/ethdb"
 	"github.com/ethereum/go-ethereum/internal/debug"
+	"github.com/ethereum/go-ethereum/internal/era"
 	"github.com/ethereum/go-ethereum/log"
 	"github.com/ethereum/go-ethereum/node"
+	"github.com/ethereum/go-ethereum/params"
 	"github.com/ethereum/go-ethereum/rlp"
+	"github.com/ethereum/go-ethereum/trie"
 	"gopkg.in/urfave/cli.v1"
 )
 
@@ -269,8 +280,29 @@ func ExportAppendChain(blockchain *core.BlockChain, fn string, first uint64, las
 	return nil
 }
 
+// compactPreimagesMagic prefixes the compact binary preimage format, in which
+// every preimage is encoded as its uvarint length followed by its content. The
+// leading byte would start an RLP list, so compact files can't be mistaken for
+// the RLP streams of exported preimages.
+var compactPreimagesMagic = []byte("\xffgeth-preimages\x00")
+
 // ImportPreimages imports a batch of exported hash preimages into the database.
 func ImportPreimages(db ethdb.Database, fn string) error {
+	return importPreimages(fn, func(preimages map[common.Hash][]byte) error {
+		rawdb.WritePreimages(db, preimages)
+		return nil
+	})
+}
+
+// ImportPreimagesToStore imports a batch of exported hash preimages into the
+// append-only preimage store.
+func ImportPreimagesToStore(store *rawdb.PreimageStore, fn string) error {
+	return importPreimages(fn, store.Write)
+}
+
+// importPreimages reads the preimages from either an RLP stream or the compact
+// binary format and feeds them in batches into the given writer.
+func importPreimages(fn string, write func(map[common.Hash][]byte) error) error {
 	log.Info("Importing preimages", "file", fn)
 
 	// Open the file handle and potentially unwrap the gzip stream
@@ -286,32 +318,64 @@ func ImportPreimages(db ethdb.Database, fn string) error {
 			return err
 		}
 	}
-	stream := rlp.NewStream(reader, 0)
-
+	var (
+		buffered = bufio.NewReader(reader)
+		stream   = rlp.NewStream(buffered, 0)
+	)
+	next := func() ([]byte, error) {
+		var blob []byte
+		err := stream.Decode(&blob)
+		return blob, err
+	}
+	if magic, _ := buffered.Peek(len(compactPreimagesMagic)); bytes.Equal(magic, compactPreimagesMagic) {
+		buffered.Discard(len(compactPreimagesMagic))
+		next = func() ([]byte, error) {
+			size, err := binary.ReadUvarint(buffered)
+			if err != nil {
+				return nil, err
+			}
+			if size > 1024 {
+				return nil, fmt.Errorf("oversized preimage: %d bytes", size)
+			}
+			blob := make([]byte, size)
+			if _, err := io.ReadFull(buffered, blob); err != nil {
+				return nil, err
+			}
+			return blob, nil
+		}
+	}
 	// Import the preimages in batches to prevent disk trashing
-	preimages := make(map[common.Hash][]byte)
-
+	var (
+		preimages = make(map[common.Hash][]byte)
+		imported  int
+	)
 	for {
 		// Read the next entry and ensure it's not junk
-		var blob []byte
-
-		if err := stream.Decode(&blob); err != nil {
+		blob, err := next()
+		if err != nil {
 			if err == io.EOF {
 				break
 			}
 			return err
 		}
 		// Accumulate the preimages and flush when enough ws gathered
-		preimages[crypto.Keccak256Hash(blob)] = common.CopyBytes(blob)
+		preimages[crypto.Keccak256Hash(blob)] = blob
 		if len(preimages) > 1024 {
-			rawdb.WritePreimages(db, preimages)
+			if err := write(preimages); err != nil {
+				return err
+			}
+			imported += len(preimages)
 			preimages = make(map[common.Hash][]byte)
 		}
 	}
 	// Flush the last batch preimage data
 	if len(preimages) > 0 {
-		rawdb.WritePreimages(db, preimages)
+		if err := write(preimages); err != nil {
+			return err
+		}
+		imported += len(preimages)
 	}
+	log.Info("Imported preimages", "file", fn, "count", imported)
 	return nil
 }
 
@@ -344,3 +408,317 @@ func ExportPreimages(db ethdb.Database, fn string) error {
 	log.Info("Exported preimages", "file", fn)
 	return nil
 }
+
+// HistoryNetworkName returns the network name used to label era1 archives of
+// the chain with the given genesis hash.
+func HistoryNetworkName(genesis common.Hash) string {
+	switch genesis {
+	case params.MainnetGenesisHash:
+		return "mainnet"
+	case params.RopstenGenesisHash:
+		return "ropsten"
+	case params.RinkebyGenesisHash:
+		return "rinkeby"
+	case params.GoerliGenesisHash:
+		return "goerli"
+	default:
+		return fmt.Sprintf("custom%x", genesis[:4])
+	}
+}
+
+// ExportHistory exports blockchain history into the specified directory,
+// following the era1 format. Every file holds step consecutive blocks and a
+// checksums file with the sha256 of every archive is written alongside. The
+// first block must start an epoch, so that every file is named after the blocks
+// it actually contains.
+func ExportHistory(bc *core.BlockChain, dir string, first, last, step uint64) error {
+	if first%step != 0 {
+		return fmt.Errorf("first block %d is not at an epoch boundary (multiple of %d)", first, step)
+	}
+	log.Info("Exporting blockchain history", "dir", dir)
+	if head := bc.CurrentBlock().NumberU64(); head < last {
+		log.Warn("Last block beyond head, setting last = head", "head", head, "last", last)
+		last = head
+	}
+	network := HistoryNetworkName(bc.Genesis().Hash())
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return fmt.Errorf("error creating output directory: %w", err)
+	}
+	var (
+		start     = time.Now()
+		reported  = time.Now()
+		h         = sha256.New()
+		buf       = bytes.NewBuffer(nil)
+		checksums []string
+	)
+	for i := first; i <= last; i += step {
+		err := func() error {
+			filename := filepath.Join(dir, era.Filename(network, int(i/step), common.Hash{}))
+			f, err := os.Create(filename)
+			if err != nil {
+				return fmt.Errorf("could not create era file: %w", err)
+			}
+			defer f.Close()
+
+			w := era.NewBuilder(f)
+			for j := uint64(0); j < step && j <= last-i; j++ {
+				var (
+					n     = i + j
+					block = bc.GetBlockByNumber(n)
+				)
+				if block == nil {
+					return fmt.Errorf("export failed on #%d: not found", n)
+				}
+				receipts := bc.GetReceiptsByHash(block.Hash())
+				if receipts == nil {
+					return fmt.Errorf("export failed on #%d: receipts not found", n)
+				}
+				td := bc.GetTd(block.Hash(), block.NumberU64())
+				if td == nil {
+					return fmt.Errorf("export failed on #%d: total difficulty not found", n)
+				}
+				if err := w.Add(block, receipts, td); err != nil {
+					return err
+				}
+			}
+			root, err := w.Finalize()
+			if err != nil {
+				return fmt.Errorf("export failed to finalize %d: %w", i/step, err)
+			}
+			// Compute checksum of entire Era1.
+			if _, err := f.Seek(0, io.SeekStart); err != nil {
+				return err
+			}
+			buf.Reset()
+			if _, err := io.Copy(buf, f); err != nil {
+				return fmt.Errorf("unable to calculate checksum: %w", err)
+			}
+			h.Reset()
+			h.Write(buf.Bytes())
+			checksums = append(checksums, common.BytesToHash(h.Sum(nil)).Hex())
+
+			// Set correct filename with root.
+			if err := f.Close(); err != nil {
+				return err
+			}
+			return os.Rename(filename, filepath.Join(dir, era.Filename(network, int(i/step), root)))
+		}()
+		if err != nil {
+			return err
+		}
+		if time.Since(reported) >= 8*time.Second {
+			log.Info("Exporting blocks", "exported", i, "elapsed", common.PrettyDuration(time.Since(start)))
+			reported = time.Now()
+		}
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "checksums.txt"), []byte(strings.Join(checksums, "\n")), os.ModePerm); err != nil {
+		return err
+	}
+	log.Info("Exported blockchain to", "dir", dir)
+	return nil
+}
+
+// ImportHistory imports era1 archives from the given directory straight into
+// the ancient store, verifying checksums, accumulators, header chain validity
+// and body/receipt roots, but without executing any of the blocks.
+func ImportHistory(chain *core.BlockChain, dir string, network string) error {
+	if chain.CurrentHeader().Number.BitLen() != 0 {
+		return fmt.Errorf("history import only supported when starting from genesis")
+	}
+	entries, err := era.ReadDir(dir, network)
+	if err != nil {
+		return fmt.Errorf("error reading %s: %w", dir, err)
+	}
+	if len(entries) == 0 {
+		return fmt.Errorf("no %s era1 files found in %s", network, dir)
+	}
+	checksums, err := readChecksums(filepath.Join(dir, "checksums.txt"), len(entries))
+	if err != nil {
+		return err
+	}
+	var (
+		start    = time.Now()
+		reported = time.Now()
+		imported = 0
+		h        = sha256.New()
+		buf      = bytes.NewBuffer(nil)
+	)
+	for i, filename := range entries {
+		err := func() error {
+			f, err := os.Open(filepath.Join(dir, filename))
+			if err != nil {
+				return fmt.Errorf("unable to open era: %w", err)
+			}
+			defer f.Close()
+
+			// Validate checksum.
+			if checksums != nil {
+				buf.Reset()
+				if _, err := io.Copy(buf, f); err != nil {
+					return fmt.Errorf("unable to recalculate checksum: %w", err)
+				}
+				h.Reset()
+				h.Write(buf.Bytes())
+				if have, want := common.BytesToHash(h.Sum(nil)).Hex(), checksums[i]; have != want {
+					return fmt.Errorf("checksum mismatch: have %s, want %s", have, want)
+				}
+			}
+			// Import all block data from Era1.
+			e, err := era.From(f)
+			if err != nil {
+				return fmt.Errorf("error opening era: %w", err)
+			}
+			if err := verifyAccumulator(e); err != nil {
+				return fmt.Errorf("error verifying %s: %w", filename, err)
+			}
+			it, err := era.NewIterator(e)
+			if err != nil {
+				return fmt.Errorf("error making era reader: %w", err)
+			}
+			var (
+				blocks   types.Blocks
+				receipts []types.Receipts
+				tds      []*big.Int
+			)
+			flush := func() error {
+				if len(blocks) == 0 {
+					return nil
+				}
+				headers := make([]*types.Header, len(blocks))
+				for j, block := range blocks {
+					headers[j] = block.Header()
+				}
+				if n, err := chain.InsertHeaderChain(headers, 100); err != nil {
+					return fmt.Errorf("error inserting header %d: %w", headers[n].Number, err)
+				}
+				for j, block := range blocks {
+					if td := chain.GetTd(block.Hash(), block.NumberU64()); td == nil || td.Cmp(tds[j]) != 0 {
+						return fmt.Errorf("total difficulty mismatch at block %d: have %v, want %v", block.NumberU64(), td, tds[j])
+					}
+				}
+				if _, err := chain.InsertReceiptChain(blocks, receipts, math.MaxUint64); err != nil {
+					return fmt.Errorf("error inserting body %d: %w", blocks[0].NumberU64(), err)
+				}
+				imported += len(blocks)
+				blocks, receipts, tds = blocks[:0], receipts[:0], tds[:0]
+				return nil
+			}
+			for it.Next() {
+				if err := it.Error(); err != nil {
+					return fmt.Errorf("error iterating %s: %w", filename, err)
+				}
+				block, rs, err := it.BlockAndReceipts()
+				if err != nil {
+					return fmt.Errorf("error reading block %d: %w", it.Number(), err)
+				}
+				if block.NumberU64() == 0 {
+					if block.Hash() != chain.Genesis().Hash() {
+						return fmt.Errorf("genesis mismatch: have %x, want %x", block.Hash(), chain.Genesis().Hash())
+					}
+					continue
+				}
+				if hash := types.DeriveSha(block.Transactions(), trie.NewStackTrie(nil)); hash != block.TxHash() {
+					return fmt.Errorf("transaction root mismatch at block %d", block.NumberU64())
+				}
+				if hash := types.CalcUncleHash(block.Uncles()); hash != block.UncleHash() {
+					return fmt.Errorf("uncle root mismatch at block %d", block.NumberU64())
+				}
+				if hash := types.DeriveSha(rs, trie.NewStackTrie(nil)); hash != block.ReceiptHash() {
+					return fmt.Errorf("receipt root mismatch at block %d", block.NumberU64())
+				}
+				td, err := it.TotalDifficulty()
+				if err != nil {
+					return fmt.Errorf("error reading total difficulty %d: %w", it.Number(), err)
+				}
+				blocks, receipts, tds = append(blocks, block), append(receipts, rs), append(tds, td)
+				if len(blocks) >= importBatchSize {
+					if err := flush(); err != nil {
+						return err
+					}
+				}
+				if time.Since(reported) >= 8*time.Second {
+					log.Info("Importing Era files", "head", it.Number(), "imported", imported, "elapsed", common.PrettyDuration(time.Since(start)))
+					reported = time.Now()
+				}
+			}
+			if err := it.Error(); err != nil {
+				return fmt.Errorf("error iterating %s: %w", filename, err)
+			}
+			return flush()
+		}()
+		if err != nil {
+			return err
+		}
+	}
+	log.Info("Imported blockchain history", "dir", dir, "blocks", imported, "elapsed", common.PrettyDuration(time.Since(start)))
+	return nil
+}
+
+// readChecksums loads the archive checksums from the given file. A missing
+// file is tolerated, but a present one must cover every archive.
+func readChecksums(path string, count int) ([]string, error) {
+	blob, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		log.Warn("No checksums file found, skipping archive verification", "path", path)
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	checksums := strings.Split(strings.TrimSpace(string(blob)), "\n")
+	if len(checksums) < count {
+		return nil, fmt.Errorf("checksums file lists %d archives, have %d", len(checksums), count)
+	}
+	return checksums, nil
+}
+
+// verifyAccumulator recomputes the accumulator root of an era1 archive from its
+// block hashes and total difficulties and checks it against the stored value.
+func verifyAccumulator(e *era.Era) error {
+	want, err := e.Accumulator()
+	if err != nil {
+		return fmt.Errorf("error reading accumulator: %w", err)
+	}
+	it, err := era.NewRawIterator(e)
+	if err != nil {
+		return err
+	}
+	var (
+		hashes []common.Hash
+		tds    []*big.Int
+	)
+	for it.Next() {
+		if err := it.Error(); err != nil {
+			return err
+		}
+		var header types.Header
+		if err := rlp.Decode(it.Header, &header); err != nil {
+			return err
+		}
+		td, err := ioutil.ReadAll(it.TotalDifficulty)
+		if err != nil {
+			return err
+		}
+		hashes, tds = append(hashes, header.Hash()), append(tds, new(big.Int).SetBytes(reverse(td)))
+	}
+	if err := it.Error(); err != nil {
+		return err
+	}
+	have, err := era.ComputeAccumulator(hashes, tds)
+	if err != nil {
+		return err
+	}
+	if have != want {
+		return fmt.Errorf("accumulator mismatch: have %x, want %x", have, want)
+	}
+	return nil
+}
+
+// reverse returns a reversed copy of the byte slice, converting little endian
+// era1 integers into big endian ones.
+func reverse(b []byte) []byte {
+	r := make([]byte, len(b))
+	for i := range b {
+		r[len(b)-1-i] = b[i]
+	}
+	return r
+}
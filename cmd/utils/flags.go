@@ -38,6 +38,8 @@ import (
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/common/fdlimit"
 	"github.com/ethereum/go-ethereum/consensus"
+	"github.com/ethereum/go-ethereum/consensus/beacon"
+	"github.com/ethereum/go-ethereum/consensus/bft"
 	"github.com/ethereum/go-ethereum/consensus/clique"
 	"github.com/ethereum/go-ethereum/consensus/ethash"
 	"github.com/ethereum/go-ethereum/core"
@@ -217,6 +219,42 @@ var (
 		Name:  "snapshot",
 		Usage: `Enables snapshot-database mode (default = enable)`,
 	}
+	StateSchemeFlag = cli.StringFlag{
+		Name:  "state.scheme",
+		Usage: `Scheme to use for storing the state trie nodes ("hash" or "path", default = the one of the database)`,
+	}
+	StatePruningFlag = cli.BoolFlag{
+		Name:  "state.prune",
+		Usage: "Prune stale state in the background during block import (full sync mode only)",
+	}
+	StatePruningIntervalFlag = cli.Uint64Flag{
+		Name:  "state.prune.interval",
+		Usage: "Minimum number of blocks between two consecutive online state pruning targets",
+		Value: ethconfig.Defaults.StatePruningInterval,
+	}
+	StatePruningBloomSizeFlag = cli.Uint64Flag{
+		Name:  "state.prune.bloomsize",
+		Usage: "Megabytes of memory allocated to the online state pruning bloom filter",
+		Value: ethconfig.Defaults.StatePruningBloomSize,
+	}
+	StatePruningThrottleFlag = cli.DurationFlag{
+		Name:  "state.prune.throttle",
+		Usage: "Pause between two consecutive online state pruning deletion batches",
+		Value: ethconfig.Defaults.StatePruningThrottle,
+	}
+	StateDiffsFlag = cli.Uint64Flag{
+		Name:  "state.diffs",
+		Usage: "Number of recent blocks to keep reverse state diffs for, to roll back state on deep reorgs (0 = disabled)",
+		Value: ethconfig.Defaults.StateDiffs,
+	}
+	StateArchiveFlag = cli.BoolFlag{
+		Name:  "state.archive",
+		Usage: "Archive the flat state diffs of all blocks to serve historical state reads without the historical tries",
+	}
+	PreimageStoreFlag = cli.BoolFlag{
+		Name:  "preimages.store",
+		Usage: "Record the preimages of all hashed trie keys, seen during block execution and sync, into an append-only store",
+	}
 	TxLookupLimitFlag = cli.Uint64Flag{
 		Name:  "txlookuplimit",
 		Usage: "Number of recent blocks to maintain transactions index for (default = about one year, 0 = entire chain)",
@@ -339,6 +377,15 @@ var (
 		Usage: "Time interval to regenerate the local transaction journal",
 		Value: core.DefaultTxPoolConfig.Rejournal,
 	}
+	TxPoolRemoteJournalFlag = cli.StringFlag{
+		Name:  "txpool.remotejournal",
+		Usage: "Disk journal for remote transactions to survive node restarts (empty = disabled)",
+	}
+	TxPoolRemoteJournalAgeFlag = cli.DurationFlag{
+		Name:  "txpool.remotejournalage",
+		Usage: "Maximum age of the remote transactions to journal and reload on startup",
+		Value: core.DefaultTxPoolConfig.RemoteJournalAge,
+	}
 	TxPoolPriceLimitFlag = cli.Uint64Flag{
 		Name:  "txpool.pricelimit",
 		Usage: "Minimum gas price limit to enforce for acceptance into the pool",
@@ -374,6 +421,24 @@ var (
 		Usage: "Maximum amount of time non-executable transaction are queued",
 		Value: ethconfig.Defaults.TxPool.Lifetime,
 	}
+	TxPoolAllowedSendersFlag = cli.StringFlag{
+		Name:  "txpool.allowedsenders",
+		Usage: "Comma separated accounts allowed to submit transactions (default = everyone)",
+	}
+	TxPoolBlockedRecipientsFlag = cli.StringFlag{
+		Name:  "txpool.blockedrecipients",
+		Usage: "Comma separated contracts that transactions may not be sent to",
+	}
+	TxPoolSenderRateLimitFlag = cli.Uint64Flag{
+		Name:  "txpool.senderratelimit",
+		Usage: "Maximum number of remote transactions accepted per sender and rate interval (0 = unlimited)",
+		Value: ethconfig.Defaults.TxPool.Policy.SenderRateLimit,
+	}
+	TxPoolSenderRateIntervalFlag = cli.DurationFlag{
+		Name:  "txpool.senderrateinterval",
+		Usage: "Time interval over which the sender rate limit is enforced",
+		Value: ethconfig.Defaults.TxPool.Policy.SenderRateInterval,
+	}
 	// Performance tuning settings
 	CacheFlag = cli.IntFlag{
 		Name:  "cache",
@@ -464,6 +529,11 @@ var (
 		Name:  "miner.noverify",
 		Usage: "Disable remote sealing verification",
 	}
+	MinerOrderingFlag = cli.StringFlag{
+		Name:  "miner.ordering",
+		Usage: `Transaction ordering of the mined blocks ("price" or "arrival")`,
+		Value: miner.OrderingPrice,
+	}
 	// Account settings
 	UnlockedAccountFlag = cli.StringFlag{
 		Name:  "unlock",
@@ -559,6 +629,26 @@ var (
 		Usage: "HTTP path path prefix on which JSON-RPC is served. Use '/' to serve on all paths.",
 		Value: "",
 	}
+	// Authenticated RPC HTTP settings
+	AuthListenFlag = cli.StringFlag{
+		Name:  "authrpc.addr",
+		Usage: "Listening address for authenticated APIs",
+		Value: node.DefaultConfig.AuthAddr,
+	}
+	AuthPortFlag = cli.IntFlag{
+		Name:  "authrpc.port",
+		Usage: "Listening port for authenticated APIs",
+		Value: node.DefaultConfig.AuthPort,
+	}
+	AuthVirtualHostsFlag = cli.StringFlag{
+		Name:  "authrpc.vhosts",
+		Usage: "Comma separated list of virtual hostnames from which to accept requests (server enforced). Accepts '*' wildcard.",
+		Value: strings.Join(node.DefaultConfig.AuthVirtualHosts, ","),
+	}
+	JWTSecretFlag = cli.StringFlag{
+		Name:  "authrpc.jwtsecret",
+		Usage: "Path to a JWT secret to use for authenticated RPC endpoints",
+	}
 	GraphQLEnabledFlag = cli.BoolFlag{
 		Name:  "graphql",
 		Usage: "Enable GraphQL on the HTTP-RPC server. Note that GraphQL can only be started if an HTTP server is started as well.",
@@ -936,6 +1026,18 @@ func setHTTP(ctx *cli.Context, cfg *node.Config) {
 		cfg.HTTPPort = ctx.GlobalInt(HTTPPortFlag.Name)
 	}
 
+	if ctx.GlobalIsSet(AuthListenFlag.Name) {
+		cfg.AuthAddr = ctx.GlobalString(AuthListenFlag.Name)
+	}
+
+	if ctx.GlobalIsSet(AuthPortFlag.Name) {
+		cfg.AuthPort = ctx.GlobalInt(AuthPortFlag.Name)
+	}
+
+	if ctx.GlobalIsSet(AuthVirtualHostsFlag.Name) {
+		cfg.AuthVirtualHosts = SplitAndTrim(ctx.GlobalString(AuthVirtualHostsFlag.Name))
+	}
+
 	if ctx.GlobalIsSet(HTTPCORSDomainFlag.Name) {
 		cfg.HTTPCors = SplitAndTrim(ctx.GlobalString(HTTPCORSDomainFlag.Name))
 	}
@@ -1203,6 +1305,10 @@ func SetNodeConfig(ctx *cli.Context, cfg *node.Config) {
 	setDataDir(ctx, cfg)
 	setSmartCard(ctx, cfg)
 
+	if ctx.GlobalIsSet(JWTSecretFlag.Name) {
+		cfg.JWTSecret = ctx.GlobalString(JWTSecretFlag.Name)
+	}
+
 	if ctx.GlobalIsSet(ExternalSignerFlag.Name) {
 		cfg.ExternalSigner = ctx.GlobalString(ExternalSignerFlag.Name)
 	}
@@ -1312,6 +1418,12 @@ func setTxPool(ctx *cli.Context, cfg *core.TxPoolConfig) {
 	if ctx.GlobalIsSet(TxPoolRejournalFlag.Name) {
 		cfg.Rejournal = ctx.GlobalDuration(TxPoolRejournalFlag.Name)
 	}
+	if ctx.GlobalIsSet(TxPoolRemoteJournalFlag.Name) {
+		cfg.RemoteJournal = ctx.GlobalString(TxPoolRemoteJournalFlag.Name)
+	}
+	if ctx.GlobalIsSet(TxPoolRemoteJournalAgeFlag.Name) {
+		cfg.RemoteJournalAge = ctx.GlobalDuration(TxPoolRemoteJournalAgeFlag.Name)
+	}
 	if ctx.GlobalIsSet(TxPoolPriceLimitFlag.Name) {
 		cfg.PriceLimit = ctx.GlobalUint64(TxPoolPriceLimitFlag.Name)
 	}
@@ -1333,6 +1445,31 @@ func setTxPool(ctx *cli.Context, cfg *core.TxPoolConfig) {
 	if ctx.GlobalIsSet(TxPoolLifetimeFlag.Name) {
 		cfg.Lifetime = ctx.GlobalDuration(TxPoolLifetimeFlag.Name)
 	}
+	if ctx.GlobalIsSet(TxPoolAllowedSendersFlag.Name) {
+		cfg.Policy.AllowedSenders = splitAccounts(TxPoolAllowedSendersFlag.Name, ctx.GlobalString(TxPoolAllowedSendersFlag.Name))
+	}
+	if ctx.GlobalIsSet(TxPoolBlockedRecipientsFlag.Name) {
+		cfg.Policy.BlockedRecipients = splitAccounts(TxPoolBlockedRecipientsFlag.Name, ctx.GlobalString(TxPoolBlockedRecipientsFlag.Name))
+	}
+	if ctx.GlobalIsSet(TxPoolSenderRateLimitFlag.Name) {
+		cfg.Policy.SenderRateLimit = ctx.GlobalUint64(TxPoolSenderRateLimitFlag.Name)
+	}
+	if ctx.GlobalIsSet(TxPoolSenderRateIntervalFlag.Name) {
+		cfg.Policy.SenderRateInterval = ctx.GlobalDuration(TxPoolSenderRateIntervalFlag.Name)
+	}
+}
+
+// splitAccounts parses the comma separated list of accounts given to a flag.
+func splitAccounts(flag string, list string) []common.Address {
+	var accounts []common.Address
+	for _, account := range strings.Split(list, ",") {
+		trimmed := strings.TrimSpace(account)
+		if !common.IsHexAddress(trimmed) {
+			Fatalf("Invalid account in --%s: %s", flag, trimmed)
+		}
+		accounts = append(accounts, common.HexToAddress(trimmed))
+	}
+	return accounts
 }
 
 func setEthash(ctx *cli.Context, cfg *ethconfig.Config) {
@@ -1382,6 +1519,12 @@ func setMiner(ctx *cli.Context, cfg *miner.Config) {
 	if ctx.GlobalIsSet(MinerNoVerifyFlag.Name) {
 		cfg.Noverify = ctx.GlobalBool(MinerNoVerifyFlag.Name)
 	}
+	if ctx.GlobalIsSet(MinerOrderingFlag.Name) {
+		cfg.Ordering = ctx.GlobalString(MinerOrderingFlag.Name)
+		if _, err := miner.NewTxOrdering(cfg.Ordering); err != nil {
+			Fatalf("Invalid miner ordering: %v", err)
+		}
+	}
 	if ctx.GlobalIsSet(LegacyMinerGasTargetFlag.Name) {
 		log.Warn("The generic --miner.gastarget flag is deprecated and will be removed in the future!")
 	}
@@ -1519,6 +1662,48 @@ func SetEthConfig(ctx *cli.Context, stack *node.Node, cfg *ethconfig.Config) {
 	if ctx.GlobalIsSet(CacheNoPrefetchFlag.Name) {
 		cfg.NoPrefetch = ctx.GlobalBool(CacheNoPrefetchFlag.Name)
 	}
+	if ctx.GlobalIsSet(StateSchemeFlag.Name) {
+		cfg.StateScheme = ctx.GlobalString(StateSchemeFlag.Name)
+		if cfg.StateScheme == rawdb.PathScheme {
+			if cfg.SyncMode != downloader.FullSync {
+				log.Info("Path state scheme requested, enabling full sync")
+				cfg.SyncMode = downloader.FullSync
+			}
+			if cfg.NoPruning {
+				Fatalf("--%s=%s can't be used together with --%s=archive", StateSchemeFlag.Name, rawdb.PathScheme, GCModeFlag.Name)
+			}
+		}
+	}
+	if ctx.GlobalIsSet(StatePruningFlag.Name) {
+		cfg.StatePruning = ctx.GlobalBool(StatePruningFlag.Name)
+		if cfg.StatePruning && cfg.NoPruning {
+			log.Warn("Disabling online state pruning since archive mode is used")
+			cfg.StatePruning = false
+		}
+		// Snap and fast sync write state into the database behind the pruner's
+		// back, which a running sweep would consider stale and delete.
+		if cfg.StatePruning && cfg.SyncMode != downloader.FullSync && !ctx.GlobalBool(DeveloperFlag.Name) {
+			Fatalf("--%s can only be used together with --%s=full", StatePruningFlag.Name, SyncModeFlag.Name)
+		}
+	}
+	if ctx.GlobalIsSet(StatePruningIntervalFlag.Name) {
+		cfg.StatePruningInterval = ctx.GlobalUint64(StatePruningIntervalFlag.Name)
+	}
+	if ctx.GlobalIsSet(StatePruningBloomSizeFlag.Name) {
+		cfg.StatePruningBloomSize = ctx.GlobalUint64(StatePruningBloomSizeFlag.Name)
+	}
+	if ctx.GlobalIsSet(StatePruningThrottleFlag.Name) {
+		cfg.StatePruningThrottle = ctx.GlobalDuration(StatePruningThrottleFlag.Name)
+	}
+	if ctx.GlobalIsSet(StateDiffsFlag.Name) {
+		cfg.StateDiffs = ctx.GlobalUint64(StateDiffsFlag.Name)
+	}
+	if ctx.GlobalIsSet(StateArchiveFlag.Name) {
+		cfg.StateArchive = ctx.GlobalBool(StateArchiveFlag.Name)
+	}
+	if ctx.GlobalIsSet(PreimageStoreFlag.Name) {
+		cfg.PreimageStore = ctx.GlobalBool(PreimageStoreFlag.Name)
+	}
 	// Read the value from the flag no matter if it's set or not.
 	cfg.Preimages = ctx.GlobalBool(CachePreimagesFlag.Name)
 	if cfg.NoPruning && !cfg.Preimages {
@@ -1836,10 +2021,24 @@ func MakeGenesis(ctx *cli.Context) *core.Genesis {
 	return genesis
 }
 
+// MakeStateScheme validates the state scheme requested on the command line
+// against the one of the database, marking fresh databases with the path scheme.
+func MakeStateScheme(ctx *cli.Context, disk ethdb.Database) string {
+	scheme, err := rawdb.ParseStateScheme(ctx.GlobalString(StateSchemeFlag.Name), disk)
+	if err != nil {
+		Fatalf("%v", err)
+	}
+	if scheme == rawdb.PathScheme {
+		rawdb.WriteTrieScheme(disk, scheme)
+	}
+	return scheme
+}
+
 // MakeChain creates a chain manager from set command line flags.
 func MakeChain(ctx *cli.Context, stack *node.Node) (chain *core.BlockChain, chainDb ethdb.Database) {
 	var err error
 	chainDb = MakeChainDatabase(ctx, stack, false) // TODO(rjl493456442) support read-only database
+	scheme := MakeStateScheme(ctx, chainDb)
 	config, _, err := core.SetupGenesisBlock(chainDb, MakeGenesis(ctx))
 	if err != nil {
 		Fatalf("%v", err)
@@ -1847,6 +2046,8 @@ func MakeChain(ctx *cli.Context, stack *node.Node) (chain *core.BlockChain, chai
 	var engine consensus.Engine
 	if config.Clique != nil {
 		engine = clique.New(config.Clique, chainDb)
+	} else if config.BFT != nil {
+		engine = bft.New(config.BFT, chainDb)
 	} else {
 		engine = ethash.NewFaker()
 		if !ctx.GlobalBool(FakePoWFlag.Name) {
@@ -1862,6 +2063,9 @@ func MakeChain(ctx *cli.Context, stack *node.Node) (chain *core.BlockChain, chai
 			}, nil, false)
 		}
 	}
+	if config.TerminalTotalDifficulty != nil {
+		engine = beacon.New(engine)
+	}
 	if gcmode := ctx.GlobalString(GCModeFlag.Name); gcmode != "full" && gcmode != "archive" {
 		Fatalf("--%s must be either 'full' or 'archive'", GCModeFlag.Name)
 	}
@@ -1873,11 +2077,18 @@ func MakeChain(ctx *cli.Context, stack *node.Node) (chain *core.BlockChain, chai
 		TrieTimeLimit:       ethconfig.Defaults.TrieTimeout,
 		SnapshotLimit:       ethconfig.Defaults.SnapshotCache,
 		Preimages:           ctx.GlobalBool(CachePreimagesFlag.Name),
+		StateScheme:         scheme,
+	}
+	if cache.TrieDirtyDisabled && scheme == rawdb.PathScheme {
+		Fatalf("--%s=%s can't be used together with --%s=archive", StateSchemeFlag.Name, rawdb.PathScheme, GCModeFlag.Name)
 	}
 	if cache.TrieDirtyDisabled && !cache.Preimages {
 		cache.Preimages = true
 		log.Info("Enabling recording of key preimages since archive mode is used")
 	}
+	if ctx.GlobalBool(PreimageStoreFlag.Name) {
+		cache.PreimageDir = stack.ResolvePath("preimages")
+	}
 	if !ctx.GlobalBool(SnapshotFlag.Name) {
 		cache.SnapshotLimit = 0 // Disabled
 	}
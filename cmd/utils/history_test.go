@@ -0,0 +1,167 @@
+// Copyright 2021 The go-ethereum Authors
+// This file is part of go-ethereum.
+//
+// go-ethereum is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// go-ethereum is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with go-ethereum. If not, see <http://www.gnu.org/licenses/>.
+
+package utils
+
+import (
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/consensus/ethash"
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/internal/era"
+	"github.com/ethereum/go-ethereum/params"
+	"github.com/ethereum/go-ethereum/trie"
+)
+
+var (
+	count uint64 = 128
+	step  uint64 = 16
+)
+
+func TestHistoryImportAndExport(t *testing.T) {
+	var (
+		key, _  = crypto.HexToECDSA("b71c71a67e1177ad4e901695e1b4b9ee17ae16c6668d313eac2f96dbcda3f291")
+		address = crypto.PubkeyToAddress(key.PublicKey)
+		genesis = &core.Genesis{
+			Config: params.TestChainConfig,
+			Alloc:  core.GenesisAlloc{address: {Balance: big.NewInt(1000000000000000000)}},
+		}
+		signer = types.LatestSigner(genesis.Config)
+	)
+	// Generate chain.
+	db := rawdb.NewMemoryDatabase()
+	gblock := genesis.MustCommit(db)
+	blocks, _ := core.GenerateChain(genesis.Config, gblock, ethash.NewFaker(), db, int(count), func(i int, g *core.BlockGen) {
+		if i == 0 {
+			return
+		}
+		tx, err := types.SignNewTx(key, signer, &types.DynamicFeeTx{
+			ChainID:   genesis.Config.ChainID,
+			Nonce:     uint64(i - 1),
+			GasTipCap: common.Big0,
+			GasFeeCap: g.PrevBlock(0).BaseFee(),
+			Gas:       50000,
+			To:        &common.Address{0xaa},
+			Value:     big.NewInt(int64(i)),
+			Data:      nil,
+		})
+		if err != nil {
+			t.Fatalf("error creating tx: %v", err)
+		}
+		g.AddTx(tx)
+	})
+	// Initialize BlockChain.
+	chain, err := core.NewBlockChain(db, nil, genesis.Config, ethash.NewFaker(), vm.Config{}, nil, nil)
+	if err != nil {
+		t.Fatalf("unable to initialize chain: %v", err)
+	}
+	defer chain.Stop()
+	if _, err := chain.InsertChain(blocks); err != nil {
+		t.Fatalf("error inserting chain: %v", err)
+	}
+	// Make temp directory for era files.
+	dir, err := ioutil.TempDir("", "history-export-test")
+	if err != nil {
+		t.Fatalf("error creating temp test directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	// Exports not starting at an epoch boundary are rejected.
+	if err := ExportHistory(chain, dir, 1, count, step); err == nil {
+		t.Fatalf("misaligned history export succeeded")
+	}
+	// Export history to temp directory.
+	if err := ExportHistory(chain, dir, 0, count, step); err != nil {
+		t.Fatalf("error exporting history: %v", err)
+	}
+	network := HistoryNetworkName(gblock.Hash())
+	entries, err := era.ReadDir(dir, network)
+	if err != nil {
+		t.Fatalf("error reading era dir: %v", err)
+	}
+	if want := int(count/step) + 1; len(entries) != want {
+		t.Fatalf("wrong number of era files: have %d, want %d", len(entries), want)
+	}
+	// Import history into a fresh freezer backed database.
+	frdir, err := ioutil.TempDir("", "history-import-test")
+	if err != nil {
+		t.Fatalf("error creating temp freezer directory: %v", err)
+	}
+	defer os.RemoveAll(frdir)
+
+	db2, err := rawdb.NewDatabaseWithFreezer(rawdb.NewMemoryDatabase(), frdir, "", false)
+	if err != nil {
+		t.Fatalf("error creating freezer database: %v", err)
+	}
+	defer db2.Close()
+
+	genesis.MustCommit(db2)
+	imported, err := core.NewBlockChain(db2, nil, genesis.Config, ethash.NewFaker(), vm.Config{}, nil, nil)
+	if err != nil {
+		t.Fatalf("unable to initialize chain: %v", err)
+	}
+	defer imported.Stop()
+
+	if err := ImportHistory(imported, dir, network); err != nil {
+		t.Fatalf("failed to import history: %v", err)
+	}
+	if frozen, _ := db2.Ancients(); frozen != count+1 {
+		t.Fatalf("ancient store size mismatch: have %d, want %d", frozen, count+1)
+	}
+	if head := imported.CurrentFastBlock().NumberU64(); head != count {
+		t.Fatalf("fast head mismatch: have %d, want %d", head, count)
+	}
+	for _, want := range blocks {
+		num, hash := want.NumberU64(), want.Hash()
+		have := imported.GetBlockByNumber(num)
+		if have == nil || have.Hash() != hash {
+			t.Fatalf("block %d mismatch", num)
+		}
+		if have, want := imported.GetTd(hash, num), chain.GetTd(hash, num); have.Cmp(want) != 0 {
+			t.Fatalf("block %d total difficulty mismatch: have %v, want %v", num, have, want)
+		}
+		receipts := imported.GetReceiptsByHash(hash)
+		if types.DeriveSha(receipts, trie.NewStackTrie(nil)) != want.ReceiptHash() {
+			t.Fatalf("block %d receipts mismatch", num)
+		}
+	}
+	// Importing on top of a non-empty chain must be rejected
+	if err := ImportHistory(chain, dir, network); err == nil {
+		t.Fatalf("expected import error on populated chain")
+	}
+	// Corrupted archives must be rejected by the checksum verification
+	corrupt := filepath.Join(dir, entries[1])
+	blob, _ := ioutil.ReadFile(corrupt)
+	blob[len(blob)/2] ^= 0xff
+	ioutil.WriteFile(corrupt, blob, 0644)
+
+	db3 := rawdb.NewMemoryDatabase()
+	genesis.MustCommit(db3)
+	third, _ := core.NewBlockChain(db3, nil, genesis.Config, ethash.NewFaker(), vm.Config{}, nil, nil)
+	defer third.Stop()
+	if err := ImportHistory(third, dir, network); err == nil {
+		t.Fatalf("expected import error on corrupted archive")
+	}
+}
@@ -0,0 +1,100 @@
+// Copyright 2021 The go-ethereum Authors
+// This file is part of go-ethereum.
+//
+// go-ethereum is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// go-ethereum is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with go-ethereum. If not, see <http://www.gnu.org/licenses/>.
+
+package utils
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// Tests that preimages exported as an RLP stream and preimages in the compact
+// binary format are both imported into the preimage store.
+func TestImportPreimages(t *testing.T) {
+	dir, err := ioutil.TempDir("", "geth-preimages")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// Export a set of legacy preimages as an RLP stream
+	var (
+		db      = rawdb.NewMemoryDatabase()
+		blobs   [][]byte
+		rlpFile = filepath.Join(dir, "preimages.rlp.gz")
+	)
+	for i := 0; i < 2000; i++ {
+		blob := crypto.Keccak256([]byte{byte(i), byte(i >> 8)})
+		if i%2 == 0 {
+			blob = blob[:20]
+		}
+		blobs = append(blobs, blob)
+	}
+	for _, blob := range blobs[:1500] {
+		rawdb.WritePreimages(db, map[common.Hash][]byte{crypto.Keccak256Hash(blob): blob})
+	}
+	if err := ExportPreimages(db, rlpFile); err != nil {
+		t.Fatalf("failed to export preimages: %v", err)
+	}
+	// Write an overlapping set of preimages in the compact format
+	compact := new(bytes.Buffer)
+	compact.Write(compactPreimagesMagic)
+	for _, blob := range blobs[1000:] {
+		var size [binary.MaxVarintLen64]byte
+		compact.Write(size[:binary.PutUvarint(size[:], uint64(len(blob)))])
+		compact.Write(blob)
+	}
+	compactFile := filepath.Join(dir, "preimages.bin")
+	if err := ioutil.WriteFile(compactFile, compact.Bytes(), 0600); err != nil {
+		t.Fatal(err)
+	}
+	// Import both into a fresh store and check the contents
+	storedb := rawdb.NewMemoryDatabase()
+	store, err := rawdb.NewPreimageStore(storedb, filepath.Join(dir, "store"), false)
+	if err != nil {
+		t.Fatalf("failed to open preimage store: %v", err)
+	}
+	defer store.Close()
+
+	for _, file := range []string{rlpFile, compactFile} {
+		if err := ImportPreimagesToStore(store, file); err != nil {
+			t.Fatalf("failed to import %s: %v", file, err)
+		}
+	}
+	if count, _ := store.Count(); count != uint64(len(blobs)) {
+		t.Fatalf("stored preimage count mismatch: have %d, want %d", count, len(blobs))
+	}
+	for _, blob := range blobs {
+		if have := store.Preimage(crypto.Keccak256Hash(blob)); !bytes.Equal(have, blob) {
+			t.Fatalf("preimage mismatch: have %x, want %x", have, blob)
+		}
+	}
+	// Truncated compact files should be rejected
+	if err := ioutil.WriteFile(compactFile, compact.Bytes()[:compact.Len()-1], 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := ImportPreimagesToStore(store, compactFile); err == nil {
+		t.Fatalf("imported truncated preimages")
+	}
+}
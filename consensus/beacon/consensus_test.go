@@ -0,0 +1,230 @@
+// Copyright 2021 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package beacon
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/consensus/ethash"
+	"github.com/ethereum/go-ethereum/consensus/misc"
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/ethereum/go-ethereum/params"
+	"github.com/ethereum/go-ethereum/trie"
+)
+
+// newTestChain creates a proof-of-work chain of n blocks with the terminal total
+// difficulty set to the total difficulty of the last one. The first imported
+// blocks are inserted into a blockchain running the beacon engine.
+func newTestChain(t *testing.T, n, imported int) (*core.BlockChain, []*types.Block) {
+	config := *params.AllEthashProtocolChanges
+	var (
+		db      = rawdb.NewMemoryDatabase()
+		genspec = &core.Genesis{Config: &config, Difficulty: big.NewInt(0), BaseFee: big.NewInt(params.InitialBaseFee)}
+		genesis = genspec.MustCommit(db)
+	)
+	blocks, _ := core.GenerateChain(&config, genesis, ethash.NewFaker(), db, n, nil)
+
+	ttd := new(big.Int)
+	for _, block := range blocks {
+		ttd.Add(ttd, block.Difficulty())
+	}
+	config.TerminalTotalDifficulty = ttd
+
+	chain, _ := core.NewBlockChain(db, nil, &config, New(ethash.NewFaker()), vm.Config{}, nil, nil)
+	if _, err := chain.InsertChain(blocks[:imported]); err != nil {
+		t.Fatalf("failed to insert pre-merge chain: %v", err)
+	}
+	return chain, blocks
+}
+
+// makePoSHeader creates a proof-of-stake header on top of the given parent.
+func makePoSHeader(config *params.ChainConfig, parent *types.Header) *types.Header {
+	return &types.Header{
+		ParentHash: parent.Hash(),
+		UncleHash:  types.EmptyUncleHash,
+		Root:       parent.Root,
+		Difficulty: new(big.Int),
+		Number:     new(big.Int).Add(parent.Number, common.Big1),
+		GasLimit:   parent.GasLimit,
+		Time:       parent.Time + 12,
+		BaseFee:    misc.CalcBaseFee(config, parent),
+		MixDigest:  common.Hash{0x01},
+	}
+}
+
+// Tests that proof-of-stake headers are only accepted on top of the terminal
+// block and that they have to carry the post-merge constants.
+func TestVerifyHeader(t *testing.T) {
+	chain, blocks := newTestChain(t, 10, 10)
+	defer chain.Stop()
+
+	var (
+		engine   = chain.Engine()
+		config   = chain.Config()
+		terminal = blocks[len(blocks)-1].Header()
+	)
+	if err := engine.VerifyHeader(chain, makePoSHeader(config, terminal), true); err != nil {
+		t.Fatalf("failed to verify header on terminal block: %v", err)
+	}
+	if err := engine.VerifyHeader(chain, makePoSHeader(config, blocks[len(blocks)-2].Header()), true); err != errInvalidTerminal {
+		t.Fatalf("header before terminal block error mismatch: have %v, want %v", err, errInvalidTerminal)
+	}
+	header := makePoSHeader(config, terminal)
+	header.Nonce = types.EncodeNonce(1)
+	if err := engine.VerifyHeader(chain, header, true); err != errInvalidNonce {
+		t.Fatalf("header nonce error mismatch: have %v, want %v", err, errInvalidNonce)
+	}
+	header = makePoSHeader(config, terminal)
+	header.UncleHash = common.Hash{0x02}
+	if err := engine.VerifyHeader(chain, header, true); err != errInvalidUncleHash {
+		t.Fatalf("header uncle hash error mismatch: have %v, want %v", err, errInvalidUncleHash)
+	}
+	header = makePoSHeader(config, terminal)
+	header.Time = terminal.Time
+	if err := engine.VerifyHeader(chain, header, true); err != errOlderBlockTime {
+		t.Fatalf("header timestamp error mismatch: have %v, want %v", err, errOlderBlockTime)
+	}
+}
+
+// Tests that proof-of-work headers are rejected once the terminal total difficulty
+// is reached, both individually and in batches, even if they are valid by the
+// eth1 rules.
+func TestVerifyPoWHeaderAfterTerminal(t *testing.T) {
+	chain, blocks := newTestChain(t, 10, 5)
+	defer chain.Stop()
+
+	var (
+		config  = chain.Config()
+		headers []*types.Header
+	)
+	for _, block := range blocks[5:] {
+		headers = append(headers, block.Header())
+	}
+	terminal := headers[len(headers)-1]
+
+	header := makePoSHeader(config, terminal)
+	header.Difficulty = ethash.CalcDifficulty(config, header.Time, terminal)
+	headers = append(headers, header)
+
+	_, results := chain.Engine().VerifyHeaders(chain, headers, make([]bool, len(headers)))
+	for i := range headers {
+		err := <-results
+		if i < len(headers)-1 && err != nil {
+			t.Errorf("header %d: verification failed: %v", i, err)
+		}
+		if i == len(headers)-1 && err == nil {
+			t.Errorf("proof-of-work header after terminal block accepted in batch")
+		}
+	}
+	if _, err := chain.InsertChain(blocks[5:]); err != nil {
+		t.Fatalf("failed to insert pre-merge chain: %v", err)
+	}
+	if err := chain.Engine().VerifyHeader(chain, header, true); err == nil {
+		t.Fatalf("proof-of-work header after terminal block accepted")
+	}
+}
+
+// Tests that batches spanning the transition are verified by both engines.
+func TestVerifyHeadersTransition(t *testing.T) {
+	chain, blocks := newTestChain(t, 10, 5)
+	defer chain.Stop()
+
+	var (
+		config  = chain.Config()
+		headers []*types.Header
+	)
+	for _, block := range blocks[5:] {
+		headers = append(headers, block.Header())
+	}
+	for i := 0; i < 3; i++ {
+		headers = append(headers, makePoSHeader(config, headers[len(headers)-1]))
+	}
+	check := func(headers []*types.Header, fail int) {
+		t.Helper()
+
+		_, results := chain.Engine().VerifyHeaders(chain, headers, make([]bool, len(headers)))
+		for i := range headers {
+			err := <-results
+			if i < fail && err != nil {
+				t.Errorf("header %d: verification failed: %v", i, err)
+			}
+			if i >= fail && err == nil {
+				t.Errorf("header %d: verification succeeded, want failure", i)
+			}
+		}
+	}
+	check(headers, len(headers))
+
+	// Transitioning one block early must fail all proof-of-stake headers
+	early := append([]*types.Header{}, headers[:4]...)
+	early = append(early, makePoSHeader(config, early[3]))
+	check(early, 4)
+}
+
+// Tests that once the terminal total difficulty is reached, blocks imported via
+// InsertChain (e.g. propagated over the network) can't change the head, only the
+// consensus layer can via SetChainHead.
+func TestPoSBlocksDontMoveHead(t *testing.T) {
+	chain, blocks := newTestChain(t, 10, 10)
+	defer chain.Stop()
+
+	var (
+		config   = chain.Config()
+		terminal = blocks[len(blocks)-1]
+	)
+	makeBlock := func(parent *types.Header, time uint64) *types.Block {
+		header := makePoSHeader(config, parent)
+		header.Time += time
+		return types.NewBlock(header, nil, nil, nil, trie.NewStackTrie(nil))
+	}
+	if !chain.TTDReached() {
+		t.Fatalf("terminal total difficulty not reached")
+	}
+	// Import a proof-of-stake chain as if propagated, the head must stay put
+	a := makeBlock(terminal.Header(), 0)
+	b := makeBlock(a.Header(), 0)
+	if _, err := chain.InsertChain(types.Blocks{a, b}); err != nil {
+		t.Fatalf("failed to insert proof-of-stake blocks: %v", err)
+	}
+	if head := chain.CurrentBlock(); head.Hash() != terminal.Hash() {
+		t.Fatalf("head moved by propagated blocks: have %d, want %d", head.NumberU64(), terminal.NumberU64())
+	}
+	// Let the consensus layer set the head
+	if err := chain.SetChainHead(b); err != nil {
+		t.Fatalf("failed to set chain head: %v", err)
+	}
+	if head := chain.CurrentBlock(); head.Hash() != b.Hash() {
+		t.Fatalf("head mismatch: have %d, want %d", head.NumberU64(), b.NumberU64())
+	}
+	// A competing block below the head, having the same total difficulty, must
+	// not reorg the chain
+	c := makeBlock(terminal.Header(), 1)
+	if _, err := chain.InsertChain(types.Blocks{c}); err != nil {
+		t.Fatalf("failed to insert competing block: %v", err)
+	}
+	if head := chain.CurrentBlock(); head.Hash() != b.Hash() {
+		t.Fatalf("head reorged by propagated block: have %x, want %x", head.Hash(), b.Hash())
+	}
+	if hash := chain.GetCanonicalHash(a.NumberU64()); hash != a.Hash() {
+		t.Fatalf("canonical block mismatch: have %x, want %x", hash, a.Hash())
+	}
+}
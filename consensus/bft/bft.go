@@ -0,0 +1,883 @@
+// Copyright 2021 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+// Package bft implements a byzantine fault tolerant proof-of-authority consensus
+// engine with instant finality.
+//
+// Blocks are agreed on in rounds by a set of validators: the proposer of the
+// round broadcasts a block, the validators prevote for it and, once a quorum of
+// 2f+1 prevotes is seen, precommit to it with a committed seal. A block gathering
+// 2f+1 committed seals is final. If a round doesn't finish in time, the
+// validators move on to the next one with a different proposer.
+package bft
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math/big"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/accounts"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/consensus"
+	"github.com/ethereum/go-ethereum/consensus/misc"
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/core/state"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/ethereum/go-ethereum/event"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/params"
+	"github.com/ethereum/go-ethereum/rlp"
+	"github.com/ethereum/go-ethereum/rpc"
+	"github.com/ethereum/go-ethereum/trie"
+	lru "github.com/hashicorp/golang-lru"
+)
+
+const (
+	checkpointInterval = 1024 // Number of blocks after which to save the vote snapshot to the database
+	inmemorySnapshots  = 128  // Number of recent vote snapshots to keep in memory
+	inmemorySignatures = 4096 // Number of recent block signatures to keep in memory
+	inmemoryMessages   = 4096 // Number of recent consensus messages to track for deduplication
+
+	allowedFutureProposalTime = time.Second // Max time from current time allowed for proposals, to tolerate clock drift
+)
+
+// BFT proof-of-authority protocol constants.
+var (
+	epochLength    = uint64(30000) // Default number of blocks after which to checkpoint and reset the pending votes
+	blockPeriod    = uint64(1)     // Default minimum difference between two consecutive block's timestamps
+	requestTimeout = uint64(10000) // Default milliseconds to wait for the first round of a height to finish
+
+	nonceAuthVote = hexutil.MustDecode("0xffffffffffffffff") // Magic nonce number to vote on adding a new validator
+	nonceDropVote = hexutil.MustDecode("0x0000000000000000") // Magic nonce number to vote on removing a validator.
+
+	uncleHash = types.CalcUncleHash(nil) // Always Keccak256(RLP([])) as uncles are meaningless outside of PoW.
+
+	defaultDifficulty = big.NewInt(1) // Block difficulty of every block, forks can't happen with instant finality
+)
+
+// Various error messages to mark blocks invalid. These should be private to
+// prevent engine specific errors from being referenced in the remainder of the
+// codebase, inherently breaking if the engine is swapped out. Please put common
+// error types into the consensus package.
+var (
+	// errUnknownBlock is returned when the list of validators is requested for a
+	// block that is not part of the local blockchain.
+	errUnknownBlock = errors.New("unknown block")
+
+	// errInvalidCheckpointBeneficiary is returned if a checkpoint/epoch transition
+	// block has a beneficiary set to non-zeroes.
+	errInvalidCheckpointBeneficiary = errors.New("beneficiary in checkpoint block non-zero")
+
+	// errInvalidVote is returned if a nonce value is something else that the two
+	// allowed constants of 0x00..0 or 0xff..f.
+	errInvalidVote = errors.New("vote nonce not 0x00..0 or 0xff..f")
+
+	// errInvalidCheckpointVote is returned if a checkpoint/epoch transition block
+	// has a vote nonce set to non-zeroes.
+	errInvalidCheckpointVote = errors.New("vote nonce in checkpoint block non-zero")
+
+	// errInvalidExtraData is returned if a block's extra-data section can't be
+	// decoded into the vanity and the BFT consensus fields.
+	errInvalidExtraData = errors.New("invalid extra-data")
+
+	// errExtraValidators is returned if non-checkpoint block contain validator
+	// data in their extra-data fields.
+	errExtraValidators = errors.New("non-checkpoint block contains extra validator list")
+
+	// errMismatchingCheckpointValidators is returned if a checkpoint block contains
+	// a list of validators different than the one the local node calculated.
+	errMismatchingCheckpointValidators = errors.New("mismatching validator list on checkpoint block")
+
+	// errInvalidMixDigest is returned if a block's mix digest is not the BFT digest.
+	errInvalidMixDigest = errors.New("invalid mix digest")
+
+	// errInvalidUncleHash is returned if a block contains an non-empty uncle list.
+	errInvalidUncleHash = errors.New("non empty uncle hash")
+
+	// errInvalidDifficulty is returned if the difficulty of a block is not 1.
+	errInvalidDifficulty = errors.New("invalid difficulty")
+
+	// errInvalidTimestamp is returned if the timestamp of a block is lower than
+	// the previous block's timestamp + the minimum block period.
+	errInvalidTimestamp = errors.New("invalid timestamp")
+
+	// errInvalidVotingChain is returned if an authorization list is attempted to
+	// be modified via out-of-range or non-contiguous headers.
+	errInvalidVotingChain = errors.New("invalid voting chain")
+
+	// errUnauthorizedProposer is returned if a header is sealed by a non-validator.
+	errUnauthorizedProposer = errors.New("unauthorized proposer")
+
+	// errInvalidCommittedSeals is returned if a committed seal is not signed by
+	// a validator or the same validator committed more than once.
+	errInvalidCommittedSeals = errors.New("invalid committed seals")
+
+	// errInsufficientCommittedSeals is returned if a block is committed by less
+	// validators than required for the quorum.
+	errInsufficientCommittedSeals = errors.New("insufficient committed seals")
+
+	// errInvalidMessage is returned if a consensus message is malformed.
+	errInvalidMessage = errors.New("invalid consensus message")
+
+	// errNotStarted is returned if a block is attempted to be sealed before the
+	// consensus core was started.
+	errNotStarted = errors.New("consensus engine not started")
+)
+
+// SignerFn hashes and signs the data to be signed by a backing account.
+type SignerFn func(signer accounts.Account, mimeType string, message []byte) ([]byte, error)
+
+// chainContext is the part of the blockchain the consensus core needs to follow
+// the chain head, to execute the proposed blocks and to import the blocks the
+// validators agreed on.
+type chainContext interface {
+	consensus.ChainHeaderReader
+
+	// CurrentBlock retrieves the current head block of the canonical chain.
+	CurrentBlock() *types.Block
+
+	// StateAt returns a mutable state based on a particular point in time.
+	StateAt(root common.Hash) (*state.StateDB, error)
+
+	// Validator returns the validator checking the blocks and their states.
+	Validator() core.Validator
+
+	// Processor returns the processor executing the blocks.
+	Processor() core.Processor
+
+	// GetVMConfig returns the configuration the blocks are executed with.
+	GetVMConfig() *vm.Config
+
+	// InsertChain imports a batch of blocks into the local chain.
+	InsertChain(chain types.Blocks) (int, error)
+
+	// SubscribeChainHeadEvent registers a subscription of ChainHeadEvent.
+	SubscribeChainHeadEvent(ch chan<- core.ChainHeadEvent) event.Subscription
+}
+
+// sealRequest is a block the local miner asked to be sealed, waiting for the
+// local validator to be the proposer.
+type sealRequest struct {
+	block   *types.Block
+	results chan<- *types.Block
+	stop    <-chan struct{}
+}
+
+// ecrecover extracts the Ethereum account address from a signed header.
+func ecrecover(header *types.Header, sigcache *lru.ARCCache) (common.Address, error) {
+	// If the signature's already cached, return that
+	hash := header.Hash()
+	if address, known := sigcache.Get(hash); known {
+		return address.(common.Address), nil
+	}
+	// Retrieve the signature from the header extra-data
+	extra, err := types.ExtractBFTExtra(header)
+	if err != nil {
+		return common.Address{}, errInvalidExtraData
+	}
+	// Recover the public key and the Ethereum address
+	pubkey, err := crypto.Ecrecover(SealHash(header).Bytes(), extra.Seal)
+	if err != nil {
+		return common.Address{}, err
+	}
+	var proposer common.Address
+	copy(proposer[:], crypto.Keccak256(pubkey[1:])[12:])
+
+	sigcache.Add(hash, proposer)
+	return proposer, nil
+}
+
+// BFT is the byzantine fault tolerant proof-of-authority consensus engine.
+type BFT struct {
+	config *params.BFTConfig // Consensus engine configuration parameters
+	db     ethdb.Database    // Database to store and retrieve snapshot checkpoints
+
+	recents    *lru.ARCCache // Snapshots for recent block to speed up reorgs
+	signatures *lru.ARCCache // Signatures of recent blocks to speed up mining
+	messages   *lru.Cache    // Hashes of recent consensus messages to avoid relaying them again
+
+	proposals map[common.Address]bool // Current list of proposals we are pushing
+
+	signer common.Address // Ethereum address of the signing key
+	signFn SignerFn       // Signer function to authorize hashes with
+	lock   sync.RWMutex   // Protects the signer and core fields
+
+	chain     chainContext      // Blockchain the consensus core is running on
+	peers     *peerSet          // Peers connected via the consensus sub-protocol
+	messageCh chan *message     // Channel to feed received messages to the core
+	requestCh chan *sealRequest // Channel to feed seal requests to the core
+	failedCh  chan *types.Block // Channel to report committed blocks failing to import
+	quit      chan struct{}     // Quit channel to stop the consensus core
+	wg        sync.WaitGroup    // Wait group to track the consensus core
+}
+
+// New creates a BFT proof-of-authority consensus engine with the initial
+// validators set to the ones provided in the genesis extra-data.
+func New(config *params.BFTConfig, db ethdb.Database) *BFT {
+	// Set any missing consensus parameters to their defaults
+	conf := *config
+	if conf.Epoch == 0 {
+		conf.Epoch = epochLength
+	}
+	if conf.Period == 0 {
+		conf.Period = blockPeriod
+	}
+	if conf.RequestTimeout == 0 {
+		conf.RequestTimeout = requestTimeout
+	}
+	// Allocate the snapshot caches and create the engine
+	recents, _ := lru.NewARC(inmemorySnapshots)
+	signatures, _ := lru.NewARC(inmemorySignatures)
+	messages, _ := lru.New(inmemoryMessages)
+
+	return &BFT{
+		config:     &conf,
+		db:         db,
+		recents:    recents,
+		signatures: signatures,
+		messages:   messages,
+		proposals:  make(map[common.Address]bool),
+		peers:      newPeerSet(),
+		messageCh:  make(chan *message, 256),
+		requestCh:  make(chan *sealRequest),
+		failedCh:   make(chan *types.Block),
+	}
+}
+
+// Author implements consensus.Engine, returning the Ethereum address recovered
+// from the proposer seal in the header's extra-data section.
+func (b *BFT) Author(header *types.Header) (common.Address, error) {
+	return ecrecover(header, b.signatures)
+}
+
+// VerifyHeader checks whether a header conforms to the consensus rules.
+func (b *BFT) VerifyHeader(chain consensus.ChainHeaderReader, header *types.Header, seal bool) error {
+	return b.verifyHeader(chain, header, nil, false)
+}
+
+// VerifyHeaders is similar to VerifyHeader, but verifies a batch of headers. The
+// method returns a quit channel to abort the operations and a results channel to
+// retrieve the async verifications (the order is that of the input slice).
+func (b *BFT) VerifyHeaders(chain consensus.ChainHeaderReader, headers []*types.Header, seals []bool) (chan<- struct{}, <-chan error) {
+	abort := make(chan struct{})
+	results := make(chan error, len(headers))
+
+	go func() {
+		for i, header := range headers {
+			err := b.verifyHeader(chain, header, headers[:i], false)
+
+			select {
+			case <-abort:
+				return
+			case results <- err:
+			}
+		}
+	}()
+	return abort, results
+}
+
+// verifyHeader checks whether a header conforms to the consensus rules. The
+// caller may optionally pass in a batch of parents (ascending order) to avoid
+// looking those up from the database. This is useful for concurrently verifying
+// a batch of new headers.
+//
+// Proposals are blocks still being agreed on, so they are verified without the
+// committed seals and with some leeway for the clock drift between validators.
+func (b *BFT) verifyHeader(chain consensus.ChainHeaderReader, header *types.Header, parents []*types.Header, proposal bool) error {
+	if header.Number == nil {
+		return errUnknownBlock
+	}
+	number := header.Number.Uint64()
+
+	// Don't waste time checking blocks from the future
+	limit := uint64(time.Now().Unix())
+	if proposal {
+		limit = uint64(time.Now().Add(allowedFutureProposalTime).Unix())
+	}
+	if header.Time > limit {
+		return consensus.ErrFutureBlock
+	}
+	// Ensure that the extra-data contains the vanity and the consensus fields
+	extra, err := types.ExtractBFTExtra(header)
+	if err != nil {
+		return errInvalidExtraData
+	}
+	// Checkpoint blocks need to enforce zero beneficiary
+	checkpoint := (number % b.config.Epoch) == 0
+	if checkpoint && header.Coinbase != (common.Address{}) {
+		return errInvalidCheckpointBeneficiary
+	}
+	// Nonces must be 0x00..0 or 0xff..f, zeroes enforced on checkpoints
+	if !bytes.Equal(header.Nonce[:], nonceAuthVote) && !bytes.Equal(header.Nonce[:], nonceDropVote) {
+		return errInvalidVote
+	}
+	if checkpoint && !bytes.Equal(header.Nonce[:], nonceDropVote) {
+		return errInvalidCheckpointVote
+	}
+	// Ensure that the extra-data contains a validator list on checkpoint, but none otherwise
+	if !checkpoint && len(extra.Validators) != 0 {
+		return errExtraValidators
+	}
+	// Ensure that the mix digest marks the block as sealed by BFT
+	if header.MixDigest != types.BFTDigest {
+		return errInvalidMixDigest
+	}
+	// Ensure that the block doesn't contain any uncles which are meaningless in PoA
+	if header.UncleHash != uncleHash {
+		return errInvalidUncleHash
+	}
+	// Ensure that the block's difficulty is meaningful
+	if number > 0 {
+		if header.Difficulty == nil || header.Difficulty.Cmp(defaultDifficulty) != 0 {
+			return errInvalidDifficulty
+		}
+	}
+	// Verify that the gas limit is <= 2^63-1
+	cap := uint64(0x7fffffffffffffff)
+	if header.GasLimit > cap {
+		return fmt.Errorf("invalid gasLimit: have %v, max %v", header.GasLimit, cap)
+	}
+	// If all checks passed, validate any special fields for hard forks
+	if err := misc.VerifyForkHashes(chain.Config(), header, false); err != nil {
+		return err
+	}
+	// All basic checks passed, verify cascading fields
+	return b.verifyCascadingFields(chain, header, parents, extra, proposal)
+}
+
+// verifyCascadingFields verifies all the header fields that are not standalone,
+// rather depend on a batch of previous headers. The caller may optionally pass
+// in a batch of parents (ascending order) to avoid looking those up from the
+// database. This is useful for concurrently verifying a batch of new headers.
+func (b *BFT) verifyCascadingFields(chain consensus.ChainHeaderReader, header *types.Header, parents []*types.Header, extra *types.BFTExtra, proposal bool) error {
+	// The genesis block is the always valid dead-end
+	number := header.Number.Uint64()
+	if number == 0 {
+		return nil
+	}
+	// Ensure that the block's timestamp isn't too close to its parent
+	var parent *types.Header
+	if len(parents) > 0 {
+		parent = parents[len(parents)-1]
+	} else {
+		parent = chain.GetHeader(header.ParentHash, number-1)
+	}
+	if parent == nil || parent.Number.Uint64() != number-1 || parent.Hash() != header.ParentHash {
+		return consensus.ErrUnknownAncestor
+	}
+	if parent.Time+b.config.Period > header.Time {
+		return errInvalidTimestamp
+	}
+	// Verify that the gasUsed is <= gasLimit
+	if header.GasUsed > header.GasLimit {
+		return fmt.Errorf("invalid gasUsed: have %d, gasLimit %d", header.GasUsed, header.GasLimit)
+	}
+	if !chain.Config().IsLondon(header.Number) {
+		// Verify BaseFee not present before EIP-1559 fork.
+		if header.BaseFee != nil {
+			return fmt.Errorf("invalid baseFee before fork: have %d, want <nil>", header.BaseFee)
+		}
+		if err := misc.VerifyGaslimit(parent.GasLimit, header.GasLimit); err != nil {
+			return err
+		}
+	} else if err := misc.VerifyEip1559Header(chain.Config(), parent, header); err != nil {
+		// Verify the header's EIP-1559 attributes.
+		return err
+	}
+	// Retrieve the snapshot needed to verify this header and cache it
+	snap, err := b.snapshot(chain, number-1, header.ParentHash, parents)
+	if err != nil {
+		return err
+	}
+	// If the block is a checkpoint block, verify the validator list
+	if number%b.config.Epoch == 0 {
+		validators := snap.validators()
+		if len(extra.Validators) != len(validators) {
+			return errMismatchingCheckpointValidators
+		}
+		for i, validator := range validators {
+			if extra.Validators[i] != validator {
+				return errMismatchingCheckpointValidators
+			}
+		}
+	}
+	// All basic checks passed, verify the seals and return
+	if err := b.verifySeal(snap, header); err != nil {
+		return err
+	}
+	if proposal {
+		return nil
+	}
+	return b.verifyCommittedSeals(snap, header, extra)
+}
+
+// snapshot retrieves the authorization snapshot at a given point in time.
+func (b *BFT) snapshot(chain consensus.ChainHeaderReader, number uint64, hash common.Hash, parents []*types.Header) (*Snapshot, error) {
+	// Search for a snapshot in memory or on disk for checkpoints
+	var (
+		headers []*types.Header
+		snap    *Snapshot
+	)
+	for snap == nil {
+		// If an in-memory snapshot was found, use that
+		if s, ok := b.recents.Get(hash); ok {
+			snap = s.(*Snapshot)
+			break
+		}
+		// If an on-disk checkpoint snapshot can be found, use that
+		if number%checkpointInterval == 0 {
+			if s, err := loadSnapshot(b.config, b.signatures, b.db, hash); err == nil {
+				log.Trace("Loaded voting snapshot from disk", "number", number, "hash", hash)
+				snap = s
+				break
+			}
+		}
+		// If we're at the genesis, snapshot the initial state. Alternatively if we're
+		// at a checkpoint block without a parent (light client CHT), or we have piled
+		// up more headers than allowed to be reorged (chain reinit from a freezer),
+		// consider the checkpoint trusted and snapshot it.
+		if number == 0 || (number%b.config.Epoch == 0 && (len(headers) > params.FullImmutabilityThreshold || chain.GetHeaderByNumber(number-1) == nil)) {
+			checkpoint := chain.GetHeaderByNumber(number)
+			if checkpoint != nil {
+				hash := checkpoint.Hash()
+
+				extra, err := types.ExtractBFTExtra(checkpoint)
+				if err != nil {
+					return nil, errInvalidExtraData
+				}
+				snap = newSnapshot(b.config, b.signatures, number, hash, extra.Validators)
+				if err := snap.store(b.db); err != nil {
+					return nil, err
+				}
+				log.Info("Stored checkpoint snapshot to disk", "number", number, "hash", hash)
+				break
+			}
+		}
+		// No snapshot for this header, gather the header and move backward
+		var header *types.Header
+		if len(parents) > 0 {
+			// If we have explicit parents, pick from there (enforced)
+			header = parents[len(parents)-1]
+			if header.Hash() != hash || header.Number.Uint64() != number {
+				return nil, consensus.ErrUnknownAncestor
+			}
+			parents = parents[:len(parents)-1]
+		} else {
+			// No explicit parents (or no more left), reach out to the database
+			header = chain.GetHeader(hash, number)
+			if header == nil {
+				return nil, consensus.ErrUnknownAncestor
+			}
+		}
+		headers = append(headers, header)
+		number, hash = number-1, header.ParentHash
+	}
+	// Previous snapshot found, apply any pending headers on top of it
+	for i := 0; i < len(headers)/2; i++ {
+		headers[i], headers[len(headers)-1-i] = headers[len(headers)-1-i], headers[i]
+	}
+	snap, err := snap.apply(headers)
+	if err != nil {
+		return nil, err
+	}
+	b.recents.Add(snap.Hash, snap)
+
+	// If we've generated a new checkpoint snapshot, save to disk
+	if snap.Number%checkpointInterval == 0 && len(headers) > 0 {
+		if err = snap.store(b.db); err != nil {
+			return nil, err
+		}
+		log.Trace("Stored voting snapshot to disk", "number", snap.Number, "hash", snap.Hash)
+	}
+	return snap, err
+}
+
+// VerifyUncles implements consensus.Engine, always returning an error for any
+// uncles as this consensus mechanism doesn't permit uncles.
+func (b *BFT) VerifyUncles(chain consensus.ChainReader, block *types.Block) error {
+	if len(block.Uncles()) > 0 {
+		return errors.New("uncles not allowed")
+	}
+	return nil
+}
+
+// verifySeal checks whether the proposer seal contained in the header was
+// signed by one of the validators.
+func (b *BFT) verifySeal(snap *Snapshot, header *types.Header) error {
+	// Verifying the genesis block is not supported
+	number := header.Number.Uint64()
+	if number == 0 {
+		return errUnknownBlock
+	}
+	// Resolve the authorization key and check against validators
+	proposer, err := ecrecover(header, b.signatures)
+	if err != nil {
+		return err
+	}
+	if _, ok := snap.Validators[proposer]; !ok {
+		return errUnauthorizedProposer
+	}
+	return nil
+}
+
+// verifyCommittedSeals checks whether the block was committed by a quorum of
+// distinct validators.
+func (b *BFT) verifyCommittedSeals(snap *Snapshot, header *types.Header, extra *types.BFTExtra) error {
+	var (
+		hash      = proposalHash(header)
+		committed = make(map[common.Address]struct{})
+	)
+	for _, seal := range extra.CommittedSeal {
+		validator, err := recoverCommitter(hash, seal)
+		if err != nil {
+			return errInvalidCommittedSeals
+		}
+		if _, ok := snap.Validators[validator]; !ok {
+			return errInvalidCommittedSeals
+		}
+		if _, ok := committed[validator]; ok {
+			return errInvalidCommittedSeals
+		}
+		committed[validator] = struct{}{}
+	}
+	if len(committed) == 0 || len(committed) < snap.quorum() {
+		return errInsufficientCommittedSeals
+	}
+	return nil
+}
+
+// verifyProposal checks whether a proposed block can be voted on, i.e. that its
+// header is valid apart from the missing committed seals, that its body matches
+// the header and that executing it on top of the parent yields the state and the
+// receipts the header commits to.
+func (b *BFT) verifyProposal(chain chainContext, block *types.Block, parent *types.Header) error {
+	if err := b.verifyHeader(chain, block.Header(), []*types.Header{parent}, true); err != nil {
+		return err
+	}
+	if err := chain.Validator().ValidateBody(block); err != nil {
+		return err
+	}
+	statedb, err := chain.StateAt(parent.Root)
+	if err != nil {
+		return err
+	}
+	receipts, _, usedGas, err := chain.Processor().Process(block, statedb, *chain.GetVMConfig())
+	if err != nil {
+		return err
+	}
+	return chain.Validator().ValidateState(block, statedb, receipts, usedGas)
+}
+
+// Prepare implements consensus.Engine, preparing all the consensus fields of the
+// header for running the transactions on top.
+func (b *BFT) Prepare(chain consensus.ChainHeaderReader, header *types.Header) error {
+	// If the block isn't a checkpoint, cast a random vote (good enough for now)
+	header.Coinbase = common.Address{}
+	header.Nonce = types.BlockNonce{}
+
+	number := header.Number.Uint64()
+	// Assemble the voting snapshot to check which votes make sense
+	snap, err := b.snapshot(chain, number-1, header.ParentHash, nil)
+	if err != nil {
+		return err
+	}
+	if number%b.config.Epoch != 0 {
+		b.lock.RLock()
+
+		// Gather all the proposals that make sense voting on
+		addresses := make([]common.Address, 0, len(b.proposals))
+		for address, authorize := range b.proposals {
+			if snap.validVote(address, authorize) {
+				addresses = append(addresses, address)
+			}
+		}
+		// If there's pending proposals, cast a vote on them
+		if len(addresses) > 0 {
+			header.Coinbase = addresses[rand.Intn(len(addresses))]
+			if b.proposals[header.Coinbase] {
+				copy(header.Nonce[:], nonceAuthVote)
+			} else {
+				copy(header.Nonce[:], nonceDropVote)
+			}
+		}
+		b.lock.RUnlock()
+	}
+	// Set the correct difficulty
+	header.Difficulty = new(big.Int).Set(defaultDifficulty)
+
+	// Ensure the extra data has all its components
+	if len(header.Extra) < types.BFTExtraVanity {
+		header.Extra = append(header.Extra, bytes.Repeat([]byte{0x00}, types.BFTExtraVanity-len(header.Extra))...)
+	}
+	extra := new(types.BFTExtra)
+	if number%b.config.Epoch == 0 {
+		extra.Validators = snap.validators()
+	}
+	if err := writeExtra(header, extra); err != nil {
+		return err
+	}
+	// Mix digest marks the block as sealed by BFT
+	header.MixDigest = types.BFTDigest
+
+	// Ensure the timestamp has the correct delay
+	parent := chain.GetHeader(header.ParentHash, number-1)
+	if parent == nil {
+		return consensus.ErrUnknownAncestor
+	}
+	header.Time = parent.Time + b.config.Period
+	if header.Time < uint64(time.Now().Unix()) {
+		header.Time = uint64(time.Now().Unix())
+	}
+	return nil
+}
+
+// Finalize implements consensus.Engine, ensuring no uncles are set, nor block
+// rewards given.
+func (b *BFT) Finalize(chain consensus.ChainHeaderReader, header *types.Header, state *state.StateDB, txs []*types.Transaction, uncles []*types.Header) {
+	// No block rewards in PoA, so the state remains as is and uncles are dropped
+	header.Root = state.IntermediateRoot(chain.Config().IsEIP158(header.Number))
+	header.UncleHash = types.CalcUncleHash(nil)
+}
+
+// FinalizeAndAssemble implements consensus.Engine, ensuring no uncles are set,
+// nor block rewards given, and returns the final block.
+func (b *BFT) FinalizeAndAssemble(chain consensus.ChainHeaderReader, header *types.Header, state *state.StateDB, txs []*types.Transaction, uncles []*types.Header, receipts []*types.Receipt) (*types.Block, error) {
+	// Finalize block
+	b.Finalize(chain, header, state, txs, uncles)
+
+	// Assemble and return the final block for sealing
+	return types.NewBlock(header, txs, nil, receipts, trie.NewStackTrie(nil)), nil
+}
+
+// Authorize injects a private key into the consensus engine to propose and
+// vote on new blocks with.
+func (b *BFT) Authorize(signer common.Address, signFn SignerFn) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	b.signer = signer
+	b.signFn = signFn
+}
+
+// Seal implements consensus.Engine, signing the block with the local proposer
+// key and handing it over to the consensus core. The block is only proposed
+// once it's the local validator's turn, and delivered on the results channel if
+// the validators commit to it.
+func (b *BFT) Seal(chain consensus.ChainHeaderReader, block *types.Block, results chan<- *types.Block, stop <-chan struct{}) error {
+	header := block.Header()
+
+	// Sealing the genesis block is not supported
+	number := header.Number.Uint64()
+	if number == 0 {
+		return errUnknownBlock
+	}
+	// Don't hold the signer fields for the entire sealing procedure
+	b.lock.RLock()
+	signer, signFn, quit := b.signer, b.signFn, b.quit
+	b.lock.RUnlock()
+
+	if quit == nil {
+		return errNotStarted
+	}
+	// Bail out if we're unauthorized to propose a block
+	snap, err := b.snapshot(chain, number-1, header.ParentHash, nil)
+	if err != nil {
+		return err
+	}
+	if _, authorized := snap.Validators[signer]; !authorized {
+		return errUnauthorizedProposer
+	}
+	// Sign all the things!
+	sighash, err := signFn(accounts.Account{Address: signer}, accounts.MimetypeBFT, BFTRLP(header))
+	if err != nil {
+		return err
+	}
+	extra, err := types.ExtractBFTExtra(header)
+	if err != nil {
+		return errInvalidExtraData
+	}
+	extra.Seal = sighash
+	if err := writeExtra(header, extra); err != nil {
+		return err
+	}
+	// Wait until the block's slot arrives and hand it to the core
+	delay := time.Unix(int64(header.Time), 0).Sub(time.Now()) // nolint: gosimple
+
+	log.Trace("Waiting for slot to propose", "delay", common.PrettyDuration(delay))
+	go func() {
+		select {
+		case <-stop:
+			return
+		case <-quit:
+			return
+		case <-time.After(delay):
+		}
+		req := &sealRequest{block: block.WithSeal(header), results: results, stop: stop}
+		select {
+		case b.requestCh <- req:
+		case <-stop:
+		case <-quit:
+		}
+	}()
+	return nil
+}
+
+// deliver hands a committed block over to the miner if it's the one the local
+// node asked to seal, or imports it into the local chain otherwise. If the import
+// fails, the block is reported back to the consensus core.
+func (b *BFT) deliver(block *types.Block, req *sealRequest) {
+	if req != nil && SealHash(req.block.Header()) == SealHash(block.Header()) {
+		select {
+		case <-req.stop:
+		default:
+			select {
+			case req.results <- block:
+				return
+			default:
+				log.Warn("Sealing result is not read by miner", "sealhash", SealHash(block.Header()))
+			}
+		}
+	}
+	b.wg.Add(1)
+	go func() {
+		defer b.wg.Done()
+
+		if _, err := b.chain.InsertChain(types.Blocks{block}); err != nil {
+			log.Warn("Failed to import committed block", "number", block.Number(), "hash", block.Hash(), "err", err)
+
+			select {
+			case b.failedCh <- block:
+			case <-b.quit:
+			}
+		}
+	}()
+}
+
+// CalcDifficulty is the difficulty adjustment algorithm. It always returns 1 as
+// blocks are final once committed, so there's no fork choice to make.
+func (b *BFT) CalcDifficulty(chain consensus.ChainHeaderReader, time uint64, parent *types.Header) *big.Int {
+	return new(big.Int).Set(defaultDifficulty)
+}
+
+// SealHash returns the hash of a block prior to it being sealed.
+func (b *BFT) SealHash(header *types.Header) common.Hash {
+	return SealHash(header)
+}
+
+// Start launches the consensus core on top of the given chain, taking part in
+// the rounds if the local node is authorized as one of the validators.
+func (b *BFT) Start(chain chainContext) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	if b.quit != nil {
+		return
+	}
+	b.chain = chain
+	b.quit = make(chan struct{})
+
+	c := newEngineCore(b, chain)
+	b.wg.Add(1)
+	go func() {
+		defer b.wg.Done()
+		c.loop()
+	}()
+}
+
+// Close implements consensus.Engine, terminating the consensus core.
+func (b *BFT) Close() error {
+	b.lock.Lock()
+	if b.quit == nil {
+		b.lock.Unlock()
+		return nil
+	}
+	select {
+	case <-b.quit:
+	default:
+		close(b.quit)
+	}
+	b.lock.Unlock()
+
+	b.wg.Wait()
+	return nil
+}
+
+// APIs implements consensus.Engine, returning the user facing RPC API to allow
+// controlling the validator voting.
+func (b *BFT) APIs(chain consensus.ChainHeaderReader) []rpc.API {
+	return []rpc.API{{
+		Namespace: "bft",
+		Version:   "1.0",
+		Service:   &API{chain: chain, bft: b},
+		Public:    false,
+	}}
+}
+
+// authorized returns the local signing credentials.
+func (b *BFT) authorized() (common.Address, SignerFn) {
+	b.lock.RLock()
+	defer b.lock.RUnlock()
+
+	return b.signer, b.signFn
+}
+
+// SealHash returns the hash of a block prior to it being sealed.
+func SealHash(header *types.Header) common.Hash {
+	return crypto.Keccak256Hash(BFTRLP(header))
+}
+
+// proposalHash returns the hash of a block the validators vote on and commit to,
+// i.e. the hash of its header apart from the committed seals. Those are only
+// gathered once the block is agreed on, and may differ between the validators.
+func proposalHash(header *types.Header) common.Hash {
+	if filtered := types.BFTFilteredHeader(header, true); filtered != nil {
+		return filtered.Hash()
+	}
+	return header.Hash()
+}
+
+// BFTRLP returns the rlp bytes which need to be signed by the proposer. The RLP
+// to sign consists of the entire header apart from the proposer seal and the
+// committed seals contained in the extra-data.
+func BFTRLP(header *types.Header) []byte {
+	if filtered := types.BFTFilteredHeader(header, false); filtered != nil {
+		header = filtered
+	}
+	blob, err := rlp.EncodeToBytes(header)
+	if err != nil {
+		panic("can't encode: " + err.Error())
+	}
+	return blob
+}
+
+// writeExtra replaces the consensus fields in the extra-data of the header,
+// keeping the vanity prefix.
+func writeExtra(header *types.Header, extra *types.BFTExtra) error {
+	payload, err := rlp.EncodeToBytes(extra)
+	if err != nil {
+		return err
+	}
+	header.Extra = append(header.Extra[:types.BFTExtraVanity:types.BFTExtraVanity], payload...)
+	return nil
+}
@@ -0,0 +1,412 @@
+// Copyright 2021 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package bft
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"math/big"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/accounts"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/consensus/misc"
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/p2p"
+	"github.com/ethereum/go-ethereum/p2p/enode"
+	"github.com/ethereum/go-ethereum/params"
+	"github.com/ethereum/go-ethereum/rlp"
+	lru "github.com/hashicorp/golang-lru"
+)
+
+// testValidator is a validator node of a local test network.
+type testValidator struct {
+	key    *ecdsa.PrivateKey
+	addr   common.Address
+	engine *BFT
+	chain  *core.BlockChain
+}
+
+// newTestKeys generates n validator keys sorted by address, so that the proposer
+// of each height and round is predictable.
+func newTestKeys(n int) []*ecdsa.PrivateKey {
+	keys := make([]*ecdsa.PrivateKey, n)
+	for i := range keys {
+		keys[i], _ = crypto.GenerateKey()
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return bytes.Compare(crypto.PubkeyToAddress(keys[i].PublicKey).Bytes(), crypto.PubkeyToAddress(keys[j].PublicKey).Bytes()) < 0
+	})
+	return keys
+}
+
+// newTestGenesis creates a BFT genesis block with the given validators.
+func newTestGenesis(keys []*ecdsa.PrivateKey) *core.Genesis {
+	config := *params.AllCliqueProtocolChanges
+	config.Clique = nil
+	config.BFT = &params.BFTConfig{Period: 1, Epoch: 30000, RequestTimeout: 250}
+
+	extra := &types.BFTExtra{}
+	for _, key := range keys {
+		extra.Validators = append(extra.Validators, crypto.PubkeyToAddress(key.PublicKey))
+	}
+	payload, _ := rlp.EncodeToBytes(extra)
+
+	return &core.Genesis{
+		Config:    &config,
+		ExtraData: append(make([]byte, types.BFTExtraVanity), payload...),
+		GasLimit:  params.GenesisGasLimit,
+		BaseFee:   big.NewInt(params.InitialBaseFee),
+		Mixhash:   types.BFTDigest,
+	}
+}
+
+// newTestValidator creates a validator node with its own chain, without running
+// the consensus core yet.
+func newTestValidator(t *testing.T, genesis *core.Genesis, key *ecdsa.PrivateKey) *testValidator {
+	db := rawdb.NewMemoryDatabase()
+	genesis.MustCommit(db)
+
+	engine := New(genesis.Config.BFT, db)
+	engine.Authorize(crypto.PubkeyToAddress(key.PublicKey), func(account accounts.Account, mimeType string, data []byte) ([]byte, error) {
+		return crypto.Sign(crypto.Keccak256(data), key)
+	})
+	chain, err := core.NewBlockChain(db, nil, genesis.Config, engine, vm.Config{}, nil, nil)
+	if err != nil {
+		t.Fatalf("failed to create chain: %v", err)
+	}
+	return &testValidator{
+		key:    key,
+		addr:   crypto.PubkeyToAddress(key.PublicKey),
+		engine: engine,
+		chain:  chain,
+	}
+}
+
+// close stops the validator node.
+func (v *testValidator) close() {
+	v.engine.Close()
+	v.chain.Stop()
+}
+
+// connect links two validators via the consensus sub-protocol.
+func connect(a, b *testValidator) {
+	rwa, rwb := p2p.MsgPipe()
+
+	go a.engine.runPeer(newPeer(p2p.NewPeer(enode.PubkeyToIDV4(&b.key.PublicKey), "b", nil), rwa))
+	go b.engine.runPeer(newPeer(p2p.NewPeer(enode.PubkeyToIDV4(&a.key.PublicKey), "a", nil), rwb))
+}
+
+// newTestNetwork creates a fully connected network of validators, only running
+// the consensus core of the online ones.
+func newTestNetwork(t *testing.T, n int, online func(i int) bool) []*testValidator {
+	var (
+		keys       = newTestKeys(n)
+		genesis    = newTestGenesis(keys)
+		validators = make([]*testValidator, n)
+	)
+	for i, key := range keys {
+		validators[i] = newTestValidator(t, genesis, key)
+	}
+	for i := 0; i < n; i++ {
+		if !online(i) {
+			continue
+		}
+		validators[i].engine.Start(validators[i].chain)
+		for j := i + 1; j < n; j++ {
+			if online(j) {
+				connect(validators[i], validators[j])
+			}
+		}
+	}
+	return validators
+}
+
+// makeBlock assembles an empty block on top of the validator's chain head.
+func (v *testValidator) makeBlock(t *testing.T) *types.Block {
+	parent := v.chain.CurrentBlock()
+	header := &types.Header{
+		ParentHash: parent.Hash(),
+		Number:     new(big.Int).Add(parent.Number(), common.Big1),
+		GasLimit:   parent.GasLimit(),
+		BaseFee:    misc.CalcBaseFee(v.chain.Config(), parent.Header()),
+	}
+	if err := v.engine.Prepare(v.chain, header); err != nil {
+		t.Fatalf("failed to prepare header: %v", err)
+	}
+	statedb, err := v.chain.StateAt(parent.Root())
+	if err != nil {
+		t.Fatalf("failed to retrieve parent state: %v", err)
+	}
+	block, err := v.engine.FinalizeAndAssemble(v.chain, header, statedb, nil, nil, nil)
+	if err != nil {
+		t.Fatalf("failed to assemble block: %v", err)
+	}
+	return block
+}
+
+// seal requests every online validator to seal a block on top of its head,
+// importing it if the engine delivers it back like the miner would.
+func seal(t *testing.T, validators []*testValidator, online func(i int) bool) {
+	for i, v := range validators {
+		if online(i) {
+			v.seal(t, v.makeBlock(t))
+		}
+	}
+}
+
+// seal requests the validator to seal the given block, importing it if the
+// engine delivers it back like the miner would.
+func (v *testValidator) seal(t *testing.T, block *types.Block) {
+	results := make(chan *types.Block, 1)
+	if err := v.engine.Seal(v.chain, block, results, make(chan struct{})); err != nil {
+		t.Fatalf("validator %x: failed to seal block: %v", v.addr, err)
+	}
+	go func() {
+		select {
+		case block := <-results:
+			v.chain.InsertChain(types.Blocks{block})
+		case <-time.After(10 * time.Second):
+		}
+	}()
+}
+
+// waitHeight waits until all online validators imported the given block number
+// and ensures they agree on it.
+func waitHeight(t *testing.T, validators []*testValidator, online func(i int) bool, number uint64) *types.Block {
+	deadline := time.Now().Add(10 * time.Second)
+
+	var block *types.Block
+	for i, v := range validators {
+		if !online(i) {
+			continue
+		}
+		for v.chain.CurrentBlock().NumberU64() < number {
+			if time.Now().After(deadline) {
+				t.Fatalf("validator %d: timeout waiting for block %d, head %d", i, number, v.chain.CurrentBlock().NumberU64())
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		have := v.chain.GetBlockByNumber(number)
+		if block == nil {
+			block = have
+		} else if have.Hash() != block.Hash() {
+			t.Fatalf("validator %d: block %d mismatch: have %x, want %x", i, number, have.Hash(), block.Hash())
+		}
+	}
+	return block
+}
+
+// Tests that a network of validators agrees on consecutive blocks, each of them
+// carrying a quorum of committed seals and being proposed in turn.
+func TestCommitBlocks(t *testing.T) {
+	online := func(int) bool { return true }
+
+	validators := newTestNetwork(t, 4, online)
+	for _, v := range validators {
+		defer v.close()
+	}
+	for number := uint64(1); number <= 2; number++ {
+		seal(t, validators, online)
+		block := waitHeight(t, validators, online, number)
+
+		proposer, err := validators[0].engine.Author(block.Header())
+		if err != nil {
+			t.Fatalf("block %d: failed to recover proposer: %v", number, err)
+		}
+		if want := validators[number%4].addr; proposer != want {
+			t.Errorf("block %d: proposer mismatch: have %x, want %x", number, proposer, want)
+		}
+		extra, err := types.ExtractBFTExtra(block.Header())
+		if err != nil {
+			t.Fatalf("block %d: failed to decode extra-data: %v", number, err)
+		}
+		if len(extra.CommittedSeal) < 3 {
+			t.Errorf("block %d: committed seals mismatch: have %d, want at least 3", number, len(extra.CommittedSeal))
+		}
+	}
+}
+
+// Tests that if the proposer of a round is offline, the remaining quorum of
+// validators moves on to the next round and commits a block nonetheless.
+func TestRoundChange(t *testing.T) {
+	// Height 1 is proposed by validator 1 in round 0, validator 2 in round 1
+	online := func(i int) bool { return i != 1 }
+
+	validators := newTestNetwork(t, 4, online)
+	for _, v := range validators {
+		defer v.close()
+	}
+	seal(t, validators, online)
+	block := waitHeight(t, validators, online, 1)
+
+	proposer, err := validators[0].engine.Author(block.Header())
+	if err != nil {
+		t.Fatalf("failed to recover proposer: %v", err)
+	}
+	if proposer != validators[2].addr {
+		t.Errorf("proposer mismatch: have %x, want %x", proposer, validators[2].addr)
+	}
+}
+
+// Tests that if the proposer of a round proposes a block with an invalid state
+// root, the validators refuse to vote on it and commit the block of the next
+// round instead.
+func TestInvalidProposalState(t *testing.T) {
+	online := func(int) bool { return true }
+
+	validators := newTestNetwork(t, 4, online)
+	for _, v := range validators {
+		defer v.close()
+	}
+	// Height 1 is proposed by validator 1 in round 0, validator 2 in round 1
+	for i, v := range validators {
+		block := v.makeBlock(t)
+		if i == 1 {
+			header := block.Header()
+			header.Root = common.HexToHash("0xdeadbeef")
+			block = block.WithSeal(header)
+		}
+		v.seal(t, block)
+	}
+	block := waitHeight(t, validators, online, 1)
+
+	proposer, err := validators[0].engine.Author(block.Header())
+	if err != nil {
+		t.Fatalf("failed to recover proposer: %v", err)
+	}
+	if proposer != validators[2].addr {
+		t.Errorf("proposer mismatch: have %x, want %x", proposer, validators[2].addr)
+	}
+	if block.Root() != validators[0].chain.Genesis().Root() {
+		t.Errorf("state root mismatch: have %x, want %x", block.Root(), validators[0].chain.Genesis().Root())
+	}
+}
+
+// Tests that committed blocks are only accepted with a quorum of committed
+// seals from distinct validators, which are signed over the proposal hash.
+func TestVerifyCommittedSeals(t *testing.T) {
+	online := func(int) bool { return true }
+
+	validators := newTestNetwork(t, 4, online)
+	for _, v := range validators {
+		defer v.close()
+	}
+	seal(t, validators, online)
+	block := waitHeight(t, validators, online, 1)
+
+	var (
+		engine = validators[0].engine
+		chain  = validators[0].chain
+	)
+	extra, _ := types.ExtractBFTExtra(block.Header())
+
+	tamper := func(seals [][]byte) *types.Header {
+		header := block.Header()
+		cpy := *extra
+		cpy.CommittedSeal = seals
+		if err := writeExtra(header, &cpy); err != nil {
+			t.Fatalf("failed to write extra-data: %v", err)
+		}
+		if proposalHash(header) != proposalHash(block.Header()) {
+			t.Fatalf("committed seals changed the proposal hash")
+		}
+		return header
+	}
+	if err := engine.VerifyHeader(chain, tamper(extra.CommittedSeal[:2]), true); err != errInsufficientCommittedSeals {
+		t.Errorf("too few seals error mismatch: have %v, want %v", err, errInsufficientCommittedSeals)
+	}
+	duplicate := [][]byte{extra.CommittedSeal[0], extra.CommittedSeal[0], extra.CommittedSeal[1]}
+	if err := engine.VerifyHeader(chain, tamper(duplicate), true); err != errInvalidCommittedSeals {
+		t.Errorf("duplicate seals error mismatch: have %v, want %v", err, errInvalidCommittedSeals)
+	}
+	outsider, _ := crypto.GenerateKey()
+	forged, _ := crypto.Sign(crypto.Keccak256(commitData(proposalHash(block.Header()))), outsider)
+	if err := engine.VerifyHeader(chain, tamper(append(extra.CommittedSeal[:2:2], forged)), true); err != errInvalidCommittedSeals {
+		t.Errorf("forged seal error mismatch: have %v, want %v", err, errInvalidCommittedSeals)
+	}
+}
+
+// Tests that validators can be added to and removed from the set by a majority
+// of votes cast in the proposed headers.
+func TestSnapshotVoting(t *testing.T) {
+	var (
+		keys    = newTestKeys(4)
+		genesis = newTestGenesis(keys[:3])
+		config  = genesis.Config.BFT
+		addrs   = make([]common.Address, len(keys))
+	)
+	for i, key := range keys {
+		addrs[i] = crypto.PubkeyToAddress(key.PublicKey)
+	}
+	sigcache, _ := lru.NewARC(inmemorySignatures)
+	snap := newSnapshot(config, sigcache, 0, common.Hash{}, addrs[:3])
+
+	// Build a chain of headers voting on the fourth key, sealed by the proposers
+	var (
+		votes = []struct {
+			proposer  int
+			authorize bool
+		}{
+			{0, true}, {1, true}, // Adds the fourth validator
+			{0, false}, {1, false}, {3, false}, // Drops the fourth validator
+		}
+		headers []*types.Header
+		parent  common.Hash
+	)
+	for i, vote := range votes {
+		header := &types.Header{
+			ParentHash: parent,
+			Number:     big.NewInt(int64(i + 1)),
+			Coinbase:   addrs[3],
+			MixDigest:  types.BFTDigest,
+			Extra:      make([]byte, types.BFTExtraVanity),
+		}
+		if vote.authorize {
+			copy(header.Nonce[:], nonceAuthVote)
+		}
+		writeExtra(header, new(types.BFTExtra))
+
+		sig, _ := crypto.Sign(SealHash(header).Bytes(), keys[vote.proposer])
+		writeExtra(header, &types.BFTExtra{Seal: sig})
+
+		headers, parent = append(headers, header), header.Hash()
+	}
+	added, err := snap.apply(headers[:2])
+	if err != nil {
+		t.Fatalf("failed to apply authorizing headers: %v", err)
+	}
+	if _, ok := added.Validators[addrs[3]]; !ok || len(added.Validators) != 4 {
+		t.Fatalf("validator not added: %v", added.validators())
+	}
+	if added.quorum() != 3 || added.faulty() != 1 {
+		t.Errorf("quorum mismatch: have %d/%d, want 3/1", added.quorum(), added.faulty())
+	}
+	dropped, err := added.apply(headers[2:])
+	if err != nil {
+		t.Fatalf("failed to apply deauthorizing headers: %v", err)
+	}
+	if _, ok := dropped.Validators[addrs[3]]; ok || len(dropped.Validators) != 3 {
+		t.Fatalf("validator not dropped: %v", dropped.validators())
+	}
+}
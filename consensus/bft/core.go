@@ -0,0 +1,600 @@
+// Copyright 2021 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package bft
+
+import (
+	"time"
+
+	"github.com/ethereum/go-ethereum/accounts"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/rlp"
+)
+
+const (
+	chainHeadChanSize = 10   // Size of channel listening to ChainHeadEvent
+	maxBacklog        = 1024 // Maximum number of future messages to keep around
+	maxTimeoutShift   = 8    // Maximum number of times the round timeout is doubled
+)
+
+// engineCore is the round based state machine agreeing on the blocks of the chain
+// with the other validators. All its methods run on the loop goroutine.
+type engineCore struct {
+	engine *BFT
+	chain  chainContext
+
+	sequence uint64        // Number of the block being agreed on
+	round    uint64        // Current round within the height
+	parent   *types.Header // Parent of the block being agreed on
+	snap     *Snapshot     // Validator set voting on the current height
+
+	request       *sealRequest                           // Latest block the local miner asked to be sealed
+	proposal      *types.Block                           // Block proposed in the current round
+	locked        *types.Block                           // Block the local validator precommitted to
+	prevoted      bool                                   // Whether the local validator prevoted in this round
+	precommitted  bool                                   // Whether the local validator precommitted in this round
+	committed     bool                                   // Whether the height was already committed
+	prevotes      map[common.Address]common.Hash         // Blocks prevoted by the validators in this round
+	precommits    map[common.Address]*message            // Precommits of the validators in this round
+	roundChanges  map[uint64]map[common.Address]struct{} // Validators asking for each future round
+	roundChangeTo uint64                                 // Highest round the local validator asked for
+
+	backlog []*message  // Messages for future heights or rounds
+	timer   *time.Timer // Timer to give up on the current round
+}
+
+// newEngineCore creates the consensus state machine on top of the given chain.
+func newEngineCore(engine *BFT, chain chainContext) *engineCore {
+	timer := time.NewTimer(0)
+	if !timer.Stop() {
+		<-timer.C
+	}
+	return &engineCore{
+		engine: engine,
+		chain:  chain,
+		timer:  timer,
+	}
+}
+
+// loop is the main event loop of the consensus core, processing the chain head
+// updates, the seal requests of the local miner and the messages of the other
+// validators until the engine is closed.
+func (c *engineCore) loop() {
+	headCh := make(chan core.ChainHeadEvent, chainHeadChanSize)
+	sub := c.chain.SubscribeChainHeadEvent(headCh)
+	defer sub.Unsubscribe()
+	defer c.timer.Stop()
+
+	c.newHeight(c.chain.CurrentBlock().Header())
+	for {
+		select {
+		case ev := <-headCh:
+			c.newHeight(ev.Block.Header())
+
+		case req := <-c.engine.requestCh:
+			c.handleRequest(req)
+
+		case msg := <-c.engine.messageCh:
+			c.handleMessage(msg)
+
+		case block := <-c.engine.failedCh:
+			c.handleFailedImport(block)
+
+		case <-c.timer.C:
+			c.handleTimeout()
+
+		case <-sub.Err():
+			return
+		case <-c.engine.quit:
+			return
+		}
+	}
+}
+
+// newHeight starts agreeing on the block following the given head.
+func (c *engineCore) newHeight(head *types.Header) {
+	number := head.Number.Uint64() + 1
+	if c.parent != nil && number <= c.sequence {
+		return // Stale or already known head
+	}
+	snap, err := c.engine.snapshot(c.chain, head.Number.Uint64(), head.Hash(), nil)
+	if err != nil {
+		log.Error("Failed to retrieve validator snapshot", "number", head.Number, "hash", head.Hash(), "err", err)
+		return
+	}
+	c.sequence, c.round, c.parent, c.snap = number, 0, head, snap
+	c.locked, c.committed, c.roundChangeTo = nil, false, 0
+	c.roundChanges = make(map[uint64]map[common.Address]struct{})
+	c.resetRound()
+
+	if c.request != nil && c.request.block.ParentHash() != head.Hash() {
+		c.request = nil
+	}
+	// The first round only times out after the block's slot has arrived
+	delay := time.Until(time.Unix(int64(head.Time+c.engine.config.Period), 0))
+	if delay < 0 {
+		delay = 0
+	}
+	c.resetTimer(delay + c.timeout(0))
+
+	log.Debug("Starting new height", "number", c.sequence, "validators", len(snap.Validators), "proposer", snap.proposer(c.sequence, 0))
+	c.propose()
+	c.replayBacklog()
+}
+
+// startRound moves on to the given round of the current height.
+func (c *engineCore) startRound(round uint64) {
+	if round <= c.round || c.committed {
+		return
+	}
+	c.round = round
+	c.resetRound()
+	for r := range c.roundChanges {
+		if r <= round {
+			delete(c.roundChanges, r)
+		}
+	}
+	c.resetTimer(c.timeout(round))
+
+	log.Debug("Starting new round", "number", c.sequence, "round", round, "proposer", c.snap.proposer(c.sequence, round))
+	c.propose()
+	c.replayBacklog()
+}
+
+// resetRound clears all the per-round state.
+func (c *engineCore) resetRound() {
+	c.proposal = nil
+	c.prevoted, c.precommitted = false, false
+	c.prevotes = make(map[common.Address]common.Hash)
+	c.precommits = make(map[common.Address]*message)
+}
+
+// timeout returns how long to wait for the given round to finish. It doubles
+// with every round to give slow networks a chance to agree eventually.
+func (c *engineCore) timeout(round uint64) time.Duration {
+	if round > maxTimeoutShift {
+		round = maxTimeoutShift
+	}
+	return time.Duration(c.engine.config.RequestTimeout) * time.Millisecond << round
+}
+
+// resetTimer reschedules the round timer.
+func (c *engineCore) resetTimer(d time.Duration) {
+	if !c.timer.Stop() {
+		select {
+		case <-c.timer.C:
+		default:
+		}
+	}
+	c.timer.Reset(d)
+}
+
+// isValidator returns whether the local node is allowed to take part in the
+// current height.
+func (c *engineCore) isValidator() bool {
+	signer, signFn := c.engine.authorized()
+	if signFn == nil {
+		return false
+	}
+	_, ok := c.snap.Validators[signer]
+	return ok
+}
+
+// handleRequest stores a block the local miner wants to be sealed, proposing it
+// if it's the local validator's turn.
+func (c *engineCore) handleRequest(req *sealRequest) {
+	// The miner may see a new head before the core does, catch up if so
+	if c.parent == nil || req.block.NumberU64() > c.sequence {
+		c.newHeight(c.chain.CurrentBlock().Header())
+	}
+	if c.parent == nil || req.block.NumberU64() != c.sequence || req.block.ParentHash() != c.parent.Hash() {
+		log.Trace("Discarding stale seal request", "number", req.block.Number(), "sequence", c.sequence)
+		return
+	}
+	c.request = req
+	c.propose()
+}
+
+// propose broadcasts the block of the round if the local validator is the
+// proposer. A block precommitted to in an earlier round takes precedence over
+// the one requested by the miner.
+func (c *engineCore) propose() {
+	if c.committed || c.proposal != nil || !c.isValidator() {
+		return
+	}
+	signer, _ := c.engine.authorized()
+	if c.snap.proposer(c.sequence, c.round) != signer {
+		return
+	}
+	block := c.locked
+	if block == nil {
+		if c.request == nil {
+			return // Nothing to propose yet, wait for the miner
+		}
+		block = c.request.block
+	}
+	payload, err := rlp.EncodeToBytes(block)
+	if err != nil {
+		log.Error("Failed to encode proposal", "err", err)
+		return
+	}
+	log.Debug("Proposing block", "number", c.sequence, "round", c.round, "hash", block.Hash(), "txs", len(block.Transactions()))
+	c.broadcast(&message{Code: msgPropose, Sequence: c.sequence, Round: c.round, Proposal: payload})
+}
+
+// handleTimeout asks the other validators to move on to the next round if the
+// current one couldn't agree on a block in time. If it did agree on one but the
+// committed block wasn't received from the proposer, it's committed with the
+// locally gathered seals instead.
+func (c *engineCore) handleTimeout() {
+	if c.committed {
+		return
+	}
+	if c.proposal != nil {
+		if seals := c.seals(proposalHash(c.proposal.Header())); len(seals) >= c.snap.quorum() {
+			log.Debug("Committing block without proposer", "number", c.sequence, "round", c.round)
+			c.commit(seals)
+			return
+		}
+	}
+	round := c.round + 1
+	if c.roundChangeTo >= round {
+		round = c.roundChangeTo + 1
+	}
+	log.Debug("Round timed out", "number", c.sequence, "round", c.round, "next", round)
+
+	c.resetTimer(c.timeout(round))
+	c.sendRoundChange(round)
+}
+
+// sendRoundChange broadcasts a request to move on to the given round.
+func (c *engineCore) sendRoundChange(round uint64) {
+	if c.roundChangeTo >= round {
+		return
+	}
+	c.roundChangeTo = round
+	c.broadcast(&message{Code: msgRoundChange, Sequence: c.sequence, Round: round})
+}
+
+// handleMessage processes a consensus message, storing it for later if it's
+// from a future height or round.
+func (c *engineCore) handleMessage(m *message) {
+	switch {
+	case c.parent == nil || m.Sequence > c.sequence:
+		c.store(m)
+		return
+	case m.Sequence < c.sequence:
+		return // Stale message
+	}
+	if _, ok := c.snap.Validators[m.sender]; !ok {
+		log.Trace("Discarding message from non-validator", "msg", m)
+		return
+	}
+	switch m.Code {
+	case msgRoundChange:
+		c.handleRoundChange(m)
+		return
+	case msgCommit:
+		c.handleCommit(m)
+		return
+	}
+	switch {
+	case m.Round > c.round:
+		c.store(m)
+		return
+	case m.Round < c.round:
+		return // Stale message
+	}
+	switch m.Code {
+	case msgPropose:
+		c.handlePropose(m)
+	case msgPrevote:
+		c.handlePrevote(m)
+	case msgPrecommit:
+		c.handlePrecommit(m)
+	}
+}
+
+// handlePropose verifies and executes the block proposed in the current round
+// and prevotes for it unless the local validator is locked on a different one.
+func (c *engineCore) handlePropose(m *message) {
+	if m.sender != c.snap.proposer(c.sequence, c.round) {
+		log.Debug("Discarding proposal from wrong proposer", "msg", m)
+		return
+	}
+	if c.proposal != nil {
+		return // Only the first proposal of a round counts
+	}
+	block := new(types.Block)
+	if err := rlp.DecodeBytes(m.Proposal, block); err != nil {
+		log.Debug("Discarding undecodable proposal", "msg", m, "err", err)
+		return
+	}
+	if block.NumberU64() != c.sequence || (block.ParentHash() != c.parent.Hash() && !c.adoptParent(block.ParentHash())) {
+		log.Debug("Discarding proposal on wrong parent", "msg", m, "number", block.Number(), "parent", block.ParentHash())
+		return
+	}
+	if err := c.engine.verifyProposal(c.chain, block, c.parent); err != nil {
+		log.Warn("Discarding invalid proposal", "msg", m, "hash", block.Hash(), "err", err)
+		return
+	}
+	c.proposal = block
+
+	hash := proposalHash(block.Header())
+	if c.locked != nil && proposalHash(c.locked.Header()) != hash {
+		log.Debug("Not prevoting proposal, locked on other block", "hash", hash, "locked", proposalHash(c.locked.Header()))
+		return
+	}
+	if c.isValidator() {
+		c.prevoted = true
+		c.broadcast(&message{Code: msgPrevote, Sequence: c.sequence, Round: c.round, Digest: hash})
+	}
+	c.check()
+}
+
+// adoptParent switches over to the given version of the parent block, which the
+// proposer may have imported with a different set of committed seals. It returns
+// whether the parent is known and only differs from the local one in the seals.
+func (c *engineCore) adoptParent(hash common.Hash) bool {
+	parent := c.chain.GetHeader(hash, c.sequence-1)
+	if parent == nil || proposalHash(parent) != proposalHash(c.parent) {
+		return false
+	}
+	log.Debug("Switching to proposer's parent", "number", parent.Number, "hash", hash, "local", c.parent.Hash())
+	c.parent = parent
+	if c.request != nil && c.request.block.ParentHash() != hash {
+		c.request = nil
+	}
+	return true
+}
+
+// handlePrevote tallies the prevote of a validator.
+func (c *engineCore) handlePrevote(m *message) {
+	if _, ok := c.prevotes[m.sender]; ok {
+		return
+	}
+	c.prevotes[m.sender] = m.Digest
+	c.check()
+}
+
+// handlePrecommit tallies the precommit of a validator, ensuring it carries a
+// valid committed seal.
+func (c *engineCore) handlePrecommit(m *message) {
+	if _, ok := c.precommits[m.sender]; ok {
+		return
+	}
+	if committer, err := recoverCommitter(m.Digest, m.Seal); err != nil || committer != m.sender {
+		log.Debug("Discarding precommit with invalid seal", "msg", m, "err", err)
+		return
+	}
+	c.precommits[m.sender] = m
+	c.check()
+}
+
+// handleRoundChange tallies the round change requests. If f+1 validators ask
+// for a round, at least one honest validator timed out, so the local one joins
+// in. Once a quorum asks for it, the round is started.
+func (c *engineCore) handleRoundChange(m *message) {
+	if m.Round <= c.round || c.committed {
+		return
+	}
+	if _, ok := c.roundChanges[m.Round]; !ok {
+		c.roundChanges[m.Round] = make(map[common.Address]struct{})
+	}
+	c.roundChanges[m.Round][m.sender] = struct{}{}
+
+	votes := len(c.roundChanges[m.Round])
+	if votes >= c.snap.quorum() {
+		c.startRound(m.Round)
+		return
+	}
+	if votes > c.snap.faulty() && c.roundChangeTo < m.Round && c.isValidator() {
+		c.resetTimer(c.timeout(m.Round))
+		c.sendRoundChange(m.Round)
+	}
+}
+
+// check advances the round after any vote: once a quorum prevoted for the
+// proposal, the local validator locks on and precommits to it, and once a
+// quorum precommitted to it, the proposer commits the block.
+func (c *engineCore) check() {
+	if c.proposal == nil || c.committed {
+		return
+	}
+	hash := proposalHash(c.proposal.Header())
+
+	if c.prevoted && !c.precommitted && c.count(hash) >= c.snap.quorum() {
+		signer, signFn := c.engine.authorized()
+		seal, err := signFn(accounts.Account{Address: signer}, accounts.MimetypeBFT, commitData(hash))
+		if err != nil {
+			log.Error("Failed to sign committed seal", "err", err)
+			return
+		}
+		c.locked, c.precommitted = c.proposal, true
+		c.broadcast(&message{Code: msgPrecommit, Sequence: c.sequence, Round: c.round, Digest: hash, Seal: seal})
+		return // Broadcasting handles the local precommit, which rechecks
+	}
+	// Only the proposer commits right away and sends out the committed block, so
+	// that every validator imports it with the same seals (and hence hash). The
+	// others fall back to their own seals if it doesn't arrive in time.
+	if signer, _ := c.engine.authorized(); c.snap.proposer(c.sequence, c.round) != signer {
+		return
+	}
+	if seals := c.seals(hash); len(seals) >= c.snap.quorum() {
+		c.commit(seals)
+	}
+}
+
+// seals returns the committed seals of the validators that precommitted to the
+// given block, in the order of the validator set.
+func (c *engineCore) seals(hash common.Hash) [][]byte {
+	var seals [][]byte
+	for _, validator := range c.snap.validators() {
+		if m, ok := c.precommits[validator]; ok && m.Digest == hash {
+			seals = append(seals, m.Seal)
+		}
+	}
+	return seals
+}
+
+// count returns the number of validators that prevoted for the given block.
+func (c *engineCore) count(hash common.Hash) int {
+	var votes int
+	for _, digest := range c.prevotes {
+		if digest == hash {
+			votes++
+		}
+	}
+	return votes
+}
+
+// commit finalizes the proposal with the gathered committed seals and sends the
+// block out to the other validators.
+func (c *engineCore) commit(seals [][]byte) {
+	header := c.proposal.Header()
+	extra, err := types.ExtractBFTExtra(header)
+	if err != nil {
+		log.Error("Failed to decode proposal extra-data", "err", err)
+		return
+	}
+	extra.CommittedSeal = seals
+	if err := writeExtra(header, extra); err != nil {
+		log.Error("Failed to encode committed seals", "err", err)
+		return
+	}
+	block := c.proposal.WithSeal(header)
+	if !c.isValidator() {
+		c.finalize(block) // Nobody would accept the commit from a non-validator
+		return
+	}
+	payload, err := rlp.EncodeToBytes(block)
+	if err != nil {
+		log.Error("Failed to encode committed block", "err", err)
+		return
+	}
+	c.broadcast(&message{Code: msgCommit, Sequence: c.sequence, Round: c.round, Proposal: payload})
+}
+
+// handleCommit imports a block committed by a quorum of validators, unless the
+// current height is already committed. Blocks other than the proposal of the
+// current round are verified and executed first.
+func (c *engineCore) handleCommit(m *message) {
+	if c.committed {
+		return
+	}
+	block := new(types.Block)
+	if err := rlp.DecodeBytes(m.Proposal, block); err != nil {
+		log.Debug("Discarding undecodable commit", "msg", m, "err", err)
+		return
+	}
+	if block.NumberU64() != c.sequence {
+		log.Debug("Discarding commit of wrong height", "msg", m, "number", block.Number())
+		return
+	}
+	header := block.Header()
+	if c.proposal != nil && proposalHash(c.proposal.Header()) == proposalHash(header) {
+		block = c.proposal.WithSeal(header)
+	} else {
+		if block.ParentHash() != c.parent.Hash() && !c.adoptParent(block.ParentHash()) {
+			log.Debug("Discarding commit on wrong parent", "msg", m, "parent", block.ParentHash())
+			return
+		}
+		if err := c.engine.verifyProposal(c.chain, block, c.parent); err != nil {
+			log.Warn("Discarding invalid commit", "msg", m, "hash", block.Hash(), "err", err)
+			return
+		}
+	}
+	extra, err := types.ExtractBFTExtra(header)
+	if err != nil {
+		log.Debug("Discarding commit with invalid extra-data", "msg", m, "err", err)
+		return
+	}
+	if err := c.engine.verifyCommittedSeals(c.snap, header, extra); err != nil {
+		log.Warn("Discarding commit with invalid seals", "msg", m, "hash", block.Hash(), "err", err)
+		return
+	}
+	c.finalize(block)
+}
+
+// finalize marks the current height committed and hands the block over to be
+// imported.
+func (c *engineCore) finalize(block *types.Block) {
+	c.committed = true
+	c.timer.Stop()
+
+	log.Info("Committed new block", "number", block.Number(), "hash", block.Hash(), "round", c.round)
+	c.engine.deliver(block, c.request)
+}
+
+// handleFailedImport resumes agreeing on the current height if its committed
+// block failed to import, so that the round times out and the block is either
+// committed again or a new round is started.
+func (c *engineCore) handleFailedImport(block *types.Block) {
+	if !c.committed || block.NumberU64() != c.sequence {
+		return
+	}
+	c.committed = false
+	c.resetTimer(c.timeout(c.round))
+}
+
+// broadcast signs a message with the local validator key, sends it to the peers
+// and processes it locally.
+func (c *engineCore) broadcast(m *message) {
+	signer, signFn := c.engine.authorized()
+	if signFn == nil {
+		return
+	}
+	sig, err := signFn(accounts.Account{Address: signer}, accounts.MimetypeBFT, m.signingPayload())
+	if err != nil {
+		log.Error("Failed to sign consensus message", "err", err)
+		return
+	}
+	m.Signature, m.sender = sig, signer
+
+	payload, err := rlp.EncodeToBytes(m)
+	if err != nil {
+		log.Error("Failed to encode consensus message", "err", err)
+		return
+	}
+	hash := crypto.Keccak256Hash(payload)
+	c.engine.messages.Add(hash, struct{}{})
+	c.engine.peers.broadcast(hash, payload, "")
+
+	c.handleMessage(m)
+}
+
+// store keeps a message around until the core reaches its height and round.
+func (c *engineCore) store(m *message) {
+	if len(c.backlog) >= maxBacklog {
+		c.backlog = c.backlog[1:]
+	}
+	c.backlog = append(c.backlog, m)
+}
+
+// replayBacklog processes the stored messages again after the height or round
+// changed, dropping the stale ones.
+func (c *engineCore) replayBacklog() {
+	backlog := c.backlog
+	c.backlog = nil
+
+	for _, m := range backlog {
+		c.handleMessage(m)
+	}
+}
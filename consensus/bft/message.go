@@ -0,0 +1,113 @@
+// Copyright 2021 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package bft
+
+import (
+	"fmt"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/rlp"
+)
+
+// Consensus message codes exchanged between the validators while agreeing on
+// a block.
+const (
+	msgPropose     = 0x00 // Proposer sending out the block of the round
+	msgPrevote     = 0x01 // Validator accepting a proposed block
+	msgPrecommit   = 0x02 // Validator committing to a block seen prevoted by a quorum
+	msgRoundChange = 0x03 // Validator requesting to move on to a new round
+	msgCommit      = 0x04 // Validator sending out the block committed by a quorum
+)
+
+// message is a signed consensus message of a validator for a given height and
+// round.
+type message struct {
+	Code      uint64      // Type of the consensus message
+	Sequence  uint64      // Block number being agreed on
+	Round     uint64      // Round within the height the message belongs to
+	Digest    common.Hash // Hash of the block being voted on (prevote, precommit)
+	Proposal  []byte      // RLP encoded proposed (propose) or committed block (commit)
+	Seal      []byte      // Committed seal over the digest (precommit)
+	Signature []byte      // Signature of the sender over all the above fields
+
+	sender common.Address // Validator recovered from the signature
+}
+
+// String implements fmt.Stringer.
+func (m *message) String() string {
+	var kind string
+	switch m.Code {
+	case msgPropose:
+		kind = "propose"
+	case msgPrevote:
+		kind = "prevote"
+	case msgPrecommit:
+		kind = "precommit"
+	case msgRoundChange:
+		kind = "roundchange"
+	case msgCommit:
+		kind = "commit"
+	default:
+		kind = fmt.Sprintf("unknown(%d)", m.Code)
+	}
+	return fmt.Sprintf("{%s seq: %d, round: %d, sender: %x}", kind, m.Sequence, m.Round, m.sender)
+}
+
+// signingPayload returns the RLP bytes which need to be signed by the sender,
+// consisting of the entire message apart from the signature.
+func (m *message) signingPayload() []byte {
+	blob, err := rlp.EncodeToBytes([]interface{}{m.Code, m.Sequence, m.Round, m.Digest, m.Proposal, m.Seal})
+	if err != nil {
+		panic("can't encode: " + err.Error())
+	}
+	return blob
+}
+
+// decodeMessage parses a consensus message from its network representation and
+// recovers the validator that signed it.
+func decodeMessage(payload []byte) (*message, error) {
+	m := new(message)
+	if err := rlp.DecodeBytes(payload, m); err != nil {
+		return nil, err
+	}
+	if m.Code > msgCommit {
+		return nil, errInvalidMessage
+	}
+	pubkey, err := crypto.SigToPub(crypto.Keccak256(m.signingPayload()), m.Signature)
+	if err != nil {
+		return nil, err
+	}
+	m.sender = crypto.PubkeyToAddress(*pubkey)
+	return m, nil
+}
+
+// commitData returns the data a validator signs to commit to the block with
+// the given hash. The message code is mixed in to ensure a committed seal can
+// never be replayed as any other signature.
+func commitData(hash common.Hash) []byte {
+	return append(hash.Bytes(), byte(msgPrecommit))
+}
+
+// recoverCommitter extracts the validator address from a committed seal.
+func recoverCommitter(hash common.Hash, seal []byte) (common.Address, error) {
+	pubkey, err := crypto.SigToPub(crypto.Keccak256(commitData(hash)), seal)
+	if err != nil {
+		return common.Address{}, err
+	}
+	return crypto.PubkeyToAddress(*pubkey), nil
+}
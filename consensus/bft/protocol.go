@@ -0,0 +1,230 @@
+// Copyright 2021 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package bft
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/p2p"
+	lru "github.com/hashicorp/golang-lru"
+)
+
+const (
+	protocolName    = "bft" // Name of the consensus sub-protocol
+	protocolVersion = 1     // Version of the consensus sub-protocol
+	protocolLength  = 1     // Number of message codes used by the sub-protocol
+
+	consensusMsg = 0x00 // Message code carrying a signed consensus message
+
+	maxMessageSize    = 10 * 1024 * 1024 // Maximum cap on the size of a consensus message
+	maxKnownMessages  = 4096             // Maximum message hashes to keep in the known list per peer
+	maxQueuedMessages = 256              // Maximum number of messages to queue up per peer before dropping
+)
+
+var (
+	errMsgTooLarge    = errors.New("message too long")
+	errInvalidMsgCode = errors.New("invalid message code")
+	errPeerRegistered = errors.New("peer already registered")
+)
+
+// peer is a remote node speaking the consensus sub-protocol.
+type peer struct {
+	*p2p.Peer
+	id string
+	rw p2p.MsgReadWriter
+
+	known *lru.Cache    // Hashes of the messages known to be known by this peer
+	queue chan []byte   // Queue of messages to send to the peer
+	term  chan struct{} // Termination channel to stop the broadcaster
+}
+
+// newPeer wraps a devp2p peer connected via the consensus sub-protocol.
+func newPeer(p *p2p.Peer, rw p2p.MsgReadWriter) *peer {
+	known, _ := lru.New(maxKnownMessages)
+	return &peer{
+		Peer:  p,
+		id:    p.ID().String(),
+		rw:    rw,
+		known: known,
+		queue: make(chan []byte, maxQueuedMessages),
+		term:  make(chan struct{}),
+	}
+}
+
+// broadcastLoop sends the queued messages to the remote peer until terminated.
+func (p *peer) broadcastLoop() {
+	for {
+		select {
+		case payload := <-p.queue:
+			if err := p2p.Send(p.rw, consensusMsg, payload); err != nil {
+				return
+			}
+		case <-p.term:
+			return
+		}
+	}
+}
+
+// send queues a message for the peer, marking it known. If the peer's queue is
+// full, the message is dropped.
+func (p *peer) send(hash common.Hash, payload []byte) {
+	p.known.Add(hash, struct{}{})
+	select {
+	case p.queue <- payload:
+	default:
+		log.Debug("Dropping consensus message to peer", "peer", p.id, "hash", hash)
+	}
+}
+
+// peerSet is the set of peers connected via the consensus sub-protocol.
+type peerSet struct {
+	peers map[string]*peer
+	lock  sync.RWMutex
+}
+
+// newPeerSet creates an empty peer set.
+func newPeerSet() *peerSet {
+	return &peerSet{peers: make(map[string]*peer)}
+}
+
+// register adds a new peer to the set.
+func (ps *peerSet) register(p *peer) error {
+	ps.lock.Lock()
+	defer ps.lock.Unlock()
+
+	if _, ok := ps.peers[p.id]; ok {
+		return errPeerRegistered
+	}
+	ps.peers[p.id] = p
+	return nil
+}
+
+// unregister removes a peer from the set.
+func (ps *peerSet) unregister(id string) {
+	ps.lock.Lock()
+	defer ps.lock.Unlock()
+
+	delete(ps.peers, id)
+}
+
+// broadcast sends a message to all the peers not yet knowing about it, apart
+// from the one it originates from.
+func (ps *peerSet) broadcast(hash common.Hash, payload []byte, origin string) {
+	ps.lock.RLock()
+	defer ps.lock.RUnlock()
+
+	for id, p := range ps.peers {
+		if id == origin || p.known.Contains(hash) {
+			continue
+		}
+		p.send(hash, payload)
+	}
+}
+
+// Protocols returns the devp2p sub-protocol the validators use to exchange the
+// consensus messages. Messages are gossiped, so validators don't need to be
+// connected directly to each other.
+func (b *BFT) Protocols() []p2p.Protocol {
+	return []p2p.Protocol{{
+		Name:    protocolName,
+		Version: protocolVersion,
+		Length:  protocolLength,
+		Run: func(p *p2p.Peer, rw p2p.MsgReadWriter) error {
+			return b.runPeer(newPeer(p, rw))
+		},
+	}}
+}
+
+// runPeer registers a consensus peer and processes its messages until the
+// connection is torn down.
+func (b *BFT) runPeer(p *peer) error {
+	if err := b.peers.register(p); err != nil {
+		return err
+	}
+	defer b.peers.unregister(p.id)
+
+	go p.broadcastLoop()
+	defer close(p.term)
+
+	log.Debug("Consensus peer connected", "peer", p.id)
+	for {
+		if err := b.handlePeerMsg(p); err != nil {
+			log.Debug("Consensus peer disconnected", "peer", p.id, "err", err)
+			return err
+		}
+	}
+}
+
+// handlePeerMsg reads the next message of a peer and hands it to the consensus
+// core, relaying it further if it's new and comes from a validator.
+func (b *BFT) handlePeerMsg(p *peer) error {
+	msg, err := p.rw.ReadMsg()
+	if err != nil {
+		return err
+	}
+	defer msg.Discard()
+
+	if msg.Size > maxMessageSize {
+		return errMsgTooLarge
+	}
+	if msg.Code != consensusMsg {
+		return errInvalidMsgCode
+	}
+	var payload []byte
+	if err := msg.Decode(&payload); err != nil {
+		return err
+	}
+	hash := crypto.Keccak256Hash(payload)
+	p.known.Add(hash, struct{}{})
+
+	if ok, _ := b.messages.ContainsOrAdd(hash, struct{}{}); ok {
+		return nil
+	}
+	m, err := decodeMessage(payload)
+	if err != nil {
+		return err
+	}
+	b.lock.RLock()
+	chain, quit := b.chain, b.quit
+	b.lock.RUnlock()
+
+	if chain == nil {
+		return nil // Consensus core not running, nothing to do
+	}
+	// Only relay the messages of the current validators to avoid being used
+	// as an amplifier by anyone else
+	head := chain.CurrentHeader()
+	snap, err := b.snapshot(chain, head.Number.Uint64(), head.Hash(), nil)
+	if err != nil {
+		return nil
+	}
+	if _, ok := snap.Validators[m.sender]; !ok {
+		log.Trace("Discarding message from non-validator", "peer", p.id, "msg", m)
+		return nil
+	}
+	b.peers.broadcast(hash, payload, p.id)
+
+	select {
+	case b.messageCh <- m:
+	case <-quit:
+	}
+	return nil
+}
@@ -71,6 +71,22 @@ func BenchmarkInsertChain_ring1000_diskdb(b *testing.B) {
 	benchInsertChain(b, true, genTxRing(1000))
 }
 
+// The storage benchmarks import blocks modifying the storage of many contracts,
+// run them with -cpu 1,2,4,... to compare the sequential and concurrent hashing
+// and committing of the storage tries.
+func BenchmarkInsertChain_storage200_memdb(b *testing.B) {
+	benchInsertChainWithAlloc(b, false, genStorageAlloc(200), genStorageCalls(200))
+}
+func BenchmarkInsertChain_storage200_diskdb(b *testing.B) {
+	benchInsertChainWithAlloc(b, true, genStorageAlloc(200), genStorageCalls(200))
+}
+func BenchmarkInsertChain_storage1000_memdb(b *testing.B) {
+	benchInsertChainWithAlloc(b, false, genStorageAlloc(1000), genStorageCalls(1000))
+}
+func BenchmarkInsertChain_storage1000_diskdb(b *testing.B) {
+	benchInsertChainWithAlloc(b, true, genStorageAlloc(1000), genStorageCalls(1000))
+}
+
 var (
 	// This is the content of the genesis block used by the benchmarks.
 	benchRootKey, _ = crypto.HexToECDSA("b71c71a67e1177ad4e901695e1b4b9ee17ae16c6668d313eac2f96dbcda3f291")
@@ -134,6 +150,44 @@ func genTxRing(naccounts int) func(int, *BlockGen) {
 	}
 }
 
+// benchStorageCode stores the block number into the slots 0-3 when called.
+var benchStorageCode = common.FromHex("0x4360005543600155436002554360035500")
+
+// genStorageAlloc returns a genesis allocation with n contracts, each of them
+// running benchStorageCode and having a filled storage.
+func genStorageAlloc(ncontracts int) GenesisAlloc {
+	alloc := GenesisAlloc{benchRootAddr: {Balance: benchRootFunds}}
+	for i := 0; i < ncontracts; i++ {
+		storage := make(map[common.Hash]common.Hash)
+		for j := 0; j < 64; j++ {
+			storage[common.BigToHash(big.NewInt(int64(j)))] = common.BigToHash(big.NewInt(int64(j + 1)))
+		}
+		alloc[benchStorageAddr(i)] = GenesisAccount{Balance: big.NewInt(1), Code: benchStorageCode, Storage: storage}
+	}
+	return alloc
+}
+
+// benchStorageAddr returns the address of the i-th storage benchmark contract.
+func benchStorageAddr(i int) common.Address {
+	return common.BigToAddress(big.NewInt(int64(0x10000 + i)))
+}
+
+// genStorageCalls returns a block generator calling the storage benchmark
+// contracts in a round robin fashion, filling the blocks.
+func genStorageCalls(ncontracts int) func(int, *BlockGen) {
+	next := 0
+	return func(i int, gen *BlockGen) {
+		block := gen.PrevBlock(i - 1)
+		gas := block.GasLimit()
+		for gas >= 100000 {
+			gas -= 100000
+			tx, _ := types.SignTx(types.NewTransaction(gen.TxNonce(benchRootAddr), benchStorageAddr(next), nil, 100000, big.NewInt(params.InitialBaseFee), nil), types.HomesteadSigner{}, benchRootKey)
+			gen.AddTx(tx)
+			next = (next + 1) % ncontracts
+		}
+	}
+}
+
 // genUncles generates blocks with two uncle headers.
 func genUncles(i int, gen *BlockGen) {
 	if i >= 6 {
@@ -147,6 +201,10 @@ func genUncles(i int, gen *BlockGen) {
 }
 
 func benchInsertChain(b *testing.B, disk bool, gen func(int, *BlockGen)) {
+	benchInsertChainWithAlloc(b, disk, GenesisAlloc{benchRootAddr: {Balance: benchRootFunds}}, gen)
+}
+
+func benchInsertChainWithAlloc(b *testing.B, disk bool, alloc GenesisAlloc, gen func(int, *BlockGen)) {
 	// Create the database in memory or in a temporary directory.
 	var db ethdb.Database
 	if !disk {
@@ -168,7 +226,7 @@ func benchInsertChain(b *testing.B, disk bool, gen func(int, *BlockGen)) {
 	// generator function.
 	gspec := Genesis{
 		Config: params.TestChainConfig,
-		Alloc:  GenesisAlloc{benchRootAddr: {Balance: benchRootFunds}},
+		Alloc:  alloc,
 	}
 	genesis := gspec.MustCommit(db)
 	chain, _ := GenerateChain(gspec.Config, genesis, ethash.NewFaker(), db, b.N, gen)
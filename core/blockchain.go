@@ -34,6 +34,7 @@ import (
 	"github.com/ethereum/go-ethereum/consensus"
 	"github.com/ethereum/go-ethereum/core/rawdb"
 	"github.com/ethereum/go-ethereum/core/state"
+	"github.com/ethereum/go-ethereum/core/state/pruner"
 	"github.com/ethereum/go-ethereum/core/state/snapshot"
 	"github.com/ethereum/go-ethereum/core/types"
 	"github.com/ethereum/go-ethereum/core/vm"
@@ -91,6 +92,8 @@ const (
 	txLookupCacheLimit  = 1024
 	maxFutureBlocks     = 256
 	maxTimeFutureBlocks = 30
+	stateDiffCacheLimit = 256
+	archiveCacheLimit   = 256
 	TriesInMemory       = 128
 
 	// BlockChainVersion ensures that an incompatible database forces a resync from scratch.
@@ -131,6 +134,21 @@ type CacheConfig struct {
 	TrieTimeLimit       time.Duration // Time limit after which to flush the current in-memory trie to disk
 	SnapshotLimit       int           // Memory allowance (MB) to use for caching snapshot entries in memory
 	Preimages           bool          // Whether to store preimage of trie key to the disk
+	StateScheme         string        // Storage scheme of the trie nodes, hash or path based
+
+	OnlinePruning          bool          // Whether to prune stale state in the background during block import
+	OnlinePruningInterval  uint64        // Minimum number of blocks between two consecutive online pruning targets
+	OnlinePruningBloomSize uint64        // Memory allowance (MB) of the online pruning state bloom
+	OnlinePruningBloom     string        // File to persist the online pruning state bloom into across restarts
+	OnlinePruningThrottle  time.Duration // Pause between two consecutive online pruning deletion batches
+
+	StateDiffs   uint64 // Number of recent blocks to keep reverse state diffs for (0 = disabled)
+	StateDiffDir string // Directory of the reverse state diff store
+
+	StateArchive    bool   // Whether to archive the flat state diffs of all blocks for historical reads
+	StateArchiveDir string // Directory of the archived flat state diff store
+
+	PreimageDir string // Directory of the append-only preimage store, recording all preimages if set
 
 	SnapshotWait bool // Wait for snapshot construction on startup. TODO(karalabe): This is a dirty hack for testing, nuke it
 }
@@ -163,10 +181,22 @@ type BlockChain struct {
 	chainConfig *params.ChainConfig // Chain & network configuration
 	cacheConfig *CacheConfig        // Cache configuration for pruning
 
-	db     ethdb.Database // Low level persistent database to store final content in
-	snaps  *snapshot.Tree // Snapshot tree for fast trie leaf access
-	triegc *prque.Prque   // Priority queue mapping block numbers to tries to gc
-	gcproc time.Duration  // Accumulates canonical block processing for trie dumping
+	db     ethdb.Database       // Low level persistent database to store final content in
+	snaps  *snapshot.Tree       // Snapshot tree for fast trie leaf access
+	prover *state.Prover        // Proof service retaining the tries of the snapshot's diff window
+	pruner *pruner.OnlinePruner // Background pruner of stale state, nil if disabled
+	triegc *prque.Prque         // Priority queue mapping block numbers to tries to gc
+	gcproc time.Duration        // Accumulates canonical block processing for trie dumping
+
+	stateDiffs     ethdb.AncientStore // Reverse state diffs of the recent canonical blocks, nil if disabled
+	stateDiffCache *lru.Cache         // Reverse state diffs of the recent blocks, until they become canonical
+
+	archive        ethdb.AncientStore // Flat state diffs of the archived canonical blocks, nil if disabled
+	archiveCache   *lru.Cache         // Flat state diffs of the recent blocks, until they become canonical
+	archiveLock    sync.RWMutex       // Lock protecting the archive range against concurrent readers
+	archiveSeeding chan struct{}      // Channel closed when the initial seeding of the archive finishes
+
+	preimages *rawdb.PreimageStore // Append-only store of the preimages of hashed keys, nil if disabled
 
 	// txLookupLimit is the maximum number of blocks from head whose tx indices
 	// are reserved:
@@ -221,6 +251,14 @@ func NewBlockChain(db ethdb.Database, cacheConfig *CacheConfig, chainConfig *par
 	if cacheConfig == nil {
 		cacheConfig = defaultCacheConfig
 	}
+	var preimages *rawdb.PreimageStore
+	if cacheConfig.PreimageDir != "" {
+		store, err := rawdb.NewPreimageStore(db, cacheConfig.PreimageDir, false)
+		if err != nil {
+			return nil, err
+		}
+		preimages = store
+	}
 	bodyCache, _ := lru.New(bodyCacheLimit)
 	bodyRLPCache, _ := lru.New(bodyCacheLimit)
 	receiptsCache, _ := lru.New(receiptsCacheLimit)
@@ -234,10 +272,13 @@ func NewBlockChain(db ethdb.Database, cacheConfig *CacheConfig, chainConfig *par
 		db:          db,
 		triegc:      prque.New(nil),
 		stateCache: state.NewDatabaseWithConfig(db, &trie.Config{
-			Cache:     cacheConfig.TrieCleanLimit,
-			Journal:   cacheConfig.TrieCleanJournal,
-			Preimages: cacheConfig.Preimages,
+			Cache:         cacheConfig.TrieCleanLimit,
+			Journal:       cacheConfig.TrieCleanJournal,
+			Preimages:     cacheConfig.Preimages,
+			Scheme:        cacheConfig.StateScheme,
+			PreimageStore: preimages,
 		}),
+		preimages:      preimages,
 		quit:           make(chan struct{}),
 		chainmu:        syncx.NewClosableMutex(),
 		shouldPreserve: shouldPreserve,
@@ -380,7 +421,43 @@ func NewBlockChain(db ethdb.Database, cacheConfig *CacheConfig, chainConfig *par
 		}
 		bc.snaps, _ = snapshot.New(bc.db, bc.stateCache.TrieDB(), bc.cacheConfig.SnapshotLimit, head.Root(), !bc.cacheConfig.SnapshotWait, true, recover)
 	}
+	bc.prover = state.NewProver(bc.stateCache, bc.snaps, TriesInMemory)
+
+	// Set up the online state pruner, resuming any interrupted pruning cycle.
+	// The path scheme overwrites stale nodes in place, so it needs no pruning.
+	if bc.cacheConfig.OnlinePruning && bc.stateCache.TrieDB().Scheme() == rawdb.PathScheme {
+		log.Warn("Online state pruning is not supported by the path scheme, disabling")
+	} else if bc.cacheConfig.OnlinePruning && !bc.cacheConfig.TrieDirtyDisabled {
+		bc.pruner = pruner.NewOnlinePruner(bc.db, bc.stateCache.TrieDB(), bc.snaps, pruner.OnlinePrunerConfig{
+			Interval:  bc.cacheConfig.OnlinePruningInterval,
+			BloomSize: bc.cacheConfig.OnlinePruningBloomSize,
+			BloomPath: bc.cacheConfig.OnlinePruningBloom,
+			Throttle:  bc.cacheConfig.OnlinePruningThrottle,
+		})
+		if err := bc.pruner.Resume(bc.CurrentBlock().Root()); err != nil {
+			log.Error("Failed to resume state pruning", "err", err)
+		}
+	}
 
+	// Open the reverse state diff store, aligning it with the current chain. The
+	// path scheme keeps reverse diffs of its own, so these are not needed.
+	if bc.cacheConfig.StateDiffs > 0 && bc.stateCache.TrieDB().Scheme() == rawdb.PathScheme {
+		log.Warn("Reverse state diffs are not supported by the path scheme, disabling")
+	} else if bc.cacheConfig.StateDiffs > 0 {
+		if bc.stateDiffs, err = rawdb.NewStateDiffFreezer(bc.cacheConfig.StateDiffDir, false); err != nil {
+			return nil, err
+		}
+		bc.stateDiffCache, _ = lru.New(stateDiffCacheLimit)
+		bc.updateStateDiffs(bc.CurrentBlock())
+	}
+	// Open the state archive, which is fed from the snapshot updates
+	if bc.cacheConfig.StateArchive && bc.snaps == nil {
+		log.Warn("State archive requires snapshots, disabling")
+	} else if bc.cacheConfig.StateArchive {
+		if err := bc.openArchive(); err != nil {
+			return nil, err
+		}
+	}
 	// Start future block processor.
 	bc.wg.Add(1)
 	go bc.futureBlocksLoop()
@@ -614,6 +691,13 @@ func (bc *BlockChain) SetHeadBeyondRoot(head uint64, root common.Hash) (uint64,
 		}
 		// Todo(rjl493456442) txlookup, bloombits, etc
 	}
+	// If the state of the new head was already pruned, try to regenerate it from
+	// the reverse state diffs so the rewind can stop right there
+	if current := bc.CurrentBlock(); head < current.NumberU64() {
+		if header := bc.GetHeaderByNumber(head); header != nil && !bc.HasState(header.Root) {
+			bc.recoverState(header)
+		}
+	}
 	// If SetHead was only called as a chain reparation method, try to skip
 	// touching the header chain altogether, unless the freezer is broken
 	if block := bc.CurrentBlock(); block.NumberU64() == head {
@@ -634,7 +718,12 @@ func (bc *BlockChain) SetHeadBeyondRoot(head uint64, root common.Hash) (uint64,
 	bc.txLookupCache.Purge()
 	bc.futureBlocks.Purge()
 
-	return rootNumber, bc.loadLastState()
+	if err := bc.loadLastState(); err != nil {
+		return rootNumber, err
+	}
+	bc.updateStateDiffs(bc.CurrentBlock())
+	bc.updateArchive(bc.CurrentBlock())
+	return rootNumber, nil
 }
 
 // FastSyncCommitHead sets the current head block to the one defined by the hash
@@ -661,6 +750,7 @@ func (bc *BlockChain) FastSyncCommitHead(hash common.Hash) error {
 	// also resuming the normal maintenance of any previously paused snapshot.
 	if bc.snaps != nil {
 		bc.snaps.Rebuild(block.Root())
+		bc.prover.Release()
 	}
 	log.Info("Committed new head block", "number", block.Number(), "hash", hash)
 	return nil
@@ -682,6 +772,11 @@ func (bc *BlockChain) Snapshots() *snapshot.Tree {
 	return bc.snaps
 }
 
+// Prover returns the proof service of the recent states.
+func (bc *BlockChain) Prover() *state.Prover {
+	return bc.prover
+}
+
 // CurrentFastBlock retrieves the current fast-sync head block of the canonical
 // chain. The block is retrieved from the blockchain's internal cache.
 func (bc *BlockChain) CurrentFastBlock() *types.Block {
@@ -705,7 +800,17 @@ func (bc *BlockChain) State() (*state.StateDB, error) {
 
 // StateAt returns a new mutable state based on a particular point in time.
 func (bc *BlockChain) StateAt(root common.Hash) (*state.StateDB, error) {
-	return state.New(root, bc.stateCache, bc.snaps)
+	statedb, err := state.New(root, bc.stateCache, bc.snaps)
+	if err != nil {
+		return nil, err
+	}
+	if bc.stateDiffs != nil {
+		statedb.TrackReverseDiff()
+	}
+	if bc.archive != nil {
+		statedb.TrackFlatDiff()
+	}
+	return statedb, nil
 }
 
 // StateCache returns the caching database underpinning the blockchain instance.
@@ -817,6 +922,9 @@ func (bc *BlockChain) writeHeadBlock(block *types.Block) {
 	}
 	bc.currentBlock.Store(block)
 	headBlockGauge.Update(int64(block.NumberU64()))
+
+	bc.updateStateDiffs(block)
+	bc.updateArchive(block)
 }
 
 // Genesis retrieves the chain's genesis block.
@@ -1030,6 +1138,10 @@ func (bc *BlockChain) Stop() {
 	bc.chainmu.Close()
 	bc.wg.Wait()
 
+	// Suspend any running state pruning, it's continued on the next startup.
+	if bc.pruner != nil {
+		bc.pruner.Stop()
+	}
 	// Ensure that the entirety of the state snapshot is journalled to disk.
 	var snapBase common.Hash
 	if bc.snaps != nil {
@@ -1047,7 +1159,12 @@ func (bc *BlockChain) Stop() {
 	if !bc.cacheConfig.TrieDirtyDisabled {
 		triedb := bc.stateCache.TrieDB()
 
-		for _, offset := range []uint64{0, 1, TriesInMemory - 1} {
+		// The path scheme can only persist a single state, so keep the HEAD
+		offsets := []uint64{0, 1, TriesInMemory - 1}
+		if triedb.Scheme() == rawdb.PathScheme {
+			offsets, snapBase = []uint64{0}, common.Hash{}
+		}
+		for _, offset := range offsets {
 			if number := bc.CurrentBlock().NumberU64(); number > offset {
 				recent := bc.GetBlockByNumber(number - offset)
 
@@ -1066,7 +1183,8 @@ func (bc *BlockChain) Stop() {
 		for !bc.triegc.Empty() {
 			triedb.Dereference(bc.triegc.PopItem().(common.Hash))
 		}
-		if size, _ := triedb.Size(); size != 0 {
+		bc.prover.Release()
+		if size, _ := triedb.Size(); size != 0 && triedb.Scheme() == rawdb.HashScheme {
 			log.Error("Dangling trie nodes after full cleanup")
 		}
 	}
@@ -1076,6 +1194,21 @@ func (bc *BlockChain) Stop() {
 		triedb := bc.stateCache.TrieDB()
 		triedb.SaveCache(bc.cacheConfig.TrieCleanJournal)
 	}
+	if bc.stateDiffs != nil {
+		if err := bc.stateDiffs.Close(); err != nil {
+			log.Error("Failed to close reverse state diff store", "err", err)
+		}
+	}
+	if bc.archive != nil {
+		if err := bc.archive.Close(); err != nil {
+			log.Error("Failed to close state archive", "err", err)
+		}
+	}
+	if bc.preimages != nil {
+		if err := bc.preimages.Close(); err != nil {
+			log.Error("Failed to close preimage store", "err", err)
+		}
+	}
 	log.Info("Blockchain stopped")
 }
 
@@ -1376,6 +1509,11 @@ func (bc *BlockChain) InsertReceiptChain(blockChain types.Blocks, receiptChain [
 		}
 	}
 
+	// Without executing the blocks, only the preimages of the addresses seen in
+	// them are known for the hashes of the synced state
+	if bc.preimages != nil {
+		bc.writeSyncPreimages(blockChain, receiptChain)
+	}
 	head := blockChain[len(blockChain)-1]
 	context := []interface{}{
 		"count", stats.processed, "elapsed", common.PrettyDuration(time.Since(start)),
@@ -1440,24 +1578,20 @@ func (bc *BlockChain) WriteBlockWithState(block *types.Block, receipts []*types.
 		return NonStatTy, errInsertionInterrupted
 	}
 	defer bc.chainmu.Unlock()
-	return bc.writeBlockWithState(block, receipts, logs, state, emitHeadEvent)
+	return bc.writeBlockAndSetHead(block, receipts, logs, state, emitHeadEvent)
 }
 
 // writeBlockWithState writes the block and all associated state to the database,
-// but is expects the chain mutex to be held.
-func (bc *BlockChain) writeBlockWithState(block *types.Block, receipts []*types.Receipt, logs []*types.Log, state *state.StateDB, emitHeadEvent bool) (status WriteStatus, err error) {
+// without touching the canonical chain. It expects the chain mutex to be held.
+func (bc *BlockChain) writeBlockWithState(block *types.Block, receipts []*types.Receipt, logs []*types.Log, state *state.StateDB) error {
 	if bc.insertStopped() {
-		return NonStatTy, errInsertionInterrupted
+		return errInsertionInterrupted
 	}
-
 	// Calculate the total difficulty of the block
 	ptd := bc.GetTd(block.ParentHash(), block.NumberU64()-1)
 	if ptd == nil {
-		return NonStatTy, consensus.ErrUnknownAncestor
+		return consensus.ErrUnknownAncestor
 	}
-	// Make sure no inconsistent state is leaked during insertion
-	currentBlock := bc.CurrentBlock()
-	localTd := bc.GetTd(currentBlock.Hash(), currentBlock.NumberU64())
 	externTd := new(big.Int).Add(block.Difficulty(), ptd)
 
 	// Irrelevant of the canonical status, write the block itself to the database.
@@ -1468,27 +1602,66 @@ func (bc *BlockChain) writeBlockWithState(block *types.Block, receipts []*types.
 	rawdb.WriteTd(blockBatch, block.Hash(), block.NumberU64(), externTd)
 	rawdb.WriteBlock(blockBatch, block)
 	rawdb.WriteReceipts(blockBatch, block.Hash(), block.NumberU64(), receipts)
-	rawdb.WritePreimages(blockBatch, state.Preimages())
+	if bc.preimages != nil {
+		if err := bc.preimages.Write(state.Preimages()); err != nil {
+			log.Crit("Failed to write preimages into disk", "err", err)
+		}
+	} else {
+		rawdb.WritePreimages(blockBatch, state.Preimages())
+	}
 	if err := blockBatch.Write(); err != nil {
 		log.Crit("Failed to write block into disk", "err", err)
 	}
 	// Commit all cached state changes into underlying memory database.
 	root, err := state.Commit(bc.chainConfig.IsEIP158(block.Number()))
 	if err != nil {
-		return NonStatTy, err
+		return err
 	}
+	bc.cacheStateDiff(block, state)
+	bc.cacheArchiveDiff(block, state)
+
 	triedb := bc.stateCache.TrieDB()
 
 	// If we're running an archive node, always flush
 	if bc.cacheConfig.TrieDirtyDisabled {
 		if err := triedb.Commit(root, false, nil); err != nil {
-			return NonStatTy, err
+			return err
+		}
+	} else if triedb.Scheme() == rawdb.PathScheme {
+		// Path based node storage, the recent states are tracked in memory on top
+		// of the persisted one, flatten the canonical state leaving the window
+		if current := block.NumberU64(); current > TriesInMemory {
+			chosen := current - TriesInMemory
+
+			// If the header is missing (canonical chain behind), we're reorging a low
+			// diff sidechain. Suspend committing until this operation is completed.
+			if header := bc.GetHeaderByNumber(chosen); header == nil {
+				log.Warn("Reorg in progress, trie commit postponed", "number", chosen)
+			} else if err := triedb.Commit(header.Root, false, nil); err != nil {
+				return err
+			}
+			// If we exceeded our memory allowance, flush the oldest states to disk
+			var (
+				nodes, imgs = triedb.Size()
+				limit       = common.StorageSize(bc.cacheConfig.TrieDirtyLimit) * 1024 * 1024
+			)
+			if nodes > limit || imgs > 4*1024*1024 {
+				triedb.Cap(limit - ethdb.IdealBatchSize)
+			}
 		}
 	} else {
 		// Full but not archive node, do proper garbage collection
 		triedb.Reference(root, common.Hash{}) // metadata reference to keep trie alive
 		bc.triegc.Push(root, -int64(block.NumberU64()))
 
+		// Keep the state provable for as long as the snapshot tracks it
+		bc.prover.Retain(root)
+
+		// Protect the new state from the online pruner before any of it hits
+		// the disk.
+		if bc.pruner != nil {
+			bc.pruneState(block, root)
+		}
 		if current := block.NumberU64(); current > TriesInMemory {
 			// If we exceeded our memory allowance, flush matured singleton nodes to disk
 			var (
@@ -1518,6 +1691,12 @@ func (bc *BlockChain) writeBlockWithState(block *types.Block, receipts []*types.
 					triedb.Commit(header.Root, true, nil)
 					lastWrite = chosen
 					bc.gcproc = 0
+
+					// The flushed state is complete on disk, use it as the target
+					// of a new pruning cycle if one is due.
+					if bc.pruner != nil && bc.pruner.Due(chosen) {
+						bc.startPruning(header, current, root)
+					}
 				}
 			}
 			// Garbage collect anything below our required write retention
@@ -1531,12 +1710,34 @@ func (bc *BlockChain) writeBlockWithState(block *types.Block, receipts []*types.
 			}
 		}
 	}
+	return nil
+}
+
+// writeBlockAndSetHead writes the block and all associated state to the database,
+// and also applies the block as the new chain head if its total difficulty is
+// higher than the current one's. It expects the chain mutex to be held.
+func (bc *BlockChain) writeBlockAndSetHead(block *types.Block, receipts []*types.Receipt, logs []*types.Log, state *state.StateDB, emitHeadEvent bool) (status WriteStatus, err error) {
+	// Make sure no inconsistent state is leaked during insertion
+	currentBlock := bc.CurrentBlock()
+	localTd := bc.GetTd(currentBlock.Hash(), currentBlock.NumberU64())
+
+	if err := bc.writeBlockWithState(block, receipts, logs, state); err != nil {
+		return NonStatTy, err
+	}
+	externTd := bc.GetTd(block.Hash(), block.NumberU64())
+
 	// If the total difficulty is higher than our known, add it to the canonical chain
 	// Second clause in the if statement reduces the vulnerability to selfish mining.
 	// Please refer to http://www.cs.cornell.edu/~ie53/publications/btcProcFC.pdf
-	reorg := externTd.Cmp(localTd) > 0
+	//
+	// Past the terminal total difficulty all blocks have zero difficulty, so the
+	// total difficulty can't pick the head anymore. Proof-of-stake blocks are only
+	// stored, the head being set by the consensus layer via SetChainHead.
+	merged := bc.ttdReached(localTd) || bc.ttdReached(new(big.Int).Sub(externTd, block.Difficulty()))
+
+	reorg := !merged && externTd.Cmp(localTd) > 0
 	currentBlock = bc.CurrentBlock()
-	if !reorg && externTd.Cmp(localTd) == 0 {
+	if !merged && !reorg && externTd.Cmp(localTd) == 0 {
 		// Split same-difficulty blocks by number, then preferentially select
 		// the block generated by the local miner as the canonical block.
 		if block.NumberU64() < currentBlock.NumberU64() {
@@ -1585,6 +1786,44 @@ func (bc *BlockChain) writeBlockWithState(block *types.Block, receipts []*types.
 	return status, nil
 }
 
+// pruneState protects a newly committed state from the running online pruning
+// cycle, if any, by collecting its nodes into the cycle's bloom filter.
+func (bc *BlockChain) pruneState(block *types.Block, root common.Hash) {
+	if !bc.pruner.Active() {
+		return
+	}
+	number := block.NumberU64()
+	parent := bc.GetHeader(block.ParentHash(), number-1)
+	if parent == nil {
+		return // can't happen, the parent was just validated
+	}
+	if err := bc.pruner.Mark(parent.Root, root); err != nil {
+		log.Error("Online state pruning aborted", "number", number, "err", err)
+	}
+}
+
+// startPruning starts a new online pruning cycle targeting the state of a block
+// just flushed to disk. All the states still referenced from memory, side chains
+// included, are protected alongside, starting with the block being imported.
+func (bc *BlockChain) startPruning(target *types.Header, current uint64, root common.Hash) {
+	window := []common.Hash{root}
+	for n := current; n > target.Number.Uint64(); n-- {
+		for _, hash := range rawdb.ReadAllHashes(bc.db, n) {
+			if header := bc.GetHeader(hash, n); header != nil {
+				window = append(window, header.Root)
+			}
+		}
+	}
+	// An unfinished snapshot is generated from the state of its disk layer, which
+	// may lag behind the in-memory tries, keep it alive to continue after the sweep.
+	if bc.snaps != nil && bc.snaps.Generating() {
+		window = append(window, bc.snaps.DiskRoot())
+	}
+	if err := bc.pruner.Start(target.Root, target.Number.Uint64(), window); err != nil {
+		log.Error("Failed to start online state pruning", "number", target.Number, "err", err)
+	}
+}
+
 // addFutureBlock checks if the block is within the max allowed window to get
 // accepted for future processing, and returns an error if the block is too far
 // ahead and was not added.
@@ -1633,7 +1872,7 @@ func (bc *BlockChain) InsertChain(chain types.Blocks) (int, error) {
 		return 0, errChainStopped
 	}
 	defer bc.chainmu.Unlock()
-	return bc.insertChain(chain, true)
+	return bc.insertChain(chain, true, true)
 }
 
 // InsertChainWithoutSealVerification works exactly the same
@@ -1646,7 +1885,70 @@ func (bc *BlockChain) InsertChainWithoutSealVerification(block *types.Block) (in
 		return 0, errChainStopped
 	}
 	defer bc.chainmu.Unlock()
-	return bc.insertChain(types.Blocks([]*types.Block{block}), false)
+	return bc.insertChain(types.Blocks([]*types.Block{block}), false, true)
+}
+
+// InsertBlockWithoutSetHead executes the block, runs the necessary verification
+// upon it and then persists the block and the associated state into the database.
+// Unlike InsertChain, it doesn't update the canonical chain, relying on a later
+// SetChainHead call to do so.
+func (bc *BlockChain) InsertBlockWithoutSetHead(block *types.Block) error {
+	if !bc.chainmu.TryLock() {
+		return errChainStopped
+	}
+	defer bc.chainmu.Unlock()
+
+	_, err := bc.insertChain(types.Blocks([]*types.Block{block}), true, false)
+	return err
+}
+
+// ttdReached returns whether the given total difficulty reached the terminal
+// total difficulty of the chain, if any.
+func (bc *BlockChain) ttdReached(td *big.Int) bool {
+	ttd := bc.chainConfig.TerminalTotalDifficulty
+	return ttd != nil && td.Cmp(ttd) >= 0
+}
+
+// TTDReached returns whether the current head block reached the terminal total
+// difficulty. From then on the head is only moved by SetChainHead, blocks from
+// the network being ignored.
+func (bc *BlockChain) TTDReached() bool {
+	current := bc.CurrentBlock()
+	td := bc.GetTd(current.Hash(), current.NumberU64())
+	return td != nil && bc.ttdReached(td)
+}
+
+// SetChainHead sets the given block as the new chain head, reorganising the
+// canonical chain if the block isn't a child of the current head. The block
+// and all its ancestors must already be present in the database.
+func (bc *BlockChain) SetChainHead(head *types.Block) error {
+	if !bc.chainmu.TryLock() {
+		return errChainStopped
+	}
+	defer bc.chainmu.Unlock()
+
+	// Run the reorg if necessary and set the given block as new head
+	start := time.Now()
+	if head.ParentHash() != bc.CurrentBlock().Hash() {
+		if err := bc.reorg(bc.CurrentBlock(), head); err != nil {
+			return err
+		}
+	}
+	bc.writeHeadBlock(head)
+
+	// Emit the events of the new head
+	var logs []*types.Log
+	for _, receipt := range rawdb.ReadReceipts(bc.db, head.Hash(), head.NumberU64(), bc.chainConfig) {
+		logs = append(logs, receipt.Logs...)
+	}
+	bc.chainFeed.Send(ChainEvent{Block: head, Hash: head.Hash(), Logs: logs})
+	if len(logs) > 0 {
+		bc.logsFeed.Send(logs)
+	}
+	bc.chainHeadFeed.Send(ChainHeadEvent{Block: head})
+
+	log.Info("Set the chain head", "number", head.Number(), "hash", head.Hash(), "elapsed", common.PrettyDuration(time.Since(start)))
+	return nil
 }
 
 // insertChain is the internal implementation of InsertChain, which assumes that
@@ -1657,7 +1959,10 @@ func (bc *BlockChain) InsertChainWithoutSealVerification(block *types.Block) (in
 // racey behaviour. If a sidechain import is in progress, and the historic state
 // is imported, but then new canon-head is added before the actual sidechain
 // completes, then the historic state could be pruned again
-func (bc *BlockChain) insertChain(chain types.Blocks, verifySeals bool) (int, error) {
+//
+// If setHead is false, the blocks are only written into the database, leaving
+// the canonical chain untouched.
+func (bc *BlockChain) insertChain(chain types.Blocks, verifySeals, setHead bool) (int, error) {
 	// If the chain is terminating, don't even bother starting up.
 	if bc.insertStopped() {
 		return 0, nil
@@ -1829,7 +2134,7 @@ func (bc *BlockChain) insertChain(chain types.Blocks, verifySeals bool) (int, er
 		if parent == nil {
 			parent = bc.GetHeader(block.ParentHash(), block.NumberU64()-1)
 		}
-		statedb, err := state.New(parent.Root, bc.stateCache, bc.snaps)
+		statedb, err := bc.StateAt(parent.Root)
 		if err != nil {
 			return it.index, err
 		}
@@ -1895,7 +2200,13 @@ func (bc *BlockChain) insertChain(chain types.Blocks, verifySeals bool) (int, er
 
 		// Write the block to the chain and get the status.
 		substart = time.Now()
-		status, err := bc.writeBlockWithState(block, receipts, logs, statedb, false)
+		var status WriteStatus
+		if !setHead {
+			// Don't set the head, only insert the block
+			err = bc.writeBlockWithState(block, receipts, logs, statedb)
+		} else {
+			status, err = bc.writeBlockAndSetHead(block, receipts, logs, statedb, false)
+		}
 		atomic.StoreUint32(&followupInterrupt, 1)
 		if err != nil {
 			return it.index, err
@@ -1908,6 +2219,15 @@ func (bc *BlockChain) insertChain(chain types.Blocks, verifySeals bool) (int, er
 		blockWriteTimer.Update(time.Since(substart) - statedb.AccountCommits - statedb.StorageCommits - statedb.SnapshotCommits)
 		blockInsertTimer.UpdateSince(start)
 
+		if !setHead {
+			// The canonical status of the block is unknown until its head is set
+			log.Debug("Inserted block without setting head", "number", block.Number(), "hash", block.Hash(),
+				"txs", len(block.Transactions()), "gas", block.GasUsed(), "elapsed", common.PrettyDuration(time.Since(start)))
+
+			stats.processed++
+			stats.usedGas += usedGas
+			continue
+		}
 		switch status {
 		case CanonStatTy:
 			log.Debug("Inserted new block", "number", block.Number(), "hash", block.Hash(),
@@ -2028,7 +2348,7 @@ func (bc *BlockChain) insertSideChain(block *types.Block, it *insertIterator) (i
 	// If the externTd was larger than our local TD, we now need to reimport the previous
 	// blocks to regenerate the required state
 	localTd := bc.GetTd(current.Hash(), current.NumberU64())
-	if localTd.Cmp(externTd) > 0 {
+	if localTd.Cmp(externTd) > 0 || bc.ttdReached(localTd) {
 		log.Info("Sidechain written to disk", "start", it.first().NumberU64(), "end", it.previous().Number, "sidetd", externTd, "localtd", localTd)
 		return it.index, err
 	}
@@ -2038,7 +2358,7 @@ func (bc *BlockChain) insertSideChain(block *types.Block, it *insertIterator) (i
 		numbers []uint64
 	)
 	parent := it.previous()
-	for parent != nil && !bc.HasState(parent.Root) {
+	for parent != nil && !bc.HasState(parent.Root) && !bc.recoverState(parent) {
 		hashes = append(hashes, parent.Hash())
 		numbers = append(numbers, parent.Number.Uint64())
 
@@ -2064,7 +2384,7 @@ func (bc *BlockChain) insertSideChain(block *types.Block, it *insertIterator) (i
 		// memory here.
 		if len(blocks) >= 2048 || memory > 64*1024*1024 {
 			log.Info("Importing heavy sidechain segment", "blocks", len(blocks), "start", blocks[0].NumberU64(), "end", block.NumberU64())
-			if _, err := bc.insertChain(blocks, false); err != nil {
+			if _, err := bc.insertChain(blocks, false, true); err != nil {
 				return 0, err
 			}
 			blocks, memory = blocks[:0], 0
@@ -2078,7 +2398,7 @@ func (bc *BlockChain) insertSideChain(block *types.Block, it *insertIterator) (i
 	}
 	if len(blocks) > 0 {
 		log.Info("Importing sidechain segment", "start", blocks[0].NumberU64(), "end", blocks[len(blocks)-1].NumberU64())
-		return bc.insertChain(blocks, false)
+		return bc.insertChain(blocks, false, true)
 	}
 	return 0, nil
 }
@@ -2199,6 +2519,11 @@ func (bc *BlockChain) reorg(oldBlock, newBlock *types.Block) error {
 		blockReorgAddMeter.Mark(int64(len(newChain)))
 		blockReorgDropMeter.Mark(int64(len(oldChain)))
 		blockReorgMeter.Mark(1)
+	} else if len(newChain) > 0 {
+		// The new head descends from the current one without being its child,
+		// which happens when the canonical head is set externally
+		log.Info("Extend chain", "add", len(newChain), "number", newChain[0].Number(), "hash", newChain[0].Hash())
+		blockReorgAddMeter.Mark(int64(len(newChain)))
 	} else {
 		log.Error("Impossible reorg, please file an issue", "oldnum", oldBlock.Number(), "oldhash", oldBlock.Hash(), "newnum", newBlock.Number(), "newhash", newBlock.Hash())
 	}
@@ -0,0 +1,381 @@
+// Copyright 2021 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package core
+
+import (
+	"errors"
+	"os"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/core/state"
+	"github.com/ethereum/go-ethereum/core/state/snapshot"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/rlp"
+	"github.com/ethereum/go-ethereum/trie"
+	lru "github.com/hashicorp/golang-lru"
+)
+
+var (
+	// errArchiveDisabled is returned if a historical state is requested but the
+	// state archive is not enabled.
+	errArchiveDisabled = errors.New("state archive disabled")
+
+	// errNotArchived is returned if a historical state is requested for a block
+	// outside of the range covered by the state archive.
+	errNotArchived = errors.New("state not archived")
+
+	// errArchiveSeedingAborted is returned if the seeding of the state archive is
+	// interrupted by the chain shutting down.
+	errArchiveSeedingAborted = errors.New("state archive seeding aborted")
+
+	// ErrArchiveSeeding is returned if a historical state is requested while the
+	// state archive is still being seeded in the background.
+	ErrArchiveSeeding = errors.New("state archive not yet available")
+)
+
+// openArchive opens the state archive and aligns it with the current chain. An
+// empty archive is started at the current head, indexing its entire state in the
+// background.
+func (bc *BlockChain) openArchive() error {
+	archive, err := rawdb.NewArchiveFreezer(bc.cacheConfig.StateArchiveDir, false)
+	if err != nil {
+		return err
+	}
+	bc.archive = archive
+	bc.archiveCache, _ = lru.New(archiveCacheLimit)
+	bc.archiveSeeding = make(chan struct{})
+
+	var (
+		items, _ = archive.Ancients()
+		tail, _  = archive.Tail()
+	)
+	if items == tail {
+		head := bc.CurrentBlock()
+		if !bc.HasState(head.Root()) {
+			log.Warn("State archive cannot be started without the head state", "number", head.Number(), "hash", head.Hash())
+			close(bc.archiveSeeding)
+			return nil
+		}
+		bc.wg.Add(1)
+		go func() {
+			defer bc.wg.Done()
+			defer close(bc.archiveSeeding)
+
+			if err := bc.seedArchive(head); err != nil {
+				log.Error("Failed to seed state archive", "err", err)
+				return
+			}
+			// Catch up with the blocks imported meanwhile, their diffs are cached
+			bc.updateArchive(bc.CurrentBlock())
+		}()
+		return nil
+	}
+	close(bc.archiveSeeding)
+
+	// The index of the last archived block might not have been written if the
+	// node crashed, do it again to be on the safe side
+	hash, enc := rawdb.ReadArchiveDiff(archive, items-1)
+	diff := new(state.FlatDiff)
+	if err := rlp.DecodeBytes(enc, diff); err != nil {
+		log.Error("Invalid archived state diff", "number", items-1, "hash", hash, "err", err)
+		return bc.resetArchive()
+	}
+	batch := bc.db.NewBatch()
+	indexArchiveDiff(batch, items-1, diff)
+	if err := batch.Write(); err != nil {
+		return err
+	}
+	bc.updateArchive(bc.CurrentBlock())
+	return nil
+}
+
+// seedArchive starts the archive at the given block by indexing its entire
+// state. The state must be available on disk, as the seeding runs concurrently
+// with the chain garbage collecting its in-memory tries.
+func (bc *BlockChain) seedArchive(head *types.Block) error {
+	// Drop any leftovers of an interrupted seeding first
+	if err := rawdb.DeleteArchiveIndex(bc.db); err != nil {
+		return err
+	}
+	var (
+		number  = head.NumberU64()
+		start   = time.Now()
+		logged  = time.Now()
+		batch   = bc.db.NewBatch()
+		account types.StateAccount
+
+		accounts, slots int
+	)
+	log.Info("Seeding state archive", "number", number, "hash", head.Hash(), "root", head.Root())
+
+	accTrie, err := bc.stateCache.OpenTrie(head.Root())
+	if err != nil {
+		return err
+	}
+	accIt := trie.NewIterator(accTrie.NodeIterator(nil))
+	for accIt.Next() {
+		if err := rlp.DecodeBytes(accIt.Value, &account); err != nil {
+			return err
+		}
+		accHash := common.BytesToHash(accIt.Key)
+		rawdb.WriteArchiveAccount(batch, accHash, number, snapshot.SlimAccountRLP(account.Nonce, account.Balance, account.Root, account.CodeHash))
+		accounts++
+
+		if account.Root != types.EmptyRootHash {
+			stTrie, err := bc.stateCache.OpenStorageTrie(accHash, account.Root)
+			if err != nil {
+				return err
+			}
+			stIt := trie.NewIterator(stTrie.NodeIterator(nil))
+			for stIt.Next() {
+				rawdb.WriteArchiveStorage(batch, accHash, common.BytesToHash(stIt.Key), number, common.CopyBytes(stIt.Value))
+				slots++
+
+				if batch.ValueSize() > ethdb.IdealBatchSize {
+					if err := batch.Write(); err != nil {
+						return err
+					}
+					batch.Reset()
+				}
+			}
+			if stIt.Err != nil {
+				return stIt.Err
+			}
+		}
+		if batch.ValueSize() > ethdb.IdealBatchSize {
+			if err := batch.Write(); err != nil {
+				return err
+			}
+			batch.Reset()
+		}
+		if time.Since(logged) > 8*time.Second {
+			log.Info("Seeding state archive", "accounts", accounts, "slots", slots, "elapsed", common.PrettyDuration(time.Since(start)))
+			logged = time.Now()
+		}
+		select {
+		case <-bc.quit:
+			return errArchiveSeedingAborted
+		default:
+		}
+	}
+	if accIt.Err != nil {
+		return accIt.Err
+	}
+	if err := batch.Write(); err != nil {
+		return err
+	}
+	// Mark the seeding complete by storing the first archive item, which has
+	// no diff of its own
+	bc.archiveLock.Lock()
+	defer bc.archiveLock.Unlock()
+
+	if number > 0 {
+		if err := bc.archive.TruncateTail(number); err != nil {
+			return err
+		}
+	}
+	enc, _ := rlp.EncodeToBytes(new(state.FlatDiff))
+	if err := rawdb.WriteArchiveDiff(bc.archive, number, head.Hash(), enc); err != nil {
+		return err
+	}
+	log.Info("Seeded state archive", "number", number, "accounts", accounts, "slots", slots, "elapsed", common.PrettyDuration(time.Since(start)))
+	return nil
+}
+
+// resetArchive deletes the entire state archive. It's seeded again on the next
+// startup.
+func (bc *BlockChain) resetArchive() error {
+	if err := bc.archive.Close(); err != nil {
+		return err
+	}
+	if err := os.RemoveAll(bc.cacheConfig.StateArchiveDir); err != nil {
+		return err
+	}
+	archive, err := rawdb.NewArchiveFreezer(bc.cacheConfig.StateArchiveDir, false)
+	if err != nil {
+		return err
+	}
+	bc.archive = archive
+	return rawdb.DeleteArchiveIndex(bc.db)
+}
+
+// cacheArchiveDiff keeps the flat diff of a block's state transition in memory
+// until the block becomes canonical and the diff can be archived.
+func (bc *BlockChain) cacheArchiveDiff(block *types.Block, statedb *state.StateDB) {
+	if bc.archive == nil {
+		return
+	}
+	diff := statedb.FlatDiff()
+	if diff == nil {
+		return
+	}
+	enc, err := rlp.EncodeToBytes(diff)
+	if err != nil {
+		log.Error("Failed to encode flat state diff", "number", block.Number(), "hash", block.Hash(), "err", err)
+		return
+	}
+	bc.archiveCache.Add(block.Hash(), enc)
+}
+
+// updateArchive aligns the state archive with the canonical chain ending in the
+// given head: the diffs of reorged blocks are removed along with their index,
+// while the ones of the new canonical blocks are archived and indexed.
+//
+// If a diff is missing, the archive is reset, as historical states can only be
+// resolved from a contiguous sequence of diffs.
+func (bc *BlockChain) updateArchive(head *types.Block) {
+	if bc.archive == nil {
+		return
+	}
+	bc.archiveLock.Lock()
+	defer bc.archiveLock.Unlock()
+
+	var (
+		number   = head.NumberU64()
+		items, _ = bc.archive.Ancients()
+		tail, _  = bc.archive.Tail()
+	)
+	if items == tail {
+		return // Empty archive, seeded in the background or on the next startup
+	}
+	// Remove the diffs above the head and the ones of non-canonical blocks
+	for items > tail {
+		if items <= number+1 {
+			if hash, _ := rawdb.ReadArchiveDiff(bc.archive, items-1); hash == rawdb.ReadCanonicalHash(bc.db, items-1) {
+				break
+			}
+		}
+		if !bc.unarchive(items - 1) {
+			return
+		}
+		items--
+	}
+	// If even the seeded state was removed, the archive cannot be continued
+	if items == tail {
+		log.Warn("State archive rewound past its start, resetting", "number", number, "start", tail)
+		if err := bc.resetArchive(); err != nil {
+			log.Error("Failed to reset state archive", "err", err)
+		}
+		return
+	}
+	// Archive and index the diffs of the new canonical blocks
+	for ; items <= number; items++ {
+		hash := rawdb.ReadCanonicalHash(bc.db, items)
+		enc, ok := bc.archiveCache.Get(hash)
+		if !ok {
+			log.Warn("Flat state diff missing, resetting state archive", "number", items, "hash", hash)
+			if err := bc.resetArchive(); err != nil {
+				log.Error("Failed to reset state archive", "err", err)
+			}
+			return
+		}
+		diff := new(state.FlatDiff)
+		if err := rlp.DecodeBytes(enc.([]byte), diff); err != nil {
+			log.Error("Invalid flat state diff", "number", items, "hash", hash, "err", err)
+			return
+		}
+		if err := rawdb.WriteArchiveDiff(bc.archive, items, hash, enc.([]byte)); err != nil {
+			log.Error("Failed to archive flat state diff", "number", items, "hash", hash, "err", err)
+			return
+		}
+		batch := bc.db.NewBatch()
+		indexArchiveDiff(batch, items, diff)
+		if err := batch.Write(); err != nil {
+			log.Crit("Failed to index archived state diff", "number", items, "err", err)
+		}
+	}
+}
+
+// unarchive removes the last archived diff along with its index.
+func (bc *BlockChain) unarchive(number uint64) bool {
+	hash, enc := rawdb.ReadArchiveDiff(bc.archive, number)
+	diff := new(state.FlatDiff)
+	if err := rlp.DecodeBytes(enc, diff); err != nil {
+		log.Error("Invalid archived state diff", "number", number, "hash", hash, "err", err)
+		return false
+	}
+	batch := bc.db.NewBatch()
+	unindexArchiveDiff(batch, number, diff)
+	if err := batch.Write(); err != nil {
+		log.Crit("Failed to unindex archived state diff", "number", number, "err", err)
+	}
+	if err := bc.archive.TruncateAncients(number); err != nil {
+		log.Error("Failed to truncate state archive", "items", number, "err", err)
+		return false
+	}
+	return true
+}
+
+// indexArchiveDiff adds the items modified in a block to the archive index.
+func indexArchiveDiff(db ethdb.KeyValueWriter, number uint64, diff *state.FlatDiff) {
+	for _, hash := range diff.Destructs {
+		rawdb.WriteArchiveDestruct(db, hash, number)
+	}
+	for _, account := range diff.Accounts {
+		rawdb.WriteArchiveAccount(db, account.Hash, number, account.Data)
+	}
+	for _, storage := range diff.Storage {
+		for _, slot := range storage.Slots {
+			rawdb.WriteArchiveStorage(db, storage.Hash, slot.Hash, number, slot.Value)
+		}
+	}
+}
+
+// unindexArchiveDiff removes the items modified in a block from the archive
+// index.
+func unindexArchiveDiff(db ethdb.KeyValueWriter, number uint64, diff *state.FlatDiff) {
+	for _, hash := range diff.Destructs {
+		rawdb.DeleteArchiveDestruct(db, hash, number)
+	}
+	for _, account := range diff.Accounts {
+		rawdb.DeleteArchiveAccount(db, account.Hash, number)
+	}
+	for _, storage := range diff.Storage {
+		for _, slot := range storage.Slots {
+			rawdb.DeleteArchiveStorage(db, storage.Hash, slot.Hash, number)
+		}
+	}
+}
+
+// ArchivedStateAt returns a read only state of a canonical block resolved from
+// the state archive, without needing the state tries of the block.
+func (bc *BlockChain) ArchivedStateAt(header *types.Header) (*state.StateDB, error) {
+	if bc.archive == nil {
+		return nil, errArchiveDisabled
+	}
+	select {
+	case <-bc.archiveSeeding:
+	default:
+		return nil, ErrArchiveSeeding
+	}
+	bc.archiveLock.RLock()
+	defer bc.archiveLock.RUnlock()
+
+	var (
+		number   = header.Number.Uint64()
+		items, _ = bc.archive.Ancients()
+		tail, _  = bc.archive.Tail()
+	)
+	if number < tail || number >= items || rawdb.ReadCanonicalHash(bc.db, number) != header.Hash() {
+		return nil, errNotArchived
+	}
+	return state.NewArchived(header.Root, number, bc.stateCache)
+}
@@ -0,0 +1,276 @@
+// Copyright 2021 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package core
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/consensus/ethash"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/ethereum/go-ethereum/params"
+)
+
+var (
+	archiveTestKey, _  = crypto.HexToECDSA("b71c71a67e1177ad4e901695e1b4b9ee17ae16c6668d313eac2f96dbcda3f291")
+	archiveTestAddress = crypto.PubkeyToAddress(archiveTestKey.PublicKey)
+
+	// archiveTestInitCode deploys a contract storing the block number into slot
+	// number%3 when called without data, and self destructing otherwise.
+	archiveTestInitCode = common.FromHex("0x600e600c600039600e6000f3" + "36600b57436003430655005b33ff")
+
+	// archiveTestFactory deploys the test contract with CREATE2 when called, so
+	// it can be recreated at the same address after self destructing.
+	archiveTestFactory  = common.HexToAddress("0xbb")
+	archiveTestContract = crypto.CreateAddress2(archiveTestFactory, common.Hash{}, crypto.Keccak256(archiveTestInitCode))
+
+	archiveTestGenesis = &Genesis{
+		Config:  params.TestChainConfig,
+		BaseFee: big.NewInt(params.InitialBaseFee),
+		Alloc: GenesisAlloc{
+			archiveTestAddress:   {Balance: big.NewInt(1000000000000000000)},
+			archiveTestFactory:   {Balance: big.NewInt(1), Code: append(common.FromHex("0x601a6011600039"+"6000601a60006000f5"+"00"), archiveTestInitCode...)},
+			common.Address{0xaa}: {Balance: big.NewInt(1), Storage: map[common.Hash]common.Hash{{}: common.HexToHash("0xff")}},
+		},
+	}
+)
+
+// makeArchiveTestChain generates blocks transferring funds around and modifying
+// the storage of the test contract, destructing it at the given block and then
+// recreating it.
+func makeArchiveTestChain(parent *types.Block, db ethdb.Database, n int, seed byte, destruct int) []*types.Block {
+	signer := types.LatestSigner(params.TestChainConfig)
+	blocks, _ := GenerateChain(params.TestChainConfig, parent, ethash.NewFaker(), db, n, func(i int, b *BlockGen) {
+		b.SetCoinbase(common.Address{seed})
+
+		if i == 0 || i == destruct+2 {
+			tx, _ := types.SignTx(types.NewTransaction(b.TxNonce(archiveTestAddress), archiveTestFactory, nil, 200000, b.BaseFee(), nil), signer, archiveTestKey)
+			b.AddTx(tx)
+		}
+		var data []byte
+		if i == destruct {
+			data = []byte{0x01}
+		}
+		tx, _ := types.SignTx(types.NewTransaction(b.TxNonce(archiveTestAddress), archiveTestContract, nil, 50000, b.BaseFee(), data), signer, archiveTestKey)
+		b.AddTx(tx)
+		tx, _ = types.SignTx(types.NewTransaction(b.TxNonce(archiveTestAddress), common.Address{seed, byte(i % 8)}, big.NewInt(int64(i+1)), params.TxGas, b.BaseFee(), nil), signer, archiveTestKey)
+		b.AddTx(tx)
+	})
+	return blocks
+}
+
+// checkArchivedStates compares the archived states of the given blocks against
+// the ones of a full archive node.
+func checkArchivedStates(t *testing.T, chain *BlockChain, full *BlockChain, blocks []*types.Block) {
+	t.Helper()
+
+	addrs := []common.Address{archiveTestAddress, archiveTestFactory, archiveTestContract, {0xaa}, {0xff}}
+	for _, seed := range []byte{1, 2} {
+		for i := 0; i < 8; i++ {
+			addrs = append(addrs, common.Address{seed, byte(i)})
+		}
+	}
+	for _, block := range blocks {
+		want, err := full.StateAt(block.Root())
+		if err != nil {
+			t.Fatalf("block #%d: failed to open reference state: %v", block.NumberU64(), err)
+		}
+		have, err := chain.ArchivedStateAt(block.Header())
+		if err != nil {
+			t.Fatalf("block #%d: failed to open archived state: %v", block.NumberU64(), err)
+		}
+		for _, addr := range addrs {
+			if have.Exist(addr) != want.Exist(addr) {
+				t.Fatalf("block #%d, account %x: existence mismatch: have %v, want %v", block.NumberU64(), addr, have.Exist(addr), want.Exist(addr))
+			}
+			if have.GetBalance(addr).Cmp(want.GetBalance(addr)) != 0 {
+				t.Fatalf("block #%d, account %x: balance mismatch: have %v, want %v", block.NumberU64(), addr, have.GetBalance(addr), want.GetBalance(addr))
+			}
+			if have.GetNonce(addr) != want.GetNonce(addr) {
+				t.Fatalf("block #%d, account %x: nonce mismatch: have %d, want %d", block.NumberU64(), addr, have.GetNonce(addr), want.GetNonce(addr))
+			}
+			if have.GetCodeHash(addr) != want.GetCodeHash(addr) {
+				t.Fatalf("block #%d, account %x: code hash mismatch: have %x, want %x", block.NumberU64(), addr, have.GetCodeHash(addr), want.GetCodeHash(addr))
+			}
+		}
+		for _, addr := range []common.Address{archiveTestContract, {0xaa}} {
+			for i := 0; i < 4; i++ {
+				slot := common.BigToHash(big.NewInt(int64(i)))
+				if have, want := have.GetState(addr, slot), want.GetState(addr, slot); have != want {
+					t.Fatalf("block #%d, account %x, slot %d: value mismatch: have %x, want %x", block.NumberU64(), addr, i, have, want)
+				}
+			}
+		}
+	}
+}
+
+// Tests that the states of all the blocks can be read from the state archive,
+// across reorgs, rewinds and restarts, without their tries being available.
+func TestStateArchive(t *testing.T) {
+	var (
+		gendb   = rawdb.NewMemoryDatabase()
+		genesis = archiveTestGenesis.MustCommit(gendb)
+
+		original   = makeArchiveTestChain(genesis, gendb, 2*TriesInMemory, 1, TriesInMemory)
+		competitor = makeArchiveTestChain(original[TriesInMemory+31], gendb, TriesInMemory, 2, -1)
+	)
+	// Import all the chains into a full archive node for the reference states
+	fulldb := rawdb.NewMemoryDatabase()
+	archiveTestGenesis.MustCommit(fulldb)
+
+	full, _ := NewBlockChain(fulldb, &CacheConfig{TrieDirtyDisabled: true}, params.TestChainConfig, ethash.NewFaker(), vm.Config{}, nil, nil)
+	defer full.Stop()
+
+	if _, err := full.InsertChain(original); err != nil {
+		t.Fatalf("failed to insert original chain into full node: %v", err)
+	}
+	if _, err := full.InsertChain(competitor); err != nil {
+		t.Fatalf("failed to insert competitor chain into full node: %v", err)
+	}
+	// Import the original chain into a pruning node with the archive enabled
+	var (
+		diskdb      = rawdb.NewMemoryDatabase()
+		cacheConfig = *defaultCacheConfig
+	)
+	archiveTestGenesis.MustCommit(diskdb)
+	cacheConfig.StateArchive = true
+	cacheConfig.StateArchiveDir = t.TempDir()
+
+	chain, err := NewBlockChain(diskdb, &cacheConfig, params.TestChainConfig, ethash.NewFaker(), vm.Config{}, nil, nil)
+	if err != nil {
+		t.Fatalf("failed to create tester chain: %v", err)
+	}
+	<-chain.archiveSeeding
+
+	if _, err := chain.InsertChain(original); err != nil {
+		t.Fatalf("failed to insert original chain: %v", err)
+	}
+	if chain.HasState(original[TriesInMemory/2].Root()) {
+		t.Fatalf("old state still available")
+	}
+	checkArchivedStates(t, chain, full, append([]*types.Block{genesis}, original...))
+
+	// Ensure the archived states refuse to be hashed, proven or committed
+	archived, err := chain.ArchivedStateAt(original[0].Header())
+	if err != nil {
+		t.Fatalf("failed to open archived state: %v", err)
+	}
+	archived.AddBalance(archiveTestAddress, big.NewInt(1))
+	if _, err := archived.GetProof(archiveTestAddress); err == nil {
+		t.Fatalf("archived state proven")
+	}
+	if _, err := archived.Commit(true); err == nil {
+		t.Fatalf("archived state committed")
+	}
+	if root := archived.IntermediateRoot(true); root != (common.Hash{}) || archived.Error() == nil {
+		t.Fatalf("archived state hashed: root %x, err %v", root, archived.Error())
+	}
+
+	// Reorg to the competitor chain and ensure the archive follows it
+	if _, err := chain.InsertChain(competitor); err != nil {
+		t.Fatalf("failed to insert competitor chain: %v", err)
+	}
+	checkArchivedStates(t, chain, full, append(original[:TriesInMemory+32], competitor...))
+	for _, block := range original[TriesInMemory+32:] {
+		if _, err := chain.ArchivedStateAt(block.Header()); err == nil {
+			t.Fatalf("block #%d: reorged state still archived", block.NumberU64())
+		}
+	}
+	// Rewind the chain and ensure the archive is truncated. The chain rewinds
+	// further until a block with its state available.
+	if err := chain.SetHead(original[TriesInMemory/2].NumberU64()); err != nil {
+		t.Fatalf("failed to rewind chain: %v", err)
+	}
+	head := chain.CurrentBlock()
+	if _, err := chain.ArchivedStateAt(original[head.NumberU64()].Header()); err == nil {
+		t.Fatalf("rewound state still archived")
+	}
+	checkArchivedStates(t, chain, full, append([]*types.Block{genesis}, original[:head.NumberU64()]...))
+
+	// Restart the chain and ensure the archive is still available
+	chain.Stop()
+	chain, err = NewBlockChain(diskdb, &cacheConfig, params.TestChainConfig, ethash.NewFaker(), vm.Config{}, nil, nil)
+	if err != nil {
+		t.Fatalf("failed to recreate tester chain: %v", err)
+	}
+	defer chain.Stop()
+
+	head = chain.CurrentBlock()
+	if _, err := chain.InsertChain(original[head.NumberU64():]); err != nil {
+		t.Fatalf("failed to reinsert original chain: %v", err)
+	}
+	checkArchivedStates(t, chain, full, append([]*types.Block{genesis}, original[:TriesInMemory+32]...))
+}
+
+// Tests that the state archive enabled on an existing chain is started from its
+// current head.
+func TestStateArchiveSeeding(t *testing.T) {
+	var (
+		gendb   = rawdb.NewMemoryDatabase()
+		genesis = archiveTestGenesis.MustCommit(gendb)
+		blocks  = makeArchiveTestChain(genesis, gendb, 64, 1, 48)
+	)
+	fulldb := rawdb.NewMemoryDatabase()
+	archiveTestGenesis.MustCommit(fulldb)
+
+	full, _ := NewBlockChain(fulldb, &CacheConfig{TrieDirtyDisabled: true}, params.TestChainConfig, ethash.NewFaker(), vm.Config{}, nil, nil)
+	defer full.Stop()
+
+	if _, err := full.InsertChain(blocks); err != nil {
+		t.Fatalf("failed to insert chain into full node: %v", err)
+	}
+	// Import the first half of the chain without archiving it
+	diskdb := rawdb.NewMemoryDatabase()
+	archiveTestGenesis.MustCommit(diskdb)
+
+	chain, err := NewBlockChain(diskdb, nil, params.TestChainConfig, ethash.NewFaker(), vm.Config{}, nil, nil)
+	if err != nil {
+		t.Fatalf("failed to create tester chain: %v", err)
+	}
+	if _, err := chain.InsertChain(blocks[:32]); err != nil {
+		t.Fatalf("failed to insert chain: %v", err)
+	}
+	chain.Stop()
+
+	// Enable the archive and import the rest of the chain
+	cacheConfig := *defaultCacheConfig
+	cacheConfig.StateArchive = true
+	cacheConfig.StateArchiveDir = t.TempDir()
+
+	chain, err = NewBlockChain(diskdb, &cacheConfig, params.TestChainConfig, ethash.NewFaker(), vm.Config{}, nil, nil)
+	if err != nil {
+		t.Fatalf("failed to recreate tester chain: %v", err)
+	}
+	defer chain.Stop()
+
+	// The blocks imported while the archive is seeded in the background are
+	// archived once it finishes
+	if _, err := chain.InsertChain(blocks[32:]); err != nil {
+		t.Fatalf("failed to insert chain: %v", err)
+	}
+	<-chain.archiveSeeding
+
+	if _, err := chain.ArchivedStateAt(blocks[30].Header()); err == nil {
+		t.Fatalf("state before the archive start available")
+	}
+	checkArchivedStates(t, chain, full, blocks[31:])
+}
@@ -0,0 +1,65 @@
+// Copyright 2021 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package core
+
+import (
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/log"
+)
+
+// writeSyncPreimages records the preimages of the addresses appearing in blocks
+// inserted without execution: the coinbases, the transaction recipients and the
+// log emitters. The state of a synced node is retrieved by hashes only, so apart
+// from the well known keys recognised by the snap syncer, these are the only
+// preimages available for it until blocks get executed.
+func (bc *BlockChain) writeSyncPreimages(blocks types.Blocks, receipts []types.Receipts) {
+	preimages := make(map[common.Hash][]byte)
+	add := func(addr common.Address) {
+		preimages[crypto.Keccak256Hash(addr[:])] = common.CopyBytes(addr[:])
+	}
+	for i, block := range blocks {
+		add(block.Coinbase())
+		for _, tx := range block.Transactions() {
+			if to := tx.To(); to != nil {
+				add(*to)
+			}
+		}
+		for _, receipt := range receipts[i] {
+			for _, log := range receipt.Logs {
+				add(log.Address)
+			}
+		}
+	}
+	if err := bc.preimages.Write(preimages); err != nil {
+		log.Error("Failed to write sync preimages", "err", err)
+	}
+}
+
+// Preimage retrieves the preimage of a hashed trie key, either from the trie
+// database or the preimage store backing it.
+func (bc *BlockChain) Preimage(hash common.Hash) []byte {
+	return bc.stateCache.TrieDB().Preimage(hash)
+}
+
+// PreimageStore returns the append-only store of the preimages of hashed keys,
+// or nil if it's disabled.
+func (bc *BlockChain) PreimageStore() *rawdb.PreimageStore {
+	return bc.preimages
+}
@@ -0,0 +1,122 @@
+// Copyright 2021 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package core
+
+import (
+	"bytes"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/consensus/ethash"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/params"
+)
+
+// Tests that the preimages of the addresses are recorded into the preimage store
+// both when executing blocks and when inserting them with their receipts.
+func TestPreimageStoreRecording(t *testing.T) {
+	dir, err := ioutil.TempDir("", "preimages")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	var (
+		gendb   = rawdb.NewMemoryDatabase()
+		key, _  = crypto.HexToECDSA("b71c71a67e1177ad4e901695e1b4b9ee17ae16c6668d313eac2f96dbcda3f291")
+		address = crypto.PubkeyToAddress(key.PublicKey)
+		gspec   = &Genesis{
+			Config:  params.TestChainConfig,
+			Alloc:   GenesisAlloc{address: {Balance: big.NewInt(1000000000000000)}},
+			BaseFee: big.NewInt(params.InitialBaseFee),
+		}
+		genesis = gspec.MustCommit(gendb)
+		signer  = types.LatestSigner(gspec.Config)
+		addrs   []common.Address
+	)
+	blocks, receipts := GenerateChain(gspec.Config, genesis, ethash.NewFaker(), gendb, 8, func(i int, block *BlockGen) {
+		coinbase, recipient := common.Address{0xc0, byte(i)}, common.Address{0xd0, byte(i)}
+		addrs = append(addrs, coinbase, recipient)
+
+		block.SetCoinbase(coinbase)
+		tx, err := types.SignTx(types.NewTransaction(block.TxNonce(address), recipient, big.NewInt(1000), params.TxGas, block.header.BaseFee, nil), signer, key)
+		if err != nil {
+			panic(err)
+		}
+		block.AddTx(tx)
+	})
+	check := func(name string, preimage func(common.Hash) []byte) {
+		t.Helper()
+		for _, addr := range addrs {
+			if have := preimage(crypto.Keccak256Hash(addr[:])); !bytes.Equal(have, addr[:]) {
+				t.Errorf("%s: preimage of %x mismatch: have %x", name, addr, have)
+			}
+		}
+	}
+	// Execute the blocks and check that the touched accounts are recorded
+	fullDb := rawdb.NewMemoryDatabase()
+	gspec.MustCommit(fullDb)
+	config := *defaultCacheConfig
+	config.PreimageDir = filepath.Join(dir, "full")
+	full, err := NewBlockChain(fullDb, &config, gspec.Config, ethash.NewFaker(), vm.Config{}, nil, nil)
+	if err != nil {
+		t.Fatalf("failed to create chain: %v", err)
+	}
+	if n, err := full.InsertChain(blocks); err != nil {
+		t.Fatalf("failed to process block %d: %v", n, err)
+	}
+	full.Stop()
+
+	store, err := rawdb.NewPreimageStore(fullDb, config.PreimageDir, true)
+	if err != nil {
+		t.Fatalf("failed to open preimage store: %v", err)
+	}
+	check("full", store.Preimage)
+	store.Close()
+
+	// Insert the blocks with their receipts and check the same addresses
+	fastDb := rawdb.NewMemoryDatabase()
+	gspec.MustCommit(fastDb)
+	config.PreimageDir = filepath.Join(dir, "fast")
+	fast, err := NewBlockChain(fastDb, &config, gspec.Config, ethash.NewFaker(), vm.Config{}, nil, nil)
+	if err != nil {
+		t.Fatalf("failed to create chain: %v", err)
+	}
+	defer fast.Stop()
+
+	headers := make([]*types.Header, len(blocks))
+	for i, block := range blocks {
+		headers[i] = block.Header()
+	}
+	if n, err := fast.InsertHeaderChain(headers, 1); err != nil {
+		t.Fatalf("failed to insert header %d: %v", n, err)
+	}
+	if n, err := fast.InsertReceiptChain(blocks, receipts, 0); err != nil {
+		t.Fatalf("failed to insert receipt %d: %v", n, err)
+	}
+	check("fast", fast.Preimage)
+	if blob := rawdb.ReadPreimage(fastDb, crypto.Keccak256Hash(addrs[0][:])); blob != nil {
+		t.Errorf("preimage written into the key-value store")
+	}
+}
@@ -2015,6 +2015,7 @@ func testSetHead(t *testing.T, tt *rewindTest, snapshots bool) {
 		t.Fatalf("Failed to import canonical chain tail: %v", err)
 	}
 	// Manually dereference anything not committed to not have to work with 128+ tries
+	chain.prover.Release()
 	for _, block := range sideblocks {
 		chain.stateCache.TrieDB().Dereference(block.Root())
 	}
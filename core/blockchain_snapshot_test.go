@@ -29,10 +29,13 @@ import (
 	"testing"
 	"time"
 
+	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/consensus"
 	"github.com/ethereum/go-ethereum/consensus/ethash"
 	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/core/state/snapshot"
 	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
 	"github.com/ethereum/go-ethereum/core/vm"
 	"github.com/ethereum/go-ethereum/ethdb"
 	"github.com/ethereum/go-ethereum/params"
@@ -716,3 +719,90 @@ func TestRecoverSnapshotFromWipingCrash(t *testing.T) {
 	test.test(t)
 	test.teardown()
 }
+
+// Tests that a state snapshot exported from one node can be imported into the
+// database of another one, which has the chain but not the state, and that the
+// node starts up on top of the imported state and keeps processing blocks.
+func TestSnapshotExportImport(t *testing.T) {
+	var (
+		key, _  = crypto.HexToECDSA("b71c71a67e1177ad4e901695e1b4b9ee17ae16c6668d313eac2f96dbcda3f291")
+		address = crypto.PubkeyToAddress(key.PublicKey)
+		gspec   = &Genesis{
+			Config:  params.TestChainConfig,
+			Alloc:   GenesisAlloc{address: {Balance: big.NewInt(1000000000000000)}},
+			BaseFee: big.NewInt(params.InitialBaseFee),
+		}
+		gendb   = rawdb.NewMemoryDatabase()
+		genesis = gspec.MustCommit(gendb)
+		signer  = types.LatestSigner(gspec.Config)
+	)
+	blocks, receipts := GenerateChain(gspec.Config, genesis, ethash.NewFaker(), gendb, 12, func(i int, block *BlockGen) {
+		tx, err := types.SignTx(types.NewTransaction(block.TxNonce(address), common.Address{byte(i)}, big.NewInt(1000), params.TxGas, block.header.BaseFee, nil), signer, key)
+		if err != nil {
+			panic(err)
+		}
+		block.AddTx(tx)
+	})
+	// Export the state of an intermediate block from a fully synced node
+	source := rawdb.NewMemoryDatabase()
+	gspec.MustCommit(source)
+	chain, _ := NewBlockChain(source, defaultCacheConfig, gspec.Config, ethash.NewFaker(), vm.Config{}, nil, nil)
+	if n, err := chain.InsertChain(blocks); err != nil {
+		t.Fatalf("failed to insert block %d: %v", n, err)
+	}
+	pivot := blocks[7]
+
+	var export bytes.Buffer
+	if err := chain.Snapshots().Export(pivot.Header(), &export); err != nil {
+		t.Fatalf("failed to export snapshot: %v", err)
+	}
+	chain.Stop()
+
+	// Retrieve the chain without the state up to the exported block, import the
+	// state and restart the node
+	db := rawdb.NewMemoryDatabase()
+	gspec.MustCommit(db)
+	chain, _ = NewBlockChain(db, defaultCacheConfig, gspec.Config, ethash.NewFaker(), vm.Config{}, nil, nil)
+
+	headers := make([]*types.Header, pivot.NumberU64())
+	for i := range headers {
+		headers[i] = blocks[i].Header()
+	}
+	if n, err := chain.InsertHeaderChain(headers, 1); err != nil {
+		t.Fatalf("failed to insert header %d: %v", n, err)
+	}
+	if n, err := chain.InsertReceiptChain(blocks[:pivot.NumberU64()], receipts[:pivot.NumberU64()], 0); err != nil {
+		t.Fatalf("failed to insert receipt %d: %v", n, err)
+	}
+	chain.Stop()
+
+	if _, err := snapshot.Import(db, bytes.NewReader(export.Bytes())); err != nil {
+		t.Fatalf("failed to import snapshot: %v", err)
+	}
+	chain, _ = NewBlockChain(db, defaultCacheConfig, gspec.Config, ethash.NewFaker(), vm.Config{}, nil, nil)
+	if head := chain.CurrentBlock(); head.Hash() != pivot.Hash() {
+		t.Fatalf("head block mismatch after import: have #%d, want #%d", head.NumberU64(), pivot.NumberU64())
+	}
+	if chain.Snapshots().Snapshot(pivot.Root()) == nil {
+		t.Fatalf("imported snapshot discarded")
+	}
+	// Process the rest of the chain on top of the imported state and restart again
+	if n, err := chain.InsertChain(blocks[pivot.NumberU64():]); err != nil {
+		t.Fatalf("failed to insert block %d on top of the imported state: %v", n, err)
+	}
+	chain.Stop()
+
+	chain, _ = NewBlockChain(db, defaultCacheConfig, gspec.Config, ethash.NewFaker(), vm.Config{}, nil, nil)
+	defer chain.Stop()
+
+	head := blocks[len(blocks)-1]
+	if have := chain.CurrentBlock(); have.Hash() != head.Hash() {
+		t.Fatalf("head block mismatch after restart: have #%d, want #%d", have.NumberU64(), head.NumberU64())
+	}
+	if chain.Snapshots().Snapshot(head.Root()) == nil {
+		t.Fatalf("snapshot of the head missing after restart")
+	}
+	if err := chain.Snapshots().Verify(head.Root()); err != nil {
+		t.Fatalf("snapshot invalid after restart: %v", err)
+	}
+}
@@ -0,0 +1,192 @@
+// Copyright 2021 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package core
+
+import (
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/core/state"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/rlp"
+)
+
+// cacheStateDiff keeps the reverse diff of a block's state transition in memory
+// until the block becomes canonical and the diff can be stored.
+func (bc *BlockChain) cacheStateDiff(block *types.Block, statedb *state.StateDB) {
+	if bc.stateDiffs == nil {
+		return
+	}
+	diff := statedb.ReverseDiff()
+	if diff == nil {
+		return
+	}
+	enc, err := rlp.EncodeToBytes(diff)
+	if err != nil {
+		log.Error("Failed to encode reverse state diff", "number", block.Number(), "hash", block.Hash(), "err", err)
+		return
+	}
+	bc.stateDiffCache.Add(block.Hash(), enc)
+}
+
+// updateStateDiffs aligns the reverse state diff store with the canonical chain
+// ending in the given head: the diffs of reorged blocks are discarded, the ones
+// of the new canonical blocks appended and the oldest ones beyond the retention
+// window deleted.
+//
+// If a diff is missing, the store is reset to continue after the block, as the
+// diffs can only be applied in a contiguous sequence.
+func (bc *BlockChain) updateStateDiffs(head *types.Block) {
+	if bc.stateDiffs == nil {
+		return
+	}
+	var (
+		number   = head.NumberU64()
+		items, _ = bc.stateDiffs.Ancients()
+		tail, _  = bc.stateDiffs.Tail()
+	)
+	// Discard the diffs above the head and the ones of non-canonical blocks
+	if items > number+1 {
+		items = number + 1
+		if items < tail {
+			items = tail
+		}
+		if err := bc.stateDiffs.TruncateAncients(items); err != nil {
+			log.Error("Failed to truncate reverse state diffs", "items", items, "err", err)
+			return
+		}
+	}
+	for items > tail {
+		if hash, _ := rawdb.ReadStateDiff(bc.stateDiffs, items-1); hash == rawdb.ReadCanonicalHash(bc.db, items-1) {
+			break
+		}
+		items--
+		if err := bc.stateDiffs.TruncateAncients(items); err != nil {
+			log.Error("Failed to truncate reverse state diffs", "items", items, "err", err)
+			return
+		}
+	}
+	// Append the diffs of the new canonical blocks
+	for ; items <= number; items++ {
+		hash := rawdb.ReadCanonicalHash(bc.db, items)
+		if enc, ok := bc.stateDiffCache.Get(hash); ok {
+			if err := rawdb.WriteStateDiff(bc.stateDiffs, items, hash, enc.([]byte)); err != nil {
+				log.Error("Failed to write reverse state diff", "number", items, "hash", hash, "err", err)
+				return
+			}
+			continue
+		}
+		if items > 0 {
+			log.Debug("Reverse state diff missing, resetting store", "number", items, "hash", hash)
+		}
+		if err := bc.stateDiffs.TruncateTail(items + 1); err != nil {
+			log.Error("Failed to reset reverse state diffs", "tail", items+1, "err", err)
+			return
+		}
+	}
+	// Delete the diffs leaving the retention window
+	if limit := bc.cacheConfig.StateDiffs; items > limit {
+		if err := bc.stateDiffs.TruncateTail(items - limit); err != nil {
+			log.Error("Failed to delete old reverse state diffs", "tail", items-limit, "err", err)
+		}
+	}
+}
+
+// recoverState regenerates the missing state of a canonical block by rolling
+// back the state of the closest later block available, using the reverse state
+// diffs. The recovered state is persisted to disk.
+//
+// With the path scheme, the persisted state is rolled back using the reverse
+// diffs of the trie database instead, discarding all states held in memory.
+func (bc *BlockChain) recoverState(header *types.Header) bool {
+	if triedb := bc.stateCache.TrieDB(); triedb.Scheme() == rawdb.PathScheme {
+		if !triedb.Recoverable(header.Root) {
+			return false
+		}
+		if err := triedb.Recover(header.Root); err != nil {
+			log.Error("Failed to roll back persisted state", "number", header.Number, "hash", header.Hash(), "err", err)
+			return false
+		}
+		log.Info("Recovered state from trie reverse diffs", "number", header.Number, "hash", header.Hash())
+		return true
+	}
+	if bc.stateDiffs == nil {
+		return false
+	}
+	var (
+		number   = header.Number.Uint64()
+		items, _ = bc.stateDiffs.Ancients()
+		tail, _  = bc.stateDiffs.Tail()
+	)
+	if number+1 < tail || number+1 >= items || rawdb.ReadCanonicalHash(bc.db, number) != header.Hash() {
+		return false
+	}
+	// Find the closest later canonical block with its state available
+	var start *types.Header
+	for n := number + 1; n < items; n++ {
+		if start = bc.GetHeaderByNumber(n); start == nil || bc.HasState(start.Root) {
+			break
+		}
+	}
+	if start == nil || !bc.HasState(start.Root) {
+		return false
+	}
+	// Roll the state back block by block, keeping the intermediate states alive
+	// until the target one is persisted
+	var (
+		triedb = bc.stateCache.TrieDB()
+		roots  []common.Hash
+	)
+	defer func() {
+		for _, root := range roots {
+			triedb.Dereference(root)
+		}
+	}()
+	for current := start; current.Number.Uint64() > number; {
+		parent := bc.GetHeader(current.ParentHash, current.Number.Uint64()-1)
+		if parent == nil {
+			return false
+		}
+		hash, enc := rawdb.ReadStateDiff(bc.stateDiffs, current.Number.Uint64())
+		if hash != current.Hash() {
+			log.Warn("Reverse state diff missing", "number", current.Number, "hash", current.Hash())
+			return false
+		}
+		diff := new(state.ReverseDiff)
+		if err := rlp.DecodeBytes(enc, diff); err != nil {
+			log.Error("Invalid reverse state diff", "number", current.Number, "hash", current.Hash(), "err", err)
+			return false
+		}
+		if diff.Root != current.Root || diff.Parent != parent.Root {
+			log.Error("Reverse state diff mismatch", "number", current.Number, "hash", current.Hash(), "root", diff.Root, "parent", diff.Parent)
+			return false
+		}
+		if err := state.ApplyReverseDiff(bc.stateCache, diff); err != nil {
+			log.Error("Failed to apply reverse state diff", "number", current.Number, "hash", current.Hash(), "err", err)
+			return false
+		}
+		triedb.Reference(diff.Parent, common.Hash{})
+		roots = append(roots, diff.Parent)
+		current = parent
+	}
+	if err := triedb.Commit(header.Root, false, nil); err != nil {
+		log.Error("Failed to persist recovered state", "number", number, "hash", header.Hash(), "err", err)
+		return false
+	}
+	log.Info("Recovered state from reverse diffs", "number", number, "hash", header.Hash(), "from", start.Number)
+	return true
+}
@@ -23,6 +23,7 @@ import (
 	"math/big"
 	"math/rand"
 	"os"
+	"path/filepath"
 	"sync"
 	"testing"
 	"time"
@@ -1544,6 +1545,7 @@ func TestTrieForkGC(t *testing.T) {
 		}
 	}
 	// Dereference all the recent tries and ensure no past trie is left in
+	chain.prover.Release()
 	for i := 0; i < TriesInMemory; i++ {
 		chain.stateCache.TrieDB().Dereference(blocks[len(blocks)-1-i].Root())
 		chain.stateCache.TrieDB().Dereference(forks[len(blocks)-1-i].Root())
@@ -1599,6 +1601,8 @@ func TestLargeReorgTrieGC(t *testing.T) {
 	if _, err := chain.InsertChain(competitor[len(competitor)-2:]); err != nil {
 		t.Fatalf("failed to finalize competitor chain: %v", err)
 	}
+	// Drop the states retained for proofs to only observe the chain's own pruning
+	chain.prover.Release()
 	for i, block := range competitor[:len(competitor)-TriesInMemory] {
 		if node, _ := chain.stateCache.TrieDB().Node(block.Root()); node != nil {
 			t.Fatalf("competitor %d: competing chain state missing", i)
@@ -1606,6 +1610,339 @@ func TestLargeReorgTrieGC(t *testing.T) {
 	}
 }
 
+// Tests that the reverse state diffs allow rewinding the chain to a block whose
+// state was already garbage collected, without rewinding any further.
+func TestStateDiffSetHead(t *testing.T) {
+	engine := ethash.NewFaker()
+
+	db := rawdb.NewMemoryDatabase()
+	genesis := (&Genesis{BaseFee: big.NewInt(params.InitialBaseFee)}).MustCommit(db)
+	blocks, _ := GenerateChain(params.TestChainConfig, genesis, engine, db, 3*TriesInMemory, func(i int, b *BlockGen) {
+		b.SetCoinbase(common.Address{byte(i % 16)})
+	})
+	diskdb := rawdb.NewMemoryDatabase()
+	(&Genesis{BaseFee: big.NewInt(params.InitialBaseFee)}).MustCommit(diskdb)
+
+	cacheConfig := *defaultCacheConfig
+	cacheConfig.StateDiffs = 2 * TriesInMemory
+	cacheConfig.StateDiffDir = t.TempDir()
+
+	chain, err := NewBlockChain(diskdb, &cacheConfig, params.TestChainConfig, engine, vm.Config{}, nil, nil)
+	if err != nil {
+		t.Fatalf("failed to create tester chain: %v", err)
+	}
+	defer chain.Stop()
+
+	if _, err := chain.InsertChain(blocks); err != nil {
+		t.Fatalf("failed to insert chain: %v", err)
+	}
+	// The diffs of the blocks within the retention window should be stored
+	if items, _ := chain.stateDiffs.Ancients(); items != uint64(len(blocks))+1 {
+		t.Fatalf("stored diff count mismatch: have %d, want %d", items, len(blocks)+1)
+	}
+	if tail, _ := chain.stateDiffs.Tail(); tail > TriesInMemory+1 {
+		t.Fatalf("stored diffs beyond retention window: tail %d", tail)
+	}
+	// Rewind to a block with pruned state and ensure it's regenerated
+	target := blocks[TriesInMemory-1]
+	if chain.HasState(target.Root()) {
+		t.Fatalf("target state still available")
+	}
+	if err := chain.SetHead(target.NumberU64()); err != nil {
+		t.Fatalf("failed to rewind chain: %v", err)
+	}
+	if head := chain.CurrentBlock(); head.Hash() != target.Hash() {
+		t.Fatalf("head mismatch: have #%d [%x], want #%d [%x]", head.NumberU64(), head.Hash(), target.NumberU64(), target.Hash())
+	}
+	if !chain.HasState(target.Root()) {
+		t.Fatalf("target state missing after rewind")
+	}
+	if items, _ := chain.stateDiffs.Ancients(); items != target.NumberU64()+1 {
+		t.Fatalf("stored diff count mismatch after rewind: have %d, want %d", items, target.NumberU64()+1)
+	}
+	// The chain should be extendable again on top of the recovered state
+	if _, err := chain.InsertChain(blocks[TriesInMemory:]); err != nil {
+		t.Fatalf("failed to reinsert chain: %v", err)
+	}
+	if head := chain.CurrentBlock(); head.Hash() != blocks[len(blocks)-1].Hash() {
+		t.Fatalf("head mismatch after reinsert: have #%d, want #%d", head.NumberU64(), len(blocks))
+	}
+}
+
+// Tests that deep reorgs roll back the state of the forking point using the
+// reverse state diffs instead of reprocessing the chain from the genesis.
+func TestStateDiffLargeReorg(t *testing.T) {
+	engine := ethash.NewFaker()
+
+	db := rawdb.NewMemoryDatabase()
+	genesis := (&Genesis{BaseFee: big.NewInt(params.InitialBaseFee)}).MustCommit(db)
+
+	shared, _ := GenerateChain(params.TestChainConfig, genesis, engine, db, 64, func(i int, b *BlockGen) { b.SetCoinbase(common.Address{1}) })
+	original, _ := GenerateChain(params.TestChainConfig, shared[len(shared)-1], engine, db, TriesInMemory+32, func(i int, b *BlockGen) { b.SetCoinbase(common.Address{2}) })
+	competitor, _ := GenerateChain(params.TestChainConfig, shared[len(shared)-1], engine, db, TriesInMemory+33, func(i int, b *BlockGen) { b.SetCoinbase(common.Address{3}) })
+
+	diskdb := rawdb.NewMemoryDatabase()
+	(&Genesis{BaseFee: big.NewInt(params.InitialBaseFee)}).MustCommit(diskdb)
+
+	cacheConfig := *defaultCacheConfig
+	cacheConfig.StateDiffs = 4 * TriesInMemory
+	cacheConfig.StateDiffDir = t.TempDir()
+
+	chain, err := NewBlockChain(diskdb, &cacheConfig, params.TestChainConfig, engine, vm.Config{}, nil, nil)
+	if err != nil {
+		t.Fatalf("failed to create tester chain: %v", err)
+	}
+	defer chain.Stop()
+
+	if _, err := chain.InsertChain(shared); err != nil {
+		t.Fatalf("failed to insert shared chain: %v", err)
+	}
+	if _, err := chain.InsertChain(original); err != nil {
+		t.Fatalf("failed to insert original chain: %v", err)
+	}
+	fork := shared[len(shared)-1]
+	if chain.HasState(fork.Root()) {
+		t.Fatalf("forking point state still available")
+	}
+	if _, err := chain.InsertChain(competitor); err != nil {
+		t.Fatalf("failed to insert competitor chain: %v", err)
+	}
+	if head := chain.CurrentBlock(); head.Hash() != competitor[len(competitor)-1].Hash() {
+		t.Fatalf("head mismatch: have #%d, want #%d", head.NumberU64(), competitor[len(competitor)-1].NumberU64())
+	}
+	// The forking point state should have been recovered to disk
+	if blob := rawdb.ReadTrieNode(diskdb, fork.Root()); len(blob) == 0 {
+		t.Fatalf("forking point state not recovered")
+	}
+	// The stored diffs should follow the new canonical chain
+	for _, block := range competitor {
+		if hash, _ := rawdb.ReadStateDiff(chain.stateDiffs, block.NumberU64()); hash != block.Hash() {
+			t.Fatalf("block #%d: stored diff hash mismatch: have %x, want %x", block.NumberU64(), hash, block.Hash())
+		}
+	}
+}
+
+// Tests that the online state pruner deletes stale state nodes in the background
+// while blocks keep being imported, without touching the recent states.
+func TestOnlineStatePruning(t *testing.T) {
+	engine := ethash.NewFaker()
+
+	var (
+		db    = rawdb.NewMemoryDatabase()
+		gspec = &Genesis{
+			BaseFee: big.NewInt(params.InitialBaseFee),
+			Alloc:   GenesisAlloc{common.Address{0xff}: {Balance: big.NewInt(1)}},
+		}
+		genesis = gspec.MustCommit(db)
+	)
+	blocks, _ := GenerateChain(params.TestChainConfig, genesis, engine, db, 2*TriesInMemory, func(i int, b *BlockGen) {
+		b.SetCoinbase(common.Address{byte(i % 16)})
+	})
+	diskdb := rawdb.NewMemoryDatabase()
+	gspec.MustCommit(diskdb)
+
+	// Flush every state to disk as soon as it leaves the in-memory window, so
+	// there's plenty of stale data on disk to prune, and a pruning target each
+	// time one is due.
+	cacheConfig := &CacheConfig{
+		TrieCleanLimit:         256,
+		TrieDirtyLimit:         0,
+		TrieTimeLimit:          time.Nanosecond,
+		SnapshotLimit:          256,
+		SnapshotWait:           true,
+		OnlinePruning:          true,
+		OnlinePruningInterval:  32,
+		OnlinePruningBloom:     filepath.Join(t.TempDir(), "statebloom"),
+		OnlinePruningBloomSize: 256,
+	}
+	chain, err := NewBlockChain(diskdb, cacheConfig, params.TestChainConfig, engine, vm.Config{}, nil, nil)
+	if err != nil {
+		t.Fatalf("failed to create tester chain: %v", err)
+	}
+	defer chain.Stop()
+
+	for i := 0; i < len(blocks); i++ {
+		if _, err := chain.InsertChain(blocks[i : i+1]); err != nil {
+			t.Fatalf("block %d: failed to insert into chain: %v", i, err)
+		}
+	}
+	for start := time.Now(); chain.pruner.Active(); time.Sleep(10 * time.Millisecond) {
+		if time.Since(start) > 10*time.Second {
+			t.Fatalf("state pruning didn't finish in time")
+		}
+	}
+	// Stale states flushed before the in-memory window of the pruning target
+	// must be gone, apart from the genesis state
+	if len(rawdb.ReadTrieNode(diskdb, genesis.Root())) == 0 {
+		t.Errorf("genesis state pruned")
+	}
+	for i := 0; i < 16; i++ {
+		if len(rawdb.ReadTrieNode(diskdb, blocks[i].Root())) > 0 {
+			t.Errorf("block %d: stale state not pruned", blocks[i].NumberU64())
+		}
+	}
+	// All the states since the pruning target must be fully accessible
+	triedb := chain.stateCache.TrieDB()
+	for i := TriesInMemory + 31; i < len(blocks); i++ {
+		tr, err := trie.New(blocks[i].Root(), triedb)
+		if err != nil {
+			t.Fatalf("block %d: failed to open state: %v", blocks[i].NumberU64(), err)
+		}
+		it := tr.NodeIterator(nil)
+		for it.Next(true) {
+		}
+		if err := it.Error(); err != nil {
+			t.Fatalf("block %d: state corrupted: %v", blocks[i].NumberU64(), err)
+		}
+	}
+	if progress := rawdb.ReadOnlinePruningProgress(diskdb); len(progress) == 0 {
+		t.Fatalf("pruning progress marker missing")
+	}
+}
+
+// Tests that with the path based state scheme only the states in the in-memory
+// window are available, with the HEAD state persisted across restarts.
+func TestPathSchemeStateRetention(t *testing.T) {
+	engine := ethash.NewFaker()
+
+	var (
+		db    = rawdb.NewMemoryDatabase()
+		gspec = &Genesis{
+			BaseFee: big.NewInt(params.InitialBaseFee),
+			Alloc:   GenesisAlloc{common.Address{0xff}: {Balance: big.NewInt(1)}},
+		}
+		genesis = gspec.MustCommit(db)
+	)
+	blocks, _ := GenerateChain(params.TestChainConfig, genesis, engine, db, 2*TriesInMemory, func(i int, b *BlockGen) {
+		b.SetCoinbase(common.Address{byte(i % 16)})
+	})
+	diskdb := rawdb.NewMemoryDatabase()
+	rawdb.WriteTrieScheme(diskdb, rawdb.PathScheme)
+	gspec.MustCommit(diskdb)
+
+	cacheConfig := &CacheConfig{
+		TrieCleanLimit: 256,
+		TrieDirtyLimit: 256,
+		TrieTimeLimit:  5 * time.Minute,
+		SnapshotLimit:  256,
+		SnapshotWait:   true,
+		StateScheme:    rawdb.PathScheme,
+	}
+	chain, err := NewBlockChain(diskdb, cacheConfig, params.TestChainConfig, engine, vm.Config{}, nil, nil)
+	if err != nil {
+		t.Fatalf("failed to create tester chain: %v", err)
+	}
+	if scheme := chain.stateCache.TrieDB().Scheme(); scheme != rawdb.PathScheme {
+		t.Fatalf("state scheme mismatch: have %q, want %q", scheme, rawdb.PathScheme)
+	}
+	if _, err := chain.InsertChain(blocks); err != nil {
+		t.Fatalf("failed to insert chain: %v", err)
+	}
+	// The states in the in-memory window must be available, older ones gone
+	for i, block := range blocks {
+		recent := i >= len(blocks)-TriesInMemory-1
+		if have := chain.HasState(block.Root()); have != recent {
+			t.Errorf("block %d: state availability mismatch: have %v, want %v", block.NumberU64(), have, recent)
+		}
+	}
+	if have, want := rawdb.ReadReverseDiffHead(diskdb), uint64(len(blocks)-TriesInMemory+1); have != want {
+		t.Errorf("reverse diff head mismatch: have %d, want %d", have, want)
+	}
+	chain.Stop()
+
+	// Reopen the chain, the HEAD state should have been persisted on shutdown
+	chain, err = NewBlockChain(diskdb, cacheConfig, params.TestChainConfig, engine, vm.Config{}, nil, nil)
+	if err != nil {
+		t.Fatalf("failed to recreate tester chain: %v", err)
+	}
+	defer chain.Stop()
+
+	if head := chain.CurrentBlock(); head.Hash() != blocks[len(blocks)-1].Hash() {
+		t.Fatalf("head block mismatch: have %d, want %d", head.NumberU64(), blocks[len(blocks)-1].NumberU64())
+	}
+	if _, err := chain.State(); err != nil {
+		t.Fatalf("failed to open head state: %v", err)
+	}
+}
+
+// Tests that with the path based state scheme the chain can be rewound beyond
+// the in-memory window, rolling the persisted state back via the reverse diffs.
+func TestPathSchemeSetHead(t *testing.T) {
+	engine := ethash.NewFaker()
+
+	var (
+		db    = rawdb.NewMemoryDatabase()
+		gspec = &Genesis{
+			BaseFee: big.NewInt(params.InitialBaseFee),
+			Alloc:   GenesisAlloc{common.Address{0xff}: {Balance: big.NewInt(1)}},
+		}
+		genesis = gspec.MustCommit(db)
+	)
+	blocks, _ := GenerateChain(params.TestChainConfig, genesis, engine, db, 2*TriesInMemory, func(i int, b *BlockGen) {
+		b.SetCoinbase(common.Address{byte(i % 16)})
+	})
+	diskdb := rawdb.NewMemoryDatabase()
+	rawdb.WriteTrieScheme(diskdb, rawdb.PathScheme)
+	gspec.MustCommit(diskdb)
+
+	cacheConfig := &CacheConfig{
+		TrieCleanLimit: 256,
+		TrieDirtyLimit: 256,
+		TrieTimeLimit:  5 * time.Minute,
+		StateScheme:    rawdb.PathScheme,
+	}
+	chain, err := NewBlockChain(diskdb, cacheConfig, params.TestChainConfig, engine, vm.Config{}, nil, nil)
+	if err != nil {
+		t.Fatalf("failed to create tester chain: %v", err)
+	}
+	if _, err := chain.InsertChain(blocks); err != nil {
+		t.Fatalf("failed to insert chain: %v", err)
+	}
+	// Rewind beyond the in-memory window, the state must be rolled back
+	target := blocks[TriesInMemory/2]
+	if chain.HasState(target.Root()) {
+		t.Fatalf("target state unexpectedly available before rewind")
+	}
+	if err := chain.SetHead(target.NumberU64()); err != nil {
+		t.Fatalf("failed to rewind chain: %v", err)
+	}
+	if head := chain.CurrentBlock(); head.Hash() != target.Hash() {
+		t.Fatalf("head block mismatch: have %d, want %d", head.NumberU64(), target.NumberU64())
+	}
+	if _, err := chain.State(); err != nil {
+		t.Fatalf("failed to open rewound head state: %v", err)
+	}
+	chain.Stop()
+
+	// Reopen the chain and rewind once more, now with nothing held in memory
+	chain, err = NewBlockChain(diskdb, cacheConfig, params.TestChainConfig, engine, vm.Config{}, nil, nil)
+	if err != nil {
+		t.Fatalf("failed to recreate tester chain: %v", err)
+	}
+	defer chain.Stop()
+
+	if head := chain.CurrentBlock(); head.Hash() != target.Hash() {
+		t.Fatalf("head block mismatch after restart: have %d, want %d", head.NumberU64(), target.NumberU64())
+	}
+	target = blocks[TriesInMemory/4]
+	if err := chain.SetHead(target.NumberU64()); err != nil {
+		t.Fatalf("failed to rewind chain: %v", err)
+	}
+	if head := chain.CurrentBlock(); head.Hash() != target.Hash() {
+		t.Fatalf("head block mismatch: have %d, want %d", head.NumberU64(), target.NumberU64())
+	}
+	if _, err := chain.State(); err != nil {
+		t.Fatalf("failed to open rewound head state: %v", err)
+	}
+	// The rewound chain must be extendable again
+	if _, err := chain.InsertChain(blocks[target.NumberU64():]); err != nil {
+		t.Fatalf("failed to reimport chain: %v", err)
+	}
+	if head := chain.CurrentBlock(); head.Hash() != blocks[len(blocks)-1].Hash() {
+		t.Fatalf("head block mismatch after reimport: have %d, want %d", head.NumberU64(), blocks[len(blocks)-1].NumberU64())
+	}
+}
+
 func TestBlockchainRecovery(t *testing.T) {
 	// Configure and generate a sample block chain
 	var (
@@ -1819,6 +2156,9 @@ func testSideImport(t *testing.T, numCanonBlocksInSidechain, blocksBetweenCommon
 		t.Fatalf("block %d: failed to insert into chain: %v", n, err)
 	}
 
+	// Drop the states retained for proofs to only observe the chain's own pruning
+	chain.prover.Release()
+
 	lastPrunedIndex := len(blocks) - TriesInMemory - 1
 	lastPrunedBlock := blocks[lastPrunedIndex]
 	firstNonPrunedBlock := blocks[len(blocks)-TriesInMemory]
@@ -2491,6 +2831,9 @@ func TestSideImportPrunedBlocks(t *testing.T) {
 		t.Fatalf("block %d: failed to insert into chain: %v", n, err)
 	}
 
+	// Drop the states retained for proofs to only observe the chain's own pruning
+	chain.prover.Release()
+
 	lastPrunedIndex := len(blocks) - TriesInMemory - 1
 	lastPrunedBlock := blocks[lastPrunedIndex]
 
@@ -3336,3 +3679,63 @@ func TestEIP1559Transition(t *testing.T) {
 		t.Fatalf("sender balance incorrect: expected %d, got %d", expected, actual)
 	}
 }
+
+// Tests that blocks inserted without setting the head are persisted but don't
+// touch the canonical chain until explicitly set as head, including across
+// reorgs and non-consecutive extensions.
+func TestInsertBlockWithoutSetHead(t *testing.T) {
+	db, chain, err := newCanonical(ethash.NewFaker(), 0, true)
+	if err != nil {
+		t.Fatalf("failed to create chain: %v", err)
+	}
+	defer chain.Stop()
+
+	genesis := chain.Genesis()
+	canon, _ := GenerateChain(params.AllEthashProtocolChanges, genesis, ethash.NewFaker(), db, 3, func(i int, b *BlockGen) {
+		b.SetCoinbase(common.Address{1})
+	})
+	side, _ := GenerateChain(params.AllEthashProtocolChanges, genesis, ethash.NewFaker(), db, 2, func(i int, b *BlockGen) {
+		b.SetCoinbase(common.Address{2})
+	})
+	for _, block := range canon {
+		if err := chain.InsertBlockWithoutSetHead(block); err != nil {
+			t.Fatalf("failed to insert block %d: %v", block.NumberU64(), err)
+		}
+		if head := chain.CurrentBlock(); head.Hash() != genesis.Hash() {
+			t.Fatalf("head changed by insertion: have %d, want genesis", head.NumberU64())
+		}
+		if !chain.HasBlockAndState(block.Hash(), block.NumberU64()) {
+			t.Fatalf("block %d not persisted", block.NumberU64())
+		}
+	}
+	// Extend the chain by multiple blocks at once
+	if err := chain.SetChainHead(canon[2]); err != nil {
+		t.Fatalf("failed to set head: %v", err)
+	}
+	for _, block := range canon {
+		if hash := chain.GetCanonicalHash(block.NumberU64()); hash != block.Hash() {
+			t.Fatalf("canonical hash %d mismatch: have %x, want %x", block.NumberU64(), hash, block.Hash())
+		}
+	}
+	// Reorg to a shorter side chain
+	for _, block := range side {
+		if err := chain.InsertBlockWithoutSetHead(block); err != nil {
+			t.Fatalf("failed to insert side block %d: %v", block.NumberU64(), err)
+		}
+	}
+	if head := chain.CurrentBlock(); head.Hash() != canon[2].Hash() {
+		t.Fatalf("head changed by side insertion: have %x, want %x", head.Hash(), canon[2].Hash())
+	}
+	if err := chain.SetChainHead(side[1]); err != nil {
+		t.Fatalf("failed to set head: %v", err)
+	}
+	if head := chain.CurrentBlock(); head.Hash() != side[1].Hash() {
+		t.Fatalf("head mismatch: have %x, want %x", head.Hash(), side[1].Hash())
+	}
+	if hash := chain.GetCanonicalHash(3); hash != (common.Hash{}) {
+		t.Fatalf("stale canonical hash retained: %x", hash)
+	}
+	if hash := chain.GetCanonicalHash(1); hash != side[0].Hash() {
+		t.Fatalf("canonical hash mismatch: have %x, want %x", hash, side[0].Hash())
+	}
+}
@@ -0,0 +1,162 @@
+// Copyright 2021 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package rawdb
+
+import (
+	"encoding/binary"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/ethereum/go-ethereum/log"
+)
+
+// ReadArchiveDiff retrieves the flat state diff of the block with the given
+// number from the archive, along with the hash of the block.
+func ReadArchiveDiff(db ethdb.AncientReader, number uint64) (common.Hash, []byte) {
+	hash, err := db.Ancient(archiveHashTable, number)
+	if err != nil || len(hash) != common.HashLength {
+		return common.Hash{}, nil
+	}
+	diff, err := db.Ancient(archiveDiffTable, number)
+	if err != nil {
+		return common.Hash{}, nil
+	}
+	return common.BytesToHash(hash), diff
+}
+
+// WriteArchiveDiff appends the flat state diff of the given block into the
+// archive.
+func WriteArchiveDiff(db ethdb.AncientWriter, number uint64, hash common.Hash, diff []byte) error {
+	_, err := db.ModifyAncients(func(op ethdb.AncientWriteOp) error {
+		if err := op.AppendRaw(archiveHashTable, number, hash.Bytes()); err != nil {
+			return err
+		}
+		return op.AppendRaw(archiveDiffTable, number, diff)
+	})
+	return err
+}
+
+// seekArchiveEntry finds the entry with the given key prefix changed at the
+// highest block number not above the given one, returning its value and number.
+func seekArchiveEntry(db ethdb.Iteratee, prefix []byte, number uint64) ([]byte, uint64, bool) {
+	it := db.NewIterator(prefix, archiveNumber(number))
+	defer it.Release()
+
+	if !it.Next() {
+		return nil, 0, false
+	}
+	key := it.Key()
+	if len(key) != len(prefix)+8 {
+		return nil, 0, false
+	}
+	return common.CopyBytes(it.Value()), ^binary.BigEndian.Uint64(key[len(prefix):]), true
+}
+
+// ReadArchiveAccount retrieves the slim account as it was at the given block
+// from the archive index, along with the number of the block which last changed
+// it. An empty account means it didn't exist.
+func ReadArchiveAccount(db ethdb.Iteratee, accountHash common.Hash, number uint64) ([]byte, uint64, bool) {
+	return seekArchiveEntry(db, append(archiveAccountPrefix, accountHash.Bytes()...), number)
+}
+
+// WriteArchiveAccount stores the slim account changed at the given block into
+// the archive index.
+func WriteArchiveAccount(db ethdb.KeyValueWriter, accountHash common.Hash, number uint64, account []byte) {
+	if err := db.Put(archiveAccountKey(accountHash, number), account); err != nil {
+		log.Crit("Failed to store archived account", "err", err)
+	}
+}
+
+// DeleteArchiveAccount removes the account changed at the given block from the
+// archive index.
+func DeleteArchiveAccount(db ethdb.KeyValueWriter, accountHash common.Hash, number uint64) {
+	if err := db.Delete(archiveAccountKey(accountHash, number)); err != nil {
+		log.Crit("Failed to delete archived account", "err", err)
+	}
+}
+
+// ReadArchiveStorage retrieves the storage value as it was at the given block
+// from the archive index, along with the number of the block which last changed
+// it. An empty value means the slot didn't exist.
+func ReadArchiveStorage(db ethdb.Iteratee, accountHash, storageHash common.Hash, number uint64) ([]byte, uint64, bool) {
+	return seekArchiveEntry(db, append(append(archiveStoragePrefix, accountHash.Bytes()...), storageHash.Bytes()...), number)
+}
+
+// WriteArchiveStorage stores the storage value changed at the given block into
+// the archive index.
+func WriteArchiveStorage(db ethdb.KeyValueWriter, accountHash, storageHash common.Hash, number uint64, value []byte) {
+	if err := db.Put(archiveStorageKey(accountHash, storageHash, number), value); err != nil {
+		log.Crit("Failed to store archived storage", "err", err)
+	}
+}
+
+// DeleteArchiveStorage removes the storage value changed at the given block
+// from the archive index.
+func DeleteArchiveStorage(db ethdb.KeyValueWriter, accountHash, storageHash common.Hash, number uint64) {
+	if err := db.Delete(archiveStorageKey(accountHash, storageHash, number)); err != nil {
+		log.Crit("Failed to delete archived storage", "err", err)
+	}
+}
+
+// ReadArchiveDestruct retrieves the number of the last block not above the
+// given one which wiped the storage of an account.
+func ReadArchiveDestruct(db ethdb.Iteratee, accountHash common.Hash, number uint64) (uint64, bool) {
+	_, number, ok := seekArchiveEntry(db, append(archiveDestructPrefix, accountHash.Bytes()...), number)
+	return number, ok
+}
+
+// WriteArchiveDestruct stores the wipe of an account's storage at the given
+// block into the archive index.
+func WriteArchiveDestruct(db ethdb.KeyValueWriter, accountHash common.Hash, number uint64) {
+	if err := db.Put(archiveDestructKey(accountHash, number), nil); err != nil {
+		log.Crit("Failed to store archived storage wipe", "err", err)
+	}
+}
+
+// DeleteArchiveDestruct removes the wipe of an account's storage at the given
+// block from the archive index.
+func DeleteArchiveDestruct(db ethdb.KeyValueWriter, accountHash common.Hash, number uint64) {
+	if err := db.Delete(archiveDestructKey(accountHash, number)); err != nil {
+		log.Crit("Failed to delete archived storage wipe", "err", err)
+	}
+}
+
+// DeleteArchiveIndex removes the entire archive index from the database.
+func DeleteArchiveIndex(db ethdb.KeyValueStore) error {
+	for _, prefix := range [][]byte{archiveAccountPrefix, archiveStoragePrefix, archiveDestructPrefix} {
+		it := db.NewIterator(prefix, nil)
+		batch := db.NewBatch()
+		for it.Next() {
+			batch.Delete(it.Key())
+			if batch.ValueSize() > ethdb.IdealBatchSize {
+				if err := batch.Write(); err != nil {
+					it.Release()
+					return err
+				}
+				batch.Reset()
+			}
+		}
+		it.Release()
+		if err := it.Error(); err != nil {
+			return err
+		}
+		if err := batch.Write(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -17,6 +17,8 @@
 package rawdb
 
 import (
+	"encoding/binary"
+
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/ethdb"
 	"github.com/ethereum/go-ethereum/log"
@@ -94,3 +96,72 @@ func DeleteTrieNode(db ethdb.KeyValueWriter, hash common.Hash) {
 		log.Crit("Failed to delete trie node", "err", err)
 	}
 }
+
+// ReadOnlinePruningProgress retrieves the serialized progress marker of the
+// background state pruner saved at the last shutdown.
+func ReadOnlinePruningProgress(db ethdb.KeyValueReader) []byte {
+	data, _ := db.Get(onlinePruningKey)
+	return data
+}
+
+// WriteOnlinePruningProgress stores the serialized progress marker of the
+// background state pruner.
+func WriteOnlinePruningProgress(db ethdb.KeyValueWriter, progress []byte) {
+	if err := db.Put(onlinePruningKey, progress); err != nil {
+		log.Crit("Failed to store online pruning progress", "err", err)
+	}
+}
+
+// DeleteOnlinePruningProgress deletes the progress marker of the background
+// state pruner.
+func DeleteOnlinePruningProgress(db ethdb.KeyValueWriter) {
+	if err := db.Delete(onlinePruningKey); err != nil {
+		log.Crit("Failed to remove online pruning progress", "err", err)
+	}
+}
+
+// ReadStateDiff retrieves the reverse state diff of the block with the given
+// number from the state diff store, along with the hash of the block.
+func ReadStateDiff(db ethdb.AncientReader, number uint64) (common.Hash, []byte) {
+	hash, err := db.Ancient(stateDiffHashTable, number)
+	if err != nil || len(hash) != common.HashLength {
+		return common.Hash{}, nil
+	}
+	diff, err := db.Ancient(stateDiffTable, number)
+	if err != nil {
+		return common.Hash{}, nil
+	}
+	return common.BytesToHash(hash), diff
+}
+
+// WriteStateDiff appends the reverse state diff of the given block into the
+// state diff store.
+func WriteStateDiff(db ethdb.AncientWriter, number uint64, hash common.Hash, diff []byte) error {
+	_, err := db.ModifyAncients(func(op ethdb.AncientWriteOp) error {
+		if err := op.AppendRaw(stateDiffHashTable, number, hash.Bytes()); err != nil {
+			return err
+		}
+		return op.AppendRaw(stateDiffTable, number, diff)
+	})
+	return err
+}
+
+// ReadPreimageIndex retrieves the number of the preimage of the provided hash
+// in the preimage store.
+func ReadPreimageIndex(db ethdb.KeyValueReader, hash common.Hash) (uint64, bool) {
+	data, _ := db.Get(preimageIndexKey(hash))
+	if len(data) != 8 {
+		return 0, false
+	}
+	return binary.BigEndian.Uint64(data), true
+}
+
+// WritePreimageIndex stores the number of the preimage of the provided hash in
+// the preimage store.
+func WritePreimageIndex(db ethdb.KeyValueWriter, hash common.Hash, number uint64) {
+	var enc [8]byte
+	binary.BigEndian.PutUint64(enc[:], number)
+	if err := db.Put(preimageIndexKey(hash), enc[:]); err != nil {
+		log.Crit("Failed to store trie preimage index", "err", err)
+	}
+}
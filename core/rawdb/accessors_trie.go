@@ -0,0 +1,159 @@
+// Copyright 2021 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package rawdb
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/ethereum/go-ethereum/log"
+)
+
+const (
+	// HashScheme is the legacy trie node storage scheme, where nodes are keyed
+	// by their hash. It allows multiple versions of the state to coexist on disk,
+	// but stale nodes can only be removed by reference counting or pruning.
+	HashScheme = "hash"
+
+	// PathScheme is the trie node storage scheme where nodes are keyed by their
+	// owner (account hash for storage tries) and path within the trie. Every
+	// write overwrites the previous version of the node, so only a single
+	// version of the state is persisted on disk, with reverse diffs allowing
+	// to roll it back.
+	PathScheme = "path"
+)
+
+// ReadAccountTrieNode retrieves the account trie node at the given path.
+func ReadAccountTrieNode(db ethdb.KeyValueReader, path []byte) []byte {
+	data, _ := db.Get(accountTrieNodeKey(path))
+	return data
+}
+
+// WriteAccountTrieNode writes the provided account trie node into database.
+func WriteAccountTrieNode(db ethdb.KeyValueWriter, path []byte, node []byte) {
+	if err := db.Put(accountTrieNodeKey(path), node); err != nil {
+		log.Crit("Failed to store account trie node", "err", err)
+	}
+}
+
+// DeleteAccountTrieNode deletes the specified account trie node from the database.
+func DeleteAccountTrieNode(db ethdb.KeyValueWriter, path []byte) {
+	if err := db.Delete(accountTrieNodeKey(path)); err != nil {
+		log.Crit("Failed to delete account trie node", "err", err)
+	}
+}
+
+// ReadStorageTrieNode retrieves the storage trie node of the given account at
+// the given path.
+func ReadStorageTrieNode(db ethdb.KeyValueReader, accountHash common.Hash, path []byte) []byte {
+	data, _ := db.Get(storageTrieNodeKey(accountHash, path))
+	return data
+}
+
+// WriteStorageTrieNode writes the provided storage trie node into database.
+func WriteStorageTrieNode(db ethdb.KeyValueWriter, accountHash common.Hash, path []byte, node []byte) {
+	if err := db.Put(storageTrieNodeKey(accountHash, path), node); err != nil {
+		log.Crit("Failed to store storage trie node", "err", err)
+	}
+}
+
+// DeleteStorageTrieNode deletes the specified storage trie node from the database.
+func DeleteStorageTrieNode(db ethdb.KeyValueWriter, accountHash common.Hash, path []byte) {
+	if err := db.Delete(storageTrieNodeKey(accountHash, path)); err != nil {
+		log.Crit("Failed to delete storage trie node", "err", err)
+	}
+}
+
+// ReadTrieScheme retrieves the trie node storage scheme persisted in the
+// database, or an empty string if none was recorded.
+func ReadTrieScheme(db ethdb.KeyValueReader) string {
+	data, _ := db.Get(trieSchemeKey)
+	return string(data)
+}
+
+// WriteTrieScheme stores the trie node storage scheme of the database.
+func WriteTrieScheme(db ethdb.KeyValueWriter, scheme string) {
+	if err := db.Put(trieSchemeKey, []byte(scheme)); err != nil {
+		log.Crit("Failed to store trie scheme", "err", err)
+	}
+}
+
+// ParseStateScheme checks the requested trie node storage scheme against the
+// one the database was initialized with, returning the scheme to use. Databases
+// predating the scheme marker but containing a chain are treated as using the
+// hash scheme.
+func ParseStateScheme(provided string, disk ethdb.Database) (string, error) {
+	if provided != "" && provided != HashScheme && provided != PathScheme {
+		return "", fmt.Errorf("unknown state scheme %q", provided)
+	}
+	stored := ReadTrieScheme(disk)
+	if stored == "" && ReadCanonicalHash(disk, 0) != (common.Hash{}) {
+		stored = HashScheme
+	}
+	switch {
+	case stored == "" && provided == "":
+		return HashScheme, nil
+	case stored == "":
+		return provided, nil
+	case provided == "" || provided == stored:
+		return stored, nil
+	default:
+		return "", fmt.Errorf("incompatible state scheme, stored: %s, provided: %s", stored, provided)
+	}
+}
+
+// ReadReverseDiff retrieves the reverse diff of the state transition with the
+// given id.
+func ReadReverseDiff(db ethdb.KeyValueReader, id uint64) []byte {
+	data, _ := db.Get(reverseDiffKey(id))
+	return data
+}
+
+// WriteReverseDiff stores the reverse diff of the state transition with the
+// given id.
+func WriteReverseDiff(db ethdb.KeyValueWriter, id uint64, diff []byte) {
+	if err := db.Put(reverseDiffKey(id), diff); err != nil {
+		log.Crit("Failed to store reverse diff", "err", err)
+	}
+}
+
+// DeleteReverseDiff deletes the reverse diff of the state transition with the
+// given id.
+func DeleteReverseDiff(db ethdb.KeyValueWriter, id uint64) {
+	if err := db.Delete(reverseDiffKey(id)); err != nil {
+		log.Crit("Failed to delete reverse diff", "err", err)
+	}
+}
+
+// ReadReverseDiffHead retrieves the id of the latest reverse diff, which is
+// the id of the persisted state as well.
+func ReadReverseDiffHead(db ethdb.KeyValueReader) uint64 {
+	data, _ := db.Get(reverseDiffHeadKey)
+	if len(data) != 8 {
+		return 0
+	}
+	return binary.BigEndian.Uint64(data)
+}
+
+// WriteReverseDiffHead stores the id of the latest reverse diff.
+func WriteReverseDiffHead(db ethdb.KeyValueWriter, id uint64) {
+	if err := db.Put(reverseDiffHeadKey, encodeBlockNumber(id)); err != nil {
+		log.Crit("Failed to store reverse diff head", "err", err)
+	}
+}
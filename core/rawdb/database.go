@@ -99,6 +99,11 @@ func (db *nofreezedb) Ancients() (uint64, error) {
 	return 0, errNotSupported
 }
 
+// Tail returns an error as we don't have a backing chain freezer.
+func (db *nofreezedb) Tail() (uint64, error) {
+	return 0, errNotSupported
+}
+
 // AncientSize returns an error as we don't have a backing chain freezer.
 func (db *nofreezedb) AncientSize(kind string) (uint64, error) {
 	return 0, errNotSupported
@@ -114,6 +119,11 @@ func (db *nofreezedb) TruncateAncients(items uint64) error {
 	return errNotSupported
 }
 
+// TruncateTail returns an error as we don't have a backing chain freezer.
+func (db *nofreezedb) TruncateTail(items uint64) error {
+	return errNotSupported
+}
+
 // Sync returns an error as we don't have a backing chain freezer.
 func (db *nofreezedb) Sync() error {
 	return errNotSupported
@@ -125,6 +135,27 @@ func NewDatabase(db ethdb.KeyValueStore) ethdb.Database {
 	return &nofreezedb{KeyValueStore: db}
 }
 
+// NewStateDiffFreezer creates an append-only store of the reverse state diffs,
+// indexed by block number. Contrary to the chain freezer, the data is written
+// directly, not moved from a key-value store in the background.
+func NewStateDiffFreezer(datadir string, readonly bool) (ethdb.AncientStore, error) {
+	frdb, err := newFreezer(datadir, "eth/db/statediff/", readonly, stateDiffTableSize, stateDiffNoSnappy)
+	if err != nil {
+		return nil, err
+	}
+	return frdb, nil
+}
+
+// NewArchiveFreezer creates an append-only store of the flat state diffs of all
+// canonical blocks, indexed by block number.
+func NewArchiveFreezer(datadir string, readonly bool) (ethdb.AncientStore, error) {
+	frdb, err := newFreezer(datadir, "eth/db/archive/", readonly, archiveTableSize, archiveNoSnappy)
+	if err != nil {
+		return nil, err
+	}
+	return frdb, nil
+}
+
 // NewDatabaseWithFreezer creates a high level database on top of a given key-
 // value data store with a freezer moving immutable chain segments into cold
 // storage.
@@ -299,11 +330,16 @@ func InspectDatabase(db ethdb.Database, keyPrefix, keyStart []byte) error {
 		numHashPairings stat
 		hashNumPairings stat
 		tries           stat
+		accountTries    stat
+		storageTries    stat
+		reverseDiffs    stat
+		archiveIndex    stat
 		codes           stat
 		txLookups       stat
 		accountSnaps    stat
 		storageSnaps    stat
 		preimages       stat
+		preimageIndex   stat
 		bloomBits       stat
 		cliqueSnaps     stat
 
@@ -347,6 +383,18 @@ func InspectDatabase(db ethdb.Database, keyPrefix, keyStart []byte) error {
 			hashNumPairings.Add(size)
 		case len(key) == common.HashLength:
 			tries.Add(size)
+		case bytes.HasPrefix(key, reverseDiffPrefix) && len(key) == len(reverseDiffPrefix)+8:
+			reverseDiffs.Add(size)
+		case bytes.HasPrefix(key, archiveAccountPrefix) && len(key) == len(archiveAccountPrefix)+common.HashLength+8:
+			archiveIndex.Add(size)
+		case bytes.HasPrefix(key, archiveStoragePrefix) && len(key) == len(archiveStoragePrefix)+2*common.HashLength+8:
+			archiveIndex.Add(size)
+		case bytes.HasPrefix(key, archiveDestructPrefix) && len(key) == len(archiveDestructPrefix)+common.HashLength+8:
+			archiveIndex.Add(size)
+		case isAccountTrieNodeKey(key):
+			accountTries.Add(size)
+		case isStorageTrieNodeKey(key):
+			storageTries.Add(size)
 		case bytes.HasPrefix(key, CodePrefix) && len(key) == len(CodePrefix)+common.HashLength:
 			codes.Add(size)
 		case bytes.HasPrefix(key, txLookupPrefix) && len(key) == (len(txLookupPrefix)+common.HashLength):
@@ -357,6 +405,8 @@ func InspectDatabase(db ethdb.Database, keyPrefix, keyStart []byte) error {
 			storageSnaps.Add(size)
 		case bytes.HasPrefix(key, preimagePrefix) && len(key) == (len(preimagePrefix)+common.HashLength):
 			preimages.Add(size)
+		case bytes.HasPrefix(key, preimageIndexPrefix) && len(key) == (len(preimageIndexPrefix)+common.HashLength):
+			preimageIndex.Add(size)
 		case bytes.HasPrefix(key, configPrefix) && len(key) == (len(configPrefix)+common.HashLength):
 			metadata.Add(size)
 		case bytes.HasPrefix(key, bloomBitsPrefix) && len(key) == (len(bloomBitsPrefix)+10+common.HashLength):
@@ -379,7 +429,7 @@ func InspectDatabase(db ethdb.Database, keyPrefix, keyStart []byte) error {
 				databaseVersionKey, headHeaderKey, headBlockKey, headFastBlockKey, lastPivotKey,
 				fastTrieProgressKey, snapshotDisabledKey, snapshotRootKey, snapshotJournalKey,
 				snapshotGeneratorKey, snapshotRecoveryKey, txIndexTailKey, fastTxLookupLimitKey,
-				uncleanShutdownKey, badBlockKey,
+				uncleanShutdownKey, badBlockKey, onlinePruningKey, trieSchemeKey, reverseDiffHeadKey,
 			} {
 				if bytes.Equal(key, meta) {
 					metadata.Add(size)
@@ -422,7 +472,12 @@ func InspectDatabase(db ethdb.Database, keyPrefix, keyStart []byte) error {
 		{"Key-Value store", "Bloombit index", bloomBits.Size(), bloomBits.Count()},
 		{"Key-Value store", "Contract codes", codes.Size(), codes.Count()},
 		{"Key-Value store", "Trie nodes", tries.Size(), tries.Count()},
+		{"Key-Value store", "Path trie account nodes", accountTries.Size(), accountTries.Count()},
+		{"Key-Value store", "Path trie storage nodes", storageTries.Size(), storageTries.Count()},
+		{"Key-Value store", "Path trie reverse diffs", reverseDiffs.Size(), reverseDiffs.Count()},
+		{"Key-Value store", "Archived state index", archiveIndex.Size(), archiveIndex.Count()},
 		{"Key-Value store", "Trie preimages", preimages.Size(), preimages.Count()},
+		{"Key-Value store", "Trie preimage index", preimageIndex.Size(), preimageIndex.Count()},
 		{"Key-Value store", "Account snapshot", accountSnaps.Size(), accountSnaps.Count()},
 		{"Key-Value store", "Storage snapshot", storageSnaps.Size(), storageSnaps.Count()},
 		{"Key-Value store", "Clique snapshots", cliqueSnaps.Size(), cliqueSnaps.Count()},
@@ -64,6 +64,18 @@ const (
 
 	// freezerTableSize defines the maximum size of freezer data files.
 	freezerTableSize = 2 * 1000 * 1000 * 1000
+
+	// stateDiffTableSize defines the maximum size of reverse state diff data
+	// files. It's kept small, as the diffs leaving the retention window can only
+	// be deleted by files.
+	stateDiffTableSize = 64 * 1024 * 1024
+
+	// archiveTableSize defines the maximum size of the archived flat state diff
+	// data files.
+	archiveTableSize = 256 * 1024 * 1024
+
+	// preimageTableSize defines the maximum size of the preimage data files.
+	preimageTableSize = 256 * 1024 * 1024
 )
 
 // freezer is an memory mapped append-only database to store immutable chain data
@@ -218,6 +230,18 @@ func (f *freezer) Ancients() (uint64, error) {
 	return atomic.LoadUint64(&f.frozen), nil
 }
 
+// Tail returns the number of the first item stored in all the tables, which is
+// the number of items deleted from the tail as well.
+func (f *freezer) Tail() (uint64, error) {
+	var tail uint64
+	for _, table := range f.tables {
+		if n := table.tail(); n > tail {
+			tail = n
+		}
+	}
+	return tail, nil
+}
+
 // AncientSize returns the ancient size of the specified category.
 func (f *freezer) AncientSize(kind string) (uint64, error) {
 	// This needs the write lock to avoid data races on table fields.
@@ -285,6 +309,27 @@ func (f *freezer) TruncateAncients(items uint64) error {
 	return nil
 }
 
+// TruncateTail discards the data files holding only items below the provided
+// threshold number. If the threshold is beyond the stored items, all the data is
+// discarded and the freezer continues from the threshold.
+func (f *freezer) TruncateTail(tail uint64) error {
+	if f.readonly {
+		return errReadOnly
+	}
+	f.writeLock.Lock()
+	defer f.writeLock.Unlock()
+
+	for _, table := range f.tables {
+		if err := table.truncateTail(tail); err != nil {
+			return err
+		}
+	}
+	if atomic.LoadUint64(&f.frozen) < tail {
+		atomic.StoreUint64(&f.frozen, tail)
+	}
+	return nil
+}
+
 // Sync flushes all data tables to disk.
 func (f *freezer) Sync() error {
 	var errs []error
@@ -22,6 +22,7 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"math"
 	"os"
 	"path/filepath"
 	"sync"
@@ -261,8 +262,12 @@ func (t *freezerTable) repair() error {
 	}
 	contentSize = stat.Size()
 
-	// Keep truncating both files until they come in sync
+	// Keep truncating both files until they come in sync. If the index holds
+	// only the first entry, that carries the tail offset instead of a position.
 	contentExp = int64(lastIndex.offset)
+	if offsetsSize == indexEntrySize {
+		contentExp = 0
+	}
 
 	for contentExp != contentSize {
 		// Truncate the head file to the last offset pointer
@@ -299,6 +304,9 @@ func (t *freezerTable) repair() error {
 			}
 			lastIndex = newLastIndex
 			contentExp = int64(lastIndex.offset)
+			if offsetsSize == indexEntrySize {
+				contentExp = 0
+			}
 		}
 	}
 	// Ensure all reparation changes have been written to disk
@@ -360,16 +368,27 @@ func (t *freezerTable) truncate(items uint64) error {
 		log = t.logger.Warn // Only loud warn if we delete multiple items
 	}
 	log("Truncating freezer table", "items", existing, "limit", items)
-	if err := truncateFreezerFile(t.index, int64(items+1)*indexEntrySize); err != nil {
-		return err
+
+	// Items deleted from the tail can't be truncated away once more
+	offset := uint64(t.itemOffset)
+	if items < offset {
+		return errOutOfBounds
 	}
-	// Calculate the new expected size of the data file and truncate it
-	buffer := make([]byte, indexEntrySize)
-	if _, err := t.index.ReadAt(buffer, int64(items*indexEntrySize)); err != nil {
+	if err := truncateFreezerFile(t.index, int64(items-offset+1)*indexEntrySize); err != nil {
 		return err
 	}
+	// Calculate the new expected size of the data file and truncate it. If all
+	// items are gone, the first index entry carries the tail offset instead.
 	var expected indexEntry
-	expected.unmarshalBinary(buffer)
+	if items == offset {
+		expected = indexEntry{filenum: t.tailId, offset: 0}
+	} else {
+		buffer := make([]byte, indexEntrySize)
+		if _, err := t.index.ReadAt(buffer, int64((items-offset)*indexEntrySize)); err != nil {
+			return err
+		}
+		expected.unmarshalBinary(buffer)
+	}
 
 	// We might need to truncate back to older files
 	if expected.filenum != t.headId {
@@ -403,6 +422,112 @@ func (t *freezerTable) truncate(items uint64) error {
 	return nil
 }
 
+// truncateTail discards the data files holding only items below the provided
+// threshold number, rewriting the index to start at the first item of the
+// earliest retained file. As data is deleted by files, some items below the
+// threshold may be retained. If the threshold is beyond the stored items, the
+// table is emptied and continues from the threshold.
+func (t *freezerTable) truncateTail(items uint64) error {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	offset := uint64(t.itemOffset)
+	if items <= offset {
+		return nil
+	}
+	if items > math.MaxUint32 {
+		return errOutOfBounds
+	}
+	oldSize, err := t.sizeNolock()
+	if err != nil {
+		return err
+	}
+	var (
+		existing = atomic.LoadUint64(&t.items)
+		tail     indexEntry // First index entry, the earliest file and the item offset
+		entries  []byte     // Index entries of the retained items
+	)
+	if items >= existing {
+		// Everything is deleted, continue in a fresh head file
+		tail = indexEntry{filenum: t.headId + 1, offset: uint32(items)}
+	} else {
+		buffer := make([]byte, (existing-offset+1)*indexEntrySize)
+		if _, err := t.index.ReadAt(buffer, 0); err != nil {
+			return err
+		}
+		// An item is stored in the file its ending index entry points to, so find
+		// the file of the threshold item and the first item stored in it
+		var entry indexEntry
+		entry.unmarshalBinary(buffer[(items-offset+1)*indexEntrySize:])
+		if entry.filenum == t.tailId {
+			return nil
+		}
+		first := items
+		for ; first > offset; first-- {
+			var prev indexEntry
+			prev.unmarshalBinary(buffer[(first-offset)*indexEntrySize:])
+			if prev.filenum != entry.filenum {
+				break
+			}
+		}
+		tail = indexEntry{filenum: entry.filenum, offset: uint32(first)}
+		entries = buffer[(first-offset+1)*indexEntrySize:]
+	}
+	// Replace the index file, starting with the new tail entry
+	var (
+		name    = t.index.Name()
+		tmpName = name + ".tmp"
+	)
+	tmp, err := openFreezerFileTruncated(tmpName)
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(append(tail.append(nil), entries...)); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	tmp.Close()
+	t.index.Close()
+	if err := os.Rename(tmpName, name); err != nil {
+		return err
+	}
+	if t.index, err = openFreezerFileForAppend(name); err != nil {
+		return err
+	}
+	// Open the new head file if everything was deleted, and drop the stale files
+	if tail.filenum > t.headId {
+		if t.head, err = t.openFile(tail.filenum, openFreezerFileTruncated); err != nil {
+			return err
+		}
+		t.headId, t.headBytes = tail.filenum, 0
+		atomic.StoreUint64(&t.items, items)
+	}
+	t.releaseFilesBefore(tail.filenum, true)
+	t.tailId, t.itemOffset = tail.filenum, tail.offset
+
+	// Retrieve the new size and update the total size counter
+	newSize, err := t.sizeNolock()
+	if err != nil {
+		return err
+	}
+	t.sizeGauge.Dec(int64(oldSize - newSize))
+	t.logger.Debug("Truncated freezer table tail", "items", items, "tail", t.itemOffset)
+	return nil
+}
+
+// tail returns the number of the first item stored in the table, which is the
+// number of items deleted from the tail as well.
+func (t *freezerTable) tail() uint64 {
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+
+	return uint64(t.itemOffset)
+}
+
 // Close closes all opened files.
 func (t *freezerTable) Close() error {
 	t.lock.Lock()
@@ -455,6 +580,19 @@ func (t *freezerTable) releaseFile(num uint32) {
 	}
 }
 
+// releaseFilesBefore closes all open files with a lower number, and optionally also deletes the files
+func (t *freezerTable) releaseFilesBefore(num uint32, remove bool) {
+	for fnum, f := range t.files {
+		if fnum < num {
+			delete(t.files, fnum)
+			f.Close()
+			if remove {
+				os.Remove(f.Name())
+			}
+		}
+	}
+}
+
 // releaseFilesAfter closes all open files with a higher number, and optionally also deletes the files
 func (t *freezerTable) releaseFilesAfter(num uint32, remove bool) {
 	for fnum, f := range t.files {
@@ -651,7 +789,10 @@ func (t *freezerTable) retrieveItems(start, count, maxBytes uint64) ([]byte, []i
 // has returns an indicator whether the specified number data
 // exists in the freezer table.
 func (t *freezerTable) has(number uint64) bool {
-	return atomic.LoadUint64(&t.items) > number
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+
+	return atomic.LoadUint64(&t.items) > number && uint64(t.itemOffset) <= number
 }
 
 // size returns the total data size in the freezer table.
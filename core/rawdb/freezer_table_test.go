@@ -398,6 +398,110 @@ func TestFreezerTruncate(t *testing.T) {
 	}
 }
 
+// TestFreezerTruncateTail tests that deleting items from the tail of a table
+// drops whole data files, and that the table stays usable afterwards.
+func TestFreezerTruncateTail(t *testing.T) {
+	t.Parallel()
+	rm, wm, sg := metrics.NewMeter(), metrics.NewMeter(), metrics.NewGauge()
+	fname := fmt.Sprintf("truncationtail-%d", rand.Uint64())
+
+	// Fill table, 3 items per data file
+	{
+		f, err := newTable(os.TempDir(), fname, rm, wm, sg, 50, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		writeChunks(t, f, 30, 15)
+
+		// Deleting within the first file is a noop
+		if err := f.truncateTail(2); err != nil {
+			t.Fatal(err)
+		}
+		if f.tail() != 0 {
+			t.Fatalf("expected tail %d, got %d", 0, f.tail())
+		}
+		// Deleting into the second file drops the first one only
+		if err := f.truncateTail(4); err != nil {
+			t.Fatal(err)
+		}
+		if f.tail() != 3 {
+			t.Fatalf("expected tail %d, got %d", 3, f.tail())
+		}
+		if _, err := os.Stat(filepath.Join(os.TempDir(), fmt.Sprintf("%s.0000.rdat", fname))); !os.IsNotExist(err) {
+			t.Fatalf("expected data file to be deleted: %v", err)
+		}
+		checkRetrieveError(t, f, map[uint64]error{2: errOutOfBounds})
+		checkRetrieve(t, f, map[uint64][]byte{3: getChunk(15, 3), 29: getChunk(15, 29)})
+		f.Close()
+	}
+	// Reopen, ensure the tail is retained and truncate the head below it
+	{
+		f, err := newTable(os.TempDir(), fname, rm, wm, sg, 50, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if f.items != 30 || f.tail() != 3 {
+			t.Fatalf("expected %d items from %d, got %d from %d", 30, 3, f.items, f.tail())
+		}
+		checkRetrieve(t, f, map[uint64][]byte{3: getChunk(15, 3), 4: getChunk(15, 4), 29: getChunk(15, 29)})
+
+		if err := f.truncate(2); err != errOutOfBounds {
+			t.Fatalf("expected error %v, got %v", errOutOfBounds, err)
+		}
+		if err := f.truncate(3); err != nil {
+			t.Fatal(err)
+		}
+		if f.items != 3 || f.headBytes != 0 {
+			t.Fatalf("expected empty table, got %d items, %d bytes", f.items, f.headBytes)
+		}
+		batch := f.newBatch()
+		if err := batch.AppendRaw(3, getChunk(15, 0xaa)); err != nil {
+			t.Fatal(err)
+		}
+		if err := batch.commit(); err != nil {
+			t.Fatal(err)
+		}
+		checkRetrieve(t, f, map[uint64][]byte{3: getChunk(15, 0xaa)})
+		f.Close()
+	}
+	// Reopen, delete everything and continue at a later item
+	{
+		f, err := newTable(os.TempDir(), fname, rm, wm, sg, 50, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		checkRetrieve(t, f, map[uint64][]byte{3: getChunk(15, 0xaa)})
+
+		if err := f.truncateTail(100); err != nil {
+			t.Fatal(err)
+		}
+		if f.items != 100 || f.tail() != 100 {
+			t.Fatalf("expected empty table at %d, got %d items from %d", 100, f.items, f.tail())
+		}
+		checkRetrieveError(t, f, map[uint64]error{3: errOutOfBounds})
+
+		batch := f.newBatch()
+		if err := batch.AppendRaw(100, getChunk(15, 0xbb)); err != nil {
+			t.Fatal(err)
+		}
+		if err := batch.commit(); err != nil {
+			t.Fatal(err)
+		}
+		f.Close()
+	}
+	{
+		f, err := newTable(os.TempDir(), fname, rm, wm, sg, 50, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		if f.items != 101 || f.tail() != 100 {
+			t.Fatalf("expected %d items from %d, got %d from %d", 101, 100, f.items, f.tail())
+		}
+		checkRetrieve(t, f, map[uint64][]byte{100: getChunk(15, 0xbb)})
+	}
+}
+
 // TestFreezerRepairFirstFile tests a head file with the very first item only half-written.
 // That will rewind the index, and _should_ truncate the head file
 func TestFreezerRepairFirstFile(t *testing.T) {
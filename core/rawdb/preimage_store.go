@@ -0,0 +1,167 @@
+// Copyright 2021 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package rawdb
+
+import (
+	"bytes"
+	"sort"
+	"sync"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/ethdb"
+)
+
+// preimageFlushItems is the number of appended preimages above which the store
+// is flushed to disk, bounding the memory held by the unflushed index entries.
+const preimageFlushItems = 16384
+
+// PreimageStore is an append-only store of the preimages of hashed trie keys.
+// The preimages are appended to a freezer, only their numbers are kept in the
+// key-value store for the lookups by hash.
+//
+// The appends are not synced to disk one by one, the index entries of the new
+// preimages are buffered in memory instead until the store is flushed, either
+// explicitly, once enough were gathered or on close.
+type PreimageStore struct {
+	db      ethdb.KeyValueStore    // Key-value store holding the preimage index
+	frdb    ethdb.AncientStore     // Freezer holding the preimages themselves
+	pending map[common.Hash]uint64 // Index entries of the preimages not yet flushed
+	lock    sync.Mutex             // Lock protecting the appends and the pending index
+}
+
+// NewPreimageStore opens the preimage store in the given directory, indexed in
+// the given key-value store.
+func NewPreimageStore(db ethdb.KeyValueStore, datadir string, readonly bool) (*PreimageStore, error) {
+	frdb, err := newFreezer(datadir, "eth/db/preimages/", readonly, preimageTableSize, preimageNoSnappy)
+	if err != nil {
+		return nil, err
+	}
+	return &PreimageStore{db: db, frdb: frdb, pending: make(map[common.Hash]uint64)}, nil
+}
+
+// Preimage retrieves the preimage of the given hash, falling back to the ones
+// stored directly in the key-value store by earlier versions.
+func (s *PreimageStore) Preimage(hash common.Hash) []byte {
+	s.lock.Lock()
+	number, ok := s.pending[hash]
+	s.lock.Unlock()
+
+	if !ok {
+		number, ok = ReadPreimageIndex(s.db, hash)
+	}
+	if ok {
+		if blob, err := s.frdb.Ancient(preimageTable, number); err == nil {
+			return blob
+		}
+	}
+	return ReadPreimage(s.db, hash)
+}
+
+// Write appends the preimages not yet known to the store, in hash order. They
+// are only indexed on disk when the store is flushed.
+func (s *PreimageStore) Write(preimages map[common.Hash][]byte) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	hashes := make([]common.Hash, 0, len(preimages))
+	for hash := range preimages {
+		if _, ok := s.pending[hash]; ok {
+			continue
+		}
+		if _, ok := ReadPreimageIndex(s.db, hash); !ok {
+			hashes = append(hashes, hash)
+		}
+	}
+	if len(hashes) == 0 {
+		return nil
+	}
+	sort.Slice(hashes, func(i, j int) bool { return bytes.Compare(hashes[i][:], hashes[j][:]) < 0 })
+
+	next, err := s.frdb.Ancients()
+	if err != nil {
+		return err
+	}
+	if _, err := s.frdb.ModifyAncients(func(op ethdb.AncientWriteOp) error {
+		for i, hash := range hashes {
+			if err := op.AppendRaw(preimageTable, next+uint64(i), preimages[hash]); err != nil {
+				return err
+			}
+		}
+		return nil
+	}); err != nil {
+		return err
+	}
+	for i, hash := range hashes {
+		s.pending[hash] = next + uint64(i)
+	}
+	preimageCounter.Inc(int64(len(hashes)))
+
+	if len(s.pending) >= preimageFlushItems {
+		return s.flush()
+	}
+	return nil
+}
+
+// Flush syncs the appended preimages to disk and indexes them.
+func (s *PreimageStore) Flush() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return s.flush()
+}
+
+// flush syncs the appended preimages to disk before indexing them, so that an
+// interruption can at worst leave some unindexed duplicates behind.
+//
+// The caller must hold the lock.
+func (s *PreimageStore) flush() error {
+	if len(s.pending) == 0 {
+		return nil
+	}
+	if err := s.frdb.Sync(); err != nil {
+		return err
+	}
+	batch := s.db.NewBatch()
+	for hash, number := range s.pending {
+		WritePreimageIndex(batch, hash, number)
+		if batch.ValueSize() > ethdb.IdealBatchSize {
+			if err := batch.Write(); err != nil {
+				return err
+			}
+			batch.Reset()
+		}
+	}
+	if err := batch.Write(); err != nil {
+		return err
+	}
+	s.pending = make(map[common.Hash]uint64)
+	return nil
+}
+
+// Count returns the number of preimages appended to the store.
+func (s *PreimageStore) Count() (uint64, error) {
+	return s.frdb.Ancients()
+}
+
+// Close flushes the store and closes its freezer.
+func (s *PreimageStore) Close() error {
+	if err := s.Flush(); err != nil {
+		s.frdb.Close()
+		return err
+	}
+	return s.frdb.Close()
+}
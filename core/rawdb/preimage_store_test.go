@@ -0,0 +1,106 @@
+// Copyright 2021 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package rawdb
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// Tests that the preimage store deduplicates the appended preimages, indexes them
+// on flush, persists them across restarts and falls back to the legacy preimages.
+func TestPreimageStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "preimages")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	db := NewMemoryDatabase()
+	store, err := NewPreimageStore(db, dir, false)
+	if err != nil {
+		t.Fatalf("failed to open preimage store: %v", err)
+	}
+	preimages := make(map[common.Hash][]byte)
+	for i := 0; i < 100; i++ {
+		blob := []byte{byte(i), 0xff}
+		preimages[crypto.Keccak256Hash(blob)] = blob
+	}
+	if err := store.Write(preimages); err != nil {
+		t.Fatalf("failed to write preimages: %v", err)
+	}
+	// The preimages should be served, but only indexed on disk once flushed
+	for hash, blob := range preimages {
+		if have := store.Preimage(hash); !bytes.Equal(have, blob) {
+			t.Fatalf("unflushed preimage %x mismatch: have %x, want %x", hash, have, blob)
+		}
+		if _, ok := ReadPreimageIndex(db, hash); ok {
+			t.Fatalf("unflushed preimage %x indexed", hash)
+		}
+	}
+	if err := store.Flush(); err != nil {
+		t.Fatalf("failed to flush preimages: %v", err)
+	}
+	for hash := range preimages {
+		if _, ok := ReadPreimageIndex(db, hash); !ok {
+			t.Fatalf("flushed preimage %x not indexed", hash)
+		}
+	}
+	// Rewrite a subset with some new ones, only the latter should be appended
+	more := map[common.Hash][]byte{
+		crypto.Keccak256Hash([]byte{0x00, 0xff}): {0x00, 0xff},
+		crypto.Keccak256Hash([]byte{0xaa}):       {0xaa},
+	}
+	if err := store.Write(more); err != nil {
+		t.Fatalf("failed to write more preimages: %v", err)
+	}
+	preimages[crypto.Keccak256Hash([]byte{0xaa})] = []byte{0xaa}
+
+	legacy := []byte("legacy")
+	WritePreimages(db, map[common.Hash][]byte{crypto.Keccak256Hash(legacy): legacy})
+	preimages[crypto.Keccak256Hash(legacy)] = legacy
+
+	check := func(store *PreimageStore) {
+		t.Helper()
+		if count, err := store.Count(); err != nil || count != 101 {
+			t.Fatalf("preimage count mismatch: have %d, want %d (%v)", count, 101, err)
+		}
+		for hash, blob := range preimages {
+			if have := store.Preimage(hash); !bytes.Equal(have, blob) {
+				t.Fatalf("preimage %x mismatch: have %x, want %x", hash, have, blob)
+			}
+		}
+		if blob := store.Preimage(common.Hash{0x01}); blob != nil {
+			t.Fatalf("unknown preimage returned: %x", blob)
+		}
+	}
+	check(store)
+
+	if err := store.Close(); err != nil {
+		t.Fatalf("failed to close preimage store: %v", err)
+	}
+	if store, err = NewPreimageStore(db, dir, true); err != nil {
+		t.Fatalf("failed to reopen preimage store: %v", err)
+	}
+	defer store.Close()
+	check(store)
+}
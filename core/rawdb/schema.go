@@ -63,6 +63,16 @@ var (
 	// snapshotSyncStatusKey tracks the snapshot sync status across restarts.
 	snapshotSyncStatusKey = []byte("SnapshotSyncStatus")
 
+	// onlinePruningKey tracks the progress of the background state pruner across restarts.
+	onlinePruningKey = []byte("OnlinePruning")
+
+	// trieSchemeKey tracks the storage scheme of the trie nodes of the database.
+	trieSchemeKey = []byte("TrieScheme")
+
+	// reverseDiffHeadKey tracks the id of the latest reverse diff of the path-based
+	// trie node storage, which is also the id of the persisted state.
+	reverseDiffHeadKey = []byte("ReverseDiffHead")
+
 	// txIndexTailKey tracks the oldest block whose transactions have been indexed.
 	txIndexTailKey = []byte("TransactionIndexTail")
 
@@ -89,9 +99,17 @@ var (
 	SnapshotAccountPrefix = []byte("a") // SnapshotAccountPrefix + account hash -> account trie value
 	SnapshotStoragePrefix = []byte("o") // SnapshotStoragePrefix + account hash + storage hash -> storage trie value
 	CodePrefix            = []byte("c") // CodePrefix + code hash -> account code
+	TrieNodeAccountPrefix = []byte("A") // TrieNodeAccountPrefix + hexPath -> account trie node (path scheme)
+	TrieNodeStoragePrefix = []byte("O") // TrieNodeStoragePrefix + account hash + hexPath -> storage trie node (path scheme)
+	reverseDiffPrefix     = []byte("R") // reverseDiffPrefix + id (uint64 big endian) -> reverse diff of a state transition
+
+	archiveAccountPrefix  = []byte("v") // archiveAccountPrefix + account hash + ^num (uint64 big endian) -> archived account
+	archiveStoragePrefix  = []byte("w") // archiveStoragePrefix + account hash + storage hash + ^num (uint64 big endian) -> archived storage value
+	archiveDestructPrefix = []byte("x") // archiveDestructPrefix + account hash + ^num (uint64 big endian) -> archived storage wipe
 
-	preimagePrefix = []byte("secure-key-")      // preimagePrefix + hash -> preimage
-	configPrefix   = []byte("ethereum-config-") // config prefix for the db
+	preimagePrefix      = []byte("secure-key-")      // preimagePrefix + hash -> preimage
+	preimageIndexPrefix = []byte("secure-index-")    // preimageIndexPrefix + hash -> number of the preimage in the preimage store (uint64 big endian)
+	configPrefix        = []byte("ethereum-config-") // config prefix for the db
 
 	// Chain index prefixes (use `i` + single byte to avoid mixing data types).
 	BloomBitsIndexPrefix = []byte("iB") // BloomBitsIndexPrefix is the data table of a chain indexer to track its progress
@@ -127,6 +145,50 @@ var FreezerNoSnappy = map[string]bool{
 	freezerDifficultyTable: true,
 }
 
+const (
+	// stateDiffTable indicates the name of the reverse state diff table.
+	stateDiffTable = "statediffs"
+
+	// stateDiffHashTable indicates the name of the table holding the hashes of
+	// the blocks the reverse state diffs belong to.
+	stateDiffHashTable = "hashes"
+)
+
+const (
+	// archiveDiffTable indicates the name of the archived flat state diff table.
+	archiveDiffTable = "diffs"
+
+	// archiveHashTable indicates the name of the table holding the hashes of the
+	// blocks the archived flat state diffs belong to.
+	archiveHashTable = "hashes"
+)
+
+const (
+	// preimageTable indicates the name of the table holding the preimages of the
+	// hashed trie keys.
+	preimageTable = "preimages"
+)
+
+// preimageNoSnappy configures whether compression is disabled for the preimage
+// table. Preimages are mostly hashes and addresses, which don't compress well.
+var preimageNoSnappy = map[string]bool{
+	preimageTable: true,
+}
+
+// archiveNoSnappy configures whether compression is disabled for the archived
+// flat state diff tables.
+var archiveNoSnappy = map[string]bool{
+	archiveDiffTable: false,
+	archiveHashTable: true,
+}
+
+// stateDiffNoSnappy configures whether compression is disabled for the reverse
+// state diff tables.
+var stateDiffNoSnappy = map[string]bool{
+	stateDiffTable:     false,
+	stateDiffHashTable: true,
+}
+
 // LegacyTxLookupEntry is the legacy TxLookupEntry definition with some unnecessary
 // fields.
 type LegacyTxLookupEntry struct {
@@ -212,6 +274,11 @@ func preimageKey(hash common.Hash) []byte {
 	return append(preimagePrefix, hash.Bytes()...)
 }
 
+// preimageIndexKey = preimageIndexPrefix + hash
+func preimageIndexKey(hash common.Hash) []byte {
+	return append(preimageIndexPrefix, hash.Bytes()...)
+}
+
 // codeKey = CodePrefix + hash
 func codeKey(hash common.Hash) []byte {
 	return append(CodePrefix, hash.Bytes()...)
@@ -226,6 +293,86 @@ func IsCodeKey(key []byte) (bool, []byte) {
 	return false, nil
 }
 
+// accountTrieNodeKey = TrieNodeAccountPrefix + nodePath.
+func accountTrieNodeKey(path []byte) []byte {
+	return append(TrieNodeAccountPrefix, path...)
+}
+
+// storageTrieNodeKey = TrieNodeStoragePrefix + accountHash + nodePath.
+func storageTrieNodeKey(accountHash common.Hash, path []byte) []byte {
+	return append(append(TrieNodeStoragePrefix, accountHash.Bytes()...), path...)
+}
+
+// IsAccountTrieNode reports whether a provided database entry is an account
+// trie node in path-based state scheme, if so return the node path as well.
+func IsAccountTrieNode(key []byte) (bool, []byte) {
+	if !bytes.HasPrefix(key, TrieNodeAccountPrefix) {
+		return false, nil
+	}
+	// The remaining key should only consist a hex node path
+	// whose length is in the range 0 to 64 (65 is excluded
+	// since leaves are always wrapped with shortNode).
+	if len(key) >= len(TrieNodeAccountPrefix)+common.HashLength*2 {
+		return false, nil
+	}
+	return true, key[len(TrieNodeAccountPrefix):]
+}
+
+// IsStorageTrieNode reports whether a provided database entry is a storage
+// trie node in path-based state scheme, if so return the owner and the node
+// path as well.
+func IsStorageTrieNode(key []byte) (bool, common.Hash, []byte) {
+	if !bytes.HasPrefix(key, TrieNodeStoragePrefix) {
+		return false, common.Hash{}, nil
+	}
+	if len(key) < len(TrieNodeStoragePrefix)+common.HashLength {
+		return false, common.Hash{}, nil
+	}
+	if len(key) >= len(TrieNodeStoragePrefix)+common.HashLength+common.HashLength*2 {
+		return false, common.Hash{}, nil
+	}
+	owner := common.BytesToHash(key[len(TrieNodeStoragePrefix) : len(TrieNodeStoragePrefix)+common.HashLength])
+	return true, owner, key[len(TrieNodeStoragePrefix)+common.HashLength:]
+}
+
+// isAccountTrieNodeKey reports whether the key is an account trie node key.
+func isAccountTrieNodeKey(key []byte) bool {
+	ok, _ := IsAccountTrieNode(key)
+	return ok
+}
+
+// isStorageTrieNodeKey reports whether the key is a storage trie node key.
+func isStorageTrieNodeKey(key []byte) bool {
+	ok, _, _ := IsStorageTrieNode(key)
+	return ok
+}
+
+// reverseDiffKey = reverseDiffPrefix + id (uint64 big endian)
+func reverseDiffKey(id uint64) []byte {
+	return append(reverseDiffPrefix, encodeBlockNumber(id)...)
+}
+
+// archiveNumber encodes a block number inverted, so that a forward iteration
+// from a given number reaches the closest lower one first.
+func archiveNumber(number uint64) []byte {
+	return encodeBlockNumber(^number)
+}
+
+// archiveAccountKey = archiveAccountPrefix + account hash + ^num (uint64 big endian)
+func archiveAccountKey(accountHash common.Hash, number uint64) []byte {
+	return append(append(archiveAccountPrefix, accountHash.Bytes()...), archiveNumber(number)...)
+}
+
+// archiveStorageKey = archiveStoragePrefix + account hash + storage hash + ^num (uint64 big endian)
+func archiveStorageKey(accountHash, storageHash common.Hash, number uint64) []byte {
+	return append(append(append(archiveStoragePrefix, accountHash.Bytes()...), storageHash.Bytes()...), archiveNumber(number)...)
+}
+
+// archiveDestructKey = archiveDestructPrefix + account hash + ^num (uint64 big endian)
+func archiveDestructKey(accountHash common.Hash, number uint64) []byte {
+	return append(append(archiveDestructPrefix, accountHash.Bytes()...), archiveNumber(number)...)
+}
+
 // configKey = configPrefix + hash
 func configKey(hash common.Hash) []byte {
 	return append(configPrefix, hash.Bytes()...)
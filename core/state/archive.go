@@ -0,0 +1,182 @@
+// Copyright 2021 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package state
+
+import (
+	"bytes"
+	"errors"
+	"sort"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/core/state/snapshot"
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/ethereum/go-ethereum/rlp"
+)
+
+var (
+	// errArchivedCommit is returned (or recorded as the database error, if the
+	// call can't fail) when attempting to hash or commit a state opened from the
+	// archive, which has no tries to hash or commit into.
+	errArchivedCommit = errors.New("archived state cannot be committed")
+
+	// errArchivedProof is returned when attempting to prove an item of a state
+	// opened from the archive, which has no tries to prove against.
+	errArchivedProof = errors.New("archived state cannot be proven")
+)
+
+// FlatDiff contains the flat accounts and storage slots modified by a state
+// transition, as fed into the snapshot tree.
+type FlatDiff struct {
+	Destructs []common.Hash     // Accounts whose storage was wiped, sorted
+	Accounts  []FlatDiffAccount // Modified accounts, sorted by hash
+	Storage   []FlatDiffStorage // Modified storage slots, sorted by account hash
+}
+
+// FlatDiffAccount is the new content of a modified account.
+type FlatDiffAccount struct {
+	Hash common.Hash // Hash of the account address
+	Data []byte      // Slim account, empty if it was deleted
+}
+
+// FlatDiffStorage contains the modified storage slots of an account.
+type FlatDiffStorage struct {
+	Hash  common.Hash    // Hash of the account address
+	Slots []FlatDiffSlot // Modified storage slots, sorted by hash
+}
+
+// FlatDiffSlot is the new value of a modified storage slot.
+type FlatDiffSlot struct {
+	Hash  common.Hash // Hash of the storage slot key
+	Value []byte      // Trie encoded value, empty if the slot was deleted
+}
+
+// newFlatDiff assembles a flat diff from the snapshot update sets. Destructed
+// accounts which weren't recreated are included as deleted.
+func newFlatDiff(destructs map[common.Hash]struct{}, accounts map[common.Hash][]byte, storage map[common.Hash]map[common.Hash][]byte) *FlatDiff {
+	diff := &FlatDiff{
+		Destructs: make([]common.Hash, 0, len(destructs)),
+		Accounts:  make([]FlatDiffAccount, 0, len(accounts)),
+		Storage:   make([]FlatDiffStorage, 0, len(storage)),
+	}
+	for hash := range destructs {
+		diff.Destructs = append(diff.Destructs, hash)
+		if _, ok := accounts[hash]; !ok {
+			diff.Accounts = append(diff.Accounts, FlatDiffAccount{Hash: hash})
+		}
+	}
+	for hash, data := range accounts {
+		diff.Accounts = append(diff.Accounts, FlatDiffAccount{Hash: hash, Data: data})
+	}
+	for hash, slots := range storage {
+		entry := FlatDiffStorage{Hash: hash, Slots: make([]FlatDiffSlot, 0, len(slots))}
+		for slot, value := range slots {
+			entry.Slots = append(entry.Slots, FlatDiffSlot{Hash: slot, Value: value})
+		}
+		sort.Slice(entry.Slots, func(i, j int) bool {
+			return bytes.Compare(entry.Slots[i].Hash[:], entry.Slots[j].Hash[:]) < 0
+		})
+		diff.Storage = append(diff.Storage, entry)
+	}
+	sort.Slice(diff.Destructs, func(i, j int) bool {
+		return bytes.Compare(diff.Destructs[i][:], diff.Destructs[j][:]) < 0
+	})
+	sort.Slice(diff.Accounts, func(i, j int) bool {
+		return bytes.Compare(diff.Accounts[i].Hash[:], diff.Accounts[j].Hash[:]) < 0
+	})
+	sort.Slice(diff.Storage, func(i, j int) bool {
+		return bytes.Compare(diff.Storage[i].Hash[:], diff.Storage[j].Hash[:]) < 0
+	})
+	return diff
+}
+
+// TrackFlatDiff enables the tracking of the flat accounts and storage slots
+// modified in the state, making a flat diff available after commit. The diff
+// is only available if the state is backed by a snapshot.
+func (s *StateDB) TrackFlatDiff() {
+	s.flatTracked = true
+}
+
+// FlatDiff returns the flat diff of the last state commit, or nil if the tracking
+// wasn't enabled or there was no snapshot to feed.
+func (s *StateDB) FlatDiff() *FlatDiff {
+	return s.flat
+}
+
+// archiveReader is a read only snapshot of a historical state, served from the
+// archive index by seeking the latest change of each item at or before the
+// block of the state.
+type archiveReader struct {
+	db     ethdb.KeyValueStore
+	root   common.Hash
+	number uint64
+}
+
+// Root returns the root hash of the archived state.
+func (r *archiveReader) Root() common.Hash {
+	return r.root
+}
+
+// Account retrieves an account as it was in the archived state.
+func (r *archiveReader) Account(hash common.Hash) (*snapshot.Account, error) {
+	data, err := r.AccountRLP(hash)
+	if err != nil || len(data) == 0 {
+		return nil, err
+	}
+	account := new(snapshot.Account)
+	if err := rlp.DecodeBytes(data, account); err != nil {
+		return nil, err
+	}
+	return account, nil
+}
+
+// AccountRLP retrieves the slim account as it was in the archived state.
+func (r *archiveReader) AccountRLP(hash common.Hash) ([]byte, error) {
+	data, _, _ := rawdb.ReadArchiveAccount(r.db, hash, r.number)
+	return data, nil
+}
+
+// Storage retrieves a storage slot as it was in the archived state. Slots last
+// changed before a wipe of the account storage are considered deleted.
+func (r *archiveReader) Storage(accountHash, storageHash common.Hash) ([]byte, error) {
+	value, changed, ok := rawdb.ReadArchiveStorage(r.db, accountHash, storageHash, r.number)
+	if !ok || len(value) == 0 {
+		return nil, nil
+	}
+	if wiped, ok := rawdb.ReadArchiveDestruct(r.db, accountHash, r.number); ok && wiped > changed {
+		return nil, nil
+	}
+	return value, nil
+}
+
+// NewArchived creates a read only state of the given block from the archive
+// index, without any of its tries available. The state can be modified for
+// call execution, but can't be hashed, proven nor committed: IntermediateRoot
+// panics and the proof and commit methods return an error.
+func NewArchived(root common.Hash, number uint64, db Database) (*StateDB, error) {
+	sdb, err := New(common.Hash{}, db, nil)
+	if err != nil {
+		return nil, err
+	}
+	sdb.originalRoot = root
+	sdb.archived = true
+	sdb.snap = &archiveReader{db: db.TrieDB().DiskDB(), root: root, number: number}
+	sdb.snapDestructs = make(map[common.Hash]struct{})
+	sdb.snapAccounts = make(map[common.Hash][]byte)
+	sdb.snapStorage = make(map[common.Hash]map[common.Hash][]byte)
+	return sdb, nil
+}
@@ -0,0 +1,306 @@
+// Copyright 2021 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package state
+
+import (
+	"math/big"
+	"sync"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/state/snapshot"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/rlp"
+	lru "github.com/hashicorp/golang-lru"
+)
+
+// maxProofLayerItems is the maximum number of proofs cached for a single state.
+const maxProofLayerItems = 16384
+
+// ProofRequest is a request for the Merkle proof of an account and some of its
+// storage slots.
+type ProofRequest struct {
+	Address     common.Address
+	StorageKeys []common.Hash
+}
+
+// AccountProof is the Merkle proof of an account and the requested storage slots,
+// along with the proven values.
+type AccountProof struct {
+	Address  common.Address
+	Proof    [][]byte
+	Nonce    uint64
+	Balance  *big.Int
+	Root     common.Hash
+	CodeHash common.Hash
+	Storage  []StorageProof // Empty proofs if the account doesn't exist
+}
+
+// StorageProof is the Merkle proof of a storage slot along with its value.
+type StorageProof struct {
+	Key   common.Hash
+	Value common.Hash
+	Proof [][]byte
+}
+
+// Prover produces Merkle proofs of accounts and storage slots for the recent
+// states. The proven values are served from the snapshot if it covers the state,
+// while the proof nodes are retrieved from the tries once and then cached for
+// the state, sharing them across requests.
+//
+// The tries of every state retained through Retain are kept alive in the trie
+// database for as long as the snapshot maintains a diff layer for the state, so
+// all the states inside the snapshot's diff window stay provable, independently
+// of the garbage collection of the chain. Nodes flushed to disk meanwhile are
+// still resolved from there. With the path scheme, the tries can't be retained
+// beyond the layers kept by the trie database itself.
+//
+// The tries themselves are only opened for the duration of a batch of requests,
+// as they keep every node resolved through them in memory.
+type Prover struct {
+	db     Database
+	snaps  *snapshot.Tree
+	layers *lru.Cache // Proof layers of the recently proven states
+
+	retained map[common.Hash]struct{} // State roots referenced in the trie database
+	lock     sync.Mutex               // Lock protecting the retained states
+}
+
+// proofLayer contains the already produced proofs of a state.
+type proofLayer struct {
+	root   common.Hash
+	proofs map[string][][]byte // Proofs by account hash or account+slot hash
+	lock   sync.Mutex
+}
+
+// proofTries contains the tries of a state opened for a batch of requests.
+type proofTries struct {
+	accounts Trie
+	storages map[common.Hash]Trie
+}
+
+// NewProver creates a prover, caching the proofs of the given number of the
+// most recently proven states.
+func NewProver(db Database, snaps *snapshot.Tree, layers int) *Prover {
+	cache, _ := lru.New(layers)
+	return &Prover{
+		db:       db,
+		snaps:    snaps,
+		layers:   cache,
+		retained: make(map[common.Hash]struct{}),
+	}
+}
+
+// Retain keeps the tries of a newly committed state alive in the trie database
+// for as long as the snapshot maintains a diff layer for it, releasing all the
+// retained states whose layers were flattened or discarded meanwhile.
+//
+// It must be called right after the state was committed into the trie database
+// and the snapshot, before the chain gets a chance to dereference it.
+func (p *Prover) Retain(root common.Hash) {
+	if p.snaps == nil {
+		return
+	}
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	triedb := p.db.TrieDB()
+	if _, ok := p.retained[root]; !ok && p.snaps.Snapshot(root) != nil {
+		triedb.Reference(root, common.Hash{})
+		p.retained[root] = struct{}{}
+	}
+	for retained := range p.retained {
+		if p.snaps.Snapshot(retained) != nil {
+			continue
+		}
+		triedb.Dereference(retained)
+		delete(p.retained, retained)
+		p.layers.Remove(retained)
+	}
+}
+
+// Release drops all the retained states, leaving their tries to the garbage
+// collection of the chain. It is used when the snapshot diff window is discarded,
+// e.g. on a rebuild after a manual head change.
+func (p *Prover) Release() {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	triedb := p.db.TrieDB()
+	for retained := range p.retained {
+		triedb.Dereference(retained)
+		delete(p.retained, retained)
+		p.layers.Remove(retained)
+	}
+}
+
+// Prove produces the Merkle proofs of the requested accounts and storage slots
+// in the state with the given root.
+func (p *Prover) Prove(root common.Hash, requests []ProofRequest) ([]*AccountProof, error) {
+	tr, err := p.db.OpenTrie(root)
+	if err != nil {
+		return nil, err
+	}
+	tries := &proofTries{
+		accounts: tr,
+		storages: make(map[common.Hash]Trie),
+	}
+	var layer *proofLayer
+	if cached, ok := p.layers.Get(root); ok {
+		layer = cached.(*proofLayer)
+	} else {
+		layer = &proofLayer{
+			root:   root,
+			proofs: make(map[string][][]byte),
+		}
+		if exist, _ := p.layers.ContainsOrAdd(root, layer); exist {
+			if cached, ok := p.layers.Get(root); ok {
+				layer = cached.(*proofLayer)
+			}
+		}
+	}
+	var snap snapshot.Snapshot
+	if p.snaps != nil {
+		snap = p.snaps.Snapshot(root)
+	}
+	layer.lock.Lock()
+	defer layer.lock.Unlock()
+
+	results := make([]*AccountProof, 0, len(requests))
+	for _, req := range requests {
+		result, err := layer.prove(p.db, snap, tries, req)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+// prove produces the proofs of a single account and its requested slots.
+func (l *proofLayer) prove(db Database, snap snapshot.Snapshot, tries *proofTries, req ProofRequest) (*AccountProof, error) {
+	addrHash := crypto.Keccak256Hash(req.Address[:])
+	account, err := l.account(snap, tries.accounts, req.Address, addrHash)
+	if err != nil {
+		return nil, err
+	}
+	proof, err := l.proof(tries.accounts, addrHash[:], string(addrHash[:]))
+	if err != nil {
+		return nil, err
+	}
+	result := &AccountProof{
+		Address:  req.Address,
+		Proof:    proof,
+		Balance:  new(big.Int),
+		Root:     emptyRoot,
+		CodeHash: common.BytesToHash(emptyCodeHash),
+		Storage:  make([]StorageProof, len(req.StorageKeys)),
+	}
+	if account == nil {
+		for i, key := range req.StorageKeys {
+			result.Storage[i] = StorageProof{Key: key, Proof: [][]byte{}}
+		}
+		return result, nil
+	}
+	result.Nonce, result.Balance, result.Root = account.Nonce, account.Balance, account.Root
+	result.CodeHash = common.BytesToHash(account.CodeHash)
+
+	// Open the storage trie only if the account has any storage
+	var storage Trie
+	if account.Root != emptyRoot {
+		if storage = tries.storages[addrHash]; storage == nil {
+			if storage, err = db.OpenStorageTrie(addrHash, account.Root); err != nil {
+				return nil, err
+			}
+			tries.storages[addrHash] = storage
+		}
+	}
+	for i, key := range req.StorageKeys {
+		slotHash := crypto.Keccak256Hash(key[:])
+		result.Storage[i] = StorageProof{Key: key, Proof: [][]byte{}}
+		if storage == nil {
+			continue
+		}
+		if result.Storage[i].Value, err = l.slot(snap, storage, addrHash, key, slotHash); err != nil {
+			return nil, err
+		}
+		if result.Storage[i].Proof, err = l.proof(storage, slotHash[:], string(addrHash[:])+string(slotHash[:])); err != nil {
+			return nil, err
+		}
+	}
+	return result, nil
+}
+
+// account retrieves an account from the snapshot if possible, or from the trie
+// otherwise. Nil is returned if the account doesn't exist.
+func (l *proofLayer) account(snap snapshot.Snapshot, accounts Trie, addr common.Address, addrHash common.Hash) (*types.StateAccount, error) {
+	if snap != nil {
+		if acc, err := snap.Account(addrHash); err == nil {
+			return fullAccount(acc), nil
+		}
+	}
+	enc, err := accounts.TryGet(addr[:])
+	if err != nil || len(enc) == 0 {
+		return nil, err
+	}
+	account := new(types.StateAccount)
+	if err := rlp.DecodeBytes(enc, account); err != nil {
+		return nil, err
+	}
+	return account, nil
+}
+
+// slot retrieves a storage slot from the snapshot if possible, or from the trie
+// otherwise.
+func (l *proofLayer) slot(snap snapshot.Snapshot, storage Trie, addrHash, key, slotHash common.Hash) (common.Hash, error) {
+	var (
+		enc []byte
+		err error
+	)
+	if snap != nil {
+		enc, err = snap.Storage(addrHash, slotHash)
+	}
+	if snap == nil || err != nil {
+		if enc, err = storage.TryGet(key[:]); err != nil {
+			return common.Hash{}, err
+		}
+	}
+	if len(enc) == 0 {
+		return common.Hash{}, nil
+	}
+	_, content, _, err := rlp.Split(enc)
+	if err != nil {
+		return common.Hash{}, err
+	}
+	return common.BytesToHash(content), nil
+}
+
+// proof retrieves the proof of a key from the cache, or produces it from the
+// trie, caching it for later requests.
+func (l *proofLayer) proof(tr Trie, key []byte, id string) ([][]byte, error) {
+	if proof, ok := l.proofs[id]; ok {
+		return proof, nil
+	}
+	var proof proofList
+	if err := tr.Prove(key, 0, &proof); err != nil {
+		return nil, err
+	}
+	if len(l.proofs) < maxProofLayerItems {
+		l.proofs[id] = proof
+	}
+	return proof, nil
+}
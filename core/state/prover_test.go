@@ -0,0 +1,177 @@
+// Copyright 2021 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package state
+
+import (
+	"math/big"
+	"reflect"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/core/state/snapshot"
+)
+
+// Tests that the proofs produced by the prover match the ones of the state,
+// both with and without a snapshot backing the proven values.
+func TestProver(t *testing.T) {
+	var (
+		diskdb = rawdb.NewMemoryDatabase()
+		db     = NewDatabase(diskdb)
+
+		addrs = []common.Address{common.HexToAddress("0x01"), common.HexToAddress("0x02"), common.HexToAddress("0x03")}
+		keys  = []common.Hash{common.HexToHash("0x01"), common.HexToHash("0x02"), common.HexToHash("0xff")}
+	)
+	state, _ := New(common.Hash{}, db, nil)
+	for i, addr := range addrs {
+		state.SetBalance(addr, big.NewInt(int64(i+1)))
+		state.SetNonce(addr, uint64(i))
+		if i > 0 {
+			state.SetCode(addr, []byte{byte(i)})
+			state.SetState(addr, keys[0], common.BigToHash(big.NewInt(int64(i))))
+			state.SetState(addr, keys[1], common.BigToHash(big.NewInt(int64(i*100))))
+		}
+	}
+	root, err := state.Commit(false)
+	if err != nil {
+		t.Fatalf("failed to commit state: %v", err)
+	}
+	if err := db.TrieDB().Commit(root, false, nil); err != nil {
+		t.Fatalf("failed to persist state: %v", err)
+	}
+	snaps, err := snapshot.New(diskdb, db.TrieDB(), 16, root, false, true, false)
+	if err != nil {
+		t.Fatalf("failed to create snapshot: %v", err)
+	}
+	state, _ = New(root, db, nil)
+
+	// Include an account missing from the state too
+	requests := []ProofRequest{{Address: common.HexToAddress("0xdead"), StorageKeys: keys}}
+	for _, addr := range addrs {
+		requests = append(requests, ProofRequest{Address: addr, StorageKeys: keys})
+	}
+	for _, prover := range []*Prover{NewProver(db, nil, 4), NewProver(db, snaps, 4)} {
+		// Prove twice to cover the cached proofs as well
+		for i := 0; i < 2; i++ {
+			proofs, err := prover.Prove(root, requests)
+			if err != nil {
+				t.Fatalf("failed to prove state: %v", err)
+			}
+			if len(proofs) != len(requests) {
+				t.Fatalf("proof count mismatch: have %d, want %d", len(proofs), len(requests))
+			}
+			for j, proof := range proofs {
+				addr := requests[j].Address
+				want, err := state.GetProof(addr)
+				if err != nil {
+					t.Fatalf("failed to prove account %x: %v", addr, err)
+				}
+				if !reflect.DeepEqual([][]byte(proof.Proof), want) {
+					t.Errorf("account %x: proof mismatch", addr)
+				}
+				if proof.Nonce != state.GetNonce(addr) || proof.Balance.Cmp(state.GetBalance(addr)) != 0 {
+					t.Errorf("account %x: nonce/balance mismatch: have %d/%v", addr, proof.Nonce, proof.Balance)
+				}
+				if codeHash := state.GetCodeHash(addr); codeHash != (common.Hash{}) && proof.CodeHash != codeHash {
+					t.Errorf("account %x: code hash mismatch: have %x, want %x", addr, proof.CodeHash, codeHash)
+				}
+				if obj := state.getStateObject(addr); obj != nil && proof.Root != obj.data.Root {
+					t.Errorf("account %x: storage root mismatch: have %x, want %x", addr, proof.Root, obj.data.Root)
+				}
+				for k, slot := range proof.Storage {
+					if slot.Key != keys[k] || slot.Value != state.GetState(addr, keys[k]) {
+						t.Errorf("account %x slot %x: value mismatch: have %x, want %x", addr, keys[k], slot.Value, state.GetState(addr, keys[k]))
+					}
+					if state.Exist(addr) {
+						want, err := state.GetStorageProof(addr, keys[k])
+						if err != nil {
+							t.Fatalf("failed to prove slot %x of %x: %v", keys[k], addr, err)
+						}
+						if len(want) == 0 {
+							want = [][]byte{}
+						}
+						if !reflect.DeepEqual(slot.Proof, want) {
+							t.Errorf("account %x slot %x: proof mismatch", addr, keys[k])
+						}
+					} else if len(slot.Proof) != 0 {
+						t.Errorf("account %x slot %x: non-empty proof of missing account", addr, keys[k])
+					}
+				}
+			}
+		}
+	}
+	// Unknown states should be rejected
+	if _, err := NewProver(db, snaps, 4).Prove(common.HexToHash("0xbad"), requests); err == nil {
+		t.Fatalf("proved unknown state")
+	}
+}
+
+// Tests that the prover keeps the states inside the snapshot's diff window
+// provable after the chain dereferenced them, releasing them once their diff
+// layers are flattened.
+func TestProverRetention(t *testing.T) {
+	var (
+		diskdb = rawdb.NewMemoryDatabase()
+		db     = NewDatabase(diskdb)
+		addr   = common.HexToAddress("0x01")
+		key    = common.HexToHash("0x01")
+	)
+	base, _ := New(common.Hash{}, db, nil)
+	base.SetBalance(addr, big.NewInt(1))
+	baseRoot, _ := base.Commit(false)
+	db.TrieDB().Commit(baseRoot, false, nil)
+
+	snaps, err := snapshot.New(diskdb, db.TrieDB(), 16, baseRoot, false, true, false)
+	if err != nil {
+		t.Fatalf("failed to create snapshot: %v", err)
+	}
+	prover := NewProver(db, snaps, 4)
+
+	// commit creates a child state with a snapshot diff layer, retains it in the
+	// prover and dereferences it the way the chain's garbage collection would
+	commit := func(parent common.Hash, balance int64) common.Hash {
+		state, _ := New(parent, db, snaps)
+		state.SetBalance(addr, big.NewInt(balance))
+		state.SetState(addr, key, common.BigToHash(big.NewInt(balance)))
+		root, err := state.Commit(false)
+		if err != nil {
+			t.Fatalf("failed to commit state: %v", err)
+		}
+		db.TrieDB().Reference(root, common.Hash{})
+		prover.Retain(root)
+		db.TrieDB().Dereference(root)
+		return root
+	}
+	requests := []ProofRequest{{Address: addr, StorageKeys: []common.Hash{key}}}
+
+	root := commit(baseRoot, 2)
+	if _, err := prover.Prove(root, requests); err != nil {
+		t.Fatalf("failed to prove retained state: %v", err)
+	}
+	// Flatten the state out of the diff window, it should be released
+	child := commit(root, 3)
+	if err := snaps.Cap(child, 0); err != nil {
+		t.Fatalf("failed to flatten snapshot: %v", err)
+	}
+	prover.Retain(child)
+	if _, err := prover.Prove(root, requests); err == nil {
+		t.Fatalf("proved released state")
+	}
+	if _, err := prover.Prove(child, requests); err != nil {
+		t.Fatalf("failed to prove retained state: %v", err)
+	}
+}
@@ -0,0 +1,725 @@
+// Copyright 2021 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package pruner
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/core/state/snapshot"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/rlp"
+	"github.com/ethereum/go-ethereum/trie"
+)
+
+const (
+	// onlinePruningIdle marks that no pruning cycle is in progress, the target
+	// of the progress marker being the last completed one.
+	onlinePruningIdle = iota
+
+	// onlinePruningMarking marks that the live state is being collected into
+	// the bloom filter. Nothing has been deleted yet, so an interrupted cycle
+	// in this stage is simply restarted.
+	onlinePruningMarking
+
+	// onlinePruningSweeping marks that stale nodes are being deleted. The bloom
+	// filter is persisted and the cycle must be resumed after a restart.
+	onlinePruningSweeping
+)
+
+var (
+	// errPruningAborted is returned if a pruning cycle was interrupted by a
+	// shutdown request.
+	errPruningAborted = errors.New("pruning aborted")
+
+	// errPruningActive is returned if a new pruning cycle is requested while a
+	// previous one is still running.
+	errPruningActive = errors.New("pruning already in progress")
+)
+
+// onlinePruningProgress is the persisted progress marker of the online pruner,
+// allowing it to resume an interrupted sweep after a restart.
+type onlinePruningProgress struct {
+	Root   common.Hash // State root of the pruning target
+	Number uint64      // Block number of the pruning target
+	Stage  uint8       // Stage the pruning cycle is in
+	Cursor []byte      // Database key the sweeper continues from
+}
+
+// OnlinePrunerConfig contains the tunables of the online state pruner.
+type OnlinePrunerConfig struct {
+	Interval  uint64        // Minimum number of blocks between the targets of two pruning cycles
+	BloomSize uint64        // Memory allowance (MB) of the state bloom filter
+	BloomPath string        // File to persist the state bloom into across restarts
+	BatchSize int           // Maximum number of trie nodes to delete in a single batch
+	Throttle  time.Duration // Pause between two consecutive deletion batches
+}
+
+// OnlinePruner is a background variant of Pruner, which deletes stale trie nodes
+// while the node keeps importing blocks. A pruning cycle goes through the same
+// two phases as the offline tool, but all state written during the cycle is
+// protected from deletion:
+//
+//   - the target state (one just flushed to disk by the chain) is collected
+//     into a bloom filter from the snapshot, falling back to a trie traversal
+//   - the state of the recent in-memory tries and of every block imported during
+//     the cycle is collected by walking the trie differences to an already
+//     collected parent state, so that only the changed nodes are visited
+//   - the database is iterated in throttled batches and all trie nodes missing
+//     from the bloom filter are deleted
+//
+// Contract code is never deleted by the online pruner, it's left for the
+// offline tool to clean up.
+type OnlinePruner struct {
+	config   OnlinePrunerConfig
+	db       ethdb.Database
+	triedb   *trie.Database
+	snaptree *snapshot.Tree
+
+	bloom    *stateBloom              // Bloom filter of the live trie nodes, nil if idle
+	progress onlinePruningProgress    // Progress of the current (or last) pruning cycle
+	marked   map[common.Hash]struct{} // State roots entirely collected into the bloom
+	resume   bool                     // Flag whether a persisted sweep is pending resumption
+	quit     chan struct{}            // Quit channel to interrupt the running cycle
+	done     chan struct{}            // Notification channel of the cycle's termination
+	lock     sync.Mutex               // Lock protecting the pruner fields
+}
+
+// NewOnlinePruner creates an online state pruner, loading the progress of any
+// previously interrupted pruning cycle.
+func NewOnlinePruner(db ethdb.Database, triedb *trie.Database, snaptree *snapshot.Tree, config OnlinePrunerConfig) *OnlinePruner {
+	if config.BloomSize < 256 {
+		log.Warn("Sanitizing online pruning bloomfilter size", "provided(MB)", config.BloomSize, "updated(MB)", 256)
+		config.BloomSize = 256
+	}
+	if config.BatchSize <= 0 {
+		config.BatchSize = 10000
+	}
+	p := &OnlinePruner{
+		config:   config,
+		db:       db,
+		triedb:   triedb,
+		snaptree: snaptree,
+	}
+	blob := rawdb.ReadOnlinePruningProgress(db)
+	if len(blob) == 0 {
+		return p
+	}
+	if err := rlp.DecodeBytes(blob, &p.progress); err != nil {
+		log.Error("Failed to decode online pruning progress", "err", err)
+		rawdb.DeleteOnlinePruningProgress(db)
+		return p
+	}
+	switch p.progress.Stage {
+	case onlinePruningMarking:
+		// Nothing was deleted yet, drop the cycle and start over later
+		log.Info("Discarding interrupted state pruning", "number", p.progress.Number, "root", p.progress.Root)
+		p.reset()
+
+	case onlinePruningSweeping:
+		bloom, err := NewStateBloomFromDisk(config.BloomPath)
+		if err != nil {
+			// The sweep cannot be safely resumed without the bloom, leave the
+			// partially pruned database as is, the live state is complete.
+			log.Error("Failed to load state pruning bloom, abandoning cycle", "path", config.BloomPath, "err", err)
+			p.reset()
+			return p
+		}
+		p.bloom, p.resume = bloom, true
+		p.marked = map[common.Hash]struct{}{p.progress.Root: {}}
+		log.Info("Loaded interrupted state pruning", "number", p.progress.Number, "root", p.progress.Root, "cursor", fmt.Sprintf("%x", p.progress.Cursor))
+	}
+	return p
+}
+
+// Active returns whether a pruning cycle is currently in progress.
+func (p *OnlinePruner) Active() bool {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	return p.bloom != nil
+}
+
+// Due returns whether a new pruning cycle should be started at the given block
+// number, based on the target of the last one.
+func (p *OnlinePruner) Due(number uint64) bool {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	return p.bloom == nil && number >= p.progress.Number+p.config.Interval
+}
+
+// Resume continues an interrupted sweep after a restart. The state of the given
+// chain head is collected into the bloom filter first, since blocks imported
+// after the last persisted bloom are not covered.
+func (p *OnlinePruner) Resume(head common.Hash) error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	if !p.resume {
+		return nil
+	}
+	p.resume = false
+
+	if err := p.markDiff(p.bloom, p.progress.Root, head); err != nil {
+		p.reset()
+		return err
+	}
+	p.marked[head] = struct{}{}
+
+	log.Info("Resuming state pruning", "number", p.progress.Number, "root", p.progress.Root)
+	p.quit, p.done = make(chan struct{}), make(chan struct{})
+	go p.run(p.bloom, p.quit, p.done, true)
+	return nil
+}
+
+// Start begins a new pruning cycle targeting the given state root, which must
+// be entirely persisted to disk. The window contains the roots of the states
+// still kept in memory, each one being collected relative to the previous one.
+//
+// Start must be called from the block import goroutine, before any of the
+// window's nodes can be flushed further.
+func (p *OnlinePruner) Start(root common.Hash, number uint64, window []common.Hash) error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	if p.bloom != nil || p.running() {
+		return errPruningActive
+	}
+	bloom, err := newStateBloomWithSize(p.config.BloomSize)
+	if err != nil {
+		return err
+	}
+	p.bloom = bloom
+	p.progress = onlinePruningProgress{Root: root, Number: number, Stage: onlinePruningMarking}
+	p.marked = map[common.Hash]struct{}{root: {}}
+	p.writeProgress(p.db)
+
+	// Collect all the recent states relative to the target. The target itself
+	// is collected in the background, but since nothing is deleted until that
+	// finishes, the differences are enough to cover the window.
+	var (
+		start = time.Now()
+		base  = root
+	)
+	for _, state := range window {
+		if _, ok := p.marked[state]; ok {
+			continue
+		}
+		if state != emptyRoot {
+			if _, err := p.triedb.Node(state); err != nil {
+				log.Debug("Skipping unavailable state from pruning", "root", state)
+				continue
+			}
+		}
+		if err := p.markDiff(p.bloom, base, state); err != nil {
+			p.reset()
+			return err
+		}
+		p.marked[state] = struct{}{}
+		base = state
+	}
+	log.Info("Started state pruning", "number", number, "root", root, "recent", len(p.marked)-1, "elapsed", common.PrettyDuration(time.Since(start)))
+
+	p.quit, p.done = make(chan struct{}), make(chan struct{})
+	go p.run(p.bloom, p.quit, p.done, false)
+	return nil
+}
+
+// Mark collects the nodes of a newly created state into the bloom filter, so
+// they are not deleted by the running sweep. It must be called before any of
+// the state's nodes are flushed to disk.
+func (p *OnlinePruner) Mark(parent common.Hash, root common.Hash) error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	if p.bloom == nil {
+		return nil
+	}
+	if _, ok := p.marked[root]; ok {
+		return nil
+	}
+	// Diff against the parent if it's already covered, otherwise against the
+	// target. Both are correct, the former is just a lot cheaper.
+	base := parent
+	if _, ok := p.marked[parent]; !ok {
+		base = p.progress.Root
+	}
+	if err := p.markDiff(p.bloom, base, root); err != nil {
+		// The new state cannot be protected, abort the cycle to avoid deleting
+		// any of its nodes. The database keeps some garbage, but is consistent.
+		log.Error("Failed to protect state from pruning, aborting", "root", root, "err", err)
+		p.abort()
+		return err
+	}
+	p.marked[root] = struct{}{}
+	return nil
+}
+
+// Stop interrupts the running pruning cycle, persisting its progress so that it
+// can be resumed after a restart.
+func (p *OnlinePruner) Stop() {
+	p.lock.Lock()
+	quit, done := p.quit, p.done
+	if quit != nil {
+		p.interrupt()
+	}
+	p.lock.Unlock()
+
+	if done == nil {
+		return
+	}
+	<-done
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	p.quit, p.done = nil, nil
+	if p.bloom == nil {
+		return // cycle finished or aborted meanwhile
+	}
+	switch p.progress.Stage {
+	case onlinePruningMarking:
+		log.Info("Discarding unfinished state pruning", "number", p.progress.Number)
+		p.reset()
+
+	case onlinePruningSweeping:
+		// Persist the bloom including all the states collected since the sweep
+		// started, then the cursor to continue from.
+		if err := p.bloom.Commit(p.config.BloomPath, p.config.BloomPath+stateBloomFileTempSuffix); err != nil {
+			log.Error("Failed to persist state pruning bloom, abandoning cycle", "err", err)
+			p.reset()
+			return
+		}
+		p.writeProgress(p.db)
+		log.Info("Suspended state pruning", "number", p.progress.Number, "cursor", fmt.Sprintf("%x", p.progress.Cursor))
+	}
+}
+
+// run executes the background part of a pruning cycle: collecting the target
+// state (unless resuming) and sweeping the database. The bloom of the cycle is
+// passed explicitly, as an abort may drop it from the pruner at any time.
+func (p *OnlinePruner) run(bloom *stateBloom, quit chan struct{}, done chan struct{}, resume bool) {
+	defer close(done)
+
+	start := time.Now()
+	if !resume {
+		if err := p.markTarget(bloom, quit); err != nil {
+			if err != errPruningAborted {
+				log.Error("Failed to collect state for pruning", "err", err)
+				p.lock.Lock()
+				if p.bloom == bloom {
+					p.abort()
+				}
+				p.lock.Unlock()
+			}
+			return
+		}
+		// The bloom needs to hit the disk before anything is deleted, otherwise
+		// an unclean shutdown would leave the sweep impossible to resume.
+		p.lock.Lock()
+		if p.bloom != bloom || p.interrupted(quit) {
+			p.lock.Unlock()
+			return
+		}
+		if err := bloom.Commit(p.config.BloomPath, p.config.BloomPath+stateBloomFileTempSuffix); err != nil {
+			log.Error("Failed to persist state pruning bloom", "err", err)
+			p.abort()
+			p.lock.Unlock()
+			return
+		}
+		p.progress.Stage = onlinePruningSweeping
+		p.writeProgress(p.db)
+		p.lock.Unlock()
+
+		log.Info("Collected live state for pruning", "elapsed", common.PrettyDuration(time.Since(start)))
+	}
+	// The snapshot generator iterates the persisted tries of its disk layer, which
+	// may well be stale ones, so keep it off until the sweep is done with them.
+	if p.snaptree != nil {
+		p.snaptree.PauseGeneration()
+		defer p.snaptree.ResumeGeneration()
+	}
+	count, err := p.sweep(bloom, quit)
+	if err != nil {
+		if err != errPruningAborted {
+			log.Error("Failed to prune state", "err", err)
+		}
+		return
+	}
+	p.lock.Lock()
+	if p.bloom != bloom || p.interrupted(quit) {
+		p.lock.Unlock()
+		return
+	}
+	number := p.progress.Number
+	p.finish()
+	p.lock.Unlock()
+
+	// Compact the database after substantial deletions to reclaim the space
+	if count >= rangeCompactionThreshold {
+		if err := compactDatabase(p.db); err != nil {
+			log.Error("Database compaction failed", "err", err)
+		}
+	}
+	log.Info("State pruning successful", "number", number, "nodes", count, "elapsed", common.PrettyDuration(time.Since(start)))
+}
+
+// markTarget collects the entire target state, together with the genesis state,
+// into the bloom filter. The snapshot is preferred since it's an order of
+// magnitude faster, but as its diff layers are flattened during import, it may
+// go stale midway, in which case the persisted trie is iterated instead.
+func (p *OnlinePruner) markTarget(bloom *stateBloom, quit chan struct{}) error {
+	root := p.progress.Root
+	if p.snaptree != nil {
+		err := p.markSnapshot(bloom, root, quit)
+		if err == nil {
+			return p.markGenesis(bloom)
+		}
+		if err == errPruningAborted {
+			return err
+		}
+		log.Warn("Failed to collect state from snapshot, iterating trie", "root", root, "err", err)
+	}
+	if err := p.markTrie(bloom, emptyRoot, root, quit); err != nil {
+		return err
+	}
+	return p.markGenesis(bloom)
+}
+
+// markSnapshot regenerates the trie of the given state from the snapshot and
+// collects all of its nodes into the bloom filter.
+func (p *OnlinePruner) markSnapshot(bloom *stateBloom, root common.Hash, quit chan struct{}) error {
+	accIt, err := p.snaptree.AccountIterator(root, common.Hash{})
+	if err != nil {
+		return err
+	}
+	defer accIt.Release()
+
+	var (
+		accounts int
+		logged   = time.Now()
+		accTrie  = trie.NewStackTrie(bloom)
+	)
+	for accIt.Next() {
+		if p.interrupted(quit) {
+			return errPruningAborted
+		}
+		account, err := snapshot.FullAccount(accIt.Account())
+		if err != nil {
+			return err
+		}
+		if storageRoot := common.BytesToHash(account.Root); storageRoot != emptyRoot {
+			stIt, err := p.snaptree.StorageIterator(root, accIt.Hash(), common.Hash{})
+			if err != nil {
+				return err
+			}
+			stTrie := trie.NewStackTrie(bloom)
+			for stIt.Next() {
+				stTrie.Update(stIt.Hash().Bytes(), common.CopyBytes(stIt.Slot()))
+			}
+			err = stIt.Error()
+			stIt.Release()
+			if err != nil {
+				return err
+			}
+			if got, err := stTrie.Commit(); err != nil {
+				return err
+			} else if got != storageRoot {
+				return fmt.Errorf("storage root mismatch for %x: have %x, want %x", accIt.Hash(), got, storageRoot)
+			}
+		}
+		blob, err := rlp.EncodeToBytes(account)
+		if err != nil {
+			return err
+		}
+		accTrie.Update(accIt.Hash().Bytes(), blob)
+
+		accounts++
+		if time.Since(logged) > 8*time.Second {
+			log.Info("Collecting state for pruning", "accounts", accounts, "at", accIt.Hash())
+			logged = time.Now()
+		}
+	}
+	if err := accIt.Error(); err != nil {
+		return err
+	}
+	got, err := accTrie.Commit()
+	if err != nil {
+		return err
+	}
+	if got != root {
+		return fmt.Errorf("state root mismatch: have %x, want %x", got, root)
+	}
+	return nil
+}
+
+// markGenesis collects the genesis state into the bloom filter.
+func (p *OnlinePruner) markGenesis(bloom *stateBloom) error {
+	genesis := rawdb.ReadBlock(p.db, rawdb.ReadCanonicalHash(p.db, 0), 0)
+	if genesis == nil {
+		return errors.New("missing genesis block")
+	}
+	return p.markTrie(bloom, emptyRoot, genesis.Root(), nil)
+}
+
+// markDiff collects all the nodes of the given state which are not present in
+// the base state into the bloom filter. The base must already be collected.
+func (p *OnlinePruner) markDiff(bloom *stateBloom, base common.Hash, root common.Hash) error {
+	if base == root {
+		return nil
+	}
+	return p.markTrie(bloom, base, root, nil)
+}
+
+// markTrie walks the difference between two account tries, and for every
+// changed account the difference between the two storage tries, collecting all
+// the visited nodes into the bloom filter. With an empty base, the entire state
+// is collected.
+func (p *OnlinePruner) markTrie(bloom *stateBloom, base common.Hash, root common.Hash, quit chan struct{}) error {
+	baseTrie, err := trie.New(base, p.triedb)
+	if err != nil {
+		return err
+	}
+	return p.walkDiff(bloom, baseTrie, root, quit, func(key []byte, blob []byte) error {
+		var account types.StateAccount
+		if err := rlp.DecodeBytes(blob, &account); err != nil {
+			return err
+		}
+		if account.Root == emptyRoot {
+			return nil
+		}
+		prev := emptyRoot
+		if base != emptyRoot {
+			enc, err := baseTrie.TryGet(key)
+			if err != nil {
+				return err
+			}
+			if len(enc) > 0 {
+				var old types.StateAccount
+				if err := rlp.DecodeBytes(enc, &old); err != nil {
+					return err
+				}
+				prev = old.Root
+			}
+		}
+		if prev == account.Root {
+			return nil
+		}
+		prevTrie, err := trie.New(prev, p.triedb)
+		if err != nil {
+			return err
+		}
+		return p.walkDiff(bloom, prevTrie, account.Root, quit, nil)
+	})
+}
+
+// walkDiff iterates all the nodes of the trie rooted at root which are missing
+// from the base trie, adding them to the bloom filter and invoking the optional
+// leaf callback.
+func (p *OnlinePruner) walkDiff(bloom *stateBloom, baseTrie *trie.Trie, root common.Hash, quit chan struct{}, onLeaf func(key []byte, blob []byte) error) error {
+	t, err := trie.New(root, p.triedb)
+	if err != nil {
+		return err
+	}
+	it, _ := trie.NewDifferenceIterator(baseTrie.NodeIterator(nil), t.NodeIterator(nil))
+	for it.Next(true) {
+		if p.interrupted(quit) {
+			return errPruningAborted
+		}
+		// Embedded nodes don't have hash.
+		if hash := it.Hash(); hash != (common.Hash{}) {
+			bloom.Put(hash.Bytes(), nil)
+		}
+		if it.Leaf() && onLeaf != nil {
+			if err := onLeaf(it.LeafKey(), it.LeafBlob()); err != nil {
+				return err
+			}
+		}
+	}
+	return it.Error()
+}
+
+// sweep iterates the database from the persisted cursor and deletes all trie
+// nodes not present in the bloom filter, in throttled batches.
+//
+// Nodes recreated by block import after being checked are collected into the
+// bloom before they get written, so all candidates of a batch are re-checked
+// while holding the lock, right before the deletion hits the disk.
+func (p *OnlinePruner) sweep(bloom *stateBloom, quit chan struct{}) (int, error) {
+	var (
+		count  int
+		size   common.StorageSize
+		start  = time.Now()
+		logged = time.Now()
+		keys   [][]byte
+		batch  = p.db.NewBatch()
+		iter   = p.db.NewIterator(nil, p.progress.Cursor)
+	)
+	defer func() { iter.Release() }()
+
+	flush := func(cursor []byte) error {
+		p.lock.Lock()
+		defer p.lock.Unlock()
+
+		if p.bloom != bloom || p.interrupted(quit) {
+			return errPruningAborted
+		}
+		for _, key := range keys {
+			if ok, err := bloom.Contain(key); err != nil {
+				return err
+			} else if ok {
+				continue
+			}
+			batch.Delete(key)
+			count++
+		}
+		p.progress.Cursor = cursor
+		p.writeProgress(batch)
+
+		if err := batch.Write(); err != nil {
+			return err
+		}
+		batch.Reset()
+		keys = keys[:0]
+		return nil
+	}
+	for iter.Next() {
+		key := iter.Key()
+
+		// Only hash-keyed trie nodes are considered, contract code is left
+		// for the offline pruner.
+		if len(key) != common.HashLength {
+			continue
+		}
+		if ok, err := bloom.Contain(key); err != nil {
+			return count, err
+		} else if ok {
+			continue
+		}
+		keys = append(keys, common.CopyBytes(key))
+		size += common.StorageSize(len(key) + len(iter.Value()))
+
+		if time.Since(logged) > 8*time.Second {
+			log.Info("Pruning state data", "nodes", count, "size", size, "elapsed", common.PrettyDuration(time.Since(start)))
+			logged = time.Now()
+		}
+		if len(keys) >= p.config.BatchSize {
+			// Continue after the last deleted key on the next run
+			cursor := common.CopyBytes(key)
+			if err := flush(cursor); err != nil {
+				return count, err
+			}
+			// Recreate the iterator after every batch commit in order to
+			// allow the underlying compactor to delete the entries, and give
+			// block import some breathing room.
+			iter.Release()
+			iter = p.db.NewIterator(nil, cursor)
+
+			if p.config.Throttle > 0 {
+				select {
+				case <-time.After(p.config.Throttle):
+				case <-quit:
+					return count, errPruningAborted
+				}
+			}
+		}
+	}
+	if err := iter.Error(); err != nil {
+		return count, err
+	}
+	if err := flush(nil); err != nil {
+		return count, err
+	}
+	log.Info("Pruned state data", "nodes", count, "size", size, "elapsed", common.PrettyDuration(time.Since(start)))
+	return count, nil
+}
+
+// interrupted returns whether the given quit channel was closed. A nil channel
+// is never closed.
+func (p *OnlinePruner) interrupted(quit chan struct{}) bool {
+	select {
+	case <-quit:
+		return true
+	default:
+		return false
+	}
+}
+
+// running returns whether the goroutine of a previous cycle is still alive. The
+// caller must hold the pruner lock.
+func (p *OnlinePruner) running() bool {
+	if p.done == nil {
+		return false
+	}
+	select {
+	case <-p.done:
+		p.quit, p.done = nil, nil
+		return false
+	default:
+		return true
+	}
+}
+
+// interrupt signals the running cycle to stop. The caller must hold the pruner
+// lock.
+func (p *OnlinePruner) interrupt() {
+	if p.quit != nil && !p.interrupted(p.quit) {
+		close(p.quit)
+	}
+}
+
+// writeProgress persists the current progress marker into the given writer.
+// The caller must hold the pruner lock.
+func (p *OnlinePruner) writeProgress(db ethdb.KeyValueWriter) {
+	blob, err := rlp.EncodeToBytes(p.progress)
+	if err != nil {
+		log.Crit("Failed to encode online pruning progress", "err", err)
+	}
+	rawdb.WriteOnlinePruningProgress(db, blob)
+}
+
+// finish marks the current cycle as successfully completed. The caller must
+// hold the pruner lock.
+func (p *OnlinePruner) finish() {
+	p.reset()
+	p.quit, p.done = nil, nil
+}
+
+// abort drops the current cycle without completing it. The caller must hold the
+// pruner lock.
+func (p *OnlinePruner) abort() {
+	p.interrupt()
+	p.reset()
+}
+
+// reset drops all state of the current cycle, but retains the target of the
+// last one for scheduling. The caller must hold the pruner lock.
+func (p *OnlinePruner) reset() {
+	p.progress.Stage, p.progress.Cursor = onlinePruningIdle, nil
+	p.writeProgress(p.db)
+
+	os.RemoveAll(p.config.BloomPath)
+	p.bloom, p.marked, p.resume = nil, nil, false
+}
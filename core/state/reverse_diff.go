@@ -0,0 +1,234 @@
+// Copyright 2021 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package state
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/rlp"
+	"github.com/ethereum/go-ethereum/trie"
+)
+
+// ReverseDiff contains the original values of all the accounts and storage slots
+// modified by a state transition, allowing to roll the state back from the root
+// to the parent one without having the parent state available.
+type ReverseDiff struct {
+	Parent   common.Hash          // Root of the state before the transition
+	Root     common.Hash          // Root of the state after the transition
+	Accounts []ReverseDiffAccount // Original accounts, sorted by hash
+}
+
+// ReverseDiffAccount is the original content of a modified account.
+type ReverseDiffAccount struct {
+	Hash    common.Hash       // Hash of the account address
+	Account []byte            // Consensus encoded account, empty if it didn't exist
+	Storage []ReverseDiffSlot // Original storage slots, sorted by hash
+}
+
+// ReverseDiffSlot is the original value of a modified storage slot.
+type ReverseDiffSlot struct {
+	Hash  common.Hash // Hash of the storage slot key
+	Value []byte      // Trie encoded value, empty if the slot didn't exist
+}
+
+// TrackReverseDiff enables the tracking of the original accounts and storage
+// slots modified in the state, making a reverse diff available after commit.
+func (s *StateDB) TrackReverseDiff() {
+	if s.diffStorage == nil {
+		s.diffStorage = make(map[common.Hash]map[common.Hash]struct{})
+		s.diffWiped = make(map[common.Hash]struct{})
+	}
+}
+
+// ReverseDiff returns the reverse diff of the last state commit, or nil if the
+// tracking wasn't enabled.
+func (s *StateDB) ReverseDiff() *ReverseDiff {
+	return s.diff
+}
+
+// trackSlotChange marks a storage slot of an account as modified.
+func (s *StateDB) trackSlotChange(addrHash common.Hash, key common.Hash) {
+	if s.diffStorage == nil {
+		return
+	}
+	slots := s.diffStorage[addrHash]
+	if slots == nil {
+		slots = make(map[common.Hash]struct{})
+		s.diffStorage[addrHash] = slots
+	}
+	slots[key] = struct{}{}
+}
+
+// trackStorageWipe marks the storage of an account as discarded, either by a
+// destruction or by a recreation.
+func (s *StateDB) trackStorageWipe(addrHash common.Hash) {
+	if s.diffWiped == nil {
+		return
+	}
+	s.diffWiped[addrHash] = struct{}{}
+}
+
+// reverseDiffAccounts gathers the original content of the given accounts from
+// the pre-state, before any changes are committed.
+func (s *StateDB) reverseDiffAccounts(addrs map[common.Address]struct{}) ([]ReverseDiffAccount, error) {
+	origin, err := s.db.OpenTrie(s.originalRoot)
+	if err != nil {
+		return nil, err
+	}
+	accounts := make([]ReverseDiffAccount, 0, len(addrs))
+	for addr := range addrs {
+		addrHash := crypto.Keccak256Hash(addr[:])
+		enc, err := origin.TryGet(addr[:])
+		if err != nil {
+			return nil, err
+		}
+		account := ReverseDiffAccount{Hash: addrHash, Account: enc}
+
+		// Retrieve the original values of the modified storage slots. If the
+		// storage was wiped, all the original slots are needed to restore it.
+		slots := make(map[common.Hash][]byte)
+		if len(enc) > 0 {
+			var data types.StateAccount
+			if err := rlp.DecodeBytes(enc, &data); err != nil {
+				return nil, err
+			}
+			if data.Root != emptyRoot {
+				storage, err := s.db.OpenStorageTrie(addrHash, data.Root)
+				if err != nil {
+					return nil, err
+				}
+				if _, wiped := s.diffWiped[addrHash]; wiped {
+					it := trie.NewIterator(storage.NodeIterator(nil))
+					for it.Next() {
+						slots[common.BytesToHash(it.Key)] = common.CopyBytes(it.Value)
+					}
+					if it.Err != nil {
+						return nil, it.Err
+					}
+				}
+				for key := range s.diffStorage[addrHash] {
+					hash := crypto.Keccak256Hash(key[:])
+					if _, ok := slots[hash]; ok {
+						continue
+					}
+					if slots[hash], err = storage.TryGet(key[:]); err != nil {
+						return nil, err
+					}
+				}
+			}
+		}
+		// Slots not present originally are deleted on rollback
+		for key := range s.diffStorage[addrHash] {
+			hash := crypto.Keccak256Hash(key[:])
+			if _, ok := slots[hash]; !ok {
+				slots[hash] = nil
+			}
+		}
+		for hash, value := range slots {
+			account.Storage = append(account.Storage, ReverseDiffSlot{Hash: hash, Value: value})
+		}
+		sort.Slice(account.Storage, func(i, j int) bool {
+			return bytes.Compare(account.Storage[i].Hash[:], account.Storage[j].Hash[:]) < 0
+		})
+		accounts = append(accounts, account)
+	}
+	sort.Slice(accounts, func(i, j int) bool {
+		return bytes.Compare(accounts[i].Hash[:], accounts[j].Hash[:]) < 0
+	})
+	return accounts, nil
+}
+
+// ApplyReverseDiff rolls the state back from the root of the diff to its parent,
+// attaching the recreated trie nodes to the database.
+func ApplyReverseDiff(db Database, diff *ReverseDiff) error {
+	triedb := db.TrieDB()
+	accounts, err := trie.New(diff.Root, triedb)
+	if err != nil {
+		return err
+	}
+	for _, account := range diff.Accounts {
+		// Accounts missing originally are simply deleted along with their storage
+		if len(account.Account) == 0 {
+			if err := accounts.TryDelete(account.Hash[:]); err != nil {
+				return err
+			}
+			continue
+		}
+		var original types.StateAccount
+		if err := rlp.DecodeBytes(account.Account, &original); err != nil {
+			return err
+		}
+		// Restore the storage slots on top of the current storage of the account
+		root := emptyRoot
+		if enc, err := accounts.TryGet(account.Hash[:]); err != nil {
+			return err
+		} else if len(enc) > 0 {
+			var current types.StateAccount
+			if err := rlp.DecodeBytes(enc, &current); err != nil {
+				return err
+			}
+			root = current.Root
+		}
+		if len(account.Storage) > 0 {
+			storage, err := trie.NewWithOwner(account.Hash, root, triedb)
+			if err != nil {
+				return err
+			}
+			for _, slot := range account.Storage {
+				if len(slot.Value) == 0 {
+					err = storage.TryDelete(slot.Hash[:])
+				} else {
+					err = storage.TryUpdate(slot.Hash[:], slot.Value)
+				}
+				if err != nil {
+					return err
+				}
+			}
+			if root, _, err = storage.Commit(nil); err != nil {
+				return err
+			}
+		}
+		if root != original.Root {
+			return fmt.Errorf("storage root mismatch for account %x: have %x, want %x", account.Hash, root, original.Root)
+		}
+		if err := accounts.TryUpdate(account.Hash[:], account.Account); err != nil {
+			return err
+		}
+	}
+	var account types.StateAccount
+	root, _, err := accounts.Commit(func(_ [][]byte, _ []byte, leaf []byte, parent common.Hash) error {
+		if err := rlp.DecodeBytes(leaf, &account); err != nil {
+			return nil
+		}
+		if account.Root != emptyRoot {
+			triedb.Reference(account.Root, parent)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if root != diff.Parent {
+		return fmt.Errorf("state root mismatch: have %x, want %x", root, diff.Parent)
+	}
+	return triedb.Update(root, diff.Root)
+}
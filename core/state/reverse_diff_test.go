@@ -0,0 +1,125 @@
+// Copyright 2021 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package state
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/rlp"
+)
+
+// Tests that a reverse diff tracked during a state transition can restore the
+// parent state on a database which only has the child state available.
+func TestReverseDiff(t *testing.T) {
+	var (
+		diskdb = rawdb.NewMemoryDatabase()
+		db     = NewDatabase(diskdb)
+
+		plain     = common.HexToAddress("0x01") // Balance and storage changes
+		destroyed = common.HexToAddress("0x02") // Self destructed with storage
+		recreated = common.HexToAddress("0x03") // Recreated with new storage
+		created   = common.HexToAddress("0x04") // Created with storage
+	)
+	state, _ := New(common.Hash{}, db, nil)
+	for i, addr := range []common.Address{plain, destroyed, recreated} {
+		state.SetBalance(addr, big.NewInt(int64(i+1)))
+		for j := 0; j < 3; j++ {
+			state.SetState(addr, common.BigToHash(big.NewInt(int64(j))), common.BigToHash(big.NewInt(int64(i*10+j+1))))
+		}
+	}
+	state.SetCode(destroyed, []byte{0x01})
+	parent, err := state.Commit(false)
+	if err != nil {
+		t.Fatalf("failed to commit parent state: %v", err)
+	}
+	// Apply all kinds of modifications in separate transactions with tracking on
+	state, _ = New(parent, db, nil)
+	state.TrackReverseDiff()
+
+	state.SetBalance(plain, big.NewInt(100))
+	state.SetState(plain, common.BigToHash(big.NewInt(0)), common.BigToHash(big.NewInt(100)))
+	state.SetState(plain, common.BigToHash(big.NewInt(1)), common.Hash{})
+	state.SetState(plain, common.BigToHash(big.NewInt(5)), common.BigToHash(big.NewInt(101)))
+	state.Suicide(destroyed)
+	state.Finalise(true)
+
+	state.CreateAccount(recreated)
+	state.SetState(recreated, common.BigToHash(big.NewInt(2)), common.BigToHash(big.NewInt(102)))
+	state.SetState(recreated, common.BigToHash(big.NewInt(7)), common.BigToHash(big.NewInt(103)))
+	state.CreateAccount(created)
+	state.SetBalance(created, big.NewInt(104))
+	state.SetState(created, common.BigToHash(big.NewInt(0)), common.BigToHash(big.NewInt(105)))
+	state.Finalise(true)
+
+	root, err := state.Commit(true)
+	if err != nil {
+		t.Fatalf("failed to commit child state: %v", err)
+	}
+	diff := state.ReverseDiff()
+	if diff == nil {
+		t.Fatalf("reverse diff not tracked")
+	}
+	if diff.Parent != parent || diff.Root != root {
+		t.Fatalf("diff roots mismatch: have %x->%x, want %x->%x", diff.Parent, diff.Root, parent, root)
+	}
+	// Persist the child state only and roll it back on a fresh database
+	if err := db.TrieDB().Commit(root, false, nil); err != nil {
+		t.Fatalf("failed to persist child state: %v", err)
+	}
+	db = NewDatabase(diskdb)
+	if _, err := New(parent, db, nil); err == nil {
+		t.Fatalf("parent state available before rollback")
+	}
+	enc, err := rlp.EncodeToBytes(diff)
+	if err != nil {
+		t.Fatalf("failed to encode diff: %v", err)
+	}
+	diff = new(ReverseDiff)
+	if err := rlp.DecodeBytes(enc, diff); err != nil {
+		t.Fatalf("failed to decode diff: %v", err)
+	}
+	if err := ApplyReverseDiff(db, diff); err != nil {
+		t.Fatalf("failed to apply diff: %v", err)
+	}
+	state, err = New(parent, db, nil)
+	if err != nil {
+		t.Fatalf("parent state missing after rollback: %v", err)
+	}
+	for i, addr := range []common.Address{plain, destroyed, recreated} {
+		if balance := state.GetBalance(addr); balance.Int64() != int64(i+1) {
+			t.Errorf("account %x: balance mismatch: have %v, want %v", addr, balance, i+1)
+		}
+		for j := 0; j < 8; j++ {
+			want := common.Hash{}
+			if j < 3 {
+				want = common.BigToHash(big.NewInt(int64(i*10 + j + 1)))
+			}
+			if have := state.GetState(addr, common.BigToHash(big.NewInt(int64(j)))); have != want {
+				t.Errorf("account %x: slot %d mismatch: have %x, want %x", addr, j, have, want)
+			}
+		}
+	}
+	if state.Exist(created) {
+		t.Errorf("created account exists after rollback")
+	}
+	if code := state.GetCode(destroyed); len(code) != 1 {
+		t.Errorf("destroyed account code mismatch: have %x", code)
+	}
+}
@@ -0,0 +1,85 @@
+// Copyright 2021 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package snapshot
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// errNotAncestor is returned if the changes between two snapshots are requested
+// but the older one is not reachable from the newer one via diff layers.
+var errNotAncestor = errors.New("snapshot is not an ancestor")
+
+// Changes is the set of accounts and storage slots touched by the diff layers
+// between two snapshots. Touched items are not necessarily modified, callers
+// need to compare the values at both ends to filter out no-op updates.
+type Changes struct {
+	Destructs map[common.Hash]struct{}                 // Accounts whose storage was wiped in between
+	Accounts  map[common.Hash]struct{}                 // Accounts touched in between, including the destructed ones
+	Storage   map[common.Hash]map[common.Hash]struct{} // Storage slots touched in between, per account
+}
+
+// Changes collects the accounts and storage slots touched by the diff layers
+// going from the snapshot with root from to the one with root to. The older
+// snapshot needs to be an ancestor of the newer one, with only diff layers in
+// between.
+func (t *Tree) Changes(from, to common.Hash) (*Changes, error) {
+	layer := t.Snapshot(to)
+	if layer == nil {
+		return nil, fmt.Errorf("snapshot [%#x] missing", to)
+	}
+	changes := &Changes{
+		Destructs: make(map[common.Hash]struct{}),
+		Accounts:  make(map[common.Hash]struct{}),
+		Storage:   make(map[common.Hash]map[common.Hash]struct{}),
+	}
+	for layer.Root() != from {
+		diff, ok := layer.(*diffLayer)
+		if !ok {
+			return nil, errNotAncestor
+		}
+		diff.lock.RLock()
+		if diff.Stale() {
+			diff.lock.RUnlock()
+			return nil, ErrSnapshotStale
+		}
+		for hash := range diff.destructSet {
+			changes.Destructs[hash] = struct{}{}
+			changes.Accounts[hash] = struct{}{}
+		}
+		for hash := range diff.accountData {
+			changes.Accounts[hash] = struct{}{}
+		}
+		for hash, slots := range diff.storageData {
+			changes.Accounts[hash] = struct{}{}
+			if changes.Storage[hash] == nil {
+				changes.Storage[hash] = make(map[common.Hash]struct{}, len(slots))
+			}
+			for slot := range slots {
+				changes.Storage[hash][slot] = struct{}{}
+			}
+		}
+		parent := diff.parent
+		diff.lock.RUnlock()
+
+		layer = parent
+	}
+	return changes, nil
+}
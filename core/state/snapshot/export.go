@@ -0,0 +1,569 @@
+// Copyright 2021 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package snapshot
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/rlp"
+	"github.com/ethereum/go-ethereum/trie"
+)
+
+// The exported snapshot is a sequence of chunks, each consisting of a one byte
+// kind, a four byte big endian payload length, the RLP encoded payload and a four
+// byte CRC32 checksum of the kind and the payload.
+//
+// The first chunk is the header and the last one the footer. In between, the
+// accounts are exported in ascending hash order, the storage slots and the code
+// of each account always preceding the chunk containing the account itself.
+const (
+	exportVersion   = 1           // Version of the snapshot export format
+	exportChunkSize = 1024 * 1024 // Payload size above which a chunk is flushed
+
+	exportHeaderChunk  = 0x00 // Chunk with the format version, the block and its state root
+	exportAccountChunk = 0x01 // Chunk with a batch of accounts
+	exportStorageChunk = 0x02 // Chunk with a batch of storage slots of a single account
+	exportCodeChunk    = 0x03 // Chunk with a batch of contract codes
+	exportFooterChunk  = 0x04 // Chunk with the number of exported items
+)
+
+// exportMagic is the prefix of every exported snapshot file.
+var exportMagic = []byte("gethsnap")
+
+var errExportCorrupted = errors.New("corrupted snapshot export")
+
+// exportHeader is the content of the header chunk.
+type exportHeader struct {
+	Version uint64
+	Number  uint64      // Number of the block the state belongs to
+	Hash    common.Hash // Hash of the block the state belongs to
+	Root    common.Hash
+}
+
+// exportFooter is the content of the footer chunk, used to detect truncation.
+type exportFooter struct {
+	Accounts uint64
+	Slots    uint64
+	Codes    uint64
+}
+
+// exportAccount is an account in slim snapshot encoding.
+type exportAccount struct {
+	Hash    common.Hash
+	Account []byte
+}
+
+// exportStorage is a batch of storage slots of a single account.
+type exportStorage struct {
+	Account common.Hash
+	Slots   []exportSlot
+}
+
+// exportSlot is a storage slot in snapshot encoding.
+type exportSlot struct {
+	Hash  common.Hash
+	Value []byte
+}
+
+// exportCode is a contract code along with its hash.
+type exportCode struct {
+	Hash common.Hash
+	Code []byte
+}
+
+// exportWriter writes checksummed chunks into an output stream.
+type exportWriter struct {
+	w   io.Writer
+	buf [5]byte
+}
+
+// writeChunk encodes the payload and writes it out as a chunk of the given kind.
+func (w *exportWriter) writeChunk(kind byte, payload interface{}) error {
+	blob, err := rlp.EncodeToBytes(payload)
+	if err != nil {
+		return err
+	}
+	w.buf[0] = kind
+	binary.BigEndian.PutUint32(w.buf[1:], uint32(len(blob)))
+	if _, err := w.w.Write(w.buf[:]); err != nil {
+		return err
+	}
+	if _, err := w.w.Write(blob); err != nil {
+		return err
+	}
+	binary.BigEndian.PutUint32(w.buf[1:], exportChecksum(kind, blob))
+	_, err = w.w.Write(w.buf[1:])
+	return err
+}
+
+// exportReader reads and validates checksummed chunks from an input stream.
+type exportReader struct {
+	r   io.Reader
+	buf [5]byte
+}
+
+// readChunk reads the next chunk, returning its kind and raw payload.
+func (r *exportReader) readChunk() (byte, []byte, error) {
+	if _, err := io.ReadFull(r.r, r.buf[:]); err != nil {
+		if err == io.EOF {
+			return 0, nil, io.ErrUnexpectedEOF // The footer must be the last chunk
+		}
+		return 0, nil, err
+	}
+	kind, size := r.buf[0], binary.BigEndian.Uint32(r.buf[1:])
+	if size > 4*exportChunkSize+1024*1024 {
+		return 0, nil, fmt.Errorf("%w: oversized chunk (%d bytes)", errExportCorrupted, size)
+	}
+	blob := make([]byte, size)
+	if _, err := io.ReadFull(r.r, blob); err != nil {
+		return 0, nil, err
+	}
+	if _, err := io.ReadFull(r.r, r.buf[1:]); err != nil {
+		return 0, nil, err
+	}
+	if have, want := exportChecksum(kind, blob), binary.BigEndian.Uint32(r.buf[1:]); have != want {
+		return 0, nil, fmt.Errorf("%w: checksum mismatch (have %08x, want %08x)", errExportCorrupted, have, want)
+	}
+	return kind, blob, nil
+}
+
+// exportChecksum calculates the checksum of a chunk.
+func exportChecksum(kind byte, payload []byte) uint32 {
+	return crc32.Update(crc32.ChecksumIEEE([]byte{kind}), crc32.IEEETable, payload)
+}
+
+// Export writes all the accounts, storage slots and contract codes of the state
+// of the given block into a portable, checksummed stream, which can be used to
+// bootstrap the state on another node via Import.
+func (t *Tree) Export(header *types.Header, w io.Writer) error {
+	root := header.Root
+	acctIt, err := t.AccountIterator(root, common.Hash{})
+	if err != nil {
+		return err
+	}
+	defer acctIt.Release()
+
+	out := &exportWriter{w: w}
+	if _, err := w.Write(exportMagic); err != nil {
+		return err
+	}
+	if err := out.writeChunk(exportHeaderChunk, &exportHeader{Version: exportVersion, Number: header.Number.Uint64(), Hash: header.Hash(), Root: root}); err != nil {
+		return err
+	}
+	var (
+		footer exportFooter
+		codes  = make(map[common.Hash]struct{})
+
+		accounts     []exportAccount
+		accountsSize int
+		code         []exportCode
+		codeSize     int
+
+		start  = time.Now()
+		logged = time.Now()
+	)
+	flushCode := func() error {
+		if len(code) == 0 {
+			return nil
+		}
+		if err := out.writeChunk(exportCodeChunk, code); err != nil {
+			return err
+		}
+		code, codeSize = nil, 0
+		return nil
+	}
+	for acctIt.Next() {
+		hash, blob := acctIt.Hash(), acctIt.Account()
+		account, err := FullAccount(blob)
+		if err != nil {
+			return err
+		}
+		// Export the contract code, unless it was already done for another account
+		if codeHash := common.BytesToHash(account.CodeHash); codeHash != emptyCode {
+			if _, ok := codes[codeHash]; !ok {
+				blob := rawdb.ReadCode(t.diskdb, codeHash)
+				if len(blob) == 0 {
+					return fmt.Errorf("missing code %x of account %x", codeHash, hash)
+				}
+				codes[codeHash] = struct{}{}
+				code = append(code, exportCode{Hash: codeHash, Code: blob})
+				codeSize += len(blob)
+				footer.Codes++
+
+				if codeSize > exportChunkSize {
+					if err := flushCode(); err != nil {
+						return err
+					}
+				}
+			}
+		}
+		// Export the storage slots, split into as many chunks as needed
+		if common.BytesToHash(account.Root) != emptyRoot {
+			storageIt, err := t.StorageIterator(root, hash, common.Hash{})
+			if err != nil {
+				return err
+			}
+			storage := exportStorage{Account: hash}
+			size := 0
+			for storageIt.Next() {
+				storage.Slots = append(storage.Slots, exportSlot{Hash: storageIt.Hash(), Value: common.CopyBytes(storageIt.Slot())})
+				size += common.HashLength + len(storageIt.Slot())
+				footer.Slots++
+
+				if size > exportChunkSize {
+					if err := out.writeChunk(exportStorageChunk, &storage); err != nil {
+						storageIt.Release()
+						return err
+					}
+					storage.Slots, size = storage.Slots[:0], 0
+				}
+			}
+			err = storageIt.Error()
+			storageIt.Release()
+			if err != nil {
+				return err
+			}
+			if len(storage.Slots) > 0 {
+				if err := out.writeChunk(exportStorageChunk, &storage); err != nil {
+					return err
+				}
+			}
+		}
+		accounts = append(accounts, exportAccount{Hash: hash, Account: common.CopyBytes(blob)})
+		accountsSize += common.HashLength + len(blob)
+		footer.Accounts++
+
+		// Flush the accounts if enough were gathered, preceded by their codes
+		if accountsSize > exportChunkSize {
+			if err := flushCode(); err != nil {
+				return err
+			}
+			if err := out.writeChunk(exportAccountChunk, accounts); err != nil {
+				return err
+			}
+			accounts, accountsSize = accounts[:0], 0
+		}
+		if time.Since(logged) > 8*time.Second {
+			log.Info("Exporting state snapshot", "at", hash, "accounts", footer.Accounts, "slots", footer.Slots, "codes", footer.Codes, "elapsed", common.PrettyDuration(time.Since(start)))
+			logged = time.Now()
+		}
+	}
+	if err := acctIt.Error(); err != nil {
+		return err
+	}
+	if err := flushCode(); err != nil {
+		return err
+	}
+	if len(accounts) > 0 {
+		if err := out.writeChunk(exportAccountChunk, accounts); err != nil {
+			return err
+		}
+	}
+	if err := out.writeChunk(exportFooterChunk, &footer); err != nil {
+		return err
+	}
+	log.Info("Exported state snapshot", "number", header.Number, "hash", header.Hash(), "root", root, "accounts", footer.Accounts, "slots", footer.Slots, "codes", footer.Codes, "elapsed", common.PrettyDuration(time.Since(start)))
+	return nil
+}
+
+// Import reads a state snapshot exported via Export and writes its accounts,
+// storage slots and contract codes into the database, regenerating all the trie
+// nodes along the way. The tries are verified against the roots stored in the
+// accounts and the state root in the export.
+//
+// The block of the exported state must already be present in the database as
+// part of the canonical chain, above the current head block, whose snapshot (if
+// any) is discarded. Once the state is imported, the block is marked as the head
+// of the chain and the snapshot is persisted as a completely generated disk layer
+// on top of it, so the node can use both right away. The header of the block is
+// returned on success.
+func Import(db ethdb.Database, r io.Reader) (*types.Header, error) {
+	if rawdb.ReadTrieScheme(db) == rawdb.PathScheme {
+		return nil, errors.New("snapshot import not supported by the path scheme")
+	}
+	in := &exportReader{r: bufio.NewReader(r)}
+	magic := make([]byte, len(exportMagic))
+	if _, err := io.ReadFull(in.r, magic); err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(magic, exportMagic) {
+		return nil, errors.New("not a snapshot export")
+	}
+	kind, blob, err := in.readChunk()
+	if err != nil {
+		return nil, err
+	}
+	var header exportHeader
+	if kind != exportHeaderChunk {
+		return nil, fmt.Errorf("%w: missing header", errExportCorrupted)
+	}
+	if err := rlp.DecodeBytes(blob, &header); err != nil {
+		return nil, err
+	}
+	if header.Version != exportVersion {
+		return nil, fmt.Errorf("unsupported export version %d", header.Version)
+	}
+	// Ensure the state belongs to a known canonical block the chain can be
+	// started from and don't move the head of the chain backwards
+	if hash := rawdb.ReadCanonicalHash(db, header.Number); hash != header.Hash {
+		return nil, fmt.Errorf("block #%d [%x] of the export not canonical", header.Number, header.Hash)
+	}
+	block := rawdb.ReadBlock(db, header.Hash, header.Number)
+	if block == nil || rawdb.ReadTd(db, header.Hash, header.Number) == nil {
+		return nil, fmt.Errorf("block #%d [%x] of the export missing", header.Number, header.Hash)
+	}
+	if block.Root() != header.Root {
+		return nil, fmt.Errorf("state root mismatch of block #%d: have %x, want %x", header.Number, header.Root, block.Root())
+	}
+	if head := rawdb.ReadHeadBlock(db); head != nil && head.NumberU64() >= header.Number && head.NumberU64() > 0 {
+		return nil, fmt.Errorf("chain head #%d already beyond the export", head.NumberU64())
+	}
+	// The head markers are moved to the block of the export, so the node must be
+	// able to serve all the chain data leading up to it
+	if err := checkChainData(db, header.Number); err != nil {
+		return nil, err
+	}
+	// Any existing snapshot belongs to an older state, drop it to avoid mixing
+	// stale entries into the imported one
+	rawdb.DeleteSnapshotRoot(db)
+	rawdb.DeleteSnapshotJournal(db)
+	if err := wipeContent(db); err != nil {
+		return nil, err
+	}
+	log.Info("Importing state snapshot", "number", header.Number, "hash", header.Hash, "root", header.Root)
+
+	var (
+		batch   = db.NewBatch()
+		counts  exportFooter
+		codes   = make(map[common.Hash]struct{})
+		roots   = make(map[common.Hash]common.Hash) // Storage roots of the accounts yet to come
+		accTrie = trie.NewStackTrie(batch)
+		accLast *common.Hash
+
+		stTrie    *trie.StackTrie
+		stAccount common.Hash
+		stLast    *common.Hash
+
+		start  = time.Now()
+		logged = time.Now()
+	)
+	// finishStorage completes the storage trie being regenerated, if any
+	finishStorage := func() error {
+		if stTrie == nil {
+			return nil
+		}
+		root, err := stTrie.Commit()
+		if err != nil {
+			return err
+		}
+		roots[stAccount], stTrie, stLast = root, nil, nil
+		return nil
+	}
+	// flushBatch writes out the batch if it grew large enough, or forcibly
+	flushBatch := func(force bool) error {
+		if batch.ValueSize() > ethdb.IdealBatchSize || (force && batch.ValueSize() > 0) {
+			if err := batch.Write(); err != nil {
+				return err
+			}
+			batch.Reset()
+		}
+		return nil
+	}
+	for {
+		kind, blob, err := in.readChunk()
+		if err != nil {
+			return nil, err
+		}
+		switch kind {
+		case exportCodeChunk:
+			var codeChunk []exportCode
+			if err := rlp.DecodeBytes(blob, &codeChunk); err != nil {
+				return nil, err
+			}
+			for _, code := range codeChunk {
+				if crypto.Keccak256Hash(code.Code) != code.Hash {
+					return nil, fmt.Errorf("%w: code hash mismatch %x", errExportCorrupted, code.Hash)
+				}
+				rawdb.WriteCode(batch, code.Hash, code.Code)
+				codes[code.Hash] = struct{}{}
+				counts.Codes++
+			}
+
+		case exportStorageChunk:
+			var storage exportStorage
+			if err := rlp.DecodeBytes(blob, &storage); err != nil {
+				return nil, err
+			}
+			// Storage chunks of an account are contiguous, start a new trie
+			// whenever the account changes
+			if stTrie == nil || stAccount != storage.Account {
+				if err := finishStorage(); err != nil {
+					return nil, err
+				}
+				if _, ok := roots[storage.Account]; ok || (accLast != nil && bytes.Compare(storage.Account[:], accLast[:]) <= 0) {
+					return nil, fmt.Errorf("%w: unordered storage of account %x", errExportCorrupted, storage.Account)
+				}
+				stTrie, stAccount = trie.NewStackTrie(batch), storage.Account
+			}
+			for i := range storage.Slots {
+				slot := &storage.Slots[i]
+				if stLast != nil && bytes.Compare(slot.Hash[:], stLast[:]) <= 0 {
+					return nil, fmt.Errorf("%w: unordered slot %x of account %x", errExportCorrupted, slot.Hash, storage.Account)
+				}
+				if err := stTrie.TryUpdate(slot.Hash[:], slot.Value); err != nil {
+					return nil, err
+				}
+				rawdb.WriteStorageSnapshot(batch, storage.Account, slot.Hash, slot.Value)
+				stLast = &slot.Hash
+				counts.Slots++
+			}
+
+		case exportAccountChunk:
+			if err := finishStorage(); err != nil {
+				return nil, err
+			}
+			var accounts []exportAccount
+			if err := rlp.DecodeBytes(blob, &accounts); err != nil {
+				return nil, err
+			}
+			for i := range accounts {
+				account := &accounts[i]
+				if accLast != nil && bytes.Compare(account.Hash[:], accLast[:]) <= 0 {
+					return nil, fmt.Errorf("%w: unordered account %x", errExportCorrupted, account.Hash)
+				}
+				full, err := FullAccount(account.Account)
+				if err != nil {
+					return nil, err
+				}
+				// Verify the storage root and the presence of the code
+				have, ok := roots[account.Hash]
+				if !ok {
+					have = emptyRoot
+				}
+				delete(roots, account.Hash)
+				if want := common.BytesToHash(full.Root); have != want {
+					return nil, fmt.Errorf("%w: storage root mismatch of account %x: have %x, want %x", errExportCorrupted, account.Hash, have, want)
+				}
+				if codeHash := common.BytesToHash(full.CodeHash); codeHash != emptyCode {
+					if _, ok := codes[codeHash]; !ok {
+						return nil, fmt.Errorf("%w: missing code %x of account %x", errExportCorrupted, codeHash, account.Hash)
+					}
+				}
+				enc, err := FullAccountRLP(account.Account)
+				if err != nil {
+					return nil, err
+				}
+				if err := accTrie.TryUpdate(account.Hash[:], enc); err != nil {
+					return nil, err
+				}
+				rawdb.WriteAccountSnapshot(batch, account.Hash, account.Account)
+				accLast = &account.Hash
+				counts.Accounts++
+			}
+			if len(roots) > 0 {
+				return nil, fmt.Errorf("%w: storage of missing accounts", errExportCorrupted)
+			}
+			if time.Since(logged) > 8*time.Second {
+				log.Info("Importing state snapshot", "at", accLast, "accounts", counts.Accounts, "slots", counts.Slots, "codes", counts.Codes, "elapsed", common.PrettyDuration(time.Since(start)))
+				logged = time.Now()
+			}
+
+		case exportFooterChunk:
+			var footer exportFooter
+			if err := rlp.DecodeBytes(blob, &footer); err != nil {
+				return nil, err
+			}
+			if stTrie != nil || len(roots) > 0 {
+				return nil, fmt.Errorf("%w: storage of missing accounts", errExportCorrupted)
+			}
+			if footer != counts {
+				return nil, fmt.Errorf("%w: item count mismatch: have %+v, want %+v", errExportCorrupted, counts, footer)
+			}
+			root, err := accTrie.Commit()
+			if err != nil {
+				return nil, err
+			}
+			if root != header.Root {
+				return nil, fmt.Errorf("state root mismatch: have %x, want %x", root, header.Root)
+			}
+			if err := flushBatch(true); err != nil {
+				return nil, err
+			}
+			// Everything imported, mark the snapshot as fully generated and
+			// link it to the block of the state, which becomes the new head
+			rawdb.DeleteSnapshotDisabled(batch)
+			rawdb.DeleteSnapshotRecoveryNumber(batch)
+			rawdb.WriteSnapshotRoot(batch, root)
+			journalProgress(batch, nil, nil)
+
+			rawdb.WriteHeadBlockHash(batch, header.Hash)
+			if number := rawdb.ReadHeaderNumber(db, rawdb.ReadHeadFastBlockHash(db)); number == nil || *number < header.Number {
+				rawdb.WriteHeadFastBlockHash(batch, header.Hash)
+			}
+			if number := rawdb.ReadHeaderNumber(db, rawdb.ReadHeadHeaderHash(db)); number == nil || *number < header.Number {
+				rawdb.WriteHeadHeaderHash(batch, header.Hash)
+			}
+			if err := flushBatch(true); err != nil {
+				return nil, err
+			}
+			log.Info("Imported state snapshot", "number", header.Number, "hash", header.Hash, "root", root, "accounts", counts.Accounts, "slots", counts.Slots, "codes", counts.Codes, "elapsed", common.PrettyDuration(time.Since(start)))
+			return block.Header(), nil
+
+		default:
+			return nil, fmt.Errorf("%w: unknown chunk kind %d", errExportCorrupted, kind)
+		}
+		if err := flushBatch(false); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// checkChainData ensures that the bodies and receipts of all the canonical blocks
+// up to the given number are available locally. The ones up to the current head
+// fast block are already vouched for by that marker.
+func checkChainData(db ethdb.Reader, number uint64) error {
+	start := uint64(1)
+	if head := rawdb.ReadHeaderNumber(db, rawdb.ReadHeadFastBlockHash(db)); head != nil {
+		start = *head + 1
+	}
+	for n := start; n <= number; n++ {
+		hash := rawdb.ReadCanonicalHash(db, n)
+		if hash == (common.Hash{}) {
+			return fmt.Errorf("canonical block #%d missing", n)
+		}
+		if !rawdb.HasBody(db, hash, n) {
+			return fmt.Errorf("body of block #%d [%x] missing", n, hash)
+		}
+		if !rawdb.HasReceipts(db, hash, n) {
+			return fmt.Errorf("receipts of block #%d [%x] missing", n, hash)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,191 @@
+// Copyright 2021 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package snapshot
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/ethereum/go-ethereum/rlp"
+	"github.com/ethereum/go-ethereum/trie"
+)
+
+// makeExportState creates a state with plain accounts, contracts sharing code
+// and a contract with a storage large enough to span multiple chunks, returning
+// its snapshot tree and a block header of the state.
+func makeExportState(t *testing.T) (*Tree, *types.Header) {
+	helper := newHelper()
+	for i := 0; i < 64; i++ {
+		acc := &Account{Balance: big.NewInt(int64(i)), Root: emptyRoot.Bytes(), CodeHash: emptyCode.Bytes()}
+		if i%4 == 0 {
+			code := []byte{byte(i % 8)}
+			rawdb.WriteCode(helper.diskdb, crypto.Keccak256Hash(code), code)
+			acc.CodeHash = crypto.Keccak256(code)
+
+			var keys, vals []string
+			for j := 0; j < i; j++ {
+				keys = append(keys, fmt.Sprintf("key-%d", j))
+				vals = append(vals, fmt.Sprintf("val-%d-%d", i, j))
+			}
+			if len(keys) > 0 {
+				acc.Root = helper.makeStorageTrie(keys, vals)
+			}
+		}
+		helper.addTrieAccount(fmt.Sprintf("acc-%d", i), acc)
+	}
+	var keys, vals []string
+	for i := 0; i < 2500; i++ {
+		keys = append(keys, fmt.Sprintf("key-%d", i))
+		vals = append(vals, string(bytes.Repeat([]byte{byte(i)}, 512)))
+	}
+	helper.addTrieAccount("acc-large", &Account{Balance: big.NewInt(1), Root: helper.makeStorageTrie(keys, vals), CodeHash: emptyCode.Bytes()})
+
+	root, _, _ := helper.accTrie.Commit(nil)
+	helper.triedb.Commit(root, false, nil)
+
+	snaps, err := New(helper.diskdb, helper.triedb, 16, root, false, true, false)
+	if err != nil {
+		t.Fatalf("failed to create snapshot: %v", err)
+	}
+	return snaps, &types.Header{Number: big.NewInt(1), Root: root, Difficulty: big.NewInt(1)}
+}
+
+// makeImportDatabase creates a database containing the canonical block of an
+// exported state along with its receipts, but not the state itself.
+func makeImportDatabase(header *types.Header) ethdb.Database {
+	db := rawdb.NewMemoryDatabase()
+	block := types.NewBlockWithHeader(header)
+	rawdb.WriteBlock(db, block)
+	rawdb.WriteReceipts(db, block.Hash(), block.NumberU64(), nil)
+	rawdb.WriteTd(db, block.Hash(), block.NumberU64(), block.Difficulty())
+	rawdb.WriteCanonicalHash(db, block.Hash(), block.NumberU64())
+	return db
+}
+
+// Tests that an exported snapshot can be imported into an empty database, with
+// the tries regenerated and the snapshot usable right away.
+func TestExportImport(t *testing.T) {
+	snaps, header := makeExportState(t)
+	root := header.Root
+
+	var export bytes.Buffer
+	if err := snaps.Export(header, &export); err != nil {
+		t.Fatalf("failed to export snapshot: %v", err)
+	}
+	// Importing without the block of the state should be rejected
+	if _, err := Import(rawdb.NewMemoryDatabase(), bytes.NewReader(export.Bytes())); err == nil {
+		t.Fatalf("import without the block of the state succeeded")
+	}
+	// Importing without the receipts leading up to the state should be rejected
+	db := makeImportDatabase(header)
+	rawdb.DeleteReceipts(db, header.Hash(), header.Number.Uint64())
+	if _, err := Import(db, bytes.NewReader(export.Bytes())); err == nil {
+		t.Fatalf("import without the receipts of the chain succeeded")
+	}
+	db = makeImportDatabase(header)
+	imported, err := Import(db, bytes.NewReader(export.Bytes()))
+	if err != nil {
+		t.Fatalf("failed to import snapshot: %v", err)
+	}
+	if imported.Hash() != header.Hash() {
+		t.Fatalf("imported block mismatch: have %x, want %x", imported.Hash(), header.Hash())
+	}
+	// The block of the state should be the new head of the chain
+	if head := rawdb.ReadHeadBlockHash(db); head != header.Hash() {
+		t.Fatalf("head block mismatch: have %x, want %x", head, header.Hash())
+	}
+	if head := rawdb.ReadHeadFastBlockHash(db); head != header.Hash() {
+		t.Fatalf("head fast block mismatch: have %x, want %x", head, header.Hash())
+	}
+	if head := rawdb.ReadHeadHeaderHash(db); head != header.Hash() {
+		t.Fatalf("head header mismatch: have %x, want %x", head, header.Hash())
+	}
+	// The regenerated tries should match the original ones
+	triedb := trie.NewDatabase(db)
+	accTrie, err := trie.NewSecure(root, triedb)
+	if err != nil {
+		t.Fatalf("failed to open account trie: %v", err)
+	}
+	accounts := 0
+	for it := trie.NewIterator(accTrie.NodeIterator(nil)); it.Next(); accounts++ {
+		var acc Account
+		if err := rlp.DecodeBytes(it.Value, &acc); err != nil {
+			t.Fatalf("failed to decode account: %v", err)
+		}
+		if root := common.BytesToHash(acc.Root); root != emptyRoot {
+			stTrie, err := trie.NewSecure(root, triedb)
+			if err != nil {
+				t.Fatalf("failed to open storage trie: %v", err)
+			}
+			it := stTrie.NodeIterator(nil)
+			for it.Next(true) {
+			}
+			if it.Error() != nil {
+				t.Fatalf("failed to iterate storage trie: %v", it.Error())
+			}
+		}
+		if codeHash := common.BytesToHash(acc.CodeHash); codeHash != emptyCode && len(rawdb.ReadCode(db, codeHash)) == 0 {
+			t.Fatalf("code %x missing", codeHash)
+		}
+	}
+	if accounts != 65 {
+		t.Fatalf("account count mismatch: have %d, want %d", accounts, 65)
+	}
+	// The imported snapshot should be loadable and complete
+	imports, err := New(db, triedb, 16, root, false, false, false)
+	if err != nil {
+		t.Fatalf("failed to load imported snapshot: %v", err)
+	}
+	if err := imports.Verify(root); err != nil {
+		t.Fatalf("imported snapshot invalid: %v", err)
+	}
+	// Importing twice should be rejected, the head already being at the block
+	if _, err := Import(db, bytes.NewReader(export.Bytes())); err == nil {
+		t.Fatalf("import below the chain head succeeded")
+	}
+}
+
+// Tests that corrupted or truncated exports are rejected.
+func TestImportCorrupted(t *testing.T) {
+	snaps, header := makeExportState(t)
+
+	var export bytes.Buffer
+	if err := snaps.Export(header, &export); err != nil {
+		t.Fatalf("failed to export snapshot: %v", err)
+	}
+	blob := export.Bytes()
+
+	corrupted := common.CopyBytes(blob)
+	corrupted[len(corrupted)/2] ^= 0xff
+	if _, err := Import(makeImportDatabase(header), bytes.NewReader(corrupted)); !errors.Is(err, errExportCorrupted) {
+		t.Errorf("corrupted export: error mismatch: have %v, want %v", err, errExportCorrupted)
+	}
+	if _, err := Import(makeImportDatabase(header), bytes.NewReader(blob[:len(blob)/2])); err == nil {
+		t.Errorf("truncated export imported")
+	}
+	if _, err := Import(makeImportDatabase(header), bytes.NewReader(blob[len(exportMagic):])); err == nil {
+		t.Errorf("export without magic imported")
+	}
+}
@@ -785,6 +785,54 @@ func TestGenerateFromEmptySnap(t *testing.T) {
 	<-stop
 }
 
+// Tests that a paused snapshot generator makes no progress, and that it continues
+// to completion once resumed.
+func TestGeneratePauseResume(t *testing.T) {
+	accountCheckRange = 10
+	storageCheckRange = 20
+	helper := newHelper()
+	stRoot := helper.makeStorageTrie([]string{"key-1", "key-2", "key-3"}, []string{"val-1", "val-2", "val-3"})
+	for i := 0; i < 400; i++ {
+		helper.addTrieAccount(fmt.Sprintf("acc-%d", i),
+			&Account{Balance: big.NewInt(1), Root: stRoot, CodeHash: emptyCode.Bytes()})
+	}
+	root, snap := helper.Generate()
+	tree := &Tree{
+		diskdb: helper.diskdb,
+		triedb: helper.triedb,
+		layers: map[common.Hash]snapshot{root: snap},
+	}
+	tree.PauseGeneration()
+	if snap.genAbort != nil {
+		t.Fatalf("generator not detached from the disk layer")
+	}
+	snap.lock.RLock()
+	marker := snap.genMarker
+	snap.lock.RUnlock()
+	if marker != nil {
+		select {
+		case <-snap.genPending:
+			t.Fatalf("snapshot generation progressed while paused")
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+	tree.ResumeGeneration()
+	select {
+	case <-snap.genPending:
+		// Snapshot generation succeeded
+
+	case <-time.After(3 * time.Second):
+		t.Errorf("Snapshot generation failed")
+	}
+	checkSnapRoot(t, snap, root)
+	// Signal abortion to the generator and wait for it to tear down
+	if snap.genAbort != nil {
+		stop := make(chan *generatorStats)
+		snap.genAbort <- stop
+		<-stop
+	}
+}
+
 // Tests that snapshot generation with existent flat state, where the flat state
 // storage is correct, but incomplete.
 // The incomplete part is on the second range
@@ -23,6 +23,7 @@ import (
 	"fmt"
 	"sync"
 	"sync/atomic"
+	"time"
 
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/core/rawdb"
@@ -163,6 +164,9 @@ type Tree struct {
 	cache  int                      // Megabytes permitted to use for read caches
 	layers map[common.Hash]snapshot // Collection of all known layers
 	lock   sync.RWMutex
+
+	genPaused bool            // Flag whether snapshot generation is paused
+	genStats  *generatorStats // Statistics of the paused generator, nil if none was running
 }
 
 // New attempts to load an already existing snapshot from a persistent key-value
@@ -283,6 +287,62 @@ func (t *Tree) Disable() {
 	}
 }
 
+// PauseGeneration interrupts any running snapshot generator, leaving the disk
+// layer (and all the ones flattened into it meanwhile) partially generated until
+// ResumeGeneration is called. It's meant for operations which need the tries
+// to stay untouched by the generator, such as deleting stale nodes.
+func (t *Tree) PauseGeneration() {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	if t.genPaused {
+		return
+	}
+	t.genPaused = true
+
+	layer := t.disklayer()
+	if layer == nil || layer.genAbort == nil {
+		return
+	}
+	abort := make(chan *generatorStats)
+	layer.genAbort <- abort
+	if t.genStats = <-abort; t.genStats != nil {
+		t.genStats.Log("Paused state snapshot generation", layer.root, layer.genMarker)
+	}
+	// Detach the generator from the layer so that flattening neither waits for,
+	// nor restarts it.
+	layer.genAbort = nil
+}
+
+// ResumeGeneration restarts the snapshot generator paused by PauseGeneration on
+// the current disk layer, continuing where it was left off.
+func (t *Tree) ResumeGeneration() {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	if !t.genPaused {
+		return
+	}
+	stats := t.genStats
+	t.genPaused, t.genStats = false, nil
+
+	layer := t.disklayer()
+	if layer == nil || layer.genAbort != nil {
+		return
+	}
+	layer.lock.RLock()
+	generating := layer.genMarker != nil && !layer.stale
+	layer.lock.RUnlock()
+	if !generating {
+		return
+	}
+	if stats == nil {
+		stats = &generatorStats{start: time.Now()}
+	}
+	layer.genAbort = make(chan chan *generatorStats)
+	go layer.generate(stats)
+}
+
 // Snapshot retrieves a snapshot belonging to the given block root, or nil if no
 // snapshot is maintained for that block.
 func (t *Tree) Snapshot(blockRoot common.Hash) Snapshot {
@@ -826,6 +886,13 @@ func (t *Tree) generating() (bool, error) {
 	return layer.genMarker != nil, nil
 }
 
+// Generating reports whether the snapshot is still under construction, either
+// actively or paused.
+func (t *Tree) Generating() bool {
+	generating, _ := t.generating()
+	return generating
+}
+
 // diskRoot is a external helper function to return the disk layer root.
 func (t *Tree) DiskRoot() common.Hash {
 	t.lock.Lock()
@@ -0,0 +1,349 @@
+// Copyright 2021 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package snapshot
+
+import (
+	"bytes"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/rlp"
+	"github.com/ethereum/go-ethereum/trie"
+)
+
+// MismatchKind is the kind of a difference found between the snapshot and the
+// tries during state verification.
+type MismatchKind int
+
+const (
+	AccountMismatch MismatchKind = iota // Account missing from or differing between the snapshot and the trie
+	StorageMismatch                     // Storage slot missing from or differing between the snapshot and the trie
+	CodeMismatch                        // Contract code missing from the database or not matching its hash
+)
+
+// String implements fmt.Stringer.
+func (k MismatchKind) String() string {
+	switch k {
+	case AccountMismatch:
+		return "account"
+	case StorageMismatch:
+		return "storage"
+	case CodeMismatch:
+		return "code"
+	default:
+		return fmt.Sprintf("unknown(%d)", int(k))
+	}
+}
+
+// Mismatch is a difference found between the snapshot and the tries.
+type Mismatch struct {
+	Kind    MismatchKind
+	Account common.Hash // Hash of the account the mismatch belongs to
+	Slot    common.Hash // Hash of the storage slot for storage mismatches
+	Snap    []byte      // Value in the snapshot (slim account or slot), nil if missing
+	Trie    []byte      // Value in the trie (consensus account or slot), nil if missing
+}
+
+// String implements fmt.Stringer.
+func (m *Mismatch) String() string {
+	switch m.Kind {
+	case StorageMismatch:
+		return fmt.Sprintf("storage %x of account %x: snapshot %x, trie %x", m.Slot, m.Account, m.Snap, m.Trie)
+	case CodeMismatch:
+		return fmt.Sprintf("code %x of account %x", m.Trie, m.Account)
+	default:
+		return fmt.Sprintf("account %x: snapshot %x, trie %x", m.Account, m.Snap, m.Trie)
+	}
+}
+
+// VerifyStats contains the statistics of a state verification.
+type VerifyStats struct {
+	Accounts   uint64 // Number of accounts verified
+	Slots      uint64 // Number of storage slots verified
+	Codes      uint64 // Number of contract codes verified
+	Mismatches uint64 // Number of mismatches found
+}
+
+// verifyBuckets is the number of account hash ranges the verification is split
+// into, to be picked up by the workers.
+const verifyBuckets = 256
+
+// VerifyState cross-checks every account, storage slot and contract code of the
+// snapshot with the given root against the tries, using the given number of
+// threads. Unlike Verify, the tries are walked too, so entries missing from or
+// excess in the snapshot are detected precisely. Every mismatch found is passed
+// to the callback, which may be called concurrently.
+//
+// Errors are only returned if the verification couldn't be completed, the found
+// mismatches are reported in the returned statistics.
+func (t *Tree) VerifyState(root common.Hash, threads int, onMismatch func(*Mismatch)) (*VerifyStats, error) {
+	if threads <= 0 {
+		threads = 1
+	}
+	var (
+		stats   VerifyStats
+		tasks   = make(chan int, verifyBuckets)
+		codes   sync.Map
+		failure error
+		lock    sync.Mutex
+		wg      sync.WaitGroup
+		done    = make(chan struct{})
+		start   = time.Now()
+	)
+	report := func(m *Mismatch) {
+		atomic.AddUint64(&stats.Mismatches, 1)
+		if onMismatch != nil {
+			onMismatch(m)
+		}
+	}
+	for i := 0; i < verifyBuckets; i++ {
+		tasks <- i
+	}
+	close(tasks)
+
+	wg.Add(threads)
+	for i := 0; i < threads; i++ {
+		go func() {
+			defer wg.Done()
+			for bucket := range tasks {
+				if err := t.verifyBucket(root, byte(bucket), &stats, &codes, report); err != nil {
+					lock.Lock()
+					if failure == nil {
+						failure = err
+					}
+					lock.Unlock()
+					return
+				}
+			}
+		}()
+	}
+	go func() {
+		ticker := time.NewTicker(8 * time.Second)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				log.Info("Verifying state snapshot", "accounts", atomic.LoadUint64(&stats.Accounts), "slots", atomic.LoadUint64(&stats.Slots),
+					"codes", atomic.LoadUint64(&stats.Codes), "mismatches", atomic.LoadUint64(&stats.Mismatches), "elapsed", common.PrettyDuration(time.Since(start)))
+			case <-done:
+				return
+			}
+		}
+	}()
+	wg.Wait()
+	close(done)
+
+	if failure != nil {
+		return nil, failure
+	}
+	log.Info("Verified state snapshot", "root", root, "accounts", stats.Accounts, "slots", stats.Slots, "codes", stats.Codes,
+		"mismatches", stats.Mismatches, "elapsed", common.PrettyDuration(time.Since(start)))
+	return &stats, nil
+}
+
+// verifyBucket cross-checks the accounts whose hashes start with the given byte.
+func (t *Tree) verifyBucket(root common.Hash, bucket byte, stats *VerifyStats, codes *sync.Map, report func(*Mismatch)) error {
+	origin := common.Hash{bucket}
+
+	snapIt, err := t.AccountIterator(root, origin)
+	if err != nil {
+		return err
+	}
+	defer snapIt.Release()
+
+	tr, err := trie.NewWithOwner(common.Hash{}, root, t.triedb)
+	if err != nil {
+		return err
+	}
+	trieIt := trie.NewIterator(tr.NodeIterator(origin[:]))
+
+	return verifyRange([]byte{bucket}, snapIt, snapIt.Account, trieIt, func(hash common.Hash, snapVal, trieVal []byte) error {
+		atomic.AddUint64(&stats.Accounts, 1)
+
+		if snapVal == nil || trieVal == nil {
+			report(&Mismatch{Kind: AccountMismatch, Account: hash, Snap: snapVal, Trie: trieVal})
+			return nil
+		}
+		full, err := FullAccountRLP(snapVal)
+		if err != nil || !bytes.Equal(full, trieVal) {
+			report(&Mismatch{Kind: AccountMismatch, Account: hash, Snap: snapVal, Trie: trieVal})
+		}
+		// Cross-check the storage and code against the ones referenced by the trie
+		var account Account
+		if err := rlp.DecodeBytes(trieVal, &account); err != nil {
+			return err
+		}
+		if err := t.verifyStorage(root, hash, common.BytesToHash(account.Root), stats, report); err != nil {
+			return err
+		}
+		if codeHash := common.BytesToHash(account.CodeHash); codeHash != emptyCode {
+			if _, seen := codes.LoadOrStore(codeHash, struct{}{}); !seen {
+				atomic.AddUint64(&stats.Codes, 1)
+				if code := rawdb.ReadCode(t.diskdb, codeHash); len(code) == 0 || crypto.Keccak256Hash(code) != codeHash {
+					report(&Mismatch{Kind: CodeMismatch, Account: hash, Trie: codeHash[:]})
+				}
+			}
+		}
+		return nil
+	})
+}
+
+// verifyStorage cross-checks the storage slots of a single account.
+func (t *Tree) verifyStorage(root common.Hash, account common.Hash, storageRoot common.Hash, stats *VerifyStats, report func(*Mismatch)) error {
+	snapIt, err := t.StorageIterator(root, account, common.Hash{})
+	if err != nil {
+		return err
+	}
+	defer snapIt.Release()
+
+	tr, err := trie.NewWithOwner(account, storageRoot, t.triedb)
+	if err != nil {
+		return err
+	}
+	trieIt := trie.NewIterator(tr.NodeIterator(nil))
+
+	return verifyRange(nil, snapIt, snapIt.Slot, trieIt, func(hash common.Hash, snapVal, trieVal []byte) error {
+		atomic.AddUint64(&stats.Slots, 1)
+		if !bytes.Equal(snapVal, trieVal) {
+			report(&Mismatch{Kind: StorageMismatch, Account: account, Slot: hash, Snap: snapVal, Trie: trieVal})
+		}
+		return nil
+	})
+}
+
+// verifyRange walks a snapshot and a trie iterator in lockstep, calling back with
+// every key found in either of them, along with the values in both, nil if the
+// key is missing from one side. The walk stops at the first key not starting
+// with the given prefix.
+func verifyRange(prefix []byte, snapIt Iterator, snapValue func() []byte, trieIt *trie.Iterator, check func(hash common.Hash, snapVal, trieVal []byte) error) error {
+	var (
+		snapOk = snapIt.Next() && bytes.HasPrefix(snapIt.Hash().Bytes(), prefix)
+		trieOk = trieIt.Next() && bytes.HasPrefix(trieIt.Key, prefix)
+	)
+	for snapOk || trieOk {
+		var (
+			hash    common.Hash
+			snapVal []byte
+			trieVal []byte
+		)
+		switch {
+		case !trieOk || (snapOk && bytes.Compare(snapIt.Hash().Bytes(), trieIt.Key) < 0):
+			hash, snapVal = snapIt.Hash(), common.CopyBytes(snapValue())
+			snapOk = snapIt.Next() && bytes.HasPrefix(snapIt.Hash().Bytes(), prefix)
+		case !snapOk || bytes.Compare(snapIt.Hash().Bytes(), trieIt.Key) > 0:
+			hash, trieVal = common.BytesToHash(trieIt.Key), common.CopyBytes(trieIt.Value)
+			trieOk = trieIt.Next() && bytes.HasPrefix(trieIt.Key, prefix)
+		default:
+			hash, snapVal, trieVal = snapIt.Hash(), common.CopyBytes(snapValue()), common.CopyBytes(trieIt.Value)
+			snapOk = snapIt.Next() && bytes.HasPrefix(snapIt.Hash().Bytes(), prefix)
+			trieOk = trieIt.Next() && bytes.HasPrefix(trieIt.Key, prefix)
+		}
+		if err := check(hash, snapVal, trieVal); err != nil {
+			return err
+		}
+	}
+	if err := snapIt.Error(); err != nil {
+		return err
+	}
+	return trieIt.Err
+}
+
+// Repair regenerates the snapshot entries of the given accounts, along with all
+// their storage slots, from the tries. Only the persistent disk layer can be
+// repaired, so root must be the root of the disk layer.
+func (t *Tree) Repair(root common.Hash, accounts []common.Hash) error {
+	if ok, err := t.generating(); err != nil {
+		return err
+	} else if ok {
+		return ErrNotConstructed
+	}
+	dl := t.disklayer()
+	if dl.root != root {
+		return fmt.Errorf("only the disk layer %x can be repaired, not %x", dl.root, root)
+	}
+	tr, err := trie.NewWithOwner(common.Hash{}, root, t.triedb)
+	if err != nil {
+		return err
+	}
+	dl.lock.Lock()
+	defer dl.lock.Unlock()
+
+	batch := t.diskdb.NewBatch()
+	for _, hash := range accounts {
+		// Drop all the storage slots of the account from the snapshot
+		it := rawdb.IterateStorageSnapshots(t.diskdb, hash)
+		for it.Next() {
+			key := it.Key()
+			if len(key) != len(rawdb.SnapshotStoragePrefix)+2*common.HashLength {
+				continue
+			}
+			batch.Delete(key)
+			dl.cache.Del(key[len(rawdb.SnapshotStoragePrefix):])
+		}
+		err := it.Error()
+		it.Release()
+		if err != nil {
+			return err
+		}
+		// Write the account and its storage back from the tries
+		enc, err := tr.TryGet(hash[:])
+		if err != nil {
+			return err
+		}
+		if len(enc) == 0 {
+			rawdb.DeleteAccountSnapshot(batch, hash)
+			dl.cache.Del(hash[:])
+			continue
+		}
+		var account Account
+		if err := rlp.DecodeBytes(enc, &account); err != nil {
+			return err
+		}
+		slim := SlimAccountRLP(account.Nonce, account.Balance, common.BytesToHash(account.Root), account.CodeHash)
+		rawdb.WriteAccountSnapshot(batch, hash, slim)
+		dl.cache.Set(hash[:], slim)
+
+		storage, err := trie.NewWithOwner(hash, common.BytesToHash(account.Root), t.triedb)
+		if err != nil {
+			return err
+		}
+		slots := trie.NewIterator(storage.NodeIterator(nil))
+		for slots.Next() {
+			slot := common.BytesToHash(slots.Key)
+			rawdb.WriteStorageSnapshot(batch, hash, slot, slots.Value)
+			dl.cache.Set(append(hash[:], slot[:]...), slots.Value)
+		}
+		if slots.Err != nil {
+			return slots.Err
+		}
+		if batch.ValueSize() > ethdb.IdealBatchSize {
+			if err := batch.Write(); err != nil {
+				return err
+			}
+			batch.Reset()
+		}
+		log.Info("Repaired snapshot account", "hash", hash)
+	}
+	return batch.Write()
+}
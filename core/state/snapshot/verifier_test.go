@@ -0,0 +1,125 @@
+// Copyright 2021 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package snapshot
+
+import (
+	"math/big"
+	"sync"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/rlp"
+)
+
+// Tests that the state verification reports every corruption of the snapshot
+// precisely, and that repairing the affected accounts fixes all but the codes.
+func TestVerifyStateRepair(t *testing.T) {
+	snaps, header := makeExportState(t)
+	root := header.Root
+
+	verify := func(threads int) (*VerifyStats, map[MismatchKind]map[common.Hash]int) {
+		var (
+			lock  sync.Mutex
+			found = make(map[MismatchKind]map[common.Hash]int)
+		)
+		stats, err := snaps.VerifyState(root, threads, func(m *Mismatch) {
+			lock.Lock()
+			defer lock.Unlock()
+			if found[m.Kind] == nil {
+				found[m.Kind] = make(map[common.Hash]int)
+			}
+			found[m.Kind][m.Account]++
+		})
+		if err != nil {
+			t.Fatalf("failed to verify state: %v", err)
+		}
+		return stats, found
+	}
+	for _, threads := range []int{1, 4} {
+		if stats, _ := verify(threads); stats.Mismatches != 0 || stats.Accounts != 65 || stats.Slots == 0 || stats.Codes != 2 {
+			t.Fatalf("threads %d: unexpected stats of intact state: %+v", threads, stats)
+		}
+	}
+	// Corrupt the snapshot in all the possible ways, caching some of the bad data
+	var (
+		missing   = hashData([]byte("acc-1"))
+		extra     = hashData([]byte("acc-extra"))
+		modified  = hashData([]byte("acc-2"))
+		storage   = hashData([]byte("acc-8"))
+		excess    = hashData([]byte("acc-12"))
+		large     = hashData([]byte("acc-large"))
+		code      = crypto.Keccak256Hash([]byte{0})
+		db        = snaps.diskdb
+		modAcc, _ = rlp.EncodeToBytes(&Account{Balance: big.NewInt(1000)})
+	)
+	rawdb.DeleteAccountSnapshot(db, missing)
+	rawdb.WriteAccountSnapshot(db, extra, modAcc)
+	rawdb.WriteStorageSnapshot(db, extra, common.Hash{0x01}, []byte{0x01})
+	rawdb.WriteAccountSnapshot(db, modified, modAcc)
+	rawdb.WriteStorageSnapshot(db, storage, hashData([]byte("key-3")), []byte("bad"))
+	rawdb.DeleteStorageSnapshot(db, storage, hashData([]byte("key-5")))
+	rawdb.WriteStorageSnapshot(db, excess, common.Hash{0xff}, []byte{0x01})
+	rawdb.DeleteStorageSnapshot(db, large, hashData([]byte("key-2000")))
+	rawdb.DeleteCode(db, code)
+
+	if _, err := snaps.Snapshot(root).Account(modified); err != nil {
+		t.Fatalf("failed to cache corrupted account: %v", err)
+	}
+	stats, found := verify(4)
+	if stats.Mismatches != 8 {
+		t.Errorf("mismatch count: have %d, want 8", stats.Mismatches)
+	}
+	for kind, want := range map[MismatchKind]map[common.Hash]int{
+		AccountMismatch: {missing: 1, extra: 1, modified: 1},
+		StorageMismatch: {storage: 2, excess: 1, large: 1},
+	} {
+		for hash, n := range want {
+			if found[kind][hash] != n {
+				t.Errorf("%v mismatches of %x: have %d, want %d", kind, hash, found[kind][hash], n)
+			}
+		}
+	}
+	if len(found[CodeMismatch]) != 1 {
+		t.Errorf("code mismatches: have %d, want 1", len(found[CodeMismatch]))
+	}
+	// Repair the accounts and ensure only the missing code is reported afterwards
+	var accounts []common.Hash
+	for _, kind := range []MismatchKind{AccountMismatch, StorageMismatch} {
+		for hash := range found[kind] {
+			accounts = append(accounts, hash)
+		}
+	}
+	if err := snaps.Repair(root, accounts); err != nil {
+		t.Fatalf("failed to repair snapshot: %v", err)
+	}
+	if stats, found = verify(4); stats.Mismatches != 1 || len(found[CodeMismatch]) != 1 {
+		t.Fatalf("unexpected mismatches after repair: %+v", stats)
+	}
+	acc, err := snaps.Snapshot(root).Account(modified)
+	if err != nil || acc == nil || acc.Balance.Uint64() != 2 {
+		t.Fatalf("cached account not repaired: %v, %v", acc, err)
+	}
+	if acc, _ := snaps.Snapshot(root).Account(extra); acc != nil {
+		t.Fatalf("excess account not removed")
+	}
+	// Only the disk layer can be repaired
+	if err := snaps.Repair(common.Hash{0x01}, accounts); err == nil {
+		t.Fatalf("repaired unknown layer")
+	}
+}
@@ -0,0 +1,446 @@
+// Copyright 2021 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package state
+
+import (
+	"bytes"
+	"sort"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/state/snapshot"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/rlp"
+	"github.com/ethereum/go-ethereum/trie"
+)
+
+// StateDiff is a page of the differences between two states, ordered by account
+// hash.
+type StateDiff struct {
+	Accounts []*AccountDiff
+	Next     *common.Hash // Account hash to continue from, nil if the diff is complete
+}
+
+// AccountDiff is the difference of a single account between two states. The
+// old or new account is nil if it doesn't exist in the respective state.
+type AccountDiff struct {
+	Hash     common.Hash
+	Old      *types.StateAccount
+	New      *types.StateAccount
+	Storage  []*SlotDiff
+	NextSlot *common.Hash // Slot hash to continue from via DiffStorage, nil if the storage diff is complete
+}
+
+// StorageDiff is a page of the differences between the storage of an account in
+// two states, ordered by slot hash.
+type StorageDiff struct {
+	Slots []*SlotDiff
+	Next  *common.Hash // Slot hash to continue from, nil if the diff is complete
+}
+
+// SlotDiff is the difference of a single storage slot between two states, with
+// zero values standing for missing slots.
+type SlotDiff struct {
+	Hash common.Hash
+	Old  common.Hash
+	New  common.Hash
+}
+
+// DiffStates returns the differences between the states with roots from and to,
+// starting at the given account hash and containing at most limit accounts, each
+// with at most limit storage slots. The rest of the storage differences of an
+// account can be retrieved via DiffStorage. If a snapshot tree is given and it links the two states via diff layers, the
+// touched items are taken from those. The tries are walked otherwise, skipping
+// over the subtries that are identical in the two states.
+func DiffStates(db Database, snaps *snapshot.Tree, from, to common.Hash, start common.Hash, limit int) (*StateDiff, error) {
+	if snaps != nil {
+		if changes, err := snaps.Changes(from, to); err == nil {
+			if diff, err := diffSnapshots(snaps, changes, from, to, start, limit); err == nil {
+				return diff, nil
+			}
+		}
+	}
+	return diffTries(db, from, to, start, limit)
+}
+
+// DiffStorage returns the differences between the storage of an account in the
+// states with roots from and to, starting at the given slot hash and containing
+// at most limit slots. The snapshot diff layers are used the same way as in
+// DiffStates.
+func DiffStorage(db Database, snaps *snapshot.Tree, from, to common.Hash, account common.Hash, start common.Hash, limit int) (*StorageDiff, error) {
+	if snaps != nil {
+		if changes, err := snaps.Changes(from, to); err == nil {
+			if diff, err := diffSnapshotsStorage(snaps, changes, from, to, account, start, limit); err == nil {
+				return diff, nil
+			}
+		}
+	}
+	return diffTriesStorage(db, from, to, account, start, limit)
+}
+
+// diffTries computes a page of the differences between two states by walking
+// their account and storage tries.
+func diffTries(db Database, from, to common.Hash, start common.Hash, limit int) (*StateDiff, error) {
+	oldTrie, err := db.OpenTrie(from)
+	if err != nil {
+		return nil, err
+	}
+	newTrie, err := db.OpenTrie(to)
+	if err != nil {
+		return nil, err
+	}
+	diff := new(StateDiff)
+	err = diffLeaves(oldTrie, newTrie, start[:], func(key, oldVal, newVal []byte) (bool, error) {
+		hash := common.BytesToHash(key)
+		if len(diff.Accounts) >= limit {
+			diff.Next = &hash
+			return true, nil
+		}
+		account := &AccountDiff{Hash: hash}
+		if account.Old, err = decodeAccount(oldVal); err != nil {
+			return false, err
+		}
+		if account.New, err = decodeAccount(newVal); err != nil {
+			return false, err
+		}
+		oldRoot, newRoot := emptyRoot, emptyRoot
+		if account.Old != nil {
+			oldRoot = account.Old.Root
+		}
+		if account.New != nil {
+			newRoot = account.New.Root
+		}
+		if oldRoot != newRoot {
+			if account.Storage, account.NextSlot, err = diffStorageTries(db, hash, oldRoot, newRoot, common.Hash{}, limit); err != nil {
+				return false, err
+			}
+		}
+		diff.Accounts = append(diff.Accounts, account)
+		return false, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return diff, nil
+}
+
+// diffTriesStorage computes a page of the differences between the storage of an
+// account in two states by walking their account and storage tries.
+func diffTriesStorage(db Database, from, to common.Hash, account common.Hash, start common.Hash, limit int) (*StorageDiff, error) {
+	oldTrie, err := db.OpenTrie(from)
+	if err != nil {
+		return nil, err
+	}
+	newTrie, err := db.OpenTrie(to)
+	if err != nil {
+		return nil, err
+	}
+	oldRoot, err := storageRoot(oldTrie, account)
+	if err != nil {
+		return nil, err
+	}
+	newRoot, err := storageRoot(newTrie, account)
+	if err != nil {
+		return nil, err
+	}
+	diff := new(StorageDiff)
+	if oldRoot != newRoot {
+		if diff.Slots, diff.Next, err = diffStorageTries(db, account, oldRoot, newRoot, start, limit); err != nil {
+			return nil, err
+		}
+	}
+	return diff, nil
+}
+
+// storageRoot retrieves the storage root of an account from an account trie,
+// the empty root if the account doesn't exist.
+func storageRoot(tr Trie, hash common.Hash) (common.Hash, error) {
+	it := trie.NewIterator(tr.NodeIterator(hash[:]))
+	if !it.Next() || !bytes.Equal(it.Key, hash[:]) {
+		return emptyRoot, it.Err
+	}
+	account, err := decodeAccount(it.Value)
+	if err != nil {
+		return common.Hash{}, err
+	}
+	return account.Root, nil
+}
+
+// diffStorageTries computes a page of the differences between two storage tries
+// of an account, starting at the given slot hash and containing at most limit
+// slots. The slot hash to continue from is returned if there's more.
+func diffStorageTries(db Database, addrHash, from, to common.Hash, start common.Hash, limit int) ([]*SlotDiff, *common.Hash, error) {
+	oldTrie, err := db.OpenStorageTrie(addrHash, from)
+	if err != nil {
+		return nil, nil, err
+	}
+	newTrie, err := db.OpenStorageTrie(addrHash, to)
+	if err != nil {
+		return nil, nil, err
+	}
+	var (
+		slots []*SlotDiff
+		next  *common.Hash
+	)
+	err = diffLeaves(oldTrie, newTrie, start[:], func(key, oldVal, newVal []byte) (bool, error) {
+		hash := common.BytesToHash(key)
+		if len(slots) >= limit {
+			next = &hash
+			return true, nil
+		}
+		slot := &SlotDiff{Hash: hash}
+		if slot.Old, err = decodeSlot(oldVal); err != nil {
+			return false, err
+		}
+		if slot.New, err = decodeSlot(newVal); err != nil {
+			return false, err
+		}
+		slots = append(slots, slot)
+		return false, nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return slots, next, nil
+}
+
+// diffLeaves iterates over the leaves differing between two tries in ascending
+// key order, starting at the given key. The callback receives the old and new
+// values of every such leaf, nil if missing from either trie, and may stop the
+// iteration by returning true.
+func diffLeaves(a, b Trie, start []byte, fn func(key, oldVal, newVal []byte) (bool, error)) error {
+	var (
+		added, _   = trie.NewDifferenceIterator(a.NodeIterator(start), b.NodeIterator(start))
+		removed, _ = trie.NewDifferenceIterator(b.NodeIterator(start), a.NodeIterator(start))
+
+		addIt  = trie.NewIterator(added)
+		remIt  = trie.NewIterator(removed)
+		addOk  = addIt.Next()
+		remOk  = remIt.Next()
+		stop   bool
+		err    error
+		oldVal []byte
+		newVal []byte
+	)
+	for (addOk || remOk) && !stop {
+		var key []byte
+		switch {
+		case !remOk || (addOk && bytes.Compare(addIt.Key, remIt.Key) < 0):
+			key, oldVal, newVal = common.CopyBytes(addIt.Key), nil, common.CopyBytes(addIt.Value)
+			addOk = addIt.Next()
+		case !addOk || bytes.Compare(addIt.Key, remIt.Key) > 0:
+			key, oldVal, newVal = common.CopyBytes(remIt.Key), common.CopyBytes(remIt.Value), nil
+			remOk = remIt.Next()
+		default:
+			key, oldVal, newVal = common.CopyBytes(addIt.Key), common.CopyBytes(remIt.Value), common.CopyBytes(addIt.Value)
+			addOk, remOk = addIt.Next(), remIt.Next()
+		}
+		if stop, err = fn(key, oldVal, newVal); err != nil {
+			return err
+		}
+	}
+	if addIt.Err != nil {
+		return addIt.Err
+	}
+	return remIt.Err
+}
+
+// diffSnapshots computes a page of the differences between two states using the
+// items touched by the snapshot diff layers linking them.
+func diffSnapshots(snaps *snapshot.Tree, changes *snapshot.Changes, from, to common.Hash, start common.Hash, limit int) (*StateDiff, error) {
+	oldSnap, newSnap := snaps.Snapshot(from), snaps.Snapshot(to)
+	if oldSnap == nil || newSnap == nil {
+		return nil, snapshot.ErrSnapshotStale
+	}
+	hashes := make([]common.Hash, 0, len(changes.Accounts))
+	for hash := range changes.Accounts {
+		if bytes.Compare(hash[:], start[:]) >= 0 {
+			hashes = append(hashes, hash)
+		}
+	}
+	sort.Slice(hashes, func(i, j int) bool { return bytes.Compare(hashes[i][:], hashes[j][:]) < 0 })
+
+	diff := new(StateDiff)
+	for _, hash := range hashes {
+		oldAcc, err := oldSnap.Account(hash)
+		if err != nil {
+			return nil, err
+		}
+		newAcc, err := newSnap.Account(hash)
+		if err != nil {
+			return nil, err
+		}
+		account := &AccountDiff{Hash: hash, Old: fullAccount(oldAcc), New: fullAccount(newAcc)}
+		if account.Storage, account.NextSlot, err = diffStorageSnapshots(snaps, changes, oldSnap, newSnap, from, account, common.Hash{}, limit); err != nil {
+			return nil, err
+		}
+		// Skip the accounts touched without being modified
+		if len(account.Storage) == 0 && accountsEqual(account.Old, account.New) {
+			continue
+		}
+		if len(diff.Accounts) >= limit {
+			diff.Next = &account.Hash
+			break
+		}
+		diff.Accounts = append(diff.Accounts, account)
+	}
+	return diff, nil
+}
+
+// diffSnapshotsStorage computes a page of the differences between the storage of
+// an account in two states using the items touched by the snapshot diff layers
+// linking them.
+func diffSnapshotsStorage(snaps *snapshot.Tree, changes *snapshot.Changes, from, to common.Hash, hash common.Hash, start common.Hash, limit int) (*StorageDiff, error) {
+	oldSnap, newSnap := snaps.Snapshot(from), snaps.Snapshot(to)
+	if oldSnap == nil || newSnap == nil {
+		return nil, snapshot.ErrSnapshotStale
+	}
+	diff := new(StorageDiff)
+	if _, ok := changes.Accounts[hash]; !ok {
+		return diff, nil
+	}
+	oldAcc, err := oldSnap.Account(hash)
+	if err != nil {
+		return nil, err
+	}
+	newAcc, err := newSnap.Account(hash)
+	if err != nil {
+		return nil, err
+	}
+	account := &AccountDiff{Hash: hash, Old: fullAccount(oldAcc), New: fullAccount(newAcc)}
+	if diff.Slots, diff.Next, err = diffStorageSnapshots(snaps, changes, oldSnap, newSnap, from, account, start, limit); err != nil {
+		return nil, err
+	}
+	return diff, nil
+}
+
+// diffStorageSnapshots computes a page of the differences between the storage of
+// an account in two snapshots, starting at the given slot hash and containing at
+// most limit slots. The slot hash to continue from is returned if there's more.
+func diffStorageSnapshots(snaps *snapshot.Tree, changes *snapshot.Changes, oldSnap, newSnap snapshot.Snapshot, from common.Hash, account *AccountDiff, start common.Hash, limit int) ([]*SlotDiff, *common.Hash, error) {
+	// Gather the touched slots, including all the old ones if the storage
+	// was wiped in between
+	touched := make(map[common.Hash]struct{}, len(changes.Storage[account.Hash]))
+	for slot := range changes.Storage[account.Hash] {
+		if bytes.Compare(slot[:], start[:]) >= 0 {
+			touched[slot] = struct{}{}
+		}
+	}
+	if _, ok := changes.Destructs[account.Hash]; ok && account.Old != nil && account.Old.Root != emptyRoot {
+		it, err := snaps.StorageIterator(from, account.Hash, start)
+		if err != nil {
+			return nil, nil, err
+		}
+		for it.Next() {
+			touched[it.Hash()] = struct{}{}
+		}
+		err = it.Error()
+		it.Release()
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	hashes := make([]common.Hash, 0, len(touched))
+	for slot := range touched {
+		hashes = append(hashes, slot)
+	}
+	sort.Slice(hashes, func(i, j int) bool { return bytes.Compare(hashes[i][:], hashes[j][:]) < 0 })
+
+	var slots []*SlotDiff
+	for i := range hashes {
+		hash := hashes[i]
+		slot := &SlotDiff{Hash: hash}
+		if account.Old != nil {
+			enc, err := oldSnap.Storage(account.Hash, hash)
+			if err != nil {
+				return nil, nil, err
+			}
+			if slot.Old, err = decodeSlot(enc); err != nil {
+				return nil, nil, err
+			}
+		}
+		if account.New != nil {
+			enc, err := newSnap.Storage(account.Hash, hash)
+			if err != nil {
+				return nil, nil, err
+			}
+			if slot.New, err = decodeSlot(enc); err != nil {
+				return nil, nil, err
+			}
+		}
+		if slot.Old == slot.New {
+			continue
+		}
+		if len(slots) >= limit {
+			return slots, &hash, nil
+		}
+		slots = append(slots, slot)
+	}
+	return slots, nil, nil
+}
+
+// fullAccount converts a slim snapshot account into a consensus one, filling in
+// the omitted empty root and code hash. Nil is returned for missing accounts.
+func fullAccount(acc *snapshot.Account) *types.StateAccount {
+	if acc == nil {
+		return nil
+	}
+	account := &types.StateAccount{
+		Nonce:    acc.Nonce,
+		Balance:  acc.Balance,
+		Root:     common.BytesToHash(acc.Root),
+		CodeHash: acc.CodeHash,
+	}
+	if len(account.CodeHash) == 0 {
+		account.CodeHash = emptyCodeHash
+	}
+	if account.Root == (common.Hash{}) {
+		account.Root = emptyRoot
+	}
+	return account
+}
+
+// decodeAccount decodes an RLP encoded consensus account, nil if empty.
+func decodeAccount(enc []byte) (*types.StateAccount, error) {
+	if len(enc) == 0 {
+		return nil, nil
+	}
+	account := new(types.StateAccount)
+	if err := rlp.DecodeBytes(enc, account); err != nil {
+		return nil, err
+	}
+	return account, nil
+}
+
+// decodeSlot decodes an RLP encoded storage value, zero if empty.
+func decodeSlot(enc []byte) (common.Hash, error) {
+	if len(enc) == 0 {
+		return common.Hash{}, nil
+	}
+	_, content, _, err := rlp.Split(enc)
+	if err != nil {
+		return common.Hash{}, err
+	}
+	return common.BytesToHash(content), nil
+}
+
+// accountsEqual reports whether two accounts, possibly missing, are the same.
+func accountsEqual(a, b *types.StateAccount) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return a.Nonce == b.Nonce && a.Balance.Cmp(b.Balance) == 0 && a.Root == b.Root && bytes.Equal(a.CodeHash, b.CodeHash)
+}
@@ -0,0 +1,214 @@
+// Copyright 2021 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package state
+
+import (
+	"math/big"
+	"reflect"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/core/state/snapshot"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// Tests that the differences between states are the same whether computed from
+// the tries or from the snapshot diff layers, that they cover every change and
+// that the paginated accounts and storage slots add up to the complete diff.
+func TestDiffStates(t *testing.T) {
+	var (
+		diskdb = rawdb.NewMemoryDatabase()
+		db     = NewDatabase(diskdb)
+		addrs  []common.Address
+	)
+	for i := 0; i < 16; i++ {
+		addrs = append(addrs, common.BytesToAddress([]byte{0xaa, byte(i)}))
+	}
+	state, _ := New(common.Hash{}, db, nil)
+	for i, addr := range addrs[:12] {
+		state.SetBalance(addr, big.NewInt(int64(i+1)))
+		for j := 0; j < i; j++ {
+			state.SetState(addr, common.BigToHash(big.NewInt(int64(j))), common.BigToHash(big.NewInt(int64(i*j+1))))
+		}
+	}
+	root, _ := state.Commit(false)
+	if err := db.TrieDB().Commit(root, false, nil); err != nil {
+		t.Fatalf("failed to persist state: %v", err)
+	}
+	snaps, err := snapshot.New(diskdb, db.TrieDB(), 16, root, false, true, false)
+	if err != nil {
+		t.Fatalf("failed to create snapshot: %v", err)
+	}
+	roots := []common.Hash{root}
+
+	// Modify the state over a few blocks, with no-op updates, deletions,
+	// destructions and resurrections sprinkled in
+	for block := 0; block < 3; block++ {
+		state, _ = New(roots[len(roots)-1], db, snaps)
+		for i, addr := range addrs {
+			switch (i + block) % 4 {
+			case 0:
+				state.AddBalance(addr, big.NewInt(int64(block+1)))
+			case 1:
+				state.SetState(addr, common.BigToHash(big.NewInt(int64(block))), common.Hash{})
+				state.SetState(addr, common.BigToHash(big.NewInt(int64(100+block))), common.BigToHash(big.NewInt(int64(i))))
+			case 2:
+				state.SetState(addr, common.Hash{}, state.GetState(addr, common.Hash{}))
+			case 3:
+				state.Suicide(addr)
+			}
+		}
+		state.Finalise(false)
+		if block == 1 {
+			state.SetState(addrs[3], common.Hash{0x01}, common.Hash{0x02})
+		}
+		root, err := state.Commit(false)
+		if err != nil {
+			t.Fatalf("block %d: failed to commit state: %v", block, err)
+		}
+		if err := db.TrieDB().Commit(root, false, nil); err != nil {
+			t.Fatalf("block %d: failed to persist state: %v", block, err)
+		}
+		roots = append(roots, root)
+	}
+	for i := 0; i < len(roots); i++ {
+		for j := i; j < len(roots); j++ {
+			want, err := DiffStates(db, nil, roots[i], roots[j], common.Hash{}, len(addrs))
+			if err != nil {
+				t.Fatalf("diff %d->%d: failed to diff tries: %v", i, j, err)
+			}
+			if want.Next != nil {
+				t.Fatalf("diff %d->%d: incomplete diff", i, j)
+			}
+			if i == j && len(want.Accounts) != 0 {
+				t.Fatalf("diff %d->%d: differences in identical states: %d", i, j, len(want.Accounts))
+			}
+			checkStateDiff(t, db, roots[i], roots[j], want, addrs)
+
+			if i > 0 || j > 0 {
+				changes, err := snaps.Changes(roots[i], roots[j])
+				if err != nil {
+					t.Fatalf("diff %d->%d: failed to collect snapshot changes: %v", i, j, err)
+				}
+				have, err := diffSnapshots(snaps, changes, roots[i], roots[j], common.Hash{}, len(addrs))
+				if err != nil {
+					t.Fatalf("diff %d->%d: failed to diff snapshots: %v", i, j, err)
+				}
+				if !reflect.DeepEqual(have, want) {
+					t.Fatalf("diff %d->%d: snapshot diff mismatch", i, j)
+				}
+			}
+			// Paginated diffs should add up to the complete one
+			for _, snaps := range []*snapshot.Tree{nil, snaps} {
+				var (
+					start common.Hash
+					pages []*AccountDiff
+				)
+				for {
+					page, err := DiffStates(db, snaps, roots[i], roots[j], start, 2)
+					if err != nil {
+						t.Fatalf("diff %d->%d: failed to diff page: %v", i, j, err)
+					}
+					// Complete the truncated storage diffs of the accounts
+					for _, account := range page.Accounts {
+						for account.NextSlot != nil {
+							storage, err := DiffStorage(db, snaps, roots[i], roots[j], account.Hash, *account.NextSlot, 2)
+							if err != nil {
+								t.Fatalf("diff %d->%d: failed to diff storage page: %v", i, j, err)
+							}
+							if len(storage.Slots) == 0 {
+								t.Fatalf("diff %d->%d: empty storage page", i, j)
+							}
+							account.Storage, account.NextSlot = append(account.Storage, storage.Slots...), storage.Next
+						}
+					}
+					pages = append(pages, page.Accounts...)
+					if page.Next == nil {
+						break
+					}
+					start = *page.Next
+				}
+				if len(pages) != len(want.Accounts) || (len(pages) > 0 && !reflect.DeepEqual(pages, want.Accounts)) {
+					t.Fatalf("diff %d->%d: paginated diff mismatch, snapshot %v", i, j, snaps != nil)
+				}
+			}
+		}
+	}
+	// Snapshots not linked by diff layers should be rejected
+	if _, err := snaps.Changes(roots[2], roots[1]); err == nil {
+		t.Fatalf("collected changes of descendant")
+	}
+}
+
+// testDiffKeys returns the regular storage keys used by the state diff tests.
+func testDiffKeys() []common.Hash {
+	var keys []common.Hash
+	for i := 0; i < 16; i++ {
+		keys = append(keys, common.BigToHash(big.NewInt(int64(i))), common.BigToHash(big.NewInt(int64(100+i))))
+	}
+	return keys
+}
+
+// checkStateDiff verifies a state diff against the states it was computed from.
+func checkStateDiff(t *testing.T, db Database, from, to common.Hash, diff *StateDiff, addrs []common.Address) {
+	t.Helper()
+
+	oldState, _ := New(from, db, nil)
+	newState, _ := New(to, db, nil)
+
+	keys := make(map[common.Hash]common.Hash)
+	for _, key := range append(testDiffKeys(), common.Hash{0x01}) {
+		keys[crypto.Keccak256Hash(key[:])] = key
+	}
+	changed := make(map[common.Hash]*AccountDiff)
+	for _, account := range diff.Accounts {
+		changed[account.Hash] = account
+	}
+	for _, addr := range addrs {
+		account := changed[crypto.Keccak256Hash(addr[:])]
+		if oldState.Exist(addr) != newState.Exist(addr) ||
+			oldState.GetBalance(addr).Cmp(newState.GetBalance(addr)) != 0 ||
+			oldState.GetNonce(addr) != newState.GetNonce(addr) ||
+			oldState.StorageTrie(addr) != nil && newState.StorageTrie(addr) != nil && oldState.StorageTrie(addr).Hash() != newState.StorageTrie(addr).Hash() {
+			if account == nil {
+				t.Fatalf("account %x: change missing", addr)
+			}
+		}
+		if account == nil {
+			continue
+		}
+		if (account.Old != nil) != oldState.Exist(addr) || (account.New != nil) != newState.Exist(addr) {
+			t.Fatalf("account %x: existence mismatch", addr)
+		}
+		for _, slot := range account.Storage {
+			if slot.Old == slot.New {
+				t.Fatalf("account %x slot %x: unchanged slot reported", addr, slot.Hash)
+			}
+			key, ok := keys[slot.Hash]
+			if !ok {
+				t.Fatalf("account %x slot %x: unknown slot reported", addr, slot.Hash)
+			}
+			if old := oldState.GetState(addr, key); slot.Old != old {
+				t.Fatalf("account %x slot %x: old value mismatch: have %x, want %x", addr, key, slot.Old, old)
+			}
+			if new := newState.GetState(addr, key); slot.New != new {
+				t.Fatalf("account %x slot %x: new value mismatch: have %x, want %x", addr, key, slot.New, new)
+			}
+		}
+	}
+}
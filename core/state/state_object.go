@@ -62,7 +62,7 @@ func (s Storage) Copy() Storage {
 // The usage pattern is as follows:
 // First you need to obtain a state object.
 // Account values can be accessed and modified through the object.
-// Finally, call CommitTrie to write the modified storage trie into a database.
+// Finally, call commitTrie to write the modified storage trie into a database.
 type stateObject struct {
 	address  common.Address
 	addrHash common.Hash // hash of ethereum address of the account
@@ -154,7 +154,7 @@ func (s *stateObject) getTrie(db Database) Trie {
 		if s.data.Root != emptyRoot && s.db.prefetcher != nil {
 			// When the miner is creating the pending state, there is no
 			// prefetcher
-			s.trie = s.db.prefetcher.trie(s.data.Root)
+			s.trie = s.db.prefetcher.trie(s.addrHash, s.data.Root)
 		}
 		if s.trie == nil {
 			var err error
@@ -310,7 +310,7 @@ func (s *stateObject) finalise(prefetch bool) {
 		}
 	}
 	if s.db.prefetcher != nil && prefetch && len(slotsToPrefetch) > 0 && s.data.Root != emptyRoot {
-		s.db.prefetcher.prefetch(s.data.Root, slotsToPrefetch)
+		s.db.prefetcher.prefetch(s.addrHash, s.data.Root, slotsToPrefetch)
 	}
 	if len(s.dirtyStorage) > 0 {
 		s.dirtyStorage = make(Storage)
@@ -320,21 +320,40 @@ func (s *stateObject) finalise(prefetch bool) {
 // updateTrie writes cached storage modifications into the object's storage trie.
 // It will return nil if the trie has not been loaded and no changes have been made
 func (s *stateObject) updateTrie(db Database) Trie {
-	// Make sure all dirty slots are finalized into the pending storage area
-	s.finalise(false) // Don't prefetch anymore, pull directly if need be
-	if len(s.pendingStorage) == 0 {
-		return s.trie
-	}
 	// Track the amount of time wasted on updating the storage trie
 	if metrics.EnabledExpensive {
 		defer func(start time.Time) { s.db.StorageUpdates += time.Since(start) }(time.Now())
 	}
+	tr, updates := s.prepareTrie(db)
+	s.writeTrie(tr, updates)
+	return tr
+}
+
+// slotUpdate is a modified storage slot to be written into the trie.
+type slotUpdate struct {
+	key   common.Hash
+	value []byte // Trie encoded value, nil if the slot was deleted
+}
+
+// prepareTrie finalizes the pending storage modifications, doing all their
+// bookkeeping in the state, and retrieves the storage trie to write them into.
+// The returned updates are applied with writeTrie, which touches nothing but
+// the object itself, so the tries of multiple objects can be written in parallel.
+//
+// The trie is nil if it has not been loaded and no changes have been made.
+func (s *stateObject) prepareTrie(db Database) (Trie, []slotUpdate) {
+	// Make sure all dirty slots are finalized into the pending storage area
+	s.finalise(false) // Don't prefetch anymore, pull directly if need be
+	if len(s.pendingStorage) == 0 {
+		return s.trie, nil
+	}
 	// The snapshot storage map for the object
 	var storage map[common.Hash][]byte
-	// Insert all the pending updates into the trie
+	// Retrieve the trie to insert all the pending updates into
 	tr := s.getTrie(db)
 	hasher := s.db.hasher
 
+	updates := make([]slotUpdate, 0, len(s.pendingStorage))
 	usedStorage := make([][]byte, 0, len(s.pendingStorage))
 	for key, value := range s.pendingStorage {
 		// Skip noop changes, persist actual changes
@@ -342,17 +361,18 @@ func (s *stateObject) updateTrie(db Database) Trie {
 			continue
 		}
 		s.originStorage[key] = value
+		s.db.trackSlotChange(s.addrHash, key)
 
 		var v []byte
 		if (value == common.Hash{}) {
-			s.setError(tr.TryDelete(key[:]))
 			s.db.StorageDeleted += 1
 		} else {
 			// Encoding []byte cannot fail, ok to ignore the error.
 			v, _ = rlp.EncodeToBytes(common.TrimLeftZeroes(value[:]))
-			s.setError(tr.TryUpdate(key[:], v))
 			s.db.StorageUpdated += 1
 		}
+		updates = append(updates, slotUpdate{key: key, value: v})
+
 		// If state snapshotting is active, cache the data til commit
 		if s.db.snap != nil {
 			if storage == nil {
@@ -367,41 +387,33 @@ func (s *stateObject) updateTrie(db Database) Trie {
 		usedStorage = append(usedStorage, common.CopyBytes(key[:])) // Copy needed for closure
 	}
 	if s.db.prefetcher != nil {
-		s.db.prefetcher.used(s.data.Root, usedStorage)
+		s.db.prefetcher.used(s.addrHash, s.data.Root, usedStorage)
 	}
 	if len(s.pendingStorage) > 0 {
 		s.pendingStorage = make(Storage)
 	}
-	return tr
+	return tr, updates
 }
 
-// UpdateRoot sets the trie root to the current root hash of
-func (s *stateObject) updateRoot(db Database) {
-	// If nothing changed, don't bother with hashing anything
-	if s.updateTrie(db) == nil {
-		return
-	}
-	// Track the amount of time wasted on hashing the storage trie
-	if metrics.EnabledExpensive {
-		defer func(start time.Time) { s.db.StorageHashes += time.Since(start) }(time.Now())
+// writeTrie inserts the prepared storage updates into the storage trie.
+func (s *stateObject) writeTrie(tr Trie, updates []slotUpdate) {
+	for _, update := range updates {
+		if update.value == nil {
+			s.setError(tr.TryDelete(update.key[:]))
+		} else {
+			s.setError(tr.TryUpdate(update.key[:], update.value))
+		}
 	}
-	s.data.Root = s.trie.Hash()
 }
 
-// CommitTrie the storage trie of the object to db.
-// This updates the trie root.
-func (s *stateObject) CommitTrie(db Database) (int, error) {
-	// If nothing changed, don't bother with hashing anything
-	if s.updateTrie(db) == nil {
-		return 0, nil
-	}
+// commitTrie commits the storage trie of the object to its database, updating
+// the trie root. All the storage updates must have been written into the trie
+// already, in which case only the object itself is touched, so the tries of
+// multiple objects can be committed in parallel.
+func (s *stateObject) commitTrie() (int, error) {
 	if s.dbErr != nil {
 		return 0, s.dbErr
 	}
-	// Track the amount of time wasted on committing the storage trie
-	if metrics.EnabledExpensive {
-		defer func(start time.Time) { s.db.StorageCommits += time.Since(start) }(time.Now())
-	}
 	root, committed, err := s.trie.Commit(nil)
 	if err == nil {
 		s.data.Root = root
@@ -74,6 +74,18 @@ type StateDB struct {
 	snapAccounts  map[common.Hash][]byte
 	snapStorage   map[common.Hash]map[common.Hash][]byte
 
+	// Reverse diff tracking, only enabled on request. The modified storage slots
+	// and the wiped storages are tracked by the hash of the account address.
+	diffStorage map[common.Hash]map[common.Hash]struct{}
+	diffWiped   map[common.Hash]struct{}
+	diff        *ReverseDiff
+
+	// Flat diff tracking for the archive, only enabled on request
+	flatTracked bool
+	flat        *FlatDiff
+
+	archived bool // Whether the state is served from the archive, without tries
+
 	// This map holds 'live' objects, which will get modified while processing a state transition.
 	stateObjects        map[common.Address]*stateObject
 	stateObjectsPending map[common.Address]struct{} // State objects finalized but not yet written to the trie
@@ -320,6 +332,9 @@ func (s *StateDB) GetProof(addr common.Address) ([][]byte, error) {
 
 // GetProofByHash returns the Merkle proof for a given account.
 func (s *StateDB) GetProofByHash(addrHash common.Hash) ([][]byte, error) {
+	if s.archived {
+		return nil, errArchivedProof
+	}
 	var proof proofList
 	err := s.trie.Prove(addrHash[:], 0, &proof)
 	return proof, err
@@ -327,6 +342,9 @@ func (s *StateDB) GetProofByHash(addrHash common.Hash) ([][]byte, error) {
 
 // GetStorageProof returns the Merkle proof for given storage slot.
 func (s *StateDB) GetStorageProof(a common.Address, key common.Hash) ([][]byte, error) {
+	if s.archived {
+		return nil, errArchivedProof
+	}
 	var proof proofList
 	trie := s.StorageTrie(a)
 	if trie == nil {
@@ -583,6 +601,9 @@ func (s *StateDB) createObject(addr common.Address) (newobj, prev *stateObject)
 			s.snapDestructs[prev.addrHash] = struct{}{}
 		}
 	}
+	if prev != nil {
+		s.trackStorageWipe(prev.addrHash)
+	}
 	newobj = newObject(s, addr, types.StateAccount{})
 	if prev == nil {
 		s.journal.append(createObjectChange{account: &addr})
@@ -714,7 +735,7 @@ func (s *StateDB) Copy() *StateDB {
 	if s.prefetcher != nil {
 		state.prefetcher = s.prefetcher.copy()
 	}
-	if s.snaps != nil {
+	if s.snaps != nil || s.archived {
 		// In order for the miner to be able to use and make additions
 		// to the snapshot tree, we need to copy that aswell.
 		// Otherwise, any block mined by ourselves will cause gaps in the tree,
@@ -739,6 +760,23 @@ func (s *StateDB) Copy() *StateDB {
 			state.snapStorage[k] = temp
 		}
 	}
+	state.flatTracked = s.flatTracked
+	state.archived = s.archived
+
+	if s.diffStorage != nil {
+		state.diffStorage = make(map[common.Hash]map[common.Hash]struct{}, len(s.diffStorage))
+		for k, v := range s.diffStorage {
+			temp := make(map[common.Hash]struct{}, len(v))
+			for kk := range v {
+				temp[kk] = struct{}{}
+			}
+			state.diffStorage[k] = temp
+		}
+		state.diffWiped = make(map[common.Hash]struct{}, len(s.diffWiped))
+		for k := range s.diffWiped {
+			state.diffWiped[k] = struct{}{}
+		}
+	}
 	return state
 }
 
@@ -789,6 +827,7 @@ func (s *StateDB) Finalise(deleteEmptyObjects bool) {
 		}
 		if obj.suicided || (deleteEmptyObjects && obj.empty()) {
 			obj.deleted = true
+			s.trackStorageWipe(obj.addrHash)
 
 			// If state snapshotting is active, also mark the destruction there.
 			// Note, we can't do this only at the end of a block because multiple
@@ -811,7 +850,7 @@ func (s *StateDB) Finalise(deleteEmptyObjects bool) {
 		addressesToPrefetch = append(addressesToPrefetch, common.CopyBytes(addr[:])) // Copy needed for closure
 	}
 	if s.prefetcher != nil && len(addressesToPrefetch) > 0 {
-		s.prefetcher.prefetch(s.originalRoot, addressesToPrefetch)
+		s.prefetcher.prefetch(common.Hash{}, s.originalRoot, addressesToPrefetch)
 	}
 	// Invalidate journal because reverting across transactions is not allowed.
 	s.clearJournalAndRefund()
@@ -820,9 +859,16 @@ func (s *StateDB) Finalise(deleteEmptyObjects bool) {
 // IntermediateRoot computes the current root hash of the state trie.
 // It is called in between transactions to get the root hash that
 // goes into transaction receipts.
+//
+// States opened from the archive have no tries to hash, they are finalised but
+// return an empty root, recording the failure in the state's database error.
 func (s *StateDB) IntermediateRoot(deleteEmptyObjects bool) common.Hash {
 	// Finalise all the dirty storage states and write them into the tries
 	s.Finalise(deleteEmptyObjects)
+	if s.archived {
+		s.setError(errArchivedCommit)
+		return common.Hash{}
+	}
 
 	// If there was a trie prefetcher operating, it gets aborted and irrevocably
 	// modified after we start retrieving tries. Remove it from the statedb after
@@ -843,16 +889,13 @@ func (s *StateDB) IntermediateRoot(deleteEmptyObjects bool) common.Hash {
 	// the account prefetcher. Instead, let's process all the storage updates
 	// first, giving the account prefeches just a few more milliseconds of time
 	// to pull useful data from disk.
-	for addr := range s.stateObjectsPending {
-		if obj := s.stateObjects[addr]; !obj.deleted {
-			obj.updateRoot(s.db)
-		}
-	}
+	s.updateStorageRoots()
+
 	// Now we're about to start to write changes to the trie. The trie is so far
 	// _untouched_. We can check with the prefetcher, if it can give us a trie
 	// which has the same root, but also has some content loaded into it.
 	if prefetcher != nil {
-		if trie := prefetcher.trie(s.originalRoot); trie != nil {
+		if trie := prefetcher.trie(common.Hash{}, s.originalRoot); trie != nil {
 			s.trie = trie
 		}
 	}
@@ -868,7 +911,7 @@ func (s *StateDB) IntermediateRoot(deleteEmptyObjects bool) common.Hash {
 		usedAddrs = append(usedAddrs, common.CopyBytes(addr[:])) // Copy needed for closure
 	}
 	if prefetcher != nil {
-		prefetcher.used(s.originalRoot, usedAddrs)
+		prefetcher.used(common.Hash{}, s.originalRoot, usedAddrs)
 	}
 	if len(s.stateObjectsPending) > 0 {
 		s.stateObjectsPending = make(map[common.Address]struct{})
@@ -901,11 +944,23 @@ func (s *StateDB) Commit(deleteEmptyObjects bool) (common.Hash, error) {
 	if s.dbErr != nil {
 		return common.Hash{}, fmt.Errorf("commit aborted due to earlier error: %v", s.dbErr)
 	}
+	if s.archived {
+		return common.Hash{}, errArchivedCommit
+	}
 	// Finalize any pending changes and merge everything into the tries
 	s.IntermediateRoot(deleteEmptyObjects)
 
+	// If reverse diffs are tracked, gather the original values of the modified
+	// accounts and slots before the pre-state gets overwritten
+	var diffAccounts []ReverseDiffAccount
+	if s.diffStorage != nil {
+		var err error
+		if diffAccounts, err = s.reverseDiffAccounts(s.stateObjectsDirty); err != nil {
+			return common.Hash{}, err
+		}
+	}
 	// Commit objects to the trie, measuring the elapsed time
-	var storageCommitted int
+	var objs []*stateObject
 	codeWriter := s.db.TrieDB().DiskDB().NewBatch()
 	for addr := range s.stateObjectsDirty {
 		if obj := s.stateObjects[addr]; !obj.deleted {
@@ -914,14 +969,17 @@ func (s *StateDB) Commit(deleteEmptyObjects bool) (common.Hash, error) {
 				rawdb.WriteCode(codeWriter, common.BytesToHash(obj.CodeHash()), obj.code)
 				obj.dirtyCode = false
 			}
-			// Write any storage changes in the state object to its storage trie
-			committed, err := obj.CommitTrie(s.db)
-			if err != nil {
-				return common.Hash{}, err
+			// Write any storage changes in the state object to its storage trie,
+			// committing the modified ones concurrently afterwards
+			if obj.updateTrie(s.db) != nil {
+				objs = append(objs, obj)
 			}
-			storageCommitted += committed
 		}
 	}
+	storageCommitted, err := s.commitStorageTries(objs)
+	if err != nil {
+		return common.Hash{}, err
+	}
 	if len(s.stateObjectsDirty) > 0 {
 		s.stateObjectsDirty = make(map[common.Address]struct{})
 	}
@@ -950,6 +1008,18 @@ func (s *StateDB) Commit(deleteEmptyObjects bool) (common.Hash, error) {
 	if err != nil {
 		return common.Hash{}, err
 	}
+	// Attach the committed trie nodes to the new state, required by the path
+	// based scheme to track them as a state transition
+	if err := s.db.TrieDB().Update(root, s.originalRoot); err != nil {
+		return common.Hash{}, err
+	}
+	if s.diffStorage != nil {
+		s.diff = &ReverseDiff{Parent: s.originalRoot, Root: root, Accounts: diffAccounts}
+		s.diffStorage = make(map[common.Hash]map[common.Hash]struct{})
+		s.diffWiped = make(map[common.Hash]struct{})
+	}
+	s.originalRoot = root
+
 	if metrics.EnabledExpensive {
 		s.AccountCommits += time.Since(start)
 
@@ -963,10 +1033,14 @@ func (s *StateDB) Commit(deleteEmptyObjects bool) (common.Hash, error) {
 		s.StorageUpdated, s.StorageDeleted = 0, 0
 	}
 	// If snapshotting is enabled, update the snapshot tree with this new version
+	s.flat = nil
 	if s.snap != nil {
 		if metrics.EnabledExpensive {
 			defer func(start time.Time) { s.SnapshotCommits += time.Since(start) }(time.Now())
 		}
+		if s.flatTracked {
+			s.flat = newFlatDiff(s.snapDestructs, s.snapAccounts, s.snapStorage)
+		}
 		// Only update if there's a state transition (skip empty Clique blocks)
 		if parent := s.snap.Root(); parent != root {
 			if err := s.snaps.Update(root, parent, s.snapDestructs, s.snapAccounts, s.snapStorage); err != nil {
@@ -0,0 +1,119 @@
+// Copyright 2021 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package state
+
+import (
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/ethereum/go-ethereum/metrics"
+)
+
+// runStorageTasks runs the given number of tasks on a pool of workers bounded by
+// GOMAXPROCS, returning when all of them are done. With a single worker, the
+// tasks are run inline.
+func runStorageTasks(tasks int, run func(task int)) {
+	workers := runtime.GOMAXPROCS(0)
+	if workers > tasks {
+		workers = tasks
+	}
+	if workers <= 1 {
+		for i := 0; i < tasks; i++ {
+			run(i)
+		}
+		return
+	}
+	var (
+		next int64 = -1
+		wg   sync.WaitGroup
+	)
+	wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go func() {
+			defer wg.Done()
+			for {
+				task := int(atomic.AddInt64(&next, 1))
+				if task >= tasks {
+					return
+				}
+				run(task)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// updateStorageRoots writes the pending storage updates of the live objects into
+// their storage tries and rehashes them. The bookkeeping of the updates and the
+// retrieval of the tries, picking up the ones preloaded by the prefetcher, are
+// done sequentially. The tries are then updated and hashed concurrently, each of
+// them by a single worker, so the resulting roots are deterministic.
+func (s *StateDB) updateStorageRoots() {
+	var (
+		objs    []*stateObject
+		tries   []Trie
+		updates [][]slotUpdate
+		start   = time.Now()
+	)
+	for addr := range s.stateObjectsPending {
+		obj := s.stateObjects[addr]
+		if obj.deleted {
+			continue
+		}
+		// If the trie was never loaded and nothing changed, there's nothing to hash
+		tr, slots := obj.prepareTrie(s.db)
+		if tr == nil {
+			continue
+		}
+		objs, tries, updates = append(objs, obj), append(tries, tr), append(updates, slots)
+	}
+	if metrics.EnabledExpensive {
+		s.StorageUpdates += time.Since(start)
+		defer func(start time.Time) { s.StorageHashes += time.Since(start) }(time.Now())
+	}
+	runStorageTasks(len(objs), func(i int) {
+		objs[i].writeTrie(tries[i], updates[i])
+		objs[i].data.Root = tries[i].Hash()
+	})
+}
+
+// commitStorageTries commits the storage tries of the given objects, with all
+// their updates already written, concurrently. The number of committed nodes is
+// returned, along with the first error encountered.
+func (s *StateDB) commitStorageTries(objs []*stateObject) (int, error) {
+	if metrics.EnabledExpensive {
+		defer func(start time.Time) { s.StorageCommits += time.Since(start) }(time.Now())
+	}
+	var (
+		committed int
+		failure   error
+		lock      sync.Mutex
+	)
+	runStorageTasks(len(objs), func(i int) {
+		n, err := objs[i].commitTrie()
+
+		lock.Lock()
+		defer lock.Unlock()
+		committed += n
+		if err != nil && failure == nil {
+			failure = err
+		}
+	})
+	return committed, failure
+}
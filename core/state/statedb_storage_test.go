@@ -0,0 +1,84 @@
+// Copyright 2021 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package state
+
+import (
+	"math/big"
+	"runtime"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+)
+
+// storageTestRoots modifies the storage of many accounts over a few blocks,
+// returning the intermediate and committed roots of every block.
+func storageTestRoots(t *testing.T) []common.Hash {
+	db := NewDatabase(rawdb.NewMemoryDatabase())
+	state, _ := New(common.Hash{}, db, nil)
+
+	var roots []common.Hash
+	for block := 0; block < 4; block++ {
+		for i := 0; i < 128; i++ {
+			addr := common.BytesToAddress([]byte{byte(i), 0xff})
+			state.AddBalance(addr, big.NewInt(1))
+			for j := 0; j < 32; j++ {
+				var value common.Hash
+				if (i+j+block)%5 != 0 { // Delete some of the slots in each block
+					value = common.BigToHash(big.NewInt(int64(block*1000 + i*j + 1)))
+				}
+				state.SetState(addr, common.BigToHash(big.NewInt(int64(j*(block+1)))), value)
+			}
+			if block == 2 && i%16 == 0 {
+				state.Suicide(addr)
+			}
+		}
+		roots = append(roots, state.IntermediateRoot(false))
+
+		root, err := state.Commit(false)
+		if err != nil {
+			t.Fatalf("block %d: failed to commit state: %v", block, err)
+		}
+		if err := state.Database().TrieDB().Commit(root, false, nil); err != nil {
+			t.Fatalf("block %d: failed to flush state: %v", block, err)
+		}
+		if state, err = New(root, db, nil); err != nil {
+			t.Fatalf("block %d: failed to reopen state: %v", block, err)
+		}
+		roots = append(roots, root)
+	}
+	return roots
+}
+
+// Tests that updating, hashing and committing storage tries concurrently gives
+// the same results as doing it sequentially.
+func TestParallelStorageRoots(t *testing.T) {
+	defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(0))
+
+	runtime.GOMAXPROCS(1)
+	want := storageTestRoots(t)
+
+	for _, procs := range []int{2, 8, 32} {
+		runtime.GOMAXPROCS(procs)
+		have := storageTestRoots(t)
+		for i := range want {
+			if have[i] != want[i] {
+				t.Fatalf("procs %d, root %d: mismatch: have %x, want %x", procs, i, have[i], want[i])
+			}
+		}
+	}
+}
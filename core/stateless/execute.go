@@ -0,0 +1,145 @@
+// Copyright 2021 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package stateless
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/consensus"
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/core/state"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/params"
+	"github.com/ethereum/go-ethereum/trie"
+)
+
+// errMissingParent is returned if a witness doesn't contain the header of the
+// parent of the executed block.
+var errMissingParent = errors.New("witness misses parent header")
+
+// Execute runs a block using only the data contained in its witness, verifying
+// the gas used, the receipts and the post state root against the block header.
+// A witness missing any data accessed during execution fails the execution.
+func Execute(config *params.ChainConfig, engine consensus.Engine, block *types.Block, witness *Witness) (types.Receipts, error) {
+	// Assemble the ancestor headers, their hashes are authenticated by the
+	// parent hash links walked during the lookups
+	chain := &witnessChain{
+		config:  config,
+		engine:  engine,
+		headers: make(map[common.Hash]*types.Header, len(witness.Headers)),
+	}
+	for _, header := range witness.Headers {
+		chain.headers[header.Hash()] = header
+	}
+	parent := chain.GetHeader(block.ParentHash(), block.NumberU64()-1)
+	if parent == nil {
+		return nil, errMissingParent
+	}
+	chain.parent = parent
+
+	// Load the trie nodes and codes into an in-memory database, keyed by their
+	// hashes so that only the authentic ones can be ever resolved
+	db := rawdb.NewMemoryDatabase()
+	for _, node := range witness.State {
+		rawdb.WriteTrieNode(db, crypto.Keccak256Hash(node), node)
+	}
+	for _, code := range witness.Codes {
+		rawdb.WriteCode(db, crypto.Keccak256Hash(code), code)
+	}
+	statedb, err := state.New(parent.Root, state.NewDatabase(db), nil)
+	if err != nil {
+		return nil, err
+	}
+	processor := core.NewStateProcessor(config, chain, engine)
+	receipts, _, usedGas, err := processor.Process(block, statedb, vm.Config{})
+	if err != nil {
+		// Report missing witness data instead of the failures it caused
+		if dbErr := statedb.Error(); dbErr != nil {
+			return nil, dbErr
+		}
+		return nil, err
+	}
+	// Hash the post state before checking for missing witness data, as the trie
+	// updates might need further nodes
+	root := statedb.IntermediateRoot(config.IsEIP158(block.Number()))
+	if err := statedb.Error(); err != nil {
+		return nil, err
+	}
+	if block.GasUsed() != usedGas {
+		return nil, fmt.Errorf("invalid gas used (remote: %d local: %d)", block.GasUsed(), usedGas)
+	}
+	if hash := types.DeriveSha(receipts, trie.NewStackTrie(nil)); hash != block.ReceiptHash() {
+		return nil, fmt.Errorf("invalid receipt root hash (remote: %x local: %x)", block.ReceiptHash(), hash)
+	}
+	if root != block.Root() {
+		return nil, fmt.Errorf("invalid merkle root (remote: %x local: %x)", block.Root(), root)
+	}
+	return receipts, nil
+}
+
+// witnessChain is a chain serving the headers contained in a witness.
+type witnessChain struct {
+	config  *params.ChainConfig
+	engine  consensus.Engine
+	parent  *types.Header
+	headers map[common.Hash]*types.Header
+}
+
+// Config retrieves the chain configuration.
+func (c *witnessChain) Config() *params.ChainConfig { return c.config }
+
+// Engine retrieves the consensus engine.
+func (c *witnessChain) Engine() consensus.Engine { return c.engine }
+
+// CurrentHeader retrieves the parent of the executed block.
+func (c *witnessChain) CurrentHeader() *types.Header { return c.parent }
+
+// GetHeader retrieves a header by hash and number.
+func (c *witnessChain) GetHeader(hash common.Hash, number uint64) *types.Header {
+	if header := c.headers[hash]; header != nil && header.Number.Uint64() == number {
+		return header
+	}
+	return nil
+}
+
+// GetHeaderByHash retrieves a header by hash.
+func (c *witnessChain) GetHeaderByHash(hash common.Hash) *types.Header {
+	return c.headers[hash]
+}
+
+// GetHeaderByNumber retrieves a header by number, walking the parent links from
+// the parent of the executed block.
+func (c *witnessChain) GetHeaderByNumber(number uint64) *types.Header {
+	header := c.parent
+	for header != nil && header.Number.Uint64() > number {
+		header = c.GetHeader(header.ParentHash, header.Number.Uint64()-1)
+	}
+	if header == nil || header.Number.Uint64() != number {
+		return nil
+	}
+	return header
+}
+
+// GetTd retrieves the total difficulty of a header. Witnesses don't carry total
+// difficulties, so none are ever available.
+func (c *witnessChain) GetTd(hash common.Hash, number uint64) *big.Int { return nil }
@@ -0,0 +1,185 @@
+// Copyright 2021 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package stateless
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/consensus/ethash"
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/params"
+	"github.com/ethereum/go-ethereum/rlp"
+)
+
+var (
+	testKey, _  = crypto.HexToECDSA("b71c71a67e1177ad4e901695e1b4b9ee17ae16c6668d313eac2f96dbcda3f291")
+	testAddress = crypto.PubkeyToAddress(testKey.PublicKey)
+
+	// testContract stores the hash of the block three blocks back into slot
+	// number%3 and the code size of 0xaa into slot 0x10 when called without
+	// data, and self destructs otherwise.
+	testContract = common.HexToAddress("0xcc")
+	testCode     = common.FromHex("0x36601557" + "6003430340" + "6003430655" + "60aa3b601055" + "00" + "5b33ff")
+)
+
+// newTestChain creates a chain of blocks calling the test contract, moving funds
+// around and self destructing the contract at the given block.
+func newTestChain(t *testing.T, scheme string, n int, destruct int) (*core.BlockChain, []*types.Block) {
+	t.Helper()
+
+	genesis := &core.Genesis{
+		Config:  params.TestChainConfig,
+		BaseFee: big.NewInt(params.InitialBaseFee),
+		Alloc: core.GenesisAlloc{
+			testAddress:          {Balance: big.NewInt(1000000000000000000)},
+			testContract:         {Balance: big.NewInt(1), Code: testCode},
+			common.Address{0xaa}: {Balance: big.NewInt(1), Code: []byte{0x60, 0x00}, Storage: map[common.Hash]common.Hash{{}: common.HexToHash("0xff")}},
+		},
+	}
+	// Generate the blocks one by one on top of an archive chain, which serves
+	// the ancestor headers for BLOCKHASH
+	var (
+		signer = types.LatestSigner(params.TestChainConfig)
+		gendb  = rawdb.NewMemoryDatabase()
+		parent = genesis.MustCommit(gendb)
+		blocks []*types.Block
+	)
+	genchain, err := core.NewBlockChain(gendb, &core.CacheConfig{TrieDirtyDisabled: true}, params.TestChainConfig, ethash.NewFaker(), vm.Config{}, nil, nil)
+	if err != nil {
+		t.Fatalf("failed to create generator chain: %v", err)
+	}
+	defer genchain.Stop()
+
+	for i := 0; i < n; i++ {
+		next, _ := core.GenerateChain(params.TestChainConfig, parent, ethash.NewFaker(), gendb, 1, func(_ int, b *core.BlockGen) {
+			b.SetCoinbase(common.Address{0x01})
+
+			var data []byte
+			if i == destruct {
+				data = []byte{0x01}
+			}
+			tx, _ := types.SignTx(types.NewTransaction(b.TxNonce(testAddress), testContract, nil, 100000, b.BaseFee(), data), signer, testKey)
+			b.AddTxWithChain(genchain, tx)
+			tx, _ = types.SignTx(types.NewTransaction(b.TxNonce(testAddress), common.Address{0x02, byte(i % 4)}, big.NewInt(int64(i+1)), params.TxGas, b.BaseFee(), nil), signer, testKey)
+			b.AddTxWithChain(genchain, tx)
+		})
+		if _, err := genchain.InsertChain(next); err != nil {
+			t.Fatalf("failed to insert generated block: %v", err)
+		}
+		parent = next[0]
+		blocks = append(blocks, parent)
+	}
+	db := rawdb.NewMemoryDatabase()
+	if scheme == rawdb.PathScheme {
+		rawdb.WriteTrieScheme(db, rawdb.PathScheme)
+	}
+	genesis.MustCommit(db)
+
+	config := &core.CacheConfig{
+		TrieCleanLimit: 256,
+		TrieDirtyLimit: 256,
+		StateScheme:    scheme,
+	}
+	chain, err := core.NewBlockChain(db, config, params.TestChainConfig, ethash.NewFaker(), vm.Config{}, nil, nil)
+	if err != nil {
+		t.Fatalf("failed to create chain: %v", err)
+	}
+	if _, err := chain.InsertChain(blocks); err != nil {
+		t.Fatalf("failed to insert chain: %v", err)
+	}
+	return chain, blocks
+}
+
+// Tests that the witnesses of blocks are enough to execute them statelessly,
+// after going through an encoding round trip.
+func TestExecuteWitness(t *testing.T) {
+	for _, scheme := range []string{rawdb.HashScheme, rawdb.PathScheme} {
+		chain, blocks := newTestChain(t, scheme, 8, 5)
+		for _, block := range blocks {
+			witness, err := Generate(chain, block)
+			if err != nil {
+				t.Fatalf("%s, block #%d: failed to generate witness: %v", scheme, block.NumberU64(), err)
+			}
+			enc, err := rlp.EncodeToBytes(witness)
+			if err != nil {
+				t.Fatalf("%s, block #%d: failed to encode witness: %v", scheme, block.NumberU64(), err)
+			}
+			dec := new(Witness)
+			if err := rlp.DecodeBytes(enc, dec); err != nil {
+				t.Fatalf("%s, block #%d: failed to decode witness: %v", scheme, block.NumberU64(), err)
+			}
+			receipts, err := Execute(params.TestChainConfig, ethash.NewFaker(), block, dec)
+			if err != nil {
+				t.Fatalf("%s, block #%d: failed to execute block: %v", scheme, block.NumberU64(), err)
+			}
+			if len(receipts) != len(block.Transactions()) {
+				t.Fatalf("%s, block #%d: receipt count mismatch: have %d, want %d", scheme, block.NumberU64(), len(receipts), len(block.Transactions()))
+			}
+		}
+		chain.Stop()
+	}
+}
+
+// Tests that stateless execution fails if any part of the witness is missing.
+func TestExecuteIncompleteWitness(t *testing.T) {
+	chain, blocks := newTestChain(t, rawdb.HashScheme, 8, 5)
+	defer chain.Stop()
+
+	for _, block := range blocks[2:] {
+		witness, err := Generate(chain, block)
+		if err != nil {
+			t.Fatalf("block #%d: failed to generate witness: %v", block.NumberU64(), err)
+		}
+		// The hash of an ancestor is stored by the contract until it's destructed
+		// in block #6, so the ancestor headers are needed besides the parent
+		incomplete := *witness
+		if block.NumberU64() < 6 {
+			if len(witness.Headers) < 2 {
+				t.Fatalf("block #%d: ancestor headers missing: have %d", block.NumberU64(), len(witness.Headers))
+			}
+			incomplete.Headers = witness.Headers[:1]
+			if _, err := Execute(params.TestChainConfig, ethash.NewFaker(), block, &incomplete); err == nil {
+				t.Fatalf("block #%d: execution succeeded without ancestor headers", block.NumberU64())
+			}
+		}
+		incomplete = *witness
+		incomplete.Headers = witness.Headers[1:]
+		if _, err := Execute(params.TestChainConfig, ethash.NewFaker(), block, &incomplete); err != errMissingParent {
+			t.Fatalf("block #%d: execution error mismatch without parent header: have %v, want %v", block.NumberU64(), err, errMissingParent)
+		}
+		if block.NumberU64() <= 6 {
+			incomplete = *witness
+			incomplete.Codes = nil
+			if _, err := Execute(params.TestChainConfig, ethash.NewFaker(), block, &incomplete); err == nil {
+				t.Fatalf("block #%d: execution succeeded without codes", block.NumberU64())
+			}
+		}
+		for i := range witness.State {
+			incomplete = *witness
+			incomplete.State = append(append([][]byte{}, witness.State[:i]...), witness.State[i+1:]...)
+			if _, err := Execute(params.TestChainConfig, ethash.NewFaker(), block, &incomplete); err == nil {
+				t.Fatalf("block #%d: execution succeeded without trie node %x", block.NumberU64(), crypto.Keccak256(witness.State[i]))
+			}
+		}
+	}
+}
@@ -0,0 +1,196 @@
+// Copyright 2021 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+// Package stateless implements the generation of block execution witnesses and
+// the execution of blocks from such witnesses alone, without any local state.
+package stateless
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/core/state"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/ethereum/go-ethereum/trie"
+)
+
+// Witness contains everything accessed during the execution of a block, which
+// is enough to execute the block again without any other chain data.
+type Witness struct {
+	Headers []*types.Header // Parent header, followed by the ancestors accessed by BLOCKHASH
+	Codes   [][]byte        // Contract codes accessed during execution, sorted by hash
+	State   [][]byte        // Trie nodes accessed during execution and hashing, sorted by hash
+}
+
+// Generate executes a block on top of its parent state, recording every trie
+// node, contract code and header accessed in the process into a witness. The
+// state of the parent block must be available.
+func Generate(chain *core.BlockChain, block *types.Block) (*Witness, error) {
+	parent := chain.GetHeader(block.ParentHash(), block.NumberU64()-1)
+	if parent == nil {
+		return nil, fmt.Errorf("parent block %#x not found", block.ParentHash())
+	}
+	// Open the parent state without the snapshot, forcing all the reads to go
+	// through the tries so that the accessed nodes are recorded
+	db := &recordingDatabase{
+		Database: chain.StateCache(),
+		recorder: trie.NewRecorder(),
+		codes:    make(map[common.Hash][]byte),
+	}
+	statedb, err := state.New(parent.Root, db, nil)
+	if err != nil {
+		return nil, err
+	}
+	hc := &recordingChain{
+		BlockChain: chain,
+		headers:    make(map[common.Hash]*types.Header),
+	}
+	processor := core.NewStateProcessor(chain.Config(), hc, chain.Engine())
+	if _, _, _, err := processor.Process(block, statedb, vm.Config{}); err != nil {
+		return nil, err
+	}
+	// Hash the post state to record the nodes touched by the trie updates too
+	if root := statedb.IntermediateRoot(chain.Config().IsEIP158(block.Number())); root != block.Root() {
+		return nil, fmt.Errorf("state root mismatch: have %x, want %x", root, block.Root())
+	}
+	witness := &Witness{
+		Headers: []*types.Header{parent},
+		Codes:   db.sortedCodes(),
+		State:   db.recorder.Nodes(),
+	}
+	for hash, header := range hc.headers {
+		if hash != parent.Hash() {
+			witness.Headers = append(witness.Headers, header)
+		}
+	}
+	sort.Slice(witness.Headers[1:], func(i, j int) bool {
+		return witness.Headers[i+1].Number.Cmp(witness.Headers[j+1].Number) > 0
+	})
+	return witness, nil
+}
+
+// recordingDatabase is a state database recording the trie nodes and contract
+// codes accessed through it.
+type recordingDatabase struct {
+	state.Database
+
+	recorder *trie.Recorder
+	codes    map[common.Hash][]byte
+	lock     sync.Mutex
+}
+
+// OpenTrie opens the main account trie, attaching the node recorder.
+func (db *recordingDatabase) OpenTrie(root common.Hash) (state.Trie, error) {
+	tr, err := db.Database.OpenTrie(root)
+	if err != nil {
+		return nil, err
+	}
+	return db.record(tr)
+}
+
+// OpenStorageTrie opens the storage trie of an account, attaching the node
+// recorder.
+func (db *recordingDatabase) OpenStorageTrie(addrHash, root common.Hash) (state.Trie, error) {
+	tr, err := db.Database.OpenStorageTrie(addrHash, root)
+	if err != nil {
+		return nil, err
+	}
+	return db.record(tr)
+}
+
+// record attaches the node recorder to a trie.
+func (db *recordingDatabase) record(tr state.Trie) (state.Trie, error) {
+	st, ok := tr.(*trie.SecureTrie)
+	if !ok {
+		return nil, fmt.Errorf("unknown trie type %T", tr)
+	}
+	st.Record(db.recorder)
+	return st, nil
+}
+
+// ContractCode retrieves a particular contract's code, recording it.
+func (db *recordingDatabase) ContractCode(addrHash, codeHash common.Hash) ([]byte, error) {
+	code, err := db.Database.ContractCode(addrHash, codeHash)
+	if err != nil {
+		return nil, err
+	}
+	db.lock.Lock()
+	db.codes[codeHash] = code
+	db.lock.Unlock()
+
+	return code, nil
+}
+
+// ContractCodeSize retrieves a particular contract's code size. The whole code
+// is recorded, as it's needed to prove the size.
+func (db *recordingDatabase) ContractCodeSize(addrHash, codeHash common.Hash) (int, error) {
+	code, err := db.ContractCode(addrHash, codeHash)
+	return len(code), err
+}
+
+// sortedCodes returns the recorded contract codes sorted by hash.
+func (db *recordingDatabase) sortedCodes() [][]byte {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	hashes := make([]common.Hash, 0, len(db.codes))
+	for hash := range db.codes {
+		hashes = append(hashes, hash)
+	}
+	sort.Slice(hashes, func(i, j int) bool {
+		return bytes.Compare(hashes[i][:], hashes[j][:]) < 0
+	})
+	codes := make([][]byte, len(hashes))
+	for i, hash := range hashes {
+		codes[i] = db.codes[hash]
+	}
+	return codes
+}
+
+// recordingChain is a chain recording the headers looked up through it.
+type recordingChain struct {
+	*core.BlockChain
+
+	headers map[common.Hash]*types.Header
+}
+
+// GetHeader retrieves a block header by hash and number, recording it.
+func (c *recordingChain) GetHeader(hash common.Hash, number uint64) *types.Header {
+	return c.record(c.BlockChain.GetHeader(hash, number))
+}
+
+// GetHeaderByNumber retrieves a canonical block header by number, recording it.
+func (c *recordingChain) GetHeaderByNumber(number uint64) *types.Header {
+	return c.record(c.BlockChain.GetHeaderByNumber(number))
+}
+
+// GetHeaderByHash retrieves a block header by hash, recording it.
+func (c *recordingChain) GetHeaderByHash(hash common.Hash) *types.Header {
+	return c.record(c.BlockChain.GetHeaderByHash(hash))
+}
+
+// record stores a looked up header, if it was found.
+func (c *recordingChain) record(header *types.Header) *types.Header {
+	if header != nil {
+		c.headers[header.Hash()] = header
+	}
+	return header
+}
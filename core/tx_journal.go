@@ -17,9 +17,11 @@
 package core
 
 import (
+	"bufio"
 	"errors"
 	"io"
 	"os"
+	"time"
 
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/core/types"
@@ -178,3 +180,123 @@ func (journal *txJournal) close() error {
 	}
 	return err
 }
+
+// remoteJournalEntry is a remote transaction along with the time it was first
+// seen, as stored in the remote transaction journal.
+type remoteJournalEntry struct {
+	Tx   *types.Transaction
+	Time uint64 // Unix time the transaction was first seen at
+}
+
+// remoteTxJournal is a snapshot of the remote transactions of the pool, taken
+// periodically and on shutdown to allow repopulating the pool after restarts
+// without waiting for the network gossip.
+type remoteTxJournal struct {
+	path   string        // Filesystem path to store the transactions at
+	maxAge time.Duration // Maximum age of the transactions to store and reload
+}
+
+// newRemoteTxJournal creates a new remote transaction journal.
+func newRemoteTxJournal(path string, maxAge time.Duration) *remoteTxJournal {
+	return &remoteTxJournal{
+		path:   path,
+		maxAge: maxAge,
+	}
+}
+
+// load parses a remote transaction journal dump from disk, restoring the first
+// seen times of the transactions not older than the age cap and injecting them
+// into the specified pool for revalidation.
+func (journal *remoteTxJournal) load(add func([]*types.Transaction) []error) error {
+	// Skip the parsing if the journal file doesn't exist at all
+	input, err := os.Open(journal.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer input.Close()
+
+	var (
+		stream  = rlp.NewStream(input, 0)
+		now     = time.Now()
+		batch   types.Transactions
+		failure error
+
+		total, expired, dropped int
+	)
+	loadBatch := func(txs types.Transactions) {
+		for _, err := range add(txs) {
+			if err != nil {
+				log.Trace("Failed to add journaled remote transaction", "err", err)
+				dropped++
+			}
+		}
+	}
+	for {
+		// Parse the next transaction and terminate on error
+		var entry remoteJournalEntry
+		if err = stream.Decode(&entry); err != nil {
+			if err != io.EOF {
+				failure = err
+			}
+			break
+		}
+		total++
+
+		seen := time.Unix(int64(entry.Time), 0)
+		if now.Sub(seen) > journal.maxAge {
+			expired++
+			continue
+		}
+		entry.Tx.SetTime(seen)
+		if batch = append(batch, entry.Tx); batch.Len() > 1024 {
+			loadBatch(batch)
+			batch = batch[:0]
+		}
+	}
+	if batch.Len() > 0 {
+		loadBatch(batch)
+	}
+	log.Info("Loaded remote transaction journal", "transactions", total, "expired", expired, "dropped", dropped)
+	return failure
+}
+
+// save regenerates the remote transaction journal from the given transactions,
+// skipping the ones older than the age cap.
+func (journal *remoteTxJournal) save(all map[common.Address]types.Transactions) error {
+	replacement, err := os.OpenFile(journal.path+".new", os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	var (
+		output    = bufio.NewWriter(replacement)
+		now       = time.Now()
+		journaled int
+	)
+	for _, txs := range all {
+		for _, tx := range txs {
+			if now.Sub(tx.Time()) > journal.maxAge {
+				continue
+			}
+			if err = rlp.Encode(output, &remoteJournalEntry{Tx: tx, Time: uint64(tx.Time().Unix())}); err != nil {
+				replacement.Close()
+				return err
+			}
+			journaled++
+		}
+	}
+	if err = output.Flush(); err != nil {
+		replacement.Close()
+		return err
+	}
+	replacement.Close()
+
+	// Replace the previous journal with the newly generated one
+	if err = os.Rename(journal.path+".new", journal.path); err != nil {
+		return err
+	}
+	log.Debug("Regenerated remote transaction journal", "transactions", journaled, "accounts", len(all))
+	return nil
+}
@@ -26,6 +26,7 @@ import (
 	"time"
 
 	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/mclock"
 	"github.com/ethereum/go-ethereum/common/prque"
 	"github.com/ethereum/go-ethereum/consensus/misc"
 	"github.com/ethereum/go-ethereum/core/state"
@@ -40,6 +41,11 @@ const (
 	// chainHeadChanSize is the size of channel listening to ChainHeadEvent.
 	chainHeadChanSize = 10
 
+	// txPoolEventChanSize is the number of lifecycle event batches buffered for
+	// delivery to the subscribers. Batches are dropped if the subscribers can't
+	// keep up, so a slow one doesn't stall the pool.
+	txPoolEventChanSize = 64
+
 	// txSlotSize is used to calculate how many data slots a single transaction
 	// takes up based on its size. The slots are used as DoS protection, ensuring
 	// that validating a new transaction remains a constant operation (in reality
@@ -112,6 +118,8 @@ var (
 	invalidTxMeter     = metrics.NewRegisteredMeter("txpool/invalid", nil)
 	underpricedTxMeter = metrics.NewRegisteredMeter("txpool/underpriced", nil)
 	overflowedTxMeter  = metrics.NewRegisteredMeter("txpool/overflowed", nil)
+	policyTxMeter      = metrics.NewRegisteredMeter("txpool/policy", nil)
+	eventDropMeter     = metrics.NewRegisteredMeter("txpool/events/dropped", nil)
 	// throttleTxMeter counts how many transactions are rejected due to too-many-changes between
 	// txpool reorgs.
 	throttleTxMeter = metrics.NewRegisteredMeter("txpool/throttle", nil)
@@ -156,6 +164,9 @@ type TxPoolConfig struct {
 	Journal   string           // Journal of local transactions to survive node restarts
 	Rejournal time.Duration    // Time interval to regenerate the local transaction journal
 
+	RemoteJournal    string        // Journal of remote transactions to survive node restarts, regenerated every Rejournal (empty = disabled)
+	RemoteJournalAge time.Duration // Maximum age of the remote transactions to journal and reload
+
 	PriceLimit uint64 // Minimum gas price to enforce for acceptance into the pool
 	PriceBump  uint64 // Minimum price bump percentage to replace an already existing transaction (nonce)
 
@@ -165,6 +176,11 @@ type TxPoolConfig struct {
 	GlobalQueue  uint64 // Maximum number of non-executable transaction slots for all accounts
 
 	Lifetime time.Duration // Maximum amount of time non-executable transaction are queued
+
+	EventHistory uint64 // Number of recent transaction lifecycle events retained for lookups (0 = disabled)
+
+	Policy   TxPolicyConfig // Configuration of the built-in admission policies
+	Policies []TxPoolPolicy `toml:"-"` // Custom admission policies, evaluated after the built-in ones
 }
 
 // DefaultTxPoolConfig contains the default configurations for the transaction
@@ -173,6 +189,8 @@ var DefaultTxPoolConfig = TxPoolConfig{
 	Journal:   "transactions.rlp",
 	Rejournal: time.Hour,
 
+	RemoteJournalAge: time.Hour,
+
 	PriceLimit: 1,
 	PriceBump:  10,
 
@@ -182,6 +200,12 @@ var DefaultTxPoolConfig = TxPoolConfig{
 	GlobalQueue:  1024,
 
 	Lifetime: 3 * time.Hour,
+
+	EventHistory: 4096,
+
+	Policy: TxPolicyConfig{
+		SenderRateInterval: time.Minute,
+	},
 }
 
 // sanitize checks the provided user configurations and changes anything that's
@@ -192,6 +216,10 @@ func (config *TxPoolConfig) sanitize() TxPoolConfig {
 		log.Warn("Sanitizing invalid txpool journal time", "provided", conf.Rejournal, "updated", time.Second)
 		conf.Rejournal = time.Second
 	}
+	if conf.RemoteJournal != "" && conf.RemoteJournalAge < 1 {
+		log.Warn("Sanitizing invalid txpool remote journal age", "provided", conf.RemoteJournalAge, "updated", DefaultTxPoolConfig.RemoteJournalAge)
+		conf.RemoteJournalAge = DefaultTxPoolConfig.RemoteJournalAge
+	}
 	if conf.PriceLimit < 1 {
 		log.Warn("Sanitizing invalid txpool price limit", "provided", conf.PriceLimit, "updated", DefaultTxPoolConfig.PriceLimit)
 		conf.PriceLimit = DefaultTxPoolConfig.PriceLimit
@@ -236,6 +264,7 @@ type TxPool struct {
 	chain       blockChain
 	gasPrice    *big.Int
 	txFeed      event.Feed
+	eventFeed   event.Feed
 	scope       event.SubscriptionScope
 	signer      types.Signer
 	mu          sync.RWMutex
@@ -248,8 +277,16 @@ type TxPool struct {
 	pendingNonces *txNoncer      // Pending state tracking virtual nonces
 	currentMaxGas uint64         // Current gas limit for transaction caps
 
-	locals  *accountSet // Set of local transaction to exempt from eviction rules
-	journal *txJournal  // Journal of local transaction to back up to disk
+	locals        *accountSet      // Set of local transaction to exempt from eviction rules
+	journal       *txJournal       // Journal of local transaction to back up to disk
+	remoteJournal *remoteTxJournal // Journal of remote transactions to back up to disk
+	policies      []TxPoolPolicy   // Admission policies, the built-in ones followed by the custom ones
+	privates      *privateTxSet    // Private transactions not to be propagated to the network
+
+	events   []TxPoolEvent            // Lifecycle events recorded since the last delivery
+	eventCh  chan []TxPoolEvent       // Batches of lifecycle events waiting for delivery
+	history  *txEventHistory          // Recent lifecycle events retained for lookups
+	included map[common.Hash]struct{} // Transactions included by the chain during the running reorg
 
 	pending map[common.Address]*txList   // All currently processable transactions
 	queue   map[common.Address]*txList   // Queued but non-processable transactions
@@ -298,18 +335,23 @@ func NewTxPool(config TxPoolConfig, chainconfig *params.ChainConfig, chain block
 		reorgShutdownCh: make(chan struct{}),
 		initDoneCh:      make(chan struct{}),
 		gasPrice:        new(big.Int).SetUint64(config.PriceLimit),
+		eventCh:         make(chan []TxPoolEvent, txPoolEventChanSize),
+		history:         newTxEventHistory(int(config.EventHistory)),
+		privates:        newPrivateTxSet(),
 	}
 	pool.locals = newAccountSet(pool.signer)
 	for _, addr := range config.Locals {
 		log.Info("Setting new local account", "address", addr)
 		pool.locals.add(addr)
 	}
+	pool.policies = append(config.Policy.policies(mclock.System{}), config.Policies...)
 	pool.priced = newTxPricedList(pool.all)
 	pool.reset(nil, chain.CurrentBlock().Header())
 
 	// Start the reorg loop early so it can handle requests generated during journal loading.
-	pool.wg.Add(1)
+	pool.wg.Add(2)
 	go pool.scheduleReorgLoop()
+	go pool.eventLoop()
 
 	// If local transactions and journaling is enabled, load from disk
 	if !config.NoLocals && config.Journal != "" {
@@ -322,6 +364,18 @@ func NewTxPool(config TxPoolConfig, chainconfig *params.ChainConfig, chain block
 			log.Warn("Failed to rotate transaction journal", "err", err)
 		}
 	}
+	// If remote transaction journaling is enabled, reinject them for revalidation
+	if config.RemoteJournal != "" {
+		pool.remoteJournal = newRemoteTxJournal(config.RemoteJournal, config.RemoteJournalAge)
+
+		// The reloaded transactions were admitted before, don't count them again
+		reload := func(txs []*types.Transaction) []error {
+			return pool.addTxs(txs, false, false, false)
+		}
+		if err := pool.remoteJournal.load(reload); err != nil {
+			log.Warn("Failed to load remote transaction journal", "err", err)
+		}
+	}
 
 	// Subscribe events from blockchain and start the main event loop.
 	pool.chainHeadSub = pool.chain.SubscribeChainHeadEvent(pool.chainHeadCh)
@@ -391,11 +445,13 @@ func (pool *TxPool) loop() {
 					list := pool.queue[addr].Flatten()
 					for _, tx := range list {
 						pool.removeTx(tx.Hash(), true)
+						pool.recordDrop(tx.Hash(), TxDropExpired)
 					}
 					queuedEvictionMeter.Mark(int64(len(list)))
 				}
 			}
 			pool.mu.Unlock()
+			pool.sendEvents()
 
 		// Handle local transaction journal rotation
 		case <-journal.C:
@@ -406,6 +462,9 @@ func (pool *TxPool) loop() {
 				}
 				pool.mu.Unlock()
 			}
+			if pool.remoteJournal != nil {
+				pool.saveRemoteJournal()
+			}
 		}
 	}
 }
@@ -422,15 +481,118 @@ func (pool *TxPool) Stop() {
 	if pool.journal != nil {
 		pool.journal.close()
 	}
+	if pool.remoteJournal != nil {
+		pool.saveRemoteJournal()
+	}
 	log.Info("Transaction pool stopped")
 }
 
+// saveRemoteJournal regenerates the remote transaction journal from the current
+// contents of the pool.
+func (pool *TxPool) saveRemoteJournal() {
+	pool.mu.RLock()
+	remotes := pool.remote()
+	pool.mu.RUnlock()
+
+	if err := pool.remoteJournal.save(remotes); err != nil {
+		log.Warn("Failed to save remote tx journal", "err", err)
+	}
+}
+
 // SubscribeNewTxsEvent registers a subscription of NewTxsEvent and
 // starts sending event to the given channel.
 func (pool *TxPool) SubscribeNewTxsEvent(ch chan<- NewTxsEvent) event.Subscription {
 	return pool.scope.Track(pool.txFeed.Subscribe(ch))
 }
 
+// SubscribeTxPoolEvent registers a subscription of the transaction lifecycle
+// events of the pool and starts sending them to the given channel.
+func (pool *TxPool) SubscribeTxPoolEvent(ch chan<- TxPoolEvent) event.Subscription {
+	return pool.scope.Track(pool.eventFeed.Subscribe(ch))
+}
+
+// History returns the retained lifecycle events of a transaction, oldest first.
+func (pool *TxPool) History(hash common.Hash) []TxPoolEvent {
+	pool.mu.RLock()
+	defer pool.mu.RUnlock()
+
+	return pool.history.get(hash)
+}
+
+// recordEvent records a lifecycle event of a transaction, to be delivered to
+// the subscribers by sendEvents.
+//
+// Note, this method assumes the pool lock is held!
+func (pool *TxPool) recordEvent(ev TxPoolEvent) {
+	ev.Private = pool.privates.contains(ev.Hash) || (ev.ReplacedBy != (common.Hash{}) && pool.privates.contains(ev.ReplacedBy))
+	ev.Time = time.Now()
+	pool.events = append(pool.events, ev)
+	pool.history.add(ev)
+}
+
+// recordDrop records that a transaction left the pool for the given reason.
+//
+// Note, this method assumes the pool lock is held!
+func (pool *TxPool) recordDrop(hash common.Hash, reason string) {
+	pool.recordEvent(TxPoolEvent{Hash: hash, Kind: TxPoolEventDropped, Reason: reason})
+}
+
+// recordStale records that a transaction left the pool as its nonce was used
+// by the chain, either by itself being included or by a competitor.
+//
+// Note, this method assumes the pool lock is held!
+func (pool *TxPool) recordStale(hash common.Hash) {
+	if _, ok := pool.included[hash]; ok {
+		pool.recordEvent(TxPoolEvent{Hash: hash, Kind: TxPoolEventIncluded})
+		return
+	}
+	pool.recordDrop(hash, TxDropNonceTooLow)
+}
+
+// recordReplace records that a transaction was superseded by another one.
+//
+// Note, this method assumes the pool lock is held!
+func (pool *TxPool) recordReplace(hash common.Hash, replacement common.Hash) {
+	pool.recordEvent(TxPoolEvent{Hash: hash, Kind: TxPoolEventReplaced, ReplacedBy: replacement})
+}
+
+// sendEvents hands the lifecycle events recorded since the last call over for
+// delivery to the subscribers. If the delivery falls too far behind, the events
+// are dropped. It must be called without holding the pool lock.
+func (pool *TxPool) sendEvents() {
+	pool.mu.Lock()
+	events := pool.events
+	pool.events = nil
+	pool.mu.Unlock()
+
+	if len(events) == 0 {
+		return
+	}
+	select {
+	case pool.eventCh <- events:
+	default:
+		eventDropMeter.Mark(int64(len(events)))
+		log.Debug("Dropping transaction pool events, subscribers too slow", "count", len(events))
+	}
+}
+
+// eventLoop delivers the batches of lifecycle events to the subscribers, in
+// the background so that slow subscribers don't block the pool.
+func (pool *TxPool) eventLoop() {
+	defer pool.wg.Done()
+
+	for {
+		select {
+		case events := <-pool.eventCh:
+			for _, ev := range events {
+				pool.eventFeed.Send(ev)
+			}
+		case <-pool.reorgShutdownCh:
+			return
+		}
+	}
+}
+
 // GasPrice returns the current gas price enforced by the transaction pool.
 func (pool *TxPool) GasPrice() *big.Int {
 	pool.mu.RLock()
@@ -442,6 +604,8 @@ func (pool *TxPool) GasPrice() *big.Int {
 // SetGasPrice updates the minimum price required by the transaction pool for a
 // new transaction, and drops all transactions below this threshold.
 func (pool *TxPool) SetGasPrice(price *big.Int) {
+	defer pool.sendEvents()
+
 	pool.mu.Lock()
 	defer pool.mu.Unlock()
 
@@ -453,6 +617,7 @@ func (pool *TxPool) SetGasPrice(price *big.Int) {
 		drop := pool.all.RemotesBelowTip(price)
 		for _, tx := range drop {
 			pool.removeTx(tx.Hash(), false)
+			pool.recordDrop(tx.Hash(), TxDropUnderpriced)
 		}
 		pool.priced.Removed(len(drop))
 	}
@@ -460,6 +625,51 @@ func (pool *TxPool) SetGasPrice(price *big.Int) {
 	log.Info("Transaction pool price threshold updated", "price", price)
 }
 
+// Policy returns the configuration of the built-in admission policies.
+func (pool *TxPool) Policy() TxPolicyConfig {
+	pool.mu.RLock()
+	defer pool.mu.RUnlock()
+
+	return pool.config.Policy
+}
+
+// SetPolicy replaces the built-in admission policies of the pool, keeping the
+// custom ones. Remote transactions in the pool rejected by the new allowlist or
+// blocklist are dropped, rate limits only apply to new transactions though.
+func (pool *TxPool) SetPolicy(config TxPolicyConfig) {
+	defer pool.sendEvents()
+
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+
+	builtins := config.policies(mclock.System{})
+	pool.config.Policy = config
+	pool.policies = append(builtins, pool.config.Policies...)
+
+	var drop []*types.Transaction
+	pool.all.Range(func(hash common.Hash, tx *types.Transaction, local bool) bool {
+		from, _ := types.Sender(pool.signer, tx) // already validated during insertion
+		for _, policy := range builtins {
+			if _, ok := policy.(txPoolQuota); ok {
+				continue
+			}
+			if policy.Check(tx, from, local) != nil {
+				drop = append(drop, tx)
+				break
+			}
+		}
+		return true
+	}, false, true)
+	for _, tx := range drop {
+		pool.removeTx(tx.Hash(), false)
+		pool.recordDrop(tx.Hash(), TxDropPolicy)
+	}
+	pool.priced.Removed(len(drop))
+
+	log.Info("Transaction pool policy updated", "allowed", len(config.AllowedSenders), "blocked", len(config.BlockedRecipients),
+		"ratelimit", config.SenderRateLimit, "interval", config.SenderRateInterval, "dropped", len(drop))
+}
+
 // Nonce returns the next nonce of an account, with all transactions executable
 // by the pool already applied on top.
 func (pool *TxPool) Nonce(addr common.Address) uint64 {
@@ -567,15 +777,34 @@ func (pool *TxPool) Locals() []common.Address {
 
 // local retrieves all currently known local transactions, grouped by origin
 // account and sorted by nonce. The returned transaction set is a copy and can be
-// freely modified by calling code.
+// freely modified by calling code. Private transactions are excluded as they are
+// not to be journaled.
 func (pool *TxPool) local() map[common.Address]types.Transactions {
 	txs := make(map[common.Address]types.Transactions)
 	for addr := range pool.locals.accounts {
 		if pending := pool.pending[addr]; pending != nil {
-			txs[addr] = append(txs[addr], pending.Flatten()...)
+			txs[addr] = append(txs[addr], pool.privates.filter(pending.Flatten())...)
 		}
 		if queued := pool.queue[addr]; queued != nil {
-			txs[addr] = append(txs[addr], queued.Flatten()...)
+			txs[addr] = append(txs[addr], pool.privates.filter(queued.Flatten())...)
+		}
+	}
+	return txs
+}
+
+// remote retrieves all currently known remote transactions, grouped by origin
+// account and sorted by nonce. Private transactions are excluded as they are not
+// to be journaled.
+func (pool *TxPool) remote() map[common.Address]types.Transactions {
+	txs := make(map[common.Address]types.Transactions)
+	for addr, pending := range pool.pending {
+		if !pool.locals.contains(addr) {
+			txs[addr] = append(txs[addr], pool.privates.filter(pending.Flatten())...)
+		}
+	}
+	for addr, queued := range pool.queue {
+		if !pool.locals.contains(addr) {
+			txs[addr] = append(txs[addr], pool.privates.filter(queued.Flatten())...)
 		}
 	}
 	return txs
@@ -645,6 +874,47 @@ func (pool *TxPool) validateTx(tx *types.Transaction, local bool) error {
 	return nil
 }
 
+// checkPolicies evaluates the admission policies of the pool on a transaction,
+// returning the first rejection. Quotas are checked separately, only for new
+// submissions.
+func (pool *TxPool) checkPolicies(tx *types.Transaction, local bool) error {
+	from, _ := types.Sender(pool.signer, tx) // already validated
+	for _, policy := range pool.policies {
+		if _, ok := policy.(txPoolQuota); ok {
+			continue
+		}
+		if err := policy.Check(tx, from, local); err != nil {
+			return &TxPolicyError{Policy: policy.Name(), Err: err}
+		}
+	}
+	return nil
+}
+
+// checkQuotas evaluates the quotas of the admission policies on a newly submitted
+// transaction, returning the first rejection.
+func (pool *TxPool) checkQuotas(tx *types.Transaction, local bool) error {
+	from, _ := types.Sender(pool.signer, tx) // already validated
+	for _, policy := range pool.policies {
+		if quota, ok := policy.(txPoolQuota); ok {
+			if err := quota.Check(tx, from, local); err != nil {
+				return &TxPolicyError{Policy: quota.Name(), Err: err}
+			}
+		}
+	}
+	return nil
+}
+
+// admitQuotas counts a newly submitted transaction admitted into the pool against
+// the quotas of the admission policies.
+func (pool *TxPool) admitQuotas(tx *types.Transaction, local bool) {
+	from, _ := types.Sender(pool.signer, tx) // already validated
+	for _, policy := range pool.policies {
+		if quota, ok := policy.(txPoolQuota); ok {
+			quota.Admit(tx, from, local)
+		}
+	}
+}
+
 // add validates a transaction and inserts it into the non-executable queue for later
 // pending promotion and execution. If the transaction is a replacement for an already
 // pending or queued one, it overwrites the previous transaction if its price is higher.
@@ -670,6 +940,12 @@ func (pool *TxPool) add(tx *types.Transaction, local bool) (replaced bool, err e
 		invalidTxMeter.Mark(1)
 		return false, err
 	}
+	// If the transaction is rejected by any admission policy, discard it
+	if err := pool.checkPolicies(tx, isLocal); err != nil {
+		log.Trace("Discarding transaction rejected by policy", "hash", hash, "err", err)
+		policyTxMeter.Mark(1)
+		return false, err
+	}
 	// If the transaction pool is full, discard underpriced transactions
 	if uint64(pool.all.Slots()+numSlots(tx)) > pool.config.GlobalSlots+pool.config.GlobalQueue {
 		// If the new transaction is underpriced, don't accept it
@@ -705,6 +981,7 @@ func (pool *TxPool) add(tx *types.Transaction, local bool) (replaced bool, err e
 			log.Trace("Discarding freshly underpriced transaction", "hash", tx.Hash(), "gasTipCap", tx.GasTipCap(), "gasFeeCap", tx.GasFeeCap())
 			underpricedTxMeter.Mark(1)
 			pool.removeTx(tx.Hash(), false)
+			pool.recordDrop(tx.Hash(), TxDropUnderpriced)
 		}
 	}
 	// Try to replace an existing transaction in the pending pool
@@ -721,11 +998,13 @@ func (pool *TxPool) add(tx *types.Transaction, local bool) (replaced bool, err e
 			pool.all.Remove(old.Hash())
 			pool.priced.Removed(1)
 			pendingReplaceMeter.Mark(1)
+			pool.recordReplace(old.Hash(), hash)
 		}
 		pool.all.Add(tx, isLocal)
 		pool.priced.Put(tx, isLocal)
 		pool.journalTx(from, tx)
 		pool.queueTxEvent(tx)
+		pool.recordEvent(TxPoolEvent{Hash: hash, Kind: TxPoolEventAdded})
 		log.Trace("Pooled new executable transaction", "hash", hash, "from", from, "to", tx.To())
 
 		// Successful promotion, bump the heartbeat
@@ -747,6 +1026,7 @@ func (pool *TxPool) add(tx *types.Transaction, local bool) (replaced bool, err e
 		localGauge.Inc(1)
 	}
 	pool.journalTx(from, tx)
+	pool.recordEvent(TxPoolEvent{Hash: hash, Kind: TxPoolEventAdded})
 
 	log.Trace("Pooled new future transaction", "hash", hash, "from", from, "to", tx.To())
 	return replaced, nil
@@ -772,6 +1052,7 @@ func (pool *TxPool) enqueueTx(hash common.Hash, tx *types.Transaction, local boo
 		pool.all.Remove(old.Hash())
 		pool.priced.Removed(1)
 		queuedReplaceMeter.Mark(1)
+		pool.recordReplace(old.Hash(), hash)
 	} else {
 		// Nothing was replaced, bump the queued counter
 		queuedGauge.Inc(1)
@@ -795,8 +1076,8 @@ func (pool *TxPool) enqueueTx(hash common.Hash, tx *types.Transaction, local boo
 // journalTx adds the specified transaction to the local disk journal if it is
 // deemed to have been sent from a local account.
 func (pool *TxPool) journalTx(from common.Address, tx *types.Transaction) {
-	// Only journal if it's enabled and the transaction is local
-	if pool.journal == nil || !pool.locals.contains(from) {
+	// Only journal if it's enabled and the transaction is local, but not private
+	if pool.journal == nil || !pool.locals.contains(from) || pool.privates.contains(tx.Hash()) {
 		return
 	}
 	if err := pool.journal.insert(tx); err != nil {
@@ -821,6 +1102,7 @@ func (pool *TxPool) promoteTx(addr common.Address, hash common.Hash, tx *types.T
 		pool.all.Remove(hash)
 		pool.priced.Removed(1)
 		pendingDiscardMeter.Mark(1)
+		pool.recordDrop(hash, TxDropReplaceFailed)
 		return false
 	}
 	// Otherwise discard any previous transaction and mark this
@@ -828,6 +1110,7 @@ func (pool *TxPool) promoteTx(addr common.Address, hash common.Hash, tx *types.T
 		pool.all.Remove(old.Hash())
 		pool.priced.Removed(1)
 		pendingReplaceMeter.Mark(1)
+		pool.recordReplace(old.Hash(), hash)
 	} else {
 		// Nothing was replaced, bump the pending counter
 		pendingGauge.Inc(1)
@@ -846,7 +1129,7 @@ func (pool *TxPool) promoteTx(addr common.Address, hash common.Hash, tx *types.T
 // This method is used to add transactions from the RPC API and performs synchronous pool
 // reorganization and event propagation.
 func (pool *TxPool) AddLocals(txs []*types.Transaction) []error {
-	return pool.addTxs(txs, !pool.config.NoLocals, true)
+	return pool.addTxs(txs, !pool.config.NoLocals, true, true)
 }
 
 // AddLocal enqueues a single local transaction into the pool if it is valid. This is
@@ -862,12 +1145,12 @@ func (pool *TxPool) AddLocal(tx *types.Transaction) error {
 // This method is used to add transactions from the p2p network and does not wait for pool
 // reorganization and internal event propagation.
 func (pool *TxPool) AddRemotes(txs []*types.Transaction) []error {
-	return pool.addTxs(txs, false, false)
+	return pool.addTxs(txs, false, false, true)
 }
 
 // This is like AddRemotes, but waits for pool reorganization. Tests use this method.
 func (pool *TxPool) AddRemotesSync(txs []*types.Transaction) []error {
-	return pool.addTxs(txs, false, true)
+	return pool.addTxs(txs, false, true, true)
 }
 
 // This is like AddRemotes with a single transaction, but waits for pool reorganization. Tests use this method.
@@ -885,8 +1168,9 @@ func (pool *TxPool) AddRemote(tx *types.Transaction) error {
 	return errs[0]
 }
 
-// addTxs attempts to queue a batch of transactions if they are valid.
-func (pool *TxPool) addTxs(txs []*types.Transaction, local, sync bool) []error {
+// addTxs attempts to queue a batch of transactions if they are valid. Only newly
+// submitted transactions are subject to the quotas of the admission policies.
+func (pool *TxPool) addTxs(txs []*types.Transaction, local, sync, submitted bool) []error {
 	// Filter out known ones without obtaining the pool lock or recovering signatures
 	var (
 		errs = make([]error, len(txs))
@@ -917,8 +1201,9 @@ func (pool *TxPool) addTxs(txs []*types.Transaction, local, sync bool) []error {
 
 	// Process all the new transaction and merge any errors into the original slice
 	pool.mu.Lock()
-	newErrs, dirtyAddrs := pool.addTxsLocked(news, local)
+	newErrs, dirtyAddrs := pool.addTxsLocked(news, local, submitted)
 	pool.mu.Unlock()
+	pool.sendEvents()
 
 	var nilSlot = 0
 	for _, err := range newErrs {
@@ -936,14 +1221,28 @@ func (pool *TxPool) addTxs(txs []*types.Transaction, local, sync bool) []error {
 	return errs
 }
 
-// addTxsLocked attempts to queue a batch of transactions if they are valid.
+// addTxsLocked attempts to queue a batch of transactions if they are valid. The
+// newly submitted ones are checked against the quotas of the admission policies,
+// and counted if admitted.
 // The transaction pool lock must be held.
-func (pool *TxPool) addTxsLocked(txs []*types.Transaction, local bool) ([]error, *accountSet) {
+func (pool *TxPool) addTxsLocked(txs []*types.Transaction, local, submitted bool) ([]error, *accountSet) {
 	dirty := newAccountSet(pool.signer)
 	errs := make([]error, len(txs))
 	for i, tx := range txs {
+		isLocal := local || pool.locals.containsTx(tx)
+		if submitted {
+			if err := pool.checkQuotas(tx, isLocal); err != nil {
+				log.Trace("Discarding transaction exceeding quota", "hash", tx.Hash(), "err", err)
+				policyTxMeter.Mark(1)
+				errs[i] = err
+				continue
+			}
+		}
 		replaced, err := pool.add(tx, local)
 		errs[i] = err
+		if err == nil && submitted {
+			pool.admitQuotas(tx, isLocal)
+		}
 		if err == nil && !replaced {
 			dirty.addTx(tx)
 		}
@@ -1182,6 +1481,11 @@ func (pool *TxPool) runReorg(done chan struct{}, reset *txpoolResetRequest, dirt
 			pendingBaseFee := misc.CalcBaseFee(pool.chainconfig, reset.newHead)
 			pool.priced.SetBaseFee(pendingBaseFee)
 		}
+		head := reset.newHead
+		if head == nil {
+			head = pool.chain.CurrentBlock().Header() // Special case during testing
+		}
+		pool.expirePrivates(head.Number.Uint64())
 	}
 	// Ensure pool.queue and pool.pending sizes stay within the configured limits.
 	pool.truncatePending()
@@ -1194,6 +1498,7 @@ func (pool *TxPool) runReorg(done chan struct{}, reset *txpoolResetRequest, dirt
 	}
 	dropBetweenReorgHistogram.Update(int64(pool.changesSinceReorg))
 	pool.changesSinceReorg = 0 // Reset change counter
+	pool.included = nil
 	pool.mu.Unlock()
 
 	// Notify subsystems for newly added transactions
@@ -1211,6 +1516,7 @@ func (pool *TxPool) runReorg(done chan struct{}, reset *txpoolResetRequest, dirt
 		}
 		pool.txFeed.Send(NewTxsEvent{txs})
 	}
+	pool.sendEvents()
 }
 
 // reset retrieves the current state of the blockchain and ensures the content
@@ -1219,6 +1525,17 @@ func (pool *TxPool) reset(oldHead, newHead *types.Header) {
 	// If we're reorging an old state, reinject all dropped transactions
 	var reinject types.Transactions
 
+	// Track the transactions included by the new chain segment, so they are
+	// reported as such instead of being dropped for their stale nonces
+	pool.included = make(map[common.Hash]struct{})
+
+	if oldHead != nil && oldHead.Hash() == newHead.ParentHash {
+		if block := pool.chain.GetBlock(newHead.Hash(), newHead.Number.Uint64()); block != nil {
+			for _, tx := range block.Transactions() {
+				pool.included[tx.Hash()] = struct{}{}
+			}
+		}
+	}
 	if oldHead != nil && oldHead.Hash() != newHead.ParentHash {
 		// If the reorg is too deep, avoid doing it (will happen during fast sync)
 		oldNum := oldHead.Number.Uint64()
@@ -1276,6 +1593,9 @@ func (pool *TxPool) reset(oldHead, newHead *types.Header) {
 					}
 				}
 				reinject = types.TxDifference(discarded, included)
+				for _, tx := range included {
+					pool.included[tx.Hash()] = struct{}{}
+				}
 			}
 		}
 	}
@@ -1295,7 +1615,7 @@ func (pool *TxPool) reset(oldHead, newHead *types.Header) {
 	// Inject any transactions discarded due to reorgs
 	log.Debug("Reinjecting stale transactions", "count", len(reinject))
 	senderCacher.recover(pool.signer, reinject)
-	pool.addTxsLocked(reinject, false)
+	pool.addTxsLocked(reinject, false, false)
 
 	// Update all fork indicator by next pending block number.
 	next := new(big.Int).Add(newHead.Number, big.NewInt(1))
@@ -1322,6 +1642,7 @@ func (pool *TxPool) promoteExecutables(accounts []common.Address) []*types.Trans
 		for _, tx := range forwards {
 			hash := tx.Hash()
 			pool.all.Remove(hash)
+			pool.recordStale(hash)
 		}
 		log.Trace("Removed old queued transactions", "count", len(forwards))
 		// Drop all transactions that are too costly (low balance or out of gas)
@@ -1329,6 +1650,7 @@ func (pool *TxPool) promoteExecutables(accounts []common.Address) []*types.Trans
 		for _, tx := range drops {
 			hash := tx.Hash()
 			pool.all.Remove(hash)
+			pool.recordDrop(hash, TxDropUnpayable)
 		}
 		log.Trace("Removed unpayable queued transactions", "count", len(drops))
 		queuedNofundsMeter.Mark(int64(len(drops)))
@@ -1351,6 +1673,7 @@ func (pool *TxPool) promoteExecutables(accounts []common.Address) []*types.Trans
 			for _, tx := range caps {
 				hash := tx.Hash()
 				pool.all.Remove(hash)
+				pool.recordDrop(hash, TxDropAccountLimit)
 				log.Trace("Removed cap-exceeding queued transaction", "hash", hash)
 			}
 			queuedRateLimitMeter.Mark(int64(len(caps)))
@@ -1413,6 +1736,7 @@ func (pool *TxPool) truncatePending() {
 						// Drop the transaction from the global pools too
 						hash := tx.Hash()
 						pool.all.Remove(hash)
+						pool.recordDrop(hash, TxDropPoolLimit)
 
 						// Update the account nonce to the dropped transaction
 						pool.pendingNonces.setIfLower(offenders[i], tx.Nonce())
@@ -1440,6 +1764,7 @@ func (pool *TxPool) truncatePending() {
 					// Drop the transaction from the global pools too
 					hash := tx.Hash()
 					pool.all.Remove(hash)
+					pool.recordDrop(hash, TxDropPoolLimit)
 
 					// Update the account nonce to the dropped transaction
 					pool.pendingNonces.setIfLower(addr, tx.Nonce())
@@ -1487,6 +1812,7 @@ func (pool *TxPool) truncateQueue() {
 		if size := uint64(list.Len()); size <= drop {
 			for _, tx := range list.Flatten() {
 				pool.removeTx(tx.Hash(), true)
+				pool.recordDrop(tx.Hash(), TxDropPoolLimit)
 			}
 			drop -= size
 			queuedRateLimitMeter.Mark(int64(size))
@@ -1496,6 +1822,7 @@ func (pool *TxPool) truncateQueue() {
 		txs := list.Flatten()
 		for i := len(txs) - 1; i >= 0 && drop > 0; i-- {
 			pool.removeTx(txs[i].Hash(), true)
+			pool.recordDrop(txs[i].Hash(), TxDropPoolLimit)
 			drop--
 			queuedRateLimitMeter.Mark(1)
 		}
@@ -1519,6 +1846,7 @@ func (pool *TxPool) demoteUnexecutables() {
 		for _, tx := range olds {
 			hash := tx.Hash()
 			pool.all.Remove(hash)
+			pool.recordStale(hash)
 			log.Trace("Removed old pending transaction", "hash", hash)
 		}
 		// Drop all transactions that are too costly (low balance or out of gas), and queue any invalids back for later
@@ -1527,6 +1855,7 @@ func (pool *TxPool) demoteUnexecutables() {
 			hash := tx.Hash()
 			log.Trace("Removed unpayable pending transaction", "hash", hash)
 			pool.all.Remove(hash)
+			pool.recordDrop(hash, TxDropUnpayable)
 		}
 		pendingNofundsMeter.Mark(int64(len(drops)))
 
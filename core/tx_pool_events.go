@@ -0,0 +1,92 @@
+// Copyright 2021 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package core
+
+import (
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// TxPoolEventKind is the type of a transaction lifecycle event of the pool.
+type TxPoolEventKind string
+
+const (
+	TxPoolEventAdded    TxPoolEventKind = "added"    // Transaction entered the pool
+	TxPoolEventReplaced TxPoolEventKind = "replaced" // Transaction was replaced by another one with the same nonce
+	TxPoolEventIncluded TxPoolEventKind = "included" // Transaction left the pool after being included in the chain
+	TxPoolEventDropped  TxPoolEventKind = "dropped"  // Transaction was removed from the pool without inclusion
+)
+
+// Reasons reported for the transactions dropped from the pool.
+const (
+	TxDropUnderpriced   = "underpriced"    // Evicted by better priced transactions or below the pool price
+	TxDropNonceTooLow   = "nonce-too-low"  // Nonce already used by the chain, a competing transaction got included
+	TxDropUnpayable     = "unpayable"      // Sender balance or block gas limit insufficient to execute the transaction
+	TxDropExpired       = "expired"        // Queued for longer than the configured lifetime
+	TxDropAccountLimit  = "account-limit"  // Exceeded the queue slots of the sender
+	TxDropPoolLimit     = "pool-limit"     // Exceeded the global slots of the pool
+	TxDropPolicy        = "policy"         // Rejected by a reloaded admission policy
+	TxDropReplaceFailed = "replace-failed" // Lost the promotion against an executable transaction with the same nonce
+)
+
+// TxPoolEvent is posted when a transaction enters, gets replaced in, gets mined
+// out of or is dropped from the transaction pool.
+type TxPoolEvent struct {
+	Hash       common.Hash     // Hash of the transaction the event is about
+	Kind       TxPoolEventKind // Type of the lifecycle event
+	Reason     string          // Reason of the drop, empty for other events
+	ReplacedBy common.Hash     // Hash of the replacement transaction, zero for other events
+	Private    bool            // Whether any of the transactions was submitted privately
+	Time       time.Time       // Time of the event
+}
+
+// txEventHistory is a bounded log of the most recent transaction lifecycle
+// events, discarding the oldest ones when full.
+type txEventHistory struct {
+	events []TxPoolEvent
+	head   int // Index of the oldest event, once the log is full
+	limit  int
+}
+
+func newTxEventHistory(limit int) *txEventHistory {
+	return &txEventHistory{limit: limit}
+}
+
+// add appends an event to the log, overwriting the oldest one if full.
+func (h *txEventHistory) add(ev TxPoolEvent) {
+	if h.limit <= 0 {
+		return
+	}
+	if len(h.events) < h.limit {
+		h.events = append(h.events, ev)
+		return
+	}
+	h.events[h.head] = ev
+	h.head = (h.head + 1) % h.limit
+}
+
+// get returns the retained events of a transaction, oldest first.
+func (h *txEventHistory) get(hash common.Hash) []TxPoolEvent {
+	var events []TxPoolEvent
+	for i := 0; i < len(h.events); i++ {
+		if ev := h.events[(h.head+i)%len(h.events)]; ev.Hash == hash {
+			events = append(events, ev)
+		}
+	}
+	return events
+}
@@ -0,0 +1,164 @@
+// Copyright 2021 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package core
+
+import (
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/core/state"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/event"
+	"github.com/ethereum/go-ethereum/params"
+	"github.com/ethereum/go-ethereum/trie"
+)
+
+// testInclusionChain is a test chain serving a head block including a set of
+// transactions, on top of the empty test chain head.
+type testInclusionChain struct {
+	*testBlockChain
+	block *types.Block
+}
+
+func (bc *testInclusionChain) GetBlock(hash common.Hash, number uint64) *types.Block {
+	if hash == bc.block.Hash() {
+		return bc.block
+	}
+	return bc.testBlockChain.GetBlock(hash, number)
+}
+
+// Tests that the lifecycle events of the transactions are delivered to the
+// subscribers and retained in the history of the pool.
+func TestTransactionPoolEvents(t *testing.T) {
+	t.Parallel()
+
+	statedb, _ := state.New(common.Hash{}, state.NewDatabase(rawdb.NewMemoryDatabase()), nil)
+	chain := &testInclusionChain{testBlockChain: &testBlockChain{10000000, statedb, new(event.Feed)}}
+
+	pool := NewTxPool(testTxPoolConfig, params.TestChainConfig, chain)
+	defer pool.Stop()
+	<-pool.initDoneCh
+
+	events := make(chan TxPoolEvent, 16)
+	sub := pool.SubscribeTxPoolEvent(events)
+	defer sub.Unsubscribe()
+
+	key, _ := crypto.GenerateKey()
+	addr := crypto.PubkeyToAddress(key.PublicKey)
+	testAddBalance(pool, addr, big.NewInt(1000000000000000000))
+
+	var (
+		tx0 = pricedTransaction(0, 100000, big.NewInt(1), key)
+		tx1 = pricedTransaction(0, 100000, big.NewInt(2), key)
+		tx2 = pricedTransaction(1, 100000, big.NewInt(1), key)
+	)
+	for _, tx := range []*types.Transaction{tx0, tx1, tx2} {
+		if err := pool.AddRemotesSync([]*types.Transaction{tx})[0]; err != nil {
+			t.Fatalf("failed to add transaction: %v", err)
+		}
+	}
+	// Drop one transaction by raising the price and mine the other one
+	pool.SetGasPrice(big.NewInt(2))
+
+	parent := chain.CurrentBlock().Header()
+	chain.block = types.NewBlock(&types.Header{ParentHash: parent.Hash(), Number: big.NewInt(1), GasLimit: parent.GasLimit, BaseFee: big.NewInt(1)}, types.Transactions{tx1}, nil, nil, trie.NewStackTrie(nil))
+
+	testSetNonce(pool, addr, 1)
+	<-pool.requestReset(parent, chain.block.Header())
+
+	want := []TxPoolEvent{
+		{Hash: tx0.Hash(), Kind: TxPoolEventAdded},
+		{Hash: tx0.Hash(), Kind: TxPoolEventReplaced, ReplacedBy: tx1.Hash()},
+		{Hash: tx1.Hash(), Kind: TxPoolEventAdded},
+		{Hash: tx2.Hash(), Kind: TxPoolEventAdded},
+		{Hash: tx2.Hash(), Kind: TxPoolEventDropped, Reason: TxDropUnderpriced},
+		{Hash: tx1.Hash(), Kind: TxPoolEventIncluded},
+	}
+	for i, w := range want {
+		select {
+		case ev := <-events:
+			if ev.Hash != w.Hash || ev.Kind != w.Kind || ev.Reason != w.Reason || ev.ReplacedBy != w.ReplacedBy {
+				t.Fatalf("event %d mismatch: have %+v, want %+v", i, ev, w)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("event %d missing", i)
+		}
+	}
+	select {
+	case ev := <-events:
+		t.Fatalf("unexpected event: %+v", ev)
+	case <-time.After(50 * time.Millisecond):
+	}
+	// Ensure the history retained the events of the individual transactions
+	history := pool.History(tx0.Hash())
+	if len(history) != 2 || history[0].Kind != TxPoolEventAdded || history[1].ReplacedBy != tx1.Hash() {
+		t.Fatalf("history mismatch: %+v", history)
+	}
+	if history := pool.History(common.Hash{}); len(history) != 0 {
+		t.Fatalf("history of unknown transaction: %+v", history)
+	}
+}
+
+// Tests that a subscriber not consuming the lifecycle events doesn't block the
+// pool from accepting transactions.
+func TestTransactionPoolEventsSlowSubscriber(t *testing.T) {
+	t.Parallel()
+
+	pool, key := setupTxPool()
+	defer pool.Stop()
+
+	sub := pool.SubscribeTxPoolEvent(make(chan TxPoolEvent))
+	defer sub.Unsubscribe()
+
+	testAddBalance(pool, crypto.PubkeyToAddress(key.PublicKey), big.NewInt(1000000000000000000))
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 2*txPoolEventChanSize; i++ {
+			pool.AddRemotesSync([]*types.Transaction{transaction(uint64(i), 100000, key)})
+		}
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("transaction pool blocked by slow event subscriber")
+	}
+}
+
+// Tests that the event history discards the oldest events once full.
+func TestTxEventHistory(t *testing.T) {
+	history := newTxEventHistory(3)
+	for i := 0; i < 5; i++ {
+		history.add(TxPoolEvent{Hash: common.Hash{byte(i % 2)}, Reason: string(rune('a' + i))})
+	}
+	if events := history.get(common.Hash{0}); len(events) != 2 || events[0].Reason != "c" || events[1].Reason != "e" {
+		t.Fatalf("history mismatch: %+v", events)
+	}
+	if events := history.get(common.Hash{1}); len(events) != 1 || events[0].Reason != "d" {
+		t.Fatalf("history mismatch: %+v", events)
+	}
+	disabled := newTxEventHistory(0)
+	disabled.add(TxPoolEvent{})
+	if events := disabled.get(common.Hash{}); len(events) != 0 {
+		t.Fatalf("disabled history retained events: %+v", events)
+	}
+}
@@ -0,0 +1,223 @@
+// Copyright 2021 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package core
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/mclock"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+var (
+	// ErrSenderNotAllowed is returned if the sender of a transaction is not on
+	// the allowlist of the pool.
+	ErrSenderNotAllowed = errors.New("sender not allowed")
+
+	// ErrRecipientBlocked is returned if a transaction calls a contract which is
+	// on the blocklist of the pool.
+	ErrRecipientBlocked = errors.New("recipient blocked")
+
+	// ErrSenderRateLimited is returned if the sender of a transaction exceeded
+	// the number of transactions it may submit in the current rate interval.
+	ErrSenderRateLimited = errors.New("sender rate limit exceeded")
+)
+
+// TxPoolPolicy is an admission policy of the transaction pool, deciding whether
+// a transaction may enter it. Policies are evaluated with the pool lock held,
+// after the transaction passed all the basic validity checks.
+type TxPoolPolicy interface {
+	// Name returns the identifier of the policy, reported in the rejections.
+	Name() string
+
+	// Check returns an error if the transaction from the given sender may not
+	// enter the pool.
+	Check(tx *types.Transaction, from common.Address, local bool) error
+}
+
+// txPoolQuota is implemented by the admission policies enforcing a quota on the
+// transactions submitted to the pool. Their checks only apply to new submissions,
+// not to transactions reinjected after a reorg or reloaded from the journal, and
+// only the submissions actually admitted into the pool count against the quota.
+type txPoolQuota interface {
+	TxPoolPolicy
+
+	// Admit counts a transaction from the given sender admitted into the pool.
+	Admit(tx *types.Transaction, from common.Address, local bool)
+}
+
+// TxPolicyError is returned if a transaction is rejected by an admission policy
+// of the pool. Over RPC, the policy and the reason are attached as error data.
+type TxPolicyError struct {
+	Policy string // Name of the rejecting policy
+	Err    error  // Reason of the rejection
+}
+
+func (e *TxPolicyError) Error() string {
+	return fmt.Sprintf("transaction rejected by %s policy: %v", e.Policy, e.Err)
+}
+
+func (e *TxPolicyError) Unwrap() error { return e.Err }
+
+// ErrorData returns the rejecting policy and the reason of the rejection.
+func (e *TxPolicyError) ErrorData() interface{} {
+	return map[string]string{"policy": e.Policy, "reason": e.Err.Error()}
+}
+
+// TxPolicyConfig are the configuration parameters of the built-in admission
+// policies of the transaction pool.
+type TxPolicyConfig struct {
+	AllowedSenders    []common.Address `toml:",omitempty"` // Senders allowed to submit transactions (empty = everyone)
+	BlockedRecipients []common.Address `toml:",omitempty"` // Contracts that transactions may not be sent to
+
+	SenderRateLimit    uint64        `toml:",omitempty"` // Maximum number of remote transactions accepted per sender and interval (0 = unlimited)
+	SenderRateInterval time.Duration `toml:",omitempty"` // Time interval over which the sender rate limit is enforced
+}
+
+// policies creates the built-in admission policies enabled in the config.
+func (config *TxPolicyConfig) policies(clock mclock.Clock) []TxPoolPolicy {
+	var policies []TxPoolPolicy
+	if len(config.AllowedSenders) > 0 {
+		policies = append(policies, newSenderAllowlist(config.AllowedSenders))
+	}
+	if len(config.BlockedRecipients) > 0 {
+		policies = append(policies, newRecipientBlocklist(config.BlockedRecipients))
+	}
+	if config.SenderRateLimit > 0 {
+		policies = append(policies, newSenderRateLimit(config.SenderRateLimit, config.SenderRateInterval, clock))
+	}
+	return policies
+}
+
+// senderAllowlist is an admission policy only accepting the transactions of a
+// fixed set of senders.
+type senderAllowlist map[common.Address]struct{}
+
+func newSenderAllowlist(senders []common.Address) senderAllowlist {
+	allowed := make(senderAllowlist, len(senders))
+	for _, sender := range senders {
+		allowed[sender] = struct{}{}
+	}
+	return allowed
+}
+
+func (p senderAllowlist) Name() string { return "sender-allowlist" }
+
+func (p senderAllowlist) Check(tx *types.Transaction, from common.Address, local bool) error {
+	if _, ok := p[from]; !ok {
+		return fmt.Errorf("%w: %v", ErrSenderNotAllowed, from)
+	}
+	return nil
+}
+
+// recipientBlocklist is an admission policy rejecting the transactions sent to
+// a fixed set of contracts.
+type recipientBlocklist map[common.Address]struct{}
+
+func newRecipientBlocklist(recipients []common.Address) recipientBlocklist {
+	blocked := make(recipientBlocklist, len(recipients))
+	for _, recipient := range recipients {
+		blocked[recipient] = struct{}{}
+	}
+	return blocked
+}
+
+func (p recipientBlocklist) Name() string { return "recipient-blocklist" }
+
+func (p recipientBlocklist) Check(tx *types.Transaction, from common.Address, local bool) error {
+	if to := tx.To(); to != nil {
+		if _, ok := p[*to]; ok {
+			return fmt.Errorf("%w: %v", ErrRecipientBlocked, *to)
+		}
+	}
+	return nil
+}
+
+// senderRateLimit is an admission policy capping the number of transactions a
+// remote sender may submit within fixed time windows. Local transactions are
+// exempt from the limit. It's a quota, so only admitted submissions are counted.
+type senderRateLimit struct {
+	limit    uint64
+	interval time.Duration
+	clock    mclock.Clock
+
+	windows map[common.Address]*rateWindow // Current rate window of each recent sender
+	pruned  mclock.AbsTime                 // Last time the expired windows were dropped
+}
+
+// rateWindow tracks the transactions accepted from a sender in a time window.
+type rateWindow struct {
+	start mclock.AbsTime
+	count uint64
+}
+
+func newSenderRateLimit(limit uint64, interval time.Duration, clock mclock.Clock) *senderRateLimit {
+	if interval <= 0 {
+		interval = time.Minute
+	}
+	return &senderRateLimit{
+		limit:    limit,
+		interval: interval,
+		clock:    clock,
+		windows:  make(map[common.Address]*rateWindow),
+		pruned:   clock.Now(),
+	}
+}
+
+func (p *senderRateLimit) Name() string { return "sender-ratelimit" }
+
+func (p *senderRateLimit) Check(tx *types.Transaction, from common.Address, local bool) error {
+	if local {
+		return nil
+	}
+	if window := p.window(from); window.count >= p.limit {
+		return fmt.Errorf("%w: %d transactions per %v", ErrSenderRateLimited, p.limit, p.interval)
+	}
+	return nil
+}
+
+func (p *senderRateLimit) Admit(tx *types.Transaction, from common.Address, local bool) {
+	if local {
+		return
+	}
+	p.window(from).count++
+}
+
+// window returns the current rate window of a sender, starting a new one if the
+// previous expired.
+func (p *senderRateLimit) window(from common.Address) *rateWindow {
+	now := p.clock.Now()
+
+	// Drop the expired windows every now and then to keep the memory bounded
+	if now.Sub(p.pruned) >= p.interval {
+		for sender, window := range p.windows {
+			if now.Sub(window.start) >= p.interval {
+				delete(p.windows, sender)
+			}
+		}
+		p.pruned = now
+	}
+	window := p.windows[from]
+	if window == nil || now.Sub(window.start) >= p.interval {
+		window = &rateWindow{start: now}
+		p.windows[from] = window
+	}
+	return window
+}
@@ -0,0 +1,179 @@
+// Copyright 2021 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package core
+
+import (
+	"errors"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/mclock"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/core/state"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/event"
+	"github.com/ethereum/go-ethereum/params"
+)
+
+// gasCapPolicy is a custom admission policy rejecting transactions over a gas cap.
+type gasCapPolicy uint64
+
+func (p gasCapPolicy) Name() string { return "gas-cap" }
+
+func (p gasCapPolicy) Check(tx *types.Transaction, from common.Address, local bool) error {
+	if tx.Gas() > uint64(p) {
+		return errors.New("gas over cap")
+	}
+	return nil
+}
+
+// Tests that the admission policies reject transactions with the reasons
+// reported, and that reloading them drops the no longer admitted ones.
+func TestTransactionPolicies(t *testing.T) {
+	t.Parallel()
+
+	statedb, _ := state.New(common.Hash{}, state.NewDatabase(rawdb.NewMemoryDatabase()), nil)
+	blockchain := &testBlockChain{1000000, statedb, new(event.Feed)}
+
+	config := testTxPoolConfig
+	config.Policies = []TxPoolPolicy{gasCapPolicy(200000)}
+	pool := NewTxPool(config, params.TestChainConfig, blockchain)
+	defer pool.Stop()
+
+	keyA, _ := crypto.GenerateKey()
+	keyB, _ := crypto.GenerateKey()
+	addrA, addrB := crypto.PubkeyToAddress(keyA.PublicKey), crypto.PubkeyToAddress(keyB.PublicKey)
+	testAddBalance(pool, addrA, big.NewInt(1000000000))
+	testAddBalance(pool, addrB, big.NewInt(1000000000))
+
+	if err := pool.AddRemote(transaction(0, 100000, keyA)); err != nil {
+		t.Fatalf("failed to add transaction: %v", err)
+	}
+	if err := pool.AddRemote(transaction(0, 100000, keyB)); err != nil {
+		t.Fatalf("failed to add transaction: %v", err)
+	}
+	// Custom policies should reject the transactions with their names
+	var perr *TxPolicyError
+	if err := pool.AddRemote(transaction(1, 300000, keyA)); !errors.As(err, &perr) || perr.Policy != "gas-cap" {
+		t.Fatalf("custom policy rejection mismatch: %v", err)
+	}
+	// Allow only one sender and ensure the other one is dropped and rejected
+	pool.SetPolicy(TxPolicyConfig{AllowedSenders: []common.Address{addrA}})
+	if pending, queued := pool.Stats(); pending+queued != 1 {
+		t.Fatalf("pooled transactions mismatch after allowlisting: have %d, want 1", pending+queued)
+	}
+	if err := pool.AddRemote(transaction(1, 100000, keyB)); !errors.Is(err, ErrSenderNotAllowed) {
+		t.Fatalf("allowlist rejection mismatch: have %v, want %v", err, ErrSenderNotAllowed)
+	}
+	if err := pool.AddLocal(transaction(1, 100000, keyB)); !errors.Is(err, ErrSenderNotAllowed) {
+		t.Fatalf("local allowlist rejection mismatch: have %v, want %v", err, ErrSenderNotAllowed)
+	}
+	if err := pool.AddRemote(transaction(1, 300000, keyA)); !errors.As(err, &perr) || perr.Policy != "gas-cap" {
+		t.Fatalf("custom policy dropped on reload: %v", err)
+	}
+	// Block the recipient of all the test transactions and ensure they're dropped
+	pool.SetPolicy(TxPolicyConfig{BlockedRecipients: []common.Address{{}}})
+	if pending, queued := pool.Stats(); pending != 0 || queued != 0 {
+		t.Fatalf("transactions remained after blocklisting: pending %d, queued %d", pending, queued)
+	}
+	err := pool.AddRemote(transaction(0, 100000, keyB))
+	if !errors.Is(err, ErrRecipientBlocked) || !errors.As(err, &perr) || perr.Policy != "recipient-blocklist" {
+		t.Fatalf("blocklist rejection mismatch: %v", err)
+	}
+	if data, ok := perr.ErrorData().(map[string]string); !ok || data["policy"] != "recipient-blocklist" {
+		t.Fatalf("error data mismatch: %v", perr.ErrorData())
+	}
+	if policy := pool.Policy(); len(policy.BlockedRecipients) != 1 || len(policy.AllowedSenders) != 0 {
+		t.Fatalf("policy config mismatch: %+v", policy)
+	}
+	if err := validateTxPoolInternals(pool); err != nil {
+		t.Fatalf("pool internal state corrupted: %v", err)
+	}
+}
+
+// Tests that the sender rate limit caps the remote transactions of every sender
+// within a time window.
+func TestSenderRateLimit(t *testing.T) {
+	var (
+		clock  = new(mclock.Simulated)
+		policy = newSenderRateLimit(2, time.Minute, clock)
+		key, _ = crypto.GenerateKey()
+		tx     = transaction(0, 100000, key)
+		addrA  = common.Address{0xaa}
+		addrB  = common.Address{0xbb}
+	)
+	for i := 0; i < 2; i++ {
+		if err := policy.Check(tx, addrA, false); err != nil {
+			t.Fatalf("transaction %d rejected: %v", i, err)
+		}
+		policy.Admit(tx, addrA, false)
+	}
+	if err := policy.Check(tx, addrA, false); !errors.Is(err, ErrSenderRateLimited) {
+		t.Fatalf("rate limit mismatch: have %v, want %v", err, ErrSenderRateLimited)
+	}
+	if err := policy.Check(tx, addrA, true); err != nil {
+		t.Fatalf("local transaction rate limited: %v", err)
+	}
+	if err := policy.Check(tx, addrB, false); err != nil {
+		t.Fatalf("other sender rate limited: %v", err)
+	}
+	// Start a new window and ensure the expired ones are dropped
+	clock.Run(time.Minute)
+	if err := policy.Check(tx, addrA, false); err != nil {
+		t.Fatalf("transaction rejected in new window: %v", err)
+	}
+	if len(policy.windows) != 1 {
+		t.Fatalf("expired windows retained: %d", len(policy.windows))
+	}
+}
+
+// Tests that only the remote submissions actually admitted into the pool count
+// against the sender rate limit.
+func TestSenderRateLimitAdmitted(t *testing.T) {
+	t.Parallel()
+
+	statedb, _ := state.New(common.Hash{}, state.NewDatabase(rawdb.NewMemoryDatabase()), nil)
+	blockchain := &testBlockChain{1000000, statedb, new(event.Feed)}
+
+	config := testTxPoolConfig
+	config.Policy = TxPolicyConfig{SenderRateLimit: 2, SenderRateInterval: time.Hour}
+	pool := NewTxPool(config, params.TestChainConfig, blockchain)
+	defer pool.Stop()
+
+	key, _ := crypto.GenerateKey()
+	testAddBalance(pool, crypto.PubkeyToAddress(key.PublicKey), big.NewInt(1000000000))
+
+	if err := pool.addRemoteSync(pricedTransaction(0, 100000, big.NewInt(10), key)); err != nil {
+		t.Fatalf("failed to add transaction: %v", err)
+	}
+	// Rejected replacements should not be counted
+	for i := 0; i < 3; i++ {
+		if err := pool.addRemoteSync(pricedTransaction(0, uint64(100001+i), big.NewInt(10), key)); !errors.Is(err, ErrReplaceUnderpriced) {
+			t.Fatalf("replacement %d: error mismatch: have %v, want %v", i, err, ErrReplaceUnderpriced)
+		}
+	}
+	// The second admitted transaction exhausts the quota
+	if err := pool.addRemoteSync(pricedTransaction(1, 100000, big.NewInt(10), key)); err != nil {
+		t.Fatalf("failed to add transaction: %v", err)
+	}
+	if err := pool.addRemoteSync(pricedTransaction(2, 100000, big.NewInt(10), key)); !errors.Is(err, ErrSenderRateLimited) {
+		t.Fatalf("rate limit mismatch: have %v, want %v", err, ErrSenderRateLimited)
+	}
+}
@@ -0,0 +1,152 @@
+// Copyright 2021 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package core
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/log"
+)
+
+// ErrPrivateTxExpired is returned if a private transaction is submitted with an
+// expiry block the chain already reached.
+var ErrPrivateTxExpired = errors.New("private transaction expired")
+
+// privateTxSet tracks the private transactions of the pool along with the last
+// block they may be included in. It has its own lock so the network handlers
+// can consult it without contending on the pool lock.
+type privateTxSet struct {
+	expiry map[common.Hash]uint64
+	lock   sync.RWMutex
+}
+
+func newPrivateTxSet() *privateTxSet {
+	return &privateTxSet{expiry: make(map[common.Hash]uint64)}
+}
+
+// add marks a transaction private until the given block, returning whether it
+// was already marked.
+func (s *privateTxSet) add(hash common.Hash, maxBlock uint64) bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	_, exists := s.expiry[hash]
+	s.expiry[hash] = maxBlock
+	return exists
+}
+
+func (s *privateTxSet) remove(hash common.Hash) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	delete(s.expiry, hash)
+}
+
+func (s *privateTxSet) contains(hash common.Hash) bool {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	_, exists := s.expiry[hash]
+	return exists
+}
+
+// filter returns the transactions of the list which are not private.
+func (s *privateTxSet) filter(txs types.Transactions) types.Transactions {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	if len(s.expiry) == 0 {
+		return txs
+	}
+	public := make(types.Transactions, 0, len(txs))
+	for _, tx := range txs {
+		if _, private := s.expiry[tx.Hash()]; !private {
+			public = append(public, tx)
+		}
+	}
+	return public
+}
+
+// AddPrivate enqueues a single transaction into the pool if it is valid, marking
+// it private: it is available to the locally built blocks, but never propagated
+// to the network nor exposed over RPC. The transaction is dropped if the chain
+// progresses past maxBlock without including it.
+//
+// The sender is not tracked as a local account, so full pricing constraints
+// apply and none of its transactions get journaled because of the submission.
+func (pool *TxPool) AddPrivate(tx *types.Transaction, maxBlock uint64) error {
+	if head := pool.chain.CurrentBlock().NumberU64(); maxBlock <= head {
+		return fmt.Errorf("%w: expiry block %d, head %d", ErrPrivateTxExpired, maxBlock, head)
+	}
+	// Mark the transaction before insertion, otherwise the promotion might
+	// announce it to the network before it's known to be private
+	hash := tx.Hash()
+	known := pool.privates.add(hash, maxBlock)
+
+	if err := pool.addTxs([]*types.Transaction{tx}, false, true, true)[0]; err != nil {
+		if !known {
+			pool.privates.remove(hash)
+		}
+		return err
+	}
+	return nil
+}
+
+// IsPrivate returns whether the transaction was submitted privately and must not
+// be propagated to the network.
+func (pool *TxPool) IsPrivate(hash common.Hash) bool {
+	return pool.privates.contains(hash)
+}
+
+// FilterPrivate returns the transactions of the list which were not submitted
+// privately.
+func (pool *TxPool) FilterPrivate(txs types.Transactions) types.Transactions {
+	return pool.privates.filter(txs)
+}
+
+// expirePrivates drops the private transactions which may no longer be included
+// on top of the given head and forgets about the ones already gone from the pool.
+//
+// Note, this method assumes the pool lock is held!
+func (pool *TxPool) expirePrivates(head uint64) {
+	expired := make(map[common.Hash]uint64)
+
+	pool.privates.lock.Lock()
+	for hash, maxBlock := range pool.privates.expiry {
+		if pool.all.Get(hash) == nil {
+			delete(pool.privates.expiry, hash)
+			continue
+		}
+		if head >= maxBlock {
+			expired[hash] = maxBlock
+		}
+	}
+	pool.privates.lock.Unlock()
+
+	// Drop the expired transactions while they are still marked private, so the
+	// recorded events are too
+	for hash, maxBlock := range expired {
+		log.Debug("Dropping expired private transaction", "hash", hash, "expiry", maxBlock, "head", head)
+		pool.removeTx(hash, true)
+		pool.recordDrop(hash, TxDropExpired)
+		pool.privates.remove(hash)
+	}
+}
@@ -0,0 +1,96 @@
+// Copyright 2021 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package core
+
+import (
+	"errors"
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// Tests that private transactions are tracked until their expiry block, kept
+// out of the journals and the local accounts, flagged in the lifecycle events
+// and dropped once the chain progresses past the expiry.
+func TestTransactionPrivate(t *testing.T) {
+	t.Parallel()
+
+	pool, key := setupTxPool()
+	defer pool.Stop()
+
+	addr := crypto.PubkeyToAddress(key.PublicKey)
+	testAddBalance(pool, addr, big.NewInt(1000000000000000000))
+
+	var (
+		tx0 = transaction(0, 100000, key)
+		tx1 = transaction(1, 100000, key)
+	)
+	if err := pool.AddPrivate(tx0, 0); !errors.Is(err, ErrPrivateTxExpired) {
+		t.Fatalf("expired private transaction error mismatch: have %v, want %v", err, ErrPrivateTxExpired)
+	}
+	if pool.IsPrivate(tx0.Hash()) {
+		t.Fatalf("rejected transaction marked private")
+	}
+	if err := pool.AddPrivate(tx0, 2); err != nil {
+		t.Fatalf("failed to add private transaction: %v", err)
+	}
+	if locals := pool.Locals(); len(locals) != 0 {
+		t.Fatalf("private transaction sender marked local: %v", locals)
+	}
+	if err := pool.AddLocal(tx1); err != nil {
+		t.Fatalf("failed to add local transaction: %v", err)
+	}
+	if !pool.IsPrivate(tx0.Hash()) || pool.IsPrivate(tx1.Hash()) {
+		t.Fatalf("private flags mismatch")
+	}
+	if pending, _ := pool.Stats(); pending != 2 {
+		t.Fatalf("pending transactions mismatch: have %d, want 2", pending)
+	}
+	// Ensure only the public transaction would be journaled
+	pool.mu.RLock()
+	locals := pool.local()[addr]
+	pool.mu.RUnlock()
+	if len(locals) != 1 || locals[0].Hash() != tx1.Hash() {
+		t.Fatalf("journaled transactions mismatch: %v", locals)
+	}
+	// Progress the chain to the expiry and ensure the private transaction is dropped
+	head := &types.Header{Number: big.NewInt(2), GasLimit: 10000000, BaseFee: big.NewInt(1)}
+	<-pool.requestReset(nil, head)
+
+	if pool.Has(tx0.Hash()) || pool.IsPrivate(tx0.Hash()) {
+		t.Fatalf("expired private transaction retained")
+	}
+	history := pool.History(tx0.Hash())
+	if len(history) == 0 || history[len(history)-1].Reason != TxDropExpired {
+		t.Fatalf("expiry event missing: %+v", history)
+	}
+	for _, ev := range history {
+		if !ev.Private {
+			t.Fatalf("private transaction event not flagged: %+v", ev)
+		}
+	}
+	for _, ev := range pool.History(tx1.Hash()) {
+		if ev.Private {
+			t.Fatalf("public transaction event flagged private: %+v", ev)
+		}
+	}
+	if err := validateTxPoolInternals(pool); err != nil {
+		t.Fatalf("pool internal state corrupted: %v", err)
+	}
+}
@@ -24,6 +24,7 @@ import (
 	"math/big"
 	"math/rand"
 	"os"
+	"path/filepath"
 	"sync/atomic"
 	"testing"
 	"time"
@@ -2347,6 +2348,80 @@ func testTransactionJournaling(t *testing.T, nolocals bool) {
 	pool.Stop()
 }
 
+// Tests that remote transactions are journaled on shutdown and revalidated when
+// reinjected on startup, with their age retained and the expired ones dropped.
+func TestTransactionRemoteJournaling(t *testing.T) {
+	t.Parallel()
+
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatalf("failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	statedb, _ := state.New(common.Hash{}, state.NewDatabase(rawdb.NewMemoryDatabase()), nil)
+	blockchain := &testBlockChain{1000000, statedb, new(event.Feed)}
+
+	config := testTxPoolConfig
+	config.RemoteJournal = filepath.Join(dir, "remotes.rlp")
+	config.RemoteJournalAge = time.Hour
+
+	pool := NewTxPool(config, params.TestChainConfig, blockchain)
+
+	fresh, _ := crypto.GenerateKey()
+	stale, _ := crypto.GenerateKey()
+	testAddBalance(pool, crypto.PubkeyToAddress(fresh.PublicKey), big.NewInt(1000000000))
+	testAddBalance(pool, crypto.PubkeyToAddress(stale.PublicKey), big.NewInt(1000000000))
+
+	// Add two executable and a gapped remote transaction, plus one too old to journal
+	seen := time.Now().Add(-time.Minute).Truncate(time.Second)
+	txs := []*types.Transaction{
+		transaction(0, 100000, fresh),
+		transaction(1, 100000, fresh),
+		transaction(3, 100000, fresh),
+	}
+	for _, tx := range txs {
+		tx.SetTime(seen)
+	}
+	expired := transaction(0, 100000, stale)
+	expired.SetTime(time.Now().Add(-2 * time.Hour))
+
+	for _, err := range pool.AddRemotesSync(append(txs, expired)) {
+		if err != nil {
+			t.Fatalf("failed to add remote transaction: %v", err)
+		}
+	}
+	if pending, queued := pool.Stats(); pending != 3 || queued != 1 {
+		t.Fatalf("transactions mismatched: have %d/%d, want %d/%d", pending, queued, 3, 1)
+	}
+	// Terminate the pool, include the first transaction and restart it
+	pool.Stop()
+	statedb.SetNonce(crypto.PubkeyToAddress(fresh.PublicKey), 1)
+	blockchain = &testBlockChain{1000000, statedb, new(event.Feed)}
+
+	pool = NewTxPool(config, params.TestChainConfig, blockchain)
+	defer pool.Stop()
+	<-pool.requestReset(nil, nil)
+
+	if pending, queued := pool.Stats(); pending != 1 || queued != 1 {
+		t.Fatalf("transactions mismatched: have %d/%d, want %d/%d", pending, queued, 1, 1)
+	}
+	for _, tx := range txs[1:] {
+		if have := pool.Get(tx.Hash()); have == nil || !have.Time().Equal(seen) {
+			t.Fatalf("transaction %x not restored with its age", tx.Hash())
+		}
+	}
+	if pool.Has(expired.Hash()) {
+		t.Fatalf("expired transaction restored")
+	}
+	if len(pool.Locals()) != 0 {
+		t.Fatalf("remote transactions restored as local")
+	}
+	if err := validateTxPoolInternals(pool); err != nil {
+		t.Fatalf("pool internal state corrupted: %v", err)
+	}
+}
+
 // TestTransactionStatusCheck tests that the pool can correctly retrieve the
 // pending status of individual transactions.
 func TestTransactionStatusCheck(t *testing.T) {
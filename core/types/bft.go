@@ -0,0 +1,83 @@
+// Copyright 2021 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package types
+
+import (
+	"errors"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/rlp"
+)
+
+var (
+	// BFTDigest is the mix digest of every block sealed by the BFT consensus
+	// engine ("ctical byzantine fault tolerance" in ASCII).
+	BFTDigest = common.HexToHash("0x63746963616c2062797a616e74696e65206661756c7420746f6c6572616e6365")
+
+	// BFTExtraVanity is the fixed number of extra-data prefix bytes reserved for
+	// the validator vanity.
+	BFTExtraVanity = 32
+
+	// ErrInvalidBFTHeaderExtra is returned if the extra-data of a BFT header
+	// can't be decoded.
+	ErrInvalidBFTHeaderExtra = errors.New("invalid bft header extra-data")
+)
+
+// BFTExtra is the consensus data stored in the extra-data of a BFT header after
+// the vanity prefix.
+type BFTExtra struct {
+	Validators    []common.Address // Validator set, only present on checkpoint blocks
+	Seal          []byte           // Signature of the proposer over the seal hash
+	CommittedSeal [][]byte         // Signatures of the validators committing the block
+}
+
+// ExtractBFTExtra extracts all values of the BFTExtra from the header. It
+// returns an error if the length of the given extra-data is less than the
+// vanity or the rest can't be decoded.
+func ExtractBFTExtra(h *Header) (*BFTExtra, error) {
+	if len(h.Extra) < BFTExtraVanity {
+		return nil, ErrInvalidBFTHeaderExtra
+	}
+	extra := new(BFTExtra)
+	if err := rlp.DecodeBytes(h.Extra[BFTExtraVanity:], extra); err != nil {
+		return nil, err
+	}
+	return extra, nil
+}
+
+// BFTFilteredHeader returns a filtered header which some information (like
+// the seal and committed seals) are cleared to fulfill the BFT hash rules. It
+// returns nil if the extra-data cannot be decoded.
+func BFTFilteredHeader(h *Header, keepSeal bool) *Header {
+	extra, err := ExtractBFTExtra(h)
+	if err != nil {
+		return nil
+	}
+	if !keepSeal {
+		extra.Seal = []byte{}
+	}
+	extra.CommittedSeal = [][]byte{}
+
+	payload, err := rlp.EncodeToBytes(extra)
+	if err != nil {
+		return nil
+	}
+	cpy := CopyHeader(h)
+	cpy.Extra = append(cpy.Extra[:BFTExtraVanity:BFTExtraVanity], payload...)
+
+	return cpy
+}
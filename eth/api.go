@@ -33,7 +33,10 @@ import (
 	"github.com/ethereum/go-ethereum/core"
 	"github.com/ethereum/go-ethereum/core/rawdb"
 	"github.com/ethereum/go-ethereum/core/state"
+	"github.com/ethereum/go-ethereum/core/state/snapshot"
+	"github.com/ethereum/go-ethereum/core/stateless"
 	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
 	"github.com/ethereum/go-ethereum/internal/ethapi"
 	"github.com/ethereum/go-ethereum/log"
 	"github.com/ethereum/go-ethereum/rlp"
@@ -257,6 +260,50 @@ func (api *PrivateAdminAPI) ImportChain(file string) (bool, error) {
 	return true, nil
 }
 
+// TxPoolPolicyArgs is the configuration of the built-in admission policies of
+// the transaction pool, as exchanged over RPC.
+type TxPoolPolicyArgs struct {
+	AllowedSenders     []common.Address `json:"allowedSenders"`
+	BlockedRecipients  []common.Address `json:"blockedRecipients"`
+	SenderRateLimit    uint64           `json:"senderRateLimit"`
+	SenderRateInterval string           `json:"senderRateInterval"` // Duration string, e.g. "1m30s"
+}
+
+// TxPoolPolicy retrieves the configuration of the built-in admission policies of
+// the transaction pool.
+func (api *PrivateAdminAPI) TxPoolPolicy() TxPoolPolicyArgs {
+	policy := api.eth.TxPool().Policy()
+	return TxPoolPolicyArgs{
+		AllowedSenders:     policy.AllowedSenders,
+		BlockedRecipients:  policy.BlockedRecipients,
+		SenderRateLimit:    policy.SenderRateLimit,
+		SenderRateInterval: policy.SenderRateInterval.String(),
+	}
+}
+
+// SetTxPoolPolicy replaces the built-in admission policies of the transaction
+// pool, dropping the pooled remote transactions not admitted by the new ones.
+func (api *PrivateAdminAPI) SetTxPoolPolicy(args TxPoolPolicyArgs) (bool, error) {
+	policy := core.TxPolicyConfig{
+		AllowedSenders:     args.AllowedSenders,
+		BlockedRecipients:  args.BlockedRecipients,
+		SenderRateLimit:    args.SenderRateLimit,
+		SenderRateInterval: api.eth.TxPool().Policy().SenderRateInterval,
+	}
+	if args.SenderRateInterval != "" {
+		interval, err := time.ParseDuration(args.SenderRateInterval)
+		if err != nil {
+			return false, fmt.Errorf("invalid sender rate interval: %v", err)
+		}
+		if interval <= 0 {
+			return false, errors.New("sender rate interval must be positive")
+		}
+		policy.SenderRateInterval = interval
+	}
+	api.eth.TxPool().SetPolicy(policy)
+	return true, nil
+}
+
 // PublicDebugAPI is the collection of Ethereum full node APIs exposed
 // over the public debugging endpoint.
 type PublicDebugAPI struct {
@@ -312,7 +359,7 @@ func NewPrivateDebugAPI(eth *Ethereum) *PrivateDebugAPI {
 
 // Preimage is a debug API function that returns the preimage for a sha3 hash, if known.
 func (api *PrivateDebugAPI) Preimage(ctx context.Context, hash common.Hash) (hexutil.Bytes, error) {
-	if preimage := rawdb.ReadPreimage(api.eth.ChainDb(), hash); preimage != nil {
+	if preimage := api.eth.blockchain.Preimage(hash); preimage != nil {
 		return preimage, nil
 	}
 	return nil, errors.New("unknown preimage")
@@ -464,6 +511,220 @@ func storageRangeAt(st state.Trie, start []byte, maxResult int) (StorageRangeRes
 	return result, nil
 }
 
+// StorageRange returns the storage of the given account at the post state of a
+// block, starting at the given hashed key. The range is served from the snapshot
+// if it covers the state, and from the storage trie otherwise.
+func (api *PrivateDebugAPI) StorageRange(ctx context.Context, address common.Address, blockNrOrHash rpc.BlockNumberOrHash, keyStart hexutil.Bytes, maxResult int) (StorageRangeResult, error) {
+	header, err := api.eth.APIBackend.HeaderByNumberOrHash(ctx, blockNrOrHash)
+	if err != nil {
+		return StorageRangeResult{}, err
+	}
+	if header == nil {
+		return StorageRangeResult{}, errors.New("block not found")
+	}
+	if maxResult > AccountRangeMaxResults || maxResult <= 0 {
+		maxResult = AccountRangeMaxResults
+	}
+	if result, err := api.snapshotStorageRange(header.Root, address, keyStart, maxResult); err == nil {
+		return result, nil
+	}
+	statedb, err := api.eth.blockchain.StateAt(header.Root)
+	if err != nil {
+		return StorageRangeResult{}, err
+	}
+	st := statedb.StorageTrie(address)
+	if st == nil {
+		return StorageRangeResult{}, fmt.Errorf("account %x doesn't exist", address)
+	}
+	return storageRangeAt(st, keyStart, maxResult)
+}
+
+// snapshotStorageRange returns a storage range of an account from the snapshot,
+// failing if the snapshot doesn't cover the requested state.
+func (api *PrivateDebugAPI) snapshotStorageRange(root common.Hash, address common.Address, start []byte, maxResult int) (StorageRangeResult, error) {
+	snaps := api.eth.blockchain.Snapshots()
+	if snaps == nil {
+		return StorageRangeResult{}, errors.New("snapshots disabled")
+	}
+	snap := snaps.Snapshot(root)
+	if snap == nil {
+		return StorageRangeResult{}, fmt.Errorf("snapshot %x not found", root)
+	}
+	addrHash := crypto.Keccak256Hash(address[:])
+	account, err := snap.Account(addrHash)
+	if err != nil {
+		return StorageRangeResult{}, err
+	}
+	if account == nil {
+		return StorageRangeResult{}, fmt.Errorf("account %x doesn't exist", address)
+	}
+	it, err := snaps.StorageIterator(root, addrHash, rangeSeek(start))
+	if err != nil {
+		return StorageRangeResult{}, err
+	}
+	defer it.Release()
+
+	return snapshotStorageRangeAt(it, api.eth.blockchain.StateCache().TrieDB(), maxResult)
+}
+
+func snapshotStorageRangeAt(it snapshot.StorageIterator, triedb *trie.Database, maxResult int) (StorageRangeResult, error) {
+	result := StorageRangeResult{Storage: storageMap{}}
+	for i := 0; i < maxResult && it.Next(); i++ {
+		_, content, _, err := rlp.Split(it.Slot())
+		if err != nil {
+			return StorageRangeResult{}, err
+		}
+		e := storageEntry{Value: common.BytesToHash(content)}
+		if preimage := triedb.Preimage(it.Hash()); preimage != nil {
+			preimage := common.BytesToHash(preimage)
+			e.Key = &preimage
+		}
+		result.Storage[it.Hash()] = e
+	}
+	// Add the 'next key' so clients can continue downloading.
+	if it.Next() {
+		next := it.Hash()
+		result.NextKey = &next
+	}
+	if err := it.Error(); err != nil {
+		return StorageRangeResult{}, err
+	}
+	return result, nil
+}
+
+// AccountRangeResult is the result of a debug_stateAccountRange API call.
+type AccountRangeResult struct {
+	Accounts accountMap   `json:"accounts"`
+	NextKey  *common.Hash `json:"nextKey"` // nil if Accounts includes the last account in the state.
+}
+
+type accountMap map[common.Hash]accountEntry
+
+type accountEntry struct {
+	Address     *common.Address `json:"address"`
+	Nonce       hexutil.Uint64  `json:"nonce"`
+	Balance     *hexutil.Big    `json:"balance"`
+	StorageRoot common.Hash     `json:"storageRoot"`
+	CodeHash    common.Hash     `json:"codeHash"`
+}
+
+// StateAccountRange returns the accounts at the post state of a block, starting
+// at the given account hash. Unlike AccountRange, the accounts are listed without
+// their code and storage, which allows serving them from the snapshot if it
+// covers the state. The account trie is iterated otherwise.
+func (api *PrivateDebugAPI) StateAccountRange(ctx context.Context, blockNrOrHash rpc.BlockNumberOrHash, start hexutil.Bytes, maxResults int) (AccountRangeResult, error) {
+	header, err := api.eth.APIBackend.HeaderByNumberOrHash(ctx, blockNrOrHash)
+	if err != nil {
+		return AccountRangeResult{}, err
+	}
+	if header == nil {
+		return AccountRangeResult{}, errors.New("block not found")
+	}
+	if maxResults > AccountRangeMaxResults || maxResults <= 0 {
+		maxResults = AccountRangeMaxResults
+	}
+	if snaps := api.eth.blockchain.Snapshots(); snaps != nil {
+		if it, err := snaps.AccountIterator(header.Root, rangeSeek(start)); err == nil {
+			result, err := snapshotAccountRange(it, api.eth.blockchain.StateCache().TrieDB(), maxResults)
+			it.Release()
+			if err == nil {
+				return result, nil
+			}
+		}
+	}
+	tr, err := api.eth.blockchain.StateCache().OpenTrie(header.Root)
+	if err != nil {
+		return AccountRangeResult{}, err
+	}
+	return accountRange(tr, start, maxResults)
+}
+
+func snapshotAccountRange(it snapshot.AccountIterator, triedb *trie.Database, maxResults int) (AccountRangeResult, error) {
+	result := AccountRangeResult{Accounts: accountMap{}}
+	for i := 0; i < maxResults && it.Next(); i++ {
+		account, err := snapshot.FullAccount(it.Account())
+		if err != nil {
+			return AccountRangeResult{}, err
+		}
+		e := accountEntry{
+			Nonce:       hexutil.Uint64(account.Nonce),
+			Balance:     (*hexutil.Big)(account.Balance),
+			StorageRoot: common.BytesToHash(account.Root),
+			CodeHash:    common.BytesToHash(account.CodeHash),
+		}
+		if preimage := triedb.Preimage(it.Hash()); preimage != nil {
+			addr := common.BytesToAddress(preimage)
+			e.Address = &addr
+		}
+		result.Accounts[it.Hash()] = e
+	}
+	// Add the 'next key' so clients can continue downloading.
+	if it.Next() {
+		next := it.Hash()
+		result.NextKey = &next
+	}
+	if err := it.Error(); err != nil {
+		return AccountRangeResult{}, err
+	}
+	return result, nil
+}
+
+func accountRange(tr state.Trie, start []byte, maxResults int) (AccountRangeResult, error) {
+	it := trie.NewIterator(tr.NodeIterator(start))
+	result := AccountRangeResult{Accounts: accountMap{}}
+	for i := 0; i < maxResults && it.Next(); i++ {
+		var account types.StateAccount
+		if err := rlp.DecodeBytes(it.Value, &account); err != nil {
+			return AccountRangeResult{}, err
+		}
+		e := accountEntry{
+			Nonce:       hexutil.Uint64(account.Nonce),
+			Balance:     (*hexutil.Big)(account.Balance),
+			StorageRoot: account.Root,
+			CodeHash:    common.BytesToHash(account.CodeHash),
+		}
+		if preimage := tr.GetKey(it.Key); preimage != nil {
+			addr := common.BytesToAddress(preimage)
+			e.Address = &addr
+		}
+		result.Accounts[common.BytesToHash(it.Key)] = e
+	}
+	// Add the 'next key' so clients can continue downloading.
+	if it.Next() {
+		next := common.BytesToHash(it.Key)
+		result.NextKey = &next
+	}
+	if it.Err != nil {
+		return AccountRangeResult{}, it.Err
+	}
+	return result, nil
+}
+
+// rangeSeek converts a possibly partial start key of a range request into the
+// hash to seek the snapshot iterators to, matching the trie iterator positioning.
+func rangeSeek(start []byte) common.Hash {
+	return common.BytesToHash(common.RightPadBytes(start, common.HashLength))
+}
+
+// ExecutionWitness re-executes the block with the given hash on top of its parent
+// state and returns the RLP encoded witness of the execution: every trie node,
+// contract code and ancestor header accessed. The witness is enough to execute
+// the block again statelessly and verify its post state root.
+func (api *PrivateDebugAPI) ExecutionWitness(blockHash common.Hash) (hexutil.Bytes, error) {
+	block := api.eth.blockchain.GetBlockByHash(blockHash)
+	if block == nil {
+		return nil, fmt.Errorf("block %#x not found", blockHash)
+	}
+	if block.NumberU64() == 0 {
+		return nil, errors.New("genesis is not executable")
+	}
+	witness, err := stateless.Generate(api.eth.blockchain, block)
+	if err != nil {
+		return nil, err
+	}
+	return rlp.EncodeToBytes(witness)
+}
+
 // GetModifiedAccountsByNumber returns all accounts that have changed between the
 // two blocks specified. A change is defined as a difference in nonce, balance,
 // code hash, or storage hash.
@@ -547,6 +808,150 @@ func (api *PrivateDebugAPI) getModifiedAccounts(startBlock, endBlock *types.Bloc
 	return dirty, nil
 }
 
+// StateDiffResult is a page of the differences between two states.
+type StateDiffResult struct {
+	Accounts []AccountDiffResult `json:"accounts"`
+	Next     *common.Hash        `json:"next"` // nil if the diff is complete
+}
+
+// AccountDiffResult is the difference of a single account between two states.
+// The old or new account is nil if it doesn't exist in the respective state.
+type AccountDiffResult struct {
+	Hash        common.Hash         `json:"hash"`
+	Address     *common.Address     `json:"address,omitempty"` // nil if the preimage is unknown
+	Old         *DiffAccount        `json:"old"`
+	New         *DiffAccount        `json:"new"`
+	Storage     []StorageDiffResult `json:"storage"`
+	NextStorage *common.Hash        `json:"nextStorage"` // nil if the storage diff is complete
+}
+
+// StorageDiffPageResult is a page of the differences between the storage of an
+// account in two states.
+type StorageDiffPageResult struct {
+	Storage []StorageDiffResult `json:"storage"`
+	Next    *common.Hash        `json:"next"` // nil if the diff is complete
+}
+
+// DiffAccount is an account as present in one of the diffed states.
+type DiffAccount struct {
+	Nonce       hexutil.Uint64 `json:"nonce"`
+	Balance     *hexutil.Big   `json:"balance"`
+	StorageRoot common.Hash    `json:"storageRoot"`
+	CodeHash    common.Hash    `json:"codeHash"`
+}
+
+// StorageDiffResult is the difference of a single storage slot between two
+// states, with zero values standing for missing slots.
+type StorageDiffResult struct {
+	Hash common.Hash  `json:"hash"`
+	Key  *common.Hash `json:"key,omitempty"` // nil if the preimage is unknown
+	Old  common.Hash  `json:"old"`
+	New  common.Hash  `json:"new"`
+}
+
+// StateDiff returns the accounts and storage slots differing between the states
+// with the given roots, starting at the given account hash. At most maxResults
+// accounts are returned, along with the hash to continue from if there's more.
+// The storage differences of each account are limited to maxResults slots too,
+// the rest can be retrieved via StateDiffStorage. The snapshot diff layers are
+// used if they link the two states, the tries are walked otherwise.
+func (api *PrivateDebugAPI) StateDiff(from, to common.Hash, start *common.Hash, maxResults int) (*StateDiffResult, error) {
+	if maxResults > AccountRangeMaxResults || maxResults <= 0 {
+		maxResults = AccountRangeMaxResults
+	}
+	var origin common.Hash
+	if start != nil {
+		origin = *start
+	}
+	diff, err := state.DiffStates(api.eth.blockchain.StateCache(), api.eth.blockchain.Snapshots(), from, to, origin, maxResults)
+	if err != nil {
+		return nil, err
+	}
+	result := &StateDiffResult{Accounts: make([]AccountDiffResult, 0, len(diff.Accounts)), Next: diff.Next}
+	for _, account := range diff.Accounts {
+		res := AccountDiffResult{
+			Hash:        account.Hash,
+			Old:         newDiffAccount(account.Old),
+			New:         newDiffAccount(account.New),
+			Storage:     api.newStorageDiffResults(account.Storage),
+			NextStorage: account.NextSlot,
+		}
+		if preimage := api.eth.blockchain.Preimage(account.Hash); preimage != nil {
+			addr := common.BytesToAddress(preimage)
+			res.Address = &addr
+		}
+		result.Accounts = append(result.Accounts, res)
+	}
+	return result, nil
+}
+
+// StateDiffStorage returns the storage slots of an account differing between the
+// states with the given roots, starting at the given slot hash. At most
+// maxResults slots are returned, along with the hash to continue from if there's
+// more.
+func (api *PrivateDebugAPI) StateDiffStorage(from, to common.Hash, account common.Hash, start *common.Hash, maxResults int) (*StorageDiffPageResult, error) {
+	if maxResults > AccountRangeMaxResults || maxResults <= 0 {
+		maxResults = AccountRangeMaxResults
+	}
+	var origin common.Hash
+	if start != nil {
+		origin = *start
+	}
+	diff, err := state.DiffStorage(api.eth.blockchain.StateCache(), api.eth.blockchain.Snapshots(), from, to, account, origin, maxResults)
+	if err != nil {
+		return nil, err
+	}
+	return &StorageDiffPageResult{Storage: api.newStorageDiffResults(diff.Slots), Next: diff.Next}, nil
+}
+
+// newStorageDiffResults converts the storage slot differences into their RPC
+// representation, resolving the slot keys if their preimages are known.
+func (api *PrivateDebugAPI) newStorageDiffResults(slots []*state.SlotDiff) []StorageDiffResult {
+	results := make([]StorageDiffResult, 0, len(slots))
+	for _, slot := range slots {
+		storage := StorageDiffResult{Hash: slot.Hash, Old: slot.Old, New: slot.New}
+		if preimage := api.eth.blockchain.Preimage(slot.Hash); preimage != nil {
+			key := common.BytesToHash(preimage)
+			storage.Key = &key
+		}
+		results = append(results, storage)
+	}
+	return results
+}
+
+// StateDiffByNumber returns the accounts and storage slots differing between the
+// post states of the given blocks. See StateDiff for the details.
+func (api *PrivateDebugAPI) StateDiffByNumber(from, to rpc.BlockNumber, start *common.Hash, maxResults int) (*StateDiffResult, error) {
+	fromHeader, err := api.eth.APIBackend.HeaderByNumber(context.Background(), from)
+	if err != nil {
+		return nil, err
+	}
+	if fromHeader == nil {
+		return nil, fmt.Errorf("block #%d not found", from)
+	}
+	toHeader, err := api.eth.APIBackend.HeaderByNumber(context.Background(), to)
+	if err != nil {
+		return nil, err
+	}
+	if toHeader == nil {
+		return nil, fmt.Errorf("block #%d not found", to)
+	}
+	return api.StateDiff(fromHeader.Root, toHeader.Root, start, maxResults)
+}
+
+// newDiffAccount converts a state account into its RPC representation.
+func newDiffAccount(account *types.StateAccount) *DiffAccount {
+	if account == nil {
+		return nil
+	}
+	return &DiffAccount{
+		Nonce:       hexutil.Uint64(account.Nonce),
+		Balance:     (*hexutil.Big)(account.Balance),
+		StorageRoot: account.Root,
+		CodeHash:    common.BytesToHash(account.CodeHash),
+	}
+}
+
 // GetAccessibleState returns the first number where the node has accessible
 // state on disk. Note this being the post-state of that block and the pre-state
 // of the next block.
@@ -46,6 +46,7 @@ type EthAPIBackend struct {
 	allowUnprotectedTxs bool
 	eth                 *Ethereum
 	gpo                 *gasprice.Oracle
+	prover              *state.Prover
 }
 
 // ChainConfig returns the active chain configuration.
@@ -100,7 +101,7 @@ func (b *EthAPIBackend) BlockByNumber(ctx context.Context, number rpc.BlockNumbe
 	// Pending block is only known by the miner
 	if number == rpc.PendingBlockNumber {
 		block := b.eth.miner.PendingBlock()
-		return block, nil
+		return b.filterPrivateBlock(block), nil
 	}
 	// Otherwise resolve and return the block
 	if number == rpc.LatestBlockNumber {
@@ -152,7 +153,7 @@ func (b *EthAPIBackend) StateAndHeaderByNumber(ctx context.Context, number rpc.B
 	if header == nil {
 		return nil, nil, errors.New("header not found")
 	}
-	stateDb, err := b.eth.BlockChain().StateAt(header.Root)
+	stateDb, err := b.stateAt(header)
 	return stateDb, header, err
 }
 
@@ -171,12 +172,33 @@ func (b *EthAPIBackend) StateAndHeaderByNumberOrHash(ctx context.Context, blockN
 		if blockNrOrHash.RequireCanonical && b.eth.blockchain.GetCanonicalHash(header.Number.Uint64()) != hash {
 			return nil, nil, errors.New("hash is not currently canonical")
 		}
-		stateDb, err := b.eth.BlockChain().StateAt(header.Root)
+		stateDb, err := b.stateAt(header)
 		return stateDb, header, err
 	}
 	return nil, nil, errors.New("invalid arguments; neither block nor hash specified")
 }
 
+// stateAt returns the state of the given block, resolving it from the state
+// archive if the tries of the block are not available anymore. While the archive
+// is still being seeded, that is reported instead of the missing tries.
+func (b *EthAPIBackend) stateAt(header *types.Header) (*state.StateDB, error) {
+	stateDb, err := b.eth.BlockChain().StateAt(header.Root)
+	if err != nil {
+		archived, archErr := b.eth.BlockChain().ArchivedStateAt(header)
+		if archErr == nil {
+			return archived, nil
+		}
+		if errors.Is(archErr, core.ErrArchiveSeeding) {
+			return nil, archErr
+		}
+	}
+	return stateDb, err
+}
+
+func (b *EthAPIBackend) StateProver() *state.Prover {
+	return b.prover
+}
+
 func (b *EthAPIBackend) GetReceipts(ctx context.Context, hash common.Hash) (types.Receipts, error) {
 	return b.eth.blockchain.GetReceiptsByHash(hash), nil
 }
@@ -239,16 +261,36 @@ func (b *EthAPIBackend) SendTx(ctx context.Context, signedTx *types.Transaction)
 	return b.eth.txPool.AddLocal(signedTx)
 }
 
+func (b *EthAPIBackend) SendPrivateTx(ctx context.Context, signedTx *types.Transaction, maxBlock uint64) error {
+	return b.eth.txPool.AddPrivate(signedTx, maxBlock)
+}
+
+func (b *EthAPIBackend) SendBundle(ctx context.Context, txs types.Transactions, blockNumber uint64, minTimestamp uint64, maxTimestamp uint64) (common.Hash, error) {
+	bundle := &miner.Bundle{
+		Txs:          txs,
+		BlockNumber:  blockNumber,
+		MinTimestamp: minTimestamp,
+		MaxTimestamp: maxTimestamp,
+	}
+	if err := b.eth.Miner().AddBundle(bundle); err != nil {
+		return common.Hash{}, err
+	}
+	return bundle.Hash(), nil
+}
+
 func (b *EthAPIBackend) GetPoolTransactions() (types.Transactions, error) {
 	pending := b.eth.txPool.Pending(false)
 	var txs types.Transactions
 	for _, batch := range pending {
-		txs = append(txs, batch...)
+		txs = append(txs, b.eth.txPool.FilterPrivate(batch)...)
 	}
 	return txs, nil
 }
 
 func (b *EthAPIBackend) GetPoolTransaction(hash common.Hash) *types.Transaction {
+	if b.eth.txPool.IsPrivate(hash) {
+		return nil
+	}
 	return b.eth.txPool.Get(hash)
 }
 
@@ -266,11 +308,40 @@ func (b *EthAPIBackend) Stats() (pending int, queued int) {
 }
 
 func (b *EthAPIBackend) TxPoolContent() (map[common.Address]types.Transactions, map[common.Address]types.Transactions) {
-	return b.eth.TxPool().Content()
+	pending, queued := b.eth.TxPool().Content()
+	return b.filterPrivate(pending), b.filterPrivate(queued)
 }
 
 func (b *EthAPIBackend) TxPoolContentFrom(addr common.Address) (types.Transactions, types.Transactions) {
-	return b.eth.TxPool().ContentFrom(addr)
+	pending, queued := b.eth.TxPool().ContentFrom(addr)
+	return b.eth.TxPool().FilterPrivate(pending), b.eth.TxPool().FilterPrivate(queued)
+}
+
+// filterPrivate removes the private transactions from the content of the pool,
+// along with the accounts left without any. Private transactions are never to be
+// exposed over RPC, neither directly nor via the subscriptions.
+func (b *EthAPIBackend) filterPrivate(content map[common.Address]types.Transactions) map[common.Address]types.Transactions {
+	for addr, txs := range content {
+		if txs = b.eth.TxPool().FilterPrivate(txs); len(txs) > 0 {
+			content[addr] = txs
+		} else {
+			delete(content, addr)
+		}
+	}
+	return content
+}
+
+// filterPrivateBlock removes the private transactions from the pending block, as
+// they must not be exposed over RPC before being mined. The header is left as is.
+func (b *EthAPIBackend) filterPrivateBlock(block *types.Block) *types.Block {
+	if block == nil {
+		return nil
+	}
+	txs := b.eth.TxPool().FilterPrivate(block.Transactions())
+	if len(txs) == len(block.Transactions()) {
+		return block
+	}
+	return block.WithBody(txs, block.Uncles())
 }
 
 func (b *EthAPIBackend) TxPool() *core.TxPool {
@@ -278,7 +349,67 @@ func (b *EthAPIBackend) TxPool() *core.TxPool {
 }
 
 func (b *EthAPIBackend) SubscribeNewTxsEvent(ch chan<- core.NewTxsEvent) event.Subscription {
-	return b.eth.TxPool().SubscribeNewTxsEvent(ch)
+	pool := b.eth.TxPool()
+	return event.NewSubscription(func(quit <-chan struct{}) error {
+		txsCh := make(chan core.NewTxsEvent)
+		sub := pool.SubscribeNewTxsEvent(txsCh)
+		defer sub.Unsubscribe()
+
+		for {
+			select {
+			case ev := <-txsCh:
+				txs := pool.FilterPrivate(ev.Txs)
+				if len(txs) == 0 {
+					continue
+				}
+				select {
+				case ch <- core.NewTxsEvent{Txs: txs}:
+				case <-quit:
+					return nil
+				}
+			case err := <-sub.Err():
+				return err
+			case <-quit:
+				return nil
+			}
+		}
+	})
+}
+
+func (b *EthAPIBackend) SubscribeTxPoolEvent(ch chan<- core.TxPoolEvent) event.Subscription {
+	return event.NewSubscription(func(quit <-chan struct{}) error {
+		events := make(chan core.TxPoolEvent)
+		sub := b.eth.TxPool().SubscribeTxPoolEvent(events)
+		defer sub.Unsubscribe()
+
+		for {
+			select {
+			case ev := <-events:
+				if ev.Private {
+					continue
+				}
+				select {
+				case ch <- ev:
+				case <-quit:
+					return nil
+				}
+			case err := <-sub.Err():
+				return err
+			case <-quit:
+				return nil
+			}
+		}
+	})
+}
+
+func (b *EthAPIBackend) TxPoolHistory(hash common.Hash) []core.TxPoolEvent {
+	var events []core.TxPoolEvent
+	for _, ev := range b.eth.TxPool().History(hash) {
+		if !ev.Private {
+			events = append(events, ev)
+		}
+	}
+	return events
 }
 
 func (b *EthAPIBackend) SyncProgress() ethereum.SyncProgress {
@@ -28,6 +28,7 @@ import (
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/core/rawdb"
 	"github.com/ethereum/go-ethereum/core/state"
+	"github.com/ethereum/go-ethereum/core/state/snapshot"
 	"github.com/ethereum/go-ethereum/crypto"
 )
 
@@ -218,3 +219,65 @@ func TestStorageRangeAt(t *testing.T) {
 		}
 	}
 }
+
+// Tests that the account and storage ranges served from the snapshot match the
+// ones served from the tries.
+func TestSnapshotRanges(t *testing.T) {
+	t.Parallel()
+
+	var (
+		diskdb   = rawdb.NewMemoryDatabase()
+		statedb  = state.NewDatabase(diskdb)
+		state, _ = state.New(common.Hash{}, statedb, nil)
+		addr     = common.Address{0x01}
+	)
+	state.SetNonce(addr, 1)
+	for i := 0; i < 32; i++ {
+		state.SetBalance(common.BytesToAddress([]byte{byte(i), 0xff}), big.NewInt(int64(i+1)))
+		state.SetState(addr, common.BigToHash(big.NewInt(int64(i))), common.BigToHash(big.NewInt(int64(i+1))))
+	}
+	root, _ := state.Commit(true)
+	if err := statedb.TrieDB().Commit(root, false, nil); err != nil {
+		t.Fatalf("failed to persist state: %v", err)
+	}
+	snaps, err := snapshot.New(diskdb, statedb.TrieDB(), 16, root, false, true, false)
+	if err != nil {
+		t.Fatalf("failed to create snapshot: %v", err)
+	}
+	accTrie, _ := statedb.OpenTrie(root)
+	for _, start := range [][]byte{nil, {0x00}, {0x40}, {0x80, 0x01}} {
+		for _, limit := range []int{1, 5, 100} {
+			want, err := storageRangeAt(state.StorageTrie(addr), start, limit)
+			if err != nil {
+				t.Fatalf("failed to retrieve trie storage range: %v", err)
+			}
+			it, _ := snaps.StorageIterator(root, crypto.Keccak256Hash(addr[:]), rangeSeek(start))
+			have, err := snapshotStorageRangeAt(it, statedb.TrieDB(), limit)
+			it.Release()
+			if err != nil {
+				t.Fatalf("failed to retrieve snapshot storage range: %v", err)
+			}
+			if !reflect.DeepEqual(have, want) {
+				t.Fatalf("storage range 0x%x.., limit %d: mismatch:\ngot %s\nwant %s", start, limit, dumper.Sdump(have), dumper.Sdump(want))
+			}
+			wantAccs, err := accountRange(accTrie, start, limit)
+			if err != nil {
+				t.Fatalf("failed to retrieve trie account range: %v", err)
+			}
+			accIt, _ := snaps.AccountIterator(root, rangeSeek(start))
+			haveAccs, err := snapshotAccountRange(accIt, statedb.TrieDB(), limit)
+			accIt.Release()
+			if err != nil {
+				t.Fatalf("failed to retrieve snapshot account range: %v", err)
+			}
+			if !reflect.DeepEqual(haveAccs, wantAccs) {
+				t.Fatalf("account range 0x%x.., limit %d: mismatch:\ngot %s\nwant %s", start, limit, dumper.Sdump(haveAccs), dumper.Sdump(wantAccs))
+			}
+			for hash, entry := range haveAccs.Accounts {
+				if entry.Address == nil || crypto.Keccak256Hash(entry.Address[:]) != hash {
+					t.Fatalf("account %x: preimage mismatch", hash)
+				}
+			}
+		}
+	}
+}
@@ -30,6 +30,8 @@ import (
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/common/hexutil"
 	"github.com/ethereum/go-ethereum/consensus"
+	"github.com/ethereum/go-ethereum/consensus/beacon"
+	"github.com/ethereum/go-ethereum/consensus/bft"
 	"github.com/ethereum/go-ethereum/consensus/clique"
 	"github.com/ethereum/go-ethereum/core"
 	"github.com/ethereum/go-ethereum/core/bloombits"
@@ -131,6 +133,21 @@ func New(stack *node.Node, config *ethconfig.Config) (*Ethereum, error) {
 	if err != nil {
 		return nil, err
 	}
+	// Validate the state scheme against the database, marking fresh ones before
+	// the genesis state gets committed
+	scheme, err := rawdb.ParseStateScheme(config.StateScheme, chainDb)
+	if err != nil {
+		return nil, err
+	}
+	if scheme == rawdb.PathScheme {
+		if config.SyncMode != downloader.FullSync {
+			return nil, fmt.Errorf("path state scheme requires full sync, have %v", config.SyncMode)
+		}
+		if config.NoPruning {
+			return nil, errors.New("path state scheme can't be used in archive mode")
+		}
+		rawdb.WriteTrieScheme(chainDb, scheme)
+	}
 	chainConfig, genesisHash, genesisErr := core.SetupGenesisBlockWithOverride(chainDb, config.Genesis, config.OverrideLondon)
 	if _, ok := genesisErr.(*params.ConfigCompatError); genesisErr != nil && !ok {
 		return nil, genesisErr
@@ -186,8 +203,24 @@ func New(stack *node.Node, config *ethconfig.Config) (*Ethereum, error) {
 			TrieTimeLimit:       config.TrieTimeout,
 			SnapshotLimit:       config.SnapshotCache,
 			Preimages:           config.Preimages,
+			StateScheme:         scheme,
+
+			OnlinePruning:          config.StatePruning,
+			OnlinePruningInterval:  config.StatePruningInterval,
+			OnlinePruningBloomSize: config.StatePruningBloomSize,
+			OnlinePruningBloom:     stack.ResolvePath("statebloom.online"),
+			OnlinePruningThrottle:  config.StatePruningThrottle,
+
+			StateDiffs:   config.StateDiffs,
+			StateDiffDir: stack.ResolvePath("statediffs"),
+
+			StateArchive:    config.StateArchive,
+			StateArchiveDir: stack.ResolvePath("statearchive"),
 		}
 	)
+	if config.PreimageStore {
+		cacheConfig.PreimageDir = stack.ResolvePath("preimages")
+	}
 	eth.blockchain, err = core.NewBlockChain(chainDb, cacheConfig, chainConfig, eth.engine, vmConfig, eth.shouldPreserve, &config.TxLookupLimit)
 	if err != nil {
 		return nil, err
@@ -203,6 +236,9 @@ func New(stack *node.Node, config *ethconfig.Config) (*Ethereum, error) {
 	if config.TxPool.Journal != "" {
 		config.TxPool.Journal = stack.ResolvePath(config.TxPool.Journal)
 	}
+	if config.TxPool.RemoteJournal != "" {
+		config.TxPool.RemoteJournal = stack.ResolvePath(config.TxPool.RemoteJournal)
+	}
 	eth.txPool = core.NewTxPool(config.TxPool, chainConfig, eth.blockchain)
 
 	// Permit the downloader to use the trie cache allowance during fast sync
@@ -228,7 +264,7 @@ func New(stack *node.Node, config *ethconfig.Config) (*Ethereum, error) {
 	eth.miner = miner.New(eth, &config.Miner, chainConfig, eth.EventMux(), eth.engine, eth.isLocalBlock)
 	eth.miner.SetExtra(makeExtraData(config.Miner.ExtraData))
 
-	eth.APIBackend = &EthAPIBackend{stack.Config().ExtRPCEnabled(), stack.Config().AllowUnprotectedTxs, eth, nil}
+	eth.APIBackend = &EthAPIBackend{stack.Config().ExtRPCEnabled(), stack.Config().AllowUnprotectedTxs, eth, nil, nil}
 	if eth.APIBackend.allowUnprotectedTxs {
 		log.Info("Unprotected transactions allowed")
 	}
@@ -237,6 +273,7 @@ func New(stack *node.Node, config *ethconfig.Config) (*Ethereum, error) {
 		gpoParams.Default = config.Miner.GasPrice
 	}
 	eth.APIBackend.gpo = gasprice.NewOracle(eth.APIBackend, gpoParams)
+	eth.APIBackend.prover = eth.blockchain.Prover()
 
 	// Setup DNS discovery iterators.
 	dnsclient := dnsdisc.NewClient(dnsdisc.Config{})
@@ -421,12 +458,38 @@ func (s *Ethereum) shouldPreserve(block *types.Block) bool {
 	// is A, F and G sign the block of round5 and reject the block of opponents
 	// and in the round6, the last available signer B is offline, the whole
 	// network is stuck.
-	if _, ok := s.engine.(*clique.Clique); ok {
+	if s.cliqueEngine() != nil {
 		return false
 	}
 	return s.isLocalBlock(block)
 }
 
+// cliqueEngine returns the clique consensus engine if the chain is running one,
+// or nil otherwise.
+func (s *Ethereum) cliqueEngine() *clique.Clique {
+	if c, ok := s.engine.(*clique.Clique); ok {
+		return c
+	} else if bc, ok := s.engine.(*beacon.Beacon); ok {
+		if c, ok := bc.InnerEngine().(*clique.Clique); ok {
+			return c
+		}
+	}
+	return nil
+}
+
+// bftEngine returns the byzantine fault tolerant consensus engine if the chain
+// is running one, or nil otherwise.
+func (s *Ethereum) bftEngine() *bft.BFT {
+	if b, ok := s.engine.(*bft.BFT); ok {
+		return b
+	} else if bc, ok := s.engine.(*beacon.Beacon); ok {
+		if b, ok := bc.InnerEngine().(*bft.BFT); ok {
+			return b
+		}
+	}
+	return nil
+}
+
 // SetEtherbase sets the mining reward address.
 func (s *Ethereum) SetEtherbase(etherbase common.Address) {
 	s.lock.Lock()
@@ -465,13 +528,20 @@ func (s *Ethereum) StartMining(threads int) error {
 			log.Error("Cannot start mining without etherbase", "err", err)
 			return fmt.Errorf("etherbase missing: %v", err)
 		}
-		if clique, ok := s.engine.(*clique.Clique); ok {
+		cli := s.cliqueEngine()
+		bftEngine := s.bftEngine()
+		if cli != nil || bftEngine != nil {
 			wallet, err := s.accountManager.Find(accounts.Account{Address: eb})
 			if wallet == nil || err != nil {
 				log.Error("Etherbase account unavailable locally", "err", err)
 				return fmt.Errorf("signer missing: %v", err)
 			}
-			clique.Authorize(eb, wallet.SignData)
+			if cli != nil {
+				cli.Authorize(eb, wallet.SignData)
+			}
+			if bftEngine != nil {
+				bftEngine.Authorize(eb, wallet.SignData)
+			}
 		}
 		// If mining is started, we can disable the transaction rejection mechanism
 		// introduced to speed sync times.
@@ -518,6 +588,9 @@ func (s *Ethereum) Protocols() []p2p.Protocol {
 	if s.config.SnapshotCache > 0 {
 		protos = append(protos, snap.MakeProtocols((*snapHandler)(s.handler), s.snapDialCandidates)...)
 	}
+	if engine := s.bftEngine(); engine != nil {
+		protos = append(protos, engine.Protocols()...)
+	}
 	return protos
 }
 
@@ -537,6 +610,10 @@ func (s *Ethereum) Start() error {
 		}
 		maxPeers -= s.config.LightPeers
 	}
+	// Start the consensus core if the validators need to agree on the blocks
+	if engine := s.bftEngine(); engine != nil {
+		engine.Start(s.blockchain)
+	}
 	// Start the networking layer and the light server if requested
 	s.handler.Start(maxPeers)
 	return nil
@@ -14,297 +14,293 @@
 // You should have received a copy of the GNU Lesser General Public License
 // along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
 
-// Package catalyst implements the temporary eth1/eth2 RPC integration.
+// Package catalyst implements the Engine API, driving the execution layer from
+// an external consensus client.
 package catalyst
 
 import (
+	"crypto/sha256"
+	"encoding/binary"
 	"errors"
 	"fmt"
-	"math/big"
+	"sync"
 	"time"
 
 	"github.com/ethereum/go-ethereum/common"
-	"github.com/ethereum/go-ethereum/consensus/misc"
-	"github.com/ethereum/go-ethereum/core"
-	"github.com/ethereum/go-ethereum/core/state"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/rawdb"
 	"github.com/ethereum/go-ethereum/core/types"
 	"github.com/ethereum/go-ethereum/eth"
 	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/miner"
 	"github.com/ethereum/go-ethereum/node"
-	chainParams "github.com/ethereum/go-ethereum/params"
 	"github.com/ethereum/go-ethereum/rpc"
-	"github.com/ethereum/go-ethereum/trie"
 )
 
-// Register adds catalyst APIs to the node.
+// Register adds the Engine API to the node, served on the authenticated
+// endpoint only.
 func Register(stack *node.Node, backend *eth.Ethereum) error {
 	chainconfig := backend.BlockChain().Config()
 	if chainconfig.TerminalTotalDifficulty == nil {
 		return errors.New("catalyst started without valid total difficulty")
 	}
 
-	log.Warn("Catalyst mode enabled")
+	log.Warn("Engine API enabled", "protocol", "eth")
 	stack.RegisterAPIs([]rpc.API{
 		{
-			Namespace: "consensus",
-			Version:   "1.0",
-			Service:   newConsensusAPI(backend),
-			Public:    true,
+			Namespace:     "engine",
+			Version:       "1.0",
+			Service:       NewConsensusAPI(backend),
+			Public:        true,
+			Authenticated: true,
 		},
 	})
 	return nil
 }
 
-type consensusAPI struct {
-	eth *eth.Ethereum
-}
-
-func newConsensusAPI(eth *eth.Ethereum) *consensusAPI {
-	return &consensusAPI{eth: eth}
-}
-
-// blockExecutionEnv gathers all the data required to execute
-// a block, either when assembling it or when inserting it.
-type blockExecutionEnv struct {
-	chain   *core.BlockChain
-	state   *state.StateDB
-	tcount  int
-	gasPool *core.GasPool
+// ConsensusAPI implements the Engine API, through which the consensus client
+// drives the chain head selection and the block production.
+type ConsensusAPI struct {
+	eth            *eth.Ethereum
+	preparedBlocks *payloadQueue // preparedBlocks caches payloads being built (*miner.Payload) by payload ID (PayloadID)
 
-	header   *types.Header
-	txs      []*types.Transaction
-	receipts []*types.Receipt
-}
-
-func (env *blockExecutionEnv) commitTransaction(tx *types.Transaction, coinbase common.Address) error {
-	vmconfig := *env.chain.GetVMConfig()
-	snap := env.state.Snapshot()
-	receipt, err := core.ApplyTransaction(env.chain.Config(), env.chain, &coinbase, env.gasPool, env.state, env.header, tx, &env.header.GasUsed, vmconfig)
-	if err != nil {
-		env.state.RevertToSnapshot(snap)
-		return err
-	}
-	env.txs = append(env.txs, tx)
-	env.receipts = append(env.receipts, receipt)
-	return nil
+	forkChoiceLock sync.Mutex // Lock for the forkChoiceUpdated method
 }
 
-func (api *consensusAPI) makeEnv(parent *types.Block, header *types.Header) (*blockExecutionEnv, error) {
-	state, err := api.eth.BlockChain().StateAt(parent.Root())
-	if err != nil {
-		return nil, err
+// NewConsensusAPI creates a new consensus api for the given backend.
+func NewConsensusAPI(eth *eth.Ethereum) *ConsensusAPI {
+	return &ConsensusAPI{
+		eth:            eth,
+		preparedBlocks: newPayloadQueue(),
 	}
-	env := &blockExecutionEnv{
-		chain:   api.eth.BlockChain(),
-		state:   state,
-		header:  header,
-		gasPool: new(core.GasPool).AddGas(header.GasLimit),
-	}
-	return env, nil
 }
 
-// AssembleBlock creates a new block, inserts it into the chain, and returns the "execution
-// data" required for eth2 clients to process the new block.
-func (api *consensusAPI) AssembleBlock(params assembleBlockParams) (*executableData, error) {
-	log.Info("Producing block", "parentHash", params.ParentHash)
-
-	bc := api.eth.BlockChain()
-	parent := bc.GetBlockByHash(params.ParentHash)
-	if parent == nil {
-		log.Warn("Cannot assemble block with parent hash to unknown block", "parentHash", params.ParentHash)
-		return nil, fmt.Errorf("cannot assemble block with unknown parent %s", params.ParentHash)
-	}
-
-	pool := api.eth.TxPool()
-
-	if parent.Time() >= params.Timestamp {
-		return nil, fmt.Errorf("child timestamp lower than parent's: %d >= %d", parent.Time(), params.Timestamp)
+// ForkchoiceUpdatedV1 sets the head of the chain and the finalized block as
+// requested by the consensus client. If payload attributes are given, it also
+// starts building a new payload on top of the new head, returning its id.
+func (api *ConsensusAPI) ForkchoiceUpdatedV1(update ForkchoiceStateV1, payloadAttributes *PayloadAttributesV1) (ForkChoiceResponse, error) {
+	api.forkChoiceLock.Lock()
+	defer api.forkChoiceLock.Unlock()
+
+	log.Trace("Engine API request received", "method", "ForkchoiceUpdated", "head", update.HeadBlockHash, "finalized", update.FinalizedBlockHash, "safe", update.SafeBlockHash)
+	if update.HeadBlockHash == (common.Hash{}) {
+		log.Warn("Forkchoice requested update to zero hash")
+		return STATUS_INVALID, nil
 	}
-	if now := uint64(time.Now().Unix()); params.Timestamp > now+1 {
-		wait := time.Duration(params.Timestamp-now) * time.Second
-		log.Info("Producing block too far in the future", "wait", common.PrettyDuration(wait))
-		time.Sleep(wait)
+	// Check whether we have the block yet in our database or not. If not, we
+	// can't do anything but wait for the block to be delivered.
+	block := api.eth.BlockChain().GetBlockByHash(update.HeadBlockHash)
+	if block == nil {
+		log.Warn("Forkchoice requested unknown head", "hash", update.HeadBlockHash)
+		return STATUS_SYNCING, nil
 	}
-
-	pending := pool.Pending(true)
-
-	coinbase, err := api.eth.Etherbase()
-	if err != nil {
-		return nil, err
+	// Block is known locally, just sanity check that the beacon client does not
+	// attempt to push us back to before the merge.
+	if block.Difficulty().BitLen() > 0 || block.NumberU64() == 0 {
+		var (
+			td  = api.eth.BlockChain().GetTd(update.HeadBlockHash, block.NumberU64())
+			ptd = api.eth.BlockChain().GetTd(block.ParentHash(), block.NumberU64()-1)
+			ttd = api.eth.BlockChain().Config().TerminalTotalDifficulty
+		)
+		if td == nil || (block.NumberU64() > 0 && ptd == nil) {
+			log.Error("TDs unavailable for TTD check", "number", block.NumberU64(), "hash", update.HeadBlockHash, "td", td, "parent", block.ParentHash(), "ptd", ptd)
+			return STATUS_INVALID, errors.New("TDs unavailable for TTD check")
+		}
+		if td.Cmp(ttd) < 0 || (block.NumberU64() > 0 && ptd.Cmp(ttd) >= 0) {
+			log.Error("Refusing beacon update to pre-merge", "number", block.NumberU64(), "hash", update.HeadBlockHash, "diff", block.Difficulty(), "age", common.PrettyAge(time2unix(block.Time())))
+			return ForkChoiceResponse{PayloadStatus: INVALID_TERMINAL_BLOCK, PayloadID: nil}, nil
+		}
 	}
-	num := parent.Number()
-	header := &types.Header{
-		ParentHash: parent.Hash(),
-		Number:     num.Add(num, common.Big1),
-		Coinbase:   coinbase,
-		GasLimit:   parent.GasLimit(), // Keep the gas limit constant in this prototype
-		Extra:      []byte{},
-		Time:       params.Timestamp,
+	if rawdb.ReadCanonicalHash(api.eth.ChainDb(), block.NumberU64()) != update.HeadBlockHash {
+		// Block is not canonical, set head.
+		if err := api.eth.BlockChain().SetChainHead(block); err != nil {
+			return STATUS_INVALID, err
+		}
+	} else {
+		// If the head block is already in our canonical chain, the beacon client is
+		// probably resyncing. Ignore the update.
+		log.Info("Ignoring beacon update to old head", "number", block.NumberU64(), "hash", update.HeadBlockHash, "have", api.eth.BlockChain().CurrentBlock().NumberU64())
 	}
-	if config := api.eth.BlockChain().Config(); config.IsLondon(header.Number) {
-		header.BaseFee = misc.CalcBaseFee(config, parent.Header())
+	// If the beacon client also advertised a finalized block, make sure it's
+	// known and canonical.
+	if update.FinalizedBlockHash != (common.Hash{}) {
+		finalBlock := api.eth.BlockChain().GetBlockByHash(update.FinalizedBlockHash)
+		if finalBlock == nil {
+			log.Warn("Final block not available in database", "hash", update.FinalizedBlockHash)
+			return STATUS_INVALID, InvalidForkChoiceState.With(errors.New("final block not available in database"))
+		} else if rawdb.ReadCanonicalHash(api.eth.ChainDb(), finalBlock.NumberU64()) != update.FinalizedBlockHash {
+			log.Warn("Final block not in canonical chain", "number", finalBlock.NumberU64(), "hash", update.FinalizedBlockHash)
+			return STATUS_INVALID, InvalidForkChoiceState.With(errors.New("final block not in canonical chain"))
+		}
 	}
-	err = api.eth.Engine().Prepare(bc, header)
-	if err != nil {
-		return nil, err
+	// Check if the safe block hash is in our canonical tree, if not somethings wrong
+	if update.SafeBlockHash != (common.Hash{}) {
+		safeBlock := api.eth.BlockChain().GetBlockByHash(update.SafeBlockHash)
+		if safeBlock == nil {
+			log.Warn("Safe block not available in database")
+			return STATUS_INVALID, InvalidForkChoiceState.With(errors.New("safe block not available in database"))
+		}
+		if rawdb.ReadCanonicalHash(api.eth.ChainDb(), safeBlock.NumberU64()) != update.SafeBlockHash {
+			log.Warn("Safe block not in canonical chain")
+			return STATUS_INVALID, InvalidForkChoiceState.With(errors.New("safe block not in canonical chain"))
+		}
 	}
-
-	env, err := api.makeEnv(parent, header)
-	if err != nil {
-		return nil, err
+	valid := func(id *PayloadID) ForkChoiceResponse {
+		return ForkChoiceResponse{
+			PayloadStatus: PayloadStatusV1{Status: VALID, LatestValidHash: &update.HeadBlockHash},
+			PayloadID:     id,
+		}
 	}
-
-	var (
-		signer       = types.MakeSigner(bc.Config(), header.Number)
-		txHeap       = types.NewTransactionsByPriceAndNonce(signer, pending, nil)
-		transactions []*types.Transaction
-	)
-	for {
-		if env.gasPool.Gas() < chainParams.TxGas {
-			log.Trace("Not enough gas for further transactions", "have", env.gasPool, "want", chainParams.TxGas)
-			break
+	// If payload generation was requested, start building a new block to be
+	// potentially sealed by the beacon client. The payload keeps being improved
+	// in the background until it's requested.
+	if payloadAttributes != nil {
+		id := computePayloadId(update.HeadBlockHash, payloadAttributes)
+		if api.preparedBlocks.has(id) {
+			log.Debug("Payload already being built", "id", id)
+			return valid(&id), nil
 		}
-		tx := txHeap.Peek()
-		if tx == nil {
-			break
+		log.Info("Creating new payload for sealing", "id", id)
+		args := &miner.BuildPayloadArgs{
+			Parent:       update.HeadBlockHash,
+			Timestamp:    payloadAttributes.Timestamp,
+			FeeRecipient: payloadAttributes.SuggestedFeeRecipient,
+			Random:       payloadAttributes.PrevRandao,
 		}
-
-		// The sender is only for logging purposes, and it doesn't really matter if it's correct.
-		from, _ := types.Sender(signer, tx)
-
-		// Execute the transaction
-		env.state.Prepare(tx.Hash(), env.tcount)
-		err = env.commitTransaction(tx, coinbase)
-		switch err {
-		case core.ErrGasLimitReached:
-			// Pop the current out-of-gas transaction without shifting in the next from the account
-			log.Trace("Gas limit exceeded for current block", "sender", from)
-			txHeap.Pop()
-
-		case core.ErrNonceTooLow:
-			// New head notification data race between the transaction pool and miner, shift
-			log.Trace("Skipping transaction with low nonce", "sender", from, "nonce", tx.Nonce())
-			txHeap.Shift()
-
-		case core.ErrNonceTooHigh:
-			// Reorg notification data race between the transaction pool and miner, skip account =
-			log.Trace("Skipping account with high nonce", "sender", from, "nonce", tx.Nonce())
-			txHeap.Pop()
-
-		case nil:
-			// Everything ok, collect the logs and shift in the next transaction from the same account
-			env.tcount++
-			txHeap.Shift()
-			transactions = append(transactions, tx)
-
-		default:
-			// Strange error, discard the transaction and get the next in line (note, the
-			// nonce-too-high clause will prevent us from executing in vain).
-			log.Debug("Transaction failed, account skipped", "hash", tx.Hash(), "err", err)
-			txHeap.Shift()
+		payload, err := api.eth.Miner().BuildPayload(args)
+		if err != nil {
+			log.Error("Failed to build payload", "err", err)
+			return valid(nil), InvalidPayloadAttributes.With(err)
 		}
+		api.preparedBlocks.put(id, payload)
+		return valid(&id), nil
 	}
-
-	// Create the block.
-	block, err := api.eth.Engine().FinalizeAndAssemble(bc, header, env.state, transactions, nil /* uncles */, env.receipts)
-	if err != nil {
-		return nil, err
-	}
-	return &executableData{
-		BlockHash:    block.Hash(),
-		ParentHash:   block.ParentHash(),
-		Miner:        block.Coinbase(),
-		StateRoot:    block.Root(),
-		Number:       block.NumberU64(),
-		GasLimit:     block.GasLimit(),
-		GasUsed:      block.GasUsed(),
-		Timestamp:    block.Time(),
-		ReceiptRoot:  block.ReceiptHash(),
-		LogsBloom:    block.Bloom().Bytes(),
-		Transactions: encodeTransactions(block.Transactions()),
-	}, nil
+	return valid(nil), nil
 }
 
-func encodeTransactions(txs []*types.Transaction) [][]byte {
-	var enc = make([][]byte, len(txs))
-	for i, tx := range txs {
-		enc[i], _ = tx.MarshalBinary()
+// ExchangeTransitionConfigurationV1 checks the given configuration against
+// the configuration of the node.
+func (api *ConsensusAPI) ExchangeTransitionConfigurationV1(config TransitionConfigurationV1) (*TransitionConfigurationV1, error) {
+	if config.TerminalTotalDifficulty == nil {
+		return nil, errors.New("invalid terminal total difficulty")
 	}
-	return enc
-}
-
-func decodeTransactions(enc [][]byte) ([]*types.Transaction, error) {
-	var txs = make([]*types.Transaction, len(enc))
-	for i, encTx := range enc {
-		var tx types.Transaction
-		if err := tx.UnmarshalBinary(encTx); err != nil {
-			return nil, fmt.Errorf("invalid transaction %d: %v", i, err)
+	ttd := api.eth.BlockChain().Config().TerminalTotalDifficulty
+	if ttd == nil || ttd.Cmp(config.TerminalTotalDifficulty.ToInt()) != 0 {
+		log.Warn("Invalid TTD configured", "geth", ttd, "beacon", config.TerminalTotalDifficulty)
+		return nil, fmt.Errorf("invalid ttd: execution %v consensus %v", ttd, config.TerminalTotalDifficulty)
+	}
+	if config.TerminalBlockHash != (common.Hash{}) {
+		if hash := api.eth.BlockChain().GetCanonicalHash(uint64(config.TerminalBlockNumber)); hash == config.TerminalBlockHash {
+			return &TransitionConfigurationV1{
+				TerminalTotalDifficulty: (*hexutil.Big)(ttd),
+				TerminalBlockHash:       config.TerminalBlockHash,
+				TerminalBlockNumber:     config.TerminalBlockNumber,
+			}, nil
 		}
-		txs[i] = &tx
+		return nil, fmt.Errorf("invalid terminal block hash")
 	}
-	return txs, nil
+	return &TransitionConfigurationV1{TerminalTotalDifficulty: (*hexutil.Big)(ttd)}, nil
 }
 
-func insertBlockParamsToBlock(config *chainParams.ChainConfig, parent *types.Header, params executableData) (*types.Block, error) {
-	txs, err := decodeTransactions(params.Transactions)
-	if err != nil {
-		return nil, err
+// GetPayloadV1 returns the best version of a payload built so far by id, and
+// stops improving it.
+func (api *ConsensusAPI) GetPayloadV1(payloadID PayloadID) (*ExecutableDataV1, error) {
+	log.Trace("Engine API request received", "method", "GetPayload", "id", payloadID)
+	data := api.preparedBlocks.get(payloadID)
+	if data == nil {
+		return nil, UnknownPayload
 	}
+	return data, nil
+}
 
-	number := big.NewInt(0)
-	number.SetUint64(params.Number)
-	header := &types.Header{
-		ParentHash:  params.ParentHash,
-		UncleHash:   types.EmptyUncleHash,
-		Coinbase:    params.Miner,
-		Root:        params.StateRoot,
-		TxHash:      types.DeriveSha(types.Transactions(txs), trie.NewStackTrie(nil)),
-		ReceiptHash: params.ReceiptRoot,
-		Bloom:       types.BytesToBloom(params.LogsBloom),
-		Difficulty:  big.NewInt(1),
-		Number:      number,
-		GasLimit:    params.GasLimit,
-		GasUsed:     params.GasUsed,
-		Time:        params.Timestamp,
+// NewPayloadV1 creates an Eth1 block, inserts it in the chain, and returns the
+// status of the validation. The canonical chain isn't updated, that's up to
+// a subsequent fork choice update.
+func (api *ConsensusAPI) NewPayloadV1(params ExecutableDataV1) (PayloadStatusV1, error) {
+	log.Trace("Engine API request received", "method", "ExecutePayload", "number", params.Number, "hash", params.BlockHash)
+	block, err := ExecutableDataToBlock(params)
+	if err != nil {
+		log.Debug("Invalid NewPayload params", "params", params, "error", err)
+		return PayloadStatusV1{Status: INVALID_BLOCK_HASH}, nil
 	}
-	if config.IsLondon(number) {
-		header.BaseFee = misc.CalcBaseFee(config, parent)
+	// If we already have the block locally, ignore the entire execution and just
+	// return a fake success.
+	if block := api.eth.BlockChain().GetBlockByHash(params.BlockHash); block != nil {
+		log.Warn("Ignoring already known beacon payload", "number", params.Number, "hash", params.BlockHash, "age", common.PrettyAge(time2unix(block.Time())))
+		hash := block.Hash()
+		return PayloadStatusV1{Status: VALID, LatestValidHash: &hash}, nil
 	}
-	block := types.NewBlockWithHeader(header).WithBody(txs, nil /* uncles */)
-	return block, nil
-}
-
-// NewBlock creates an Eth1 block, inserts it in the chain, and either returns true,
-// or false + an error. This is a bit redundant for go, but simplifies things on the
-// eth2 side.
-func (api *consensusAPI) NewBlock(params executableData) (*newBlockResponse, error) {
-	parent := api.eth.BlockChain().GetBlockByHash(params.ParentHash)
+	// If the parent is missing, we - in theory - could trigger a sync, but that
+	// would also entail a reorg. That is problematic if multiple sibling blocks
+	// are being fed to us, and even more so, if some semi-distant uncle shortens
+	// our live chain. As such, payload execution will not permit reorgs and thus
+	// will not trigger a sync cycle.
+	parent := api.eth.BlockChain().GetBlock(block.ParentHash(), block.NumberU64()-1)
 	if parent == nil {
-		return &newBlockResponse{false}, fmt.Errorf("could not find parent %x", params.ParentHash)
+		log.Warn("Ignoring payload with missing parent", "number", block.NumberU64(), "hash", block.Hash(), "parent", block.ParentHash())
+		return PayloadStatusV1{Status: ACCEPTED}, nil
 	}
-	block, err := insertBlockParamsToBlock(api.eth.BlockChain().Config(), parent.Header(), params)
-	if err != nil {
-		return nil, err
+	// We have an existing parent, do some sanity checks to avoid the beacon client
+	// triggering too early
+	var (
+		td  = api.eth.BlockChain().GetTd(parent.Hash(), parent.NumberU64())
+		ttd = api.eth.BlockChain().Config().TerminalTotalDifficulty
+	)
+	if td == nil {
+		log.Error("TD unavailable for TTD check", "number", parent.NumberU64(), "hash", parent.Hash())
+		return PayloadStatusV1{Status: INVALID}, errors.New("TD unavailable for TTD check")
+	}
+	if td.Cmp(ttd) < 0 {
+		log.Warn("Ignoring pre-merge payload", "number", params.Number, "hash", params.BlockHash, "td", td, "ttd", ttd)
+		return INVALID_TERMINAL_BLOCK, nil
+	}
+	if block.Time() <= parent.Time() {
+		log.Warn("Invalid timestamp", "parent", parent.Time(), "block", block.Time())
+		return api.invalid(errors.New("invalid timestamp"), parent), nil
+	}
+	if !api.eth.BlockChain().HasBlockAndState(block.ParentHash(), block.NumberU64()-1) {
+		log.Warn("State not available, ignoring new payload", "number", block.NumberU64(), "hash", block.Hash())
+		return PayloadStatusV1{Status: ACCEPTED}, nil
 	}
-	_, err = api.eth.BlockChain().InsertChainWithoutSealVerification(block)
-	return &newBlockResponse{err == nil}, err
+	log.Trace("Inserting block without sethead", "hash", block.Hash(), "number", block.Number)
+	if err := api.eth.BlockChain().InsertBlockWithoutSetHead(block); err != nil {
+		log.Warn("NewPayloadV1: inserting block failed", "error", err)
+		return api.invalid(err, parent), nil
+	}
+	hash := block.Hash()
+	return PayloadStatusV1{Status: VALID, LatestValidHash: &hash}, nil
+}
+
+// computePayloadId computes a pseudo-random payloadid, based on the parameters.
+func computePayloadId(headBlockHash common.Hash, params *PayloadAttributesV1) PayloadID {
+	// Hash
+	hasher := sha256.New()
+	hasher.Write(headBlockHash[:])
+	binary.Write(hasher, binary.BigEndian, params.Timestamp)
+	hasher.Write(params.PrevRandao[:])
+	hasher.Write(params.SuggestedFeeRecipient[:])
+	var out PayloadID
+	copy(out[:], hasher.Sum(nil)[:8])
+	return out
+}
+
+// invalid returns a response "INVALID" with the latest valid hash set to the
+// given parent.
+func (api *ConsensusAPI) invalid(err error, parent *types.Block) PayloadStatusV1 {
+	hash := parent.Hash()
+	errorMsg := err.Error()
+	return PayloadStatusV1{Status: INVALID, LatestValidHash: &hash, ValidationError: &errorMsg}
 }
 
 // Used in tests to add a the list of transactions from a block to the tx pool.
-func (api *consensusAPI) addBlockTxs(block *types.Block) error {
+func (api *ConsensusAPI) addBlockTxs(block *types.Block) error {
 	for _, tx := range block.Transactions() {
 		api.eth.TxPool().AddLocal(tx)
 	}
 	return nil
 }
 
-// FinalizeBlock is called to mark a block as synchronized, so
-// that data that is no longer needed can be removed.
-func (api *consensusAPI) FinalizeBlock(blockHash common.Hash) (*genericResponse, error) {
-	return &genericResponse{true}, nil
-}
-
-// SetHead is called to perform a force choice.
-func (api *consensusAPI) SetHead(newHead common.Hash) (*genericResponse, error) {
-	return &genericResponse{true}, nil
+// time2unix converts a block timestamp into a time for age logging.
+func time2unix(timestamp uint64) time.Time {
+	return time.Unix(int64(timestamp), 0)
 }
@@ -17,9 +17,12 @@
 package catalyst
 
 import (
+	"errors"
 	"math/big"
 	"testing"
 
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
 	"github.com/ethereum/go-ethereum/consensus/ethash"
 	"github.com/ethereum/go-ethereum/core"
 	"github.com/ethereum/go-ethereum/core/rawdb"
@@ -38,18 +41,21 @@ var (
 	// testAddr is the Ethereum address of the tester account.
 	testAddr = crypto.PubkeyToAddress(testKey.PublicKey)
 
-	testBalance = big.NewInt(2e15)
+	testBalance = big.NewInt(2e18)
 )
 
-func generateTestChain() (*core.Genesis, []*types.Block) {
+// generatePreMergeChain creates a proof-of-work chain of n blocks, whose last
+// block is the terminal one.
+func generatePreMergeChain(n int) (*core.Genesis, []*types.Block) {
 	db := rawdb.NewMemoryDatabase()
-	config := params.AllEthashProtocolChanges
+	config := *params.AllEthashProtocolChanges
 	genesis := &core.Genesis{
-		Config:    config,
-		Alloc:     core.GenesisAlloc{testAddr: {Balance: testBalance}},
-		ExtraData: []byte("test genesis"),
-		Timestamp: 9000,
-		BaseFee:   big.NewInt(params.InitialBaseFee),
+		Config:     &config,
+		Alloc:      core.GenesisAlloc{testAddr: {Balance: testBalance}},
+		ExtraData:  []byte("test genesis"),
+		Timestamp:  9000,
+		Difficulty: big.NewInt(0),
+		BaseFee:    big.NewInt(params.InitialBaseFee),
 	}
 	generate := func(i int, g *core.BlockGen) {
 		g.OffsetTime(5)
@@ -57,173 +63,327 @@ func generateTestChain() (*core.Genesis, []*types.Block) {
 	}
 	gblock := genesis.ToBlock(db)
 	engine := ethash.NewFaker()
-	blocks, _ := core.GenerateChain(config, gblock, engine, db, 10, generate)
-	blocks = append([]*types.Block{gblock}, blocks...)
-	return genesis, blocks
-}
+	blocks, _ := core.GenerateChain(&config, gblock, engine, db, n, generate)
 
-// TODO (MariusVanDerWijden) reenable once engine api is updated to the latest spec
-/*
-func generateTestChainWithFork(n int, fork int) (*core.Genesis, []*types.Block, []*types.Block) {
-	if fork >= n {
-		fork = n - 1
-	}
-	db := rawdb.NewMemoryDatabase()
-	config := &params.ChainConfig{
-		ChainID:                 big.NewInt(1337),
-		HomesteadBlock:          big.NewInt(0),
-		EIP150Block:             big.NewInt(0),
-		EIP155Block:             big.NewInt(0),
-		EIP158Block:             big.NewInt(0),
-		ByzantiumBlock:          big.NewInt(0),
-		ConstantinopleBlock:     big.NewInt(0),
-		PetersburgBlock:         big.NewInt(0),
-		IstanbulBlock:           big.NewInt(0),
-		MuirGlacierBlock:        big.NewInt(0),
-		BerlinBlock:             big.NewInt(0),
-		LondonBlock:             big.NewInt(0),
-		TerminalTotalDifficulty: big.NewInt(0),
-		Ethash:                  new(params.EthashConfig),
-	}
-	genesis := &core.Genesis{
-		Config:    config,
-		Alloc:     core.GenesisAlloc{testAddr: {Balance: testBalance}},
-		ExtraData: []byte("test genesis"),
-		Timestamp: 9000,
-		BaseFee:   big.NewInt(params.InitialBaseFee),
-	}
-	generate := func(i int, g *core.BlockGen) {
-		g.OffsetTime(5)
-		g.SetExtra([]byte("test"))
+	totalDifficulty := new(big.Int).Set(gblock.Difficulty())
+	for _, b := range blocks {
+		totalDifficulty.Add(totalDifficulty, b.Difficulty())
 	}
-	generateFork := func(i int, g *core.BlockGen) {
-		g.OffsetTime(5)
-		g.SetExtra([]byte("testF"))
-	}
-	gblock := genesis.ToBlock(db)
-	engine := ethash.NewFaker()
-	blocks, _ := core.GenerateChain(config, gblock, engine, db, n, generate)
+	config.TerminalTotalDifficulty = totalDifficulty
+
 	blocks = append([]*types.Block{gblock}, blocks...)
-	forkedBlocks, _ := core.GenerateChain(config, blocks[fork], engine, db, n-fork, generateFork)
-	return genesis, blocks, forkedBlocks
+	return genesis, blocks
 }
-*/
 
 func TestEth2AssembleBlock(t *testing.T) {
-	genesis, blocks := generateTestChain()
+	genesis, blocks := generatePreMergeChain(10)
 	n, ethservice := startEthService(t, genesis, blocks[1:9])
 	defer n.Close()
 
-	api := newConsensusAPI(ethservice)
+	api := NewConsensusAPI(ethservice)
 	signer := types.NewEIP155Signer(ethservice.BlockChain().Config().ChainID)
 	tx, err := types.SignTx(types.NewTransaction(0, blocks[8].Coinbase(), big.NewInt(1000), params.TxGas, big.NewInt(params.InitialBaseFee), nil), signer, testKey)
 	if err != nil {
 		t.Fatalf("error signing transaction, err=%v", err)
 	}
 	ethservice.TxPool().AddLocal(tx)
-	blockParams := assembleBlockParams{
-		ParentHash: blocks[8].ParentHash(),
-		Timestamp:  blocks[8].Time(),
+	blockParams := PayloadAttributesV1{
+		Timestamp: blocks[8].Time() + 5,
 	}
-	execData, err := api.AssembleBlock(blockParams)
-
+	execData, err := assembleBlock(api, blocks[8].Hash(), &blockParams)
 	if err != nil {
 		t.Fatalf("error producing block, err=%v", err)
 	}
-
 	if len(execData.Transactions) != 1 {
 		t.Fatalf("invalid number of transactions %d != 1", len(execData.Transactions))
 	}
 }
 
 func TestEth2AssembleBlockWithAnotherBlocksTxs(t *testing.T) {
-	genesis, blocks := generateTestChain()
+	genesis, blocks := generatePreMergeChain(10)
 	n, ethservice := startEthService(t, genesis, blocks[1:9])
 	defer n.Close()
 
-	api := newConsensusAPI(ethservice)
+	api := NewConsensusAPI(ethservice)
 
 	// Put the 10th block's tx in the pool and produce a new block
 	api.addBlockTxs(blocks[9])
-	blockParams := assembleBlockParams{
-		ParentHash: blocks[9].ParentHash(),
-		Timestamp:  blocks[9].Time(),
+	blockParams := PayloadAttributesV1{
+		Timestamp: blocks[8].Time() + 5,
 	}
-	execData, err := api.AssembleBlock(blockParams)
+	execData, err := assembleBlock(api, blocks[8].Hash(), &blockParams)
 	if err != nil {
 		t.Fatalf("error producing block, err=%v", err)
 	}
-
 	if len(execData.Transactions) != blocks[9].Transactions().Len() {
 		t.Fatalf("invalid number of transactions %d != 1", len(execData.Transactions))
 	}
 }
 
-// TODO (MariusVanDerWijden) reenable once engine api is updated to the latest spec
-/*
+func TestEth2PrepareAndGetPayload(t *testing.T) {
+	genesis, blocks := generatePreMergeChain(10)
+	n, ethservice := startEthService(t, genesis, blocks[1:])
+	defer n.Close()
+
+	api := NewConsensusAPI(ethservice)
+
+	// Put the last block's txs in the pool and produce a new block
+	api.addBlockTxs(blocks[10])
+	blockParams := PayloadAttributesV1{
+		Timestamp:             blocks[10].Time() + 5,
+		SuggestedFeeRecipient: common.Address{0x01},
+		PrevRandao:            common.Hash{0x02},
+	}
+	fcState := ForkchoiceStateV1{
+		HeadBlockHash:      blocks[10].Hash(),
+		SafeBlockHash:      common.Hash{},
+		FinalizedBlockHash: common.Hash{},
+	}
+	resp, err := api.ForkchoiceUpdatedV1(fcState, &blockParams)
+	if err != nil {
+		t.Fatalf("error preparing payload, err=%v", err)
+	}
+	if resp.PayloadStatus.Status != VALID || resp.PayloadID == nil {
+		t.Fatalf("invalid fork choice response: %+v", resp)
+	}
+	payloadID := computePayloadId(fcState.HeadBlockHash, &blockParams)
+	if *resp.PayloadID != payloadID {
+		t.Fatalf("payload id mismatch: have %v, want %v", resp.PayloadID, payloadID)
+	}
+	execData, err := api.GetPayloadV1(payloadID)
+	if err != nil {
+		t.Fatalf("error getting payload, err=%v", err)
+	}
+	if len(execData.Transactions) != blocks[10].Transactions().Len() {
+		t.Fatalf("invalid number of transactions %d != 1", len(execData.Transactions))
+	}
+	if execData.FeeRecipient != blockParams.SuggestedFeeRecipient || execData.PrevRandao != blockParams.PrevRandao {
+		t.Fatalf("payload attributes not applied: %+v", execData)
+	}
+	// Test invalid payloadID
+	var invPayload PayloadID
+	copy(invPayload[:], payloadID[:])
+	invPayload[0] = ^invPayload[0]
+	if _, err := api.GetPayloadV1(invPayload); !errors.Is(err, UnknownPayload) {
+		t.Fatalf("unknown payload error mismatch: have %v, want %v", err, UnknownPayload)
+	}
+}
+
+func TestInvalidPayloadTimestamp(t *testing.T) {
+	genesis, blocks := generatePreMergeChain(10)
+	n, ethservice := startEthService(t, genesis, blocks[1:])
+	defer n.Close()
+
+	var (
+		api    = NewConsensusAPI(ethservice)
+		parent = ethservice.BlockChain().CurrentBlock()
+	)
+	tests := []struct {
+		time      uint64
+		shouldErr bool
+	}{
+		{0, true},
+		{parent.Time(), true},
+		{parent.Time() - 1, true},
+		{parent.Time() + 1, false},
+	}
+	for i, test := range tests {
+		params := PayloadAttributesV1{
+			Timestamp:             test.time,
+			PrevRandao:            crypto.Keccak256Hash([]byte{byte(123)}),
+			SuggestedFeeRecipient: parent.Coinbase(),
+		}
+		fcState := ForkchoiceStateV1{
+			HeadBlockHash:      parent.Hash(),
+			SafeBlockHash:      common.Hash{},
+			FinalizedBlockHash: common.Hash{},
+		}
+		_, err := api.ForkchoiceUpdatedV1(fcState, &params)
+		if test.shouldErr && err == nil {
+			t.Errorf("test %d: expected error preparing payload with invalid timestamp", i)
+		} else if !test.shouldErr && err != nil {
+			t.Errorf("test %d: error preparing payload with valid timestamp: %v", i, err)
+		}
+	}
+}
+
 func TestEth2NewBlock(t *testing.T) {
-	genesis, blocks, forkedBlocks := generateTestChainWithFork(10, 4)
-	n, ethservice := startEthService(t, genesis, blocks[1:5])
+	genesis, preMergeBlocks := generatePreMergeChain(10)
+	n, ethservice := startEthService(t, genesis, preMergeBlocks[1:])
 	defer n.Close()
 
-	api := newConsensusAPI(ethservice)
-	for i := 5; i < 10; i++ {
-		p := executableData{
-			ParentHash:   ethservice.BlockChain().CurrentBlock().Hash(),
-			Miner:        blocks[i].Coinbase(),
-			StateRoot:    blocks[i].Root(),
-			GasLimit:     blocks[i].GasLimit(),
-			GasUsed:      blocks[i].GasUsed(),
-			Transactions: encodeTransactions(blocks[i].Transactions()),
-			ReceiptRoot:  blocks[i].ReceiptHash(),
-			LogsBloom:    blocks[i].Bloom().Bytes(),
-			BlockHash:    blocks[i].Hash(),
-			Timestamp:    blocks[i].Time(),
-			Number:       uint64(i),
+	var (
+		api    = NewConsensusAPI(ethservice)
+		parent = preMergeBlocks[len(preMergeBlocks)-1]
+
+		// This EVM code generates a log when the contract is created.
+		logCode = common.Hex2Bytes("60606040525b7f24ec1d3ff24c2f6ff210738839dbc339cd45a5294d85c79361016243157aae7b60405180905060405180910390a15b600a8060416000396000f360606040526008565b00")
+	)
+	// The event channels.
+	newLogCh := make(chan []*types.Log, 10)
+	ethservice.BlockChain().SubscribeLogsEvent(newLogCh)
+
+	for i := 0; i < 10; i++ {
+		statedb, _ := ethservice.BlockChain().StateAt(parent.Root())
+		nonce := statedb.GetNonce(testAddr)
+		tx, _ := types.SignTx(types.NewContractCreation(nonce, new(big.Int), 1000000, big.NewInt(2*params.InitialBaseFee), logCode), types.LatestSigner(ethservice.BlockChain().Config()), testKey)
+		ethservice.TxPool().AddLocal(tx)
+
+		execData, err := assembleBlock(api, parent.Hash(), &PayloadAttributesV1{
+			Timestamp: parent.Time() + 5,
+		})
+		if err != nil {
+			t.Fatalf("Failed to create the executable data %v", err)
 		}
-		success, err := api.NewBlock(p)
-		if err != nil || !success.Valid {
+		block, err := ExecutableDataToBlock(*execData)
+		if err != nil {
+			t.Fatalf("Failed to convert executable data to block %v", err)
+		}
+		newResp, err := api.NewPayloadV1(*execData)
+		if err != nil || newResp.Status != VALID {
+			t.Fatalf("Failed to insert block: %v %+v", err, newResp)
+		}
+		if ethservice.BlockChain().CurrentBlock().NumberU64() != block.NumberU64()-1 {
+			t.Fatalf("Chain head shouldn't be updated")
+		}
+		fcState := ForkchoiceStateV1{
+			HeadBlockHash:      block.Hash(),
+			SafeBlockHash:      block.Hash(),
+			FinalizedBlockHash: block.Hash(),
+		}
+		if _, err := api.ForkchoiceUpdatedV1(fcState, nil); err != nil {
 			t.Fatalf("Failed to insert block: %v", err)
 		}
+		if ethservice.BlockChain().CurrentBlock().NumberU64() != block.NumberU64() {
+			t.Fatalf("Chain head should be updated")
+		}
+		if logs := <-newLogCh; len(logs) != 1 {
+			t.Fatalf("Log number mismatch: have %d, want 1", len(logs))
+		}
+		parent = block
 	}
 
-	exp := ethservice.BlockChain().CurrentBlock().Hash()
-
-	// Introduce the fork point.
-	lastBlockNum := blocks[4].Number()
-	lastBlock := blocks[4]
-	for i := 0; i < 4; i++ {
-		lastBlockNum.Add(lastBlockNum, big.NewInt(1))
-		p := executableData{
-			ParentHash:   lastBlock.Hash(),
-			Miner:        forkedBlocks[i].Coinbase(),
-			StateRoot:    forkedBlocks[i].Root(),
-			Number:       lastBlockNum.Uint64(),
-			GasLimit:     forkedBlocks[i].GasLimit(),
-			GasUsed:      forkedBlocks[i].GasUsed(),
-			Transactions: encodeTransactions(blocks[i].Transactions()),
-			ReceiptRoot:  forkedBlocks[i].ReceiptHash(),
-			LogsBloom:    forkedBlocks[i].Bloom().Bytes(),
-			BlockHash:    forkedBlocks[i].Hash(),
-			Timestamp:    forkedBlocks[i].Time(),
-		}
-		success, err := api.NewBlock(p)
-		if err != nil || !success.Valid {
-			t.Fatalf("Failed to insert forked block #%d: %v", i, err)
+	// Introduce fork chain
+	var (
+		head = ethservice.BlockChain().CurrentBlock().NumberU64()
+	)
+	parent = preMergeBlocks[len(preMergeBlocks)-1]
+	for i := 0; i < 10; i++ {
+		execData, err := assembleBlock(api, parent.Hash(), &PayloadAttributesV1{
+			Timestamp:             parent.Time() + 6,
+			SuggestedFeeRecipient: common.Address{0x01},
+		})
+		if err != nil {
+			t.Fatalf("Failed to create the executable data %v", err)
 		}
-		lastBlock, err = insertBlockParamsToBlock(ethservice.BlockChain().Config(), lastBlock.Header(), p)
+		block, err := ExecutableDataToBlock(*execData)
 		if err != nil {
-			t.Fatal(err)
+			t.Fatalf("Failed to convert executable data to block %v", err)
 		}
+		newResp, err := api.NewPayloadV1(*execData)
+		if err != nil || newResp.Status != VALID {
+			t.Fatalf("Failed to insert block: %v %+v", err, newResp)
+		}
+		if ethservice.BlockChain().CurrentBlock().NumberU64() != head {
+			t.Fatalf("Chain head shouldn't be updated")
+		}
+		parent = block
+	}
+	fcState := ForkchoiceStateV1{
+		HeadBlockHash:      parent.Hash(),
+		SafeBlockHash:      parent.Hash(),
+		FinalizedBlockHash: parent.Hash(),
+	}
+	if _, err := api.ForkchoiceUpdatedV1(fcState, nil); err != nil {
+		t.Fatalf("Failed to set the fork as head: %v", err)
+	}
+	if ethservice.BlockChain().CurrentBlock().Hash() != parent.Hash() {
+		t.Fatalf("Chain head should be updated to the fork")
+	}
+}
+
+func TestEth2RefusePreMerge(t *testing.T) {
+	genesis, blocks := generatePreMergeChain(10)
+	n, ethservice := startEthService(t, genesis, blocks[1:])
+	defer n.Close()
+
+	api := NewConsensusAPI(ethservice)
+
+	// Forkchoice updates to proof-of-work blocks other than the terminal one
+	// are refused
+	resp, err := api.ForkchoiceUpdatedV1(ForkchoiceStateV1{HeadBlockHash: blocks[5].Hash()}, nil)
+	if err != nil {
+		t.Fatalf("failed to update fork choice: %v", err)
+	}
+	if resp.PayloadStatus.Status != INVALID || resp.PayloadStatus.LatestValidHash == nil || *resp.PayloadStatus.LatestValidHash != (common.Hash{}) {
+		t.Fatalf("pre-merge fork choice accepted: %+v", resp.PayloadStatus)
+	}
+	if head := ethservice.BlockChain().CurrentBlock(); head.Hash() != blocks[10].Hash() {
+		t.Fatalf("chain head changed: have %d, want %d", head.NumberU64(), 10)
 	}
+	// Unknown heads are reported as syncing
+	resp, err = api.ForkchoiceUpdatedV1(ForkchoiceStateV1{HeadBlockHash: common.Hash{0x01}}, nil)
+	if err != nil || resp.PayloadStatus.Status != SYNCING {
+		t.Fatalf("unknown head status mismatch: have %v %+v, want %s", err, resp.PayloadStatus, SYNCING)
+	}
+	// Payloads with mangled hashes are rejected
+	execData, err := assembleBlock(api, blocks[10].Hash(), &PayloadAttributesV1{Timestamp: blocks[10].Time() + 5})
+	if err != nil {
+		t.Fatalf("failed to create the executable data %v", err)
+	}
+	execData.BlockHash = common.Hash{0x01}
+	if status, err := api.NewPayloadV1(*execData); err != nil || status.Status != INVALID_BLOCK_HASH {
+		t.Fatalf("mangled payload status mismatch: have %v %+v, want %s", err, status, INVALID_BLOCK_HASH)
+	}
+}
 
-	if ethservice.BlockChain().CurrentBlock().Hash() != exp {
-		t.Fatalf("Wrong head after inserting fork %x != %x", exp, ethservice.BlockChain().CurrentBlock().Hash())
+func TestExchangeTransitionConfig(t *testing.T) {
+	genesis, blocks := generatePreMergeChain(10)
+	n, ethservice := startEthService(t, genesis, blocks[1:])
+	defer n.Close()
+
+	var (
+		api = NewConsensusAPI(ethservice)
+		ttd = genesis.Config.TerminalTotalDifficulty
+	)
+	// invalid ttd
+	config := TransitionConfigurationV1{
+		TerminalTotalDifficulty: (*hexutil.Big)(big.NewInt(0)),
+	}
+	if _, err := api.ExchangeTransitionConfigurationV1(config); err == nil {
+		t.Fatal("expected error on invalid config, invalid ttd")
+	}
+	// invalid terminal block hash
+	config = TransitionConfigurationV1{
+		TerminalTotalDifficulty: (*hexutil.Big)(ttd),
+		TerminalBlockHash:       common.Hash{1},
+		TerminalBlockNumber:     10,
+	}
+	if _, err := api.ExchangeTransitionConfigurationV1(config); err == nil {
+		t.Fatal("expected error on invalid config, invalid hash")
+	}
+	// valid config
+	config = TransitionConfigurationV1{
+		TerminalTotalDifficulty: (*hexutil.Big)(ttd),
+		TerminalBlockHash:       blocks[10].Hash(),
+		TerminalBlockNumber:     10,
+	}
+	if _, err := api.ExchangeTransitionConfigurationV1(config); err != nil {
+		t.Fatalf("expected no error on valid config, got %v", err)
 	}
 }
-*/
 
 // startEthService creates a full node instance for testing.
+// assembleBlock synchronously builds a block on top of the given parent with
+// the pending transactions of the pool.
+func assembleBlock(api *ConsensusAPI, parentHash common.Hash, params *PayloadAttributesV1) (*ExecutableDataV1, error) {
+	block, err := api.eth.Miner().GetSealingBlockSync(parentHash, params.Timestamp, params.SuggestedFeeRecipient, params.PrevRandao, false)
+	if err != nil {
+		return nil, err
+	}
+	return BlockToExecutableData(block), nil
+}
+
 func startEthService(t *testing.T, genesis *core.Genesis, blocks []*types.Block) (*node.Node, *eth.Ethereum) {
 	t.Helper()
 
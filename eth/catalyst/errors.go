@@ -0,0 +1,73 @@
+// Copyright 2021 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package catalyst
+
+import "github.com/ethereum/go-ethereum/rpc"
+
+// EngineAPIError is a standardized error message between consensus and execution
+// clients, also containing any custom error message Geth might include.
+type EngineAPIError struct {
+	code int
+	msg  string
+	err  error
+}
+
+func (e *EngineAPIError) ErrorCode() int { return e.code }
+func (e *EngineAPIError) Error() string  { return e.msg }
+func (e *EngineAPIError) ErrorData() interface{} {
+	if e.err == nil {
+		return nil
+	}
+	return struct {
+		Error string `json:"err"`
+	}{e.err.Error()}
+}
+
+// With returns a copy of the error with a new embedded custom data field.
+func (e *EngineAPIError) With(err error) *EngineAPIError {
+	return &EngineAPIError{
+		code: e.code,
+		msg:  e.msg,
+		err:  err,
+	}
+}
+
+var (
+	_ rpc.Error     = new(EngineAPIError)
+	_ rpc.DataError = new(EngineAPIError)
+)
+
+var (
+	// GenericServerError is returned when an unexpected failure occurs while
+	// serving a request.
+	GenericServerError = &EngineAPIError{code: -32000, msg: "Server error"}
+
+	// UnknownPayload is returned when a payload is requested that was never
+	// built or was already evicted.
+	UnknownPayload = &EngineAPIError{code: -38001, msg: "Unknown payload"}
+
+	// InvalidForkChoiceState is returned if the safe or finalized blocks of a
+	// fork choice are unknown or not canonical.
+	InvalidForkChoiceState = &EngineAPIError{code: -38002, msg: "Invalid forkchoice state"}
+
+	// InvalidPayloadAttributes is returned if a payload can't be built with the
+	// given attributes.
+	InvalidPayloadAttributes = &EngineAPIError{code: -38003, msg: "Invalid payload attributes"}
+
+	// InvalidParams is returned if the request parameters are malformed.
+	InvalidParams = &EngineAPIError{code: -32602, msg: "Invalid parameters"}
+)
@@ -27,6 +27,8 @@ import (
 
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/consensus"
+	"github.com/ethereum/go-ethereum/consensus/beacon"
+	"github.com/ethereum/go-ethereum/consensus/bft"
 	"github.com/ethereum/go-ethereum/consensus/clique"
 	"github.com/ethereum/go-ethereum/consensus/ethash"
 	"github.com/ethereum/go-ethereum/core"
@@ -82,6 +84,9 @@ var Defaults = Config{
 	TrieDirtyCache:          256,
 	TrieTimeout:             60 * time.Minute,
 	SnapshotCache:           102,
+	StatePruningInterval:    10000,
+	StatePruningBloomSize:   2048,
+	StatePruningThrottle:    100 * time.Millisecond,
 	Miner: miner.Config{
 		GasCeil:  8000000,
 		GasPrice: big.NewInt(params.GWei),
@@ -167,6 +172,25 @@ type Config struct {
 	TrieTimeout             time.Duration
 	SnapshotCache           int
 	Preimages               bool
+	StateScheme             string `toml:",omitempty"` // Storage scheme of the trie nodes ("hash" or "path"), defaults to the one of the database
+
+	// Online state pruning options
+	StatePruning          bool          `toml:",omitempty"` // Whether to prune stale state in the background during block import
+	StatePruningInterval  uint64        `toml:",omitempty"` // Minimum number of blocks between two consecutive pruning targets
+	StatePruningBloomSize uint64        `toml:",omitempty"` // Memory allowance (MB) of the pruning state bloom
+	StatePruningThrottle  time.Duration `toml:",omitempty"` // Pause between two consecutive pruning deletion batches
+
+	// Number of recent blocks to keep reverse state diffs for, allowing the state
+	// to be rolled back on deep reorgs and rewinds (0 = disabled)
+	StateDiffs uint64 `toml:",omitempty"`
+
+	// Whether to archive the flat state diffs of all blocks, allowing historical
+	// state reads without keeping the historical tries (archive-lite)
+	StateArchive bool `toml:",omitempty"`
+
+	// Whether to record the preimages of all hashed trie keys, seen both during
+	// block execution and sync, into a dedicated append-only store
+	PreimageStore bool `toml:",omitempty"`
 
 	// Mining options
 	Miner miner.Config
@@ -207,32 +231,43 @@ type Config struct {
 }
 
 // CreateConsensusEngine creates a consensus engine for the given chain configuration.
+// If the chain is configured to transition to proof-of-stake, the engine is wrapped
+// into the beacon engine taking over once the terminal total difficulty is reached.
 func CreateConsensusEngine(stack *node.Node, chainConfig *params.ChainConfig, config *ethash.Config, notify []string, noverify bool, db ethdb.Database) consensus.Engine {
-	// If proof-of-authority is requested, set it up
+	var engine consensus.Engine
 	if chainConfig.Clique != nil {
-		return clique.New(chainConfig.Clique, db)
+		// If proof-of-authority is requested, set it up
+		engine = clique.New(chainConfig.Clique, db)
+	} else if chainConfig.BFT != nil {
+		// If byzantine fault tolerant proof-of-authority is requested, set it up
+		engine = bft.New(chainConfig.BFT, db)
+	} else {
+		// Otherwise assume proof-of-work
+		switch config.PowMode {
+		case ethash.ModeFake:
+			log.Warn("Ethash used in fake mode")
+		case ethash.ModeTest:
+			log.Warn("Ethash used in test mode")
+		case ethash.ModeShared:
+			log.Warn("Ethash used in shared mode")
+		}
+		pow := ethash.New(ethash.Config{
+			PowMode:          config.PowMode,
+			CacheDir:         stack.ResolvePath(config.CacheDir),
+			CachesInMem:      config.CachesInMem,
+			CachesOnDisk:     config.CachesOnDisk,
+			CachesLockMmap:   config.CachesLockMmap,
+			DatasetDir:       config.DatasetDir,
+			DatasetsInMem:    config.DatasetsInMem,
+			DatasetsOnDisk:   config.DatasetsOnDisk,
+			DatasetsLockMmap: config.DatasetsLockMmap,
+			NotifyFull:       config.NotifyFull,
+		}, notify, noverify)
+		pow.SetThreads(-1) // Disable CPU mining
+		engine = pow
 	}
-	// Otherwise assume proof-of-work
-	switch config.PowMode {
-	case ethash.ModeFake:
-		log.Warn("Ethash used in fake mode")
-	case ethash.ModeTest:
-		log.Warn("Ethash used in test mode")
-	case ethash.ModeShared:
-		log.Warn("Ethash used in shared mode")
+	if chainConfig.TerminalTotalDifficulty != nil {
+		engine = beacon.New(engine)
 	}
-	engine := ethash.New(ethash.Config{
-		PowMode:          config.PowMode,
-		CacheDir:         stack.ResolvePath(config.CacheDir),
-		CachesInMem:      config.CachesInMem,
-		CachesOnDisk:     config.CachesOnDisk,
-		CachesLockMmap:   config.CachesLockMmap,
-		DatasetDir:       config.DatasetDir,
-		DatasetsInMem:    config.DatasetsInMem,
-		DatasetsOnDisk:   config.DatasetsOnDisk,
-		DatasetsLockMmap: config.DatasetsLockMmap,
-		NotifyFull:       config.NotifyFull,
-	}, notify, noverify)
-	engine.SetThreads(-1) // Disable CPU mining
 	return engine
 }
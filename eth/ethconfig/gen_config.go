@@ -48,6 +48,14 @@ func (c Config) MarshalTOML() (interface{}, error) {
 		TrieTimeout             time.Duration
 		SnapshotCache           int
 		Preimages               bool
+		StateScheme             string        `toml:",omitempty"`
+		StatePruning            bool          `toml:",omitempty"`
+		StatePruningInterval    uint64        `toml:",omitempty"`
+		StatePruningBloomSize   uint64        `toml:",omitempty"`
+		StatePruningThrottle    time.Duration `toml:",omitempty"`
+		StateDiffs              uint64        `toml:",omitempty"`
+		StateArchive            bool          `toml:",omitempty"`
+		PreimageStore           bool          `toml:",omitempty"`
 		Miner                   miner.Config
 		Ethash                  ethash.Config
 		TxPool                  core.TxPoolConfig
@@ -92,6 +100,14 @@ func (c Config) MarshalTOML() (interface{}, error) {
 	enc.TrieTimeout = c.TrieTimeout
 	enc.SnapshotCache = c.SnapshotCache
 	enc.Preimages = c.Preimages
+	enc.StateScheme = c.StateScheme
+	enc.StatePruning = c.StatePruning
+	enc.StatePruningInterval = c.StatePruningInterval
+	enc.StatePruningBloomSize = c.StatePruningBloomSize
+	enc.StatePruningThrottle = c.StatePruningThrottle
+	enc.StateDiffs = c.StateDiffs
+	enc.StateArchive = c.StateArchive
+	enc.PreimageStore = c.PreimageStore
 	enc.Miner = c.Miner
 	enc.Ethash = c.Ethash
 	enc.TxPool = c.TxPool
@@ -140,6 +156,14 @@ func (c *Config) UnmarshalTOML(unmarshal func(interface{}) error) error {
 		TrieTimeout             *time.Duration
 		SnapshotCache           *int
 		Preimages               *bool
+		StateScheme             *string        `toml:",omitempty"`
+		StatePruning            *bool          `toml:",omitempty"`
+		StatePruningInterval    *uint64        `toml:",omitempty"`
+		StatePruningBloomSize   *uint64        `toml:",omitempty"`
+		StatePruningThrottle    *time.Duration `toml:",omitempty"`
+		StateDiffs              *uint64        `toml:",omitempty"`
+		StateArchive            *bool          `toml:",omitempty"`
+		PreimageStore           *bool          `toml:",omitempty"`
 		Miner                   *miner.Config
 		Ethash                  *ethash.Config
 		TxPool                  *core.TxPoolConfig
@@ -247,6 +271,30 @@ func (c *Config) UnmarshalTOML(unmarshal func(interface{}) error) error {
 	if dec.Preimages != nil {
 		c.Preimages = *dec.Preimages
 	}
+	if dec.StateScheme != nil {
+		c.StateScheme = *dec.StateScheme
+	}
+	if dec.StatePruning != nil {
+		c.StatePruning = *dec.StatePruning
+	}
+	if dec.StatePruningInterval != nil {
+		c.StatePruningInterval = *dec.StatePruningInterval
+	}
+	if dec.StatePruningBloomSize != nil {
+		c.StatePruningBloomSize = *dec.StatePruningBloomSize
+	}
+	if dec.StatePruningThrottle != nil {
+		c.StatePruningThrottle = *dec.StatePruningThrottle
+	}
+	if dec.StateDiffs != nil {
+		c.StateDiffs = *dec.StateDiffs
+	}
+	if dec.StateArchive != nil {
+		c.StateArchive = *dec.StateArchive
+	}
+	if dec.PreimageStore != nil {
+		c.PreimageStore = *dec.PreimageStore
+	}
 	if dec.Miner != nil {
 		c.Miner = *dec.Miner
 	}
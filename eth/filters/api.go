@@ -31,6 +31,7 @@ import (
 	"github.com/ethereum/go-ethereum/core/types"
 	"github.com/ethereum/go-ethereum/ethdb"
 	"github.com/ethereum/go-ethereum/event"
+	"github.com/ethereum/go-ethereum/internal/ethapi"
 	"github.com/ethereum/go-ethereum/rpc"
 )
 
@@ -40,6 +41,8 @@ type filter struct {
 	typ      Type
 	deadline *time.Timer // filter is inactiv when deadline triggers
 	hashes   []common.Hash
+	fullTx   bool                 // whether to return full pending transactions instead of hashes
+	txs      []*types.Transaction // pending transactions collected since the last poll
 	crit     FilterCriteria
 	logs     []*types.Log
 	s        *Subscription // associated subscription in event system
@@ -102,30 +105,75 @@ func (api *PublicFilterAPI) timeoutLoop(timeout time.Duration) {
 	}
 }
 
-// NewPendingTransactionFilter creates a filter that fetches pending transaction hashes
-// as transactions enter the pending state.
+// PendingTxCriteria restricts the pending transactions reported to subscribers
+// and filters by their sender and recipient.
+type PendingTxCriteria struct {
+	From []common.Address `json:"from"` // Senders to report the transactions of, empty for all
+	To   []common.Address `json:"to"`   // Recipients to report the transactions to, empty for all
+}
+
+// match reports whether the transaction from the given sender satisfies the
+// criteria. Contract creations never match recipient restrictions.
+func (crit *PendingTxCriteria) match(tx *types.Transaction, from common.Address) bool {
+	if crit == nil {
+		return true
+	}
+	if len(crit.From) > 0 && !includes(crit.From, from) {
+		return false
+	}
+	if len(crit.To) > 0 && (tx.To() == nil || !includes(crit.To, *tx.To())) {
+		return false
+	}
+	return true
+}
+
+// filterPendingTxs returns the transactions matching the criteria.
+func (api *PublicFilterAPI) filterPendingTxs(txs []*types.Transaction, crit *PendingTxCriteria) []*types.Transaction {
+	if crit == nil || (len(crit.From) == 0 && len(crit.To) == 0) {
+		return txs
+	}
+	var (
+		signer  = types.LatestSigner(api.backend.ChainConfig())
+		matched []*types.Transaction
+	)
+	for _, tx := range txs {
+		from, err := types.Sender(signer, tx)
+		if err != nil {
+			continue
+		}
+		if crit.match(tx, from) {
+			matched = append(matched, tx)
+		}
+	}
+	return matched
+}
+
+// NewPendingTransactionFilter creates a filter that fetches pending transactions
+// as they enter the pending state, optionally restricted by sender and recipient.
+// Their hashes are returned, or the full transactions if fullTx is set.
 //
 // It is part of the filter package because this filter can be used through the
 // `eth_getFilterChanges` polling method that is also used for log filters.
 //
 // https://eth.wiki/json-rpc/API#eth_newpendingtransactionfilter
-func (api *PublicFilterAPI) NewPendingTransactionFilter() rpc.ID {
+func (api *PublicFilterAPI) NewPendingTransactionFilter(fullTx *bool, crit *PendingTxCriteria) rpc.ID {
 	var (
-		pendingTxs   = make(chan []common.Hash)
+		pendingTxs   = make(chan []*types.Transaction)
 		pendingTxSub = api.events.SubscribePendingTxs(pendingTxs)
 	)
 
 	api.filtersMu.Lock()
-	api.filters[pendingTxSub.ID] = &filter{typ: PendingTransactionsSubscription, deadline: time.NewTimer(api.timeout), hashes: make([]common.Hash, 0), s: pendingTxSub}
+	api.filters[pendingTxSub.ID] = &filter{typ: PendingTransactionsSubscription, fullTx: fullTx != nil && *fullTx, deadline: time.NewTimer(api.timeout), txs: make([]*types.Transaction, 0), s: pendingTxSub}
 	api.filtersMu.Unlock()
 
 	go func() {
 		for {
 			select {
-			case ph := <-pendingTxs:
+			case pTx := <-pendingTxs:
+				pTx = api.filterPendingTxs(pTx, crit)
 				api.filtersMu.Lock()
 				if f, found := api.filters[pendingTxSub.ID]; found {
-					f.hashes = append(f.hashes, ph...)
+					f.txs = append(f.txs, pTx...)
 				}
 				api.filtersMu.Unlock()
 			case <-pendingTxSub.Err():
@@ -141,8 +189,9 @@ func (api *PublicFilterAPI) NewPendingTransactionFilter() rpc.ID {
 }
 
 // NewPendingTransactions creates a subscription that is triggered each time a transaction
-// enters the transaction pool and was signed from one of the transactions this nodes manages.
-func (api *PublicFilterAPI) NewPendingTransactions(ctx context.Context) (*rpc.Subscription, error) {
+// enters the transaction pool, optionally restricted by sender and recipient. The hash of
+// the transaction is sent, or the full transaction if fullTx is set.
+func (api *PublicFilterAPI) NewPendingTransactions(ctx context.Context, fullTx *bool, crit *PendingTxCriteria) (*rpc.Subscription, error) {
 	notifier, supported := rpc.NotifierFromContext(ctx)
 	if !supported {
 		return &rpc.Subscription{}, rpc.ErrNotificationsUnsupported
@@ -151,16 +200,24 @@ func (api *PublicFilterAPI) NewPendingTransactions(ctx context.Context) (*rpc.Su
 	rpcSub := notifier.CreateSubscription()
 
 	go func() {
-		txHashes := make(chan []common.Hash, 128)
-		pendingTxSub := api.events.SubscribePendingTxs(txHashes)
+		txs := make(chan []*types.Transaction, 128)
+		pendingTxSub := api.events.SubscribePendingTxs(txs)
 
 		for {
 			select {
-			case hashes := <-txHashes:
+			case txs := <-txs:
 				// To keep the original behaviour, send a single tx hash in one notification.
 				// TODO(rjl493456442) Send a batch of tx hashes in one notification
-				for _, h := range hashes {
-					notifier.Notify(rpcSub.ID, h)
+				txs = api.filterPendingTxs(txs, crit)
+				if fullTx != nil && *fullTx {
+					header, config := api.backend.CurrentHeader(), api.backend.ChainConfig()
+					for _, tx := range txs {
+						notifier.Notify(rpcSub.ID, ethapi.NewRPCPendingTransaction(tx, header, config))
+					}
+				} else {
+					for _, tx := range txs {
+						notifier.Notify(rpcSub.ID, tx.Hash())
+					}
 				}
 			case <-rpcSub.Err():
 				pendingTxSub.Unsubscribe()
@@ -431,7 +488,23 @@ func (api *PublicFilterAPI) GetFilterChanges(id rpc.ID) (interface{}, error) {
 		f.deadline.Reset(api.timeout)
 
 		switch f.typ {
-		case PendingTransactionsSubscription, BlocksSubscription:
+		case PendingTransactionsSubscription:
+			txs := f.txs
+			f.txs = nil
+			if f.fullTx {
+				header, config := api.backend.CurrentHeader(), api.backend.ChainConfig()
+				rpcTxs := make([]*ethapi.RPCTransaction, 0, len(txs))
+				for _, tx := range txs {
+					rpcTxs = append(rpcTxs, ethapi.NewRPCPendingTransaction(tx, header, config))
+				}
+				return rpcTxs, nil
+			}
+			hashes := make([]common.Hash, 0, len(txs))
+			for _, tx := range txs {
+				hashes = append(hashes, tx.Hash())
+			}
+			return hashes, nil
+		case BlocksSubscription:
 			hashes := f.hashes
 			f.hashes = nil
 			return returnHashes(hashes), nil
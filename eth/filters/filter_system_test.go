@@ -18,6 +18,7 @@ package filters
 
 import (
 	"context"
+	"crypto/ecdsa"
 	"fmt"
 	"math/big"
 	"math/rand"
@@ -33,8 +34,10 @@ import (
 	"github.com/ethereum/go-ethereum/core/bloombits"
 	"github.com/ethereum/go-ethereum/core/rawdb"
 	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
 	"github.com/ethereum/go-ethereum/ethdb"
 	"github.com/ethereum/go-ethereum/event"
+	"github.com/ethereum/go-ethereum/internal/ethapi"
 	"github.com/ethereum/go-ethereum/params"
 	"github.com/ethereum/go-ethereum/rpc"
 )
@@ -58,6 +61,15 @@ func (b *testBackend) ChainDb() ethdb.Database {
 	return b.db
 }
 
+func (b *testBackend) ChainConfig() *params.ChainConfig {
+	return params.TestChainConfig
+}
+
+func (b *testBackend) CurrentHeader() *types.Header {
+	hdr, _ := b.HeaderByNumber(context.TODO(), rpc.LatestBlockNumber)
+	return hdr
+}
+
 func (b *testBackend) HeaderByNumber(ctx context.Context, blockNr rpc.BlockNumber) (*types.Header, error) {
 	var (
 		hash common.Hash
@@ -233,7 +245,7 @@ func TestPendingTxFilter(t *testing.T) {
 		hashes []common.Hash
 	)
 
-	fid0 := api.NewPendingTransactionFilter()
+	fid0 := api.NewPendingTransactionFilter(nil, nil)
 
 	time.Sleep(1 * time.Second)
 	backend.txFeed.Send(core.NewTxsEvent{Txs: transactions})
@@ -269,6 +281,96 @@ func TestPendingTxFilter(t *testing.T) {
 	}
 }
 
+// TestPendingTxFilterFullTx tests that pending tx filters and subscriptions
+// return full transactions if requested, restricted by sender and recipient.
+func TestPendingTxFilterFullTx(t *testing.T) {
+	t.Parallel()
+
+	var (
+		db      = rawdb.NewMemoryDatabase()
+		backend = &testBackend{db: db}
+		api     = NewPublicFilterAPI(backend, false, deadline)
+		signer  = types.LatestSigner(backend.ChainConfig())
+
+		keyA, _ = crypto.GenerateKey()
+		keyB, _ = crypto.GenerateKey()
+		addrA   = crypto.PubkeyToAddress(keyA.PublicKey)
+		toA     = common.Address{0xaa}
+		toB     = common.Address{0xbb}
+		fullTx  = true
+		crit    = &PendingTxCriteria{From: []common.Address{addrA}, To: []common.Address{toA}}
+	)
+	sign := func(nonce uint64, to *common.Address, key *ecdsa.PrivateKey) *types.Transaction {
+		tx, _ := types.SignNewTx(key, signer, &types.LegacyTx{Nonce: nonce, To: to, Gas: 21000, GasPrice: big.NewInt(1)})
+		return tx
+	}
+	transactions := []*types.Transaction{
+		sign(0, &toA, keyA),
+		sign(1, &toB, keyA),
+		sign(0, &toA, keyB),
+		sign(2, nil, keyA),
+		sign(3, &toA, keyA),
+	}
+	want := []*types.Transaction{transactions[0], transactions[4]}
+
+	// Subscribe to the full transactions over RPC too
+	server := rpc.NewServer()
+	defer server.Stop()
+	if err := server.RegisterName("eth", api); err != nil {
+		t.Fatalf("failed to register filter API: %v", err)
+	}
+	client := rpc.DialInProc(server)
+	defer client.Close()
+
+	notifications := make(chan *ethapi.RPCTransaction, len(transactions))
+	sub, err := client.EthSubscribe(context.Background(), notifications, "newPendingTransactions", fullTx, crit)
+	if err != nil {
+		t.Fatalf("failed to subscribe to pending transactions: %v", err)
+	}
+	defer sub.Unsubscribe()
+
+	fid := api.NewPendingTransactionFilter(&fullTx, crit)
+
+	time.Sleep(1 * time.Second)
+	backend.txFeed.Send(core.NewTxsEvent{Txs: transactions})
+
+	var (
+		polled  []*ethapi.RPCTransaction
+		timeout = time.Now().Add(time.Second)
+	)
+	for len(polled) < len(want) && time.Now().Before(timeout) {
+		results, err := api.GetFilterChanges(fid)
+		if err != nil {
+			t.Fatalf("Unable to retrieve transactions: %v", err)
+		}
+		polled = append(polled, results.([]*ethapi.RPCTransaction)...)
+		time.Sleep(100 * time.Millisecond)
+	}
+	if len(polled) != len(want) {
+		t.Fatalf("invalid number of polled transactions: have %d, want %d", len(polled), len(want))
+	}
+	for i, tx := range want {
+		if polled[i].Hash != tx.Hash() || polled[i].From != addrA {
+			t.Errorf("polled transaction %d mismatch: have %x from %x, want %x", i, polled[i].Hash, polled[i].From, tx.Hash())
+		}
+		select {
+		case notified := <-notifications:
+			if notified.Hash != tx.Hash() || notified.From != addrA {
+				t.Errorf("notified transaction %d mismatch: have %x from %x, want %x", i, notified.Hash, notified.From, tx.Hash())
+			}
+		case err := <-sub.Err():
+			t.Fatalf("subscription failed: %v", err)
+		case <-time.After(time.Second):
+			t.Fatalf("notification %d timed out", i)
+		}
+	}
+	select {
+	case notified := <-notifications:
+		t.Fatalf("unexpected notification: %x", notified.Hash)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
 // TestLogFilterCreation test whether a given filter criteria makes sense.
 // If not it must return an error.
 func TestLogFilterCreation(t *testing.T) {
@@ -688,7 +790,7 @@ func TestPendingTxFilterDeadlock(t *testing.T) {
 	// timeout either in 100ms or 200ms
 	fids := make([]rpc.ID, 20)
 	for i := 0; i < len(fids); i++ {
-		fid := api.NewPendingTransactionFilter()
+		fid := api.NewPendingTransactionFilter(nil, nil)
 		fids[i] = fid
 		// Wait for at least one tx to arrive in filter
 		for {
@@ -308,6 +308,15 @@ func testSendTransactions(t *testing.T, protocol uint) {
 
 		insert[nonce] = tx
 	}
+	// Mark some of the transactions private, these must never reach the peers
+	public := make([]*types.Transaction, 0, len(insert))
+	for i, tx := range insert {
+		if i%10 == 0 {
+			handler.txpool.private[tx.Hash()] = true
+		} else {
+			public = append(public, tx)
+		}
+	}
 	go handler.txpool.AddRemotes(insert) // Need goroutine to not block on feed
 	time.Sleep(250 * time.Millisecond)   // Wait until tx events get out of the system (can't use events, tx broadcaster races with peer join)
 
@@ -349,7 +358,7 @@ func testSendTransactions(t *testing.T, protocol uint) {
 
 	// Make sure we get all the transactions on the correct channels
 	seen := make(map[common.Hash]struct{})
-	for len(seen) < len(insert) {
+	for len(seen) < len(public) {
 		switch protocol {
 		case 65, 66:
 			select {
@@ -358,6 +367,9 @@ func testSendTransactions(t *testing.T, protocol uint) {
 					if _, ok := seen[hash]; ok {
 						t.Errorf("duplicate transaction announced: %x", hash)
 					}
+					if handler.txpool.IsPrivate(hash) {
+						t.Errorf("private transaction announced: %x", hash)
+					}
 					seen[hash] = struct{}{}
 				}
 			case <-bcasts:
@@ -368,11 +380,18 @@ func testSendTransactions(t *testing.T, protocol uint) {
 			panic("unsupported protocol, please extend test")
 		}
 	}
-	for _, tx := range insert {
+	for _, tx := range public {
 		if _, ok := seen[tx.Hash()]; !ok {
 			t.Errorf("missing transaction: %x", tx.Hash())
 		}
 	}
+	// Ensure the private transactions are not served to the peers either
+	pool := (*ethHandler)(handler.handler).TxPool()
+	for i, tx := range insert {
+		if served := pool.Get(tx.Hash()) != nil; served != (i%10 != 0) {
+			t.Errorf("transaction %d served mismatch: have %v", i, served)
+		}
+	}
 }
 
 // Tests that transactions get propagated to all attached peers, either via direct
@@ -0,0 +1,100 @@
+// Copyright 2021 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package snap
+
+import (
+	"sync"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/log"
+)
+
+const (
+	// preimageAddressCandidates is the number of low addresses (the zero address,
+	// the precompiles and the system contracts) whose preimages are recognised
+	// among the synced account hashes.
+	preimageAddressCandidates = 256
+
+	// preimageSlotCandidates is the number of low storage slots (where contracts
+	// keep their fixed size variables) whose preimages are recognised among the
+	// synced storage hashes.
+	preimageSlotCandidates = 1024
+)
+
+// PreimageWriter is a store the preimages of the synced hashed keys are recorded
+// into.
+type PreimageWriter interface {
+	Write(preimages map[common.Hash][]byte) error
+}
+
+var (
+	preimageCandidates     map[common.Hash][]byte // Known preimages matched against the synced keys
+	preimageCandidatesOnce sync.Once              // Guard building the candidates on first use
+)
+
+// knownPreimages returns the preimages the synced hashed keys are matched with.
+// The snap protocol only delivers the hashes of the keys, so the preimages can
+// only be recovered for keys that are known upfront.
+func knownPreimages() map[common.Hash][]byte {
+	preimageCandidatesOnce.Do(func() {
+		preimageCandidates = make(map[common.Hash][]byte, preimageAddressCandidates+preimageSlotCandidates)
+		for i := 0; i < preimageAddressCandidates; i++ {
+			var addr common.Address
+			addr[common.AddressLength-1] = byte(i)
+			preimageCandidates[crypto.Keccak256Hash(addr[:])] = addr[:]
+		}
+		for i := 0; i < preimageSlotCandidates; i++ {
+			var slot common.Hash
+			slot[common.HashLength-2], slot[common.HashLength-1] = byte(i>>8), byte(i)
+			preimageCandidates[crypto.Keccak256Hash(slot[:])] = slot[:]
+		}
+	})
+	return preimageCandidates
+}
+
+// SetPreimageWriter sets the store the preimages of the synced accounts and
+// storage slots are recorded into. It must be called before syncing starts.
+func (s *Syncer) SetPreimageWriter(writer PreimageWriter) {
+	s.preimages = writer
+}
+
+// recordPreimages records the preimages of the given synced hashed keys, for
+// the ones recognised.
+func (s *Syncer) recordPreimages(hashes []common.Hash) {
+	if s.preimages == nil {
+		return
+	}
+	var (
+		known     = knownPreimages()
+		preimages map[common.Hash][]byte
+	)
+	for _, hash := range hashes {
+		if preimage, ok := known[hash]; ok {
+			if preimages == nil {
+				preimages = make(map[common.Hash][]byte)
+			}
+			preimages[hash] = preimage
+		}
+	}
+	if len(preimages) == 0 {
+		return
+	}
+	if err := s.preimages.Write(preimages); err != nil {
+		log.Error("Failed to write synced preimages", "err", err)
+	}
+}
@@ -0,0 +1,64 @@
+// Copyright 2021 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package snap
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// testPreimageWriter gathers the preimages recorded by the syncer.
+type testPreimageWriter map[common.Hash][]byte
+
+func (w testPreimageWriter) Write(preimages map[common.Hash][]byte) error {
+	for hash, preimage := range preimages {
+		w[hash] = preimage
+	}
+	return nil
+}
+
+// Tests that the preimages of the well known keys are recognised among the
+// synced hashes and recorded, while the unknown ones are skipped.
+func TestRecordPreimages(t *testing.T) {
+	var (
+		writer = make(testPreimageWriter)
+		syncer = NewSyncer(rawdb.NewMemoryDatabase())
+
+		addr    = common.BytesToAddress([]byte{0x04})
+		slot    = common.BigToHash(common.Big3)
+		unknown = common.HexToHash("0xdeadbeef")
+	)
+	// Without a writer set, nothing should be recorded (nor crash)
+	syncer.recordPreimages([]common.Hash{crypto.Keccak256Hash(addr[:])})
+
+	syncer.SetPreimageWriter(writer)
+	syncer.recordPreimages([]common.Hash{crypto.Keccak256Hash(addr[:]), crypto.Keccak256Hash(slot[:]), crypto.Keccak256Hash(unknown[:])})
+
+	if len(writer) != 2 {
+		t.Fatalf("recorded preimage count mismatch: have %d, want %d", len(writer), 2)
+	}
+	if preimage := writer[crypto.Keccak256Hash(addr[:])]; !bytes.Equal(preimage, addr[:]) {
+		t.Errorf("address preimage mismatch: have %x, want %x", preimage, addr)
+	}
+	if preimage := writer[crypto.Keccak256Hash(slot[:])]; !bytes.Equal(preimage, slot[:]) {
+		t.Errorf("slot preimage mismatch: have %x, want %x", preimage, slot)
+	}
+}
@@ -179,6 +179,12 @@ func (ec *Client) SubscribePendingTransactions(ctx context.Context, ch chan<- co
 	return ec.c.EthSubscribe(ctx, ch, "newPendingTransactions")
 }
 
+// SubscribeFullPendingTransactions subscribes to new pending transactions,
+// delivering the full transactions instead of their hashes.
+func (ec *Client) SubscribeFullPendingTransactions(ctx context.Context, ch chan<- *types.Transaction) (*rpc.ClientSubscription, error) {
+	return ec.c.EthSubscribe(ctx, ch, "newPendingTransactions", true)
+}
+
 func toBlockNumArg(number *big.Int) string {
 	if number == nil {
 		return "latest"
@@ -255,6 +255,8 @@ func testSubscribePendingTransactions(t *testing.T, client *rpc.Client) {
 	// Subscribe to Transactions
 	ch := make(chan common.Hash)
 	ec.SubscribePendingTransactions(context.Background(), ch)
+	fullCh := make(chan *types.Transaction, 1)
+	ec.SubscribeFullPendingTransactions(context.Background(), fullCh)
 	// Send a transaction
 	chainID, err := ethcl.ChainID(context.Background())
 	if err != nil {
@@ -281,6 +283,9 @@ func testSubscribePendingTransactions(t *testing.T, client *rpc.Client) {
 	if hash != signedTx.Hash() {
 		t.Fatalf("Invalid tx hash received, got %v, want %v", hash, signedTx.Hash())
 	}
+	if full := <-fullCh; full.Hash() != signedTx.Hash() {
+		t.Fatalf("Invalid tx received, got %v, want %v", full.Hash(), signedTx.Hash())
+	}
 }
 
 func testCallContract(t *testing.T, client *rpc.Client) {
@@ -86,6 +86,10 @@ type AncientReader interface {
 	// Ancients returns the ancient item numbers in the ancient store.
 	Ancients() (uint64, error)
 
+	// Tail returns the number of the first stored item in the ancient store,
+	// which is the number of items deleted from the tail as well.
+	Tail() (uint64, error)
+
 	// AncientSize returns the ancient size of the specified category.
 	AncientSize(kind string) (uint64, error)
 }
@@ -100,6 +104,11 @@ type AncientWriter interface {
 	// TruncateAncients discards all but the first n ancient data from the ancient store.
 	TruncateAncients(n uint64) error
 
+	// TruncateTail discards the first n ancient data from the ancient store. As
+	// the data is deleted by files, some of these items may be retained. If n is
+	// beyond the stored items, all data is discarded and the store continues at n.
+	TruncateTail(n uint64) error
+
 	// Sync flushes all in-memory ancient store data to disk.
 	Sync() error
 }
@@ -0,0 +1,102 @@
+// Copyright 2021 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package era
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// accumulatorDepth is the depth of the merkle tree over MaxEra1Size header
+// records, i.e. log2(MaxEra1Size).
+const accumulatorDepth = 13
+
+// zeroHashes contains the roots of empty subtrees at every height, used to pad
+// the accumulator tree up to its fixed capacity.
+var zeroHashes = func() [accumulatorDepth + 1][32]byte {
+	var hashes [accumulatorDepth + 1][32]byte
+	for i := 1; i <= accumulatorDepth; i++ {
+		hashes[i] = sha256.Sum256(append(hashes[i-1][:], hashes[i-1][:]...))
+	}
+	return hashes
+}()
+
+// ComputeAccumulator calculates the SSZ hash tree root of the list of header
+// records, each one being a (block hash, total difficulty) tuple. The result
+// matches hash_tree_root(List[HeaderRecord, 8192]) of the era1 specification.
+func ComputeAccumulator(hashes []common.Hash, tds []*big.Int) (common.Hash, error) {
+	if len(hashes) != len(tds) {
+		return common.Hash{}, fmt.Errorf("number of block hashes (%d) and total difficulties (%d) differ", len(hashes), len(tds))
+	}
+	if len(hashes) > MaxEra1Size {
+		return common.Hash{}, fmt.Errorf("too many records: have %d, max %d", len(hashes), MaxEra1Size)
+	}
+	layer := make([][32]byte, len(hashes))
+	for i := range hashes {
+		td, err := uint256LE(tds[i])
+		if err != nil {
+			return common.Hash{}, err
+		}
+		layer[i] = sha256.Sum256(append(hashes[i].Bytes(), td...))
+	}
+	// Merkleize the records, padding every layer with the empty subtree root
+	for depth := 0; depth < accumulatorDepth; depth++ {
+		next := make([][32]byte, (len(layer)+1)/2)
+		for i := range next {
+			left, right := layer[2*i], zeroHashes[depth]
+			if 2*i+1 < len(layer) {
+				right = layer[2*i+1]
+			}
+			next[i] = sha256.Sum256(append(left[:], right[:]...))
+		}
+		layer = next
+	}
+	root := zeroHashes[accumulatorDepth]
+	if len(layer) > 0 {
+		root = layer[0]
+	}
+	// Mix in the length of the list as a little endian uint256
+	var length [32]byte
+	binary.LittleEndian.PutUint64(length[:8], uint64(len(hashes)))
+	return common.Hash(sha256.Sum256(append(root[:], length[:]...))), nil
+}
+
+// uint256LE encodes a big integer as a 32 byte little endian value.
+func uint256LE(n *big.Int) ([]byte, error) {
+	if n.Sign() < 0 || n.BitLen() > 256 {
+		return nil, fmt.Errorf("total difficulty out of range: %v", n)
+	}
+	b := make([]byte, 32)
+	n.FillBytes(b)
+	for i, j := 0, len(b)-1; i < j; i, j = i+1, j-1 {
+		b[i], b[j] = b[j], b[i]
+	}
+	return b, nil
+}
+
+// leUint256 decodes a 32 byte little endian value into a big integer.
+func leUint256(b []byte) *big.Int {
+	be := make([]byte, len(b))
+	for i := range b {
+		be[len(b)-1-i] = b[i]
+	}
+	return new(big.Int).SetBytes(be)
+}
@@ -0,0 +1,152 @@
+// Copyright 2021 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+// Package e2store implements the e2store container format, a simple
+// type-length-value record stream used as the basis of era archives.
+//
+// Each entry is laid out as:
+//
+//   type (2 bytes LE) | length (4 bytes LE) | reserved (2 bytes, zero) | data
+package e2store
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// headerSize is the size of the fixed entry header.
+const headerSize = 8
+
+var errReservedNonZero = errors.New("e2store: reserved header bytes must be zero")
+
+// Entry is a single type-length-value record.
+type Entry struct {
+	Type  uint16
+	Value []byte
+}
+
+// Writer writes entries in the e2store format to an underlying stream.
+type Writer struct {
+	w io.Writer
+}
+
+// NewWriter returns a new e2store writer wrapping w.
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{w: w}
+}
+
+// Write writes a single entry with the given type and payload, returning the
+// total number of bytes written including the header.
+func (w *Writer) Write(typ uint16, b []byte) (int, error) {
+	if uint64(len(b)) > uint64(^uint32(0)) {
+		return 0, fmt.Errorf("e2store: entry too large (%d bytes)", len(b))
+	}
+	var header [headerSize]byte
+	binary.LittleEndian.PutUint16(header[0:2], typ)
+	binary.LittleEndian.PutUint32(header[2:6], uint32(len(b)))
+
+	n, err := w.w.Write(header[:])
+	if err != nil {
+		return n, err
+	}
+	m, err := w.w.Write(b)
+	return n + m, err
+}
+
+// Reader reads entries from an e2store formatted stream with random access.
+type Reader struct {
+	r      io.ReaderAt
+	offset int64
+}
+
+// NewReader returns a new e2store reader wrapping r.
+func NewReader(r io.ReaderAt) *Reader {
+	return &Reader{r: r}
+}
+
+// Read reads the next entry from the stream, advancing the internal offset.
+func (r *Reader) Read() (*Entry, error) {
+	e, n, err := r.ReadAt(r.offset)
+	if err != nil {
+		return nil, err
+	}
+	r.offset += n
+	return e, nil
+}
+
+// ReadAt reads the entry located at the given offset, returning it along with
+// the total number of bytes it occupies.
+func (r *Reader) ReadAt(off int64) (*Entry, int64, error) {
+	typ, length, err := r.ReadMetadataAt(off)
+	if err != nil {
+		return nil, 0, err
+	}
+	entry := &Entry{Type: typ, Value: make([]byte, length)}
+	if length > 0 {
+		if _, err := r.r.ReadAt(entry.Value, off+headerSize); err != nil {
+			if err == io.EOF {
+				return nil, 0, io.ErrUnexpectedEOF
+			}
+			return nil, 0, err
+		}
+	}
+	return entry, headerSize + int64(length), nil
+}
+
+// ReaderAt returns a reader for the payload of the entry at the given offset
+// without loading it into memory, along with the total size of the entry.
+func (r *Reader) ReaderAt(expType uint16, off int64) (io.Reader, int64, error) {
+	typ, length, err := r.ReadMetadataAt(off)
+	if err != nil {
+		return nil, 0, err
+	}
+	if typ != expType {
+		return nil, 0, fmt.Errorf("e2store: wrong entry type, want %#x have %#x", expType, typ)
+	}
+	return io.NewSectionReader(r.r, off+headerSize, int64(length)), headerSize + int64(length), nil
+}
+
+// ReadMetadataAt reads the header of the entry located at the given offset.
+func (r *Reader) ReadMetadataAt(off int64) (uint16, uint32, error) {
+	var header [headerSize]byte
+	if n, err := r.r.ReadAt(header[:], off); err != nil {
+		if err == io.EOF && n > 0 {
+			return 0, 0, io.ErrUnexpectedEOF
+		}
+		return 0, 0, err
+	}
+	if header[6] != 0 || header[7] != 0 {
+		return 0, 0, errReservedNonZero
+	}
+	return binary.LittleEndian.Uint16(header[0:2]), binary.LittleEndian.Uint32(header[2:6]), nil
+}
+
+// Find returns the first entry with the given type, scanning from the start.
+func (r *Reader) Find(want uint16) (*Entry, error) {
+	var off int64
+	for {
+		e, n, err := r.ReadAt(off)
+		if err != nil {
+			return nil, err
+		}
+		if e.Type == want {
+			return e, nil
+		}
+		off += n
+	}
+}
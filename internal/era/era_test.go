@@ -0,0 +1,184 @@
+// Copyright 2021 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package era
+
+import (
+	"bytes"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/trie"
+)
+
+// makeTestChain creates a chain of blocks with a single transaction and
+// receipt each, along with the running total difficulties.
+func makeTestChain(start uint64, n int) ([]*types.Block, []types.Receipts, []*big.Int) {
+	var (
+		blocks   []*types.Block
+		receipts []types.Receipts
+		tds      []*big.Int
+		td       = big.NewInt(int64(start))
+		parent   common.Hash
+	)
+	for i := 0; i < n; i++ {
+		num := start + uint64(i)
+		tx := types.NewTransaction(num, common.Address{0xaa}, big.NewInt(int64(i)), 21000, big.NewInt(1), []byte{byte(i)})
+		receipt := &types.Receipt{
+			Type:              types.LegacyTxType,
+			Status:            types.ReceiptStatusSuccessful,
+			CumulativeGasUsed: 21000,
+			Logs:              []*types.Log{{Address: common.Address{byte(i)}, Topics: []common.Hash{{byte(i)}}}},
+		}
+		receipt.Bloom = types.CreateBloom(types.Receipts{receipt})
+		header := &types.Header{
+			ParentHash: parent,
+			Number:     new(big.Int).SetUint64(num),
+			Difficulty: big.NewInt(1),
+			GasLimit:   8000000,
+		}
+		block := types.NewBlock(header, []*types.Transaction{tx}, nil, []*types.Receipt{receipt}, trie.NewStackTrie(nil))
+		td = new(big.Int).Add(td, block.Difficulty())
+
+		blocks = append(blocks, block)
+		receipts = append(receipts, types.Receipts{receipt})
+		tds = append(tds, td)
+		parent = block.Hash()
+	}
+	return blocks, receipts, tds
+}
+
+func TestEra1Builder(t *testing.T) {
+	dir, err := ioutil.TempDir("", "era1-test")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	var (
+		start                 = uint64(128)
+		blocks, receipts, tds = makeTestChain(start, 128)
+		filename              = filepath.Join(dir, "test.era1")
+		f, _                  = os.Create(filename)
+		builder               = NewBuilder(f)
+	)
+	for i, block := range blocks {
+		if err := builder.Add(block, receipts[i], tds[i]); err != nil {
+			t.Fatalf("error adding entry: %v", err)
+		}
+	}
+	root, err := builder.Finalize()
+	if err != nil {
+		t.Fatalf("error finalizing era1: %v", err)
+	}
+	f.Close()
+
+	// Verify era1 contents.
+	e, err := Open(filename)
+	if err != nil {
+		t.Fatalf("failed to open era: %v", err)
+	}
+	defer e.Close()
+
+	if e.Start() != start || e.Count() != uint64(len(blocks)) {
+		t.Fatalf("metadata mismatch: have start %d count %d, want start %d count %d", e.Start(), e.Count(), start, len(blocks))
+	}
+	if have, err := e.Accumulator(); err != nil || have != root {
+		t.Fatalf("accumulator mismatch: have %x, want %x (err %v)", have, root, err)
+	}
+	if td, err := e.InitialTD(); err != nil || td.Cmp(big.NewInt(int64(start))) != 0 {
+		t.Fatalf("initial total difficulty mismatch: have %v, want %d (err %v)", td, start, err)
+	}
+	it, err := NewIterator(e)
+	if err != nil {
+		t.Fatalf("failed to make iterator: %s", err)
+	}
+	for i := 0; it.Next(); i++ {
+		if err := it.Error(); err != nil {
+			t.Fatalf("iterator error at %d: %v", i, err)
+		}
+		block, receipt, err := it.BlockAndReceipts()
+		if err != nil {
+			t.Fatalf("error reading block %d: %v", i, err)
+		}
+		if block.Hash() != blocks[i].Hash() {
+			t.Fatalf("block %d hash mismatch: have %x, want %x", i, block.Hash(), blocks[i].Hash())
+		}
+		if types.DeriveSha(receipt, trie.NewStackTrie(nil)) != block.ReceiptHash() {
+			t.Fatalf("block %d receipt root mismatch", i)
+		}
+		td, err := it.TotalDifficulty()
+		if err != nil {
+			t.Fatalf("error reading total difficulty %d: %v", i, err)
+		}
+		if td.Cmp(tds[i]) != 0 {
+			t.Fatalf("total difficulty %d mismatch: have %v, want %v", i, td, tds[i])
+		}
+		// Random access should agree with iteration
+		byNumber, err := e.GetBlockByNumber(start + uint64(i))
+		if err != nil || byNumber.Hash() != block.Hash() {
+			t.Fatalf("random access block %d mismatch (err %v)", i, err)
+		}
+		rs, err := e.GetReceiptsByNumber(start + uint64(i))
+		if err != nil || !bytes.Equal(rs[0].Bloom[:], receipt[0].Bloom[:]) {
+			t.Fatalf("random access receipts %d mismatch (err %v)", i, err)
+		}
+	}
+	if _, err := e.GetBlockByNumber(start + uint64(len(blocks))); err == nil {
+		t.Fatalf("expected out-of-bounds error")
+	}
+}
+
+func TestEra1BuilderNonContiguous(t *testing.T) {
+	blocks, receipts, tds := makeTestChain(0, 3)
+
+	builder := NewBuilder(new(bytes.Buffer))
+	if err := builder.Add(blocks[0], receipts[0], tds[0]); err != nil {
+		t.Fatalf("error adding entry: %v", err)
+	}
+	if err := builder.Add(blocks[2], receipts[2], tds[2]); err == nil {
+		t.Fatalf("expected error on gapped block")
+	}
+}
+
+func TestAccumulator(t *testing.T) {
+	blocks, _, tds := makeTestChain(0, 4)
+	hashes := make([]common.Hash, len(blocks))
+	for i, block := range blocks {
+		hashes[i] = block.Hash()
+	}
+	root, err := ComputeAccumulator(hashes, tds)
+	if err != nil {
+		t.Fatalf("error computing accumulator: %v", err)
+	}
+	// Any change to a block hash, difficulty or the number of records must
+	// result in a different root
+	if other, _ := ComputeAccumulator(hashes[:3], tds[:3]); other == root {
+		t.Fatalf("accumulator unchanged after dropping a record")
+	}
+	tds[1] = new(big.Int).Add(tds[1], common.Big1)
+	if other, _ := ComputeAccumulator(hashes, tds); other == root {
+		t.Fatalf("accumulator unchanged after modifying total difficulty")
+	}
+	if _, err := ComputeAccumulator(hashes, tds[:1]); err == nil {
+		t.Fatalf("expected error on mismatched inputs")
+	}
+}
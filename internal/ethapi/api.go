@@ -45,6 +45,7 @@ import (
 	"github.com/ethereum/go-ethereum/params"
 	"github.com/ethereum/go-ethereum/rlp"
 	"github.com/ethereum/go-ethereum/rpc"
+	"github.com/ethereum/go-ethereum/trie"
 	"github.com/tyler-smith/go-bip39"
 )
 
@@ -160,7 +161,7 @@ func (s *PublicTxPoolAPI) Content() map[string]map[string]map[string]*RPCTransac
 	for account, txs := range pending {
 		dump := make(map[string]*RPCTransaction)
 		for _, tx := range txs {
-			dump[fmt.Sprintf("%d", tx.Nonce())] = newRPCPendingTransaction(tx, curHeader, s.b.ChainConfig())
+			dump[fmt.Sprintf("%d", tx.Nonce())] = NewRPCPendingTransaction(tx, curHeader, s.b.ChainConfig())
 		}
 		content["pending"][account.Hex()] = dump
 	}
@@ -168,7 +169,7 @@ func (s *PublicTxPoolAPI) Content() map[string]map[string]map[string]*RPCTransac
 	for account, txs := range queue {
 		dump := make(map[string]*RPCTransaction)
 		for _, tx := range txs {
-			dump[fmt.Sprintf("%d", tx.Nonce())] = newRPCPendingTransaction(tx, curHeader, s.b.ChainConfig())
+			dump[fmt.Sprintf("%d", tx.Nonce())] = NewRPCPendingTransaction(tx, curHeader, s.b.ChainConfig())
 		}
 		content["queued"][account.Hex()] = dump
 	}
@@ -184,14 +185,14 @@ func (s *PublicTxPoolAPI) ContentFrom(addr common.Address) map[string]map[string
 	// Build the pending transactions
 	dump := make(map[string]*RPCTransaction, len(pending))
 	for _, tx := range pending {
-		dump[fmt.Sprintf("%d", tx.Nonce())] = newRPCPendingTransaction(tx, curHeader, s.b.ChainConfig())
+		dump[fmt.Sprintf("%d", tx.Nonce())] = NewRPCPendingTransaction(tx, curHeader, s.b.ChainConfig())
 	}
 	content["pending"] = dump
 
 	// Build the queued transactions
 	dump = make(map[string]*RPCTransaction, len(queue))
 	for _, tx := range queue {
-		dump[fmt.Sprintf("%d", tx.Nonce())] = newRPCPendingTransaction(tx, curHeader, s.b.ChainConfig())
+		dump[fmt.Sprintf("%d", tx.Nonce())] = NewRPCPendingTransaction(tx, curHeader, s.b.ChainConfig())
 	}
 	content["queued"] = dump
 
@@ -242,6 +243,42 @@ func (s *PublicTxPoolAPI) Inspect() map[string]map[string]map[string]string {
 	return content
 }
 
+// RPCTxPoolEvent represents a transaction lifecycle event of the pool that will
+// serialize to the RPC representation.
+type RPCTxPoolEvent struct {
+	Hash       common.Hash    `json:"hash"`
+	Kind       string         `json:"kind"`
+	Reason     string         `json:"reason,omitempty"`
+	ReplacedBy *common.Hash   `json:"replacedBy,omitempty"`
+	Time       hexutil.Uint64 `json:"time"`
+}
+
+// newRPCTxPoolEvent converts a transaction pool event into its RPC representation.
+func newRPCTxPoolEvent(ev core.TxPoolEvent) *RPCTxPoolEvent {
+	result := &RPCTxPoolEvent{
+		Hash:   ev.Hash,
+		Kind:   string(ev.Kind),
+		Reason: ev.Reason,
+		Time:   hexutil.Uint64(ev.Time.Unix()),
+	}
+	if ev.ReplacedBy != (common.Hash{}) {
+		replacement := ev.ReplacedBy
+		result.ReplacedBy = &replacement
+	}
+	return result
+}
+
+// History returns the recent lifecycle events of a transaction retained by the
+// pool, telling when it was added, replaced or why it was dropped.
+func (s *PublicTxPoolAPI) History(hash common.Hash) []*RPCTxPoolEvent {
+	events := s.b.TxPoolHistory(hash)
+	result := make([]*RPCTxPoolEvent, 0, len(events))
+	for _, ev := range events {
+		result = append(result, newRPCTxPoolEvent(ev))
+	}
+	return result
+}
+
 // PublicAccountAPI provides an API to access accounts managed by this node.
 // It offers only methods that can retrieve accounts.
 type PublicAccountAPI struct {
@@ -641,6 +678,10 @@ type AccountResult struct {
 	Nonce        hexutil.Uint64  `json:"nonce"`
 	StorageHash  common.Hash     `json:"storageHash"`
 	StorageProof []StorageResult `json:"storageProof"`
+
+	// StorageMultiProof is the deduplicated multiproof of all the storage keys,
+	// replacing their individual proofs in the results of eth_getCompactProof(s).
+	StorageMultiProof []string `json:"storageMultiProof,omitempty"`
 }
 
 type StorageResult struct {
@@ -649,13 +690,133 @@ type StorageResult struct {
 	Proof []string     `json:"proof"`
 }
 
+// ProofArgs represents the account and storage keys to prove in a batched proof
+// request.
+type ProofArgs struct {
+	Address     common.Address `json:"address"`
+	StorageKeys []string       `json:"storageKeys"`
+}
+
 // GetProof returns the Merkle-proof for a given account and optionally some storage keys.
 func (s *PublicBlockChainAPI) GetProof(ctx context.Context, address common.Address, storageKeys []string, blockNrOrHash rpc.BlockNumberOrHash) (*AccountResult, error) {
+	results, err := s.GetProofs(ctx, []ProofArgs{{Address: address, StorageKeys: storageKeys}}, blockNrOrHash)
+	if err != nil {
+		return nil, err
+	}
+	return results[0], nil
+}
+
+// GetCompactProof returns the Merkle-proof for a given account and optionally some
+// storage keys, with the storage proofs merged into a single multiproof.
+func (s *PublicBlockChainAPI) GetCompactProof(ctx context.Context, address common.Address, storageKeys []string, blockNrOrHash rpc.BlockNumberOrHash) (*AccountResult, error) {
+	results, err := s.GetCompactProofs(ctx, []ProofArgs{{Address: address, StorageKeys: storageKeys}}, blockNrOrHash)
+	if err != nil {
+		return nil, err
+	}
+	return results[0], nil
+}
+
+// GetProofs returns the Merkle-proofs for multiple accounts and optionally some
+// of their storage keys, all proven against the state of the same block.
+func (s *PublicBlockChainAPI) GetProofs(ctx context.Context, args []ProofArgs, blockNrOrHash rpc.BlockNumberOrHash) ([]*AccountResult, error) {
+	return s.getProofs(ctx, args, blockNrOrHash)
+}
+
+// GetCompactProofs returns the Merkle-proofs for multiple accounts and optionally
+// some of their storage keys, all proven against the state of the same block. The
+// storage proofs of each account are merged into a single multiproof.
+func (s *PublicBlockChainAPI) GetCompactProofs(ctx context.Context, args []ProofArgs, blockNrOrHash rpc.BlockNumberOrHash) ([]*AccountResult, error) {
+	results, err := s.getProofs(ctx, args, blockNrOrHash)
+	if err != nil {
+		return nil, err
+	}
+	for _, result := range results {
+		compactStorageProofs(result)
+	}
+	return results, nil
+}
+
+// getProofs creates the Merkle-proofs of the requested accounts and storage keys.
+func (s *PublicBlockChainAPI) getProofs(ctx context.Context, args []ProofArgs, blockNrOrHash rpc.BlockNumberOrHash) ([]*AccountResult, error) {
+	// Serve the proofs from the prover if available, as it doesn't need to load
+	// the full state and caches the proofs of the recent blocks. The pending
+	// state is only known by the miner though.
+	if prover := s.b.StateProver(); prover != nil {
+		if number, ok := blockNrOrHash.Number(); !ok || number != rpc.PendingBlockNumber {
+			header, err := s.b.HeaderByNumberOrHash(ctx, blockNrOrHash)
+			if err != nil {
+				return nil, err
+			}
+			if header == nil {
+				return nil, errors.New("header not found")
+			}
+			return proveAccounts(prover, header.Root, args)
+		}
+	}
 	state, _, err := s.b.StateAndHeaderByNumberOrHash(ctx, blockNrOrHash)
 	if state == nil || err != nil {
 		return nil, err
 	}
+	results := make([]*AccountResult, len(args))
+	for i, arg := range args {
+		if results[i], err = proveAccount(state, arg.Address, arg.StorageKeys); err != nil {
+			return nil, err
+		}
+	}
+	return results, nil
+}
+
+// proveAccounts creates the proofs of the given accounts and storage keys with
+// the state prover.
+func proveAccounts(prover *state.Prover, root common.Hash, args []ProofArgs) ([]*AccountResult, error) {
+	requests := make([]state.ProofRequest, len(args))
+	for i, arg := range args {
+		requests[i] = state.ProofRequest{Address: arg.Address, StorageKeys: make([]common.Hash, len(arg.StorageKeys))}
+		for j, key := range arg.StorageKeys {
+			requests[i].StorageKeys[j] = common.HexToHash(key)
+		}
+	}
+	proofs, err := prover.Prove(root, requests)
+	if err != nil {
+		return nil, err
+	}
+	results := make([]*AccountResult, len(proofs))
+	for i, proof := range proofs {
+		storageProof := make([]StorageResult, len(proof.Storage))
+		for j, slot := range proof.Storage {
+			storageProof[j] = StorageResult{args[i].StorageKeys[j], (*hexutil.Big)(slot.Value.Big()), toHexSlice(slot.Proof)}
+		}
+		results[i] = &AccountResult{
+			Address:      proof.Address,
+			AccountProof: toHexSlice(proof.Proof),
+			Balance:      (*hexutil.Big)(proof.Balance),
+			CodeHash:     proof.CodeHash,
+			Nonce:        hexutil.Uint64(proof.Nonce),
+			StorageHash:  proof.Root,
+			StorageProof: storageProof,
+		}
+	}
+	return results, nil
+}
 
+// compactStorageProofs merges the individual storage proofs of an account into a
+// multiproof, which can be verified with trie.VerifyMultiProof against the
+// storage root and the hashes of the storage keys.
+func compactStorageProofs(result *AccountResult) {
+	proofs := make([][][]byte, len(result.StorageProof))
+	for i, slot := range result.StorageProof {
+		proofs[i] = make([][]byte, len(slot.Proof))
+		for j, node := range slot.Proof {
+			proofs[i][j] = hexutil.MustDecode(node)
+		}
+		result.StorageProof[i].Proof = []string{}
+	}
+	result.StorageMultiProof = toHexSlice(trie.MergeProofs(proofs...))
+}
+
+// proveAccount creates the proof of an account and the given storage keys from
+// the full state.
+func proveAccount(state *state.StateDB, address common.Address, storageKeys []string) (*AccountResult, error) {
 	storageTrie := state.StorageTrie(address)
 	storageHash := types.EmptyRootHash
 	codeHash := state.GetCodeHash(address)
@@ -1331,8 +1492,8 @@ func newRPCTransaction(tx *types.Transaction, blockHash common.Hash, blockNumber
 	return result
 }
 
-// newRPCPendingTransaction returns a pending transaction that will serialize to the RPC representation
-func newRPCPendingTransaction(tx *types.Transaction, current *types.Header, config *params.ChainConfig) *RPCTransaction {
+// NewRPCPendingTransaction returns a pending transaction that will serialize to the RPC representation
+func NewRPCPendingTransaction(tx *types.Transaction, current *types.Header, config *params.ChainConfig) *RPCTransaction {
 	var baseFee *big.Int
 	if current != nil {
 		baseFee = misc.CalcBaseFee(config, current)
@@ -1568,7 +1729,7 @@ func (s *PublicTransactionPoolAPI) GetTransactionByHash(ctx context.Context, has
 	}
 	// No finalized transaction, try to retrieve it from the pool
 	if tx := s.b.GetPoolTransaction(hash); tx != nil {
-		return newRPCPendingTransaction(tx, s.b.CurrentHeader(), s.b.ChainConfig()), nil
+		return NewRPCPendingTransaction(tx, s.b.CurrentHeader(), s.b.ChainConfig()), nil
 	}
 
 	// Transaction unknown, return as such
@@ -1668,15 +1829,9 @@ func (s *PublicTransactionPoolAPI) sign(addr common.Address, tx *types.Transacti
 
 // SubmitTransaction is a helper function that submits tx to txPool and logs a message.
 func SubmitTransaction(ctx context.Context, b Backend, tx *types.Transaction) (common.Hash, error) {
-	// If the transaction fee cap is already specified, ensure the
-	// fee of the given transaction is _reasonable_.
-	if err := checkTxFee(tx.GasPrice(), tx.Gas(), b.RPCTxFeeCap()); err != nil {
+	if err := checkSubmission(b, tx); err != nil {
 		return common.Hash{}, err
 	}
-	if !b.UnprotectedAllowed() && !tx.Protected() {
-		// Ensure only eip155 signed transactions are submitted if EIP155Required is set.
-		return common.Hash{}, errors.New("only replay-protected (EIP-155) transactions allowed over RPC")
-	}
 	if err := b.SendTx(ctx, tx); err != nil {
 		return common.Hash{}, err
 	}
@@ -1696,6 +1851,20 @@ func SubmitTransaction(ctx context.Context, b Backend, tx *types.Transaction) (c
 	return tx.Hash(), nil
 }
 
+// checkSubmission ensures a transaction is acceptable for submission over RPC.
+func checkSubmission(b Backend, tx *types.Transaction) error {
+	// If the transaction fee cap is already specified, ensure the
+	// fee of the given transaction is _reasonable_.
+	if err := checkTxFee(tx.GasPrice(), tx.Gas(), b.RPCTxFeeCap()); err != nil {
+		return err
+	}
+	if !b.UnprotectedAllowed() && !tx.Protected() {
+		// Ensure only eip155 signed transactions are submitted if EIP155Required is set.
+		return errors.New("only replay-protected (EIP-155) transactions allowed over RPC")
+	}
+	return nil
+}
+
 // SendTransaction creates a transaction for the given argument, sign it and submit it to the
 // transaction pool.
 func (s *PublicTransactionPoolAPI) SendTransaction(ctx context.Context, args TransactionArgs) (common.Hash, error) {
@@ -1755,6 +1924,86 @@ func (s *PublicTransactionPoolAPI) SendRawTransaction(ctx context.Context, input
 	return SubmitTransaction(ctx, s.b, tx)
 }
 
+// defaultPrivateTxBlocks is the number of blocks a private transaction may be
+// included in if no expiry is requested.
+const defaultPrivateTxBlocks = 25
+
+// PrivateTxArgs represents the arguments to submit a private transaction.
+type PrivateTxArgs struct {
+	Tx             hexutil.Bytes   `json:"tx"`
+	MaxBlockNumber *hexutil.Uint64 `json:"maxBlockNumber"`
+}
+
+// SendPrivateTransaction will add the signed transaction to the transaction pool
+// without announcing it to the network nor exposing it over RPC, so it may only
+// be included by the blocks built locally. The transaction is dropped if it's not
+// included until the max block number, which defaults to 25 blocks from the
+// current head.
+func (s *PublicTransactionPoolAPI) SendPrivateTransaction(ctx context.Context, args PrivateTxArgs) (common.Hash, error) {
+	tx := new(types.Transaction)
+	if err := tx.UnmarshalBinary(args.Tx); err != nil {
+		return common.Hash{}, err
+	}
+	if err := checkSubmission(s.b, tx); err != nil {
+		return common.Hash{}, err
+	}
+	maxBlock := s.b.CurrentBlock().NumberU64() + defaultPrivateTxBlocks
+	if args.MaxBlockNumber != nil {
+		maxBlock = uint64(*args.MaxBlockNumber)
+	}
+	if err := s.b.SendPrivateTx(ctx, tx, maxBlock); err != nil {
+		return common.Hash{}, err
+	}
+	log.Info("Submitted private transaction", "hash", tx.Hash().Hex(), "nonce", tx.Nonce(), "expiry", maxBlock)
+	return tx.Hash(), nil
+}
+
+// SendBundleArgs represents the arguments to submit a bundle of transactions.
+type SendBundleArgs struct {
+	Txs          []hexutil.Bytes `json:"txs"`
+	BlockNumber  hexutil.Uint64  `json:"blockNumber"`
+	MinTimestamp *uint64         `json:"minTimestamp"`
+	MaxTimestamp *uint64         `json:"maxTimestamp"`
+}
+
+// SendBundle submits a bundle of signed transactions to be included atomically,
+// in order, at the top of the given block, provided it's mined within the given
+// timestamp bounds. Bundles are only included if none of their transactions
+// revert, and the most profitable ones are preferred. The hash of the bundle is
+// returned.
+func (s *PublicTransactionPoolAPI) SendBundle(ctx context.Context, args SendBundleArgs) (common.Hash, error) {
+	if len(args.Txs) == 0 {
+		return common.Hash{}, errors.New("bundle missing transactions")
+	}
+	var (
+		txs          = make(types.Transactions, 0, len(args.Txs))
+		minTimestamp uint64
+		maxTimestamp uint64
+	)
+	for i, input := range args.Txs {
+		tx := new(types.Transaction)
+		if err := tx.UnmarshalBinary(input); err != nil {
+			return common.Hash{}, fmt.Errorf("invalid transaction %d: %v", i, err)
+		}
+		if err := checkSubmission(s.b, tx); err != nil {
+			return common.Hash{}, fmt.Errorf("invalid transaction %d: %v", i, err)
+		}
+		txs = append(txs, tx)
+	}
+	if args.MinTimestamp != nil {
+		minTimestamp = *args.MinTimestamp
+	}
+	if args.MaxTimestamp != nil {
+		maxTimestamp = *args.MaxTimestamp
+	}
+	hash, err := s.b.SendBundle(ctx, txs, uint64(args.BlockNumber), minTimestamp, maxTimestamp)
+	if err != nil {
+		return common.Hash{}, err
+	}
+	log.Info("Submitted bundle", "hash", hash, "txs", len(txs), "block", uint64(args.BlockNumber))
+	return hash, nil
+}
+
 // Sign calculates an ECDSA signature for:
 // keccack256("\x19Ethereum Signed Message:\n" + len(message) + message).
 //
@@ -1836,12 +2085,42 @@ func (s *PublicTransactionPoolAPI) PendingTransactions() ([]*RPCTransaction, err
 	for _, tx := range pending {
 		from, _ := types.Sender(s.signer, tx)
 		if _, exists := accounts[from]; exists {
-			transactions = append(transactions, newRPCPendingTransaction(tx, curHeader, s.b.ChainConfig()))
+			transactions = append(transactions, NewRPCPendingTransaction(tx, curHeader, s.b.ChainConfig()))
 		}
 	}
 	return transactions, nil
 }
 
+// TxpoolEvents creates a subscription that is triggered each time a transaction
+// enters, gets replaced in or is dropped from the transaction pool.
+func (s *PublicTransactionPoolAPI) TxpoolEvents(ctx context.Context) (*rpc.Subscription, error) {
+	notifier, supported := rpc.NotifierFromContext(ctx)
+	if !supported {
+		return &rpc.Subscription{}, rpc.ErrNotificationsUnsupported
+	}
+	rpcSub := notifier.CreateSubscription()
+
+	go func() {
+		events := make(chan core.TxPoolEvent, 128)
+		eventsSub := s.b.SubscribeTxPoolEvent(events)
+		defer eventsSub.Unsubscribe()
+
+		for {
+			select {
+			case ev := <-events:
+				notifier.Notify(rpcSub.ID, newRPCTxPoolEvent(ev))
+			case <-eventsSub.Err():
+				return
+			case <-rpcSub.Err():
+				return
+			case <-notifier.Closed():
+				return
+			}
+		}
+	}()
+	return rpcSub, nil
+}
+
 // Resend accepts an existing transaction and a new gas price and limit. It will remove
 // the given transaction from the pool and reinsert it with the new gas price and limit.
 func (s *PublicTransactionPoolAPI) Resend(ctx context.Context, sendArgs TransactionArgs, gasPrice *hexutil.Big, gasLimit *hexutil.Uint64) (common.Hash, error) {
@@ -161,6 +161,10 @@ func (b *LesApiBackend) StateAndHeaderByNumberOrHash(ctx context.Context, blockN
 	return nil, nil, errors.New("invalid arguments; neither block nor hash specified")
 }
 
+func (b *LesApiBackend) StateProver() *state.Prover {
+	return nil
+}
+
 func (b *LesApiBackend) GetReceipts(ctx context.Context, hash common.Hash) (types.Receipts, error) {
 	if number := rawdb.ReadHeaderNumber(b.eth.chainDb, hash); number != nil {
 		return light.GetBlockReceipts(ctx, b.eth.odr, hash, *number)
@@ -195,6 +199,17 @@ func (b *LesApiBackend) SendTx(ctx context.Context, signedTx *types.Transaction)
 	return b.eth.txPool.Add(ctx, signedTx)
 }
 
+// SendPrivateTx is not supported by the light client, it has no blocks to include
+// the transaction into without relaying it to the network.
+func (b *LesApiBackend) SendPrivateTx(ctx context.Context, signedTx *types.Transaction, maxBlock uint64) error {
+	return errors.New("private transactions not supported by light client")
+}
+
+// SendBundle is not supported by the light client, it does not build blocks.
+func (b *LesApiBackend) SendBundle(ctx context.Context, txs types.Transactions, blockNumber uint64, minTimestamp uint64, maxTimestamp uint64) (common.Hash, error) {
+	return common.Hash{}, errors.New("bundles not supported by light client")
+}
+
 func (b *LesApiBackend) RemoveTx(txHash common.Hash) {
 	b.eth.txPool.RemoveTx(txHash)
 }
@@ -231,6 +246,19 @@ func (b *LesApiBackend) SubscribeNewTxsEvent(ch chan<- core.NewTxsEvent) event.S
 	return b.eth.txPool.SubscribeNewTxsEvent(ch)
 }
 
+// SubscribeTxPoolEvent is not supported by the light client, its pool does not
+// track the lifecycle of the transactions.
+func (b *LesApiBackend) SubscribeTxPoolEvent(ch chan<- core.TxPoolEvent) event.Subscription {
+	return event.NewSubscription(func(quit <-chan struct{}) error {
+		<-quit
+		return nil
+	})
+}
+
+func (b *LesApiBackend) TxPoolHistory(hash common.Hash) []core.TxPoolEvent {
+	return nil
+}
+
 func (b *LesApiBackend) SubscribeChainEvent(ch chan<- core.ChainEvent) event.Subscription {
 	return b.eth.blockchain.SubscribeChainEvent(ch)
 }
@@ -0,0 +1,369 @@
+// Copyright 2021 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package miner
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"sort"
+	"sync"
+	"sync/atomic"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/core/state"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/log"
+)
+
+const (
+	maxBundles       = 1024 // Maximum number of bundles waiting for inclusion
+	maxBundleTxs     = 16   // Maximum number of transactions in a single bundle
+	maxBundleBlocks  = 32   // Maximum number of blocks ahead of the head a bundle may target
+	maxSenderBundles = 16   // Maximum number of waiting bundles an account may send transactions in
+)
+
+var (
+	// errEmptyBundle is returned if a bundle without transactions is submitted.
+	errEmptyBundle = errors.New("empty bundle")
+
+	// errBundleTooLate is returned if a bundle targets a block already mined.
+	errBundleTooLate = errors.New("bundle targets past block")
+
+	// errBundleTooEarly is returned if a bundle targets a block too far ahead.
+	errBundleTooEarly = errors.New("bundle targets distant block")
+
+	// errBundleTooLarge is returned if a bundle contains too many transactions.
+	errBundleTooLarge = errors.New("bundle too large")
+
+	// errBundleKnown is returned if the same bundle is already waiting for the
+	// same block.
+	errBundleKnown = errors.New("bundle already known")
+
+	// errBundleSenderLimit is returned if a sender of the bundle's transactions
+	// has too many bundles waiting for inclusion.
+	errBundleSenderLimit = errors.New("too many bundles from sender")
+
+	// errBundlePoolFull is returned if too many bundles are waiting for inclusion.
+	errBundlePoolFull = errors.New("bundle pool full")
+
+	// errBundleReverted is returned if a transaction of a bundle fails or reverts.
+	errBundleReverted = errors.New("bundle reverted")
+)
+
+// Bundle is a list of transactions to be included atomically, in the given
+// order, at the top of a specific block.
+type Bundle struct {
+	Txs          types.Transactions // Transactions to include, all or none
+	BlockNumber  uint64             // Number of the block to include the bundle in
+	MinTimestamp uint64             // Minimum timestamp of the block (0 = unbounded)
+	MaxTimestamp uint64             // Maximum timestamp of the block (0 = unbounded)
+
+	senders []common.Address // Distinct senders of the transactions, set by the pool
+}
+
+// bundleKey identifies a bundle waiting for inclusion. The same transactions
+// may be bundled for multiple blocks, so the target is part of the key.
+type bundleKey struct {
+	hash   common.Hash
+	number uint64
+}
+
+// Hash returns the identifier of the bundle, the hash of its transaction hashes.
+func (b *Bundle) Hash() common.Hash {
+	hashes := make([]byte, 0, len(b.Txs)*common.HashLength)
+	for _, tx := range b.Txs {
+		hashes = append(hashes, tx.Hash().Bytes()...)
+	}
+	return crypto.Keccak256Hash(hashes)
+}
+
+// eligible returns whether the bundle may be included in the given block.
+func (b *Bundle) eligible(header *types.Header) bool {
+	if b.BlockNumber != header.Number.Uint64() {
+		return false
+	}
+	if b.MinTimestamp != 0 && header.Time < b.MinTimestamp {
+		return false
+	}
+	if b.MaxTimestamp != 0 && header.Time > b.MaxTimestamp {
+		return false
+	}
+	return true
+}
+
+// bundlePool holds the bundles waiting for inclusion.
+type bundlePool struct {
+	bundles []*Bundle              // Bundles waiting for inclusion, in arrival order
+	known   map[bundleKey]struct{} // Bundles waiting for inclusion, to reject duplicates
+	senders map[common.Address]int // Number of waiting bundles per transaction sender
+	lock    sync.Mutex
+}
+
+// newBundlePool creates an empty bundle pool.
+func newBundlePool() *bundlePool {
+	return &bundlePool{
+		known:   make(map[bundleKey]struct{}),
+		senders: make(map[common.Address]int),
+	}
+}
+
+// add inserts a bundle into the pool. Bundles are rejected if they target a block
+// at or below the head or too far ahead of it, if they are already known, or if
+// any of their transactions can't be executed on top of the head state due to a
+// stale nonce or a balance too low to pay for the transactions of the sender.
+func (p *bundlePool) add(bundle *Bundle, head uint64, statedb *state.StateDB, signer types.Signer) error {
+	if len(bundle.Txs) == 0 {
+		return errEmptyBundle
+	}
+	if len(bundle.Txs) > maxBundleTxs {
+		return fmt.Errorf("%w: %d txs, limit %d", errBundleTooLarge, len(bundle.Txs), maxBundleTxs)
+	}
+	if bundle.BlockNumber <= head {
+		return fmt.Errorf("%w: block %d, head %d", errBundleTooLate, bundle.BlockNumber, head)
+	}
+	if bundle.BlockNumber > head+maxBundleBlocks {
+		return fmt.Errorf("%w: block %d, head %d", errBundleTooEarly, bundle.BlockNumber, head)
+	}
+	// Ensure the transactions are executable against the head state
+	var (
+		nonces  = make(map[common.Address]uint64)
+		costs   = make(map[common.Address]*big.Int)
+		senders []common.Address
+	)
+	for i, tx := range bundle.Txs {
+		from, err := types.Sender(signer, tx)
+		if err != nil {
+			return fmt.Errorf("tx %d: %v", i, err)
+		}
+		if _, ok := nonces[from]; !ok {
+			nonces[from] = statedb.GetNonce(from)
+			costs[from] = new(big.Int)
+			senders = append(senders, from)
+		}
+		if tx.Nonce() < nonces[from] {
+			return fmt.Errorf("tx %d: %w: address %v, tx: %d state: %d", i, core.ErrNonceTooLow, from, tx.Nonce(), nonces[from])
+		}
+		nonces[from] = tx.Nonce() + 1
+
+		costs[from].Add(costs[from], tx.Cost())
+		if balance := statedb.GetBalance(from); balance.Cmp(costs[from]) < 0 {
+			return fmt.Errorf("tx %d: %w: address %v have %v want %v", i, core.ErrInsufficientFunds, from, balance, costs[from])
+		}
+	}
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	key := bundleKey{hash: bundle.Hash(), number: bundle.BlockNumber}
+	if _, ok := p.known[key]; ok {
+		return errBundleKnown
+	}
+	if len(p.bundles) >= maxBundles {
+		return errBundlePoolFull
+	}
+	for _, sender := range senders {
+		if p.senders[sender] >= maxSenderBundles {
+			return fmt.Errorf("%w: %v", errBundleSenderLimit, sender)
+		}
+	}
+	bundle.senders = senders
+	p.bundles = append(p.bundles, bundle)
+	p.known[key] = struct{}{}
+	for _, sender := range bundle.senders {
+		p.senders[sender]++
+	}
+	return nil
+}
+
+// untrack drops the bookkeeping of a bundle leaving the pool.
+//
+// Note, this method assumes the pool lock is held!
+func (p *bundlePool) untrack(bundle *Bundle) {
+	delete(p.known, bundleKey{hash: bundle.Hash(), number: bundle.BlockNumber})
+	for _, sender := range bundle.senders {
+		if p.senders[sender]--; p.senders[sender] <= 0 {
+			delete(p.senders, sender)
+		}
+	}
+}
+
+// eligible returns the bundles which may be included in the given block, and
+// drops the ones targeting earlier blocks.
+func (p *bundlePool) eligible(header *types.Header) []*Bundle {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	var (
+		number  = header.Number.Uint64()
+		kept    = p.bundles[:0]
+		matches []*Bundle
+	)
+	for _, bundle := range p.bundles {
+		if bundle.BlockNumber < number {
+			p.untrack(bundle)
+			continue
+		}
+		kept = append(kept, bundle)
+		if bundle.eligible(header) {
+			matches = append(matches, bundle)
+		}
+	}
+	for i := len(kept); i < len(p.bundles); i++ {
+		p.bundles[i] = nil
+	}
+	p.bundles = kept
+	return matches
+}
+
+// remove drops a bundle from the pool.
+func (p *bundlePool) remove(bundle *Bundle) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	for i, b := range p.bundles {
+		if b == bundle {
+			p.bundles = append(p.bundles[:i], p.bundles[i+1:]...)
+			p.untrack(bundle)
+			return
+		}
+	}
+}
+
+// simulatedBundle is a bundle along with the profit it yields to the miner
+// when executed on top of the pending state.
+type simulatedBundle struct {
+	bundle *Bundle
+	profit *big.Int
+}
+
+// simulateBundle executes a bundle on a copy of the given environment,
+// returning the increase of the coinbase balance it yields, or an error if
+// any of its transactions fails or reverts.
+func (w *worker) simulateBundle(env *environment, bundle *Bundle, coinbase common.Address) (*big.Int, error) {
+	var (
+		statedb = env.state.Copy()
+		gasPool = new(core.GasPool).AddGas(env.gasPool.Gas())
+		header  = types.CopyHeader(env.header)
+		before  = statedb.GetBalance(coinbase)
+	)
+	for i, tx := range bundle.Txs {
+		statedb.Prepare(tx.Hash(), env.tcount+i)
+
+		receipt, err := core.ApplyTransaction(w.chainConfig, w.chain, &coinbase, gasPool, statedb, header, tx, &header.GasUsed, *w.chain.GetVMConfig())
+		if err != nil {
+			return nil, fmt.Errorf("%w: tx %d: %v", errBundleReverted, i, err)
+		}
+		if receipt.Status == types.ReceiptStatusFailed {
+			return nil, fmt.Errorf("%w: tx %d reverted", errBundleReverted, i)
+		}
+	}
+	return new(big.Int).Sub(statedb.GetBalance(coinbase), before), nil
+}
+
+// commitBundle atomically applies all the transactions of a bundle on top of
+// the given environment, rolling all of them back if any fails or reverts. The
+// logs generated by the transactions are returned.
+func (w *worker) commitBundle(env *environment, bundle *Bundle, coinbase common.Address) ([]*types.Log, error) {
+	var (
+		snap     = env.state.Snapshot()
+		gas      = *env.gasPool
+		gasUsed  = env.header.GasUsed
+		tcount   = env.tcount
+		txs      = len(env.txs)
+		receipts = len(env.receipts)
+		logs     []*types.Log
+	)
+	for i, tx := range bundle.Txs {
+		env.state.Prepare(tx.Hash(), env.tcount)
+
+		txLogs, err := w.commitTransaction(env, tx, coinbase)
+		if err == nil && env.receipts[len(env.receipts)-1].Status == types.ReceiptStatusFailed {
+			err = errors.New("execution reverted")
+		}
+		if err != nil {
+			env.state.RevertToSnapshot(snap)
+			*env.gasPool = gas
+			env.header.GasUsed = gasUsed
+			env.tcount = tcount
+			env.txs = env.txs[:txs]
+			env.receipts = env.receipts[:receipts]
+			return nil, fmt.Errorf("%w: tx %d: %v", errBundleReverted, i, err)
+		}
+		logs = append(logs, txLogs...)
+		env.tcount++
+	}
+	return logs, nil
+}
+
+// commitBundles simulates the bundles targeting the environment's block and includes
+// the most profitable ones which don't conflict with each other at the top of
+// the block. Bundles failing the simulation are discarded. The number of bundles
+// included is returned, along with whether the work should be discarded as it
+// was interrupted by a new head.
+func (w *worker) commitBundles(env *environment, coinbase common.Address, interrupt *int32) (int, bool) {
+	bundles := w.bundles.eligible(env.header)
+	if len(bundles) == 0 {
+		return 0, false
+	}
+	if env.gasPool == nil {
+		env.gasPool = new(core.GasPool).AddGas(env.header.GasLimit)
+	}
+	interrupted := func() bool {
+		return interrupt != nil && atomic.LoadInt32(interrupt) != commitInterruptNone
+	}
+	// Simulate all the bundles in isolation, discarding the failing ones
+	simulated := make([]*simulatedBundle, 0, len(bundles))
+	for _, bundle := range bundles {
+		if interrupted() {
+			return 0, atomic.LoadInt32(interrupt) == commitInterruptNewHead
+		}
+		profit, err := w.simulateBundle(env, bundle, coinbase)
+		if err != nil {
+			log.Debug("Discarding failed bundle", "hash", bundle.Hash(), "err", err)
+			w.bundles.remove(bundle)
+			continue
+		}
+		simulated = append(simulated, &simulatedBundle{bundle: bundle, profit: profit})
+	}
+	sort.SliceStable(simulated, func(i, j int) bool {
+		return simulated[i].profit.Cmp(simulated[j].profit) > 0
+	})
+	// Include the bundles most profitable first, skipping the ones conflicting
+	// with the bundles already included
+	var (
+		included int
+		logs     []*types.Log
+	)
+	defer func() { w.sendPendingLogs(env, logs) }()
+
+	for _, sim := range simulated {
+		if interrupted() {
+			return included, atomic.LoadInt32(interrupt) == commitInterruptNewHead
+		}
+		bundleLogs, err := w.commitBundle(env, sim.bundle, coinbase)
+		if err != nil {
+			log.Trace("Skipping conflicting bundle", "hash", sim.bundle.Hash(), "err", err)
+			continue
+		}
+		log.Debug("Included bundle", "hash", sim.bundle.Hash(), "txs", len(sim.bundle.Txs), "profit", sim.profit)
+		logs = append(logs, bundleLogs...)
+		included++
+	}
+	return included, false
+}
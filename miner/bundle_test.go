@@ -0,0 +1,242 @@
+// Copyright 2021 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package miner
+
+import (
+	"errors"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/consensus/ethash"
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/core/state"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/params"
+)
+
+// newBundleState creates a state with the test bank account funded.
+func newBundleState() *state.StateDB {
+	statedb, _ := state.New(common.Hash{}, state.NewDatabase(rawdb.NewMemoryDatabase()), nil)
+	statedb.SetBalance(testBankAddress, testBankFunds)
+	return statedb
+}
+
+// Tests that the bundle pool accepts bundles for future blocks only, filters
+// them by block number and timestamp, and prunes the stale ones.
+func TestBundlePool(t *testing.T) {
+	var (
+		pool    = newBundlePool()
+		statedb = newBundleState()
+		signer  = types.HomesteadSigner{}
+		txs     = func(nonce uint64) types.Transactions {
+			return types.Transactions{orderedTx(testBankKey, nonce, 1, 0)}
+		}
+	)
+	if err := pool.add(&Bundle{BlockNumber: 2}, 1, statedb, signer); !errors.Is(err, errEmptyBundle) {
+		t.Fatalf("empty bundle error mismatch: have %v, want %v", err, errEmptyBundle)
+	}
+	if err := pool.add(&Bundle{Txs: txs(0), BlockNumber: 1}, 1, statedb, signer); !errors.Is(err, errBundleTooLate) {
+		t.Fatalf("late bundle error mismatch: have %v, want %v", err, errBundleTooLate)
+	}
+	var (
+		open   = &Bundle{Txs: txs(0), BlockNumber: 2}
+		early  = &Bundle{Txs: txs(1), BlockNumber: 2, MinTimestamp: 20}
+		late   = &Bundle{Txs: txs(2), BlockNumber: 2, MaxTimestamp: 5}
+		future = &Bundle{Txs: txs(0), BlockNumber: 3}
+	)
+	for _, bundle := range []*Bundle{open, early, late, future} {
+		if err := pool.add(bundle, 1, statedb, signer); err != nil {
+			t.Fatalf("failed to add bundle: %v", err)
+		}
+	}
+	if have := pool.eligible(&types.Header{Number: big.NewInt(2), Time: 10}); len(have) != 1 || have[0] != open {
+		t.Fatalf("eligible bundles mismatch: have %v, want %v", have, []*Bundle{open})
+	}
+	if have := pool.eligible(&types.Header{Number: big.NewInt(2), Time: 20}); len(have) != 2 || have[0] != open || have[1] != early {
+		t.Fatalf("eligible bundles mismatch: have %v, want %v", have, []*Bundle{open, early})
+	}
+	pool.remove(open)
+	if have := pool.eligible(&types.Header{Number: big.NewInt(3), Time: 30}); len(have) != 1 || have[0] != future {
+		t.Fatalf("eligible bundles mismatch: have %v, want %v", have, []*Bundle{future})
+	}
+	if len(pool.bundles) != 1 || len(pool.known) != 1 || pool.senders[testBankAddress] != 1 {
+		t.Fatalf("stale bundles retained: have %d bundles, %d known, %d by sender, want 1", len(pool.bundles), len(pool.known), pool.senders[testBankAddress])
+	}
+}
+
+// Tests that the bundle pool rejects bundles which are duplicated, oversized,
+// target distant blocks, aren't executable or exceed the per sender limit.
+func TestBundlePoolLimits(t *testing.T) {
+	var (
+		pool    = newBundlePool()
+		statedb = newBundleState()
+		signer  = types.HomesteadSigner{}
+	)
+	statedb.SetNonce(testBankAddress, 1)
+
+	if err := pool.add(&Bundle{Txs: types.Transactions{orderedTx(testBankKey, 1, 1, 0)}, BlockNumber: 2 + maxBundleBlocks}, 1, statedb, signer); !errors.Is(err, errBundleTooEarly) {
+		t.Fatalf("distant bundle error mismatch: have %v, want %v", err, errBundleTooEarly)
+	}
+	large := new(Bundle)
+	for i := 0; i <= maxBundleTxs; i++ {
+		large.Txs = append(large.Txs, orderedTx(testBankKey, uint64(1+i), 1, 0))
+	}
+	if err := pool.add(large, 1, statedb, signer); !errors.Is(err, errBundleTooLarge) {
+		t.Fatalf("large bundle error mismatch: have %v, want %v", err, errBundleTooLarge)
+	}
+	if err := pool.add(&Bundle{Txs: types.Transactions{orderedTx(testBankKey, 0, 1, 0)}, BlockNumber: 2}, 1, statedb, signer); !errors.Is(err, core.ErrNonceTooLow) {
+		t.Fatalf("stale nonce error mismatch: have %v, want %v", err, core.ErrNonceTooLow)
+	}
+	poor, _ := crypto.GenerateKey()
+	if err := pool.add(&Bundle{Txs: types.Transactions{orderedTx(poor, 0, 1, 0)}, BlockNumber: 2}, 1, statedb, signer); !errors.Is(err, core.ErrInsufficientFunds) {
+		t.Fatalf("unfunded bundle error mismatch: have %v, want %v", err, core.ErrInsufficientFunds)
+	}
+	bundle := &Bundle{Txs: types.Transactions{orderedTx(testBankKey, 1, 1, 0)}, BlockNumber: 2}
+	if err := pool.add(bundle, 1, statedb, signer); err != nil {
+		t.Fatalf("failed to add bundle: %v", err)
+	}
+	if err := pool.add(&Bundle{Txs: bundle.Txs, BlockNumber: 2}, 1, statedb, signer); !errors.Is(err, errBundleKnown) {
+		t.Fatalf("duplicate bundle error mismatch: have %v, want %v", err, errBundleKnown)
+	}
+	for i := 1; i < maxSenderBundles; i++ {
+		if err := pool.add(&Bundle{Txs: types.Transactions{orderedTx(testBankKey, uint64(1+i), 1, 0)}, BlockNumber: 2}, 1, statedb, signer); err != nil {
+			t.Fatalf("failed to add bundle %d: %v", i, err)
+		}
+	}
+	if err := pool.add(&Bundle{Txs: types.Transactions{orderedTx(testBankKey, 1, 2, 0)}, BlockNumber: 2}, 1, statedb, signer); !errors.Is(err, errBundleSenderLimit) {
+		t.Fatalf("sender limit error mismatch: have %v, want %v", err, errBundleSenderLimit)
+	}
+	// Once the bundles leave the pool, the sender may submit again
+	pool.eligible(&types.Header{Number: big.NewInt(3)})
+	if err := pool.add(&Bundle{Txs: types.Transactions{orderedTx(testBankKey, 1, 2, 0)}, BlockNumber: 3}, 2, statedb, signer); err != nil {
+		t.Fatalf("failed to add bundle after expiry: %v", err)
+	}
+}
+
+// Tests that the worker includes the valid bundles at the top of the block and
+// discards the failing ones.
+func TestBundleInclusion(t *testing.T) {
+	engine := ethash.NewFaker()
+	defer engine.Close()
+
+	w, _ := newTestWorker(t, ethashChainConfig, engine, rawdb.NewMemoryDatabase(), 0)
+	defer w.close()
+
+	signer := types.LatestSigner(ethashChainConfig)
+	var (
+		valid = &Bundle{BlockNumber: 1, Txs: types.Transactions{
+			types.MustSignNewTx(testBankKey, signer, &types.LegacyTx{
+				Nonce:    0,
+				To:       &testUserAddress,
+				Value:    big.NewInt(5000),
+				Gas:      params.TxGas,
+				GasPrice: big.NewInt(params.InitialBaseFee),
+			}),
+		}}
+		failing = &Bundle{BlockNumber: 1, Txs: types.Transactions{
+			types.MustSignNewTx(testBankKey, signer, &types.LegacyTx{
+				Nonce:    5,
+				To:       &testUserAddress,
+				Value:    big.NewInt(1000),
+				Gas:      params.TxGas,
+				GasPrice: big.NewInt(params.InitialBaseFee),
+			}),
+		}}
+	)
+	for _, bundle := range []*Bundle{valid, failing} {
+		if err := w.bundles.add(bundle, 0, newBundleState(), signer); err != nil {
+			t.Fatalf("failed to add bundle: %v", err)
+		}
+	}
+	taskCh := make(chan *task, 1)
+	w.newTaskHook = func(task *task) {
+		if task.block.NumberU64() == 1 && len(task.receipts) > 0 {
+			select {
+			case taskCh <- task:
+			default:
+			}
+		}
+	}
+	w.skipSealHook = func(task *task) bool { return true }
+	w.start()
+
+	select {
+	case task := <-taskCh:
+		// The bundle displaces the pending transaction with the same nonce
+		if txs := task.block.Transactions(); len(txs) != 1 || txs[0].Hash() != valid.Txs[0].Hash() {
+			t.Fatalf("block transactions mismatch: have %d, want bundle only", len(txs))
+		}
+		if balance := task.state.GetBalance(testUserAddress); balance.Cmp(big.NewInt(5000)) != 0 {
+			t.Fatalf("account balance mismatch: have %d, want %d", balance, 5000)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatalf("new task timeout")
+	}
+	w.bundles.lock.Lock()
+	defer w.bundles.lock.Unlock()
+	for _, bundle := range w.bundles.bundles {
+		if bundle == failing {
+			t.Fatalf("failing bundle retained")
+		}
+	}
+}
+
+// Tests that the value of a built payload is the tips its transactions pay, even
+// if the fee recipient itself sends some of them and its balance drops.
+func TestBundlePayloadValue(t *testing.T) {
+	var (
+		db        = rawdb.NewMemoryDatabase()
+		recipient = common.HexToAddress("0xdeadbeef")
+	)
+	w, b := newTestWorker(t, params.TestChainConfig, ethash.NewFaker(), db, 0)
+	defer w.close()
+
+	// The bundle is sent by the fee recipient, transferring value out
+	signer := types.LatestSigner(params.TestChainConfig)
+	bundle := &Bundle{BlockNumber: 1, Txs: types.Transactions{
+		types.MustSignNewTx(testBankKey, signer, &types.LegacyTx{
+			Nonce:    0,
+			To:       &recipient,
+			Value:    big.NewInt(5000),
+			Gas:      params.TxGas,
+			GasPrice: big.NewInt(2 * params.InitialBaseFee),
+		}),
+	}}
+	if err := w.bundles.add(bundle, 0, newBundleState(), signer); err != nil {
+		t.Fatalf("failed to add bundle: %v", err)
+	}
+	block, fees, err := w.getSealingBlock(b.chain.CurrentBlock().Hash(), uint64(time.Now().Unix()), testBankAddress, common.Hash{}, false)
+	if err != nil {
+		t.Fatalf("failed to build payload: %v", err)
+	}
+	// The bundle displaces the pending transaction with the same nonce
+	if txs := block.Transactions(); len(txs) != 1 || txs[0].Hash() != bundle.Txs[0].Hash() {
+		t.Fatalf("block transactions mismatch: have %d, want bundle only", len(txs))
+	}
+	tip, _ := bundle.Txs[0].EffectiveGasTip(block.BaseFee())
+	want := new(big.Int).Mul(tip, new(big.Int).SetUint64(block.GasUsed()))
+	if want.Sign() <= 0 {
+		t.Fatalf("bundle pays no tip")
+	}
+	if fees.Cmp(want) != 0 {
+		t.Fatalf("payload value mismatch: have %v, want %v", fees, want)
+	}
+}
@@ -52,6 +52,9 @@ type Config struct {
 	GasPrice   *big.Int       // Minimum gas price for mining a transaction
 	Recommit   time.Duration  // The time interval for miner to re-create mining work.
 	Noverify   bool           // Disable remote mining solution verification(only useful in ethash).
+
+	Ordering       string     `toml:",omitempty"` // Built-in transaction ordering strategy ("price" or "arrival")
+	CustomOrdering TxOrdering `toml:"-"`          // Custom transaction ordering strategy, overriding the built-in one
 }
 
 // Miner creates blocks and searches for proof-of-work values.
@@ -233,8 +236,35 @@ func (miner *Miner) DisablePreseal() {
 	miner.worker.disablePreseal()
 }
 
+// AddBundle queues a bundle of transactions to be included atomically at the
+// top of the block it targets, if it's profitable and doesn't revert.
+func (miner *Miner) AddBundle(bundle *Bundle) error {
+	chain := miner.eth.BlockChain()
+
+	head := chain.CurrentBlock()
+	statedb, err := chain.StateAt(head.Root())
+	if err != nil {
+		return err
+	}
+	return miner.worker.bundles.add(bundle, head.NumberU64(), statedb, types.LatestSigner(chain.Config()))
+}
+
 // SubscribePendingLogs starts delivering logs from pending transactions
 // to the given channel.
 func (miner *Miner) SubscribePendingLogs(ch chan<- []*types.Log) event.Subscription {
 	return miner.worker.pendingLogsFeed.Subscribe(ch)
 }
+
+// BuildPayload builds the payload according to the provided parameters. The
+// returned payload keeps being improved in the background until it's resolved.
+func (miner *Miner) BuildPayload(args *BuildPayloadArgs) (*Payload, error) {
+	return miner.worker.buildPayload(args)
+}
+
+// GetSealingBlockSync creates a sealing block according to the given parameters.
+// If the generation is failed or the underlying work is already closed, an error
+// will be returned.
+func (miner *Miner) GetSealingBlockSync(parent common.Hash, timestamp uint64, coinbase common.Address, random common.Hash, noTxs bool) (*types.Block, error) {
+	block, _, err := miner.worker.getSealingBlock(parent, timestamp, coinbase, random, noTxs)
+	return block, err
+}
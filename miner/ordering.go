@@ -0,0 +1,191 @@
+// Copyright 2021 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package miner
+
+import (
+	"bytes"
+	"container/heap"
+	"fmt"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+// Names of the built-in transaction ordering strategies.
+const (
+	OrderingPrice   = "price"   // Highest paying transactions first, local ones before remote ones
+	OrderingArrival = "arrival" // First seen transactions first, regardless of origin
+)
+
+// TxSet is a set of transactions the worker fills a block from, one at a time.
+// Implementations must honour the nonce order of the transactions of every
+// account.
+type TxSet interface {
+	// Peek returns the next transaction to include, nil if the set is exhausted.
+	Peek() *types.Transaction
+
+	// Shift replaces the current transaction with the next one from the same
+	// account, as the current one was included.
+	Shift()
+
+	// Pop removes the current transaction and skips the remaining ones from the
+	// same account, as the current one could not be included.
+	Pop()
+}
+
+// TxOrdering is a strategy deciding which pending transactions the worker
+// includes into a block and in what order.
+type TxOrdering interface {
+	// Order returns the sets of transactions to fill the block from, exhausted
+	// one after the other. The pending transactions are grouped by sender and
+	// sorted by nonce, split by local and remote origin. The maps are reowned.
+	Order(signer types.Signer, locals, remotes map[common.Address]types.Transactions, baseFee *big.Int) []TxSet
+}
+
+// NewTxOrdering creates one of the built-in transaction ordering strategies.
+func NewTxOrdering(name string) (TxOrdering, error) {
+	switch name {
+	case "", OrderingPrice:
+		return priceOrdering{}, nil
+	case OrderingArrival:
+		return arrivalOrdering{}, nil
+	default:
+		return nil, fmt.Errorf("unknown transaction ordering %q", name)
+	}
+}
+
+// priceOrdering is the default ordering strategy, including the local
+// transactions first, followed by the remote ones, both sorted by the fees
+// they pay to the miner.
+type priceOrdering struct{}
+
+func (priceOrdering) Order(signer types.Signer, locals, remotes map[common.Address]types.Transactions, baseFee *big.Int) []TxSet {
+	var sets []TxSet
+	if len(locals) > 0 {
+		sets = append(sets, types.NewTransactionsByPriceAndNonce(signer, locals, baseFee))
+	}
+	if len(remotes) > 0 {
+		sets = append(sets, types.NewTransactionsByPriceAndNonce(signer, remotes, baseFee))
+	}
+	return sets
+}
+
+// arrivalOrdering is a first-come-first-served ordering strategy, including the
+// transactions in the order they were first seen by the node.
+type arrivalOrdering struct{}
+
+func (arrivalOrdering) Order(signer types.Signer, locals, remotes map[common.Address]types.Transactions, baseFee *big.Int) []TxSet {
+	txs := remotes
+	if txs == nil {
+		txs = make(map[common.Address]types.Transactions)
+	}
+	for addr, list := range locals {
+		txs[addr] = list
+	}
+	if len(txs) == 0 {
+		return nil
+	}
+	return []TxSet{newTxsByArrival(signer, txs, baseFee)}
+}
+
+// txsByTime implements heap.Interface, ordering transactions by the time they
+// were first seen, tie broken by their hashes.
+type txsByTime []*types.Transaction
+
+func (s txsByTime) Len() int { return len(s) }
+func (s txsByTime) Less(i, j int) bool {
+	ti, tj := s[i].Time(), s[j].Time()
+	if ti.Equal(tj) {
+		hi, hj := s[i].Hash(), s[j].Hash()
+		return bytes.Compare(hi[:], hj[:]) < 0
+	}
+	return ti.Before(tj)
+}
+func (s txsByTime) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
+
+func (s *txsByTime) Push(x interface{}) {
+	*s = append(*s, x.(*types.Transaction))
+}
+
+func (s *txsByTime) Pop() interface{} {
+	old := *s
+	n := len(old)
+	x := old[n-1]
+	old[n-1] = nil
+	*s = old[0 : n-1]
+	return x
+}
+
+// txsByArrival is a transaction set returning the transactions in their order
+// of arrival, while honouring the nonce order of every account.
+type txsByArrival struct {
+	txs     map[common.Address]types.Transactions // Per account nonce-sorted list of transactions
+	heads   txsByTime                             // Next transaction for each unique account
+	signer  types.Signer                          // Signer for the set of transactions
+	baseFee *big.Int                              // Current base fee
+}
+
+func newTxsByArrival(signer types.Signer, txs map[common.Address]types.Transactions, baseFee *big.Int) *txsByArrival {
+	set := &txsByArrival{
+		txs:     txs,
+		heads:   make(txsByTime, 0, len(txs)),
+		signer:  signer,
+		baseFee: baseFee,
+	}
+	for from, accTxs := range txs {
+		// Drop the account if the sender doesn't match or it can't pay the base fee
+		if acc, _ := types.Sender(signer, accTxs[0]); acc != from || !set.payable(accTxs[0]) {
+			delete(txs, from)
+			continue
+		}
+		set.heads = append(set.heads, accTxs[0])
+		txs[from] = accTxs[1:]
+	}
+	heap.Init(&set.heads)
+	return set
+}
+
+// payable returns whether the transaction covers the current base fee.
+func (t *txsByArrival) payable(tx *types.Transaction) bool {
+	return t.baseFee == nil || tx.GasFeeCap().Cmp(t.baseFee) >= 0
+}
+
+// Peek returns the next transaction by arrival time.
+func (t *txsByArrival) Peek() *types.Transaction {
+	if len(t.heads) == 0 {
+		return nil
+	}
+	return t.heads[0]
+}
+
+// Shift replaces the current head with the next one from the same account.
+func (t *txsByArrival) Shift() {
+	acc, _ := types.Sender(t.signer, t.heads[0])
+	if txs, ok := t.txs[acc]; ok && len(txs) > 0 && t.payable(txs[0]) {
+		t.heads[0], t.txs[acc] = txs[0], txs[1:]
+		heap.Fix(&t.heads, 0)
+		return
+	}
+	heap.Pop(&t.heads)
+}
+
+// Pop removes the current head without shifting in the next one from the
+// same account.
+func (t *txsByArrival) Pop() {
+	heap.Pop(&t.heads)
+}
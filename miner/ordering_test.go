@@ -0,0 +1,112 @@
+// Copyright 2021 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package miner
+
+import (
+	"crypto/ecdsa"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// orderedTx creates a signed transaction first seen at the given time offset.
+func orderedTx(key *ecdsa.PrivateKey, nonce uint64, price int64, seen time.Duration) *types.Transaction {
+	tx := types.MustSignNewTx(key, types.HomesteadSigner{}, &types.LegacyTx{
+		Nonce:    nonce,
+		GasPrice: big.NewInt(price),
+		Gas:      21000,
+		To:       &common.Address{},
+	})
+	tx.SetTime(time.Unix(0, 0).Add(seen))
+	return tx
+}
+
+// drain returns all the transactions of the sets, in order.
+func drain(sets []TxSet) []*types.Transaction {
+	var txs []*types.Transaction
+	for _, set := range sets {
+		for tx := set.Peek(); tx != nil; tx = set.Peek() {
+			txs = append(txs, tx)
+			set.Shift()
+		}
+	}
+	return txs
+}
+
+// Tests that the built-in orderings return the transactions in the expected
+// order, honouring the nonces of the accounts.
+func TestTxOrdering(t *testing.T) {
+	keyA, _ := crypto.GenerateKey()
+	keyB, _ := crypto.GenerateKey()
+	addrA, addrB := crypto.PubkeyToAddress(keyA.PublicKey), crypto.PubkeyToAddress(keyB.PublicKey)
+
+	var (
+		a0 = orderedTx(keyA, 0, 1, 2*time.Second)
+		a1 = orderedTx(keyA, 1, 5, time.Second) // Replacement, seen before its predecessor
+		b0 = orderedTx(keyB, 0, 3, 3*time.Second)
+		b1 = orderedTx(keyB, 1, 3, 4*time.Second)
+	)
+	pending := func() (map[common.Address]types.Transactions, map[common.Address]types.Transactions) {
+		return map[common.Address]types.Transactions{addrA: {a0, a1}},
+			map[common.Address]types.Transactions{addrB: {b0, b1}}
+	}
+	tests := []struct {
+		ordering string
+		want     []*types.Transaction
+	}{
+		{OrderingPrice, []*types.Transaction{a0, a1, b0, b1}}, // Locals first
+		{OrderingArrival, []*types.Transaction{a0, a1, b0, b1}},
+		{"", []*types.Transaction{a0, a1, b0, b1}},
+	}
+	for i, tt := range tests {
+		ordering, err := NewTxOrdering(tt.ordering)
+		if err != nil {
+			t.Fatalf("test %d: failed to create ordering: %v", i, err)
+		}
+		locals, remotes := pending()
+		have := drain(ordering.Order(types.HomesteadSigner{}, locals, remotes, nil))
+		if len(have) != len(tt.want) {
+			t.Fatalf("test %d: transaction count mismatch: have %d, want %d", i, len(have), len(tt.want))
+		}
+		for j := range have {
+			if have[j].Hash() != tt.want[j].Hash() {
+				t.Errorf("test %d: transaction %d mismatch: have nonce %d, want %d", i, j, have[j].Nonce(), tt.want[j].Nonce())
+			}
+		}
+	}
+	// Without locals, the price ordering prefers the richer account while the
+	// arrival ordering the earlier one
+	price, _ := NewTxOrdering(OrderingPrice)
+	if have := drain(price.Order(types.HomesteadSigner{}, nil, map[common.Address]types.Transactions{addrA: {a0, a1}, addrB: {b0, b1}}, nil)); have[0] != b0 {
+		t.Errorf("price ordering head mismatch: have %x, want %x", have[0].Hash(), b0.Hash())
+	}
+	arrival, _ := NewTxOrdering(OrderingArrival)
+	if have := drain(arrival.Order(types.HomesteadSigner{}, nil, map[common.Address]types.Transactions{addrA: {a0, a1}, addrB: {b0, b1}}, nil)); have[0] != a0 {
+		t.Errorf("arrival ordering head mismatch: have %x, want %x", have[0].Hash(), a0.Hash())
+	}
+	// Accounts not paying the base fee should be skipped
+	if have := drain(arrival.Order(types.HomesteadSigner{}, nil, map[common.Address]types.Transactions{addrA: {a0, a1}, addrB: {b0, b1}}, big.NewInt(2))); len(have) != 2 || have[0] != b0 {
+		t.Errorf("base fee filtering mismatch: have %d transactions", len(have))
+	}
+	if _, err := NewTxOrdering("random"); err == nil {
+		t.Errorf("unknown ordering accepted")
+	}
+}
@@ -0,0 +1,188 @@
+// Copyright 2021 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package miner
+
+import (
+	"math/big"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/metrics"
+	"github.com/ethereum/go-ethereum/params"
+)
+
+// payloadBuildTimeout is the time after which a payload isn't improved anymore,
+// matching the SECONDS_PER_SLOT of the beacon chain mainnet configuration.
+const payloadBuildTimeout = 12 * time.Second
+
+var (
+	payloadBuildTimer    = metrics.NewRegisteredTimer("miner/payload/build", nil)
+	payloadFeesHistogram = metrics.NewRegisteredHistogram("miner/payload/fees", nil, metrics.NewExpDecaySample(1028, 0.015))
+	payloadImproveMeter  = metrics.NewRegisteredMeter("miner/payload/improve", nil)
+)
+
+// BuildPayloadArgs contains the provided parameters for building payload.
+type BuildPayloadArgs struct {
+	Parent       common.Hash    // The parent block to build payload on top
+	Timestamp    uint64         // The provided timestamp of generated payload
+	FeeRecipient common.Address // The provided recipient address for collecting transaction fee
+	Random       common.Hash    // The provided randomness value
+}
+
+// Payload wraps the built payload (block waiting for sealing). The initial
+// version of the payload has an empty transaction set and is always available,
+// the full version is then rebuilt periodically in the background, keeping the
+// one yielding the most fees, until the payload is resolved or times out.
+type Payload struct {
+	empty    *types.Block
+	full     *types.Block
+	fullFees *big.Int
+	stop     chan struct{}
+	lock     sync.Mutex
+}
+
+// newPayload initializes the payload object.
+func newPayload(empty *types.Block) *Payload {
+	return &Payload{
+		empty: empty,
+		stop:  make(chan struct{}),
+	}
+}
+
+// update updates the full-block with latest built version, if it yields more
+// fees to the fee recipient than the previous one.
+func (payload *Payload) update(block *types.Block, fees *big.Int, elapsed time.Duration) {
+	payload.lock.Lock()
+	defer payload.lock.Unlock()
+
+	select {
+	case <-payload.stop:
+		return // reject stale update
+	default:
+	}
+	payloadBuildTimer.Update(elapsed)
+	payloadFeesHistogram.Update(new(big.Int).Div(fees, big.NewInt(params.GWei)).Int64())
+
+	// Ensure the newly provided full block pays the fee recipient more. In
+	// post-merge stage, there is no uncle reward anymore and the transaction
+	// tips are the only indicator for comparison.
+	if payload.full == nil || fees.Cmp(payload.fullFees) > 0 {
+		payload.full = block
+		payload.fullFees = fees
+		payloadImproveMeter.Mark(1)
+
+		feesInEther := new(big.Float).Quo(new(big.Float).SetInt(fees), big.NewFloat(params.Ether))
+		log.Info("Updated payload", "number", block.NumberU64(), "hash", block.Hash(),
+			"txs", len(block.Transactions()), "gas", block.GasUsed(), "fees", feesInEther,
+			"elapsed", common.PrettyDuration(elapsed))
+	}
+}
+
+// Resolve returns the latest built payload and also terminates the background
+// thread for updating payload. It's safe to be called multiple times.
+func (payload *Payload) Resolve() *types.Block {
+	payload.lock.Lock()
+	defer payload.lock.Unlock()
+
+	select {
+	case <-payload.stop:
+	default:
+		close(payload.stop)
+	}
+	if payload.full != nil {
+		return payload.full
+	}
+	return payload.empty
+}
+
+// Stop terminates the background thread updating the payload without retrieving
+// it, e.g. when the payload is dropped. It's safe to be called multiple times.
+func (payload *Payload) Stop() {
+	payload.lock.Lock()
+	defer payload.lock.Unlock()
+
+	select {
+	case <-payload.stop:
+	default:
+		close(payload.stop)
+	}
+}
+
+// ResolveEmpty is basically identical to Resolve, but it expects empty block only.
+// It's only used in tests.
+func (payload *Payload) ResolveEmpty() *types.Block {
+	payload.lock.Lock()
+	defer payload.lock.Unlock()
+
+	return payload.empty
+}
+
+// buildPayload builds the payload according to the provided parameters. The
+// empty payload is built synchronously, the full one keeps being rebuilt in
+// the background at the recommit interval.
+func (w *worker) buildPayload(args *BuildPayloadArgs) (*Payload, error) {
+	// Build the initial version with no transaction included. It should be fast
+	// enough to run. The empty payload can at least make sure there is something
+	// to deliver for not missing slot.
+	empty, _, err := w.getSealingBlock(args.Parent, args.Timestamp, args.FeeRecipient, args.Random, true)
+	if err != nil {
+		return nil, err
+	}
+	payload := newPayload(empty)
+
+	go func() {
+		// Setup the timer for re-building the payload. The initial clock is kept
+		// for triggering process immediately.
+		timer := time.NewTimer(0)
+		defer timer.Stop()
+
+		// Setup the timer for terminating the process once the payload can't be
+		// proposed anymore.
+		endTimer := time.NewTimer(payloadBuildTimeout)
+		defer endTimer.Stop()
+
+		for {
+			select {
+			case <-timer.C:
+				start := time.Now()
+				block, fees, err := w.getSealingBlock(args.Parent, args.Timestamp, args.FeeRecipient, args.Random, false)
+				if err == nil {
+					payload.update(block, fees, time.Since(start))
+				} else {
+					log.Debug("Failed to rebuild payload", "parent", args.Parent, "err", err)
+				}
+				w.mu.RLock()
+				recommit := w.recommit
+				w.mu.RUnlock()
+				timer.Reset(recommit)
+
+			case <-payload.stop:
+				log.Info("Stopping work on payload", "reason", "delivery")
+				return
+			case <-endTimer.C:
+				log.Info("Stopping work on payload", "reason", "timeout")
+				return
+			case <-w.exitCh:
+				return
+			}
+		}
+	}()
+	return payload, nil
+}
@@ -0,0 +1,76 @@
+// Copyright 2021 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package miner
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/consensus/ethash"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/params"
+)
+
+func TestBuildPayload(t *testing.T) {
+	var (
+		db        = rawdb.NewMemoryDatabase()
+		recipient = common.HexToAddress("0xdeadbeef")
+	)
+	w, b := newTestWorker(t, params.TestChainConfig, ethash.NewFaker(), db, 0)
+	defer w.close()
+
+	timestamp := uint64(time.Now().Unix())
+	args := &BuildPayloadArgs{
+		Parent:       b.chain.CurrentBlock().Hash(),
+		Timestamp:    timestamp,
+		Random:       common.Hash{0x01},
+		FeeRecipient: recipient,
+	}
+	payload, err := w.buildPayload(args)
+	if err != nil {
+		t.Fatalf("Failed to build payload %v", err)
+	}
+	verify := func(block *types.Block, txs int) {
+		t.Helper()
+
+		if block.ParentHash() != b.chain.CurrentBlock().Hash() {
+			t.Fatal("Unexpected parent hash")
+		}
+		if block.MixDigest() != (common.Hash{0x01}) {
+			t.Fatal("Unexpected random value")
+		}
+		if block.Time() != timestamp {
+			t.Fatal("Unexpected timestamp")
+		}
+		if block.Coinbase() != recipient {
+			t.Fatal("Unexpected fee recipient")
+		}
+		if len(block.Transactions()) != txs {
+			t.Fatalf("Unexpected transaction set: have %d, want %d", len(block.Transactions()), txs)
+		}
+	}
+	verify(payload.ResolveEmpty(), 0)
+
+	// Ensure the full payload gets built in the background
+	time.Sleep(time.Millisecond * 100)
+	verify(payload.Resolve(), len(pendingTxs))
+
+	// Ensure resolving is idempotent and the background thread is stopped
+	verify(payload.Resolve(), len(pendingTxs))
+}
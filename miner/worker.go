@@ -19,6 +19,7 @@ package miner
 import (
 	"bytes"
 	"errors"
+	"fmt"
 	"math/big"
 	"sync"
 	"sync/atomic"
@@ -114,6 +115,30 @@ type newWorkReq struct {
 	timestamp int64
 }
 
+// generateParams wraps the settings for generating a sealing block outside of
+// the mining cycle.
+type generateParams struct {
+	timestamp  uint64         // The timestamp for sealing task
+	parentHash common.Hash    // Parent block hash
+	coinbase   common.Address // The fee recipient address for including transaction
+	random     common.Hash    // The randomness generated by beacon chain
+	noTxs      bool           // Flag whether an empty block without any transaction is expected
+}
+
+// getWorkReq represents a request for a sealing block built with the given
+// parameters, along with the channel to deliver the result to.
+type getWorkReq struct {
+	params *generateParams
+	result chan *getWorkResult
+}
+
+// getWorkResult is the outcome of a getWorkReq.
+type getWorkResult struct {
+	block *types.Block
+	fees  *big.Int
+	err   error
+}
+
 // intervalAdjust represents a resubmitting interval adjustment.
 type intervalAdjust struct {
 	ratio float64
@@ -128,6 +153,8 @@ type worker struct {
 	engine      consensus.Engine
 	eth         Backend
 	chain       *core.BlockChain
+	ordering    TxOrdering  // Strategy to order the pending transactions with
+	bundles     *bundlePool // Bundles waiting to be included at the top of the blocks
 
 	// Feeds
 	pendingLogsFeed event.Feed
@@ -143,6 +170,7 @@ type worker struct {
 
 	// Channels
 	newWorkCh          chan *newWorkReq
+	getWorkCh          chan *getWorkReq
 	taskCh             chan *task
 	resultCh           chan *types.Block
 	startCh            chan struct{}
@@ -157,9 +185,10 @@ type worker struct {
 	remoteUncles map[common.Hash]*types.Block // A set of side blocks as the possible uncle blocks.
 	unconfirmed  *unconfirmedBlocks           // A set of locally mined blocks pending canonicalness confirmations.
 
-	mu       sync.RWMutex // The lock used to protect the coinbase and extra fields
+	mu       sync.RWMutex // The lock used to protect the coinbase, extra and recommit fields
 	coinbase common.Address
 	extra    []byte
+	recommit time.Duration // The interval to rebuild requested payloads at
 
 	pendingMu    sync.RWMutex
 	pendingTasks map[common.Hash]*task
@@ -207,12 +236,25 @@ func newWorker(config *Config, chainConfig *params.ChainConfig, engine consensus
 		chainHeadCh:        make(chan core.ChainHeadEvent, chainHeadChanSize),
 		chainSideCh:        make(chan core.ChainSideEvent, chainSideChanSize),
 		newWorkCh:          make(chan *newWorkReq),
+		getWorkCh:          make(chan *getWorkReq),
 		taskCh:             make(chan *task),
 		resultCh:           make(chan *types.Block, resultQueueSize),
 		exitCh:             make(chan struct{}),
 		startCh:            make(chan struct{}, 1),
 		resubmitIntervalCh: make(chan time.Duration),
 		resubmitAdjustCh:   make(chan *intervalAdjust, resubmitAdjustChanSize),
+		bundles:            newBundlePool(),
+	}
+	// Create the transaction ordering strategy, falling back to the default one
+	if config.CustomOrdering != nil {
+		worker.ordering = config.CustomOrdering
+	} else {
+		ordering, err := NewTxOrdering(config.Ordering)
+		if err != nil {
+			log.Warn("Sanitizing miner transaction ordering", "provided", config.Ordering, "updated", OrderingPrice, "err", err)
+			ordering = priceOrdering{}
+		}
+		worker.ordering = ordering
 	}
 	// Subscribe NewTxsEvent for tx pool
 	worker.txsSub = eth.TxPool().SubscribeNewTxsEvent(worker.txsCh)
@@ -226,6 +268,7 @@ func newWorker(config *Config, chainConfig *params.ChainConfig, engine consensus
 		log.Warn("Sanitizing miner recommit interval", "provided", recommit, "updated", minRecommitInterval)
 		recommit = minRecommitInterval
 	}
+	worker.recommit = recommit
 
 	worker.wg.Add(4)
 	go worker.mainLoop()
@@ -419,6 +462,10 @@ func (w *worker) newWorkLoop(recommit time.Duration) {
 			log.Info("Miner recommit interval update", "from", minRecommit, "to", interval)
 			minRecommit, recommit = interval, interval
 
+			w.mu.Lock()
+			w.recommit = interval
+			w.mu.Unlock()
+
 			if w.resubmitHook != nil {
 				w.resubmitHook(minRecommit, recommit)
 			}
@@ -463,6 +510,10 @@ func (w *worker) mainLoop() {
 		case req := <-w.newWorkCh:
 			w.commitNewWork(req.interrupt, req.noempty, req.timestamp)
 
+		case req := <-w.getWorkCh:
+			block, fees, err := w.generateWork(req.params)
+			req.result <- &getWorkResult{block: block, fees: fees, err: err}
+
 		case ev := <-w.chainSideCh:
 			// Short circuit for duplicate side blocks
 			if _, exist := w.localUncles[ev.Block.Hash()]; exist {
@@ -522,9 +573,10 @@ func (w *worker) mainLoop() {
 					acc, _ := types.Sender(w.current.signer, tx)
 					txs[acc] = append(txs[acc], tx)
 				}
-				txset := types.NewTransactionsByPriceAndNonce(w.current.signer, txs, w.current.header.BaseFee)
 				tcount := w.current.tcount
-				w.commitTransactions(txset, coinbase, nil)
+				for _, txset := range w.ordering.Order(w.current.signer, nil, txs, w.current.header.BaseFee) {
+					w.commitTransactions(w.current, txset, coinbase, nil)
+				}
 				// Only update the snapshot if any new transactons were added
 				// to the pending block
 				if tcount != w.current.tcount {
@@ -668,13 +720,14 @@ func (w *worker) resultLoop() {
 	}
 }
 
-// makeCurrent creates a new environment for the current cycle.
-func (w *worker) makeCurrent(parent *types.Block, header *types.Header) error {
+// makeEnv creates a new environment for a sealing block built on top of the
+// given parent.
+func (w *worker) makeEnv(parent *types.Block, header *types.Header) (*environment, error) {
 	// Retrieve the parent state to execute on top and start a prefetcher for
 	// the miner to speed block sealing up a bit
 	state, err := w.chain.StateAt(parent.Root())
 	if err != nil {
-		return err
+		return nil, err
 	}
 	state.StartPrefetcher("miner")
 
@@ -696,7 +749,15 @@ func (w *worker) makeCurrent(parent *types.Block, header *types.Header) error {
 	}
 	// Keep track of transactions which return errors so they can be removed
 	env.tcount = 0
+	return env, nil
+}
 
+// makeCurrent creates a new environment for the current cycle.
+func (w *worker) makeCurrent(parent *types.Block, header *types.Header) error {
+	env, err := w.makeEnv(parent, header)
+	if err != nil {
+		return err
+	}
 	// Swap out the old work with the new one, terminating any leftover prefetcher
 	// processes in the mean time and starting a new one.
 	if w.current != nil && w.current.state != nil {
@@ -759,29 +820,29 @@ func (w *worker) updateSnapshot() {
 	w.snapshotState = w.current.state.Copy()
 }
 
-func (w *worker) commitTransaction(tx *types.Transaction, coinbase common.Address) ([]*types.Log, error) {
-	snap := w.current.state.Snapshot()
+func (w *worker) commitTransaction(env *environment, tx *types.Transaction, coinbase common.Address) ([]*types.Log, error) {
+	snap := env.state.Snapshot()
 
-	receipt, err := core.ApplyTransaction(w.chainConfig, w.chain, &coinbase, w.current.gasPool, w.current.state, w.current.header, tx, &w.current.header.GasUsed, *w.chain.GetVMConfig())
+	receipt, err := core.ApplyTransaction(w.chainConfig, w.chain, &coinbase, env.gasPool, env.state, env.header, tx, &env.header.GasUsed, *w.chain.GetVMConfig())
 	if err != nil {
-		w.current.state.RevertToSnapshot(snap)
+		env.state.RevertToSnapshot(snap)
 		return nil, err
 	}
-	w.current.txs = append(w.current.txs, tx)
-	w.current.receipts = append(w.current.receipts, receipt)
+	env.txs = append(env.txs, tx)
+	env.receipts = append(env.receipts, receipt)
 
 	return receipt.Logs, nil
 }
 
-func (w *worker) commitTransactions(txs *types.TransactionsByPriceAndNonce, coinbase common.Address, interrupt *int32) bool {
-	// Short circuit if current is nil
-	if w.current == nil {
+func (w *worker) commitTransactions(env *environment, txs TxSet, coinbase common.Address, interrupt *int32) bool {
+	// Short circuit if the environment is nil
+	if env == nil {
 		return true
 	}
 
-	gasLimit := w.current.header.GasLimit
-	if w.current.gasPool == nil {
-		w.current.gasPool = new(core.GasPool).AddGas(gasLimit)
+	gasLimit := env.header.GasLimit
+	if env.gasPool == nil {
+		env.gasPool = new(core.GasPool).AddGas(gasLimit)
 	}
 
 	var coalescedLogs []*types.Log
@@ -796,7 +857,7 @@ func (w *worker) commitTransactions(txs *types.TransactionsByPriceAndNonce, coin
 		if interrupt != nil && atomic.LoadInt32(interrupt) != commitInterruptNone {
 			// Notify resubmit loop to increase resubmitting interval due to too frequent commits.
 			if atomic.LoadInt32(interrupt) == commitInterruptResubmit {
-				ratio := float64(gasLimit-w.current.gasPool.Gas()) / float64(gasLimit)
+				ratio := float64(gasLimit-env.gasPool.Gas()) / float64(gasLimit)
 				if ratio < 0.1 {
 					ratio = 0.1
 				}
@@ -808,8 +869,8 @@ func (w *worker) commitTransactions(txs *types.TransactionsByPriceAndNonce, coin
 			return atomic.LoadInt32(interrupt) == commitInterruptNewHead
 		}
 		// If we don't have enough gas for any further transactions then we're done
-		if w.current.gasPool.Gas() < params.TxGas {
-			log.Trace("Not enough gas for further transactions", "have", w.current.gasPool, "want", params.TxGas)
+		if env.gasPool.Gas() < params.TxGas {
+			log.Trace("Not enough gas for further transactions", "have", env.gasPool, "want", params.TxGas)
 			break
 		}
 		// Retrieve the next transaction and abort if all done
@@ -821,19 +882,19 @@ func (w *worker) commitTransactions(txs *types.TransactionsByPriceAndNonce, coin
 		// during transaction acceptance is the transaction pool.
 		//
 		// We use the eip155 signer regardless of the current hf.
-		from, _ := types.Sender(w.current.signer, tx)
+		from, _ := types.Sender(env.signer, tx)
 		// Check whether the tx is replay protected. If we're not in the EIP155 hf
 		// phase, start ignoring the sender until we do.
-		if tx.Protected() && !w.chainConfig.IsEIP155(w.current.header.Number) {
+		if tx.Protected() && !w.chainConfig.IsEIP155(env.header.Number) {
 			log.Trace("Ignoring reply protected transaction", "hash", tx.Hash(), "eip155", w.chainConfig.EIP155Block)
 
 			txs.Pop()
 			continue
 		}
 		// Start executing the transaction
-		w.current.state.Prepare(tx.Hash(), w.current.tcount)
+		env.state.Prepare(tx.Hash(), env.tcount)
 
-		logs, err := w.commitTransaction(tx, coinbase)
+		logs, err := w.commitTransaction(env, tx, coinbase)
 		switch {
 		case errors.Is(err, core.ErrGasLimitReached):
 			// Pop the current out-of-gas transaction without shifting in the next from the account
@@ -853,7 +914,7 @@ func (w *worker) commitTransactions(txs *types.TransactionsByPriceAndNonce, coin
 		case errors.Is(err, nil):
 			// Everything ok, collect the logs and shift in the next transaction from the same account
 			coalescedLogs = append(coalescedLogs, logs...)
-			w.current.tcount++
+			env.tcount++
 			txs.Shift()
 
 		case errors.Is(err, core.ErrTxTypeNotSupported):
@@ -869,27 +930,35 @@ func (w *worker) commitTransactions(txs *types.TransactionsByPriceAndNonce, coin
 		}
 	}
 
-	if !w.isRunning() && len(coalescedLogs) > 0 {
+	w.sendPendingLogs(env, coalescedLogs)
+
+	// Notify resubmit loop to decrease resubmitting interval if current interval is larger
+	// than the user-specified one.
+	if interrupt != nil {
+		w.resubmitAdjustCh <- &intervalAdjust{inc: false}
+	}
+	return false
+}
+
+// sendPendingLogs pushes the logs of the transactions included in the pending
+// block to the subscribers.
+func (w *worker) sendPendingLogs(env *environment, logs []*types.Log) {
+	if !w.isRunning() && env == w.current && len(logs) > 0 {
 		// We don't push the pendingLogsEvent while we are mining. The reason is that
 		// when we are mining, the worker will regenerate a mining block every 3 seconds.
 		// In order to avoid pushing the repeated pendingLog, we disable the pending log pushing.
+		// Blocks generated on request aren't the pending one, so their logs aren't pushed either.
 
 		// make a copy, the state caches the logs and these logs get "upgraded" from pending to mined
 		// logs by filling in the block hash when the block was mined by the local miner. This can
 		// cause a race condition if a log was "upgraded" before the PendingLogsEvent is processed.
-		cpy := make([]*types.Log, len(coalescedLogs))
-		for i, l := range coalescedLogs {
+		cpy := make([]*types.Log, len(logs))
+		for i, l := range logs {
 			cpy[i] = new(types.Log)
 			*cpy[i] = *l
 		}
 		w.pendingLogsFeed.Send(cpy)
 	}
-	// Notify resubmit loop to decrease resubmitting interval if current interval is larger
-	// than the user-specified one.
-	if interrupt != nil {
-		w.resubmitAdjustCh <- &intervalAdjust{inc: false}
-	}
-	return false
 }
 
 // commitNewWork generates several new sealing tasks based on the parent block.
@@ -986,16 +1055,34 @@ func (w *worker) commitNewWork(interrupt *int32, noempty bool, timestamp int64)
 		w.commit(uncles, nil, false, tstart)
 	}
 
+	// Include the most profitable bundles at the top of the block.
+	bundles, discard := w.commitBundles(env, w.coinbase, interrupt)
+	if discard {
+		return
+	}
+
 	// Fill the block with all available pending transactions.
 	pending := w.eth.TxPool().Pending(true)
 	// Short circuit if there is no available pending transactions.
 	// But if we disable empty precommit already, ignore it. Since
 	// empty block is necessary to keep the liveness of the network.
-	if len(pending) == 0 && atomic.LoadUint32(&w.noempty) == 0 {
+	if len(pending) == 0 && bundles == 0 && atomic.LoadUint32(&w.noempty) == 0 {
 		w.updateSnapshot()
 		return
 	}
 	// Split the pending transactions into locals and remotes
+	localTxs, remoteTxs := w.splitLocals(pending)
+	for _, txs := range w.ordering.Order(env.signer, localTxs, remoteTxs, header.BaseFee) {
+		if w.commitTransactions(env, txs, w.coinbase, interrupt) {
+			return
+		}
+	}
+	w.commit(uncles, w.fullTaskHook, true, tstart)
+}
+
+// splitLocals splits the pending transactions into the ones sent by the local
+// accounts and the remote ones.
+func (w *worker) splitLocals(pending map[common.Address]types.Transactions) (map[common.Address]types.Transactions, map[common.Address]types.Transactions) {
 	localTxs, remoteTxs := make(map[common.Address]types.Transactions), pending
 	for _, account := range w.eth.TxPool().Locals() {
 		if txs := remoteTxs[account]; len(txs) > 0 {
@@ -1003,19 +1090,87 @@ func (w *worker) commitNewWork(interrupt *int32, noempty bool, timestamp int64)
 			localTxs[account] = txs
 		}
 	}
-	if len(localTxs) > 0 {
-		txs := types.NewTransactionsByPriceAndNonce(w.current.signer, localTxs, header.BaseFee)
-		if w.commitTransactions(txs, w.coinbase, interrupt) {
-			return
+	return localTxs, remoteTxs
+}
+
+// generateWork builds a sealing block with the given parameters on top of the
+// requested parent, independently of the current mining cycle. The block is
+// returned along with the tips its transactions pay to the coinbase.
+func (w *worker) generateWork(genParams *generateParams) (*types.Block, *big.Int, error) {
+	// Copy the fields guarded by the lock, no need to hold it during the build
+	w.mu.RLock()
+	extra, gasCeil := w.extra, w.config.GasCeil
+	w.mu.RUnlock()
+
+	parent := w.chain.GetBlockByHash(genParams.parentHash)
+	if parent == nil {
+		return nil, nil, fmt.Errorf("missing parent %x", genParams.parentHash)
+	}
+	if parent.Time() >= genParams.timestamp {
+		return nil, nil, fmt.Errorf("invalid timestamp, parent %d given %d", parent.Time(), genParams.timestamp)
+	}
+	num := parent.Number()
+	header := &types.Header{
+		ParentHash: parent.Hash(),
+		Number:     num.Add(num, common.Big1),
+		GasLimit:   core.CalcGasLimit(parent.GasLimit(), gasCeil),
+		Extra:      extra,
+		Time:       genParams.timestamp,
+		Coinbase:   genParams.coinbase,
+		MixDigest:  genParams.random,
+	}
+	// Set baseFee and GasLimit if we are on an EIP-1559 chain
+	if w.chainConfig.IsLondon(header.Number) {
+		header.BaseFee = misc.CalcBaseFee(w.chainConfig, parent.Header())
+		if !w.chainConfig.IsLondon(parent.Number()) {
+			parentGasLimit := parent.GasLimit() * params.ElasticityMultiplier
+			header.GasLimit = core.CalcGasLimit(parentGasLimit, gasCeil)
 		}
 	}
-	if len(remoteTxs) > 0 {
-		txs := types.NewTransactionsByPriceAndNonce(w.current.signer, remoteTxs, header.BaseFee)
-		if w.commitTransactions(txs, w.coinbase, interrupt) {
-			return
+	if err := w.engine.Prepare(w.chain, header); err != nil {
+		return nil, nil, err
+	}
+	env, err := w.makeEnv(parent, header)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer env.state.StopPrefetcher()
+
+	if !genParams.noTxs {
+		w.commitBundles(env, genParams.coinbase, nil)
+
+		localTxs, remoteTxs := w.splitLocals(w.eth.TxPool().Pending(true))
+		for _, txs := range w.ordering.Order(env.signer, localTxs, remoteTxs, header.BaseFee) {
+			w.commitTransactions(env, txs, genParams.coinbase, nil)
 		}
 	}
-	w.commit(uncles, w.fullTaskHook, true, tstart)
+	block, err := w.engine.FinalizeAndAssemble(w.chain, header, env.state, env.txs, nil, env.receipts)
+	if err != nil {
+		return nil, nil, err
+	}
+	return block, blockFees(block, env.receipts), nil
+}
+
+// getSealingBlock builds a sealing block with the given parameters. The request
+// is served by the main loop so it doesn't interfere with the mining cycle.
+func (w *worker) getSealingBlock(parent common.Hash, timestamp uint64, coinbase common.Address, random common.Hash, noTxs bool) (*types.Block, *big.Int, error) {
+	req := &getWorkReq{
+		params: &generateParams{
+			timestamp:  timestamp,
+			parentHash: parent,
+			coinbase:   coinbase,
+			random:     random,
+			noTxs:      noTxs,
+		},
+		result: make(chan *getWorkResult, 1),
+	}
+	select {
+	case w.getWorkCh <- req:
+		result := <-req.result
+		return result.block, result.fees, result.err
+	case <-w.exitCh:
+		return nil, nil, errors.New("miner closed")
+	}
 }
 
 // commit runs any post-transaction state modifications, assembles the final block
@@ -1068,12 +1223,17 @@ func (w *worker) postSideBlock(event core.ChainSideEvent) {
 	}
 }
 
-// totalFees computes total consumed miner fees in ETH. Block transactions and receipts have to have the same order.
-func totalFees(block *types.Block, receipts []*types.Receipt) *big.Float {
+// blockFees computes total consumed miner fees in wei. Block transactions and receipts have to have the same order.
+func blockFees(block *types.Block, receipts []*types.Receipt) *big.Int {
 	feesWei := new(big.Int)
 	for i, tx := range block.Transactions() {
 		minerFee, _ := tx.EffectiveGasTip(block.BaseFee())
 		feesWei.Add(feesWei, new(big.Int).Mul(new(big.Int).SetUint64(receipts[i].GasUsed), minerFee))
 	}
-	return new(big.Float).Quo(new(big.Float).SetInt(feesWei), new(big.Float).SetInt(big.NewInt(params.Ether)))
+	return feesWei
+}
+
+// totalFees computes total consumed miner fees in ETH. Block transactions and receipts have to have the same order.
+func totalFees(block *types.Block, receipts []*types.Receipt) *big.Float {
+	return new(big.Float).Quo(new(big.Float).SetInt(blockFees(block, receipts)), new(big.Float).SetInt(big.NewInt(params.Ether)))
 }
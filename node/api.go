@@ -208,7 +208,7 @@ func (api *privateAdminAPI) StartHTTP(host *string, port *int, cors *string, api
 	if err := api.node.http.setListenAddr(*host, *port); err != nil {
 		return false, err
 	}
-	if err := api.node.http.enableRPC(api.node.rpcAPIs, config); err != nil {
+	if err := api.node.http.enableRPC(api.node.openAPIs(), config); err != nil {
 		return false, err
 	}
 	if err := api.node.http.start(); err != nil {
@@ -278,7 +278,7 @@ func (api *privateAdminAPI) StartWS(host *string, port *int, allowedOrigins *str
 	if err := server.setListenAddr(*host, *port); err != nil {
 		return false, err
 	}
-	if err := server.enableWS(api.node.rpcAPIs, config); err != nil {
+	if err := server.enableWS(api.node.openAPIs(), config); err != nil {
 		return false, err
 	}
 	if err := server.start(); err != nil {
@@ -40,6 +40,7 @@ const (
 	datadirStaticNodes     = "static-nodes.json"  // Path within the datadir to the static node list
 	datadirTrustedNodes    = "trusted-nodes.json" // Path within the datadir to the trusted node list
 	datadirNodeDatabase    = "nodes"              // Path within the datadir to store the node infos
+	datadirJWTKey          = "jwtsecret"          // Path within the datadir to the node's jwt secret
 )
 
 // Config represents a small collection of configuration values to fine tune the
@@ -167,6 +168,23 @@ type Config struct {
 	// private APIs to untrusted users is a major security risk.
 	WSExposeAll bool `toml:",omitempty"`
 
+	// AuthAddr is the listening address on which the authenticated APIs are
+	// served, over both HTTP and WebSocket.
+	AuthAddr string `toml:",omitempty"`
+
+	// AuthPort is the TCP port number on which the authenticated APIs are served.
+	AuthPort int `toml:",omitempty"`
+
+	// AuthVirtualHosts is the list of virtual hostnames which are allowed on incoming
+	// requests to the authenticated APIs. This is by default {'localhost'}.
+	AuthVirtualHosts []string `toml:",omitempty"`
+
+	// JWTSecret is the path to the hex-encoded secret used to authenticate the
+	// requests to the authenticated APIs. If the file doesn't exist, a new secret
+	// is generated and stored there. If empty, the secret is kept in the data
+	// directory.
+	JWTSecret string `toml:",omitempty"`
+
 	// GraphQLCors is the Cross-Origin Resource Sharing header to send to requesting
 	// clients. Please be aware that CORS is a browser enforced security, it's fully
 	// useless for custom HTTP clients.
@@ -17,8 +17,11 @@
 package node
 
 import (
+	crand "crypto/rand"
 	"errors"
 	"fmt"
+	"hash/crc32"
+	"io/ioutil"
 	"net/http"
 	"os"
 	"path/filepath"
@@ -27,6 +30,8 @@ import (
 	"sync"
 
 	"github.com/ethereum/go-ethereum/accounts"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
 	"github.com/ethereum/go-ethereum/core/rawdb"
 	"github.com/ethereum/go-ethereum/ethdb"
 	"github.com/ethereum/go-ethereum/event"
@@ -55,6 +60,7 @@ type Node struct {
 	rpcAPIs       []rpc.API   // List of APIs currently provided by the node
 	http          *httpServer //
 	ws            *httpServer //
+	httpAuth      *httpServer // Serves the authenticated APIs over both HTTP and WebSocket
 	ipc           *ipcServer  // Stores information about the ipc http server
 	inprocHandler *rpc.Server // In-process RPC request handler to process the API requests
 
@@ -148,6 +154,7 @@ func New(conf *Config) (*Node, error) {
 	// Configure RPC servers.
 	node.http = newHTTPServer(node.log, conf.HTTPTimeouts)
 	node.ws = newHTTPServer(node.log, rpc.DefaultHTTPTimeouts)
+	node.httpAuth = newHTTPServer(node.log, conf.HTTPTimeouts)
 	node.ipc = newIPCServer(node.log, conf.IPCEndpoint())
 
 	return node, nil
@@ -361,7 +368,7 @@ func (n *Node) startRPC() error {
 		if err := n.http.setListenAddr(n.config.HTTPHost, n.config.HTTPPort); err != nil {
 			return err
 		}
-		if err := n.http.enableRPC(n.rpcAPIs, config); err != nil {
+		if err := n.http.enableRPC(n.openAPIs(), config); err != nil {
 			return err
 		}
 	}
@@ -377,7 +384,33 @@ func (n *Node) startRPC() error {
 		if err := server.setListenAddr(n.config.WSHost, n.config.WSPort); err != nil {
 			return err
 		}
-		if err := server.enableWS(n.rpcAPIs, config); err != nil {
+		if err := server.enableWS(n.openAPIs(), config); err != nil {
+			return err
+		}
+	}
+
+	// Configure the authenticated endpoint, if any API requires it.
+	if n.config.AuthAddr != "" && len(n.openAPIs()) < len(n.rpcAPIs) {
+		secret, err := n.obtainJWTSecret(n.config.JWTSecret)
+		if err != nil {
+			return err
+		}
+		if err := n.httpAuth.setListenAddr(n.config.AuthAddr, n.config.AuthPort); err != nil {
+			return err
+		}
+		httpConfig := httpConfig{
+			Vhosts:    n.config.AuthVirtualHosts,
+			Modules:   DefaultAuthModules,
+			jwtSecret: secret,
+		}
+		if err := n.httpAuth.enableRPC(n.rpcAPIs, httpConfig); err != nil {
+			return err
+		}
+		wsConfig := wsConfig{
+			Modules:   DefaultAuthModules,
+			jwtSecret: secret,
+		}
+		if err := n.httpAuth.enableWS(n.rpcAPIs, wsConfig); err != nil {
 			return err
 		}
 	}
@@ -385,7 +418,54 @@ func (n *Node) startRPC() error {
 	if err := n.http.start(); err != nil {
 		return err
 	}
-	return n.ws.start()
+	if err := n.ws.start(); err != nil {
+		return err
+	}
+	return n.httpAuth.start()
+}
+
+// openAPIs returns the registered APIs which don't require authentication.
+func (n *Node) openAPIs() []rpc.API {
+	var apis []rpc.API
+	for _, api := range n.rpcAPIs {
+		if !api.Authenticated {
+			apis = append(apis, api)
+		}
+	}
+	return apis
+}
+
+// obtainJWTSecret loads the hex-encoded JWT secret from the given path, or the
+// default location within the data directory if empty. If the file doesn't
+// exist, a new secret is generated and persisted.
+func (n *Node) obtainJWTSecret(path string) ([]byte, error) {
+	if path == "" {
+		path = n.ResolvePath(datadirJWTKey)
+	}
+	if path != "" {
+		if data, err := ioutil.ReadFile(path); err == nil {
+			secret := common.FromHex(strings.TrimSpace(string(data)))
+			if len(secret) != 32 {
+				return nil, fmt.Errorf("invalid JWT secret in %s: length %d", path, len(secret))
+			}
+			n.log.Info("Loaded JWT secret file", "path", path, "crc32", fmt.Sprintf("%#x", crc32.ChecksumIEEE(secret)))
+			return secret, nil
+		}
+	}
+	secret := make([]byte, 32)
+	if _, err := crand.Read(secret); err != nil {
+		return nil, err
+	}
+	// Without a data directory, there's nowhere to persist the secret to
+	if path == "" {
+		n.log.Warn("Generated ephemeral JWT secret", "secret", hexutil.Encode(secret))
+		return secret, nil
+	}
+	if err := ioutil.WriteFile(path, []byte(hexutil.Encode(secret)), 0600); err != nil {
+		return nil, err
+	}
+	n.log.Info("Generated JWT secret", "path", path)
+	return secret, nil
 }
 
 func (n *Node) wsServerForPort(port int) *httpServer {
@@ -398,6 +478,7 @@ func (n *Node) wsServerForPort(port int) *httpServer {
 func (n *Node) stopRPC() {
 	n.http.stop()
 	n.ws.stop()
+	n.httpAuth.stop()
 	n.ipc.stop()
 	n.stopInProc()
 }
@@ -537,6 +618,16 @@ func (n *Node) HTTPEndpoint() string {
 	return "http://" + n.http.listenAddr()
 }
 
+// HTTPAuthEndpoint returns the URL of the authenticated HTTP server.
+func (n *Node) HTTPAuthEndpoint() string {
+	return "http://" + n.httpAuth.listenAddr()
+}
+
+// WSAuthEndpoint returns the URL of the authenticated WebSocket server.
+func (n *Node) WSAuthEndpoint() string {
+	return "ws://" + n.httpAuth.listenAddr()
+}
+
 // WSEndpoint returns the current JSON-RPC over WebSocket endpoint.
 func (n *Node) WSEndpoint() string {
 	if n.http.wsAllowed() {
@@ -18,12 +18,16 @@ package node
 
 import (
 	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
 	"fmt"
 	"net/http"
 	"net/url"
 	"strconv"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/ethereum/go-ethereum/internal/testlog"
 	"github.com/ethereum/go-ethereum/log"
@@ -229,6 +233,65 @@ func Test_checkPath(t *testing.T) {
 	}
 }
 
+// TestJWT makes sure the JWT authentication is enforced on the http and ws
+// handlers when a secret is configured.
+func TestJWT(t *testing.T) {
+	secret := []byte("secret")
+	issue := func(secret []byte, alg string, iat int64) string {
+		header := base64.RawURLEncoding.EncodeToString([]byte(fmt.Sprintf(`{"alg":"%s","typ":"JWT"}`, alg)))
+		claims := base64.RawURLEncoding.EncodeToString([]byte(fmt.Sprintf(`{"iat":%d}`, iat)))
+
+		mac := hmac.New(sha256.New, secret)
+		mac.Write([]byte(header + "." + claims))
+		return header + "." + claims + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+	}
+	srv := createAndStartServer(t, &httpConfig{jwtSecret: secret}, true, &wsConfig{jwtSecret: secret})
+	defer srv.stop()
+
+	var (
+		httpURL = "http://" + srv.listenAddr()
+		wsURL   = "ws://" + srv.listenAddr()
+		now     = time.Now().Unix()
+	)
+	tests := []struct {
+		token string
+		ok    bool
+	}{
+		{issue(secret, "HS256", now), true},
+		{issue(secret, "HS256", now+30), true},
+		{"", false},
+		{"not.a.token", false},
+		{issue([]byte("other"), "HS256", now), false},
+		{issue(secret, "none", now), false},
+		{issue(secret, "HS256", now-120), false},
+		{issue(secret, "HS256", now+120), false},
+	}
+	for i, tt := range tests {
+		var headers []string
+		if tt.token != "" {
+			headers = []string{"Authorization", "Bearer " + tt.token}
+		}
+		want := http.StatusForbidden
+		if tt.ok {
+			want = http.StatusOK
+		}
+		if resp := rpcRequest(t, httpURL, headers...); resp.StatusCode != want {
+			t.Errorf("test %d: http status mismatch: have %d, want %d", i, resp.StatusCode, want)
+		}
+		header := make(http.Header)
+		if tt.token != "" {
+			header.Set("Authorization", "Bearer "+tt.token)
+		}
+		conn, _, err := websocket.DefaultDialer.Dial(wsURL, header)
+		if conn != nil {
+			conn.Close()
+		}
+		if (err == nil) != tt.ok {
+			t.Errorf("test %d: ws connection mismatch: have err %v, want ok %v", i, err, tt.ok)
+		}
+	}
+}
+
 func createAndStartServer(t *testing.T, conf *httpConfig, ws bool, wsConf *wsConfig) *httpServer {
 	t.Helper()
 
@@ -28,6 +28,7 @@ import (
 	"github.com/VictoriaMetrics/fastcache"
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/crypto"
 	"github.com/ethereum/go-ethereum/ethdb"
 	"github.com/ethereum/go-ethereum/log"
 	"github.com/ethereum/go-ethereum/metrics"
@@ -68,13 +69,16 @@ var (
 // servers even while the trie is executing expensive garbage collection.
 type Database struct {
 	diskdb ethdb.KeyValueStore // Persistent storage for matured trie nodes
+	scheme string              // Storage scheme of the trie nodes on disk
+	paths  *pathDatabase       // Backend of the path-based scheme, nil for the hash scheme
 
 	cleans  *fastcache.Cache            // GC friendly memory cache of clean node RLPs
 	dirties map[common.Hash]*cachedNode // Data and references relationships of dirty trie nodes
 	oldest  common.Hash                 // Oldest tracked node, flush-list head
 	newest  common.Hash                 // Newest tracked node, flush-list tail
 
-	preimages map[common.Hash][]byte // Preimages of nodes from the secure trie
+	preimages     map[common.Hash][]byte // Preimages of nodes from the secure trie
+	preimageStore *rawdb.PreimageStore   // Append-only store of the preimages, nil to keep them in the key-value store
 
 	gctime  time.Duration      // Time spent on garbage collection since last commit
 	gcnodes uint64             // Nodes garbage collected since last commit
@@ -277,6 +281,11 @@ type Config struct {
 	Cache     int    // Memory allowance (MB) to use for caching trie nodes in memory
 	Journal   string // Journal of clean cache to survive node restarts
 	Preimages bool   // Flag whether the preimage of trie key is recorded
+	Scheme    string // Storage scheme of the trie nodes, defaults to the one of the database
+
+	// PreimageStore is the append-only store to write the preimages of the trie
+	// keys into. If set, the preimages are always recorded.
+	PreimageStore *rawdb.PreimageStore
 }
 
 // NewDatabase creates a new trie database to store ephemeral trie content before
@@ -298,16 +307,33 @@ func NewDatabaseWithConfig(diskdb ethdb.KeyValueStore, config *Config) *Database
 			cleans = fastcache.LoadFromFileOrNew(config.Journal, config.Cache*1024*1024)
 		}
 	}
+	// Use the storage scheme the database was initialized with, unless it's a
+	// fresh one and the path scheme is explicitly requested.
+	scheme := rawdb.ReadTrieScheme(diskdb)
+	if scheme == "" {
+		scheme = rawdb.HashScheme
+		if config != nil && config.Scheme == rawdb.PathScheme {
+			rawdb.WriteTrieScheme(diskdb, rawdb.PathScheme)
+			scheme = rawdb.PathScheme
+		}
+	}
 	db := &Database{
 		diskdb: diskdb,
+		scheme: scheme,
 		cleans: cleans,
 		dirties: map[common.Hash]*cachedNode{{}: {
 			children: make(map[common.Hash]uint16),
 		}},
 	}
-	if config == nil || config.Preimages { // TODO(karalabe): Flip to default off in the future
+	if scheme == rawdb.PathScheme {
+		db.paths = newPathDatabase(diskdb, cleans)
+	}
+	if config == nil || config.Preimages || config.PreimageStore != nil { // TODO(karalabe): Flip to default off in the future
 		db.preimages = make(map[common.Hash][]byte)
 	}
+	if config != nil {
+		db.preimageStore = config.PreimageStore
+	}
 	return db
 }
 
@@ -316,11 +342,30 @@ func (db *Database) DiskDB() ethdb.KeyValueStore {
 	return db.diskdb
 }
 
+// Scheme returns the storage scheme of the trie nodes.
+func (db *Database) Scheme() string {
+	return db.scheme
+}
+
 // insert inserts a collapsed trie node into the memory database.
 // The blob size must be specified to allow proper size tracking.
 // All nodes inserted by this function will be reference tracked
 // and in theory should only used for **trie nodes** insertion.
-func (db *Database) insert(hash common.Hash, size int, node node) {
+//
+// The owner and path of the node are only tracked with the path scheme, which
+// also accepts nil nodes marking the node at the path as deleted.
+func (db *Database) insert(owner common.Hash, path []byte, hash common.Hash, size int, node node) {
+	if db.paths != nil {
+		var blob []byte
+		if node != nil {
+			blob = (&cachedNode{node: simplifyNode(node)}).rlp()
+		}
+		db.paths.insert(owner, path, hash, blob)
+		return
+	}
+	if node == nil {
+		return
+	}
 	// If the node's already cached, skip
 	if _, ok := db.dirties[hash]; ok {
 		return
@@ -368,8 +413,15 @@ func (db *Database) insertPreimage(hash common.Hash, preimage []byte) {
 }
 
 // node retrieves a cached trie node from memory, or returns nil if none can be
-// found in the memory cache.
-func (db *Database) node(hash common.Hash) node {
+// found in the memory cache. The owner and path of the node are only used by
+// the path scheme.
+func (db *Database) node(owner common.Hash, path []byte, hash common.Hash) node {
+	if db.paths != nil {
+		if enc := db.pathNode(owner, path, hash); enc != nil {
+			return mustDecodeNode(hash[:], enc)
+		}
+		return nil
+	}
 	// Retrieve the node from the clean cache if available
 	if db.cleans != nil {
 		if enc := db.cleans.Get(nil, hash[:]); enc != nil {
@@ -403,9 +455,54 @@ func (db *Database) node(hash common.Hash) node {
 	return mustDecodeNode(hash[:], enc)
 }
 
+// nodeBlob retrieves an encoded trie node by its owner, path and hash. The owner
+// and path are only used by the path scheme.
+func (db *Database) nodeBlob(owner common.Hash, path []byte, hash common.Hash) ([]byte, error) {
+	if db.paths == nil {
+		return db.Node(hash)
+	}
+	if enc := db.pathNode(owner, path, hash); enc != nil {
+		return enc, nil
+	}
+	return nil, errors.New("not found")
+}
+
+// pathNode retrieves an encoded trie node with the path scheme, looking at the
+// states in memory first, then at the clean cache and the disk. Since the nodes
+// at a path are overwritten by newer versions, the clean cache is keyed by owner
+// and path too, its entries being verified against the requested hash.
+func (db *Database) pathNode(owner common.Hash, path []byte, hash common.Hash) []byte {
+	if enc := db.paths.dirty(owner, path, hash); enc != nil {
+		memcacheDirtyHitMeter.Mark(1)
+		memcacheDirtyReadMeter.Mark(int64(len(enc)))
+		return enc
+	}
+	key := pathCacheKey(owner, path)
+	if db.cleans != nil {
+		if enc := db.cleans.Get(nil, key); enc != nil && crypto.Keccak256Hash(enc) == hash {
+			memcacheCleanHitMeter.Mark(1)
+			memcacheCleanReadMeter.Mark(int64(len(enc)))
+			return enc
+		}
+	}
+	enc := db.paths.disk(owner, path, hash)
+	if enc != nil && db.cleans != nil {
+		db.cleans.Set(key, enc)
+		memcacheCleanMissMeter.Mark(1)
+		memcacheCleanWriteMeter.Mark(int64(len(enc)))
+	}
+	return enc
+}
+
 // Node retrieves an encoded cached trie node from memory. If it cannot be found
 // cached, the method queries the persistent database for the content.
+//
+// With the path scheme, nodes can't be looked up by hash alone, so an error is
+// always returned.
 func (db *Database) Node(hash common.Hash) ([]byte, error) {
+	if db.paths != nil {
+		return nil, errors.New("not found")
+	}
 	// It doesn't make sense to retrieve the metaroot
 	if hash == (common.Hash{}) {
 		return nil, errors.New("not found")
@@ -443,6 +540,12 @@ func (db *Database) Node(hash common.Hash) ([]byte, error) {
 	return nil, errors.New("not found")
 }
 
+// Preimage retrieves the preimage of a hashed trie key, either from the memory
+// cache or from the persistent storage.
+func (db *Database) Preimage(hash common.Hash) []byte {
+	return db.preimage(hash)
+}
+
 // preimage retrieves a cached trie node pre-image from memory. If it cannot be
 // found cached, the method queries the persistent database for the content.
 func (db *Database) preimage(hash common.Hash) []byte {
@@ -458,6 +561,9 @@ func (db *Database) preimage(hash common.Hash) []byte {
 	if preimage != nil {
 		return preimage
 	}
+	if db.preimageStore != nil {
+		return db.preimageStore.Preimage(hash)
+	}
 	return rawdb.ReadPreimage(db.diskdb, hash)
 }
 
@@ -465,6 +571,9 @@ func (db *Database) preimage(hash common.Hash) []byte {
 // This method is extremely expensive and should only be used to validate internal
 // states in test code.
 func (db *Database) Nodes() []common.Hash {
+	if db.paths != nil {
+		return db.paths.hashes()
+	}
 	db.lock.RLock()
 	defer db.lock.RUnlock()
 
@@ -482,6 +591,9 @@ func (db *Database) Nodes() []common.Hash {
 // and external node(e.g. storage trie root), all internal trie nodes
 // are referenced together by database itself.
 func (db *Database) Reference(child common.Hash, parent common.Hash) {
+	if db.paths != nil {
+		return // Nodes are not reference counted in the path scheme
+	}
 	db.lock.Lock()
 	defer db.lock.Unlock()
 
@@ -516,6 +628,9 @@ func (db *Database) Dereference(root common.Hash) {
 		log.Error("Attempted to dereference the trie cache meta root")
 		return
 	}
+	if db.paths != nil {
+		return // Nodes are not reference counted in the path scheme
+	}
 	db.lock.Lock()
 	defer db.lock.Unlock()
 
@@ -589,7 +704,18 @@ func (db *Database) dereference(child common.Hash, parent common.Hash) {
 //
 // Note, this method is a non-synchronized mutator. It is unsafe to call this
 // concurrently with other mutators.
+//
+// With the path scheme, whole state transitions are flattened into disk, oldest
+// first, as stale nodes can't be garbage collected from memory.
 func (db *Database) Cap(limit common.StorageSize) error {
+	if db.paths != nil {
+		if db.preimagesSize > 4*1024*1024 {
+			if err := db.flushPreimages(); err != nil {
+				return err
+			}
+		}
+		return db.paths.cap(limit)
+	}
 	// Create a database batch to flush persistent data out. It is important that
 	// outside code doesn't see an inconsistent state (referenced data removed from
 	// memory cache during commit but not yet in persistent storage). This is ensured
@@ -610,7 +736,9 @@ func (db *Database) Cap(limit common.StorageSize) error {
 		if db.preimages == nil {
 			log.Error("Attempted to write preimages whilst disabled")
 		} else {
-			rawdb.WritePreimages(batch, db.preimages)
+			if err := db.writePreimages(batch); err != nil {
+				return err
+			}
 			if batch.ValueSize() > ethdb.IdealBatchSize {
 				if err := batch.Write(); err != nil {
 					return err
@@ -692,7 +820,16 @@ func (db *Database) Cap(limit common.StorageSize) error {
 //
 // Note, this method is a non-synchronized mutator. It is unsafe to call this
 // concurrently with other mutators.
+//
+// With the path scheme, all the state transitions leading to the given root are
+// flattened into disk, discarding any other states in memory not built on top.
 func (db *Database) Commit(node common.Hash, report bool, callback func(common.Hash)) error {
+	if db.paths != nil {
+		if err := db.flushPreimages(); err != nil {
+			return err
+		}
+		return db.paths.commit(node, report, callback)
+	}
 	// Create a database batch to flush persistent data out. It is important that
 	// outside code doesn't see an inconsistent state (referenced data removed from
 	// memory cache during commit but not yet in persistent storage). This is ensured
@@ -702,7 +839,9 @@ func (db *Database) Commit(node common.Hash, report bool, callback func(common.H
 
 	// Move all of the accumulated preimages into a write batch
 	if db.preimages != nil {
-		rawdb.WritePreimages(batch, db.preimages)
+		if err := db.writePreimages(batch); err != nil {
+			return err
+		}
 		// Since we're going to replay trie node writes into the clean cache, flush out
 		// any batched pre-images before continuing.
 		if err := batch.Write(); err != nil {
@@ -752,6 +891,62 @@ func (db *Database) Commit(node common.Hash, report bool, callback func(common.H
 	return nil
 }
 
+// writePreimages writes all the accumulated preimages into the preimage store if
+// there's one, or into the given batch otherwise.
+func (db *Database) writePreimages(batch ethdb.KeyValueWriter) error {
+	if db.preimageStore != nil {
+		return db.preimageStore.Write(db.preimages)
+	}
+	rawdb.WritePreimages(batch, db.preimages)
+	return nil
+}
+
+// flushPreimages writes all the accumulated preimages to disk.
+func (db *Database) flushPreimages() error {
+	if db.preimages == nil {
+		return nil
+	}
+	batch := db.diskdb.NewBatch()
+	if err := db.writePreimages(batch); err != nil {
+		return err
+	}
+	if err := batch.Write(); err != nil {
+		return err
+	}
+	db.lock.Lock()
+	db.preimages, db.preimagesSize = make(map[common.Hash][]byte), 0
+	db.lock.Unlock()
+	return nil
+}
+
+// Update attaches all the trie nodes committed since the last update to the
+// state with the given root, derived from the parent state. It's a noop for
+// the hash scheme, where the nodes are reference counted instead.
+func (db *Database) Update(root common.Hash, parent common.Hash) error {
+	if db.paths == nil {
+		return nil
+	}
+	return db.paths.update(root, parent)
+}
+
+// Recoverable returns whether the persisted state can be rolled back to the
+// given root. It's always false for the hash scheme.
+func (db *Database) Recoverable(root common.Hash) bool {
+	if db.paths == nil {
+		return false
+	}
+	return db.paths.recoverable(root)
+}
+
+// Recover rolls the persisted state back to the given root using the reverse
+// diffs stored by the path scheme, discarding all states tracked in memory.
+func (db *Database) Recover(root common.Hash) error {
+	if db.paths == nil {
+		return errors.New("state recovery not supported by hash scheme")
+	}
+	return db.paths.recover(root)
+}
+
 // commit is the private locked version of Commit.
 func (db *Database) commit(hash common.Hash, batch ethdb.Batch, uncacher *cleaner, callback func(common.Hash)) error {
 	// If the node does not exist, it's a previously committed node
@@ -837,6 +1032,13 @@ func (c *cleaner) Delete(key []byte) error {
 // Size returns the current storage size of the memory cache in front of the
 // persistent database layer.
 func (db *Database) Size() (common.StorageSize, common.StorageSize) {
+	if db.paths != nil {
+		size := db.paths.size()
+
+		db.lock.RLock()
+		defer db.lock.RUnlock()
+		return size, db.preimagesSize
+	}
 	db.lock.RLock()
 	defer db.lock.RUnlock()
 
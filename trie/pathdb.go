@@ -0,0 +1,499 @@
+// Copyright 2021 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package trie
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/VictoriaMetrics/fastcache"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/rlp"
+)
+
+// defaultReverseDiffLimit is the number of reverse diffs retained on disk, i.e.
+// how many blocks the persisted state can be rolled back by.
+const defaultReverseDiffLimit = 128
+
+var (
+	// errStateUnknown is returned if a state transition is based on a root which
+	// is neither tracked in memory nor persisted.
+	errStateUnknown = errors.New("unknown state")
+
+	// errStateUnrecoverable is returned if the persisted state cannot be rolled
+	// back to the requested root, since the reverse diffs are not available.
+	errStateUnrecoverable = errors.New("state not recoverable")
+)
+
+// pathNode is a trie node tracked by the path-based storage scheme.
+type pathNode struct {
+	hash common.Hash // Hash of the node, empty for deleted nodes
+	blob []byte      // RLP encoded node, nil for deleted nodes
+}
+
+// pathNodeSet is a collection of trie nodes grouped by owner and path.
+type pathNodeSet map[common.Hash]map[string]*pathNode
+
+// size returns the approximate memory used by the nodes of the set.
+func (set pathNodeSet) size() common.StorageSize {
+	var size common.StorageSize
+	for _, nodes := range set {
+		size += common.HashLength
+		for path, n := range nodes {
+			size += common.StorageSize(len(path) + common.HashLength + len(n.blob))
+		}
+	}
+	return size
+}
+
+// pathLayer is the set of trie nodes written by a single state transition, kept
+// in memory on top of the persisted state until it's flattened into disk.
+type pathLayer struct {
+	root   common.Hash        // Root hash of the state after the transition
+	parent common.Hash        // Root hash of the state before the transition
+	nodes  pathNodeSet        // Trie nodes written by the transition
+	size   common.StorageSize // Approximate memory used by the nodes
+}
+
+// reverseDiffNode is the previous version of a trie node overwritten, or created,
+// by a state transition.
+type reverseDiffNode struct {
+	Owner common.Hash
+	Path  []byte
+	Blob  []byte // Previous node blob, empty if the node didn't exist
+}
+
+// reverseDiff is the persisted undo log of a single state transition, allowing
+// the state on disk to be rolled back to its parent.
+type reverseDiff struct {
+	Parent common.Hash       // Root hash of the state the diff reverts to
+	Root   common.Hash       // Root hash of the state the diff reverts from
+	Nodes  []reverseDiffNode // Previous versions of the nodes touched
+}
+
+// pathDatabase is the backend of the path-based trie node storage scheme. The
+// nodes are stored on disk keyed by owner and path, so the disk only contains a
+// single version of the state. Recent states are kept in memory as per state
+// transition layers on top of the persisted one, and flattened into disk once
+// they fall out of the retention window, saving a reverse diff of each one to
+// allow rolling the persisted state back.
+//
+// Since the nodes are still referenced by hash from their parents, lookups are
+// verified against the expected hash. This allows resolving nodes of any of the
+// tracked states without knowing which one they belong to.
+//
+// As nodes can't be looked up by hash alone, and historical states are gone
+// once overwritten, the scheme doesn't support serving or syncing state over
+// the network, nor pruning. The storage of destructed accounts is not wiped
+// either, the stale nodes are simply unreachable.
+type pathDatabase struct {
+	diskdb   ethdb.KeyValueStore        // Persistent storage for the flattened state
+	cleans   *fastcache.Cache           // Clean node cache keyed by path, kept in sync with the disk
+	diskRoot common.Hash                // Root hash of the persisted state
+	diskID   uint64                     // Id of the persisted state (and its reverse diff)
+	layers   map[common.Hash]*pathLayer // In-memory state transitions on top of the disk
+	newest   common.Hash                // Most recently added layer
+	pending  pathNodeSet                // Nodes committed, but not yet attached to a state
+	limit    uint64                     // Number of reverse diffs to retain on disk
+
+	lock sync.RWMutex
+}
+
+// newPathDatabase creates the path-based node storage on top of a database,
+// loading the root of the persisted state.
+func newPathDatabase(diskdb ethdb.KeyValueStore, cleans *fastcache.Cache) *pathDatabase {
+	db := &pathDatabase{
+		diskdb:   diskdb,
+		cleans:   cleans,
+		diskRoot: emptyRoot,
+		diskID:   rawdb.ReadReverseDiffHead(diskdb),
+		layers:   make(map[common.Hash]*pathLayer),
+		pending:  make(pathNodeSet),
+		limit:    defaultReverseDiffLimit,
+	}
+	if blob := rawdb.ReadAccountTrieNode(diskdb, nil); len(blob) > 0 {
+		db.diskRoot = crypto.Keccak256Hash(blob)
+	}
+	return db
+}
+
+// insert tracks a node committed by a trie, to be attached to the next state
+// update. A nil blob marks the node at the path as deleted.
+func (db *pathDatabase) insert(owner common.Hash, path []byte, hash common.Hash, blob []byte) {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	nodes, ok := db.pending[owner]
+	if !ok {
+		nodes = make(map[string]*pathNode)
+		db.pending[owner] = nodes
+	}
+	nodes[string(path)] = &pathNode{hash: hash, blob: blob}
+}
+
+// dirty retrieves the blob of the trie node with the given owner, path and hash
+// from the states tracked in memory, or nil if it's not available.
+func (db *pathDatabase) dirty(owner common.Hash, path []byte, hash common.Hash) []byte {
+	db.lock.RLock()
+	defer db.lock.RUnlock()
+
+	if n := db.pending[owner][string(path)]; n != nil && n.hash == hash {
+		return n.blob
+	}
+	for _, layer := range db.layers {
+		if n := layer.nodes[owner][string(path)]; n != nil && n.hash == hash {
+			return n.blob
+		}
+	}
+	return nil
+}
+
+// disk retrieves the blob of the trie node with the given owner, path and hash
+// from the persisted state, or nil if the node on disk is a different version.
+func (db *pathDatabase) disk(owner common.Hash, path []byte, hash common.Hash) []byte {
+	var blob []byte
+	if owner == (common.Hash{}) {
+		blob = rawdb.ReadAccountTrieNode(db.diskdb, path)
+	} else {
+		blob = rawdb.ReadStorageTrieNode(db.diskdb, owner, path)
+	}
+	if len(blob) == 0 || crypto.Keccak256Hash(blob) != hash {
+		return nil
+	}
+	return blob
+}
+
+// hashes returns the hashes of all nodes tracked in memory.
+func (db *pathDatabase) hashes() []common.Hash {
+	db.lock.RLock()
+	defer db.lock.RUnlock()
+
+	var hashes []common.Hash
+	collect := func(set pathNodeSet) {
+		for _, nodes := range set {
+			for _, n := range nodes {
+				if n.blob != nil {
+					hashes = append(hashes, n.hash)
+				}
+			}
+		}
+	}
+	collect(db.pending)
+	for _, layer := range db.layers {
+		collect(layer.nodes)
+	}
+	return hashes
+}
+
+// size returns the approximate memory used by the in-memory layers.
+func (db *pathDatabase) size() common.StorageSize {
+	db.lock.RLock()
+	defer db.lock.RUnlock()
+
+	size := db.pending.size()
+	for _, layer := range db.layers {
+		size += layer.size
+	}
+	return size
+}
+
+// update attaches all the pending nodes to a new in-memory layer, representing
+// the transition from the parent state to the given root.
+func (db *pathDatabase) update(root common.Hash, parent common.Hash) error {
+	if root == (common.Hash{}) {
+		root = emptyRoot
+	}
+	if parent == (common.Hash{}) {
+		parent = emptyRoot
+	}
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	nodes := db.pending
+	db.pending = make(pathNodeSet)
+
+	// If the state is already known, the nodes are identical to the tracked ones
+	if root == parent || root == db.diskRoot || db.layers[root] != nil {
+		return nil
+	}
+	if parent != db.diskRoot && db.layers[parent] == nil {
+		return fmt.Errorf("%w: parent %x", errStateUnknown, parent)
+	}
+	db.layers[root] = &pathLayer{
+		root:   root,
+		parent: parent,
+		nodes:  nodes,
+		size:   nodes.size(),
+	}
+	db.newest = root
+	return nil
+}
+
+// bottom returns the layer right above the disk on the ancestry of the given
+// root, or nil if the root is not tracked in memory.
+func (db *pathDatabase) bottom(root common.Hash) *pathLayer {
+	layer := db.layers[root]
+	for layer != nil && layer.parent != db.diskRoot {
+		layer = db.layers[layer.parent]
+	}
+	return layer
+}
+
+// cap flattens the oldest layers on the ancestry of the most recent state into
+// disk, until the memory used by the remaining ones drops below the limit.
+func (db *pathDatabase) cap(limit common.StorageSize) error {
+	for db.size() > limit {
+		db.lock.RLock()
+		layer := db.bottom(db.newest)
+		if layer == nil {
+			// The newest layer is gone (discarded fork), pick any on top of the disk
+			for _, l := range db.layers {
+				if l.parent == db.diskRoot {
+					layer = l
+					break
+				}
+			}
+		}
+		db.lock.RUnlock()
+
+		if layer == nil {
+			return nil // Only pending nodes left
+		}
+		if err := db.flatten(layer); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// commit flattens all the layers up to and including the given state into disk.
+func (db *pathDatabase) commit(root common.Hash, report bool, callback func(common.Hash)) error {
+	if root == (common.Hash{}) {
+		root = emptyRoot
+	}
+	var (
+		start  = time.Now()
+		layers int
+		nodes  int
+		size   common.StorageSize
+	)
+	for {
+		db.lock.RLock()
+		if root == db.diskRoot {
+			db.lock.RUnlock()
+			break
+		}
+		layer := db.bottom(root)
+		db.lock.RUnlock()
+
+		if layer == nil {
+			// Same as the hash scheme, states not tracked in memory are deemed to
+			// be persisted already (or overwritten by a later one)
+			log.Debug("Skipping commit of untracked state", "root", root)
+			break
+		}
+		if callback != nil {
+			for _, set := range layer.nodes {
+				for _, n := range set {
+					if n.blob != nil {
+						callback(n.hash)
+					}
+				}
+			}
+		}
+		for _, set := range layer.nodes {
+			nodes += len(set)
+		}
+		layers, size = layers+1, size+layer.size
+
+		if err := db.flatten(layer); err != nil {
+			return err
+		}
+	}
+	logger := log.Info
+	if !report {
+		logger = log.Debug
+	}
+	logger("Persisted trie from memory database", "layers", layers, "nodes", nodes, "size", size, "time", time.Since(start), "livesize", db.size())
+	return nil
+}
+
+// flatten writes the nodes of a layer right above the disk into the database,
+// together with the reverse diff undoing it. All other layers on top of the
+// previously persisted state are discarded, as they become unreachable.
+func (db *pathDatabase) flatten(layer *pathLayer) error {
+	var (
+		batch = db.diskdb.NewBatch()
+		diff  = &reverseDiff{Parent: layer.parent, Root: layer.root}
+	)
+	for owner, nodes := range layer.nodes {
+		for path, n := range nodes {
+			var prev []byte
+			if owner == (common.Hash{}) {
+				prev = rawdb.ReadAccountTrieNode(db.diskdb, []byte(path))
+			} else {
+				prev = rawdb.ReadStorageTrieNode(db.diskdb, owner, []byte(path))
+			}
+			diff.Nodes = append(diff.Nodes, reverseDiffNode{Owner: owner, Path: []byte(path), Blob: prev})
+			db.writeNode(batch, owner, []byte(path), n.blob)
+		}
+	}
+	enc, err := rlp.EncodeToBytes(diff)
+	if err != nil {
+		return err
+	}
+	id := db.diskID + 1
+	rawdb.WriteReverseDiff(batch, id, enc)
+	rawdb.WriteReverseDiffHead(batch, id)
+	if id > db.limit {
+		rawdb.DeleteReverseDiff(batch, id-db.limit)
+	}
+	if err := batch.Write(); err != nil {
+		return err
+	}
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	db.diskRoot, db.diskID = layer.root, id
+	delete(db.layers, layer.root)
+
+	// Drop all the layers which were not built on top of the new disk state
+	for {
+		var dropped bool
+		for root, l := range db.layers {
+			if l.parent != db.diskRoot && db.layers[l.parent] == nil {
+				delete(db.layers, root)
+				dropped = true
+			}
+		}
+		if !dropped {
+			break
+		}
+	}
+	return nil
+}
+
+// recoverable returns whether the persisted state can be rolled back to the
+// given root using the retained reverse diffs.
+func (db *pathDatabase) recoverable(root common.Hash) bool {
+	db.lock.RLock()
+	defer db.lock.RUnlock()
+
+	if root == db.diskRoot {
+		return true
+	}
+	for id := db.diskID; id > 0; id-- {
+		diff, err := readReverseDiff(db.diskdb, id)
+		if err != nil {
+			return false
+		}
+		if diff.Parent == root {
+			return true
+		}
+	}
+	return false
+}
+
+// recover rolls the persisted state back to the given root by applying the
+// reverse diffs. All in-memory layers are discarded.
+func (db *pathDatabase) recover(root common.Hash) error {
+	if !db.recoverable(root) {
+		return fmt.Errorf("%w: %x", errStateUnrecoverable, root)
+	}
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	db.layers, db.newest = make(map[common.Hash]*pathLayer), common.Hash{}
+	db.pending = make(pathNodeSet)
+
+	start := time.Now()
+	for db.diskRoot != root {
+		diff, err := readReverseDiff(db.diskdb, db.diskID)
+		if err != nil {
+			return err
+		}
+		if diff.Root != db.diskRoot {
+			return fmt.Errorf("reverse diff %d mismatch: have %x, want %x", db.diskID, diff.Root, db.diskRoot)
+		}
+		batch := db.diskdb.NewBatch()
+		for _, n := range diff.Nodes {
+			db.writeNode(batch, n.Owner, n.Path, n.Blob)
+		}
+		rawdb.DeleteReverseDiff(batch, db.diskID)
+		rawdb.WriteReverseDiffHead(batch, db.diskID-1)
+		if err := batch.Write(); err != nil {
+			return err
+		}
+		db.diskRoot, db.diskID = diff.Parent, db.diskID-1
+	}
+	log.Info("Rolled back persisted state", "root", root, "elapsed", common.PrettyDuration(time.Since(start)))
+	return nil
+}
+
+// readReverseDiff loads and decodes the reverse diff with the given id.
+func readReverseDiff(db ethdb.KeyValueReader, id uint64) (*reverseDiff, error) {
+	blob := rawdb.ReadReverseDiff(db, id)
+	if len(blob) == 0 {
+		return nil, fmt.Errorf("reverse diff %d not found", id)
+	}
+	var diff reverseDiff
+	if err := rlp.DecodeBytes(blob, &diff); err != nil {
+		return nil, err
+	}
+	return &diff, nil
+}
+
+// writeNode writes a trie node into the database batch under its owner and path,
+// or deletes the node at the path if the blob is empty. The clean cache is
+// updated right away, as the overwritten version must not be served anymore.
+func (db *pathDatabase) writeNode(batch ethdb.KeyValueWriter, owner common.Hash, path []byte, blob []byte) {
+	if db.cleans != nil {
+		if len(blob) == 0 {
+			db.cleans.Del(pathCacheKey(owner, path))
+		} else {
+			db.cleans.Set(pathCacheKey(owner, path), blob)
+		}
+	}
+	writePathNode(batch, owner, path, blob)
+}
+
+// pathCacheKey returns the key of a trie node in the clean cache with the path
+// scheme, being the concatenation of its owner and path.
+func pathCacheKey(owner common.Hash, path []byte) []byte {
+	return append(owner.Bytes(), path...)
+}
+
+// writePathNode writes a trie node into the database under its owner and path,
+// or deletes the node at the path if the blob is empty.
+func writePathNode(db ethdb.KeyValueWriter, owner common.Hash, path []byte, blob []byte) {
+	switch {
+	case owner == (common.Hash{}) && len(blob) == 0:
+		rawdb.DeleteAccountTrieNode(db, path)
+	case owner == (common.Hash{}):
+		rawdb.WriteAccountTrieNode(db, path, blob)
+	case len(blob) == 0:
+		rawdb.DeleteStorageTrieNode(db, owner, path)
+	default:
+		rawdb.WriteStorageTrieNode(db, owner, path, blob)
+	}
+}
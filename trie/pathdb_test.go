@@ -0,0 +1,304 @@
+// Copyright 2021 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package trie
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/ethereum/go-ethereum/ethdb/memorydb"
+)
+
+// pathTestState is the content of a test state, consisting of an account trie
+// and a single storage trie.
+type pathTestState struct {
+	root     common.Hash
+	accounts map[string]string
+	storage  map[string]string
+}
+
+// pathTestOwner is the owner of the storage trie in the test states.
+var pathTestOwner = common.HexToHash("0xdeadbeef")
+
+// randomPathKey generates a key which is likely to share a prefix with others,
+// with short keys yielding embedded nodes.
+func randomPathKey(rng *rand.Rand) string {
+	key := make([]byte, 1+rng.Intn(4))
+	for i := range key {
+		key[i] = byte(rng.Intn(4)) << 4
+	}
+	return string(key)
+}
+
+// mutatePathTrie applies a batch of random inserts and deletions to a trie,
+// tracking them in the given content map.
+func mutatePathTrie(rng *rand.Rand, trie *Trie, content map[string]string) {
+	for i := 0; i < 16; i++ {
+		key := randomPathKey(rng)
+		if _, ok := content[key]; ok && rng.Intn(2) == 0 {
+			trie.Delete([]byte(key))
+			delete(content, key)
+			continue
+		}
+		val := fmt.Sprintf("v%d", rng.Intn(1000))
+		if rng.Intn(4) == 0 {
+			val = string(bytes.Repeat([]byte(val), 10))
+		}
+		trie.Update([]byte(key), []byte(val))
+		content[key] = val
+	}
+}
+
+// makePathStates creates a chain of test states on top of each other, each one
+// attached to the database as a separate state transition.
+func makePathStates(t *testing.T, db *Database, n int) []*pathTestState {
+	var (
+		rng    = rand.New(rand.NewSource(1))
+		states []*pathTestState
+		parent = &pathTestState{root: emptyRoot, accounts: make(map[string]string), storage: make(map[string]string)}
+		stroot = emptyRoot
+	)
+	for i := 0; i < n; i++ {
+		state := &pathTestState{accounts: make(map[string]string), storage: make(map[string]string)}
+		for k, v := range parent.accounts {
+			state.accounts[k] = v
+		}
+		for k, v := range parent.storage {
+			state.storage[k] = v
+		}
+		storage, err := NewWithOwner(pathTestOwner, stroot, db)
+		if err != nil {
+			t.Fatalf("state %d: failed to open storage trie: %v", i, err)
+		}
+		mutatePathTrie(rng, storage, state.storage)
+		if stroot, _, err = storage.Commit(nil); err != nil {
+			t.Fatalf("state %d: failed to commit storage trie: %v", i, err)
+		}
+		accounts, err := New(parent.root, db)
+		if err != nil {
+			t.Fatalf("state %d: failed to open account trie: %v", i, err)
+		}
+		mutatePathTrie(rng, accounts, state.accounts)
+
+		// Link the storage root into the state to keep the roots unique
+		accounts.Update([]byte("storage-root"), stroot[:])
+		state.accounts["storage-root"] = string(stroot[:])
+
+		if state.root, _, err = accounts.Commit(nil); err != nil {
+			t.Fatalf("state %d: failed to commit account trie: %v", i, err)
+		}
+		if err := db.Update(state.root, parent.root); err != nil {
+			t.Fatalf("state %d: failed to update database: %v", i, err)
+		}
+		states = append(states, state)
+		parent = state
+	}
+	return states
+}
+
+// checkPathState verifies that the given state can be fully read from the
+// database, returning the number of hashed account and storage nodes in it.
+func checkPathState(t *testing.T, db *Database, state *pathTestState) (int, int) {
+	t.Helper()
+
+	check := func(trie *Trie, content map[string]string) int {
+		var (
+			nodes int
+			found = make(map[string]string)
+			it    = trie.NodeIterator(nil)
+		)
+		for it.Next(true) {
+			if it.Hash() != (common.Hash{}) {
+				nodes++
+			}
+			if it.Leaf() {
+				found[string(it.LeafKey())] = string(it.LeafBlob())
+			}
+		}
+		if it.Error() != nil {
+			t.Fatalf("state %x: failed to iterate trie: %v", state.root, it.Error())
+		}
+		if len(found) != len(content) {
+			t.Fatalf("state %x: entry count mismatch: have %d, want %d", state.root, len(found), len(content))
+		}
+		for k, v := range content {
+			if found[k] != v {
+				t.Fatalf("state %x: entry %x mismatch: have %x, want %x", state.root, k, found[k], v)
+			}
+		}
+		return nodes
+	}
+	accounts, err := New(state.root, db)
+	if err != nil {
+		t.Fatalf("state %x: failed to open account trie: %v", state.root, err)
+	}
+	storage, err := NewWithOwner(pathTestOwner, common.BytesToHash([]byte(state.accounts["storage-root"])), db)
+	if err != nil {
+		t.Fatalf("state %x: failed to open storage trie: %v", state.root, err)
+	}
+	return check(accounts, state.accounts), check(storage, state.storage)
+}
+
+// checkPathDisk verifies that the persisted state matches the given one exactly,
+// without any stale nodes left around on disk.
+func checkPathDisk(t *testing.T, diskdb ethdb.Database, state *pathTestState) {
+	t.Helper()
+
+	accountNodes, storageNodes := checkPathState(t, NewDatabase(diskdb), state)
+
+	var accounts, storage int
+	it := diskdb.NewIterator(nil, nil)
+	defer it.Release()
+	for it.Next() {
+		if ok, _ := rawdb.IsAccountTrieNode(it.Key()); ok {
+			accounts++
+		}
+		if ok, owner, _ := rawdb.IsStorageTrieNode(it.Key()); ok && owner == pathTestOwner {
+			storage++
+		}
+	}
+	if accounts != accountNodes {
+		t.Errorf("state %x: account node count mismatch: have %d, want %d", state.root, accounts, accountNodes)
+	}
+	if storage != storageNodes {
+		t.Errorf("state %x: storage node count mismatch: have %d, want %d", state.root, storage, storageNodes)
+	}
+}
+
+// Tests that the path scheme tracks the recent states in memory and persists
+// them on commit, overwriting the stale nodes of the parent states.
+func TestPathDatabaseCommit(t *testing.T) {
+	diskdb := rawdb.NewMemoryDatabase()
+	db := NewDatabaseWithConfig(diskdb, &Config{Cache: 16, Scheme: rawdb.PathScheme})
+	if scheme := rawdb.ReadTrieScheme(diskdb); scheme != rawdb.PathScheme {
+		t.Fatalf("scheme marker mismatch: have %q, want %q", scheme, rawdb.PathScheme)
+	}
+	states := makePathStates(t, db, 32)
+
+	// All the states should be available from memory
+	for _, state := range states {
+		checkPathState(t, db, state)
+	}
+	// Persist the states in two steps, checking the disk content each time
+	if err := db.Commit(states[15].root, false, nil); err != nil {
+		t.Fatalf("failed to commit state: %v", err)
+	}
+	checkPathDisk(t, diskdb, states[15])
+	for _, state := range states[15:] {
+		checkPathState(t, db, state)
+	}
+	if err := db.Commit(states[31].root, false, nil); err != nil {
+		t.Fatalf("failed to commit state: %v", err)
+	}
+	checkPathDisk(t, diskdb, states[31])
+
+	// Overwritten states must be gone, even if cached before
+	if _, err := New(states[15].root, db); err == nil {
+		t.Errorf("stale state still available")
+	}
+	if size, _ := db.Size(); size != 0 {
+		t.Errorf("dangling nodes after full commit: %v", size)
+	}
+	if head := rawdb.ReadReverseDiffHead(diskdb); head != 32 {
+		t.Errorf("reverse diff head mismatch: have %d, want %d", head, 32)
+	}
+	// A reopened database should continue from the persisted state
+	checkPathState(t, NewDatabase(diskdb), states[31])
+}
+
+// Tests that capping the path scheme flattens the oldest states into disk.
+func TestPathDatabaseCap(t *testing.T) {
+	diskdb := rawdb.NewMemoryDatabase()
+	db := NewDatabaseWithConfig(diskdb, &Config{Scheme: rawdb.PathScheme})
+	states := makePathStates(t, db, 32)
+
+	size, _ := db.Size()
+	if err := db.Cap(size / 2); err != nil {
+		t.Fatalf("failed to cap database: %v", err)
+	}
+	if capped, _ := db.Size(); capped > size/2 {
+		t.Fatalf("database not capped: have %v, want <= %v", capped, size/2)
+	}
+	head := rawdb.ReadReverseDiffHead(diskdb)
+	if head == 0 || head >= 32 {
+		t.Fatalf("unexpected number of flattened states: %d", head)
+	}
+	checkPathDisk(t, diskdb, states[head-1])
+	for _, state := range states[head-1:] {
+		checkPathState(t, db, state)
+	}
+}
+
+// Tests that the persisted state can be rolled back using the reverse diffs.
+func TestPathDatabaseRecover(t *testing.T) {
+	diskdb := rawdb.NewMemoryDatabase()
+	db := NewDatabaseWithConfig(diskdb, &Config{Cache: 16, Scheme: rawdb.PathScheme})
+	states := makePathStates(t, db, 32)
+
+	if err := db.Commit(states[31].root, false, nil); err != nil {
+		t.Fatalf("failed to commit state: %v", err)
+	}
+	if db.Recoverable(common.HexToHash("0x01")) {
+		t.Fatalf("unknown state reported recoverable")
+	}
+	for _, i := range []int{30, 20, 0} {
+		if !db.Recoverable(states[i].root) {
+			t.Fatalf("state %d not recoverable", i)
+		}
+		if err := db.Recover(states[i].root); err != nil {
+			t.Fatalf("failed to recover state %d: %v", i, err)
+		}
+		checkPathDisk(t, diskdb, states[i])
+		checkPathState(t, db, states[i])
+
+		if head := rawdb.ReadReverseDiffHead(diskdb); head != uint64(i+1) {
+			t.Fatalf("reverse diff head mismatch: have %d, want %d", head, i+1)
+		}
+	}
+	// Rolling back beyond the first state should empty the database
+	if err := db.Recover(emptyRoot); err != nil {
+		t.Fatalf("failed to recover empty state: %v", err)
+	}
+	if blob := rawdb.ReadAccountTrieNode(diskdb, nil); len(blob) != 0 {
+		t.Fatalf("account trie root left after full rollback")
+	}
+	if db.Recoverable(states[31].root) {
+		t.Fatalf("rolled back state reported recoverable")
+	}
+}
+
+// Tests that the scheme of an initialized database can't be changed.
+func TestPathDatabaseScheme(t *testing.T) {
+	diskdb := memorydb.New()
+	if scheme := NewDatabase(diskdb).Scheme(); scheme != rawdb.HashScheme {
+		t.Fatalf("default scheme mismatch: have %q, want %q", scheme, rawdb.HashScheme)
+	}
+	if scheme := NewDatabaseWithConfig(diskdb, &Config{Scheme: rawdb.PathScheme}).Scheme(); scheme != rawdb.PathScheme {
+		t.Fatalf("requested scheme mismatch: have %q, want %q", scheme, rawdb.PathScheme)
+	}
+	if scheme := NewDatabase(diskdb).Scheme(); scheme != rawdb.PathScheme {
+		t.Fatalf("stored scheme mismatch: have %q, want %q", scheme, rawdb.PathScheme)
+	}
+	if _, err := rawdb.ParseStateScheme(rawdb.HashScheme, rawdb.NewDatabase(diskdb)); err == nil {
+		t.Fatalf("incompatible scheme accepted")
+	}
+}
@@ -22,6 +22,7 @@ import (
 	"fmt"
 
 	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
 	"github.com/ethereum/go-ethereum/ethdb"
 	"github.com/ethereum/go-ethereum/ethdb/memorydb"
 	"github.com/ethereum/go-ethereum/log"
@@ -38,8 +39,11 @@ import (
 func (t *Trie) Prove(key []byte, fromLevel uint, proofDb ethdb.KeyValueWriter) error {
 	// Collect all nodes on the path to key.
 	key = keybytesToHex(key)
-	var nodes []node
-	tn := t.root
+	var (
+		nodes []node
+		tn    = t.root
+		hex   = key
+	)
 	for len(key) > 0 && tn != nil {
 		switch n := tn.(type) {
 		case *shortNode:
@@ -57,7 +61,7 @@ func (t *Trie) Prove(key []byte, fromLevel uint, proofDb ethdb.KeyValueWriter) e
 			nodes = append(nodes, n)
 		case hashNode:
 			var err error
-			tn, err = t.resolveHash(n, nil)
+			tn, err = t.resolveHash(n, hex[:len(hex)-len(key)])
 			if err != nil {
 				log.Error(fmt.Sprintf("Unhandled trie error: %v", err))
 				return err
@@ -129,6 +133,107 @@ func VerifyProof(rootHash common.Hash, key []byte, proofDb ethdb.KeyValueReader)
 	}
 }
 
+// ProveMulti constructs a merkle multiproof for a set of keys. The result contains
+// all encoded nodes on the paths to the values at the keys, the nodes shared by
+// multiple paths included only once, in the order of their first appearance when
+// proving the keys one by one.
+//
+// The absence of keys is proven the same way as by Prove.
+func (t *Trie) ProveMulti(keys [][]byte, fromLevel uint) ([][]byte, error) {
+	proofs := make([][][]byte, len(keys))
+	for i, key := range keys {
+		var proof proofList
+		if err := t.Prove(key, fromLevel, &proof); err != nil {
+			return nil, err
+		}
+		proofs[i] = proof
+	}
+	return MergeProofs(proofs...), nil
+}
+
+// ProveMulti constructs a merkle multiproof for a set of keys, see Trie.ProveMulti
+// for the details.
+func (t *SecureTrie) ProveMulti(keys [][]byte, fromLevel uint) ([][]byte, error) {
+	return t.trie.ProveMulti(keys, fromLevel)
+}
+
+// MergeProofs merges merkle proofs of the same trie into a multiproof, dropping
+// the duplicate nodes. Merging the proofs of some keys gives the same result as
+// proving them with ProveMulti.
+func MergeProofs(proofs ...[][]byte) [][]byte {
+	var (
+		merged [][]byte
+		seen   = make(map[string]struct{})
+	)
+	for _, proof := range proofs {
+		for _, node := range proof {
+			if _, ok := seen[string(node)]; ok {
+				continue
+			}
+			seen[string(node)] = struct{}{}
+			merged = append(merged, node)
+		}
+	}
+	return merged
+}
+
+// VerifyMultiProof checks merkle multiproofs. The given proof must contain the
+// values for all keys in a trie with the given root hash, returned in the order
+// of the keys, nil for the absent ones. VerifyMultiProof returns an error if the
+// proof contains invalid trie nodes, the wrong values or any node not needed to
+// prove the keys, so only minimal proofs are accepted.
+func VerifyMultiProof(rootHash common.Hash, keys [][]byte, proof [][]byte) ([][]byte, error) {
+	proofDb := &usedProofReader{
+		db:   memorydb.New(),
+		used: make(map[string]struct{}),
+	}
+	for _, node := range proof {
+		proofDb.db.Put(crypto.Keccak256(node), node)
+	}
+	values := make([][]byte, len(keys))
+	for i, key := range keys {
+		value, err := VerifyProof(rootHash, key, proofDb)
+		if err != nil {
+			return nil, fmt.Errorf("key %d (%x): %v", i, key, err)
+		}
+		values[i] = value
+	}
+	if unused := proofDb.db.Len() - len(proofDb.used); unused > 0 {
+		return nil, fmt.Errorf("%d unused proof nodes", unused)
+	}
+	return values, nil
+}
+
+// proofList is a list of proof nodes, collected in the order they are written.
+type proofList [][]byte
+
+func (n *proofList) Put(key []byte, value []byte) error {
+	*n = append(*n, value)
+	return nil
+}
+
+func (n *proofList) Delete(key []byte) error {
+	panic("not supported")
+}
+
+// usedProofReader is a proof database tracking the nodes read from it.
+type usedProofReader struct {
+	db   *memorydb.Database
+	used map[string]struct{}
+}
+
+func (r *usedProofReader) Has(key []byte) (bool, error) {
+	return r.db.Has(key)
+}
+
+func (r *usedProofReader) Get(key []byte) ([]byte, error) {
+	value, err := r.db.Get(key)
+	if err == nil {
+		r.used[string(key)] = struct{}{}
+	}
+	return value, err
+}
+
 // proofToPath converts a merkle proof to trie node path. The main purpose of
 // this function is recovering a node path from the merkle proof stream. All
 // necessary nodes will be resolved and leave the remaining as hashnode.
@@ -21,6 +21,7 @@ import (
 	crand "crypto/rand"
 	"encoding/binary"
 	mrand "math/rand"
+	"reflect"
 	"sort"
 	"testing"
 	"time"
@@ -814,6 +815,81 @@ func TestBloatedProof(t *testing.T) {
 }
 
 // mutateByte changes one byte in b.
+// TestMultiProof tests that multiproofs of key sets, including absent keys,
+// verify and are smaller than the individual proofs.
+func TestMultiProof(t *testing.T) {
+	trie, vals := randomTrie(500)
+	root := trie.Hash()
+
+	for i := 0; i < 10; i++ {
+		var (
+			keys   [][]byte
+			proofs [][][]byte
+			size   int
+		)
+		for _, kv := range vals {
+			if len(keys) == 40 {
+				break
+			}
+			keys = append(keys, kv.k)
+		}
+		keys = append(keys, randBytes(32), randBytes(32))
+		for _, key := range keys {
+			var proof proofList
+			if err := trie.Prove(key, 0, &proof); err != nil {
+				t.Fatalf("failed to prove key %x: %v", key, err)
+			}
+			proofs = append(proofs, proof)
+			for _, node := range proof {
+				size += len(node)
+			}
+		}
+		proof, err := trie.ProveMulti(keys, 0)
+		if err != nil {
+			t.Fatalf("failed to create multiproof: %v", err)
+		}
+		var multisize int
+		for _, node := range proof {
+			multisize += len(node)
+		}
+		if multisize >= size {
+			t.Fatalf("multiproof not compact: have %d bytes, individual proofs %d bytes", multisize, size)
+		}
+		if merged := MergeProofs(proofs...); !reflect.DeepEqual(merged, proof) {
+			t.Fatalf("merged proofs mismatch multiproof")
+		}
+		values, err := VerifyMultiProof(root, keys, proof)
+		if err != nil {
+			t.Fatalf("failed to verify multiproof: %v", err)
+		}
+		for j, key := range keys {
+			var want []byte
+			if kv := vals[string(key)]; kv != nil {
+				want = kv.v
+			}
+			if !bytes.Equal(values[j], want) {
+				t.Fatalf("value mismatch for key %x: have %x, want %x", key, values[j], want)
+			}
+		}
+		// Proofs with missing, modified or superfluous nodes should be rejected
+		index := mrand.Intn(len(proof))
+		bad := append(append([][]byte{}, proof[:index]...), proof[index+1:]...)
+		if _, err := VerifyMultiProof(root, keys, bad); err == nil {
+			t.Fatalf("multiproof verified with missing node %d", index)
+		}
+		bad = append([][]byte{}, proof...)
+		bad[index] = common.CopyBytes(bad[index])
+		mutateByte(bad[index])
+		if _, err := VerifyMultiProof(root, keys, bad); err == nil {
+			t.Fatalf("multiproof verified with modified node %d", index)
+		}
+		bad = append(append([][]byte{}, proof...), []byte{0xc2, 0x80, 0x80})
+		if _, err := VerifyMultiProof(root, keys, bad); err == nil {
+			t.Fatalf("multiproof verified with superfluous nodes")
+		}
+	}
+}
+
 func mutateByte(b []byte) {
 	for r := mrand.Intn(len(b)); ; {
 		new := byte(mrand.Intn(255))
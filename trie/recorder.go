@@ -0,0 +1,95 @@
+// Copyright 2021 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package trie
+
+import (
+	"bytes"
+	"sort"
+	"sync"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/log"
+)
+
+// Recorder collects the encoded trie nodes resolved from the database by the
+// tries it's attached to. The collected nodes are enough to replay the same
+// trie accesses without the database, e.g. for stateless block execution.
+//
+// Recorder is safe for concurrent use by multiple tries.
+type Recorder struct {
+	nodes map[common.Hash][]byte
+	lock  sync.Mutex
+}
+
+// NewRecorder creates an empty trie node recorder.
+func NewRecorder() *Recorder {
+	return &Recorder{nodes: make(map[common.Hash][]byte)}
+}
+
+// record stores the encoding of a node resolved from the database, unless it
+// was already recorded.
+func (r *Recorder) record(db *Database, owner common.Hash, path []byte, hash common.Hash) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if _, ok := r.nodes[hash]; ok {
+		return
+	}
+	blob, err := db.nodeBlob(owner, path, hash)
+	if err != nil {
+		log.Error("Failed to record trie node", "owner", owner, "path", path, "hash", hash, "err", err)
+		return
+	}
+	r.nodes[hash] = common.CopyBytes(blob)
+}
+
+// Nodes returns the encodings of all the recorded trie nodes, sorted by hash.
+func (r *Recorder) Nodes() [][]byte {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	hashes := make([]common.Hash, 0, len(r.nodes))
+	for hash := range r.nodes {
+		hashes = append(hashes, hash)
+	}
+	sort.Slice(hashes, func(i, j int) bool {
+		return bytes.Compare(hashes[i][:], hashes[j][:]) < 0
+	})
+	nodes := make([][]byte, len(hashes))
+	for i, hash := range hashes {
+		nodes[i] = r.nodes[hash]
+	}
+	return nodes
+}
+
+// Record attaches a recorder to the trie, which collects the root node and all
+// the nodes resolved from the database from now on. Nodes loaded before aren't
+// recorded, so the recorder should be attached right after opening the trie.
+func (t *Trie) Record(r *Recorder) {
+	t.recorder = r
+	if t.root == nil || t.db == nil {
+		return
+	}
+	if hash, _ := t.root.cache(); hash != nil {
+		r.record(t.db, t.owner, nil, common.BytesToHash(hash))
+	}
+}
+
+// Record attaches a recorder to the trie, see Trie.Record for the details.
+func (t *SecureTrie) Record(r *Recorder) {
+	t.trie.Record(r)
+}
@@ -0,0 +1,84 @@
+// Copyright 2021 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package trie
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/ethdb/memorydb"
+)
+
+// Tests that the nodes collected by a recorder are enough to replay the same
+// trie accesses and modifications without the original database.
+func TestRecorder(t *testing.T) {
+	triedb, tr, content := makeTestSecureTrie()
+	root := tr.Hash()
+
+	// Access and modify the trie with a recorder attached
+	accesses := func(tr *SecureTrie) (common.Hash, error) {
+		for i := byte(0); i < 16; i++ {
+			key := common.LeftPadBytes([]byte{1, i}, 32)
+			val, err := tr.TryGet(key)
+			if err != nil {
+				return common.Hash{}, err
+			}
+			if !bytes.Equal(val, content[string(key)]) {
+				t.Fatalf("value mismatch for key %x: have %x, want %x", key, val, content[string(key)])
+			}
+		}
+		if err := tr.TryDelete(common.LeftPadBytes([]byte{2, 7}, 32)); err != nil {
+			return common.Hash{}, err
+		}
+		if err := tr.TryUpdate(common.LeftPadBytes([]byte{3, 7}, 32), []byte{0xff}); err != nil {
+			return common.Hash{}, err
+		}
+		return tr.Hash(), nil
+	}
+	recorder := NewRecorder()
+	tr, err := NewSecure(root, triedb)
+	if err != nil {
+		t.Fatalf("failed to open trie: %v", err)
+	}
+	tr.Record(recorder)
+	want, err := accesses(tr)
+	if err != nil {
+		t.Fatalf("failed to access recorded trie: %v", err)
+	}
+	// Replay the accesses from the recorded nodes only
+	diskdb := memorydb.New()
+	for _, node := range recorder.Nodes() {
+		diskdb.Put(crypto.Keccak256(node), node)
+	}
+	tr, err = NewSecure(root, NewDatabase(diskdb))
+	if err != nil {
+		t.Fatalf("failed to open replayed trie: %v", err)
+	}
+	have, err := accesses(tr)
+	if err != nil {
+		t.Fatalf("failed to replay accesses: %v", err)
+	}
+	if have != want {
+		t.Fatalf("root mismatch: have %x, want %x", have, want)
+	}
+	// Accessing anything else should fail
+	if _, err := tr.TryGet(common.LeftPadBytes([]byte{12, 200}, 32)); err == nil {
+		t.Fatalf("unrecorded access succeeded")
+	}
+}